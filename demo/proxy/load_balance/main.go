@@ -1,22 +1,23 @@
 package main
 
 import (
+	"GO_GATEWAY/proxy/gateway"
 	"GO_GATEWAY/proxy/load_balance"
-	"bytes"
-	"io/ioutil"
+	"GO_GATEWAY/proxy/metrics"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	addr      = "127.0.0.1:2002"
-	transport = &http.Transport{
+	addr         = "127.0.0.1:2002"
+	backendsFile = flag.String("backends", "", "动态后端配置文件，YAML 或 JSON")
+	transport    = &http.Transport{
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second, //连接超时
 			KeepAlive: 30 * time.Second, //长连接超时时间
@@ -56,19 +57,8 @@ func NewMultipleHostsReverseProxy(lb load_balance.LoadBalance) *httputil.Reverse
 	//更改内容
 	modifyFunc := func(resp *http.Response) error {
 		//请求以下命令：curl 'http://127.0.0.1:2002/error'
-		if resp.StatusCode != 200 {
-			//获取内容
-			oldPayload, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return err
-			}
-			//追加内容
-			newPayload := []byte("StatusCode error:" + string(oldPayload))
-			resp.Body = ioutil.NopCloser(bytes.NewBuffer(newPayload))
-			resp.ContentLength = int64(len(newPayload))
-			resp.Header.Set("Content-Length", strconv.FormatInt(int64(len(newPayload)), 10))
-		}
-		return nil
+		//追加内容，大响应体流式处理，不整体读入内存
+		return gateway.DecorateErrorResponse(resp, "StatusCode error:", gateway.DefaultErrorBufferThreshold)
 	}
 
 	//错误回调 ：关闭real_server时测试，错误回调
@@ -94,14 +84,23 @@ func singleJoiningSlash(a, b string) string {
 }
 
 func main() {
-	rb := load_balance.LoadBanlanceFactory(load_balance.LbWeightRoundRobin)
-	if err := rb.Add("http://127.0.0.1:2003/base", "10"); err != nil {
-		log.Println(err)
-	}
-	if err := rb.Add("http://127.0.0.1:2004/base", "20"); err != nil {
-		log.Println(err)
+	flag.Parse()
+	//固定的兜底后端总在列表中，再合并配置文件中的后端
+	var sources []load_balance.LoadBalanceConf
+	if *backendsFile != "" {
+		fileConf, err := load_balance.NewLoadBalanceFileConf("http://%s/base", *backendsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sources = append(sources, fileConf)
 	}
+	mConf := load_balance.NewHybridConf([]string{"http://127.0.0.1:2003/base,10", "http://127.0.0.1:2004/base,20"}, sources...)
+	rb := load_balance.LoadBanlanceFactorWithConf(load_balance.LbWeightRoundRobin, mConf)
 	proxy := NewMultipleHostsReverseProxy(rb)
+	//请求指标：curl 'http://127.0.0.1:2002/metrics'
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", gateway.RequestMetrics(proxy))
 	log.Println("Starting httpserver at " + addr)
-	log.Fatal(http.ListenAndServe(addr, proxy))
-}
\ No newline at end of file
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
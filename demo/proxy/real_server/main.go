@@ -18,7 +18,7 @@ func main() {
 	rs2.Run()
 
 	//监听关闭信号
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 }
@@ -0,0 +1,566 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/registry"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 管理接口，独立监听端口，运行时查看与调整后端，POST /debug/route 模拟请求查看路由与后端选择，GET /metrics 供 Prometheus 抓取
+type Admin struct {
+	Router     *Router
+	Auth       func(http.Handler) http.Handler //可选认证中间件，如 BasicAuth，不作用于 GET /ready 与 GET /metrics
+	Transports *TransportRegistry              //可选，删除后端或路由时关闭其空闲连接
+	Ready      func() bool                     //可选，GET /ready 的就绪判断，如主备部署时传入 LeaderElector.IsLeader
+	Journal    *load_balance.Journal           //可选，记录通过管理接口修改后端的操作，GET /config/history 查看
+	//可选，POST /config/import 为新增的路由创建处理器，未设置时导入的文档只能包含已有的路由
+	RouteHandler func(route ConfigRoute) (http.Handler, error)
+
+	mux       sync.RWMutex
+	pools     map[string]load_balance.ManagedBalance
+	draining  map[string]string //摘除中的后端 -> 所属 pool
+	filters   map[string]load_balance.FilterConf
+	checks    map[string]func() string
+	failovers map[string]*registry.Failover
+	confs     map[string]adminRouteConf //路由名 -> 路由使用的配置主题
+
+	statsReader metrics.DeltaReader //GET /stats?reset=true 的增量基线
+}
+
+type adminRouteConf struct {
+	conf      load_balance.LoadBalanceConf
+	observers []load_balance.Observer
+}
+
+// 就绪子检查的状态
+const (
+	ReadyOK       = "ok"
+	ReadyDegraded = "degraded" //仍然就绪，如注册中心故障时继续使用最后一次的后端列表
+	ReadyFailed   = "failed"
+)
+
+type adminReady struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type adminBackend struct {
+	Addr     string     `json:"addr"`
+	Weight   int        `json:"weight,omitempty"`
+	Status   string     `json:"status"`
+	Inflight int64      `json:"inflight"`
+	ConnPool *PoolStats `json:"conn_pool,omitempty"`
+}
+
+type adminPool struct {
+	Pool     string         `json:"pool"`
+	Backends []adminBackend `json:"backends"`
+}
+
+type adminBackendReq struct {
+	Pool   string `json:"pool"`
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight"`
+}
+
+type adminFilter struct {
+	Expr           string `json:"expr"`
+	IncludeWarning bool   `json:"include_warning"`
+}
+
+type adminRoute struct {
+	Name         string `json:"name"`
+	Host         string `json:"host,omitempty"`
+	PathPrefix   string `json:"path_prefix"`
+	MaxBodyBytes int64  `json:"max_body_bytes,omitempty"`
+}
+
+func NewAdmin(router *Router) *Admin {
+	return &Admin{Router: router, pools: map[string]load_balance.ManagedBalance{}, draining: map[string]string{},
+		filters: map[string]load_balance.FilterConf{}, checks: map[string]func() string{}, failovers: map[string]*registry.Failover{},
+		confs: map[string]adminRouteConf{}}
+}
+
+// 注册需要管理的负载均衡
+func (a *Admin) AddPool(name string, lb load_balance.ManagedBalance) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.pools[name] = lb
+}
+
+// 注册可以通过 /pools/{pool}/filter 调整筛选条件的配置主题
+func (a *Admin) AddFilterConf(pool string, conf load_balance.FilterConf) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.filters[pool] = conf
+}
+
+// 登记路由使用的配置主题与监听它的负载均衡，DELETE /routes/{name} 删除路由时断开监听并关闭配置主题
+func (a *Admin) AddRouteConf(route string, conf load_balance.LoadBalanceConf, observers ...load_balance.Observer) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.confs[route] = adminRouteConf{conf: conf, observers: observers}
+}
+
+// 注册可以通过 GET /pools/{pool}/failover 查看当前来源的故障切换注册中心
+func (a *Admin) AddFailover(pool string, f *registry.Failover) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.failovers[pool] = f
+}
+
+// 注册 GET /ready 的子检查，check 返回 ReadyOK、ReadyDegraded 或 ReadyFailed，有失败的子检查时不就绪
+func (a *Admin) AddReadyCheck(name string, check func() string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.checks[name] = check
+}
+
+// 配置主题处于 stale 模式时降级的子检查
+func StaleCheck(conf load_balance.StaleConf) func() string {
+	return func() string {
+		if conf.Stale() {
+			return ReadyDegraded
+		}
+		return ReadyOK
+	}
+}
+
+// 故障切换注册中心没有使用优先级最高的来源时降级的子检查
+func FailoverCheck(f *registry.Failover) func() string {
+	return func() string {
+		status := f.Status()
+		if len(status.Sources) > 0 && status.Active != status.Sources[0].Name {
+			return ReadyDegraded
+		}
+		return ReadyOK
+	}
+}
+
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /backends", a.listBackends)
+	mux.HandleFunc("POST /backends", a.addBackend)
+	mux.HandleFunc("DELETE /backends/{addr}", a.removeBackend)
+	mux.HandleFunc("PUT /backends/{addr}/weight", a.setWeight)
+	mux.HandleFunc("GET /routes", a.listRoutes)
+	mux.HandleFunc("DELETE /routes/{name}", a.removeRoute)
+	mux.HandleFunc("POST /debug/route", a.debugRoute)
+	mux.HandleFunc("GET /metrics/routes", a.routeMetrics)
+	mux.HandleFunc("GET /stats", a.stats)
+	mux.HandleFunc("GET /pools/{pool}/filter", a.getFilter)
+	mux.HandleFunc("PUT /pools/{pool}/filter", a.setFilter)
+	mux.HandleFunc("GET /pools/{pool}/failover", a.getFailover)
+	mux.HandleFunc("GET /config/history", a.history)
+	mux.HandleFunc("GET /config/export", a.exportConfig)
+	mux.HandleFunc("POST /config/import", a.importConfig)
+	if a.Auth == nil {
+		mux.HandleFunc("GET /ready", a.ready)
+		mux.Handle("GET /metrics", metrics.Handler())
+		return mux
+	}
+	//就绪探针与 Prometheus 抓取不带凭证，不经过 Auth
+	public := http.NewServeMux()
+	public.HandleFunc("GET /ready", a.ready)
+	public.Handle("GET /metrics", metrics.Handler())
+	public.Handle("/", a.Auth(mux))
+	return public
+}
+
+func (a *Admin) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, a.Handler())
+}
+
+// 没有设置 Ready 与子检查时总是就绪
+func (a *Admin) ready(w http.ResponseWriter, req *http.Request) {
+	body := adminReady{Ready: a.Ready == nil || a.Ready()}
+	a.mux.RLock()
+	checks := make(map[string]func() string, len(a.checks))
+	for name, check := range a.checks {
+		checks[name] = check
+	}
+	a.mux.RUnlock()
+	if len(checks) > 0 {
+		body.Checks = map[string]string{}
+	}
+	for name, check := range checks {
+		status := check()
+		body.Checks[name] = status
+		if status == ReadyFailed {
+			body.Ready = false
+		}
+	}
+	if !body.Ready {
+		writeJSON(w, http.StatusServiceUnavailable, body)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// 未指定 pool 且只有一个 pool 时使用该 pool
+func (a *Admin) pool(name string) (string, load_balance.ManagedBalance, error) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	if name == "" && len(a.pools) == 1 {
+		for n, lb := range a.pools {
+			return n, lb, nil
+		}
+	}
+	if name == "" {
+		return "", nil, errors.New("pool is required")
+	}
+	lb, ok := a.pools[name]
+	if !ok {
+		return "", nil, errors.New("pool not found: " + name)
+	}
+	return name, lb, nil
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Admin) listBackends(w http.ResponseWriter, req *http.Request) {
+	a.mux.RLock()
+	names := make([]string, 0, len(a.pools))
+	for name := range a.pools {
+		names = append(names, name)
+	}
+	a.mux.RUnlock()
+	sort.Strings(names)
+
+	result := []adminPool{}
+	for _, name := range names {
+		_, lb, _ := a.pool(name)
+		pool := adminPool{Pool: name, Backends: []adminBackend{}}
+		servers := lb.Servers()
+		for _, addr := range servers {
+			stats := BackendPoolStats(addr)
+			b := adminBackend{Addr: addr, Status: "up", Inflight: backendInflight.Get(addr), ConnPool: &stats}
+			if wlb, ok := lb.(load_balance.WeightedBalance); ok {
+				b.Weight, _ = wlb.Weight(addr)
+			}
+			pool.Backends = append(pool.Backends, b)
+		}
+		a.mux.Lock()
+		for addr, poolName := range a.draining {
+			if poolName != name || contains(servers, addr) {
+				continue
+			}
+			inflight := backendInflight.Get(addr)
+			status := "draining"
+			if inflight == 0 {
+				status = "drained"
+				delete(a.draining, addr)
+			}
+			pool.Backends = append(pool.Backends, adminBackend{Addr: addr, Status: status, Inflight: inflight})
+		}
+		a.mux.Unlock()
+		result = append(result, pool)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func validateBackendAddr(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return errors.New("addr must be an absolute http(s) url")
+	}
+	return nil
+}
+
+func (a *Admin) addBackend(w http.ResponseWriter, req *http.Request) {
+	var body adminBackendReq
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateBackendAddr(body.Addr); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Weight < 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("weight must be positive"))
+		return
+	}
+	if body.Weight == 0 {
+		body.Weight = 50 //默认weight
+	}
+	name, lb, err := a.pool(body.Pool)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	if contains(lb.Servers(), body.Addr) {
+		writeJSONError(w, http.StatusConflict, errors.New("backend already exists"))
+		return
+	}
+	if err := lb.Add(body.Addr, strconv.Itoa(body.Weight)); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.mux.Lock()
+	delete(a.draining, body.Addr)
+	a.mux.Unlock()
+	a.record(req, load_balance.ConfChange{Pool: name, Added: []string{body.Addr + "," + strconv.Itoa(body.Weight)}})
+	writeJSON(w, http.StatusCreated, adminBackendReq{Pool: name, Addr: body.Addr, Weight: body.Weight})
+}
+
+// 删除后端，drain=true 时保留在列表中直到进行中的请求结束
+func (a *Admin) removeBackend(w http.ResponseWriter, req *http.Request) {
+	addr := req.PathValue("addr")
+	name, lb, err := a.pool(req.URL.Query().Get("pool"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	removed := addr
+	if wlb, ok := lb.(load_balance.WeightedBalance); ok {
+		if weight, err := wlb.Weight(addr); err == nil {
+			removed += "," + strconv.Itoa(weight)
+		}
+	}
+	if err := lb.Remove(addr); err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	a.record(req, load_balance.ConfChange{Pool: name, Removed: []string{removed}})
+	if a.Transports != nil {
+		a.Transports.Remove(addr)
+	}
+	if req.URL.Query().Get("drain") == "true" {
+		a.mux.Lock()
+		a.draining[addr] = name
+		a.mux.Unlock()
+		writeJSON(w, http.StatusAccepted, adminBackend{Addr: addr, Status: "draining", Inflight: backendInflight.Get(addr)})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) setWeight(w http.ResponseWriter, req *http.Request) {
+	addr := req.PathValue("addr")
+	var body adminBackendReq
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Weight <= 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("weight must be positive"))
+		return
+	}
+	name, lb, err := a.pool(body.Pool)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	wlb, ok := lb.(load_balance.WeightedBalance)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, errors.New("pool does not support weights"))
+		return
+	}
+	from, _ := wlb.Weight(addr)
+	if err := wlb.SetWeight(addr, body.Weight); err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	a.record(req, load_balance.ConfChange{Pool: name, Changed: []load_balance.ConfWeightChange{{Addr: addr, From: strconv.Itoa(from), To: strconv.Itoa(body.Weight)}}})
+	writeJSON(w, http.StatusOK, adminBackendReq{Pool: name, Addr: addr, Weight: body.Weight})
+}
+
+func (a *Admin) filterConf(w http.ResponseWriter, req *http.Request) (load_balance.FilterConf, bool) {
+	a.mux.RLock()
+	conf, ok := a.filters[req.PathValue("pool")]
+	a.mux.RUnlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("filter conf not found: "+req.PathValue("pool")))
+	}
+	return conf, ok
+}
+
+func (a *Admin) getFilter(w http.ResponseWriter, req *http.Request) {
+	conf, ok := a.filterConf(w, req)
+	if !ok {
+		return
+	}
+	body := adminFilter{}
+	if f := conf.Filter(); f != nil {
+		body = adminFilter{Expr: f.Expr(), IncludeWarning: f.IncludeWarning()}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// 替换后立即按新条件重新发布后端列表
+func (a *Admin) setFilter(w http.ResponseWriter, req *http.Request) {
+	conf, ok := a.filterConf(w, req)
+	if !ok {
+		return
+	}
+	var body adminFilter
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter, err := registry.NewFilter(body.Expr, body.IncludeWarning)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	conf.SetFilter(filter)
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (a *Admin) getFailover(w http.ResponseWriter, req *http.Request) {
+	a.mux.RLock()
+	f, ok := a.failovers[req.PathValue("pool")]
+	a.mux.RUnlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("failover registry not found: "+req.PathValue("pool")))
+		return
+	}
+	writeJSON(w, http.StatusOK, f.Status())
+}
+
+// 操作人依次取 BasicAuth 用户名、X-Admin-Actor 请求头、客户端地址
+func adminActor(req *http.Request) string {
+	if user, _, ok := req.BasicAuth(); ok && user != "" {
+		return user
+	}
+	if actor := req.Header.Get("X-Admin-Actor"); actor != "" {
+		return actor
+	}
+	return req.RemoteAddr
+}
+
+func (a *Admin) record(req *http.Request, change load_balance.ConfChange) {
+	if a.Journal == nil {
+		return
+	}
+	change.Source, change.Actor = load_balance.JournalSourceAdmin, adminActor(req)
+	a.Journal.Record(change)
+}
+
+// 按时间顺序返回最近的变更，?pool= 按 pool 筛选，?limit= 限制条数
+func (a *Admin) history(w http.ResponseWriter, req *http.Request) {
+	if a.Journal == nil {
+		writeJSON(w, http.StatusOK, []load_balance.ConfChange{})
+		return
+	}
+	limit := 0
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSONError(w, http.StatusBadRequest, errors.New("invalid limit: "+v))
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, a.Journal.Recent(req.URL.Query().Get("pool"), limit))
+}
+
+func (a *Admin) listRoutes(w http.ResponseWriter, req *http.Request) {
+	routes := []adminRoute{}
+	if a.Router != nil {
+		for _, r := range a.Router.Routes() {
+			routes = append(routes, adminRoute{Name: r.Name, Host: r.Host, PathPrefix: r.PathPrefix, MaxBodyBytes: r.MaxBodyBytes})
+		}
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// 所有指标的 JSON 快照(metrics.MetricsSnapshot)：
+// ?sections=lb,backends 只输出指定的段；?reset=true 返回计数器、直方图、后端与状态码统计自上一次 reset 读取以来的增量，
+// 基线由管理接口自己保存(见 metrics.DeltaReader)，全局指标不清零，不影响 /metrics 等累计值的导出，需要配置 Auth。
+// 先复制快照再序列化，序列化期间不持有指标的锁
+func (a *Admin) stats(w http.ResponseWriter, req *http.Request) {
+	var sections []string
+	if v := req.URL.Query().Get("sections"); v != "" {
+		sections = strings.Split(v, ",")
+	}
+	reset := false
+	if v := req.URL.Query().Get("reset"); v != "" {
+		var err error
+		if reset, err = strconv.ParseBool(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errors.New("invalid reset: "+v))
+			return
+		}
+	}
+	if reset && a.Auth == nil {
+		writeJSONError(w, http.StatusForbidden, errors.New("reset requires admin authentication"))
+		return
+	}
+	//先校验段名，避免推进基线后才发现参数错误
+	if _, err := (metrics.MetricsSnapshot{}).Filter(sections...); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	var snapshot metrics.MetricsSnapshot
+	if reset {
+		snapshot = a.statsReader.Read()
+	} else {
+		snapshot = metrics.GetSnapshot()
+	}
+	snapshot, _ = snapshot.Filter(sections...)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// 按路由名的请求数、错误率与耗时，见 RouteMetrics
+func (a *Admin) routeMetrics(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, RouteMetrics())
+}
+
+// 删除路由，并断开、关闭通过 AddRouteConf 登记的配置主题
+func (a *Admin) removeRoute(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	if a.Router == nil || !a.Router.Remove(name) {
+		writeJSONError(w, http.StatusNotFound, errors.New("route not found: "+name))
+		return
+	}
+	a.closeRouteConf(name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 断开并关闭已删除路由登记的配置主题
+func (a *Admin) closeRouteConf(name string) {
+	a.mux.Lock()
+	rc, ok := a.confs[name]
+	delete(a.confs, name)
+	a.mux.Unlock()
+	if !ok {
+		return
+	}
+	for _, o := range rc.observers {
+		rc.conf.Detach(o)
+		if lb, ok := o.(load_balance.ManagedBalance); ok && a.Transports != nil {
+			a.Transports.Untrack(lb)
+		}
+	}
+	rc.conf.Close()
+}
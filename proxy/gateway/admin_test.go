@@ -0,0 +1,476 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/registry"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func adminDo(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminBackends(t *testing.T) {
+	lb := &load_balance.WeightRoundRobinBalance{}
+	lb.Add("http://127.0.0.1:2003", "10")
+	router := NewRouter()
+	router.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: okHandler})
+	admin := NewAdmin(router)
+	admin.AddPool("default", lb)
+	h := admin.Handler()
+
+	rec := adminDo(h, "POST", "/backends", `{"addr":"http://127.0.0.1:2004","weight":20}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("add got %d %s", rec.Code, rec.Body)
+	}
+	for body, want := range map[string]int{
+		`{"addr":"127.0.0.1:2005"}`:                    http.StatusBadRequest,
+		`{"addr":"http://127.0.0.1:2005","weight":-1}`: http.StatusBadRequest,
+		`not json`:                                    http.StatusBadRequest,
+		`{"addr":"http://127.0.0.1:2004"}`:            http.StatusConflict,
+		`{"pool":"x","addr":"http://127.0.0.1:2005"}`: http.StatusNotFound,
+	} {
+		if rec := adminDo(h, "POST", "/backends", body); rec.Code != want {
+			t.Errorf("%s: got %d want %d", body, rec.Code, want)
+		}
+	}
+
+	escaped := url.PathEscape("http://127.0.0.1:2004")
+	if rec := adminDo(h, "PUT", "/backends/"+escaped+"/weight", `{"weight":5}`); rec.Code != http.StatusOK {
+		t.Fatalf("set weight got %d %s", rec.Code, rec.Body)
+	}
+	if w, _ := lb.Weight("http://127.0.0.1:2004"); w != 5 {
+		t.Fatalf("weight = %d", w)
+	}
+	if rec := adminDo(h, "PUT", "/backends/"+escaped+"/weight", `{"weight":0}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("zero weight got %d", rec.Code)
+	}
+
+	var pools []adminPool
+	rec = adminDo(h, "GET", "/backends", "")
+	json.Unmarshal(rec.Body.Bytes(), &pools)
+	if len(pools) != 1 || len(pools[0].Backends) != 2 || pools[0].Backends[1].Weight != 5 || pools[0].Backends[1].Status != "up" {
+		t.Fatalf("unexpected list %s", rec.Body)
+	}
+
+	if rec := adminDo(h, "DELETE", "/backends/"+escaped+"?drain=true", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("drain got %d", rec.Code)
+	}
+	if servers := lb.Servers(); len(servers) != 1 {
+		t.Fatalf("drained backend should not receive traffic, servers %v", servers)
+	}
+	rec = adminDo(h, "GET", "/backends", "")
+	if !strings.Contains(rec.Body.String(), `"status":"drained"`) {
+		t.Fatalf("drained backend should be listed once, got %s", rec.Body)
+	}
+	if rec := adminDo(h, "DELETE", "/backends/"+escaped, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("remove missing got %d", rec.Code)
+	}
+	if rec := adminDo(h, "DELETE", "/backends/"+url.PathEscape("http://127.0.0.1:2003"), ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("remove got %d", rec.Code)
+	}
+
+	rec = adminDo(h, "GET", "/routes", "")
+	if !strings.Contains(rec.Body.String(), `"path_prefix":"/api"`) {
+		t.Fatalf("routes got %s", rec.Body)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	admin := NewAdmin(nil)
+	admin.Auth = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("X-Admin-Token") != "t" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+	if rec := adminDo(admin.Handler(), "GET", "/routes", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d", rec.Code)
+	}
+	//探针与抓取不带凭证
+	for _, path := range []string{"/ready", "/metrics"} {
+		if rec := adminDo(admin.Handler(), "GET", path, ""); rec.Code != http.StatusOK {
+			t.Fatalf("%s got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestAdminReady(t *testing.T) {
+	admin := NewAdmin(nil)
+	h := admin.Handler()
+	if rec := adminDo(h, "GET", "/ready", ""); rec.Code != http.StatusOK {
+		t.Fatalf("default got %d", rec.Code)
+	}
+	//备节点不就绪
+	leader := false
+	admin.Ready = func() bool { return leader }
+	if rec := adminDo(h, "GET", "/ready", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("standby got %d", rec.Code)
+	}
+	leader = true
+	if rec := adminDo(h, "GET", "/ready", ""); rec.Code != http.StatusOK {
+		t.Fatalf("leader got %d", rec.Code)
+	}
+}
+
+func TestAdminHistory(t *testing.T) {
+	lb := &load_balance.WeightRoundRobinBalance{}
+	lb.Add("http://127.0.0.1:2003", "10")
+	journal, _ := load_balance.NewJournal(0, "")
+	admin := NewAdmin(nil)
+	admin.Journal = journal
+	admin.AddPool("default", lb)
+	h := admin.Handler()
+	do := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetBasicAuth("alice", "secret")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do("POST", "/backends", `{"addr":"http://127.0.0.1:2004","weight":20}`)
+	do("PUT", "/backends/"+url.PathEscape("http://127.0.0.1:2004")+"/weight", `{"weight":5}`)
+	do("DELETE", "/backends/"+url.PathEscape("http://127.0.0.1:2003"), "")
+	//失败的操作不记录
+	do("DELETE", "/backends/"+url.PathEscape("http://127.0.0.1:2003"), "")
+	journal.Record(load_balance.ConfChange{Pool: "other", Source: load_balance.JournalSourceHealth, Removed: []string{"http://127.0.0.1:3001,50"}})
+
+	rec := adminDo(h, "GET", "/config/history?pool=default", "")
+	var history []load_balance.ConfChange
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("history got %d %s", rec.Code, rec.Body)
+	}
+	if len(history) != 3 {
+		t.Fatalf("history %+v", history)
+	}
+	for _, c := range history {
+		if c.Source != load_balance.JournalSourceAdmin || c.Actor != "alice" || c.Pool != "default" {
+			t.Fatalf("entry %+v", c)
+		}
+	}
+	if history[0].Added[0] != "http://127.0.0.1:2004,20" ||
+		history[1].Changed[0] != (load_balance.ConfWeightChange{Addr: "http://127.0.0.1:2004", From: "20", To: "5"}) ||
+		history[2].Removed[0] != "http://127.0.0.1:2003,10" {
+		t.Fatalf("history %+v", history)
+	}
+	if rec := adminDo(h, "GET", "/config/history?limit=1", ""); !strings.Contains(rec.Body.String(), `"source":"health"`) {
+		t.Fatalf("limit got %s", rec.Body)
+	}
+	if rec := adminDo(h, "GET", "/config/history?limit=x", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad limit got %d", rec.Code)
+	}
+}
+
+type stubStaleConf bool
+
+func (s *stubStaleConf) Stale() bool { return bool(*s) }
+
+func TestAdminReadyChecks(t *testing.T) {
+	admin := NewAdmin(nil)
+	stale := stubStaleConf(false)
+	admin.AddReadyCheck("registry_orders", StaleCheck(&stale))
+	h := admin.Handler()
+	if rec := adminDo(h, "GET", "/ready", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"registry_orders":"ok"`) {
+		t.Fatalf("ok got %d %s", rec.Code, rec.Body)
+	}
+	//注册中心故障时降级但仍然就绪
+	stale = true
+	if rec := adminDo(h, "GET", "/ready", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"registry_orders":"degraded"`) {
+		t.Fatalf("degraded got %d %s", rec.Code, rec.Body)
+	}
+	admin.AddReadyCheck("disk", func() string { return ReadyFailed })
+	if rec := adminDo(h, "GET", "/ready", ""); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"ready":false`) {
+		t.Fatalf("failed got %d %s", rec.Code, rec.Body)
+	}
+}
+
+type stubFilterConf struct {
+	filter *registry.Filter
+}
+
+func (s *stubFilterConf) SetFilter(filter *registry.Filter) { s.filter = filter }
+
+func (s *stubFilterConf) Filter() *registry.Filter { return s.filter }
+
+func TestAdminFilter(t *testing.T) {
+	admin := NewAdmin(nil)
+	conf := &stubFilterConf{}
+	admin.AddFilterConf("orders", conf)
+	h := admin.Handler()
+	if rec := adminDo(h, "GET", "/pools/orders/filter", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"expr":""`) {
+		t.Fatalf("get got %d %s", rec.Code, rec.Body)
+	}
+	if rec := adminDo(h, "PUT", "/pools/orders/filter", `{"expr":"primary and not canary","include_warning":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("put got %d %s", rec.Code, rec.Body)
+	}
+	if conf.filter == nil || conf.filter.Expr() != "primary and not canary" || !conf.filter.IncludeWarning() {
+		t.Fatalf("filter %+v", conf.filter)
+	}
+	for target, want := range map[string]int{
+		"/pools/orders/filter":   http.StatusBadRequest,
+		"/pools/payments/filter": http.StatusNotFound,
+	} {
+		if rec := adminDo(h, "PUT", target, `{"expr":"primary and"}`); rec.Code != want {
+			t.Errorf("%s: got %d want %d", target, rec.Code, want)
+		}
+	}
+	if conf.filter.Expr() != "primary and not canary" {
+		t.Fatal("invalid expression replaced filter")
+	}
+}
+
+func TestAdminConcurrentMutation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+	lb := &load_balance.WeightRoundRobinBalance{}
+	lb.Add(upstream.URL, "10")
+	p := NewProxy(lb, Options{})
+	admin := NewAdmin(nil)
+	admin.AddPool("default", lb)
+	h := admin.Handler()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	failures := make(chan int, 1000)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				rec := httptest.NewRecorder()
+				p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+				if rec.Code != http.StatusOK {
+					failures <- rec.Code
+				}
+			}
+		}()
+	}
+	extra := "http://127.0.0.1:1"
+	for i := 0; i < 50; i++ {
+		adminDo(h, "POST", "/backends", `{"addr":"`+extra+`","weight":1}`)
+		adminDo(h, "PUT", "/backends/"+url.PathEscape(upstream.URL)+"/weight", `{"weight":20}`)
+		adminDo(h, "DELETE", "/backends/"+url.PathEscape(extra), "")
+		adminDo(h, "GET", "/backends", "")
+	}
+	close(stop)
+	wg.Wait()
+	close(failures)
+	//新增的后端不可达，只允许少量请求打到它上面
+	bad := 0
+	for range failures {
+		bad++
+	}
+	if servers := lb.Servers(); len(servers) != 1 || servers[0] != upstream.URL {
+		t.Fatalf("unexpected servers %v", servers)
+	}
+	t.Logf("%d requests hit the temporary backend", bad)
+}
+
+func TestAdminFailover(t *testing.T) {
+	primary, secondary := registry.NewMemory(), registry.NewMemory()
+	f := registry.NewFailover(registry.FailoverOptions{}, registry.FailoverSource{Name: "zk", Registry: primary}, registry.FailoverSource{Name: "file", Registry: secondary})
+	admin := NewAdmin(nil)
+	admin.AddFailover("orders", f)
+	admin.AddReadyCheck("registry_orders", FailoverCheck(f))
+	h := admin.Handler()
+	rec := adminDo(h, "GET", "/pools/orders/failover", "")
+	var status registry.FailoverStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got %d %v", rec.Code, err)
+	}
+	if status.Active != "zk" || len(status.Sources) != 2 || !status.Sources[1].Healthy {
+		t.Fatalf("status %+v", status)
+	}
+	if rec := adminDo(h, "GET", "/ready", ""); !strings.Contains(rec.Body.String(), `"registry_orders":"ok"`) {
+		t.Fatalf("ready %s", rec.Body)
+	}
+	if rec := adminDo(h, "GET", "/pools/payments/failover", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown pool got %d", rec.Code)
+	}
+}
+
+type stubRouteConf struct {
+	load_balance.LoadBalanceConf
+	detached []load_balance.Observer
+	closed   bool
+}
+
+func (s *stubRouteConf) Detach(o load_balance.Observer) { s.detached = append(s.detached, o) }
+
+func (s *stubRouteConf) Close() { s.closed = true }
+
+func TestAdminRemoveRoute(t *testing.T) {
+	router := NewRouter()
+	router.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: okHandler})
+	admin := NewAdmin(router)
+	conf := &stubRouteConf{}
+	lb := &load_balance.WeightRoundRobinBalance{}
+	admin.AddRouteConf("api", conf, lb)
+	h := admin.Handler()
+	if rec := adminDo(h, "DELETE", "/routes/api", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if len(router.Routes()) != 0 || !conf.closed || len(conf.detached) != 1 || conf.detached[0] != load_balance.Observer(lb) {
+		t.Fatalf("routes %d conf %+v", len(router.Routes()), conf)
+	}
+	if rec := adminDo(h, "DELETE", "/routes/api", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete got %d", rec.Code)
+	}
+}
+
+// 抓取 GET /metrics，返回 "名称{标签}" 到值的映射
+func scrapeMetrics(t *testing.T, h http.Handler) map[string]float64 {
+	t.Helper()
+	rec := adminDo(h, "GET", "/metrics", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("scrape got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	series := map[string]float64{}
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q", line)
+		}
+		series[line[:i]] = v
+	}
+	return series
+}
+
+func TestAdminMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer backend.Close()
+	lb := &load_balance.WeightRoundRobinBalance{}
+	lb.Add(backend.URL, "10")
+	h := RequestMetrics(NewProxy(lb, Options{}))
+	admin := NewAdmin(nil)
+	before := scrapeMetrics(t, admin.Handler())
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	after := scrapeMetrics(t, admin.Handler())
+
+	requests := `gateway_requests_total{status_class="2xx"}`
+	if got := after[requests] - before[requests]; got != 3 {
+		t.Fatalf("%s increased by %v", requests, got)
+	}
+	if got := after[`gateway_lb_selections_total{backend="`+backend.URL+`"}`]; got != 3 {
+		t.Fatalf("selections %v", got)
+	}
+	ttfb := 0
+	for series, v := range after {
+		if strings.HasPrefix(series, "gateway_upstream_ttfb_seconds_bucket{") && strings.HasSuffix(series, `le="+Inf"}`) &&
+			strings.Contains(series, strings.TrimPrefix(backend.URL, "http://")) {
+			ttfb = int(v)
+		}
+	}
+	if ttfb != 3 {
+		t.Fatalf("ttfb histogram count %d", ttfb)
+	}
+	if rec := adminDo(admin.Handler(), "GET", "/metrics", ""); !strings.Contains(rec.Body.String(), "# TYPE gateway_upstream_ttfb_seconds histogram\n") ||
+		!strings.Contains(rec.Body.String(), "# TYPE gateway_lb_selections_total counter\n") {
+		t.Fatalf("missing type lines")
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("ok")) }))
+	defer backend.Close()
+	lb := &load_balance.WeightRoundRobinBalance{}
+	lb.Add(backend.URL, "10")
+	h := NewProxy(lb, Options{})
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	admin := NewAdmin(nil)
+	stats := func(target string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+		t.Helper()
+		rec := adminDo(admin.Handler(), "GET", target, "")
+		var doc map[string]json.RawMessage
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, doc
+	}
+
+	rec, doc := stats("/stats")
+	if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("headers %v", rec.Header())
+	}
+	for _, key := range []string{"time", "uptime", "counters", "gauges", "histograms", "backends", "lb", "runtime"} {
+		if _, ok := doc[key]; !ok {
+			t.Errorf("missing %s", key)
+		}
+	}
+	var uptime map[string]any
+	json.Unmarshal(doc["uptime"], &uptime)
+	if _, ok := uptime["ns"].(float64); !ok || uptime["human"] == "" {
+		t.Fatalf("uptime %s", doc["uptime"])
+	}
+	var snapshot metrics.MetricsSnapshot
+	json.Unmarshal(rec.Body.Bytes(), &snapshot)
+	if snapshot.LB[backend.URL] != 2 || snapshot.Backends[backend.URL].Requests != 2 || snapshot.Backends[backend.URL].Bytes != 4 ||
+		snapshot.Runtime == nil || snapshot.Runtime.Goroutines == 0 {
+		t.Fatalf("snapshot lb %v backends %+v runtime %+v", snapshot.LB, snapshot.Backends[backend.URL], snapshot.Runtime)
+	}
+
+	//只输出选择的段
+	_, doc = stats("/stats?sections=lb,backends")
+	if len(doc) != 4 || doc["lb"] == nil || doc["backends"] == nil || doc["counters"] != nil {
+		t.Fatalf("filtered keys %v", doc)
+	}
+	if rec, _ := stats("/stats?sections=lb,paths"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown section got %d", rec.Code)
+	}
+
+	//按区间读取增量需要认证
+	if rec, _ := stats("/stats?reset=true"); rec.Code != http.StatusForbidden {
+		t.Fatalf("reset without auth got %d", rec.Code)
+	}
+	admin.Auth = func(next http.Handler) http.Handler { return next }
+	_, doc = stats("/stats?sections=lb&reset=true")
+	json.Unmarshal(doc["lb"], &snapshot.LB)
+	if snapshot.LB[backend.URL] != 2 {
+		t.Fatalf("reset read lb %v", snapshot.LB)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	_, doc = stats("/stats?sections=lb,backends&reset=true")
+	var after metrics.MetricsSnapshot
+	json.Unmarshal(doc["lb"], &after.LB)
+	json.Unmarshal(doc["backends"], &after.Backends)
+	if after.LB[backend.URL] != 1 || after.Backends[backend.URL].Requests != 1 {
+		t.Fatalf("delta lb %v backends %+v", after.LB, after.Backends[backend.URL])
+	}
+	//全局指标仍为累计值，Prometheus 序列不会变小
+	_, doc = stats("/stats?sections=lb,backends")
+	json.Unmarshal(doc["lb"], &after.LB)
+	json.Unmarshal(doc["backends"], &after.Backends)
+	if after.LB[backend.URL] != 3 || after.Backends[backend.URL].Requests != 3 {
+		t.Fatalf("cumulative lb %v backends %+v", after.LB, after.Backends[backend.URL])
+	}
+	if series := scrapeMetrics(t, admin.Handler()); series[`gateway_lb_selections_total{backend="`+backend.URL+`"}`] != 3 {
+		t.Fatal("prometheus counter went backwards")
+	}
+}
@@ -0,0 +1,200 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAggregateTimeout  = 5 * time.Second
+	DefaultAggregateMaxBytes = 1 << 20
+)
+
+var (
+	errAggregateLoop     = errors.New("aggregate sub-request routed back to an aggregate route")
+	errAggregateTooLarge = errors.New("sub-response body too large")
+
+	aggregateErrors = metrics.NewCounterVec("gateway_aggregate_subrequest_errors_total", "聚合接口子请求失败次数，按结果中的 key 统计", "key")
+)
+
+// 聚合接口的一个子请求，结果放在响应 JSON 的 Key 字段下
+type SubRequest struct {
+	Key     string
+	Method  string        //默认 GET
+	Path    string        //路径模板，{name} 替换为原请求的查询参数 name，如 /users/{id}?fields=basic
+	Timeout time.Duration //单个子请求的超时，默认使用 Aggregator.Timeout
+}
+
+// 聚合接口(scatter-gather)，作为路由的 Handler 使用：
+// 并发执行各子请求，子请求带上原请求的请求头(包括认证信息)，
+// 经 Handler(一般为网关的 *Router)走正常的路由、中间件与负载均衡，
+// 各结果须为 JSON，按 key 组合为一个 JSON 对象返回
+type Aggregator struct {
+	Handler     http.Handler
+	SubRequests []SubRequest
+	Timeout     time.Duration //子请求默认超时，默认 DefaultAggregateTimeout
+	MaxBytes    int64         //单个子响应的大小上限，默认 DefaultAggregateMaxBytes
+	FailAll     bool          //任一子请求失败时整体返回 502，默认在对应 key 下返回 {"error": ...}
+}
+
+type aggregateResult struct {
+	body json.RawMessage
+	err  error
+}
+
+func (a *Aggregator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	//子请求再次路由到聚合接口时拒绝，避免无限递归
+	if req.Context().Value(aggregateContextKey) != nil {
+		writeJSONError(w, http.StatusLoopDetected, errAggregateLoop)
+		return
+	}
+	ctx := context.WithValue(req.Context(), aggregateContextKey, true)
+	results := make([]aggregateResult, len(a.SubRequests))
+	var wg sync.WaitGroup
+	for i, sub := range a.SubRequests {
+		wg.Add(1)
+		go func(i int, sub SubRequest) {
+			defer wg.Done()
+			body, err := a.do(ctx, req, sub)
+			results[i] = aggregateResult{body: body, err: err}
+		}(i, sub)
+	}
+	wg.Wait()
+
+	combined := make(map[string]interface{}, len(results))
+	for i, r := range results {
+		key := a.SubRequests[i].Key
+		if r.err == nil {
+			combined[key] = r.body
+			continue
+		}
+		aggregateErrors.Inc(key)
+		if a.FailAll {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": r.err.Error(), "key": key})
+			return
+		}
+		combined[key] = map[string]string{"error": r.err.Error()}
+	}
+	writeJSON(w, http.StatusOK, combined)
+}
+
+// 执行一个子请求，返回其 JSON 响应体
+func (a *Aggregator) do(ctx context.Context, orig *http.Request, sub SubRequest) (json.RawMessage, error) {
+	timeout := sub.Timeout
+	if timeout <= 0 {
+		timeout = a.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultAggregateTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	method := sub.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, expandPath(sub.Path, orig.URL.Query()), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = orig.Header.Clone()
+	removeHopHeaders(req.Header, false)
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept-Encoding") //子响应需要解析，不接受压缩
+	req.Host = orig.Host
+	req.RemoteAddr = orig.RemoteAddr
+	req.RequestURI = req.URL.RequestURI()
+
+	//Handler 不响应取消时也按超时返回，此时丢弃其写入的内容
+	rec := &bufferedResponse{header: http.Header{}, max: a.maxBytes()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Handler.ServeHTTP(rec, req)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("sub-request %s: %w", sub.Key, ctx.Err())
+	}
+	if rec.tooLarge {
+		return nil, errAggregateTooLarge
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status < 200 || rec.status > 299 {
+		return nil, fmt.Errorf("upstream status %d", rec.status)
+	}
+	body := bytes.TrimSpace(rec.body.Bytes())
+	if !json.Valid(body) {
+		return nil, errors.New("invalid json in sub-response")
+	}
+	return body, nil
+}
+
+func (a *Aggregator) maxBytes() int64 {
+	if a.MaxBytes > 0 {
+		return a.MaxBytes
+	}
+	return DefaultAggregateMaxBytes
+}
+
+// 把 {name} 替换为查询参数的值，值经过转义
+func expandPath(tmpl string, query url.Values) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			break
+		}
+		b.WriteString(tmpl[:start])
+		b.WriteString(url.PathEscape(query.Get(tmpl[start+1 : start+end])))
+		tmpl = tmpl[start+end+1:]
+	}
+	b.WriteString(tmpl)
+	return b.String()
+}
+
+// 缓冲子请求的响应
+type bufferedResponse struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	max      int64
+	tooLarge bool
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) WriteHeader(code int) {
+	if r.status == 0 && code >= 200 {
+		r.status = code
+	}
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if int64(r.body.Len()+len(b)) > r.max {
+		r.tooLarge = true
+		return 0, errAggregateTooLarge
+	}
+	return r.body.Write(b)
+}
@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 每个后端延迟 100ms，返回收到的路径与认证头
+func aggregateRouter(t *testing.T) *Router {
+	r := NewRouter()
+	for _, name := range []string{"users", "orders"} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"path": req.URL.RequestURI(), "auth": req.Header.Get("Authorization")})
+		}))
+		t.Cleanup(upstream.Close)
+		lb := &load_balance.RoundRobinBalance{}
+		lb.Add(upstream.URL)
+		r.Handle(&Route{Name: name, PathPrefix: "/" + name, Handler: NewProxy(lb, Options{})})
+	}
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(failing.URL)
+	r.Handle(&Route{Name: "failing", PathPrefix: "/failing", Handler: NewProxy(lb, Options{})})
+	return r
+}
+
+func TestAggregatorParallel(t *testing.T) {
+	r := aggregateRouter(t)
+	r.Handle(&Route{Name: "bff", PathPrefix: "/bff", Handler: &Aggregator{Handler: r, SubRequests: []SubRequest{
+		{Key: "user", Path: "/users/{id}"},
+		{Key: "orders", Path: "/orders?user={id}&limit=5"},
+	}}})
+
+	req := httptest.NewRequest("GET", "/bff/home?id=42", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	r.ServeHTTP(rec, req)
+	if d := time.Since(start); d > 180*time.Millisecond {
+		t.Fatalf("sub-requests not parallel, took %s", d)
+	}
+	var got map[string]map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if got["user"]["path"] != "/users/42" || got["orders"]["path"] != "/orders?user=42&limit=5" {
+		t.Fatalf("path templates not expanded: %v", got)
+	}
+	if got["user"]["auth"] != "Bearer token" || got["orders"]["auth"] != "Bearer token" {
+		t.Fatalf("headers not propagated: %v", got)
+	}
+}
+
+func TestAggregatorPartialFailure(t *testing.T) {
+	r := aggregateRouter(t)
+	subs := []SubRequest{
+		{Key: "user", Path: "/users/1"},
+		{Key: "broken", Path: "/failing"},
+		{Key: "slow", Path: "/orders", Timeout: 20 * time.Millisecond},
+		{Key: "loop", Path: "/bff"},
+	}
+	r.Handle(&Route{Name: "bff", PathPrefix: "/bff", Handler: &Aggregator{Handler: r, SubRequests: subs}})
+	r.Handle(&Route{Name: "strict", PathPrefix: "/strict", Handler: &Aggregator{Handler: r, SubRequests: subs, FailAll: true}})
+
+	before := aggregateErrors.Get("broken")
+	rec := serve(r, "GET", "/bff", "10.0.0.1:1234")
+	var got map[string]map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if got["user"]["path"] != "/users/1" {
+		t.Fatalf("successful key missing: %v", got)
+	}
+	for _, key := range []string{"broken", "slow", "loop"} {
+		if got[key]["error"] == "" {
+			t.Fatalf("%s should report an error: %v", key, got)
+		}
+	}
+	if aggregateErrors.Get("broken") != before+1 {
+		t.Fatal("sub-request error not counted")
+	}
+
+	rec = serve(r, "GET", "/strict", "10.0.0.1:1234")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("fail-all should return 502, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	query := map[string][]string{"id": {"a b/c"}}
+	for tmpl, want := range map[string]string{
+		"/users/{id}":   "/users/a%20b%2Fc",
+		"/users/{none}": "/users/",
+		"/plain":        "/plain",
+		"/open/{id":     "/open/{id",
+	} {
+		if got := expandPath(tmpl, query); got != want {
+			t.Fatalf("%s: got %s want %s", tmpl, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+)
+
+const (
+	DefaultBodyMemoryThreshold = 1 << 20
+	DefaultBodyMaxBuffer       = 32 << 20
+)
+
+var (
+	bodySpilled    = metrics.NewCounterVec("gateway_request_body_spilled_total", "请求体超过内存阈值、缓冲到临时文件的次数", "route")
+	bodyUnbuffered = metrics.NewCounterVec("gateway_request_body_unbuffered_total", "请求体超过缓冲上限、不可重试的次数", "route")
+)
+
+// 缓冲请求体并设置 req.GetBody，使请求可以重放。
+// 不超过 memLimit 的请求体保存在内存，更大的写入 dir 下的临时文件，超过 maxLimit 则不缓冲，GetBody 保持为空。
+// 返回的 cleanup 在请求结束后调用，用于删除临时文件
+func bufferRequestBody(req *http.Request, memLimit, maxLimit int64, dir string) (cleanup func(), err error) {
+	cleanup = func() {}
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return cleanup, nil
+	}
+	route := routeName(req)
+	if req.ContentLength > maxLimit {
+		bodyUnbuffered.Inc(route)
+		return cleanup, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(req.Body, memLimit+1))
+	if err != nil {
+		return cleanup, err
+	}
+	if int64(len(buf)) <= memLimit {
+		req.Body.Close()
+		req.ContentLength = int64(len(buf))
+		req.Body = io.NopCloser(bytes.NewReader(buf))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		}
+		return cleanup, nil
+	}
+
+	f, err := os.CreateTemp(dir, "gateway-body-*")
+	if err != nil {
+		return cleanup, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := f.Write(buf)
+	if err == nil {
+		var copied int64
+		copied, err = io.Copy(f, io.LimitReader(req.Body, maxLimit-int64(n)+1))
+		n += int(copied)
+	}
+	if err != nil {
+		cleanup()
+		return func() {}, err
+	}
+	size := int64(n)
+	if size > maxLimit {
+		//超过上限：已读部分从临时文件回放，剩余部分继续读原始请求体
+		bodyUnbuffered.Inc(route)
+		req.Body = readCloser{io.MultiReader(io.NewSectionReader(f, 0, size), req.Body), req.Body}
+		return cleanup, nil
+	}
+	bodySpilled.Inc(route)
+	req.Body.Close()
+	req.ContentLength = size
+	req.Body = io.NopCloser(io.NewSectionReader(f, 0, size))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(f, 0, size)), nil
+	}
+	return cleanup, nil
+}
+
+func routeName(req *http.Request) string {
+	if route := RouteFromContext(req.Context()); route != nil {
+		return route.Name
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func dirEntries(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestBufferRequestBodyMemory(t *testing.T) {
+	dir := t.TempDir()
+	req := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader("hello")))
+	req.ContentLength = -1
+	cleanup, err := bufferRequestBody(req, 16, 64, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if req.GetBody == nil || req.ContentLength != 5 || dirEntries(t, dir) != 0 {
+		t.Fatalf("small body should be buffered in memory, len %d files %d", req.ContentLength, dirEntries(t, dir))
+	}
+	for i := 0; i < 2; i++ {
+		body, _ := req.GetBody()
+		if data, _ := io.ReadAll(body); string(data) != "hello" {
+			t.Fatalf("replay %d got %q", i, data)
+		}
+	}
+	if data, _ := io.ReadAll(req.Body); string(data) != "hello" {
+		t.Fatalf("body got %q", data)
+	}
+}
+
+func TestBufferRequestBodyOversized(t *testing.T) {
+	dir := t.TempDir()
+	payload := strings.Repeat("x", 100)
+	before := bodyUnbuffered.Get("")
+	for _, length := range []int64{100, -1} {
+		req := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(payload)))
+		req.ContentLength = length
+		cleanup, err := bufferRequestBody(req, 16, 64, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.GetBody != nil {
+			t.Fatal("oversized body should not be replayable")
+		}
+		if data, _ := io.ReadAll(req.Body); string(data) != payload {
+			t.Fatalf("oversized body changed, got %d bytes", len(data))
+		}
+		cleanup()
+	}
+	if bodyUnbuffered.Get("") != before+2 {
+		t.Fatal("oversized requests not counted")
+	}
+	if dirEntries(t, dir) != 0 {
+		t.Fatal("temp file not removed")
+	}
+}
+
+func TestProxyBodySpillToDisk(t *testing.T) {
+	dir := t.TempDir()
+	payload := strings.Repeat("0123456789", 100)
+	var filesDuringRequest int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		filesDuringRequest = dirEntries(t, dir)
+		data, _ := io.ReadAll(req.Body)
+		w.Write(data)
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	p := NewProxy(lb, Options{MaxRetries: 1, BodyMemoryThreshold: 64, BodyMaxBuffer: 4096, BodySpillDir: dir})
+
+	before := bodySpilled.Get("")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("PUT", "/", strings.NewReader(payload)))
+	if rec.Body.String() != payload {
+		t.Fatalf("upstream got %d bytes", rec.Body.Len())
+	}
+	if filesDuringRequest != 1 {
+		t.Fatalf("expected body spilled to disk, %d files", filesDuringRequest)
+	}
+	if dirEntries(t, dir) != 0 {
+		t.Fatal("temp file not removed after request")
+	}
+	if bodySpilled.Get("") != before+1 {
+		t.Fatal("spill not counted")
+	}
+}
+
+func TestProxyRetryAfterSpill(t *testing.T) {
+	dir := t.TempDir()
+	payload := strings.Repeat("abcdefgh", 200)
+	var got string
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits++
+		data, _ := io.ReadAll(req.Body)
+		got = string(data)
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	lb.Add(refusedAddr(t)) //轮询从第二个后端开始，首次请求必然失败
+	p := NewProxy(lb, Options{MaxRetries: 1, BodyMemoryThreshold: 64, BodySpillDir: dir})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("PUT", "/upload?a=1", strings.NewReader(payload)))
+	if rec.Code != http.StatusOK || hits != 1 || got != payload {
+		t.Fatalf("retry failed: code %d hits %d body %d bytes", rec.Code, hits, len(got))
+	}
+	if dirEntries(t, dir) != 0 {
+		t.Fatal("temp file not removed after retry")
+	}
+
+	//超过缓冲上限的请求不重试
+	lb = &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	lb.Add(refusedAddr(t))
+	p = NewProxy(lb, Options{MaxRetries: 1, BodyMemoryThreshold: 64, BodyMaxBuffer: 128, BodySpillDir: dir})
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("PUT", "/upload", strings.NewReader(payload)))
+	if rec.Code != http.StatusBadGateway || hits != 1 {
+		t.Fatalf("oversized request retried: code %d hits %d", rec.Code, hits)
+	}
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouterBodyLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		w.Write(data)
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	p := NewProxy(lb, Options{})
+
+	r := NewRouter()
+	r.MaxBodyBytes = 16
+	r.Handle(&Route{Name: "api", PathPrefix: "/", Handler: p})
+	r.Handle(&Route{Name: "upload", PathPrefix: "/upload", Handler: p, MaxBodyBytes: 1024})
+	gw := httptest.NewServer(r)
+	defer gw.Close()
+
+	post := func(path string, size int, chunked bool) *http.Response {
+		var body io.Reader = strings.NewReader(strings.Repeat("x", size))
+		if chunked {
+			body = io.MultiReader(body) //隐藏长度，使用分块编码
+		}
+		resp, err := http.Post(gw.URL+path, "text/plain", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post("/api", 10, false); resp.StatusCode != http.StatusOK {
+		t.Fatalf("small body got %d", resp.StatusCode)
+	}
+	if resp := post("/api", 100, false); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large body got %d", resp.StatusCode)
+	}
+	if resp := post("/api", 100, true); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunked overrun got %d", resp.StatusCode)
+	}
+	if resp := post("/upload", 100, true); resp.StatusCode != http.StatusOK {
+		t.Fatalf("route override got %d", resp.StatusCode)
+	}
+}
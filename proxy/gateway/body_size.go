@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	errResponseTooLarge = errors.New("upstream response body too large")
+
+	requestBodyBytes  = metrics.NewHistogramVec("gateway_request_body_bytes", "按路由统计的请求体实际字节数", "route", metrics.SizeBuckets)
+	responseBodyBytes = metrics.NewHistogramVec("gateway_response_body_bytes", "按路由统计的响应体实际字节数", "route", metrics.SizeBuckets)
+	responseTooLarge  = metrics.NewCounterVec("gateway_response_too_large_total", "响应体超过路由限制的次数", "route")
+)
+
+// 响应体超过 Route.MaxResponseBytes 时的处理方式
+type ResponseLimitMode int
+
+const (
+	ResponseLimitReject ResponseLimitMode = iota //已知长度超限返回 502，分块响应在超限处截断
+	ResponseLimitFlag                            //照常转发，只计数并记录日志
+)
+
+// 统计实际读取的请求体字节数，分块上传也按实际读到的字节计算
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// 统计写给客户端的响应体字节数
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// 对上游响应执行路由的大小限制
+func limitResponse(resp *http.Response, route *Route) error {
+	max := route.MaxResponseBytes
+	if max <= 0 || resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	if resp.ContentLength > max {
+		responseTooLarge.Inc(route.Name)
+		if route.ResponseLimit == ResponseLimitReject {
+			resp.Body.Close()
+			return errResponseTooLarge
+		}
+		fmt.Println("response too large", route.Name, resp.Request.URL.Path, resp.ContentLength)
+		return nil
+	}
+	if resp.ContentLength < 0 {
+		resp.Body = &limitedResponseBody{ReadCloser: resp.Body, route: route, remaining: max}
+	}
+	return nil
+}
+
+// 长度未知的响应在读取过程中检查上限
+type limitedResponseBody struct {
+	io.ReadCloser
+	route     *Route
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedResponseBody) Read(p []byte) (int, error) {
+	reject := b.route.ResponseLimit == ResponseLimitReject
+	if reject {
+		if b.exceeded {
+			return 0, errResponseTooLarge
+		}
+		//多读一个字节用于判断是否超限
+		if int64(len(p)) > b.remaining+1 {
+			p = p[:b.remaining+1]
+		}
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	if !b.exceeded {
+		b.exceeded = true
+		responseTooLarge.Inc(b.route.Name)
+		if !reject {
+			fmt.Println("response too large", b.route.Name)
+		}
+	}
+	if reject {
+		//只输出到上限为止
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, errResponseTooLarge
+	}
+	b.remaining = 0
+	return n, err
+}
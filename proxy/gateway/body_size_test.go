@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodySizeAccounting(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+		//分块响应
+		for i := 0; i < 3; i++ {
+			w.Write([]byte(strings.Repeat("x", 1000)))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	r := NewRouter()
+	r.Handle(&Route{Name: "size-accounting", PathPrefix: "/", Handler: NewProxy(lb, Options{}), MaxBodyBytes: 4096})
+
+	//长度未知的分块上传
+	req := httptest.NewRequest("POST", "/upload", io.MultiReader(strings.NewReader(strings.Repeat("y", 2500))))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 3000 {
+		t.Fatalf("got %d %d bytes", rec.Code, rec.Body.Len())
+	}
+	reqHist, respHist := requestBodyBytes.Get("size-accounting"), responseBodyBytes.Get("size-accounting")
+	if reqHist.Count != 1 || reqHist.Sum != 2500 || respHist.Count != 1 || respHist.Sum != 3000 {
+		t.Fatalf("request %+v response %+v", reqHist, respHist)
+	}
+	//2500 字节落在 4KB 的桶中
+	if reqHist.Counts[1] != 0 || reqHist.Counts[2] != 1 {
+		t.Fatalf("bucket counts %v", reqHist.Counts)
+	}
+
+	//请求体超过路由上限
+	req = httptest.NewRequest("POST", "/upload", io.MultiReader(strings.NewReader(strings.Repeat("y", 5000))))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized request got %d", rec.Code)
+	}
+}
+
+func TestResponseLimit(t *testing.T) {
+	payload := strings.Repeat("z", 3000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/chunked") {
+			for i := 0; i < 3; i++ {
+				w.Write([]byte(payload[:1000]))
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
+		w.Header().Set("Content-Length", "3000")
+		w.Write([]byte(payload))
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	p := NewProxy(lb, Options{})
+	r := NewRouter()
+	r.Handle(&Route{Name: "resp-reject", PathPrefix: "/reject", Handler: p, MaxResponseBytes: 1500})
+	r.Handle(&Route{Name: "resp-flag", PathPrefix: "/flag", Handler: p, MaxResponseBytes: 1500, ResponseLimit: ResponseLimitFlag})
+
+	//已知长度超限直接 502
+	rec := serve(r, "GET", "/reject", "1.1.1.1:1")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("known length got %d", rec.Code)
+	}
+	//分块响应在上限处截断
+	rec = serve(r, "GET", "/reject/chunked", "1.1.1.1:1")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 1500 {
+		t.Fatalf("chunked got %d %d bytes", rec.Code, rec.Body.Len())
+	}
+	if responseTooLarge.Get("resp-reject") != 2 {
+		t.Fatalf("reject count %d", responseTooLarge.Get("resp-reject"))
+	}
+
+	//只标记的路由完整转发
+	for _, target := range []string{"/flag", "/flag/chunked"} {
+		rec = serve(r, "GET", target, "1.1.1.1:1")
+		if rec.Code != http.StatusOK || rec.Body.String() != payload {
+			t.Fatalf("%s got %d %d bytes", target, rec.Code, rec.Body.Len())
+		}
+	}
+	if responseTooLarge.Get("resp-flag") != 2 {
+		t.Fatalf("flag count %d", responseTooLarge.Get("resp-flag"))
+	}
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/middleware"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errBodyIdleTimeout = errors.New("upstream response body idle timeout")
+
+	bodyIdleTimeouts = metrics.NewCounterVec("gateway_body_idle_timeouts_total", "上游响应体长时间没有数据、被中断的次数", "route")
+)
+
+// 本次请求响应体的空闲超时，路由配置优先，负数表示不检查
+func (p *Proxy) bodyIdleTimeout(route *Route) time.Duration {
+	timeout := p.opts.BodyIdleTimeout
+	if route != nil && route.BodyIdleTimeout != 0 {
+		timeout = route.BodyIdleTimeout
+	}
+	if timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+// 为上游响应体加上空闲检查：一次读取超过 timeout 没有返回数据时关闭响应体，
+// 中断上游请求并释放连接。计时只在读取上游时进行，写给慢客户端的时间不计入。
+// 响应头已发出时 ReverseProxy 会以 http.ErrAbortHandler 中断，客户端连接被关闭
+func watchResponseBody(resp *http.Response, timeout time.Duration) {
+	if timeout <= 0 || resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		return
+	}
+	b := &watchdogBody{ReadCloser: resp.Body, req: resp.Request, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, b.fire)
+	b.timer.Stop()
+	resp.Body = b
+}
+
+type watchdogBody struct {
+	io.ReadCloser
+	req     *http.Request
+	timeout time.Duration
+	timer   *time.Timer
+	n       int64 //已读取的字节数
+	fired   int32
+}
+
+func (b *watchdogBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&b.fired) == 1 {
+		return 0, errBodyIdleTimeout
+	}
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	atomic.AddInt64(&b.n, int64(n))
+	if atomic.LoadInt32(&b.fired) == 1 {
+		return n, errBodyIdleTimeout
+	}
+	return n, err
+}
+
+func (b *watchdogBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
+
+func (b *watchdogBody) fire() {
+	if !atomic.CompareAndSwapInt32(&b.fired, 0, 1) {
+		return
+	}
+	route := routeName(b.req)
+	bodyIdleTimeouts.Inc(route)
+	middleware.SetLogField(b.req, "aborted", "body_idle_timeout")
+	fmt.Println("upstream body idle timeout", route, b.req.URL.Path, "after", atomic.LoadInt64(&b.n), "bytes")
+	b.ReadCloser.Close()
+}
@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/middleware"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 发送 1KB 后停止发送的后端
+func stallingUpstream(t *testing.T) *httptest.Server {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 1024))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-req.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(stop)
+		srv.Close()
+	})
+	return srv
+}
+
+// 把每行日志发送到 channel，避免测试与处理请求的 goroutine 竞争
+type logSink chan string
+
+func (s logSink) Write(p []byte) (int, error) {
+	s <- string(p)
+	return len(p), nil
+}
+
+func TestBodyIdleTimeoutAborts(t *testing.T) {
+	upstream := stallingUpstream(t)
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	r := NewRouter()
+	r.Handle(&Route{Name: "stall", PathPrefix: "/", Handler: NewProxy(lb, Options{BodyIdleTimeout: 100 * time.Millisecond})})
+	logs := logSink(make(chan string, 1))
+	h := (&middleware.AccessLog{Logger: log.New(logs, "", 0)}).Handler(r)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	before := bodyIdleTimeouts.Get("stall")
+	start := time.Now()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if err == nil || len(data) != 1024 {
+		t.Fatalf("client should see a truncated transfer, got %d bytes err %v", len(data), err)
+	}
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("abort took %s", elapsed)
+	}
+	if bodyIdleTimeouts.Get("stall") != before+1 {
+		t.Fatal("idle timeout not counted")
+	}
+	if line := <-logs; !strings.Contains(line, "aborted=body_idle_timeout") {
+		t.Fatalf("partial transfer not flagged in access log: %q", line)
+	}
+}
+
+func TestBodyIdleTimeoutPerRoute(t *testing.T) {
+	upstream := stallingUpstream(t)
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	p := NewProxy(lb, Options{BodyIdleTimeout: 50 * time.Millisecond})
+	r := NewRouter()
+	r.Handle(&Route{Name: "sse", PathPrefix: "/events", Handler: p, BodyIdleTimeout: -1})
+	r.Handle(&Route{Name: "slow", PathPrefix: "/slow", Handler: p, BodyIdleTimeout: 300 * time.Millisecond})
+
+	//关闭检查的路由一直等待，直到客户端断开
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	client := &http.Client{Timeout: 400 * time.Millisecond}
+	resp, err := client.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if time.Since(start) < 300*time.Millisecond {
+		t.Fatal("disabled watchdog aborted the stream")
+	}
+
+	resp, err = http.Get(srv.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if d := time.Since(start); d < 250*time.Millisecond || d > time.Second {
+		t.Fatalf("route watchdog should abort after about 300ms, took %s", d)
+	}
+}
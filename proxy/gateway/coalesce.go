@@ -0,0 +1,240 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCoalesceMaxBytes   = 1 << 20
+	DefaultCoalesceDisableFor = time.Minute
+)
+
+// 参与合并键计算的默认请求头，内容协商或身份不同的请求不会被合并
+var DefaultCoalesceVaryHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"}
+
+var (
+	coalescedRequests = metrics.NewCounterVec("gateway_coalesced_requests_total", "与进行中的相同请求合并、未访问上游的请求数", "")
+	coalesceDisabled  = metrics.NewCounterVec("gateway_coalesce_disabled_total", "响应超过缓冲上限、暂停合并的次数", "")
+)
+
+type CoalesceConf struct {
+	MaxBytes    int64         //可共享的响应体上限，默认 DefaultCoalesceMaxBytes
+	VaryHeaders []string      //参与合并键计算的请求头，默认 DefaultCoalesceVaryHeaders
+	DisableFor  time.Duration //响应超过上限后该键暂停合并的时长，默认 DefaultCoalesceDisableFor
+}
+
+// 合并并发的相同 GET/HEAD 请求：同一时刻只有第一个请求(leader)访问上游，
+// 其余请求等待并复用其响应。leader 的响应同时写给自己的客户端并缓冲，
+// 超过上限或 leader 被取消时等待的请求各自访问上游。
+// 放在响应缓存之前时，合并后的唯一一次请求会写入缓存
+type Coalescer struct {
+	conf CoalesceConf
+
+	mux      sync.Mutex
+	calls    map[string]*coalesceCall
+	disabled map[string]time.Time //暂停合并的键 -> 恢复时间
+}
+
+type coalesceCall struct {
+	done   chan struct{}
+	result *coalesceResult //为空表示不可共享
+}
+
+type coalesceResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func NewCoalescer(conf CoalesceConf) *Coalescer {
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = DefaultCoalesceMaxBytes
+	}
+	if conf.VaryHeaders == nil {
+		conf.VaryHeaders = DefaultCoalesceVaryHeaders
+	}
+	if conf.DisableFor <= 0 {
+		conf.DisableFor = DefaultCoalesceDisableFor
+	}
+	return &Coalescer{conf: conf, calls: map[string]*coalesceCall{}, disabled: map[string]time.Time{}}
+}
+
+func (c *Coalescer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead || req.ContentLength > 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		key := c.key(req)
+		call, leader := c.join(key)
+		if call == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if leader {
+			c.lead(key, call, w, req, next)
+			return
+		}
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			return
+		}
+		if call.result == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		coalescedRequests.Inc("")
+		call.result.writeTo(w)
+	})
+}
+
+func (c *Coalescer) key(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.Host)
+	b.WriteString(req.URL.RequestURI())
+	for _, name := range c.conf.VaryHeaders {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// 加入进行中的请求，没有则成为 leader；键暂停合并时返回 nil
+func (c *Coalescer) join(key string) (*coalesceCall, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if until, ok := c.disabled[key]; ok {
+		if time.Now().Before(until) {
+			return nil, false
+		}
+		delete(c.disabled, key)
+	}
+	if call, ok := c.calls[key]; ok {
+		return call, false
+	}
+	call := &coalesceCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+func (c *Coalescer) lead(key string, call *coalesceCall, w http.ResponseWriter, req *http.Request, next http.Handler) {
+	cw := &coalesceWriter{ResponseWriter: w, max: c.conf.MaxBytes}
+	cw.overflowed = func() {
+		c.disable(key)
+		c.finish(key, call, nil)
+	}
+	//放在 defer 中，panic 时也能释放等待的请求；panic(如 http.ErrAbortHandler)时已写出的内容不完整，
+	//等待者自行请求上游
+	defer func() {
+		if err := recover(); err != nil {
+			c.finish(key, call, nil)
+			panic(err)
+		}
+		if cw.overflow || req.Context().Err() != nil {
+			c.finish(key, call, nil)
+			return
+		}
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		header := cw.header
+		if header == nil {
+			header = w.Header().Clone()
+		}
+		c.finish(key, call, &coalesceResult{status: status, header: header, body: cw.buf.Bytes()})
+	}()
+	next.ServeHTTP(cw, req)
+}
+
+// 只执行一次：从进行中的请求中删除并唤醒等待者
+func (c *Coalescer) finish(key string, call *coalesceCall, result *coalesceResult) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.calls[key] != call {
+		return
+	}
+	delete(c.calls, key)
+	call.result = result
+	close(call.done)
+}
+
+// 响应过大，暂停该键的合并，顺便清理已过期的键
+func (c *Coalescer) disable(key string) {
+	coalesceDisabled.Inc("")
+	now := time.Now()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for k, until := range c.disabled {
+		if now.After(until) {
+			delete(c.disabled, k)
+		}
+	}
+	c.disabled[key] = now.Add(c.conf.DisableFor)
+}
+
+// 每个等待者写出独立的响应头副本
+func (r *coalesceResult) writeTo(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range r.header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body)
+}
+
+// 写给 leader 客户端的同时缓冲响应，超过上限后立即释放等待者
+type coalesceWriter struct {
+	http.ResponseWriter
+	max      int64
+	status   int
+	header   http.Header
+	buf      bytes.Buffer
+	overflow bool
+
+	overflowed func()
+}
+
+func (w *coalesceWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *coalesceWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if int64(w.buf.Len()+len(b)) > w.max {
+			w.overflow = true
+			w.buf = bytes.Buffer{}
+			w.overflowed()
+		} else {
+			w.buf.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *coalesceWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *coalesceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
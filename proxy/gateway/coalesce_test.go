@@ -0,0 +1,187 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 并发发起 n 个相同请求，等上游收到请求后再放行
+func coalesceFire(t *testing.T, h http.Handler, n int, release chan struct{}, setup func(req *http.Request)) []*httptest.ResponseRecorder {
+	recs := make([]*httptest.ResponseRecorder, n)
+	var started, done sync.WaitGroup
+	for i := range recs {
+		started.Add(1)
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			req := httptest.NewRequest("GET", "/items?page=1", nil)
+			if setup != nil {
+				setup(req)
+			}
+			recs[i] = httptest.NewRecorder()
+			started.Done()
+			h.ServeHTTP(recs[i], req)
+		}(i)
+	}
+	started.Wait()
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	done.Wait()
+	return recs
+}
+
+func TestCoalesceConcurrentGets(t *testing.T) {
+	var hits int64
+	var release chan struct{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		<-release
+		w.Header().Set("X-Backend", "a")
+		w.Write([]byte("items"))
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	h := NewCoalescer(CoalesceConf{}).Handler(NewProxy(lb, Options{}))
+
+	before := coalescedRequests.Get("")
+	release = make(chan struct{})
+	recs := coalesceFire(t, h, 100, release, nil)
+	if hits != 1 {
+		t.Fatalf("expected exactly one upstream request, got %d", hits)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != "items" || rec.Header().Get("X-Backend") != "a" {
+			t.Fatalf("request %d got %d %q %v", i, rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+	if coalescedRequests.Get("") != before+99 {
+		t.Fatalf("coalesced requests not counted: %d", coalescedRequests.Get("")-before)
+	}
+	//响应头各自独立
+	recs[0].Header().Set("X-Backend", "changed")
+	if recs[1].Header().Get("X-Backend") != "a" {
+		t.Fatal("waiters share the same header map")
+	}
+
+	//请求完成后不再合并
+	release = make(chan struct{})
+	close(release)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items?page=1", nil))
+	if hits != 2 {
+		t.Fatalf("finished request should not be reused, hits %d", hits)
+	}
+}
+
+func TestCoalesceKeyHeaders(t *testing.T) {
+	var hits int64
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		<-release
+		w.Write([]byte(req.Header.Get("Authorization")))
+	})
+	h := NewCoalescer(CoalesceConf{}).Handler(upstream)
+	var n int64
+	recs := coalesceFire(t, h, 10, release, func(req *http.Request) {
+		req.Header.Set("Authorization", "user"+string(rune('0'+atomic.AddInt64(&n, 1)%2)))
+	})
+	if hits != 2 {
+		t.Fatalf("different credentials should not be coalesced, hits %d", hits)
+	}
+	for _, rec := range recs {
+		if !strings.HasPrefix(rec.Body.String(), "user") {
+			t.Fatalf("got %q", rec.Body.String())
+		}
+	}
+
+	//非安全方法不合并
+	hits = 0
+	release = make(chan struct{})
+	close(release)
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/items", nil))
+	}
+	if hits != 3 {
+		t.Fatalf("POST should not be coalesced, hits %d", hits)
+	}
+}
+
+func TestCoalesceOverCap(t *testing.T) {
+	var hits int64
+	release := make(chan struct{})
+	body := strings.Repeat("x", 100)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		<-release
+		w.Write([]byte(body))
+	})
+	h := NewCoalescer(CoalesceConf{MaxBytes: 64}).Handler(upstream)
+
+	before := coalesceDisabled.Get("")
+	recs := coalesceFire(t, h, 5, release, nil)
+	for _, rec := range recs {
+		if rec.Body.String() != body {
+			t.Fatalf("got %d bytes", rec.Body.Len())
+		}
+	}
+	if hits != 5 || coalesceDisabled.Get("") != before+1 {
+		t.Fatalf("oversized response should not be shared, hits %d", hits)
+	}
+
+	//超过上限后该键暂停合并
+	hits = 0
+	release = make(chan struct{})
+	coalesceFire(t, h, 5, release, nil)
+	if hits != 5 {
+		t.Fatalf("disabled key should not be coalesced, hits %d", hits)
+	}
+}
+
+func TestCoalesceLeaderPanic(t *testing.T) {
+	var hits int64
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		w.Header().Set("Content-Length", "100")
+		if atomic.AddInt64(&hits, 1) == 1 {
+			//上游在响应体中途断开
+			w.Write([]byte("partial"))
+			panic(http.ErrAbortHandler)
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	})
+	coalesced := NewCoalescer(CoalesceConf{}).Handler(upstream)
+	//与 http.Server 一样吞掉 ErrAbortHandler
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if err := recover(); err != nil && err != http.ErrAbortHandler {
+				panic(err)
+			}
+		}()
+		coalesced.ServeHTTP(w, req)
+	})
+
+	before := coalescedRequests.Get("")
+	recs := coalesceFire(t, h, 5, release, nil)
+	if hits != 5 || coalescedRequests.Get("") != before {
+		t.Fatalf("aborted response should not be shared, hits %d", hits)
+	}
+	partial := 0
+	for _, rec := range recs {
+		if rec.Body.String() == "partial" {
+			partial++
+		} else if rec.Body.Len() != 100 {
+			t.Fatalf("got %d bytes", rec.Body.Len())
+		}
+	}
+	if partial != 1 {
+		t.Fatalf("%d requests got the partial body", partial)
+	}
+}
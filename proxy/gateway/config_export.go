@@ -0,0 +1,493 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 导出时替换敏感信息的占位符
+const ConfigRedacted = "redacted"
+
+// 网关生效配置的完整快照，GET /config/export 导出，POST /config/import 导入到另一个实例。
+// 时间长度为 time.ParseDuration 的格式，如 "1.5s"
+type ConfigDocument struct {
+	Routes     []ConfigRoute     `json:"routes"`
+	Pools      []ConfigPool      `json:"pools"`
+	Transports []ConfigTransport `json:"transports,omitempty"`
+}
+
+// 路由的可序列化部分。处理器、中间件与转发钩子无法导出，导入时沿用同名路由的，新增的路由通过 Admin.RouteHandler 创建
+type ConfigRoute struct {
+	Name               string                `json:"name"`
+	Host               string                `json:"host,omitempty"`
+	PathPrefix         string                `json:"path_prefix"`
+	Headers            []ConfigMatcher       `json:"headers,omitempty"`
+	Query              []ConfigMatcher       `json:"query,omitempty"`
+	Methods            []string              `json:"methods,omitempty"`
+	MaxBodyBytes       int64                 `json:"max_body_bytes,omitempty"`
+	RetryNonIdempotent bool                  `json:"retry_non_idempotent,omitempty"`
+	Timeout            string                `json:"timeout,omitempty"`
+	MaxTimeout         string                `json:"max_timeout,omitempty"`
+	MaxResponseBytes   int64                 `json:"max_response_bytes,omitempty"`
+	ResponseLimit      ResponseLimitMode     `json:"response_limit,omitempty"`
+	BodyIdleTimeout    string                `json:"body_idle_timeout,omitempty"`
+	Transport          *ConfigRouteTransport `json:"transport,omitempty"`
+}
+
+type ConfigMatcher struct {
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
+	Regexp string `json:"regexp,omitempty"`
+	Absent bool   `json:"absent,omitempty"`
+}
+
+type ConfigRouteTransport struct {
+	DialTimeout           string `json:"dial_timeout,omitempty"`
+	ResponseHeaderTimeout string `json:"response_header_timeout,omitempty"`
+	MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host,omitempty"`
+	ForceAttemptHTTP2     bool   `json:"force_attempt_http2,omitempty"`
+	DisableCompression    bool   `json:"disable_compression,omitempty"`
+}
+
+type ConfigPool struct {
+	Pool     string          `json:"pool"`
+	Backends []ConfigBackend `json:"backends"`
+}
+
+type ConfigBackend struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight,omitempty"` //为 0 时导入为默认权重 50
+}
+
+// 后端的连接参数。TLS 设置与代理密码不导出，导入时保留当前实例的设置
+type ConfigTransport struct {
+	Host                  string `json:"host"`
+	MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host,omitempty"`
+	DialTimeout           string `json:"dial_timeout,omitempty"`
+	KeepAlive             string `json:"keep_alive,omitempty"`
+	IdleConnTimeout       string `json:"idle_conn_timeout,omitempty"`
+	ResponseHeaderTimeout string `json:"response_header_timeout,omitempty"`
+	TLS                   string `json:"tls,omitempty"`            //设置了 TLS 时为 ConfigRedacted
+	OutboundProxy         string `json:"outbound_proxy,omitempty"` //密码替换为 ConfigRedacted 的代理地址
+}
+
+// 一个配置段的变化，按名称排序
+type ConfigSectionDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// 导入的结果，Pools 只包含后端有变化的 pool
+type ConfigImportResult struct {
+	Routes     ConfigSectionDiff         `json:"routes"`
+	Pools      []load_balance.ConfChange `json:"pools"`
+	Transports ConfigSectionDiff         `json:"transports"`
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func parseDuration(field, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", field, s)
+	}
+	return d, nil
+}
+
+func exportMatchers(matchers []Matcher) []ConfigMatcher {
+	var list []ConfigMatcher
+	for _, m := range matchers {
+		cm := ConfigMatcher{Name: m.Name, Value: m.Value, Absent: m.Absent}
+		if m.Regexp != nil {
+			cm.Regexp = m.Regexp.String()
+		}
+		list = append(list, cm)
+	}
+	return list
+}
+
+func exportRoute(route *Route) ConfigRoute {
+	cr := ConfigRoute{
+		Name:               route.Name,
+		Host:               route.Host,
+		PathPrefix:         route.PathPrefix,
+		Headers:            exportMatchers(route.Headers),
+		Query:              exportMatchers(route.Query),
+		Methods:            route.Methods,
+		MaxBodyBytes:       route.MaxBodyBytes,
+		RetryNonIdempotent: route.RetryNonIdempotent,
+		Timeout:            formatDuration(route.Timeout),
+		MaxTimeout:         formatDuration(route.MaxTimeout),
+		MaxResponseBytes:   route.MaxResponseBytes,
+		ResponseLimit:      route.ResponseLimit,
+		BodyIdleTimeout:    formatDuration(route.BodyIdleTimeout),
+	}
+	if t := route.Transport; t != nil {
+		cr.Transport = &ConfigRouteTransport{
+			DialTimeout:           formatDuration(t.DialTimeout),
+			ResponseHeaderTimeout: formatDuration(t.ResponseHeaderTimeout),
+			MaxIdleConnsPerHost:   t.MaxIdleConnsPerHost,
+			ForceAttemptHTTP2:     t.ForceAttemptHTTP2,
+			DisableCompression:    t.DisableCompression,
+		}
+	}
+	return cr
+}
+
+func exportTransport(host string, conf TransportConf) ConfigTransport {
+	ct := ConfigTransport{
+		Host:                  host,
+		MaxIdleConnsPerHost:   conf.MaxIdleConnsPerHost,
+		DialTimeout:           formatDuration(conf.DialTimeout),
+		KeepAlive:             formatDuration(conf.KeepAlive),
+		IdleConnTimeout:       formatDuration(conf.IdleConnTimeout),
+		ResponseHeaderTimeout: formatDuration(conf.ResponseHeaderTimeout),
+	}
+	if conf.TLSConfig != nil {
+		ct.TLS = ConfigRedacted
+	}
+	if conf.OutboundProxy != nil && conf.OutboundProxy.URL != "" {
+		ct.OutboundProxy = ConfigRedacted
+		if u, err := url.Parse(conf.OutboundProxy.URL); err == nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), ConfigRedacted)
+			}
+			ct.OutboundProxy = u.String()
+		}
+	}
+	return ct
+}
+
+// 当前生效的配置，各段按名称排序
+func (a *Admin) ExportConfig() ConfigDocument {
+	doc := ConfigDocument{Routes: []ConfigRoute{}, Pools: []ConfigPool{}}
+	if a.Router != nil {
+		for _, route := range a.Router.Routes() {
+			doc.Routes = append(doc.Routes, exportRoute(route))
+		}
+	}
+	sort.Slice(doc.Routes, func(i, j int) bool { return doc.Routes[i].Name < doc.Routes[j].Name })
+
+	a.mux.RLock()
+	names := make([]string, 0, len(a.pools))
+	for name := range a.pools {
+		names = append(names, name)
+	}
+	a.mux.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		_, lb, err := a.pool(name)
+		if err != nil {
+			continue
+		}
+		doc.Pools = append(doc.Pools, ConfigPool{Pool: name, Backends: exportPool(lb)})
+	}
+
+	if a.Transports != nil {
+		for host, conf := range a.Transports.Confs() {
+			doc.Transports = append(doc.Transports, exportTransport(host, conf))
+		}
+		sort.Slice(doc.Transports, func(i, j int) bool { return doc.Transports[i].Host < doc.Transports[j].Host })
+	}
+	return doc
+}
+
+func importMatchers(field string, list []ConfigMatcher) ([]Matcher, error) {
+	var matchers []Matcher
+	for _, cm := range list {
+		if cm.Name == "" {
+			return nil, fmt.Errorf("%s matcher name required", field)
+		}
+		m := Matcher{Name: cm.Name, Value: cm.Value, Absent: cm.Absent}
+		if cm.Regexp != "" {
+			re, err := regexp.Compile(cm.Regexp)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s regexp %q: %v", field, cm.Regexp, err)
+			}
+			m.Regexp = re
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// 把 cr 中的设置写入 route，保留 route 中不可导出的字段
+func importRoute(route *Route, cr ConfigRoute) error {
+	if !strings.HasPrefix(cr.PathPrefix, "/") {
+		return errors.New("path_prefix must start with /")
+	}
+	var err error
+	if route.Headers, err = importMatchers("header", cr.Headers); err != nil {
+		return err
+	}
+	if route.Query, err = importMatchers("query", cr.Query); err != nil {
+		return err
+	}
+	canonicalHeaderMatchers(route)
+	if route.Timeout, err = parseDuration("timeout", cr.Timeout); err != nil {
+		return err
+	}
+	if route.MaxTimeout, err = parseDuration("max_timeout", cr.MaxTimeout); err != nil {
+		return err
+	}
+	if route.BodyIdleTimeout, err = parseDuration("body_idle_timeout", cr.BodyIdleTimeout); err != nil {
+		return err
+	}
+	route.Transport = nil
+	if ct := cr.Transport; ct != nil {
+		t := &RouteTransport{MaxIdleConnsPerHost: ct.MaxIdleConnsPerHost, ForceAttemptHTTP2: ct.ForceAttemptHTTP2, DisableCompression: ct.DisableCompression}
+		if t.DialTimeout, err = parseDuration("transport dial_timeout", ct.DialTimeout); err != nil {
+			return err
+		}
+		if t.ResponseHeaderTimeout, err = parseDuration("transport response_header_timeout", ct.ResponseHeaderTimeout); err != nil {
+			return err
+		}
+		route.Transport = t
+	}
+	route.Name, route.Host, route.PathPrefix = cr.Name, cr.Host, cr.PathPrefix
+	route.Methods = cr.Methods
+	route.MaxBodyBytes = cr.MaxBodyBytes
+	route.RetryNonIdempotent = cr.RetryNonIdempotent
+	route.MaxResponseBytes = cr.MaxResponseBytes
+	route.ResponseLimit = cr.ResponseLimit
+	return nil
+}
+
+func importTransport(conf TransportConf, ct ConfigTransport) (TransportConf, error) {
+	var err error
+	conf.MaxIdleConnsPerHost = ct.MaxIdleConnsPerHost
+	if conf.DialTimeout, err = parseDuration("dial_timeout", ct.DialTimeout); err != nil {
+		return conf, err
+	}
+	if conf.KeepAlive, err = parseDuration("keep_alive", ct.KeepAlive); err != nil {
+		return conf, err
+	}
+	if conf.IdleConnTimeout, err = parseDuration("idle_conn_timeout", ct.IdleConnTimeout); err != nil {
+		return conf, err
+	}
+	if conf.ResponseHeaderTimeout, err = parseDuration("response_header_timeout", ct.ResponseHeaderTimeout); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+type poolImport struct {
+	lb     load_balance.ReplaceableBalance
+	conf   []string
+	change load_balance.ConfChange
+}
+
+// 校验整个文档后再应用：路由表整体替换，文档中的 pool 整体替换后端，文档中的后端连接参数逐个替换。
+// 路由表中不在文档里的路由被删除；不在文档里的 pool 与后端连接参数保持不变。校验失败时不做任何修改
+func (a *Admin) ImportConfig(doc ConfigDocument) (ConfigImportResult, error) {
+	result := ConfigImportResult{Pools: []load_balance.ConfChange{}}
+	if a.Router == nil && len(doc.Routes) > 0 {
+		return result, errors.New("admin has no router")
+	}
+
+	//路由
+	live := map[string]*Route{}
+	if a.Router != nil {
+		for _, route := range a.Router.Routes() {
+			live[route.Name] = route
+		}
+	}
+	routes := make([]*Route, 0, len(doc.Routes))
+	seen := map[string]bool{}
+	for _, cr := range doc.Routes {
+		if cr.Name == "" {
+			return result, errors.New("route name required")
+		}
+		if seen[cr.Name] {
+			return result, errors.New("duplicate route: " + cr.Name)
+		}
+		seen[cr.Name] = true
+		route := &Route{}
+		if old, ok := live[cr.Name]; ok {
+			copied := *old
+			route = &copied
+		} else if a.RouteHandler == nil {
+			return result, errors.New("no handler for new route: " + cr.Name)
+		} else {
+			h, err := a.RouteHandler(cr)
+			if err != nil {
+				return result, fmt.Errorf("route %s: %v", cr.Name, err)
+			}
+			route.Handler = h
+		}
+		if err := importRoute(route, cr); err != nil {
+			return result, fmt.Errorf("route %s: %v", cr.Name, err)
+		}
+		if old, ok := live[cr.Name]; !ok {
+			result.Routes.Added = append(result.Routes.Added, cr.Name)
+		} else if !reflect.DeepEqual(exportRoute(old), exportRoute(route)) {
+			result.Routes.Changed = append(result.Routes.Changed, cr.Name)
+		}
+		routes = append(routes, route)
+	}
+	for name := range live {
+		if !seen[name] {
+			result.Routes.Removed = append(result.Routes.Removed, name)
+		}
+	}
+
+	//后端
+	pools := []poolImport{}
+	seen = map[string]bool{}
+	for _, cp := range doc.Pools {
+		if seen[cp.Pool] {
+			return result, errors.New("duplicate pool: " + cp.Pool)
+		}
+		seen[cp.Pool] = true
+		_, lb, err := a.pool(cp.Pool)
+		if err != nil {
+			return result, err
+		}
+		rlb, ok := lb.(load_balance.ReplaceableBalance)
+		if !ok {
+			return result, errors.New("pool does not support replacing backends: " + cp.Pool)
+		}
+		//不支持权重的负载均衡只比较地址
+		_, weighted := lb.(load_balance.WeightedBalance)
+		before, after := []string{}, []string{}
+		for _, b := range exportPool(lb) {
+			before = append(before, backendEntry(b, weighted))
+		}
+		conf := []string{}
+		addrs := map[string]bool{}
+		for _, b := range cp.Backends {
+			if err := validateBackendAddr(b.Addr); err != nil {
+				return result, fmt.Errorf("pool %s backend %s: %v", cp.Pool, b.Addr, err)
+			}
+			if addrs[b.Addr] {
+				return result, fmt.Errorf("pool %s duplicate backend %s", cp.Pool, b.Addr)
+			}
+			addrs[b.Addr] = true
+			if b.Weight < 0 {
+				return result, fmt.Errorf("pool %s backend %s: weight must be positive", cp.Pool, b.Addr)
+			}
+			if b.Weight == 0 {
+				b.Weight = 50 //默认weight
+			}
+			conf = append(conf, b.Addr+","+strconv.Itoa(b.Weight))
+			after = append(after, backendEntry(b, weighted))
+		}
+		change := load_balance.DiffConf(before, after)
+		change.Pool = cp.Pool
+		pools = append(pools, poolImport{lb: rlb, conf: conf, change: change})
+	}
+
+	//后端连接参数
+	transports := map[string]TransportConf{}
+	if len(doc.Transports) > 0 && a.Transports == nil {
+		return result, errors.New("admin has no transport registry")
+	}
+	var liveTransports map[string]TransportConf
+	if a.Transports != nil {
+		liveTransports = a.Transports.Confs()
+	}
+	for _, ct := range doc.Transports {
+		if ct.Host == "" {
+			return result, errors.New("transport host required")
+		}
+		if _, ok := transports[ct.Host]; ok {
+			return result, errors.New("duplicate transport: " + ct.Host)
+		}
+		old, ok := liveTransports[ct.Host]
+		conf, err := importTransport(old, ct)
+		if err != nil {
+			return result, fmt.Errorf("transport %s: %v", ct.Host, err)
+		}
+		transports[ct.Host] = conf
+		if !ok {
+			result.Transports.Added = append(result.Transports.Added, ct.Host)
+		} else if !reflect.DeepEqual(exportTransport(ct.Host, old), exportTransport(ct.Host, conf)) {
+			result.Transports.Changed = append(result.Transports.Changed, ct.Host)
+		}
+	}
+
+	//应用
+	if a.Router != nil {
+		a.Router.SetRoutes(routes)
+		for _, name := range result.Routes.Removed {
+			a.closeRouteConf(name)
+		}
+	}
+	for _, p := range pools {
+		p.lb.SetServers(p.conf)
+		if !p.change.Empty() {
+			result.Pools = append(result.Pools, p.change)
+		}
+	}
+	for _, host := range append(result.Transports.Added, result.Transports.Changed...) {
+		a.Transports.Set(host, transports[host])
+	}
+	sort.Strings(result.Routes.Added)
+	sort.Strings(result.Routes.Removed)
+	sort.Strings(result.Routes.Changed)
+	sort.Strings(result.Transports.Added)
+	sort.Strings(result.Transports.Changed)
+	return result, nil
+}
+
+func backendEntry(b ConfigBackend, weighted bool) string {
+	if !weighted {
+		return b.Addr
+	}
+	return b.Addr + "," + strconv.Itoa(b.Weight)
+}
+
+func exportPool(lb load_balance.ManagedBalance) []ConfigBackend {
+	list := []ConfigBackend{}
+	for _, addr := range lb.Servers() {
+		b := ConfigBackend{Addr: addr}
+		if wlb, ok := lb.(load_balance.WeightedBalance); ok {
+			b.Weight, _ = wlb.Weight(addr)
+		}
+		list = append(list, b)
+	}
+	return list
+}
+
+func (a *Admin) exportConfig(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, a.ExportConfig())
+}
+
+// 导入成功时返回各配置段的变化，后端的变化同时记入变更日志
+func (a *Admin) importConfig(w http.ResponseWriter, req *http.Request) {
+	var doc ConfigDocument
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	result, err := a.ImportConfig(doc)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, change := range result.Pools {
+		a.record(req, change)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/middleware"
+	"context"
+	"sync"
+)
+
+type contextKey int
+
+const (
+	backendContextKey contextKey = iota
+	routeContextKey
+	upstreamStateContextKey
+	aggregateContextKey
+	routeLabelContextKey
+)
+
+// 路由表之外的中间件(如 RequestMetrics)放入上下文，路由表匹配后写入路由名
+type routeLabel struct {
+	name string
+	set  bool
+}
+
+func withRouteLabel(ctx context.Context) (context.Context, *routeLabel) {
+	l := &routeLabel{}
+	return context.WithValue(ctx, routeLabelContextKey, l), l
+}
+
+// 记录匹配到的路由名，没有匹配时为 UnmatchedRoute，请求未经过 RequestMetrics 时忽略
+func stampRoute(ctx context.Context, name string) {
+	if l, ok := ctx.Value(routeLabelContextKey).(*routeLabel); ok {
+		l.name, l.set = name, true
+	}
+}
+
+// 请求上下文中记录选中的后端地址
+func withBackend(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, backendContextKey, addr)
+}
+
+// 获取本次请求选中的后端地址
+func BackendFromContext(ctx context.Context) string {
+	addr, _ := ctx.Value(backendContextKey).(string)
+	return addr
+}
+
+func withRoute(ctx context.Context, route *Route) context.Context {
+	return context.WithValue(ctx, routeContextKey, route)
+}
+
+// 获取本次请求匹配到的路由
+func RouteFromContext(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeContextKey).(*Route)
+	return route
+}
+
+// 一次转发的上游状态，在 ServeHTTP 中创建，Director、Transport(重试) 与响应处理共享同一份，
+// 用于把重试后实际使用的后端、尝试次数带到响应阶段
+type upstreamState struct {
+	mux     sync.Mutex
+	backend string
+	attempt int
+	written bool //已经向客户端写出过响应(包括 1xx)
+}
+
+func withUpstreamState(ctx context.Context, backend string) context.Context {
+	return context.WithValue(ctx, upstreamStateContextKey, &upstreamState{backend: backend, attempt: 1})
+}
+
+// 记录一次新的尝试
+func setUpstreamAttempt(ctx context.Context, backend string, attempt int) {
+	if s, ok := ctx.Value(upstreamStateContextKey).(*upstreamState); ok {
+		s.mux.Lock()
+		s.backend = backend
+		s.attempt = attempt
+		s.mux.Unlock()
+	}
+}
+
+func upstreamStateFromContext(ctx context.Context) *upstreamState {
+	s, _ := ctx.Value(upstreamStateContextKey).(*upstreamState)
+	return s
+}
+
+func (s *upstreamState) markWritten() {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	s.written = true
+	s.mux.Unlock()
+}
+
+// 是否已经向客户端写出过响应，写出后不能再重试
+func responseWritten(ctx context.Context) bool {
+	s := upstreamStateFromContext(ctx)
+	if s == nil {
+		return false
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.written
+}
+
+// 获取最终使用的后端与尝试次数(从 1 开始)，不在转发过程中时返回空
+func UpstreamFromContext(ctx context.Context) (backend string, attempt int) {
+	s, ok := ctx.Value(upstreamStateContextKey).(*upstreamState)
+	if !ok {
+		return "", 0
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.backend, s.attempt
+}
+
+// 客户端真实 IP，由 middleware.RealIP 解析，限流、ACL、负载均衡与日志使用同一结果
+func ClientIPFromContext(ctx context.Context) string {
+	return middleware.ClientIPFromContext(ctx)
+}
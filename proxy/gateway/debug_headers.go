@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/middleware"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// 调试用的响应头，标注实际处理请求的后端、匹配的路由以及重试后的尝试次数
+type DebugHeaders struct {
+	Enabled        bool
+	UpstreamHeader string   //默认 X-Upstream
+	RouteHeader    string   //默认 X-Route
+	AttemptHeader  string   //默认 X-Upstream-Attempt，仅在发生重试时输出
+	TriggerHeader  string   //不为空时只对携带该请求头的请求输出
+	AllowedIPs     []string //不为空时只对这些客户端 IP/CIDR 输出
+}
+
+type debugHeaders struct {
+	conf    DebugHeaders
+	allowed []*net.IPNet
+}
+
+func newDebugHeaders(conf DebugHeaders) (*debugHeaders, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	if conf.UpstreamHeader == "" {
+		conf.UpstreamHeader = "X-Upstream"
+	}
+	if conf.RouteHeader == "" {
+		conf.RouteHeader = "X-Route"
+	}
+	if conf.AttemptHeader == "" {
+		conf.AttemptHeader = "X-Upstream-Attempt"
+	}
+	d := &debugHeaders{conf: conf}
+	for _, s := range conf.AllowedIPs {
+		ipNet, err := middleware.ParseCIDROrIP(s)
+		if err != nil {
+			return nil, err
+		}
+		d.allowed = append(d.allowed, ipNet)
+	}
+	return d, nil
+}
+
+func (d *debugHeaders) authorized(req *http.Request) bool {
+	if d.conf.TriggerHeader != "" && req.Header.Get(d.conf.TriggerHeader) == "" {
+		return false
+	}
+	if len(d.allowed) == 0 {
+		return true
+	}
+	ip := net.ParseIP(middleware.ClientIP(req))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range d.allowed {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *debugHeaders) stamp(h http.Header, req *http.Request) {
+	if d == nil || req == nil || !d.authorized(req) {
+		return
+	}
+	backend, attempt := UpstreamFromContext(req.Context())
+	if backend != "" {
+		h.Set(d.conf.UpstreamHeader, backend)
+	}
+	if route := RouteFromContext(req.Context()); route != nil {
+		h.Set(d.conf.RouteHeader, route.Name)
+	}
+	if attempt > 1 {
+		h.Set(d.conf.AttemptHeader, strconv.Itoa(attempt))
+	}
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	newRouter := func(opts Options) *Router {
+		lb := &load_balance.RoundRobinBalance{}
+		lb.Add(upstream.URL)
+		r := NewRouter()
+		r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: NewProxy(lb, opts)})
+		return r
+	}
+
+	//默认关闭
+	rec := serve(newRouter(Options{}), "GET", "/api", "10.0.0.1:1")
+	if rec.Header().Get("X-Upstream") != "" || rec.Header().Get("X-Route") != "" {
+		t.Fatalf("debug headers leaked by default: %v", rec.Header())
+	}
+
+	rec = serve(newRouter(Options{Debug: DebugHeaders{Enabled: true}}), "GET", "/api", "10.0.0.1:1")
+	if rec.Header().Get("X-Upstream") != upstream.URL || rec.Header().Get("X-Route") != "api" || rec.Header().Get("X-Upstream-Attempt") != "" {
+		t.Fatalf("unexpected debug headers: %v", rec.Header())
+	}
+
+	//需要调试请求头且客户端 IP 在白名单内
+	r := newRouter(Options{Debug: DebugHeaders{Enabled: true, TriggerHeader: "X-Debug", AllowedIPs: []string{"10.0.0.0/8"}}})
+	for _, c := range []struct {
+		remote  string
+		trigger bool
+		want    bool
+	}{
+		{"10.0.0.1:1", true, true},
+		{"10.0.0.1:1", false, false},
+		{"192.168.0.1:1", true, false},
+	} {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.RemoteAddr = c.remote
+		if c.trigger {
+			req.Header.Set("X-Debug", "1")
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if got := rec.Header().Get("X-Upstream") != ""; got != c.want {
+			t.Fatalf("%s trigger=%v: got headers %v", c.remote, c.trigger, rec.Header())
+		}
+	}
+}
+
+func TestDebugHeadersRetry(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	lb.Add(refusedAddr(t)) //首次请求必然失败
+	p := NewProxy(lb, Options{MaxRetries: 1, Debug: DebugHeaders{Enabled: true}})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Upstream") != upstream.URL || rec.Header().Get("X-Upstream-Attempt") != "2" {
+		t.Fatalf("retry not reflected: code %d headers %v", rec.Code, rec.Header())
+	}
+
+	//所有尝试都失败时，错误响应标注最后一次尝试的后端
+	refused := refusedAddr(t)
+	lb = &load_balance.RoundRobinBalance{}
+	lb.Add(refusedAddr(t))
+	lb.Add(refused)
+	p = NewProxy(lb, Options{MaxRetries: 1, Debug: DebugHeaders{Enabled: true}})
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusBadGateway || rec.Header().Get("X-Upstream-Attempt") != "2" || rec.Header().Get("X-Upstream") == refused {
+		t.Fatalf("error response headers %d %v", rec.Code, rec.Header())
+	}
+}
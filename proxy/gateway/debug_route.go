@@ -0,0 +1,254 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/middleware"
+	"GO_GATEWAY/proxy/rate_limiter"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// 调试接口的模拟请求
+type RouteDebugRequest struct {
+	Method   string            `json:"method"` //默认 GET
+	Host     string            `json:"host"`
+	Path     string            `json:"path"` //可带查询参数
+	Headers  map[string]string `json:"headers"`
+	ClientIP string            `json:"client_ip"` //作为连接的对端地址
+}
+
+// 模拟请求的处理结果：匹配的路由、中断请求的中间件、改写后的上游地址与后端选择
+type RouteDebugResult struct {
+	Route       *adminRoute       `json:"route"`
+	Status      int               `json:"status,omitempty"` //网关直接返回的状态码，为空表示会转发到后端
+	Reason      string            `json:"reason,omitempty"`
+	Middlewares []debugMiddleware `json:"middlewares"`
+	Upstream    *debugUpstream    `json:"upstream,omitempty"`
+	Backend     *debugBackend     `json:"backend,omitempty"`
+}
+
+type debugMiddleware struct {
+	Name   string `json:"name"`
+	Scope  string `json:"scope"`  //global 或 route
+	Result string `json:"result"` //pass、short_circuit 或 not_reached
+	Status int    `json:"status,omitempty"`
+}
+
+type debugUpstream struct {
+	URL     string      `json:"url"`
+	Host    string      `json:"host"`
+	Headers http.Header `json:"headers"`
+}
+
+type debugBackend struct {
+	load_balance.Decision
+	Sticky      bool          `json:"sticky,omitempty"`       //由会话保持 cookie 决定
+	Limited     bool          `json:"limited,omitempty"`      //选中的后端已被限流
+	LimitAction string        `json:"limit_action,omitempty"` //被限流时的处理：reselect 或 shed
+	Servers     []debugServer `json:"servers,omitempty"`
+}
+
+type debugServer struct {
+	Addr     string `json:"addr"`
+	Weight   int    `json:"weight,omitempty"`
+	Inflight int64  `json:"inflight"`
+	Limited  bool   `json:"limited,omitempty"`
+}
+
+// 只记录状态码与响应头的 ResponseWriter
+type debugRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *debugRecorder) Header() http.Header { return r.header }
+
+func (r *debugRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+}
+
+func (r *debugRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+func (d RouteDebugRequest) request() (*http.Request, error) {
+	method := d.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !strings.HasPrefix(d.Path, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	host := d.Host
+	if host == "" {
+		host = "localhost"
+	}
+	req, err := http.NewRequest(method, "http://"+host+d.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.Headers {
+		req.Header.Set(k, v)
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	if d.ClientIP != "" {
+		if net.ParseIP(d.ClientIP) == nil {
+			return nil, errors.New("invalid client_ip: " + d.ClientIP)
+		}
+		req.RemoteAddr = net.JoinHostPort(d.ClientIP, "0")
+	}
+	return req.WithContext(middleware.WithDryRun(req.Context())), nil
+}
+
+// 中间件的函数名，如 middleware.(*ACL).Handler
+func middlewareName(m Middleware) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(m).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// 用真实的匹配逻辑与中间件处理模拟请求，请求处于 dry run 模式，不转发到后端
+func (r *Router) Explain(req *http.Request) RouteDebugResult {
+	result := RouteDebugResult{Middlewares: []debugMiddleware{}}
+	route, _ := r.match(req)
+	r.mux.RLock()
+	chain, defaultMethods := r.chain, r.Methods
+	r.mux.RUnlock()
+	if route == nil {
+		result.Status, result.Reason = http.StatusNotFound, "no route matched"
+		return result
+	}
+	result.Route = &adminRoute{Name: route.Name, Host: route.Host, PathPrefix: route.PathPrefix, MaxBodyBytes: route.MaxBodyBytes}
+	methods := route.Methods
+	if methods == nil {
+		methods = defaultMethods
+	}
+	if methods != nil && !methodAllowed(req, methods) {
+		result.Status, result.Reason = http.StatusMethodNotAllowed, "method not allowed, allow: "+strings.Join(methods, ", ")
+		return result
+	}
+
+	//在每个中间件前记录执行到的位置，没有执行到下一个位置的中间件即为中断请求的中间件
+	middlewares := chain.Use(route.Middlewares...).middlewares
+	reached := -1
+	var final *http.Request
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reached = len(middlewares)
+		final = req
+	})
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		i, next := i, middlewares[i](h)
+		h = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			reached = i
+			next.ServeHTTP(w, req)
+		})
+	}
+	rec := &debugRecorder{header: http.Header{}}
+	h.ServeHTTP(rec, req.WithContext(withRoute(req.Context(), route)))
+	for i, m := range middlewares {
+		dm := debugMiddleware{Name: middlewareName(m), Scope: "global", Result: "pass"}
+		if i >= chain.Len() {
+			dm.Scope = "route"
+		}
+		switch {
+		case i == reached:
+			dm.Result, dm.Status = "short_circuit", rec.status
+			if dm.Status == 0 {
+				dm.Status = http.StatusOK
+			}
+			result.Status, result.Reason = dm.Status, "short-circuited by "+dm.Name
+		case i > reached:
+			dm.Result = "not_reached"
+		}
+		result.Middlewares = append(result.Middlewares, dm)
+	}
+	if final == nil {
+		return result
+	}
+	p, ok := route.Handler.(*Proxy)
+	if !ok {
+		result.Reason = "route handler is not a proxy"
+		return result
+	}
+	result.Upstream, result.Backend = p.explain(final)
+	return result
+}
+
+// 预测后端选择与改写后的上游请求，不改变负载均衡、限流状态
+func (p *Proxy) explain(req *http.Request) (*debugUpstream, *debugBackend) {
+	backend := &debugBackend{}
+	if p.sticky != nil {
+		if addr := p.sticky.backend(req, p.lb); addr != "" {
+			backend.Decision = load_balance.Decision{Addr: addr, Reason: "sticky session cookie"}
+			backend.Sticky = true
+		}
+	}
+	if !backend.Sticky {
+		if elb, ok := p.lb.(load_balance.ExplainingBalance); ok {
+			var err error
+			if backend.Decision, err = elb.Explain(middleware.ClientIP(req)); err != nil {
+				backend.Reason = err.Error()
+			}
+		} else {
+			backend.Reason = "balancer cannot predict its choice"
+		}
+	}
+	limiter := p.opts.BackendLimiter
+	if mlb, ok := p.lb.(load_balance.ManagedBalance); ok {
+		wlb, weighted := p.lb.(load_balance.WeightedBalance)
+		for _, addr := range mlb.Servers() {
+			s := debugServer{Addr: addr, Inflight: backendInflight.Get(addr)}
+			if weighted {
+				s.Weight, _ = wlb.Weight(addr)
+			}
+			s.Limited = limiter != nil && limiter.Limited(addr)
+			backend.Servers = append(backend.Servers, s)
+		}
+	}
+	addr := backend.Addr
+	if addr == "" {
+		return nil, backend
+	}
+	if limiter != nil && limiter.Limited(addr) {
+		backend.Limited = true
+		backend.LimitAction = "shed"
+		if _, ok := p.lb.(load_balance.ExcludingBalance); ok && limiter.Mode == rate_limiter.BackendLimitReselect {
+			backend.LimitAction = "reselect"
+		}
+	}
+	out := req.Clone(withBackend(req.Context(), addr))
+	p.director(out)
+	return &debugUpstream{URL: out.URL.String(), Host: out.Host, Headers: out.Header}, backend
+}
+
+func (a *Admin) debugRoute(w http.ResponseWriter, req *http.Request) {
+	var body RouteDebugRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if a.Router == nil {
+		writeJSONError(w, http.StatusNotFound, errors.New("no router"))
+		return
+	}
+	debugReq, err := body.request()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.Router.Explain(debugReq))
+}
@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/middleware"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminDebugRoute(t *testing.T) {
+	backends := map[string]string{}
+	rr := &load_balance.RoundRobinBalance{}
+	hash := load_balance.NewConsistentHashBanlance(10, nil)
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name + " " + req.URL.RequestURI() + " " + req.Header.Get("X-Debug")))
+		}))
+		defer srv.Close()
+		backends[srv.URL] = name
+		hash.Add(srv.URL)
+		if name != "c" {
+			rr.Add(srv.URL)
+		}
+	}
+	acl, _ := middleware.NewACL("global", middleware.ACLAllow, []middleware.ACLRule{{Action: middleware.ACLDeny, CIDR: "6.6.0.0/16"}})
+	limiter := middleware.NewRateLimiter(middleware.RateLimitConf{Rate: 0.001, Burst: 1})
+	r := NewRouter()
+	r.Use(acl.Handler)
+	r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: NewProxy(rr, Options{}), Director: func(req *http.Request) {
+		req.Header.Set("X-Debug", "1")
+	}})
+	r.Handle(&Route{Name: "hash", PathPrefix: "/hash", Handler: NewProxy(hash, Options{})})
+	r.Handle(&Route{Name: "limited", PathPrefix: "/limited", Handler: NewProxy(rr, Options{}), Middlewares: []Middleware{limiter.Handler}})
+	r.Handle(&Route{Name: "static", PathPrefix: "/static", Methods: []string{"GET"}, Handler: okHandler})
+	h := NewAdmin(r).Handler()
+
+	debug := func(body string) RouteDebugResult {
+		rec := adminDo(h, "POST", "/debug/route", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("debug got %d %s", rec.Code, rec.Body)
+		}
+		var result RouteDebugResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	//轮询：调试不推进轮询位置，预测结果与随后的真实请求一致
+	for i := 0; i < 3; i++ {
+		result := debug(`{"path":"/api/users?id=1","client_ip":"1.1.1.1"}`)
+		if result.Route == nil || result.Route.Name != "api" || result.Status != 0 || result.Backend == nil || result.Upstream == nil {
+			t.Fatalf("api debug: %+v", result)
+		}
+		addr := result.Backend.Addr
+		if result.Backend.Algorithm != "round_robin" || len(result.Backend.Servers) != 2 {
+			t.Fatalf("api backend: %+v", result.Backend)
+		}
+		if result.Upstream.URL != addr+"/api/users?id=1" || result.Upstream.Headers.Get("X-Debug") != "1" {
+			t.Fatalf("api upstream: %+v", result.Upstream)
+		}
+		rec := serve(r, "GET", "/api/users?id=1", "1.1.1.1:1")
+		if want := backends[addr] + " /api/users?id=1 1"; rec.Body.String() != want {
+			t.Fatalf("predicted %q, proxied %q", want, rec.Body.String())
+		}
+	}
+
+	//一致性哈希：按客户端 IP 选择
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "10.0.0.7"} {
+		result := debug(`{"path":"/hash/x","client_ip":"` + ip + `"}`)
+		if result.Backend.KeyHash == nil || result.Backend.NodeHash == nil {
+			t.Fatalf("hash debug: %+v", result.Backend)
+		}
+		rec := serve(r, "GET", "/hash/x", ip+":1")
+		if !strings.HasPrefix(rec.Body.String(), backends[result.Backend.Addr]+" ") {
+			t.Fatalf("%s: predicted %s, proxied %q", ip, result.Backend.Addr, rec.Body.String())
+		}
+	}
+
+	//全局 ACL 拒绝
+	result := debug(`{"path":"/api/users","client_ip":"6.6.6.6"}`)
+	if result.Status != http.StatusForbidden || result.Backend != nil || len(result.Middlewares) != 1 ||
+		result.Middlewares[0].Result != "short_circuit" || !strings.Contains(result.Middlewares[0].Name, "ACL") {
+		t.Fatalf("acl debug: %+v", result)
+	}
+	if rec := serve(r, "GET", "/api/users", "6.6.6.6:1"); rec.Code != result.Status {
+		t.Fatalf("acl proxied %d", rec.Code)
+	}
+
+	//路由限流：调试不消耗令牌
+	for i := 0; i < 2; i++ {
+		if result := debug(`{"path":"/limited","client_ip":"4.4.4.4"}`); result.Status != 0 || result.Backend == nil {
+			t.Fatalf("limited debug before request: %+v", result)
+		}
+	}
+	if rec := serve(r, "GET", "/limited", "4.4.4.4:1"); rec.Code != http.StatusOK {
+		t.Fatalf("limited proxied %d", rec.Code)
+	}
+	result = debug(`{"path":"/limited","client_ip":"4.4.4.4"}`)
+	if result.Status != http.StatusTooManyRequests || result.Middlewares[1].Scope != "route" || result.Middlewares[1].Result != "short_circuit" {
+		t.Fatalf("limited debug after request: %+v", result)
+	}
+	if rec := serve(r, "GET", "/limited", "4.4.4.4:1"); rec.Code != result.Status {
+		t.Fatalf("limited proxied %d", rec.Code)
+	}
+
+	//未匹配与方法不允许
+	if result := debug(`{"path":"/nothing"}`); result.Route != nil || result.Status != http.StatusNotFound {
+		t.Fatalf("unmatched debug: %+v", result)
+	}
+	if result := debug(`{"method":"POST","path":"/static/a.js"}`); result.Status != http.StatusMethodNotAllowed || serve(r, "POST", "/static/a.js", "1.1.1.1:1").Code != result.Status {
+		t.Fatalf("method debug: %+v", result)
+	}
+	if rec := adminDo(h, "POST", "/debug/route", `{"path":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid path got %d", rec.Code)
+	}
+}
+
+func TestAdminDebugRouteNoSideEffects(t *testing.T) {
+	keys := middleware.NewMemoryKeyStore([]middleware.APIKey{{Key: "k1", Name: "debug", Rate: 0.001, Burst: 1}})
+	auth := middleware.NewAPIKeyAuth(keys, middleware.APIKeyConf{})
+	acl, _ := middleware.NewACL("debug-acl", middleware.ACLAllow, []middleware.ACLRule{{Action: middleware.ACLDeny, CIDR: "6.6.0.0/16"}})
+	r := NewRouter()
+	r.Use(RequestMetrics, acl.Handler)
+	r.Handle(&Route{Name: "debug-keyed", PathPrefix: "/keyed", Handler: okHandler, Middlewares: []Middleware{auth.Handler}})
+	h := NewAdmin(r).Handler()
+
+	requests, denied := routeRequests.Get("debug-keyed"), metrics.GetSnapshot().Counters["gateway_acl_denied_total"]["debug-acl"]
+	for i := 0; i < 3; i++ {
+		adminDo(h, "POST", "/debug/route", `{"path":"/keyed","headers":{"X-API-Key":"k1"}}`)
+		adminDo(h, "POST", "/debug/route", `{"path":"/keyed","client_ip":"6.6.6.6"}`)
+	}
+	if got := routeRequests.Get("debug-keyed"); got != requests {
+		t.Fatalf("route requests moved from %d to %d", requests, got)
+	}
+	if got := metrics.GetSnapshot().Counters["gateway_acl_denied_total"]["debug-acl"]; got != denied {
+		t.Fatalf("acl denied moved from %d to %d", denied, got)
+	}
+	//API Key 的令牌没有被调试消耗
+	req := httptest.NewRequest("GET", "/keyed", nil)
+	req.Header.Set("X-API-Key", "k1")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || routeRequests.Get("debug-keyed") != requests+1 {
+		t.Fatalf("real request got %d", rec.Code)
+	}
+}
@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	DefaultDialTimeout   = 30 * time.Second
+	DefaultDialKeepAlive = 30 * time.Second
+	DefaultFallbackDelay = 300 * time.Millisecond
+
+	//建连失败的阶段
+	DialPhaseDNS     = "dns"
+	DialPhaseConnect = "connect"
+	DialPhaseTLS     = "tls"
+)
+
+var (
+	dialPhaseLatency = metrics.NewHistogramVec("gateway_dial_phase_duration_ms", "建连各阶段(dns/connect/tls)每次尝试的耗时", "phase", metrics.LatencyBuckets)
+	dialErrors       = metrics.NewCounterVec("gateway_dial_errors_total", "按失败阶段统计的建连失败次数", "phase")
+
+	errNoIPv4Address = errors.New("no ipv4 address")
+)
+
+// 建连失败时的错误，记录失败的阶段
+type DialError struct {
+	Phase string
+	Addr  string
+	Err   error
+}
+
+func (e *DialError) Error() string { return "dial " + e.Addr + " (" + e.Phase + "): " + e.Err.Error() }
+
+func (e *DialError) Unwrap() error { return e.Err }
+
+type DialerConf struct {
+	Timeout       time.Duration            //单次建连超时，默认 DefaultDialTimeout
+	KeepAlive     time.Duration            //默认 DefaultDialKeepAlive
+	FallbackDelay time.Duration            //Happy Eyeballs 中首选地址族失败前等待多久开始尝试另一族，默认 DefaultFallbackDelay，负数表示不并行尝试
+	DisableIPv6   bool                     //只使用 A 记录，用于 AAAA 记录不可用的网络
+	Resolver      load_balance.DnsResolver //默认使用系统解析
+	Socket        DialSocketConf           //keepalive 探测参数、TCP Fast Open 等 socket 选项
+}
+
+// 分阶段统计耗时的拨号器，替代 http.Transport 中的 net.Dialer
+type Dialer struct {
+	conf   DialerConf
+	dialer *net.Dialer
+}
+
+func NewDialer(conf DialerConf) *Dialer {
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultDialTimeout
+	}
+	if conf.KeepAlive == 0 {
+		conf.KeepAlive = DefaultDialKeepAlive
+	}
+	if conf.FallbackDelay == 0 {
+		conf.FallbackDelay = DefaultFallbackDelay
+	}
+	if conf.Resolver == nil {
+		conf.Resolver = net.DefaultResolver
+	}
+	control := dialControl(conf.Socket, int(conf.KeepAlive.Seconds()))
+	keepAlive := conf.KeepAlive
+	if control != nil && conf.Socket.keepAliveSet() {
+		//keepalive 由 Control 设置，避免 net.Dialer 建连后按 KeepAlive 覆盖探测参数
+		keepAlive = -1
+	}
+	return &Dialer{conf: conf, dialer: &net.Dialer{Timeout: conf.Timeout, KeepAlive: keepAlive, Control: control}}
+}
+
+func sinceMs(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+func (d *Dialer) fail(phase, addr string, err error) error {
+	dialErrors.Inc(phase)
+	return &DialError{Phase: phase, Addr: addr, Err: err}
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, d.fail(DialPhaseDNS, addr, err)
+	}
+	var ips []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IPAddr{{IP: ip}}
+	} else {
+		start := time.Now()
+		ips, err = d.conf.Resolver.LookupIPAddr(ctx, host)
+		dialPhaseLatency.Observe(DialPhaseDNS, sinceMs(start))
+		if err != nil {
+			return nil, d.fail(DialPhaseDNS, addr, err)
+		}
+	}
+	if d.conf.DisableIPv6 || network == "tcp4" {
+		v4 := ips[:0:0]
+		for _, ip := range ips {
+			if ip.IP.To4() != nil {
+				v4 = append(v4, ip)
+			}
+		}
+		if len(v4) == 0 {
+			return nil, d.fail(DialPhaseDNS, addr, errNoIPv4Address)
+		}
+		ips = v4
+	}
+	if len(ips) == 0 {
+		return nil, d.fail(DialPhaseDNS, addr, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true})
+	}
+	conn, err := d.dialParallel(ctx, network, ips, port)
+	if err != nil {
+		return nil, d.fail(DialPhaseConnect, addr, err)
+	}
+	return conn, nil
+}
+
+// 返回带 TLS 握手计时的拨号函数，用作 http.Transport.DialTLSContext，config 为空时使用默认配置
+func (d *Dialer) DialTLSContext(config *tls.Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{}
+		if config != nil {
+			cfg = config.Clone()
+		}
+		if cfg.ServerName == "" {
+			host, _, _ := net.SplitHostPort(addr)
+			cfg.ServerName = host
+		}
+		tlsConn := tls.Client(conn, cfg)
+		start := time.Now()
+		err = tlsConn.HandshakeContext(ctx)
+		dialPhaseLatency.Observe(DialPhaseTLS, sinceMs(start))
+		if err != nil {
+			conn.Close()
+			return nil, d.fail(DialPhaseTLS, addr, err)
+		}
+		return tlsConn, nil
+	}
+}
+
+// Happy Eyeballs：先尝试与第一个地址同族的地址，FallbackDelay 后或首选族全部失败时并行尝试另一族
+func (d *Dialer) dialParallel(ctx context.Context, network string, ips []net.IPAddr, port string) (net.Conn, error) {
+	var primaries, fallbacks []net.IPAddr
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == (ips[0].IP.To4() != nil) {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(fallbacks) == 0 || d.conf.FallbackDelay < 0 {
+		return d.dialSerial(ctx, network, append(primaries, fallbacks...), port)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, 2)
+	start := func(ips []net.IPAddr) {
+		go func() {
+			conn, err := d.dialSerial(ctx, network, ips, port)
+			results <- result{conn, err}
+		}()
+	}
+	start(primaries)
+	timer := time.NewTimer(d.conf.FallbackDelay)
+	defer timer.Stop()
+	pending, fallbackStarted := 1, false
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				start(fallbacks)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					//关闭落后一方建立的连接
+					go func() {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				start(fallbacks)
+				continue
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// 依次尝试各地址，每次尝试单独计时
+func (d *Dialer) dialSerial(ctx context.Context, network string, ips []net.IPAddr, port string) (net.Conn, error) {
+	var lastErr error
+	for _, ip := range ips {
+		start := time.Now()
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		dialPhaseLatency.Observe(DialPhaseConnect, sinceMs(start))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := []net.IPAddr{}
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestDialerPhaseTimings(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	d := NewDialer(DialerConf{Resolver: stubResolver{"backend.test": {"127.0.0.1"}}})
+	client := &http.Client{Transport: &http.Transport{
+		DialContext:    d.DialContext,
+		DialTLSContext: d.DialTLSContext(&tls.Config{RootCAs: pool, ServerName: "example.com"}),
+	}}
+	before := dialPhaseLatency.Snapshot()
+	resp, err := client.Get("https://backend.test:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	after := dialPhaseLatency.Snapshot()
+	for _, phase := range []string{DialPhaseDNS, DialPhaseConnect, DialPhaseTLS} {
+		if after[phase].Count != before[phase].Count+1 {
+			t.Errorf("%s timing not recorded: %d -> %d", phase, before[phase].Count, after[phase].Count)
+		}
+	}
+
+	//证书校验失败记为 tls 阶段
+	client = &http.Client{Transport: &http.Transport{
+		DialContext:    d.DialContext,
+		DialTLSContext: d.DialTLSContext(&tls.Config{ServerName: "example.com"}),
+	}}
+	_, err = client.Get("https://backend.test:" + port + "/")
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Phase != DialPhaseTLS {
+		t.Fatalf("got %v", err)
+	}
+	if _, category := ClassifyError(err); category != CategoryTLSFailure {
+		t.Fatalf("tls failure classified as %s", category)
+	}
+}
+
+func TestDialerErrorPhases(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	resolver := stubResolver{"v6only.test": {"::1"}, "dual.test": {"::1", "127.0.0.1"}}
+
+	d := NewDialer(DialerConf{Resolver: resolver})
+	_, err = d.DialContext(context.Background(), "tcp", "missing.test:"+port)
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Phase != DialPhaseDNS {
+		t.Fatalf("resolve failure: %v", err)
+	}
+	if _, category := ClassifyError(&url.Error{Op: "Get", URL: "http://missing.test/", Err: err}); category != CategoryDNSFailure {
+		t.Fatalf("dns failure classified as %s", category)
+	}
+	_, err = d.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	if !errors.As(err, &dialErr) || dialErr.Phase != DialPhaseConnect {
+		t.Fatalf("connect failure: %v", err)
+	}
+
+	//禁用 IPv6 后只有 AAAA 记录的域名无法建连
+	d = NewDialer(DialerConf{Resolver: resolver, DisableIPv6: true})
+	if _, err = d.DialContext(context.Background(), "tcp", "v6only.test:"+port); !errors.Is(err, errNoIPv4Address) {
+		t.Fatalf("ipv6 not disabled: %v", err)
+	}
+	c, err := d.DialContext(context.Background(), "tcp", "dual.test:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	//首选的 IPv6 地址不可用时立即尝试 IPv4，不必等待 FallbackDelay
+	d = NewDialer(DialerConf{Resolver: resolver, FallbackDelay: 10 * time.Second})
+	start := time.Now()
+	c, err = d.DialContext(context.Background(), "tcp", "dual.test:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("fallback waited %v", elapsed)
+	}
+}
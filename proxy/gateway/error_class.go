@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const DefaultRetryAfter = time.Second
+
+// 客户端在响应前断开，沿用 nginx 的 499，只记录不写出
+const StatusClientClosedRequest = 499
+
+// 熔断器打开时返回该错误(或包装它)，网关按 503 处理
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+var proxyErrors = metrics.NewCounterVec("gateway_proxy_errors_total", "按错误类别统计的转发失败次数", "class")
+
+// 把转发错误归类为响应状态码与对外展示的类别：
+// 连接被拒/重置、DNS 解析失败、TLS 握手失败及其他上游错误为 502，无可用后端、被限流、熔断为 503，超时为 504，客户端取消为 499
+func ClassifyError(err error) (int, string) {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var dialErr *DialError
+	switch {
+	case err == nil:
+		return http.StatusBadGateway, CategoryUpstreamError
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest, CategoryClientCanceled
+	case errors.Is(err, load_balance.ErrNoBackends), errors.Is(err, errBackendLimited):
+		return http.StatusServiceUnavailable, CategoryNoBackends
+	case errors.Is(err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable, CategoryCircuitOpen
+	case errors.As(err, &dnsErr), errors.As(err, &dialErr) && dialErr.Phase == DialPhaseDNS:
+		//DNS 超时同样按解析失败处理
+		return http.StatusBadGateway, CategoryDNSFailure
+	case errors.As(err, &dialErr) && dialErr.Phase == DialPhaseTLS && !(errors.As(err, &netErr) && netErr.Timeout()):
+		return http.StatusBadGateway, CategoryTLSFailure
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errBodyIdleTimeout), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, CategoryUpstreamTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return http.StatusBadGateway, CategoryConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return http.StatusBadGateway, CategoryConnectionReset
+	}
+	return http.StatusBadGateway, CategoryUpstreamError
+}
+
+func errorCategory(err error) string {
+	_, category := ClassifyError(err)
+	return category
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://backend/", Err: err}
+	}
+	cases := []struct {
+		name     string
+		err      error
+		status   int
+		category string
+	}{
+		{"refused", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}), http.StatusBadGateway, CategoryConnectionRefused},
+		{"reset", wrap(&net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}), http.StatusBadGateway, CategoryConnectionReset},
+		{"dns", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "backend", IsNotFound: true}}), http.StatusBadGateway, CategoryDNSFailure},
+		{"dns timeout", wrap(&net.DNSError{Err: "timeout", Name: "backend", IsTimeout: true}), http.StatusBadGateway, CategoryDNSFailure},
+		{"tls record", wrap(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), http.StatusBadGateway, CategoryUpstreamError},
+		{"tls unknown ca", wrap(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), http.StatusBadGateway, CategoryUpstreamError},
+		{"tls handshake timeout", wrap(&net.OpError{Op: "remote error", Err: timeoutError{}}), http.StatusGatewayTimeout, CategoryUpstreamTimeout},
+		{"deadline", wrap(fmt.Errorf("round trip: %w", context.DeadlineExceeded)), http.StatusGatewayTimeout, CategoryUpstreamTimeout},
+		{"canceled", wrap(context.Canceled), StatusClientClosedRequest, CategoryClientCanceled},
+		{"no backends", fmt.Errorf("select: %w", load_balance.ErrNoBackends), http.StatusServiceUnavailable, CategoryNoBackends},
+		{"backend limited", errBackendLimited, http.StatusServiceUnavailable, CategoryNoBackends},
+		{"circuit open", fmt.Errorf("backend a: %w", ErrCircuitOpen), http.StatusServiceUnavailable, CategoryCircuitOpen},
+		{"other", wrap(fmt.Errorf("malformed HTTP response")), http.StatusBadGateway, CategoryUpstreamError},
+	}
+	for _, c := range cases {
+		status, category := ClassifyError(c.err)
+		if status != c.status || category != c.category {
+			t.Errorf("%s: got %d %s want %d %s", c.name, status, category, c.status, c.category)
+		}
+	}
+}
+
+func TestProxyErrorStatus(t *testing.T) {
+	//没有后端：503 并带 Retry-After
+	before := proxyErrors.Get(CategoryNoBackends)
+	p := NewProxy(&load_balance.RoundRobinBalance{}, Options{RetryAfter: 3 * time.Second})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3" {
+		t.Fatalf("no backends: %d %v", rec.Code, rec.Header())
+	}
+	if proxyErrors.Get(CategoryNoBackends) != before+1 {
+		t.Fatal("no backends not counted")
+	}
+
+	//客户端取消：只计数不写出
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	p = NewProxy(lb, Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	before = proxyErrors.Get(CategoryClientCanceled)
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if rec.Body.Len() != 0 || rec.Flushed {
+		t.Fatalf("response written for canceled client: %d %q", rec.Code, rec.Body)
+	}
+	if proxyErrors.Get(CategoryClientCanceled) != before+1 {
+		t.Fatal("client cancel not counted")
+	}
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// 小于该大小的错误响应走缓冲路径，其余流式处理
+const DefaultErrorBufferThreshold = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// 为 4xx/5xx 响应体追加前缀。已知长度且小于阈值时读入池化的缓冲区，
+// 否则包装为流式 reader，先输出前缀再输出原始响应体，不会整体读入内存
+func DecorateErrorResponse(resp *http.Response, prefix string, threshold int64) error {
+	if resp.StatusCode < http.StatusBadRequest || prefix == "" {
+		return nil
+	}
+	//HEAD 响应不能带响应体
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return nil
+	}
+	//声明了 trailer 的响应不改写，改写后的 Content-Length 会让 trailer 无法发送
+	if len(resp.Trailer) > 0 {
+		return nil
+	}
+	if threshold <= 0 {
+		threshold = DefaultErrorBufferThreshold
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < threshold {
+		buf := bufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		buf.WriteString(prefix)
+		_, err := io.Copy(buf, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			bufferPool.Put(buf)
+			return err
+		}
+		resp.Body = &pooledBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}
+		resp.ContentLength = int64(buf.Len())
+		resp.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+		return nil
+	}
+	resp.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader([]byte(prefix)), resp.Body), Closer: resp.Body}
+	if resp.ContentLength >= 0 {
+		resp.ContentLength += int64(len(prefix))
+		resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	} else {
+		//长度未知，去掉 Content-Length 使用分块编码
+		resp.Header.Del("Content-Length")
+	}
+	return nil
+}
+
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// 关闭时将缓冲区归还到池中
+type pooledBody struct {
+	*bytes.Reader
+	buf  *bytes.Buffer
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() {
+		bufferPool.Put(b.buf)
+	})
+	return nil
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// 按需生成的响应体，不占用内存
+type patternReader struct {
+	remaining int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	for i := range p {
+		p[i] = 'e'
+	}
+	r.remaining -= int64(len(p))
+	return len(p), nil
+}
+
+func (r *patternReader) Close() error { return nil }
+
+func TestDecorateErrorResponseStreaming(t *testing.T) {
+	const size = 100 << 20
+	resp := &http.Response{StatusCode: 500, Header: http.Header{}, ContentLength: -1, Body: &patternReader{remaining: size}}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if err := DecorateErrorResponse(resp, "StatusCode error:", 0); err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, len("StatusCode error:")+3)
+	if _, err := io.ReadFull(resp.Body, head); err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(sha256.New(), resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if string(head) != "StatusCode error:eee" {
+		t.Fatalf("bad head %q", head)
+	}
+	if n+int64(len(head)) != size+int64(len("StatusCode error:")) {
+		t.Fatalf("bad length %d", n)
+	}
+	if resp.ContentLength != -1 || resp.Header.Get("Content-Length") != "" {
+		t.Fatal("unknown length should use chunked encoding")
+	}
+	if grown := int64(after.TotalAlloc - before.TotalAlloc); grown > 10<<20 {
+		t.Fatalf("allocated %d bytes for a streamed body", grown)
+	}
+}
+
+func TestDecorateErrorResponseBuffered(t *testing.T) {
+	resp := &http.Response{StatusCode: 404, Header: http.Header{}, ContentLength: 9, Body: io.NopCloser(strings.NewReader("not found"))}
+	if err := DecorateErrorResponse(resp, "StatusCode error:", 0); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "StatusCode error:not found" || resp.Header.Get("Content-Length") != "26" {
+		t.Fatalf("got %q, Content-Length %s", data, resp.Header.Get("Content-Length"))
+	}
+
+	//已知长度但超过阈值时流式处理并修正长度
+	resp = &http.Response{StatusCode: 502, Header: http.Header{}, ContentLength: 9, Body: io.NopCloser(strings.NewReader("bad gate!"))}
+	DecorateErrorResponse(resp, "E:", 4)
+	data, _ = io.ReadAll(resp.Body)
+	if string(data) != "E:bad gate!" || resp.ContentLength != 11 {
+		t.Fatalf("got %q, length %d", data, resp.ContentLength)
+	}
+
+	ok := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("fine"))}
+	DecorateErrorResponse(ok, "E:", 0)
+	if data, _ := io.ReadAll(ok.Body); string(data) != "fine" {
+		t.Fatalf("200 response changed: %q", data)
+	}
+}
+
+func TestDecorateErrorResponseSkipsNonErrors(t *testing.T) {
+	for _, status := range []int{201, 204, 206, 304} {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}, ContentLength: 0, Body: http.NoBody}
+		DecorateErrorResponse(resp, "E:", 0)
+		if resp.ContentLength != 0 || resp.Header.Get("Content-Length") != "" {
+			t.Fatalf("%d decorated", status)
+		}
+	}
+	head, _ := http.NewRequest(http.MethodHead, "http://example.com/", nil)
+	resp := &http.Response{StatusCode: 404, Header: http.Header{"Content-Length": {"9"}}, ContentLength: 9, Body: http.NoBody, Request: head}
+	DecorateErrorResponse(resp, "E:", 0)
+	if resp.ContentLength != 9 || resp.Body != http.NoBody {
+		t.Fatal("HEAD response decorated")
+	}
+}
@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultHTMLErrorPage = `<!DOCTYPE html>
+<html><head><title>{{.Status}} {{.StatusText}}</title></head>
+<body><h1>{{.Status}} {{.StatusText}}</h1>
+<p>{{.Message}}</p>
+<p>Request ID: {{.RequestID}}<br>Time: {{.Timestamp}}</p>
+{{if .Error}}<pre>{{.Error}}</pre>{{end}}</body></html>
+`
+	defaultJSONErrorPage = `{"status":{{.Status}},"error":{{json .Category}},"message":{{json .Message}},"request_id":{{json .RequestID}},"timestamp":{{json .Timestamp}}{{if .Error}},"detail":{{json .Error}}{{end}}}
+`
+)
+
+// 对外展示的错误类别，不包含上游错误细节
+const (
+	CategoryUpstreamTimeout   = "upstream_timeout"
+	CategoryConnectionRefused = "connection_refused"
+	CategoryConnectionReset   = "connection_reset"
+	CategoryDNSFailure        = "dns_failure"
+	CategoryTLSFailure        = "tls_failure"
+	CategoryNoBackends        = "no_backends"
+	CategoryCircuitOpen       = "circuit_open"
+	CategoryClientCanceled    = "client_canceled"
+	CategoryUpstreamError     = "upstream_error"
+)
+
+var categoryMessages = map[string]string{
+	CategoryUpstreamTimeout:   "The upstream service did not respond in time.",
+	CategoryConnectionRefused: "The upstream service is unreachable.",
+	CategoryConnectionReset:   "The upstream service closed the connection unexpectedly.",
+	CategoryDNSFailure:        "The upstream service could not be resolved.",
+	CategoryTLSFailure:        "A secure connection to the upstream service could not be established.",
+	CategoryCircuitOpen:       "The upstream service is temporarily unavailable.",
+	CategoryClientCanceled:    "The client closed the request.",
+	CategoryNoBackends:        "No upstream service is available.",
+	CategoryUpstreamError:     "The upstream service returned an invalid response.",
+}
+
+// 模板变量
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	Category   string
+	Message    string
+	RequestID  string
+	Timestamp  string
+	Error      string //仅 Debug 模式下有值
+}
+
+// 错误页模板，按状态码与 Accept 选择 HTML 或 JSON 模板。
+// 目录中的文件命名为 502.html、502.json、default.html、default.json，缺失时使用内置模板。
+// .html 使用 html/template 自动转义，模板中忘记 |html 也不会把请求头等内容原样输出
+type ErrorPages struct {
+	Dir   string
+	Debug bool //为 true 时在错误页中输出原始错误
+
+	mux       sync.RWMutex
+	templates map[string]pageTemplate //key 如 "502.html"、"default.json"
+}
+
+// text/template 与 html/template 的公共部分
+type pageTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+var templateFuncs = template.FuncMap{
+	"html": template.HTMLEscapeString,
+	"json": jsonString,
+}
+
+// html/template 自带 html 转义函数
+var htmlTemplateFuncs = htmltemplate.FuncMap{
+	"json": jsonString,
+}
+
+// 按扩展名选择模板包
+func parseErrorPage(name, text string) (pageTemplate, error) {
+	if filepath.Ext(name) == ".html" {
+		return htmltemplate.New(name).Funcs(htmlTemplateFuncs).Parse(text)
+	}
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+func mustParseErrorPage(name, text string) pageTemplate {
+	tmpl, err := parseErrorPage(name, text)
+	if err != nil {
+		panic(err)
+	}
+	return tmpl
+}
+
+func NewErrorPages(dir string, debug bool) (*ErrorPages, error) {
+	e := &ErrorPages{Dir: dir, Debug: debug}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// 重新加载模板目录，加载失败时保留原有模板
+func (e *ErrorPages) Reload() error {
+	templates := map[string]pageTemplate{
+		"default.html": mustParseErrorPage("default.html", defaultHTMLErrorPage),
+		"default.json": mustParseErrorPage("default.json", defaultJSONErrorPage),
+	}
+	if e.Dir != "" {
+		files, err := filepath.Glob(filepath.Join(e.Dir, "*"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := filepath.Base(file)
+			if ext := filepath.Ext(name); ext != ".html" && ext != ".json" {
+				continue
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			tmpl, err := parseErrorPage(name, string(data))
+			if err != nil {
+				return err
+			}
+			templates[name] = tmpl
+		}
+	}
+	e.mux.Lock()
+	e.templates = templates
+	e.mux.Unlock()
+	return nil
+}
+
+// 定时重新加载，返回的函数用于停止
+func (e *ErrorPages) WatchReload(interval time.Duration) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := e.Reload(); err != nil {
+					fmt.Println("error page reload error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+func (e *ErrorPages) lookup(status int, format string) pageTemplate {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	if tmpl, ok := e.templates[strconv.Itoa(status)+"."+format]; ok {
+		return tmpl
+	}
+	return e.templates["default."+format]
+}
+
+// 按 Accept 头选择 JSON 或 HTML
+func negotiateFormat(req *http.Request) string {
+	accept := req.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html") {
+		return "json"
+	}
+	return "html"
+}
+
+func (e *ErrorPages) Render(w http.ResponseWriter, req *http.Request, status int, err error) {
+	category := errorCategory(err)
+	data := ErrorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Category:   category,
+		Message:    categoryMessages[category],
+		RequestID:  requestID(req),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if e.Debug && err != nil {
+		data.Error = err.Error()
+	}
+	format := negotiateFormat(req)
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	if execErr := e.lookup(status, format).Execute(buf, data); execErr != nil {
+		fmt.Println("error page render error", execErr)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Header().Set("X-Request-Id", data.RequestID)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// 客户端传入的 X-Request-Id 超过该长度时重新生成
+const maxRequestIDLen = 128
+
+// 优先使用请求头中的 X-Request-Id，否则生成一个。客户端的值会回显在错误页与响应头中，
+// 只接受字母、数字与 -_.: 组成的值
+func requestID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-Id"); validRequestID(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	req.Header.Set("X-Request-Id", id)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
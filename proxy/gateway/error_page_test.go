@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 返回一个已关闭端口的地址，连接会被拒绝
+func refusedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return "http://" + addr
+}
+
+func TestErrorPageSanitizedNegotiation(t *testing.T) {
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(refusedAddr(t))
+	p := NewProxy(lb, Options{})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Request-Id", "req-1")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid json %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusBadGateway || body["error"] != CategoryConnectionRefused || body["request_id"] != "req-1" {
+		t.Fatalf("unexpected response %d %v", rec.Code, body)
+	}
+	if strings.Contains(rec.Body.String(), "127.0.0.1") || body["detail"] != nil {
+		t.Fatalf("upstream error leaked: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html,application/json")
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), "<h1>502 Bad Gateway</h1>") {
+		t.Fatalf("expected html page, got %q", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "127.0.0.1") {
+		t.Fatal("upstream error leaked in html")
+	}
+
+	debugPages, _ := NewErrorPages("", true)
+	p = NewProxy(lb, Options{ErrorPages: debugPages})
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "connection refused") {
+		t.Fatal("debug mode should include raw error")
+	}
+}
+
+func TestErrorPageReload(t *testing.T) {
+	dir := t.TempDir()
+	pages, err := NewErrorPages(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func() string {
+		rec := httptest.NewRecorder()
+		pages.Render(rec, httptest.NewRequest("GET", "/", nil), http.StatusServiceUnavailable, load_balance.ErrNoBackends)
+		return rec.Body.String()
+	}
+	if !strings.Contains(render(), "503 Service Unavailable") {
+		t.Fatal("default template not used")
+	}
+	os.WriteFile(filepath.Join(dir, "503.html"), []byte("custom {{.Category}} {{.Status}}"), 0644)
+	if err := pages.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(); got != "custom no_backends 503" {
+		t.Fatalf("custom template not loaded, got %q", got)
+	}
+	//模板错误时保留原模板
+	os.WriteFile(filepath.Join(dir, "503.html"), []byte("{{.Broken"), 0644)
+	if err := pages.Reload(); err == nil {
+		t.Fatal("broken template should fail to load")
+	}
+	if got := render(); got != "custom no_backends 503" {
+		t.Fatalf("previous template should be kept, got %q", got)
+	}
+}
+
+func TestErrorPageEscapesCustomHTML(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "502.html"), []byte("{{.RequestID}} {{.Error}}"), 0644)
+	pages, err := NewErrorPages(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "<script>alert(1)</script>")
+	rec := httptest.NewRecorder()
+	pages.Render(rec, req, http.StatusBadGateway, errors.New("<b>upstream</b>"))
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>") || strings.Contains(body, "<b>") {
+		t.Fatalf("unescaped output %q", body)
+	}
+	if id := rec.Header().Get("X-Request-Id"); !validRequestID(id) || strings.Contains(id, "script") {
+		t.Fatalf("request id %q echoed", id)
+	}
+	if !strings.Contains(body, "&lt;b&gt;upstream&lt;/b&gt;") {
+		t.Fatalf("got %q", body)
+	}
+}
+
+func TestRequestIDValidation(t *testing.T) {
+	for id, want := range map[string]bool{
+		"":                             false,
+		"abc-123_x.y:z":                true,
+		"a b":                          false,
+		"<x>":                          false,
+		strings.Repeat("a", 128):       true,
+		strings.Repeat("a", 129):       false,
+		"0f8e6c9e-4b1d-4c3a-9a51-6b1e": true,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Fatalf("%q got %v", id, got)
+		}
+	}
+}
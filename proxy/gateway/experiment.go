@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/middleware"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultExperimentCookieTTL      = 30 * 24 * time.Hour
+	DefaultExperimentOverrideHeader = "X-Experiment-Override"
+	ExperimentVariantHeader         = "X-Experiment-Variant"
+)
+
+var experimentAssignments = metrics.NewCounterVec("gateway_experiment_assignments_total", "A/B 实验分组次数，按实验与分组统计", "experiment,variant")
+
+// 实验分组，Weight 为相对权重
+type Variant struct {
+	Name    string
+	Weight  int
+	Handler http.Handler
+}
+
+type ExperimentConf struct {
+	Name           string
+	Variants       []Variant
+	Secret         []byte        //分组 cookie 的 HMAC 签名密钥
+	CookieName     string        //默认 gw_exp_<Name>
+	CookieTTL      time.Duration //默认 DefaultExperimentCookieTTL
+	OverrideHeader string        //QA 指定分组的请求头，默认 DefaultExperimentOverrideHeader
+	//返回 true 时才接受覆盖头，如校验来源 IP 或内部令牌；nil 表示忽略覆盖头，客户端不能绕过分组
+	OverrideAllowed func(req *http.Request) bool
+	UserIDHeader    string //用户 ID 请求头，存在时按用户 ID 固定分组
+}
+
+// A/B 实验路由：按 覆盖头(需 OverrideAllowed 允许) > 用户 ID > 签名 cookie > 客户端 IP 的顺序确定分组
+type Experiment struct {
+	conf        ExperimentConf
+	totalWeight int
+	variants    map[string]*Variant
+}
+
+func NewExperiment(conf ExperimentConf) (*Experiment, error) {
+	if conf.Name == "" {
+		return nil, errors.New("experiment name is required")
+	}
+	if strings.Contains(conf.Name, metrics.LabelValueSeparator) {
+		return nil, errors.New("invalid experiment name: " + conf.Name)
+	}
+	if len(conf.Secret) == 0 {
+		return nil, errors.New("experiment secret is required")
+	}
+	if len(conf.Variants) == 0 {
+		return nil, errors.New("experiment needs at least one variant")
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = "gw_exp_" + conf.Name
+	}
+	if conf.CookieTTL <= 0 {
+		conf.CookieTTL = DefaultExperimentCookieTTL
+	}
+	if conf.OverrideHeader == "" {
+		conf.OverrideHeader = DefaultExperimentOverrideHeader
+	}
+	e := &Experiment{conf: conf, variants: map[string]*Variant{}}
+	for i := range e.conf.Variants {
+		v := &e.conf.Variants[i]
+		if v.Name == "" || strings.ContainsAny(v.Name, ".;, /") {
+			return nil, errors.New("invalid variant name: " + v.Name)
+		}
+		if v.Weight < 0 || v.Handler == nil {
+			return nil, errors.New("invalid variant: " + v.Name)
+		}
+		if _, ok := e.variants[v.Name]; ok {
+			return nil, errors.New("duplicate variant: " + v.Name)
+		}
+		e.variants[v.Name] = v
+		e.totalWeight += v.Weight
+	}
+	if e.totalWeight == 0 {
+		return nil, errors.New("experiment variants have no weight")
+	}
+	return e, nil
+}
+
+func (e *Experiment) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	v, sticky := e.Assign(req)
+	if !sticky {
+		http.SetCookie(w, &http.Cookie{
+			Name:     e.conf.CookieName,
+			Value:    e.sign(v.Name),
+			Path:     "/",
+			MaxAge:   int(e.conf.CookieTTL / time.Second),
+			HttpOnly: true,
+		})
+	}
+	experimentAssignments.Inc(metrics.JoinLabelValues(e.conf.Name, v.Name))
+	middleware.SetLogField(req, "variant", v.Name)
+	req.Header.Set(ExperimentVariantHeader, v.Name)
+	v.Handler.ServeHTTP(w, req)
+}
+
+// 确定请求所属分组，sticky 为 true 表示无需下发分组 cookie
+func (e *Experiment) Assign(req *http.Request) (v *Variant, sticky bool) {
+	if name := req.Header.Get(e.conf.OverrideHeader); name != "" && e.conf.OverrideAllowed != nil && e.conf.OverrideAllowed(req) {
+		if v, ok := e.variants[name]; ok {
+			return v, true
+		}
+	}
+	if e.conf.UserIDHeader != "" {
+		if id := req.Header.Get(e.conf.UserIDHeader); id != "" {
+			v := e.bucket(id)
+			return v, e.cookieVariant(req) == v
+		}
+	}
+	if v := e.cookieVariant(req); v != nil {
+		return v, true
+	}
+	return e.bucket(middleware.ClientIP(req)), false
+}
+
+// 按权重把 key 稳定地映射到分组
+func (e *Experiment) bucket(key string) *Variant {
+	h := fnv.New32a()
+	h.Write([]byte(e.conf.Name + ":" + key))
+	n := int(h.Sum32() % uint32(e.totalWeight))
+	for i := range e.conf.Variants {
+		v := &e.conf.Variants[i]
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return &e.conf.Variants[len(e.conf.Variants)-1]
+}
+
+// 解析并校验分组 cookie，签名不符或分组不存在时返回 nil
+func (e *Experiment) cookieVariant(req *http.Request) *Variant {
+	c, err := req.Cookie(e.conf.CookieName)
+	if err != nil {
+		return nil
+	}
+	name, sig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return nil
+	}
+	expected := e.mac(name)
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, expected) {
+		return nil
+	}
+	return e.variants[name]
+}
+
+func (e *Experiment) sign(name string) string {
+	return name + "." + base64.RawURLEncoding.EncodeToString(e.mac(name))
+}
+
+func (e *Experiment) mac(name string) []byte {
+	m := hmac.New(sha256.New, e.conf.Secret)
+	m.Write([]byte(e.conf.Name + "|" + name))
+	return m.Sum(nil)
+}
@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func variantHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(name + ":" + req.Header.Get(ExperimentVariantHeader)))
+	})
+}
+
+func newTestExperiment(t *testing.T) *Experiment {
+	e, err := NewExperiment(ExperimentConf{
+		Name:   "checkout",
+		Secret: []byte("secret"),
+		Variants: []Variant{
+			{Name: "a", Weight: 80, Handler: variantHandler("a")},
+			{Name: "b", Weight: 20, Handler: variantHandler("b")},
+			{Name: "qa", Weight: 0, Handler: variantHandler("qa")},
+		},
+		UserIDHeader: "X-User-Id",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func experimentRequest(e *Experiment, remoteAddr string, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func experimentCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "gw_exp_checkout" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestExperimentStickyCookie(t *testing.T) {
+	e := newTestExperiment(t)
+	rec := experimentRequest(e, "10.0.0.1:1000", nil)
+	c := experimentCookie(rec)
+	if c == nil || !c.HttpOnly || c.MaxAge <= 0 {
+		t.Fatalf("assignment cookie not set: %v", c)
+	}
+	first := rec.Body.String()
+	//换了 IP 后仍按 cookie 分组，且不再重复下发 cookie
+	for i := 0; i < 20; i++ {
+		rec := experimentRequest(e, fmt.Sprintf("10.0.1.%d:1000", i), nil, c)
+		if rec.Body.String() != first {
+			t.Fatalf("cookie not sticky: %q vs %q", rec.Body, first)
+		}
+		if experimentCookie(rec) != nil {
+			t.Fatal("valid cookie should not be reissued")
+		}
+	}
+}
+
+func TestExperimentHMAC(t *testing.T) {
+	e := newTestExperiment(t)
+	//找到一个被分到 a 组的客户端，再伪造 b 组的 cookie
+	addr := ""
+	for i := 0; i < 100 && addr == ""; i++ {
+		a := fmt.Sprintf("10.1.0.%d:1000", i)
+		if experimentRequest(e, a, nil).Body.String() == "a:a" {
+			addr = a
+		}
+	}
+	for _, value := range []string{"b", "b.forged", "b." + strings.Split(e.sign("a"), ".")[1]} {
+		rec := experimentRequest(e, addr, nil, &http.Cookie{Name: "gw_exp_checkout", Value: value})
+		if rec.Body.String() != "a:a" {
+			t.Fatalf("forged cookie %q accepted: %q", value, rec.Body)
+		}
+		if c := experimentCookie(rec); c == nil || c.Value != e.sign("a") {
+			t.Fatalf("forged cookie should be replaced, got %v", c)
+		}
+	}
+	other, _ := NewExperiment(ExperimentConf{Name: "checkout", Secret: []byte("other"), Variants: []Variant{{Name: "b", Weight: 1, Handler: variantHandler("b")}}})
+	if rec := experimentRequest(e, addr, nil, &http.Cookie{Name: "gw_exp_checkout", Value: other.sign("b")}); rec.Body.String() != "a:a" {
+		t.Fatalf("cookie signed with another secret accepted: %q", rec.Body)
+	}
+	if rec := experimentRequest(e, addr, nil, &http.Cookie{Name: "gw_exp_checkout", Value: e.sign("b")}); rec.Body.String() != "b:b" {
+		t.Fatalf("valid cookie ignored: %q", rec.Body)
+	}
+}
+
+func TestExperimentOverride(t *testing.T) {
+	e := newTestExperiment(t)
+	//未配置 OverrideAllowed 时客户端不能指定分组
+	if rec := experimentRequest(e, "10.0.0.1:1000", http.Header{"X-Experiment-Override": {"qa"}}); rec.Body.String() == "qa:qa" {
+		t.Fatal("override accepted without OverrideAllowed")
+	}
+	e.conf.OverrideAllowed = func(req *http.Request) bool { return req.Header.Get("X-Qa-Token") == "t" }
+	if rec := experimentRequest(e, "10.0.0.1:1000", http.Header{"X-Experiment-Override": {"qa"}}); rec.Body.String() == "qa:qa" {
+		t.Fatal("override accepted from untrusted request")
+	}
+	rec := experimentRequest(e, "10.0.0.1:1000", http.Header{"X-Experiment-Override": {"qa"}, "X-User-Id": {"u1"}, "X-Qa-Token": {"t"}})
+	if rec.Body.String() != "qa:qa" {
+		t.Fatalf("override ignored: %q", rec.Body)
+	}
+	if experimentCookie(rec) != nil {
+		t.Fatal("override should not persist assignment")
+	}
+	//不存在的分组忽略覆盖
+	if rec := experimentRequest(e, "10.0.0.1:1000", http.Header{"X-Experiment-Override": {"nope"}, "X-Qa-Token": {"t"}}); strings.HasPrefix(rec.Body.String(), "nope") || rec.Body.Len() == 0 {
+		t.Fatalf("unknown override got %q", rec.Body)
+	}
+	//客户端不能直接指定转发给上游的分组头
+	if rec := experimentRequest(e, "10.0.0.1:1000", http.Header{"X-Experiment-Variant": {"qa"}, "X-Experiment-Override": {"b"}, "X-Qa-Token": {"t"}}); rec.Body.String() != "b:b" {
+		t.Fatalf("variant header not overwritten: %q", rec.Body)
+	}
+}
+
+func TestExperimentUserID(t *testing.T) {
+	e := newTestExperiment(t)
+	for i := 0; i < 50; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		first := experimentRequest(e, "10.0.0.1:1000", http.Header{"X-User-Id": {id}})
+		//不同 IP、携带其他分组的 cookie 时结果不变
+		for _, v := range []string{"a", "b"} {
+			rec := experimentRequest(e, "10.9.9.9:1000", http.Header{"X-User-Id": {id}}, &http.Cookie{Name: "gw_exp_checkout", Value: e.sign(v)})
+			if rec.Body.String() != first.Body.String() {
+				t.Fatalf("%s not deterministic: %q vs %q", id, rec.Body, first.Body)
+			}
+		}
+	}
+}
+
+func TestExperimentWeights(t *testing.T) {
+	e := newTestExperiment(t)
+	counts := map[string]int{}
+	n := 10000
+	for i := 0; i < n; i++ {
+		counts[e.bucket(fmt.Sprintf("user-%d", i)).Name]++
+	}
+	if counts["qa"] != 0 {
+		t.Fatalf("zero weight variant assigned %d times", counts["qa"])
+	}
+	if b := float64(counts["b"]) / float64(n); b < 0.17 || b > 0.23 {
+		t.Fatalf("variant b share %.3f, want ~0.2 (%v)", b, counts)
+	}
+}
+
+func TestNewExperimentValidation(t *testing.T) {
+	h := variantHandler("a")
+	for _, conf := range []ExperimentConf{
+		{Name: "x", Variants: []Variant{{Name: "a", Weight: 1, Handler: h}}},
+		{Name: "x", Secret: []byte("s")},
+		{Name: "x", Secret: []byte("s"), Variants: []Variant{{Name: "a.b", Weight: 1, Handler: h}}},
+		{Name: "x", Secret: []byte("s"), Variants: []Variant{{Name: "a", Weight: 1, Handler: h}, {Name: "a", Weight: 1, Handler: h}}},
+		{Name: "x", Secret: []byte("s"), Variants: []Variant{{Name: "a", Weight: 0, Handler: h}}},
+	} {
+		if _, err := NewExperiment(conf); err == nil {
+			t.Errorf("expected error for %+v", conf)
+		}
+	}
+}
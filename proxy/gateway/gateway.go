@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// 网关的一个监听端口，各自有路由表与中间件，负载均衡与指标在所有监听间共享
+type Listener struct {
+	Name        string
+	Addr        string
+	TLSConfig   *tls.Config  //不为空时监听 TLS
+	Handler     http.Handler //路由表，一般为 *Router
+	Middlewares []Middleware //监听级中间件，在路由表之前执行
+	Server      ServerConf   //超时、连接数等设置，其中 Addr/Handler/TLSConfig 以 Listener 为准
+}
+
+type GatewayOptions struct {
+	Listeners []Listener
+	Listen    func(network, addr string) (net.Listener, error) //绑定监听端口，默认按 Listener.Server.Socket 的选项监听，热升级时使用 upgrade.Upgrader.Listen
+	//优雅关闭时在停止监听前依次调用，如从 zk 注销本网关，使其他网关先停止转发再开始排空请求
+	BeforeShutdown []func(ctx context.Context) error
+}
+
+// 单进程多端口的网关
+type Gateway struct {
+	opts GatewayOptions
+
+	mux     sync.Mutex
+	servers []*Server
+	addrs   map[string]net.Addr
+	wg      sync.WaitGroup
+}
+
+func NewGateway(opts GatewayOptions) *Gateway {
+	return &Gateway{opts: opts, addrs: map[string]net.Addr{}}
+}
+
+// 绑定所有监听端口后开始服务，任一端口绑定失败时关闭已绑定的端口并返回错误
+func (g *Gateway) Start() error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if len(g.servers) > 0 {
+		return errors.New("gateway already started")
+	}
+	if len(g.opts.Listeners) == 0 {
+		return errors.New("gateway has no listeners")
+	}
+	listeners := make([]net.Listener, 0, len(g.opts.Listeners))
+	for _, conf := range g.opts.Listeners {
+		if conf.Handler == nil {
+			closeListeners(listeners)
+			return fmt.Errorf("listener %s: no handler", conf.Name)
+		}
+		listen := g.opts.Listen
+		if listen == nil {
+			listen = conf.Server.Socket.Listen
+		}
+		l, err := listen("tcp", conf.Addr)
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("listener %s: bind %s: %v", conf.Name, conf.Addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	for i, conf := range g.opts.Listeners {
+		h := NewChain(conf.Middlewares...).Then(conf.Handler)
+		serverConf := conf.Server
+		serverConf.Addr = conf.Addr
+		serverConf.Handler = h
+		serverConf.TLSConfig = conf.TLSConfig
+		s := NewServer(serverConf)
+		g.servers = append(g.servers, s)
+		g.addrs[conf.Name] = listeners[i].Addr()
+		g.wg.Add(1)
+		go func(name string, s *Server, l net.Listener) {
+			defer g.wg.Done()
+			if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
+				fmt.Println("listener", name, "serve error", err)
+			}
+		}(conf.Name, s, listeners[i])
+	}
+	return nil
+}
+
+// 实际监听的地址，用于 Addr 配置为 :0 的情况
+func (g *Gateway) Addr(name string) net.Addr {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	return g.addrs[name]
+}
+
+// 优雅关闭所有监听端口，BeforeShutdown 出错时仍继续关闭
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	g.mux.Lock()
+	servers := g.servers
+	g.servers = nil
+	g.mux.Unlock()
+	var firstErr error
+	if len(servers) > 0 {
+		for _, hook := range g.opts.BeforeShutdown {
+			if err := hook(ctx); err != nil {
+				fmt.Println("gateway before shutdown error", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	g.wg.Wait()
+	return firstErr
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
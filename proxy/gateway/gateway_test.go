@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func textHandler(text string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Proto", map[bool]string{true: "https", false: "http"}[req.TLS != nil])
+		w.Write([]byte(text))
+	})
+}
+
+func TestGatewayListenersIsolated(t *testing.T) {
+	public := NewRouter()
+	public.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: textHandler("api")})
+	internal := NewRouter()
+	internal.Handle(&Route{Name: "admin", PathPrefix: "/admin", Handler: textHandler("admin")})
+	tagged := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Listener", "internal")
+			next.ServeHTTP(w, req)
+		})
+	}
+	tlsServer := httptest.NewTLSServer(nil)
+	tlsConf, client := tlsServer.TLS, tlsServer.Client()
+	tlsServer.Close()
+
+	g := NewGateway(GatewayOptions{Listeners: []Listener{
+		{Name: "http", Addr: "127.0.0.1:0", Handler: public},
+		{Name: "https", Addr: "127.0.0.1:0", Handler: public, TLSConfig: tlsConf},
+		{Name: "internal", Addr: "127.0.0.1:0", Handler: internal, Middlewares: []Middleware{tagged}},
+	}})
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown(context.Background())
+
+	get := func(url string) (int, string, http.Header) {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data), resp.Header
+	}
+	httpURL := "http://" + g.Addr("http").String()
+	httpsURL := "https://" + g.Addr("https").String()
+	internalURL := "http://" + g.Addr("internal").String()
+
+	if code, body, h := get(httpURL + "/api/x"); code != 200 || body != "api" || h.Get("X-Listener") != "" || h.Get("X-Proto") != "http" {
+		t.Fatalf("public api got %d %q %v", code, body, h)
+	}
+	if code, body, h := get(httpsURL + "/api/x"); code != 200 || body != "api" || h.Get("X-Proto") != "https" {
+		t.Fatalf("tls api got %d %q %v", code, body, h)
+	}
+	if code, _, _ := get(httpURL + "/admin"); code != http.StatusNotFound {
+		t.Fatalf("admin reachable on public listener: %d", code)
+	}
+	if code, _, _ := get(internalURL + "/api/x"); code != http.StatusNotFound {
+		t.Fatalf("api reachable on internal listener: %d", code)
+	}
+	if code, body, h := get(internalURL + "/admin"); code != 200 || body != "admin" || h.Get("X-Listener") != "internal" {
+		t.Fatalf("internal admin got %d %q %v", code, body, h)
+	}
+
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(internalURL + "/admin"); err == nil {
+		t.Fatal("listener still serving after shutdown")
+	}
+}
+
+func TestGatewayBindFailure(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeAddr := free.Addr().String()
+	free.Close()
+
+	g := NewGateway(GatewayOptions{Listeners: []Listener{
+		{Name: "public", Addr: freeAddr, Handler: textHandler("ok")},
+		{Name: "internal", Addr: busy.Addr().String(), Handler: textHandler("ok")},
+	}})
+	err = g.Start()
+	if err == nil || !strings.Contains(err.Error(), "internal") {
+		t.Fatalf("expected bind error naming the listener, got %v", err)
+	}
+	//已绑定的端口应被释放
+	l, err := net.Listen("tcp", freeAddr)
+	if err != nil {
+		t.Fatalf("first listener not closed after failure: %v", err)
+	}
+	l.Close()
+}
+
+func TestGatewayBeforeShutdown(t *testing.T) {
+	var g *Gateway
+	var served []int
+	g = NewGateway(GatewayOptions{
+		Listeners: []Listener{{Name: "http", Addr: "127.0.0.1:0", Handler: textHandler("ok")}},
+		BeforeShutdown: []func(ctx context.Context) error{
+			//注销时仍在服务
+			func(ctx context.Context) error {
+				resp, err := http.Get("http://" + g.Addr("http").String())
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				served = append(served, resp.StatusCode)
+				return nil
+			},
+			func(ctx context.Context) error { return errors.New("deregister failed") },
+		},
+	})
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := g.Addr("http").String()
+	if err := g.Shutdown(context.Background()); err == nil || err.Error() != "deregister failed" {
+		t.Fatalf("shutdown got %v", err)
+	}
+	if len(served) != 1 || served[0] != 200 {
+		t.Fatalf("served %v before shutdown", served)
+	}
+	//钩子出错也要关闭监听
+	if _, err := http.Get("http://" + addr); err == nil {
+		t.Fatal("listener still serving after shutdown")
+	}
+}
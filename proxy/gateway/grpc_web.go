@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"golang.org/x/net/http2"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// 配置 CORS 中间件时需要放行与暴露的 gRPC-Web 头
+var (
+	GRPCWebAllowedHeaders = []string{"Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"}
+	GRPCWebExposedHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+var grpcWebRequests = metrics.NewCounterVec("gateway_grpc_web_requests_total", "转换为原生 gRPC 的 gRPC-Web 请求数，按编码(binary/text)统计", "mode")
+
+// 以 HTTP/2 明文(h2c，prior knowledge)连接上游的 transport，用作 gRPC 后端的 Options.Transport
+func NewH2CTransport() *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return defaultDialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// gRPC-Web 桥接中间件，放在 gRPC 路由的 Middlewares 中，路由的 Proxy 使用 NewH2CTransport：
+// application/grpc-web(+proto) 与 application/grpc-web-text(+proto) 请求转换为原生 gRPC 转发，
+// 响应的 trailer 按 gRPC-Web 规范编码为响应体中的最后一帧。其他请求原样转发
+func GRPCWeb(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		contentType := req.Header.Get("Content-Type")
+		if req.Method != http.MethodPost || !strings.HasPrefix(contentType, "application/grpc-web") {
+			next.ServeHTTP(w, req)
+			return
+		}
+		text := strings.HasPrefix(contentType, "application/grpc-web-text")
+		subtype := strings.TrimPrefix(strings.TrimPrefix(contentType, "application/grpc-web-text"), "application/grpc-web")
+		mode := "binary"
+		if text {
+			mode = "text"
+			req.Body = readCloser{&grpcWebTextReader{src: req.Body}, req.Body}
+			req.ContentLength = -1
+			req.Header.Del("Content-Length")
+		}
+		grpcWebRequests.Inc(mode)
+		req.Header.Set("Content-Type", "application/grpc"+subtype)
+		req.Header.Del("X-Grpc-Web")
+
+		gw := &grpcWebWriter{ResponseWriter: w, text: text, contentType: contentType}
+		next.ServeHTTP(gw, req)
+		gw.finish()
+	})
+}
+
+// 把原生 gRPC 响应转换为 gRPC-Web：改写 Content-Type，去掉 HTTP trailer 声明，
+// 结束时把 trailer 写为 flag 为 0x80 的帧；text 模式下输出 base64 编码
+type grpcWebWriter struct {
+	http.ResponseWriter
+	text        bool
+	contentType string //与请求的 Content-Type 一致
+	wroteHeader bool
+	grpc        bool     //上游返回的是 gRPC 响应
+	trailers    []string //上游声明的 trailer
+	pending     []byte   //text 模式下不足 3 字节、尚未编码的部分
+}
+
+func (w *grpcWebWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if strings.HasPrefix(h.Get("Content-Type"), "application/grpc") {
+		w.grpc = true
+		h.Set("Content-Type", w.contentType)
+		h.Del("Content-Length")
+		for _, v := range h["Trailer"] {
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					w.trailers = append(w.trailers, name)
+				}
+			}
+		}
+		h.Del("Trailer")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *grpcWebWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.grpc || !w.text {
+		return w.ResponseWriter.Write(b)
+	}
+	if err := w.writeText(b, false); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 按 3 字节一组编码，final 为 true 时把剩余部分带填充输出
+func (w *grpcWebWriter) writeText(b []byte, final bool) error {
+	data := append(w.pending, b...)
+	n := len(data) / 3 * 3
+	if final {
+		n = len(data)
+	}
+	w.pending = append([]byte(nil), data[n:]...)
+	if n == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write([]byte(base64.StdEncoding.EncodeToString(data[:n])))
+	return err
+}
+
+func (w *grpcWebWriter) Flush() {
+	if w.grpc && w.text && len(w.pending) > 0 {
+		w.writeText(nil, true)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *grpcWebWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// 写出 trailer 帧。只有头部的响应(trailers-only)中 grpc-status 已在响应头里，不再写 trailer 帧
+func (w *grpcWebWriter) finish() {
+	if !w.grpc {
+		return
+	}
+	h := w.Header()
+	trailer := http.Header{}
+	for _, name := range w.trailers {
+		if v, ok := h[http.CanonicalHeaderKey(name)]; ok {
+			trailer[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	for k, v := range h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+			//未声明的 trailer 不能留给 net/http 发送
+			delete(h, k)
+		}
+	}
+	if len(trailer) > 0 || h.Get("Grpc-Status") == "" {
+		frame := encodeGRPCWebTrailer(trailer)
+		if w.text {
+			w.writeText(frame, true)
+		} else {
+			w.ResponseWriter.Write(frame)
+		}
+	} else if w.text {
+		w.writeText(nil, true)
+	}
+}
+
+// trailer 帧：1 字节 flag(0x80) + 4 字节长度 + 小写的 "key: value\r\n" 列表
+func encodeGRPCWebTrailer(trailer http.Header) []byte {
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var body bytes.Buffer
+	for _, k := range keys {
+		for _, v := range trailer[k] {
+			body.WriteString(strings.ToLower(k))
+			body.WriteString(": ")
+			body.WriteString(v)
+			body.WriteString("\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+body.Len())
+	frame[0] = 0x80
+	binary.BigEndian.PutUint32(frame[1:], uint32(body.Len()))
+	return append(frame, body.Bytes()...)
+}
+
+// 解码 grpc-web-text 请求体，客户端可能逐条消息编码，填充会出现在中间，按 4 字符一组解码
+type grpcWebTextReader struct {
+	src     io.Reader
+	in      []byte //未满 4 字符的输入
+	out     []byte //已解码未读出的数据
+	readErr error
+}
+
+func (r *grpcWebTextReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.readErr != nil {
+			if r.readErr == io.EOF && len(r.in) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, r.readErr
+		}
+		buf := make([]byte, 4096)
+		n, err := r.src.Read(buf)
+		r.readErr = err
+		for _, c := range buf[:n] {
+			if c != '\r' && c != '\n' {
+				r.in = append(r.in, c)
+			}
+		}
+		groups := len(r.in) / 4 * 4
+		for i := 0; i < groups; i += 4 {
+			dst := make([]byte, 3)
+			m, err := base64.StdEncoding.Decode(dst, r.in[i:i+4])
+			if err != nil {
+				return 0, err
+			}
+			r.out = append(r.out, dst[:m]...)
+		}
+		r.in = append(r.in[:0], r.in[groups:]...)
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/middleware"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// StringValue{value: "world"} 的 gRPC-Web 请求体：flag 0 + 长度 7 + protobuf
+var grpcWebHelloFixture = []byte{0x00, 0x00, 0x00, 0x00, 0x07, 0x0a, 0x05, 'w', 'o', 'r', 'l', 'd'}
+
+// 不依赖生成代码的测试服务：Say 为一元调用，Count 为服务端流
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Say",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if in.Value == "fail" {
+				return nil, status.Error(codes.InvalidArgument, "bad name")
+			}
+			return wrapperspb.String("hello " + in.Value), nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Count",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := new(wrapperspb.StringValue)
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			for i := 1; i <= 3; i++ {
+				if err := stream.SendMsg(wrapperspb.String(fmt.Sprintf("%s %d", in.Value, i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}},
+}
+
+func grpcWebGateway(t *testing.T) http.Handler {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	s.RegisterService(&echoServiceDesc, struct{}{})
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add("http://" + l.Addr().String())
+	r := NewRouter()
+	r.Handle(&Route{Name: "grpc", PathPrefix: "/test.Echo/", Handler: NewProxy(lb, Options{Transport: NewH2CTransport()}), Middlewares: []Middleware{GRPCWeb}})
+	cors := middleware.NewCORS(middleware.CORSConf{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{http.MethodPost},
+		AllowedHeaders: GRPCWebAllowedHeaders,
+		ExposedHeaders: GRPCWebExposedHeaders,
+	})
+	return cors.Handler(r)
+}
+
+// 拆分 gRPC-Web 响应体，返回消息与 trailer
+func parseGRPCWebFrames(t *testing.T, body []byte) ([]string, map[string]string) {
+	var messages []string
+	trailer := map[string]string{}
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("truncated frame header: %x", body)
+		}
+		n := binary.BigEndian.Uint32(body[1:5])
+		data := body[5 : 5+n]
+		if body[0]&0x80 != 0 {
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\r\n") {
+				if k, v, ok := strings.Cut(line, ":"); ok {
+					trailer[k] = strings.TrimSpace(v)
+				}
+			}
+		} else {
+			msg := new(wrapperspb.StringValue)
+			if err := proto.Unmarshal(data, msg); err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, msg.Value)
+		}
+		body = body[5+n:]
+	}
+	return messages, trailer
+}
+
+func grpcWebCall(h http.Handler, method, contentType string, body []byte) *httptest.ResponseRecorder {
+	if strings.HasPrefix(contentType, "application/grpc-web-text") {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+	req := httptest.NewRequest("POST", "/test.Echo/"+method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGRPCWebUnary(t *testing.T) {
+	h := grpcWebGateway(t)
+	rec := grpcWebCall(h, "Say", "application/grpc-web+proto", grpcWebHelloFixture)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/grpc-web+proto" {
+		t.Fatalf("got %d %v", rec.Code, rec.Header())
+	}
+	messages, trailer := parseGRPCWebFrames(t, rec.Body.Bytes())
+	if len(messages) != 1 || messages[0] != "hello world" || trailer["grpc-status"] != "0" {
+		t.Fatalf("got %v %v", messages, trailer)
+	}
+	if rec.Header().Get("Trailer") != "" || len(rec.Result().Trailer) != 0 {
+		t.Fatal("trailers should be sent in the body")
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || !strings.Contains(rec.Header().Get("Access-Control-Expose-Headers"), "Grpc-Status") {
+		t.Fatalf("cors headers missing: %v", rec.Header())
+	}
+
+	//错误状态：只有头部的响应，grpc-status 在响应头中
+	payload, _ := proto.Marshal(wrapperspb.String("fail"))
+	frame := append([]byte{0, 0, 0, 0, byte(len(payload))}, payload...)
+	rec = grpcWebCall(h, "Say", "application/grpc-web", frame)
+	_, trailer = parseGRPCWebFrames(t, rec.Body.Bytes())
+	code := rec.Header().Get("Grpc-Status")
+	if code == "" {
+		code = trailer["grpc-status"]
+	}
+	if code != fmt.Sprint(int(codes.InvalidArgument)) {
+		t.Fatalf("error status not translated: %v %v", rec.Header(), trailer)
+	}
+}
+
+func TestGRPCWebServerStreamingText(t *testing.T) {
+	h := grpcWebGateway(t)
+	rec := grpcWebCall(h, "Count", "application/grpc-web-text", grpcWebHelloFixture)
+	if rec.Header().Get("Content-Type") != "application/grpc-web-text" {
+		t.Fatalf("got %v", rec.Header())
+	}
+	//每次 flush 输出的都是完整的 base64，整体按 4 字符一组解码
+	body, err := io.ReadAll(&grpcWebTextReader{src: rec.Body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, trailer := parseGRPCWebFrames(t, body)
+	if strings.Join(messages, ",") != "world 1,world 2,world 3" || trailer["grpc-status"] != "0" {
+		t.Fatalf("got %v %v", messages, trailer)
+	}
+}
+
+func TestGRPCWebPreflight(t *testing.T) {
+	h := grpcWebGateway(t)
+	req := httptest.NewRequest("OPTIONS", "/test.Echo/Say", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-user-agent")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || !strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "X-Grpc-Web") {
+		t.Fatalf("preflight got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestGRPCWebTextReader(t *testing.T) {
+	//逐条消息编码、中间带填充的请求体
+	in := base64.StdEncoding.EncodeToString([]byte("ab")) + base64.StdEncoding.EncodeToString([]byte("cdef")) + "\r\n"
+	got, err := io.ReadAll(&grpcWebTextReader{src: strings.NewReader(in)})
+	if err != nil || string(got) != "abcdef" {
+		t.Fatalf("got %q %v", got, err)
+	}
+	if _, err := io.ReadAll(&grpcWebTextReader{src: strings.NewReader("YWJj!")}); err == nil {
+		t.Fatal("truncated input should fail")
+	}
+}
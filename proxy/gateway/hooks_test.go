@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouteHooks(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Seen-Route", req.Header.Get("X-Route"))
+		w.Header().Set("X-Seen-Global", req.Header.Get("X-Global"))
+		w.Write([]byte("payload"))
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+
+	var order []string
+	p := NewProxy(lb, Options{
+		Director: func(req *http.Request) {
+			req.Header.Set("X-Global", "1")
+			order = append(order, "global-director")
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			order = append(order, "global-response")
+			return nil
+		},
+	})
+	r := NewRouter()
+	r.Handle(&Route{
+		Name:       "text",
+		PathPrefix: "/text",
+		Handler:    p,
+		Director: func(req *http.Request) {
+			//钩子能拿到路由与选中的后端
+			req.Header.Set("X-Route", RouteFromContext(req.Context()).Name+"@"+BackendFromContext(req.Context()))
+			order = append(order, "route-director")
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			order = append(order, "route-response")
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = io.NopCloser(strings.NewReader("decorated:" + string(body)))
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+			return nil
+		},
+	})
+	r.Handle(&Route{Name: "download", PathPrefix: "/download", Handler: p})
+
+	rec := serve(r, "GET", "/text", "10.0.0.1:1")
+	if rec.Body.String() != "decorated:payload" || rec.Header().Get("X-Seen-Route") != "text@"+upstream.URL || rec.Header().Get("X-Seen-Global") != "1" {
+		t.Fatalf("text route got %q %v", rec.Body, rec.Header())
+	}
+	if got := strings.Join(order, ","); got != "global-director,route-director,global-response,route-response" {
+		t.Fatalf("hook order %s", got)
+	}
+
+	order = nil
+	rec = serve(r, "GET", "/download", "10.0.0.1:1")
+	if rec.Body.String() != "payload" || rec.Header().Get("X-Seen-Route") != "" {
+		t.Fatalf("download route mutated: %q %v", rec.Body, rec.Header())
+	}
+	if got := strings.Join(order, ","); got != "global-director,global-response" {
+		t.Fatalf("hook order %s", got)
+	}
+}
+
+func TestRouteHookErrorHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	errRejected := errors.New("rejected by route hook")
+	var routeErr error
+
+	p := NewProxy(lb, Options{})
+	r := NewRouter()
+	r.Handle(&Route{
+		Name:           "strict",
+		PathPrefix:     "/strict",
+		Handler:        p,
+		ModifyResponse: func(resp *http.Response) error { return errRejected },
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			routeErr = err
+			w.WriteHeader(http.StatusTeapot)
+		},
+	})
+	r.Handle(&Route{
+		Name:           "plain",
+		PathPrefix:     "/plain",
+		Handler:        p,
+		ModifyResponse: func(resp *http.Response) error { return errRejected },
+	})
+
+	if rec := serve(r, "GET", "/strict", "10.0.0.1:1"); rec.Code != http.StatusTeapot || routeErr != errRejected {
+		t.Fatalf("route error handler not used: %d %v", rec.Code, routeErr)
+	}
+	//没有路由级 ErrorHandler 时使用全局错误处理
+	if rec := serve(r, "GET", "/plain", "10.0.0.1:1"); rec.Code != http.StatusBadGateway {
+		t.Fatalf("global error handler not used: %d", rec.Code)
+	}
+}
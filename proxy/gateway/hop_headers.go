@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 逐跳头，只对单个连接有效，不能转发给上游或客户端
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// 去掉标准逐跳头以及 Connection 中列出的头。
+// keepUpgrade 为 true 时保留协议升级所需的 Upgrade，并把 Connection 置为 Upgrade
+func removeHopHeaders(h http.Header, keepUpgrade bool) {
+	upgrade := ""
+	if keepUpgrade {
+		upgrade = upgradeType(h)
+	}
+	for _, v := range h["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				h.Del(token)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	if upgrade != "" {
+		h.Set("Connection", "Upgrade")
+		h.Set("Upgrade", upgrade)
+	}
+}
+
+// 请求协议升级的类型，如 websocket，未请求升级时返回空
+func upgradeType(h http.Header) string {
+	for _, v := range h["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
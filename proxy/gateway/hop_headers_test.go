@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"connection", "Connection", "close"},
+		{"proxy-connection", "Proxy-Connection", "keep-alive"},
+		{"keep-alive", "Keep-Alive", "timeout=5"},
+		{"proxy-authenticate", "Proxy-Authenticate", "Basic"},
+		{"proxy-authorization", "Proxy-Authorization", "Basic dXNlcjpwYXNz"},
+		{"te", "Te", "trailers"},
+		{"trailer", "Trailer", "X-Checksum"},
+		{"transfer-encoding", "Transfer-Encoding", "chunked"},
+		{"upgrade without connection", "Upgrade", "websocket"},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		h.Set(c.header, c.value)
+		h.Set("X-Keep", "1")
+		removeHopHeaders(h, true)
+		if h.Get(c.header) != "" || h.Get("X-Keep") != "1" {
+			t.Errorf("%s: got %v", c.name, h)
+		}
+	}
+
+	//Connection 中列出的头同样去掉
+	h := http.Header{}
+	h.Add("Connection", "X-Internal-Token, keep-alive")
+	h.Add("Connection", "X-Debug")
+	h.Set("X-Internal-Token", "secret")
+	h.Set("X-Debug", "1")
+	h.Set("X-Keep", "1")
+	removeHopHeaders(h, false)
+	if len(h) != 1 || h.Get("X-Keep") != "1" {
+		t.Fatalf("connection tokens not removed: %v", h)
+	}
+
+	//协议升级请求保留 Upgrade
+	h = http.Header{}
+	h.Set("Connection", "keep-alive, Upgrade")
+	h.Set("Upgrade", "websocket")
+	h.Set("Keep-Alive", "timeout=5")
+	removeHopHeaders(h, true)
+	if h.Get("Upgrade") != "websocket" || h.Get("Connection") != "Upgrade" || h.Get("Keep-Alive") != "" {
+		t.Fatalf("upgrade not preserved: %v", h)
+	}
+	h.Set("Connection", "Upgrade")
+	removeHopHeaders(h, false)
+	if len(h) != 0 {
+		t.Fatalf("upgrade kept without keepUpgrade: %v", h)
+	}
+}
+
+func TestProxyHopHeaders(t *testing.T) {
+	var seen http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = req.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Internal")
+		w.Header().Set("X-Upstream-Internal", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	r := NewRouter()
+	r.Handle(&Route{Name: "hop", PathPrefix: "/", Handler: NewProxy(lb, Options{}), Director: func(req *http.Request) {
+		//自定义 Director 原样复制了入站请求的头
+		req.Header.Set("Proxy-Authorization", "Basic dXNlcjpwYXNz")
+		req.Header.Set("Connection", "X-Client-Secret")
+		req.Header.Set("X-Client-Secret", "1")
+	}})
+
+	rec := serve(r, "GET", "/", "1.1.1.1:1")
+	for _, name := range []string{"Proxy-Authorization", "Connection", "X-Client-Secret"} {
+		if seen.Get(name) != "" {
+			t.Errorf("%s leaked to upstream", name)
+		}
+	}
+	for _, name := range []string{"Connection", "X-Upstream-Internal", "Keep-Alive"} {
+		if rec.Header().Get(name) != "" {
+			t.Errorf("%s leaked to client", name)
+		}
+	}
+}
+
+func TestProxyWebSocketUpgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if upgradeType(req.Header) != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+		//回显客户端发来的一行
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo:" + line)
+		rw.Flush()
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	r := NewRouter()
+	r.Handle(&Route{Name: "ws", PathPrefix: "/", Handler: NewProxy(lb, Options{})})
+	gw := httptest.NewServer(r)
+	defer gw.Close()
+
+	c, err := net.Dial("tcp", gw.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(c, "GET /chat HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		t.Fatalf("got %d %v", resp.StatusCode, resp.Header)
+	}
+	io.WriteString(c, "hello\n")
+	if line, _ := br.ReadString('\n'); line != "echo:hello\n" {
+		t.Fatalf("got %q", line)
+	}
+}
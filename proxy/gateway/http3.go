@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"crypto/tls"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultHTTP3IdleTimeout = 30 * time.Second
+	DefaultAltSvcMaxAge     = 24 * time.Hour
+)
+
+var requestLatency = metrics.NewHistogramVec("gateway_request_duration_ms", "监听端口处理请求的耗时，按协议(h1/h2/h3)统计", "protocol", metrics.LatencyBuckets)
+
+// 与 TCP 监听并行的 HTTP/3 (QUIC) 监听，使用相同的处理器与中间件，只对客户端生效，上游仍为 HTTP/1.1 或 h2
+type HTTP3Conf struct {
+	Addr          string        //UDP 监听地址，默认与 TCP 监听相同的地址和端口
+	IdleTimeout   time.Duration //QUIC 连接空闲超时，默认 DefaultHTTP3IdleTimeout
+	AdvertisePort int           //Alt-Svc 中宣告的端口，默认为实际监听的 UDP 端口，用于前面有端口转发的情况
+	AltSvcMaxAge  time.Duration //Alt-Svc 的 ma，默认 DefaultAltSvcMaxAge
+}
+
+func newHTTP3Server(conf *HTTP3Conf, tlsConf *tls.Config, handler http.Handler) *http3.Server {
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = DefaultHTTP3IdleTimeout
+	}
+	if conf.AltSvcMaxAge <= 0 {
+		conf.AltSvcMaxAge = DefaultAltSvcMaxAge
+	}
+	return &http3.Server{
+		Handler:    handler,
+		TLSConfig:  http3.ConfigureTLSConfig(tlsConf),
+		QUICConfig: &quic.Config{MaxIdleTimeout: conf.IdleTimeout},
+	}
+}
+
+// 在 TCP 监听之外启动 HTTP/3，返回 Alt-Svc 头的值
+func (s *Server) serveHTTP3(tcpAddr net.Addr) (string, error) {
+	addr := s.conf.HTTP3.Addr
+	if addr == "" {
+		addr = tcpAddr.String()
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := s.h3.Serve(pc); err != nil && err != http.ErrServerClosed && err != quic.ErrServerClosed {
+			fmt.Println("http3 serve error", err)
+		}
+	}()
+	port := s.conf.HTTP3.AdvertisePort
+	if port <= 0 {
+		port = pc.LocalAddr().(*net.UDPAddr).Port
+	}
+	return fmt.Sprintf(`h3=":%d"; ma=%d`, port, int(s.conf.HTTP3.AltSvcMaxAge.Seconds())), nil
+}
+
+func protocolLabel(req *http.Request) string {
+	switch req.ProtoMajor {
+	case 3:
+		return "h3"
+	case 2:
+		return "h2"
+	}
+	return "h1"
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/quic-go/quic-go/http3"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServerHTTP3(t *testing.T) {
+	cert, leaf := selfSignedCert(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Proto))
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(ServerConf{
+		Handler:   NewChain(RequestMetrics).Then(handler),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		HTTP3:     &HTTP3Conf{IdleTimeout: 5 * time.Second},
+	})
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	//TCP 上的响应通过 Alt-Svc 宣告同端口的 HTTP/3
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("https://" + l.Addr().String() + "/"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := `h3=":` + strconv.Itoa(port) + `"; ma=86400`; resp.Header.Get("Alt-Svc") != want {
+		t.Fatalf("alt-svc got %q want %q", resp.Header.Get("Alt-Svc"), want)
+	}
+
+	before := requestLatency.Get("h3").Count
+	h3 := &http3.RoundTripper{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer h3.Close()
+	resp, err = (&http.Client{Transport: h3, Timeout: 5 * time.Second}).Get("https://" + l.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "HTTP/3.0" || resp.Header.Get("Alt-Svc") != "" {
+		t.Fatalf("got %q alt-svc %q", body, resp.Header.Get("Alt-Svc"))
+	}
+	if requestLatency.Get("h3").Count != before+1 {
+		t.Fatal("h3 latency not labeled")
+	}
+
+	//客户端关闭连接后优雅关闭立即完成
+	h3.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != http.ErrServerClosed {
+		t.Fatalf("serve returned %v", err)
+	}
+	if _, err := (&http.Client{Transport: &http3.RoundTripper{TLSClientConfig: &tls.Config{RootCAs: pool}}, Timeout: 500 * time.Millisecond}).Get("https://" + l.Addr().String() + "/"); err == nil {
+		t.Fatal("http3 still served after shutdown")
+	}
+}
+
+func TestServerHTTP3RequiresTLS(t *testing.T) {
+	s := NewServer(ServerConf{Handler: echoBodyHandler(), HTTP3: &HTTP3Conf{}})
+	if s.h3 != nil {
+		t.Fatal("http3 enabled without tls")
+	}
+	if protocolLabel(&http.Request{ProtoMajor: 2}) != "h2" || protocolLabel(&http.Request{ProtoMajor: 1}) != "h1" {
+		t.Fatal("protocol labels")
+	}
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const DefaultJSONTransformMaxBytes = 1 << 20
+
+var errJSONDecode = errors.New("invalid json body")
+
+// 路由级的 JSON 报文改写：请求/响应为 JSON 对象且不超过大小上限时解码后交给钩子修改，再重新编码。
+// 非 JSON、非 utf-8、已压缩、带 trailer 或超过上限的报文原样透传
+type JSONTransform struct {
+	Request  func(req *http.Request, body map[string]interface{}) error
+	Response func(resp *http.Response, body map[string]interface{}) error
+
+	MaxBytes     int64 //可改写的报文上限，默认 DefaultJSONTransformMaxBytes
+	RejectOnFail bool  //解码失败时拒绝：请求返回 400，响应返回 502；默认原样透传
+}
+
+func (t *JSONTransform) maxBytes() int64 {
+	if t.MaxBytes > 0 {
+		return t.MaxBytes
+	}
+	return DefaultJSONTransformMaxBytes
+}
+
+// 改写请求体，返回错误时应以 400 拒绝请求
+func (t *JSONTransform) transformRequest(req *http.Request) error {
+	if t.Request == nil || req.Body == nil || req.Body == http.NoBody || !isJSON(req.Header) {
+		return nil
+	}
+	body, ok, err := t.transform(req.ContentLength, &req.Body, func(m map[string]interface{}) error {
+		return t.Request(req, m)
+	})
+	if err != nil || !ok {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+func (t *JSONTransform) transformResponse(resp *http.Response) error {
+	if t.Response == nil || resp.Body == nil || resp.Body == http.NoBody || !isJSON(resp.Header) {
+		return nil
+	}
+	//带 trailer 的响应不改写，保证 trailer 能原样转发
+	if len(resp.Trailer) > 0 {
+		return nil
+	}
+	body, ok, err := t.transform(resp.ContentLength, &resp.Body, func(m map[string]interface{}) error {
+		return t.Response(resp, m)
+	})
+	if err != nil || !ok {
+		return err
+	}
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// 读取、解码并调用钩子，ok 为 false 表示报文原样透传，此时 body 已恢复为可完整读取
+func (t *JSONTransform) transform(length int64, body *io.ReadCloser, hook func(map[string]interface{}) error) ([]byte, bool, error) {
+	max := t.maxBytes()
+	if length > max {
+		return nil, false, nil
+	}
+	orig := *body
+	data, err := io.ReadAll(io.LimitReader(orig, max+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > max {
+		*body = readCloser{io.MultiReader(bytes.NewReader(data), orig), orig}
+		return nil, false, nil
+	}
+	orig.Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		if t.RejectOnFail {
+			return nil, false, errJSONDecode
+		}
+		return nil, false, nil
+	}
+	m, isObject := v.(map[string]interface{})
+	if !isObject {
+		return nil, false, nil
+	}
+	if err := hook(m); err != nil {
+		return nil, false, err
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// application/json 或 +json 类型，且未压缩、字符集为 utf-8
+func isJSON(h http.Header) bool {
+	if enc := h.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return false
+	}
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return false
+	}
+	charset := strings.ToLower(params["charset"])
+	return charset == "" || charset == "utf-8" || charset == "utf8"
+}
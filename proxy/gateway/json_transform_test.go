@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 回显请求体，响应的 Content-Type 由 X-Reply-Type 指定
+func jsonEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		w.Header().Set("X-Request-Length", req.Header.Get("Content-Length"))
+		w.Header().Set("Content-Type", req.Header.Get("X-Reply-Type"))
+		w.Write(data)
+	}))
+}
+
+func jsonRouter(upstream string, t *JSONTransform) *Router {
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream)
+	r := NewRouter()
+	r.Handle(&Route{Name: "api", PathPrefix: "/", Handler: NewProxy(lb, Options{}), JSONTransform: t})
+	return r
+}
+
+func postJSON(h http.Handler, body, contentType, replyType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Reply-Type", replyType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJSONTransformResponse(t *testing.T) {
+	upstream := jsonEchoServer()
+	defer upstream.Close()
+	r := jsonRouter(upstream.URL, &JSONTransform{
+		Response: func(resp *http.Response, body map[string]interface{}) error {
+			delete(body, "internal")
+			body["served_by"] = BackendFromContext(resp.Request.Context())
+			return nil
+		},
+	})
+
+	for _, ct := range []string{"application/json", "application/json; charset=UTF-8", "application/problem+json"} {
+		rec := postJSON(r, `{"id":12345678901234567890,"internal":"secret"}`, "text/plain", ct)
+		var got map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v %q", ct, err, rec.Body)
+		}
+		if _, ok := got["internal"]; ok || got["served_by"] != upstream.URL {
+			t.Fatalf("%s: not transformed: %s", ct, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), "12345678901234567890") {
+			t.Fatalf("large number lost precision: %s", rec.Body)
+		}
+		if rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+			t.Fatalf("content-length %s for %d bytes", rec.Header().Get("Content-Length"), rec.Body.Len())
+		}
+	}
+	//非 JSON 与非 utf-8 字符集原样透传
+	for _, ct := range []string{"text/plain", "application/json; charset=iso-8859-1"} {
+		body := `{"internal":"secret"}`
+		if rec := postJSON(r, body, "text/plain", ct); rec.Body.String() != body {
+			t.Fatalf("%s: should bypass, got %q", ct, rec.Body)
+		}
+	}
+}
+
+func TestJSONTransformRequest(t *testing.T) {
+	upstream := jsonEchoServer()
+	defer upstream.Close()
+	r := jsonRouter(upstream.URL, &JSONTransform{
+		Request: func(req *http.Request, body map[string]interface{}) error {
+			body["tenant"] = req.Header.Get("X-Tenant")
+			return nil
+		},
+	})
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"a":1}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Tenant", "acme")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Body.String() != `{"a":1,"tenant":"acme"}` {
+		t.Fatalf("got %q", rec.Body)
+	}
+	if rec.Header().Get("X-Request-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Fatalf("upstream content-length %s", rec.Header().Get("X-Request-Length"))
+	}
+}
+
+func TestJSONTransformSizeCap(t *testing.T) {
+	upstream := jsonEchoServer()
+	defer upstream.Close()
+	called := false
+	r := jsonRouter(upstream.URL, &JSONTransform{
+		MaxBytes: 32,
+		Response: func(resp *http.Response, body map[string]interface{}) error { called = true; return nil },
+		Request:  func(req *http.Request, body map[string]interface{}) error { called = true; return nil },
+	})
+	big := `{"data":"` + strings.Repeat("x", 100) + `"}`
+	if rec := postJSON(r, big, "application/json", "application/json"); rec.Body.String() != big || called {
+		t.Fatalf("oversized body should bypass untouched, called %v", called)
+	}
+	//未知长度的请求体同样受上限约束
+	req := httptest.NewRequest("POST", "/", io.MultiReader(strings.NewReader(big)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Body.String() != big || called {
+		t.Fatalf("chunked oversized body should bypass untouched, called %v", called)
+	}
+}
+
+func TestJSONTransformDecodeFailure(t *testing.T) {
+	upstream := jsonEchoServer()
+	defer upstream.Close()
+	hook := func(resp *http.Response, body map[string]interface{}) error { return nil }
+	bad := `{"a":`
+
+	r := jsonRouter(upstream.URL, &JSONTransform{Response: hook})
+	if rec := postJSON(r, bad, "text/plain", "application/json"); rec.Code != http.StatusOK || rec.Body.String() != bad {
+		t.Fatalf("pass-through got %d %q", rec.Code, rec.Body)
+	}
+	r = jsonRouter(upstream.URL, &JSONTransform{Response: hook, RejectOnFail: true})
+	if rec := postJSON(r, bad, "text/plain", "application/json"); rec.Code != http.StatusBadGateway {
+		t.Fatalf("reject got %d", rec.Code)
+	}
+	r = jsonRouter(upstream.URL, &JSONTransform{Request: func(req *http.Request, body map[string]interface{}) error { return nil }, RejectOnFail: true})
+	if rec := postJSON(r, bad, "application/json", "text/plain"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("request reject got %d", rec.Code)
+	}
+}
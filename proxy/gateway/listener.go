@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errSlowClient = errors.New("client sending below minimum read rate")
+
+	openConns     = metrics.NewGaugeVec("gateway_active_connections", "监听器当前打开的连接数", "listener")
+	rejectedConns = metrics.NewCounterVec("gateway_rejected_connections_total", "超过最大连接数被直接关闭的连接数", "listener")
+	slowClients   = metrics.NewCounterVec("gateway_slow_clients_total", "请求体读取速率过低被断开的连接数", "listener")
+)
+
+// 限制并发连接数的监听器，超出的连接接受后立即关闭
+type limitListener struct {
+	net.Listener
+	name       string
+	maxConns   int64
+	minRate    int64
+	rateWindow time.Duration
+	conns      int64
+}
+
+func newLimitListener(l net.Listener, maxConns int, minRate int64, rateWindow time.Duration) *limitListener {
+	return &limitListener{
+		Listener:   l,
+		name:       l.Addr().String(),
+		maxConns:   int64(maxConns),
+		minRate:    minRate,
+		rateWindow: rateWindow,
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if n := atomic.AddInt64(&l.conns, 1); l.maxConns > 0 && n > l.maxConns {
+			atomic.AddInt64(&l.conns, -1)
+			rejectedConns.Inc(l.name)
+			c.Close()
+			continue
+		}
+		openConns.Inc(l.name)
+		return &slowConn{Conn: c, listener: l}, nil
+	}
+}
+
+// 当前打开的连接数
+func (l *limitListener) Conns() int {
+	return int(atomic.LoadInt64(&l.conns))
+}
+
+// 读取请求体期间限制最低读取速率的连接：
+// 按 rateWindow 分段统计读取字节数，每次读取前把读超时推到当前统计窗口结束，窗口内字节数低于下限时断开连接
+type slowConn struct {
+	net.Conn
+	listener *limitListener
+
+	mux          sync.Mutex
+	enforcing    bool
+	windowStart  time.Time
+	windowBytes  int64
+	readDeadline time.Time //http.Server 设置的读超时
+	closeOnce    sync.Once
+}
+
+// 开始读取请求体，开启速率检查
+func (c *slowConn) startBody() {
+	if c.listener.minRate <= 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.enforcing = true
+	c.windowStart = time.Now()
+	c.windowBytes = 0
+}
+
+// 请求体读取结束，恢复 http.Server 自己的读超时
+func (c *slowConn) endBody() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.enforcing {
+		return
+	}
+	c.enforcing = false
+	c.Conn.SetReadDeadline(c.readDeadline)
+}
+
+func (c *slowConn) Read(b []byte) (int, error) {
+	for {
+		c.mux.Lock()
+		enforcing := c.enforcing
+		windowEnd := c.windowStart.Add(c.listener.rateWindow)
+		if enforcing {
+			deadline := windowEnd
+			if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+				deadline = c.readDeadline
+			}
+			c.Conn.SetReadDeadline(deadline)
+		}
+		c.mux.Unlock()
+
+		n, err := c.Conn.Read(b)
+		if !enforcing {
+			return n, err
+		}
+
+		c.mux.Lock()
+		c.windowBytes += int64(n)
+		now := time.Now()
+		if !c.enforcing || now.Before(windowEnd) {
+			c.mux.Unlock()
+			return n, err
+		}
+		//统计窗口结束，检查速率后开始新窗口
+		min := c.listener.minRate * int64(c.listener.rateWindow) / int64(time.Second)
+		slow := c.windowBytes < min
+		c.windowStart = now
+		c.windowBytes = 0
+		serverTimeout := !c.readDeadline.IsZero() && !now.Before(c.readDeadline)
+		c.mux.Unlock()
+
+		if slow {
+			slowClients.Inc(c.listener.name)
+			c.Close()
+			return n, errSlowClient
+		}
+		var ne net.Error
+		if n == 0 && errors.As(err, &ne) && ne.Timeout() && !serverTimeout {
+			//窗口到期造成的超时，继续读
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *slowConn) SetReadDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.readDeadline = t
+	if c.enforcing {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *slowConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *slowConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(&c.listener.conns, -1)
+		openConns.Dec(c.listener.name)
+	})
+	return err
+}
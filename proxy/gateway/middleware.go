@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/middleware"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("gateway_requests_total", "网关处理的请求数，按状态码类别统计", "status_class")
+	statusLatency = metrics.NewHistogramVec("gateway_status_request_duration_ms", "请求从开始处理到最后一个字节写出的耗时，按状态码类别统计", "status_class", metrics.LatencyBuckets)
+	responseBytes = metrics.NewCounterVec("gateway_response_bytes_total", "写给客户端的响应体字节数，按状态码类别统计", "status_class")
+	routeRequests = metrics.NewCounterVec("gateway_route_requests_total", "按路由名统计的请求数，未匹配任何路由的请求记为 unmatched", "route")
+	routeErrors   = metrics.NewCounterVec("gateway_route_errors_total", "按路由名统计的 5xx 响应数", "route")
+	routeLatency  = metrics.NewHistogramVec("gateway_route_request_duration_ms", "按路由名统计的请求处理耗时", "route", metrics.LatencyBuckets)
+)
+
+// 没有匹配任何路由(404)的请求在路由指标中的标签值
+const UnmatchedRoute = "unmatched"
+
+// 中间件：包装下一个处理器，可以在调用 next 前后处理，也可以不调用 next 直接返回
+type Middleware func(http.Handler) http.Handler
+
+// 中间件链，先 Use 的在外层，即先执行：
+//
+//	NewChain(a, b).Use(c).Then(h) 的执行顺序为 a -> b -> c -> h
+//
+// Chain 不可变，Use 返回新的 Chain，可以安全地在多个路由间共享同一个基础链
+type Chain struct {
+	middlewares []Middleware
+}
+
+func NewChain(middlewares ...Middleware) Chain {
+	return Chain{middlewares: append([]Middleware(nil), middlewares...)}
+}
+
+func (c Chain) Use(middlewares ...Middleware) Chain {
+	list := make([]Middleware, 0, len(c.middlewares)+len(middlewares))
+	list = append(list, c.middlewares...)
+	list = append(list, middlewares...)
+	return Chain{middlewares: list}
+}
+
+// 把链应用到 h 上
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h
+}
+
+func (c Chain) Len() int {
+	return len(c.middlewares)
+}
+
+// 适配 middleware 包中带 Handler 方法的组件，如 AccessLog、RateLimiter、JWTAuth、BasicAuth、ACL
+func Adapt(m interface {
+	Handler(next http.Handler) http.Handler
+}) Middleware {
+	return m.Handler
+}
+
+// 按状态码类别统计请求数、耗时与响应体字节数，并按路由名统计请求数、5xx 数与耗时，
+// 全局与按路由名的状态码类别及单独统计的状态码见 metrics.RecordStatus。
+// 耗时从请求开始到 next 返回，即最后一个字节写出，包括 ErrorHandler 等网关自身写出的错误响应；
+// 路由标签只取配置的路由名，不使用请求路径；放在路由表外层时未匹配的请求记为 UnmatchedRoute，
+// 作为全局中间件(Router.Use)时未匹配的请求不经过它，不计入路由指标；不经过路由表的请求不计入路由指标；
+// dry run 请求(见 Router.Explain)不计入任何指标
+func RequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if middleware.IsDryRun(req.Context()) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		start := time.Now()
+		ctx, label := withRouteLabel(req.Context())
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req.WithContext(ctx))
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		class := strconv.Itoa(status/100) + "xx"
+		latency := sinceMs(start)
+		requestsTotal.Inc(class)
+		statusLatency.Observe(class, latency)
+		responseBytes.Add(class, sw.bytes)
+
+		route, routed := label.name, label.set
+		if !routed {
+			if r := RouteFromContext(req.Context()); r != nil {
+				route, routed = r.Name, true
+			}
+		}
+		if !routed {
+			metrics.RecordStatus("", status)
+			return
+		}
+		metrics.RecordStatus(route, status)
+		routeRequests.Inc(route)
+		if status >= 500 {
+			routeErrors.Inc(route)
+		}
+		routeLatency.Observe(route, latency)
+	})
+}
+
+// 单个路由的请求统计，路由名为 UnmatchedRoute 时为未匹配的请求
+type RouteStats struct {
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`     //5xx 响应数
+	ErrorRate    float64 `json:"error_rate"` //Errors/Requests
+	LatencyAvgMs float64 `json:"latency_avg_ms"`
+	LatencyP50Ms float64 `json:"latency_p50_ms"`
+	LatencyP99Ms float64 `json:"latency_p99_ms"`
+}
+
+// 经过 RequestMetrics 的请求按路由名的统计
+func RouteMetrics() map[string]RouteStats {
+	latency := routeLatency.Snapshot()
+	result := map[string]RouteStats{}
+	for route, requests := range routeRequests.Snapshot() {
+		stats := RouteStats{Requests: requests, Errors: routeErrors.Get(route)}
+		if requests > 0 {
+			stats.ErrorRate = float64(stats.Errors) / float64(requests)
+		}
+		if h, ok := latency[route]; ok {
+			stats.LatencyAvgMs, stats.LatencyP50Ms, stats.LatencyP99Ms = h.Average(), h.P50, h.P99
+		}
+		result[route] = stats
+	}
+	return result
+}
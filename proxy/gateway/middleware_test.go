@@ -0,0 +1,297 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 记录执行顺序的中间件
+func traceMiddleware(name string, trail *[]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			*trail = append(*trail, name)
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func headerMiddleware(value string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Layer", value)
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var trail []string
+	base := NewChain(traceMiddleware("a", &trail), traceMiddleware("b", &trail))
+	h := base.Use(traceMiddleware("c", &trail)).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		trail = append(trail, "handler")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got := strings.Join(trail, ","); got != "a,b,c,handler" {
+		t.Fatalf("order %s", got)
+	}
+	if base.Len() != 2 {
+		t.Fatal("Use should not modify the base chain")
+	}
+}
+
+func TestRouterGlobalAndRouteChains(t *testing.T) {
+	var trail []string
+	r := NewRouter()
+	r.Use(traceMiddleware("global", &trail), headerMiddleware("global"))
+	r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: okHandler,
+		Middlewares: []Middleware{traceMiddleware("route", &trail), headerMiddleware("route")}})
+	r.Handle(&Route{Name: "static", PathPrefix: "/", Handler: okHandler})
+
+	rec := serve(r, "GET", "/api/x", "10.0.0.1:1")
+	if got := strings.Join(trail, ","); got != "global,route" || rec.Header().Get("X-Layer") != "route" {
+		t.Fatalf("api trail %s layer %s", got, rec.Header().Get("X-Layer"))
+	}
+	trail = nil
+	rec = serve(r, "GET", "/index.html", "10.0.0.1:1")
+	if got := strings.Join(trail, ","); got != "global" || rec.Header().Get("X-Layer") != "global" {
+		t.Fatalf("static trail %s layer %s", got, rec.Header().Get("X-Layer"))
+	}
+
+	//后追加的全局中间件对已注册路由同样生效
+	r.Use(traceMiddleware("late", &trail))
+	trail = nil
+	serve(r, "GET", "/api/x", "10.0.0.1:1")
+	if got := strings.Join(trail, ","); got != "global,late,route" {
+		t.Fatalf("trail after Use %s", got)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	var hits int64
+	upstream := countingServer(&hits)
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+	r := NewRouter()
+	r.Use(RequestMetrics)
+	r.Handle(&Route{Name: "api", PathPrefix: "/", Handler: NewProxy(lb, Options{}), Middlewares: []Middleware{deny}})
+
+	before := requestsTotal.Get("4xx")
+	if rec := serve(r, "GET", "/", "10.0.0.1:1"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if atomic.LoadInt64(&hits) != 0 {
+		t.Fatal("short-circuited request reached the proxy")
+	}
+	if requestsTotal.Get("4xx") != before+1 {
+		t.Fatal("request metrics not recorded")
+	}
+}
+
+func TestRouterReload(t *testing.T) {
+	r := NewRouter()
+	r.Handle(&Route{Name: "old", PathPrefix: "/", Handler: okHandler, Middlewares: []Middleware{headerMiddleware("old")}})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				rec := serve(r, "GET", "/", "10.0.0.1:1")
+				//全局与路由中间件总是来自同一份配置
+				if layer := rec.Header().Get("X-Layer"); layer != "old" && layer != "new-route" {
+					t.Errorf("mixed config: %q", layer)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		r.Reload([]Middleware{headerMiddleware("new-global")}, []*Route{
+			{Name: "new", PathPrefix: "/", Handler: okHandler, Middlewares: []Middleware{headerMiddleware("new-route")}},
+		})
+	}
+	close(stop)
+	wg.Wait()
+	if routes := r.Routes(); len(routes) != 1 || routes[0].Name != "new" {
+		t.Fatalf("routes not replaced: %v", routes)
+	}
+}
+
+func TestRequestMetricsRoutes(t *testing.T) {
+	r := NewRouter()
+	r.Handle(&Route{Name: "metrics-api", PathPrefix: "/api", Handler: okHandler})
+	r.Handle(&Route{Name: "metrics-static", PathPrefix: "/static", Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})})
+	h := RequestMetrics(r)
+	before := RouteMetrics()
+
+	for _, target := range []string{"/api/users/1", "/api/users/2", "/static/app.js", "/missing/42"} {
+		serve(h, "GET", target, "10.0.0.1:1")
+	}
+	after := RouteMetrics()
+	for route, want := range map[string][2]int64{"metrics-api": {2, 0}, "metrics-static": {1, 1}, UnmatchedRoute: {1, 0}} {
+		got := after[route]
+		if got.Requests-before[route].Requests != want[0] || got.Errors-before[route].Errors != want[1] {
+			t.Errorf("%s: %+v before %+v", route, got, before[route])
+		}
+	}
+	if after["metrics-static"].ErrorRate != 1 {
+		t.Errorf("static error rate %v", after["metrics-static"].ErrorRate)
+	}
+
+	//标签值只有路由名，不出现请求路径
+	admin := NewAdmin(r)
+	rec := adminDo(admin.Handler(), "GET", "/metrics/routes", "")
+	var stats map[string]RouteStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["metrics-api"].Requests != after["metrics-api"].Requests {
+		t.Fatalf("json stats %+v", stats)
+	}
+	for route := range stats {
+		if strings.Contains(route, "/") {
+			t.Fatalf("raw path label %q", route)
+		}
+	}
+	series := scrapeMetrics(t, admin.Handler())
+	if series[`gateway_route_requests_total{route="metrics-api"}`] != float64(after["metrics-api"].Requests) ||
+		series[`gateway_route_request_duration_seconds_count{route="metrics-static"}`] == 0 {
+		t.Fatal("route series missing")
+	}
+	for name := range series {
+		if strings.HasPrefix(name, "gateway_route_") && strings.Contains(name, `="/`) {
+			t.Fatalf("raw path label %s", name)
+		}
+	}
+}
+
+// 经过反向代理的请求：正常响应、上游 5xx、连接失败(ErrorHandler)与没有可用后端
+func TestRequestMetricsProxy(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	dead := httptest.NewServer(okHandler)
+	dead.Close()
+
+	proxyTo := func(backends ...string) http.Handler {
+		lb := &load_balance.RoundRobinBalance{}
+		for _, backend := range backends {
+			lb.Add(backend)
+		}
+		return RequestMetrics(NewProxy(lb, Options{}))
+	}
+	classes := []string{"2xx", "5xx"}
+	requests, bytes, latency := map[string]int64{}, map[string]int64{}, map[string]int64{}
+	for _, class := range classes {
+		requests[class], bytes[class], latency[class] = requestsTotal.Get(class), responseBytes.Get(class), statusLatency.Get(class).Count
+	}
+	selections, noBackends, refused := lbSelections.Get(ok.URL), lbErrors.Get(CategoryNoBackends), proxyErrors.Get(CategoryConnectionRefused)
+
+	for _, c := range []struct {
+		h      http.Handler
+		status int
+	}{
+		{proxyTo(ok.URL), http.StatusOK},
+		{proxyTo(ok.URL), http.StatusOK},
+		{proxyTo(failing.URL), http.StatusInternalServerError},
+		{proxyTo(dead.URL), http.StatusBadGateway},
+		{proxyTo(), http.StatusServiceUnavailable},
+	} {
+		if rec := serve(c.h, "GET", "/", "10.0.0.1:1"); rec.Code != c.status {
+			t.Fatalf("got %d, want %d", rec.Code, c.status)
+		}
+	}
+
+	for class, want := range map[string]int64{"2xx": 2, "5xx": 3} {
+		if got := requestsTotal.Get(class) - requests[class]; got != want {
+			t.Errorf("%s requests %d, want %d", class, got, want)
+		}
+		if got := statusLatency.Get(class).Count - latency[class]; got != want {
+			t.Errorf("%s latency observations %d, want %d", class, got, want)
+		}
+	}
+	if got := responseBytes.Get("2xx") - bytes["2xx"]; got != 2*int64(len(body)) {
+		t.Errorf("2xx bytes %d", got)
+	}
+	//错误页的响应体同样计入
+	if responseBytes.Get("5xx") == bytes["5xx"] {
+		t.Error("5xx bytes not recorded")
+	}
+	if lbSelections.Get(ok.URL)-selections != 2 || lbErrors.Get(CategoryNoBackends)-noBackends != 1 {
+		t.Errorf("lb selections %d, lb errors %d", lbSelections.Get(ok.URL)-selections, lbErrors.Get(CategoryNoBackends)-noBackends)
+	}
+	if proxyErrors.Get(CategoryConnectionRefused)-refused != 1 {
+		t.Error("error handler path not recorded")
+	}
+}
+
+// 后端按路径返回状态码，经过路由与反向代理后按路由名与全局统计
+func TestRequestMetricsStatusCodes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/"))
+		w.WriteHeader(code)
+	}))
+	defer backend.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(backend.URL)
+	r := NewRouter()
+	r.Handle(&Route{Name: "status-stub", PathPrefix: "/", Handler: NewProxy(lb, Options{})})
+	h := RequestMetrics(r)
+	before := metrics.StatusCodes.Snapshot().Total
+
+	for _, code := range []int{200, 201, 304, 400, 401, 404, 429, 429, 500, 502, 503, 504} {
+		if rec := serve(h, "GET", "/"+strconv.Itoa(code), "10.0.0.1:1"); rec.Code != code {
+			t.Fatalf("got %d, want %d", rec.Code, code)
+		}
+	}
+	s := metrics.StatusCodes.Snapshot()
+	wantClasses := map[string]int64{"2xx": 2, "3xx": 1, "4xx": 5, "5xx": 4}
+	wantCodes := map[int]int64{401: 1, 404: 1, 429: 2, 502: 1, 503: 1, 504: 1}
+	if got := s.Routes["status-stub"]; !reflect.DeepEqual(got.Classes, wantClasses) || !reflect.DeepEqual(got.Codes, wantCodes) {
+		t.Fatalf("route %+v", got)
+	}
+	for class, want := range wantClasses {
+		if got := s.Total.Classes[class] - before.Classes[class]; got != want {
+			t.Errorf("total %s %d, want %d", class, got, want)
+		}
+	}
+	for code, want := range wantCodes {
+		if got := s.Total.Codes[code] - before.Codes[code]; got != want {
+			t.Errorf("total %d %d, want %d", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/middleware"
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultMirrorMaxBodyBytes = 1 << 20
+	DefaultMirrorTimeout      = 5 * time.Second
+	DefaultMirrorMaxInflight  = 100
+)
+
+var (
+	mirrorResponses = metrics.NewCounterVec("gateway_mirror_responses_total", "镜像请求结果，按状态码统计，error 表示请求失败", "status")
+	mirrorLatency   = metrics.NewCounterVec("gateway_mirror_latency_ms_total", "镜像请求累计耗时，按状态码统计，Prometheus 中换算为秒", "status")
+	mirrorSkipped   = metrics.NewCounterVec("gateway_mirror_skipped_total", "未镜像的请求数，按原因统计", "reason")
+)
+
+type MirrorConf struct {
+	LB           load_balance.LoadBalance //影子后端池
+	Percent      float64                  //镜像比例，0-100
+	MaxBodyBytes int64                    //可镜像的请求体上限，超过则不镜像，默认 DefaultMirrorMaxBodyBytes
+	Timeout      time.Duration            //镜像请求超时，默认 DefaultMirrorTimeout
+	MaxInflight  int                      //进行中的镜像请求上限，超过则丢弃，默认 DefaultMirrorMaxInflight
+	Transport    http.RoundTripper        //默认 DefaultTransport
+}
+
+// 流量镜像：异步复制一部分请求到影子后端池并丢弃响应，不影响主请求
+type Mirror struct {
+	conf     MirrorConf
+	inflight chan struct{}
+	rand     func() float64
+}
+
+func NewMirror(conf MirrorConf) *Mirror {
+	if conf.MaxBodyBytes <= 0 {
+		conf.MaxBodyBytes = DefaultMirrorMaxBodyBytes
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultMirrorTimeout
+	}
+	if conf.MaxInflight <= 0 {
+		conf.MaxInflight = DefaultMirrorMaxInflight
+	}
+	if conf.Transport == nil {
+		conf.Transport = DefaultTransport
+	}
+	return &Mirror{
+		conf:     conf,
+		inflight: make(chan struct{}, conf.MaxInflight),
+		rand:     rand.Float64,
+	}
+}
+
+func (m *Mirror) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if m.conf.Percent <= 0 || m.rand()*100 >= m.conf.Percent {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if req.ContentLength > m.conf.MaxBodyBytes {
+			mirrorSkipped.Inc("body_too_large")
+			next.ServeHTTP(w, req)
+			return
+		}
+		var body []byte
+		if req.Body != nil && req.Body != http.NoBody {
+			buf, err := io.ReadAll(io.LimitReader(req.Body, m.conf.MaxBodyBytes+1))
+			//读到的部分放回请求体，主请求仍能读到完整内容或原始错误
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+			if err != nil || int64(len(buf)) > m.conf.MaxBodyBytes {
+				mirrorSkipped.Inc("body_too_large")
+				next.ServeHTTP(w, req)
+				return
+			}
+			body = buf
+		}
+		select {
+		case m.inflight <- struct{}{}:
+		default:
+			mirrorSkipped.Inc("overload")
+			next.ServeHTTP(w, req)
+			return
+		}
+		shadow := m.shadowRequest(req, body)
+		go func() {
+			defer func() { <-m.inflight }()
+			m.send(shadow, body)
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// 在主请求被后续处理修改前复制出镜像请求
+func (m *Mirror) shadowRequest(req *http.Request, body []byte) *http.Request {
+	shadow := &http.Request{
+		Method:     req.Method,
+		URL:        cloneURL(req.URL),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     req.Header.Clone(),
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+	}
+	if shadow.Header == nil {
+		shadow.Header = http.Header{}
+	}
+	removeHopHeaders(shadow.Header, false)
+	shadow.Header.Set("X-Gateway-Mirror", "true")
+	return shadow
+}
+
+func (m *Mirror) send(shadow *http.Request, body []byte) {
+	start := time.Now()
+	status := "error"
+	defer func() {
+		mirrorResponses.Inc(status)
+		mirrorLatency.Add(status, time.Since(start).Milliseconds())
+	}()
+	addr, err := m.conf.LB.Get(middleware.ClientIP(shadow))
+	if err != nil || addr == "" {
+		return
+	}
+	target, err := url.Parse(addr)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.conf.Timeout)
+	defer cancel()
+	shadow = shadow.WithContext(ctx)
+	shadow.URL.Scheme = target.Scheme
+	shadow.URL.Host = target.Host
+	shadow.URL.Path = singleJoiningSlash(target.Path, shadow.URL.Path)
+	shadow.ContentLength = int64(len(body))
+	if len(body) > 0 {
+		shadow.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := m.conf.Transport.RoundTrip(shadow)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	status = strconv.Itoa(resp.StatusCode)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func cloneURL(u *url.URL) *url.URL {
+	u2 := *u
+	if u.User != nil {
+		user := *u.User
+		u2.User = &user
+	}
+	return &u2
+}
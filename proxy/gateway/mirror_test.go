@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mirrorRoute(primary string, mirror *Mirror) *Router {
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(primary)
+	router := NewRouter()
+	router.Handle(&Route{Name: "api", PathPrefix: "/", Handler: NewProxy(lb, Options{}), Mirror: mirror})
+	return router
+}
+
+func postThrough(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/echo?a=1", strings.NewReader(body))
+	req.Header.Set("X-Test", "1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMirrorCopiesRequest(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		w.Header().Set("X-Upstream", "primary")
+		w.Write([]byte("primary:" + string(b)))
+	}))
+	defer primary.Close()
+	got := make(chan *http.Request, 1)
+	gotBody := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		got <- req
+		gotBody <- string(b)
+		w.Write([]byte("shadow response must be discarded"))
+	}))
+	defer shadow.Close()
+	shadowLb := &load_balance.RoundRobinBalance{}
+	shadowLb.Add(shadow.URL)
+
+	plain := postThrough(mirrorRoute(primary.URL, nil), "hello")
+	before := mirrorResponses.Get("200")
+	mirrored := postThrough(mirrorRoute(primary.URL, NewMirror(MirrorConf{LB: shadowLb, Percent: 100})), "hello")
+	if plain.Code != mirrored.Code || plain.Body.String() != mirrored.Body.String() || mirrored.Header().Get("X-Upstream") != "primary" {
+		t.Fatalf("mirroring changed response: %d %q vs %d %q", plain.Code, plain.Body, mirrored.Code, mirrored.Body)
+	}
+	select {
+	case req := <-got:
+		if req.Method != "POST" || req.URL.Path != "/echo" || req.URL.RawQuery != "a=1" || req.Header.Get("X-Test") != "1" || req.Header.Get("X-Gateway-Mirror") != "true" {
+			t.Fatalf("unexpected shadow request %s %s %v", req.Method, req.URL, req.Header)
+		}
+		if body := <-gotBody; body != "hello" {
+			t.Fatalf("shadow body %q", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow request not sent")
+	}
+	deadline := time.Now().Add(time.Second)
+	for mirrorResponses.Get("200") == before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mirrorResponses.Get("200") != before+1 {
+		t.Fatal("mirror result not recorded")
+	}
+}
+
+func TestMirrorFailuresSwallowed(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(w, req.Body)
+	}))
+	defer primary.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slow.Close()
+
+	for _, addr := range []string{refusedAddr(t), slow.URL} {
+		shadowLb := &load_balance.RoundRobinBalance{}
+		shadowLb.Add(addr)
+		h := mirrorRoute(primary.URL, NewMirror(MirrorConf{LB: shadowLb, Percent: 100, Timeout: 200 * time.Millisecond}))
+		start := time.Now()
+		rec := postThrough(h, "payload")
+		if rec.Code != http.StatusOK || rec.Body.String() != "payload" {
+			t.Fatalf("%s: got %d %q", addr, rec.Code, rec.Body)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatalf("%s: shadow added latency %s", addr, d)
+		}
+	}
+	//镜像池为空
+	h := mirrorRoute(primary.URL, NewMirror(MirrorConf{LB: &load_balance.RoundRobinBalance{}, Percent: 100}))
+	if rec := postThrough(h, "payload"); rec.Body.String() != "payload" {
+		t.Fatalf("empty shadow pool got %q", rec.Body)
+	}
+}
+
+func TestMirrorSkipsLargeBody(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(w, req.Body)
+	}))
+	defer primary.Close()
+	shadowLb := &load_balance.RoundRobinBalance{}
+	shadowLb.Add(refusedAddr(t))
+	m := NewMirror(MirrorConf{LB: shadowLb, Percent: 100, MaxBodyBytes: 4})
+	h := mirrorRoute(primary.URL, m)
+
+	before := mirrorSkipped.Get("body_too_large")
+	//未知长度的请求体，读到上限后放回
+	req := httptest.NewRequest("POST", "/", io.MultiReader(strings.NewReader("0123"), strings.NewReader("456789")))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Body.String() != "0123456789" {
+		t.Fatalf("primary body truncated: %q", rec.Body)
+	}
+	if mirrorSkipped.Get("body_too_large") != before+1 {
+		t.Fatal("large body should not be mirrored")
+	}
+}
+
+func TestMirrorPercent(t *testing.T) {
+	m := NewMirror(MirrorConf{LB: &load_balance.RoundRobinBalance{}, Percent: 30})
+	m.rand = func() float64 { return 0.5 }
+	sent := false
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { sent = true }))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !sent || len(m.inflight) != 0 {
+		t.Fatal("request outside sample should pass through without mirroring")
+	}
+}
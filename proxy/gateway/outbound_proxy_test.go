@@ -0,0 +1,162 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// 进程内的正向代理，支持 CONNECT 隧道与绝对地址转发，要求 Basic 认证，记录经过的目标
+type forwardProxy struct {
+	*httptest.Server
+	mux     sync.Mutex
+	targets []string
+}
+
+func newForwardProxy(t *testing.T) *forwardProxy {
+	p := &forwardProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		p.mux.Lock()
+		p.targets = append(p.targets, req.Method+" "+req.Host)
+		p.mux.Unlock()
+		if req.Method != http.MethodConnect {
+			req.RequestURI = ""
+			req.Header.Del("Proxy-Authorization")
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		go func() {
+			io.Copy(upstream, buf)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *forwardProxy) seen() []string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *forwardProxy) authURL() string {
+	u, _ := url.Parse(p.URL)
+	u.User = url.UserPassword("user", "secret")
+	return u.String()
+}
+
+func proxyGetVia(t *testing.T, r *TransportRegistry, backend string) int {
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(backend)
+	rec := httptest.NewRecorder()
+	//经代理转发的 HTTP 请求以 Host 头构造绝对地址，这里让 Host 与后端一致
+	NewProxy(lb, Options{Transport: r}).ServeHTTP(rec, httptest.NewRequest("GET", backend+"/", nil))
+	return rec.Code
+}
+
+func TestOutboundProxyPerBackend(t *testing.T) {
+	fp := newForwardProxy(t)
+	secure := httptest.NewTLSServer(tlsOK)
+	defer secure.Close()
+	plain := httptest.NewServer(tlsOK)
+	defer plain.Close()
+	direct := httptest.NewServer(tlsOK)
+	defer direct.Close()
+
+	tlsConf, _ := NewUpstreamTLSConfig(UpstreamTLSConf{CAPEM: certPEM(secure.Certificate())})
+	r := NewTransportRegistry(nil)
+	r.Set(secure.URL, TransportConf{TLSConfig: tlsConf, OutboundProxy: &OutboundProxy{URL: fp.authURL()}})
+	r.Set(plain.URL, TransportConf{OutboundProxy: &OutboundProxy{URL: fp.authURL()}})
+
+	//HTTPS 后端经 CONNECT 隧道
+	if code := proxyGetVia(t, r, secure.URL); code != http.StatusOK {
+		t.Fatalf("https via proxy got %d", code)
+	}
+	//HTTP 后端按绝对地址转发
+	if code := proxyGetVia(t, r, plain.URL); code != http.StatusOK {
+		t.Fatalf("http via proxy got %d", code)
+	}
+	//未配置的后端直连
+	if code := proxyGetVia(t, r, direct.URL); code != http.StatusOK {
+		t.Fatalf("direct got %d", code)
+	}
+	seen := fp.seen()
+	secureHost, plainHost := secure.Listener.Addr().String(), plain.Listener.Addr().String()
+	if len(seen) != 2 || seen[0] != "CONNECT "+secureHost || seen[1] != "GET "+plainHost {
+		t.Fatalf("proxy saw %v", seen)
+	}
+
+	//NO_PROXY 中的后端绕过代理
+	r.Set(plain.URL, TransportConf{OutboundProxy: &OutboundProxy{URL: fp.authURL(), NoProxy: []string{"127.0.0.0/8"}}})
+	if code := proxyGetVia(t, r, plain.URL); code != http.StatusOK || len(fp.seen()) != 2 {
+		t.Fatalf("no_proxy backend went through proxy: %d %v", code, fp.seen())
+	}
+
+	//认证失败时请求失败，不会绕过代理
+	r.Set(plain.URL, TransportConf{OutboundProxy: &OutboundProxy{URL: fp.URL}})
+	if code := proxyGetVia(t, r, plain.URL); code != http.StatusProxyAuthRequired {
+		t.Fatalf("unauthenticated proxy request got %d", code)
+	}
+	r.Set(plain.URL, TransportConf{OutboundProxy: &OutboundProxy{URL: "proxy.corp:3128"}})
+	if code := proxyGetVia(t, r, plain.URL); code != http.StatusBadGateway {
+		t.Fatalf("invalid proxy url got %d", code)
+	}
+}
+
+func TestOutboundProxyBypass(t *testing.T) {
+	p := &OutboundProxy{NoProxy: []string{"internal.corp", ".svc.local", "10.0.0.0/8", "192.168.1.1", "api.example.com:8443"}}
+	cases := map[string]bool{
+		"http://internal.corp/":          true,
+		"http://a.internal.corp/":        true,
+		"http://notinternal.corp/":       false,
+		"http://svc.local/":              false,
+		"http://db.svc.local/":           true,
+		"http://10.1.2.3:8080/":          true,
+		"http://192.168.1.1/":            true,
+		"http://192.168.1.2/":            false,
+		"https://api.example.com:8443/":  true,
+		"https://api.example.com/":       false,
+		"http://public.example.com:443/": false,
+	}
+	for target, want := range cases {
+		u, _ := url.Parse(target)
+		if got := p.bypass(u); got != want {
+			t.Errorf("%s: got %v want %v", target, got, want)
+		}
+	}
+	u, _ := url.Parse("http://anything/")
+	if !(&OutboundProxy{NoProxy: []string{"*"}}).bypass(u) {
+		t.Fatal("* should bypass everything")
+	}
+}
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+var (
+	poolHits     = metrics.NewCounterVec("gateway_pool_hits_total", "复用连接池中已有连接的上游请求数", "backend")
+	poolMisses   = metrics.NewCounterVec("gateway_pool_misses_total", "需要新建连接的上游请求数", "backend")
+	poolIdle     = metrics.NewGaugeVec("gateway_pool_idle_conns", "连接池中空闲连接数的近似值，不包含空闲超时被关闭的连接", "backend")
+	upstreamTTFB = metrics.NewHistogramVec("gateway_upstream_ttfb_ms", "从发起上游请求到收到响应首字节的耗时", "backend", metrics.LatencyBuckets)
+)
+
+// 通过 httptrace 统计连接复用、空闲连接与首字节耗时，按后端区分，并记录每次尝试的结果(见 recordUpstreamResult)
+type poolStatsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *poolStatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backend := BackendFromContext(req.Context())
+	if backend == "" {
+		backend = req.URL.Host
+	}
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				poolHits.Inc(backend)
+			} else {
+				poolMisses.Inc(backend)
+			}
+			if info.WasIdle {
+				poolIdle.Dec(backend)
+			}
+		},
+		PutIdleConn: func(err error) {
+			if err == nil {
+				poolIdle.Inc(backend)
+			}
+		},
+		GotFirstResponseByte: func() {
+			upstreamTTFB.Observe(backend, sinceMs(start))
+		},
+	}
+	ctx := httptrace.WithClientTrace(req.Context(), trace)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	return recordUpstreamResult(backend, start, resp, err)
+}
+
+// 单个后端的连接池统计
+type PoolStats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	IdleConns int64   `json:"idle_conns"`
+	TTFBAvgMs float64 `json:"ttfb_avg_ms"`
+}
+
+func BackendPoolStats(backend string) PoolStats {
+	stats := PoolStats{
+		Hits:      poolHits.Get(backend),
+		Misses:    poolMisses.Get(backend),
+		IdleConns: poolIdle.Get(backend),
+	}
+	if ttfb := upstreamTTFB.Get(backend); ttfb.Count > 0 {
+		stats.TTFBAvgMs = ttfb.Sum / float64(ttfb.Count)
+	}
+	return stats
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPoolStats(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	p := NewProxy(lb, Options{})
+
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d got %d", i, rec.Code)
+		}
+	}
+	//首个请求新建连接，之后都复用
+	stats := BackendPoolStats(upstream.URL)
+	if stats.Misses != 1 || stats.Hits != 4 {
+		t.Fatalf("got %+v", stats)
+	}
+	if stats.IdleConns != 1 {
+		t.Fatalf("idle conns %d", stats.IdleConns)
+	}
+	if ttfb := upstreamTTFB.Get(upstream.URL); ttfb.Count != 5 {
+		t.Fatalf("ttfb samples %d", ttfb.Count)
+	}
+
+	admin := NewAdmin(nil)
+	admin.AddPool("api", lb)
+	rec := adminDo(admin.Handler(), "GET", "/backends", "")
+	var pools []adminPool
+	if err := json.Unmarshal(rec.Body.Bytes(), &pools); err != nil {
+		t.Fatal(err)
+	}
+	if pool := pools[0].Backends[0].ConnPool; pool == nil || pool.Hits != 4 || pool.Misses != 1 {
+		t.Fatalf("admin pool stats %s", rec.Body)
+	}
+}
@@ -0,0 +1,428 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/middleware"
+	"GO_GATEWAY/proxy/rate_limiter"
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errBackendLimited = errors.New("backend rate limited")
+
+	backendReselects = metrics.NewCounterVec("gateway_backend_reselects_total", "后端被限流后重新选择的次数", "backend")
+	backendSheds     = metrics.NewCounterVec("gateway_backend_sheds_total", "后端被限流后直接拒绝的次数", "backend")
+	backendInflight  = metrics.NewGaugeVec("gateway_backend_inflight_requests", "后端进行中的请求数", "backend")
+	lbSelections     = metrics.NewCounterVec(metrics.LBSelectionsMetric, "负载均衡选中后端的次数，不包含连接失败后的重试", "backend")
+	lbErrors         = metrics.NewCounterVec("gateway_lb_errors_total", "负载均衡选择后端失败的次数，按错误类别统计", "category")
+
+	defaultDialer = NewDialer(DialerConf{}) //连接超时、长连接超时使用 DefaultDialTimeout、DefaultDialKeepAlive
+
+	DefaultTransport = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment, //HTTP_PROXY/HTTPS_PROXY/NO_PROXY，按后端覆盖见 TransportConf.OutboundProxy
+		DialContext:           defaultDialer.DialContext,
+		DialTLSContext:        defaultDialer.DialTLSContext(nil),
+		MaxIdleConns:          100,              //最大空闲连接
+		IdleConnTimeout:       90 * time.Second, //空闲超时时间
+		TLSHandshakeTimeout:   10 * time.Second, //tls握手超时时间
+		ExpectContinueTimeout: 1 * time.Second,  //100-continue状态码超时时间
+	}
+)
+
+type Options struct {
+	Transport      http.RoundTripper
+	BackendLimiter *rate_limiter.BackendLimiter //按后端限流，nil 表示不限流
+
+	ErrorPrefix          string      //非200响应体追加的前缀，为空不处理
+	ErrorBufferThreshold int64       //错误响应体小于该值时缓冲处理，默认 DefaultErrorBufferThreshold
+	ErrorPages           *ErrorPages //网关自身错误的错误页，默认使用内置模板
+
+	//全局转发钩子，在内置的地址改写、错误响应处理之后执行，先于路由的钩子
+	Director       func(req *http.Request)
+	ModifyResponse func(resp *http.Response) error
+
+	FlushInterval         time.Duration //响应体 flush 间隔，0 表示不定时 flush，流式响应总是立即 flush
+	StreamingContentTypes []string      //流式响应类型，默认 DefaultStreamingContentTypes
+
+	Debug DebugHeaders //在响应头中标注后端、路由与重试次数，默认关闭
+
+	Tracing TracingOptions //OpenTelemetry 链路追踪，默认关闭
+	Sticky  StickyConf     //网关下发 cookie 的会话保持，默认关闭
+
+	RetryAfter time.Duration //503 响应的 Retry-After，默认 DefaultRetryAfter
+
+	Timeout       time.Duration //单个请求的默认超时(包括重试)，0 表示不限制
+	TimeoutHeader string        //采信客户端指定超时的请求头，如 X-Request-Timeout，为空表示不采信
+
+	BodyIdleTimeout time.Duration //上游响应体多久没有数据时中断，与总超时独立，0 表示不检查
+
+	MaxRetries          int         //连接失败时换后端重试的次数，0 表示不重试
+	RetryPolicy         RetryPolicy //哪些请求可以重试，默认只重试幂等请求
+	BodyMemoryThreshold int64       //重试时请求体在内存中缓冲的上限，默认 DefaultBodyMemoryThreshold
+	BodyMaxBuffer       int64       //请求体缓冲上限，超过则不缓冲也不重试，默认 DefaultBodyMaxBuffer
+	BodySpillDir        string      //请求体临时文件目录，默认系统临时目录
+}
+
+// 基于负载均衡的反向代理：先选出后端，再交给 httputil.ReverseProxy 转发
+type Proxy struct {
+	lb           load_balance.LoadBalance
+	opts         Options
+	reverseProxy *httputil.ReverseProxy
+	tracing      *tracing
+	sticky       *stickySessions
+	debug        *debugHeaders
+	transport    http.RoundTripper //单次转发使用的 transport，重试在其之上进行
+}
+
+func NewProxy(lb load_balance.LoadBalance, opts Options) *Proxy {
+	if opts.Transport == nil {
+		opts.Transport = DefaultTransport
+	}
+	if opts.ErrorPages == nil {
+		opts.ErrorPages, _ = NewErrorPages("", false)
+	}
+	if opts.StreamingContentTypes == nil {
+		opts.StreamingContentTypes = DefaultStreamingContentTypes
+	}
+	if opts.RetryAfter < time.Second {
+		opts.RetryAfter = DefaultRetryAfter
+	}
+	if opts.BodyMemoryThreshold <= 0 {
+		opts.BodyMemoryThreshold = DefaultBodyMemoryThreshold
+	}
+	if opts.BodyMaxBuffer <= 0 {
+		opts.BodyMaxBuffer = DefaultBodyMaxBuffer
+	}
+	p := &Proxy{lb: lb, opts: opts}
+	if debug, err := newDebugHeaders(opts.Debug); err != nil {
+		fmt.Println("debug headers init error", err)
+	} else {
+		p.debug = debug
+	}
+	if sticky, err := newStickySessions(opts.Sticky, lb); err != nil {
+		fmt.Println("sticky sessions init error", err)
+	} else {
+		p.sticky = sticky
+	}
+	var transport http.RoundTripper = &poolStatsTransport{next: newRouteTransports(opts.Transport)}
+	if t, err := newTracing(opts.Tracing); err != nil {
+		fmt.Println("tracing init error", err)
+	} else if t != nil {
+		p.tracing = t
+		transport = &tracingTransport{tracing: t, next: transport}
+	}
+	p.transport = transport
+	p.reverseProxy = &httputil.ReverseProxy{
+		Director:       p.director,
+		Transport:      roundTripperFunc(p.roundTrip),
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+		FlushInterval:  opts.FlushInterval,
+	}
+	return p
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if p.tracing != nil {
+		var span trace.Span
+		req, span = p.tracing.startServerSpan(req)
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() { endServerSpan(span, sw.status) }()
+		w = sw
+	}
+	if route := RouteFromContext(req.Context()); route != nil && route.JSONTransform != nil {
+		if err := route.JSONTransform.transformRequest(req); err != nil {
+			p.opts.ErrorPages.Render(w, req, http.StatusBadRequest, err)
+			return
+		}
+	}
+	addr := ""
+	if p.sticky != nil {
+		addr = p.sticky.backend(req, p.lb)
+		//粘性后端同样受后端限流约束，被限流时重新选择并改写 cookie
+		if limiter := p.opts.BackendLimiter; addr != "" && limiter != nil && !limiter.Allow(addr) {
+			addr = ""
+		}
+	}
+	if addr == "" {
+		var err error
+		addr, err = p.selectBackend(req)
+		if err != nil {
+			lbErrors.Inc(errorCategory(err))
+			p.writeError(w, req, err)
+			return
+		}
+		if p.sticky != nil {
+			http.SetCookie(w, p.sticky.cookie(addr))
+		}
+	}
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("gateway.backend", addr))
+	if p.mayRetry(req) {
+		cleanup, err := bufferRequestBody(req, p.opts.BodyMemoryThreshold, p.opts.BodyMaxBuffer, p.opts.BodySpillDir)
+		defer cleanup()
+		if err != nil {
+			p.errorHandler(w, req, err)
+			return
+		}
+	}
+	lbSelections.Inc(addr)
+	backendInflight.Inc(addr)
+	ctx := withUpstreamState(withBackend(req.Context(), addr), addr)
+	//重试会把进行中的请求转到新的后端，结束时减去最终使用的后端
+	defer func() {
+		backend, _ := UpstreamFromContext(ctx)
+		backendInflight.Dec(backend)
+	}()
+	if timeout := p.requestTimeout(req); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	p.reverseProxy.ServeHTTP(&streamWriter{ResponseWriter: w, p: p, state: upstreamStateFromContext(ctx)}, req.WithContext(ctx))
+}
+
+// 连接失败时排除已失败的后端重新选择并重放请求体，请求体无法重放时不重试
+func (p *Proxy) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.transport.RoundTrip(req)
+	excludingLb, ok := p.lb.(load_balance.ExcludingBalance)
+	if !ok {
+		return resp, err
+	}
+	excluded := map[string]bool{}
+	for attempt := 1; err != nil && attempt <= p.opts.MaxRetries; attempt++ {
+		if req.Context().Err() != nil || (req.Body != nil && req.GetBody == nil) {
+			break
+		}
+		if responseWritten(req.Context()) || !p.shouldRetry(req, err) {
+			break
+		}
+		excluded[BackendFromContext(req.Context())] = true
+		addr, lbErr := excludingLb.GetExcluding(middleware.ClientIP(req), excluded)
+		if lbErr != nil {
+			break
+		}
+		if limiter := p.opts.BackendLimiter; limiter != nil && !limiter.Allow(addr) {
+			excluded[addr] = true
+			continue
+		}
+		retry := req.Clone(withBackend(req.Context(), addr))
+		retargetURL(retry, addr)
+		if req.Body != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				break
+			}
+		}
+		backendInflight.Dec(BackendFromContext(req.Context()))
+		backendInflight.Inc(addr)
+		req = retry
+		setUpstreamAttempt(req.Context(), addr, attempt+1)
+		resp, err = p.transport.RoundTrip(req)
+	}
+	return resp, err
+}
+
+// 关闭链路追踪导出等后台资源
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	if p.tracing != nil {
+		return p.tracing.shutdown(ctx)
+	}
+	return nil
+}
+
+// 选择后端，选中的后端被限流时按配置重新选择或拒绝
+func (p *Proxy) selectBackend(req *http.Request) (string, error) {
+	key := middleware.ClientIP(req)
+	addr, err := p.lb.Get(key)
+	if err != nil {
+		return "", err
+	}
+	if addr == "" {
+		return "", load_balance.ErrNoBackends
+	}
+	limiter := p.opts.BackendLimiter
+	if limiter == nil || limiter.Allow(addr) {
+		return addr, nil
+	}
+	excludingLb, ok := p.lb.(load_balance.ExcludingBalance)
+	if limiter.Mode == rate_limiter.BackendLimitShed || !ok {
+		backendSheds.Inc(addr)
+		return "", errBackendLimited
+	}
+	excluded := map[string]bool{addr: true}
+	for {
+		backendReselects.Inc(addr)
+		next, err := excludingLb.GetExcluding(key, excluded)
+		if err != nil {
+			backendSheds.Inc(addr)
+			return "", errBackendLimited
+		}
+		if limiter.Allow(next) {
+			return next, nil
+		}
+		excluded[next] = true
+		addr = next
+	}
+}
+
+func (p *Proxy) director(req *http.Request) {
+	rewriteURL(req, req.URL, BackendFromContext(req.Context()))
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+	if p.opts.Director != nil {
+		p.opts.Director(req)
+	}
+	if route := RouteFromContext(req.Context()); route != nil && route.Director != nil {
+		route.Director(req)
+	}
+	//钩子之后统一去掉逐跳头，协议升级请求保留 Upgrade 交给 ReverseProxy 处理
+	removeHopHeaders(req.Header, true)
+}
+
+// 按后端地址改写请求地址，src 为转发前的原始地址
+func rewriteURL(req *http.Request, src *url.URL, backend string) {
+	target, err := url.Parse(backend)
+	if err != nil {
+		return
+	}
+	u := *src
+	targetQuery := target.RawQuery
+	u.Scheme = target.Scheme
+	u.Host = target.Host
+	u.Path = singleJoiningSlash(target.Path, src.Path)
+	if targetQuery == "" || src.RawQuery == "" {
+		u.RawQuery = targetQuery + src.RawQuery
+	} else {
+		u.RawQuery = targetQuery + "&" + src.RawQuery
+	}
+	req.URL = &u
+}
+
+// 重试换后端时只替换已经过 Director 改写的地址中的协议与主机，保留钩子改写后的路径与参数
+func retargetURL(req *http.Request, backend string) {
+	target, err := url.Parse(backend)
+	if err != nil {
+		return
+	}
+	u := *req.URL
+	u.Scheme = target.Scheme
+	u.Host = target.Host
+	req.URL = &u
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		removeHopHeaders(resp.Header, false)
+	}
+	p.debug.stamp(resp.Header, resp.Request)
+	route := RouteFromContext(resp.Request.Context())
+	watchResponseBody(resp, p.bodyIdleTimeout(route))
+	if route != nil {
+		if err := limitResponse(resp, route); err != nil {
+			return err
+		}
+	}
+	if p.isStreaming(resp.Header) {
+		return nil
+	}
+	if err := DecorateErrorResponse(resp, p.opts.ErrorPrefix, p.opts.ErrorBufferThreshold); err != nil {
+		return err
+	}
+	if p.opts.ModifyResponse != nil {
+		if err := p.opts.ModifyResponse(resp); err != nil {
+			return err
+		}
+	}
+	if route == nil {
+		return nil
+	}
+	if route.JSONTransform != nil {
+		if err := route.JSONTransform.transformResponse(resp); err != nil {
+			return &routeHookError{err: err}
+		}
+	}
+	if route.SubFilter != nil {
+		route.SubFilter.transformResponse(resp)
+	}
+	if route.ModifyResponse != nil {
+		if err := route.ModifyResponse(resp); err != nil {
+			return &routeHookError{err: err}
+		}
+	}
+	return nil
+}
+
+// 路由 ModifyResponse 钩子返回的错误，交给路由自己的 ErrorHandler
+type routeHookError struct {
+	err error
+}
+
+func (e *routeHookError) Error() string { return e.err.Error() }
+
+func (e *routeHookError) Unwrap() error { return e.err }
+
+func (p *Proxy) errorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	var hookErr *routeHookError
+	if errors.As(err, &hookErr) {
+		if route := RouteFromContext(req.Context()); route != nil && route.ErrorHandler != nil {
+			route.ErrorHandler(w, req, hookErr.err)
+			return
+		}
+		err = hookErr.err
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		middleware.WriteBodyTooLarge(w, maxErr.Limit)
+		return
+	}
+	p.writeError(w, req, err)
+}
+
+// 按错误类别输出 502/503/504 并计数，客户端已断开时只记录不写出
+func (p *Proxy) writeError(w http.ResponseWriter, req *http.Request, err error) {
+	//请求被超时或客户端取消时，传输层返回的错误不一定包装了上下文错误
+	if ctxErr := req.Context().Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		err = fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	status, category := ClassifyError(err)
+	proxyErrors.Inc(category)
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("gateway.error_class", category))
+	if status == StatusClientClosedRequest {
+		fmt.Println("client closed request", req.Method, req.URL.Path, err)
+		return
+	}
+	p.debug.stamp(w.Header(), req)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(int(p.opts.RetryAfter/time.Second)))
+	}
+	p.opts.ErrorPages.Render(w, req, status, err)
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
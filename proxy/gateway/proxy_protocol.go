@@ -0,0 +1,270 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/middleware"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("malformed proxy protocol header")
+
+	proxyProtoRejected = metrics.NewCounterVec("gateway_proxy_protocol_rejected_total", "PROXY protocol 头不合法或来源不受信任被断开的连接数", "reason")
+)
+
+type ProxyProtocolConf struct {
+	TrustedPeers  []string      //允许发送 PROXY 头的对端地址或网段，其他来源的连接直接断开
+	HeaderTimeout time.Duration //读取 PROXY 头的超时，默认 DefaultProxyHeaderTimeout
+}
+
+// 解析 PROXY protocol v1/v2 头的监听器，连接的 RemoteAddr 替换为头中的客户端地址。
+// 头在第一次 Read 或 RemoteAddr 时解析，不阻塞 Accept
+type proxyProtoListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func newProxyProtoListener(l net.Listener, conf ProxyProtocolConf) (*proxyProtoListener, error) {
+	pl := &proxyProtoListener{Listener: l, timeout: conf.HeaderTimeout}
+	if pl.timeout <= 0 {
+		pl.timeout = DefaultProxyHeaderTimeout
+	}
+	for _, item := range conf.TrustedPeers {
+		ipNet, err := middleware.ParseCIDROrIP(item)
+		if err != nil {
+			return nil, err
+		}
+		pl.trusted = append(pl.trusted, ipNet)
+	}
+	return pl, nil
+}
+
+func (l *proxyProtoListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.isTrusted(c.RemoteAddr()) {
+			proxyProtoRejected.Inc("untrusted")
+			c.Close()
+			continue
+		}
+		return &proxyProtoConn{Conn: c, r: bufio.NewReader(c), timeout: l.timeout}, nil
+	}
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyProtoConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remoteAddr, c.localAddr, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			proxyProtoRejected.Inc("malformed")
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// 读取 v1 或 v2 头，LOCAL/UNKNOWN 类型的头返回空地址，表示使用连接本身的地址
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	prefix, err := r.Peek(len(proxyV2Signature))
+	if bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, errProxyHeader
+}
+
+// PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n，最长 107 字节
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errProxyHeader
+	}
+	src, err := parseProxyAddrV1(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyAddrV1(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyAddrV1(proto, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, errProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	verCmd, fam := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, nil, errProxyHeader
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	switch verCmd & 0x0f {
+	case 0x0: //LOCAL，负载均衡自己的健康检查等连接
+		return nil, nil, nil
+	case 0x1: //PROXY
+	default:
+		return nil, nil, errProxyHeader
+	}
+	var ipLen int
+	switch fam >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default: //UNSPEC 或 unix socket，不改写地址
+		return nil, nil, nil
+	}
+	if fam&0x0f != 0x1 && fam&0x0f != 0x2 {
+		return nil, nil, errProxyHeader
+	}
+	if len(body) < ipLen*2+4 {
+		return nil, nil, errProxyHeader
+	}
+	src := &net.TCPAddr{IP: net.IP(body[:ipLen]), Port: int(binary.BigEndian.Uint16(body[ipLen*2:]))}
+	dst := &net.TCPAddr{IP: net.IP(body[ipLen : ipLen*2]), Port: int(binary.BigEndian.Uint16(body[ipLen*2+2:]))}
+	return src, dst, nil
+}
+
+// 向上游写出 PROXY 头，供 TCP 转发时让后端拿到真实客户端地址，version 为 1 或 2
+func WriteProxyHeader(w io.Writer, version int, src, dst net.Addr) error {
+	srcAddr, ok1 := src.(*net.TCPAddr)
+	dstAddr, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return errors.New("proxy protocol requires tcp addresses")
+	}
+	srcIP, dstIP := srcAddr.IP.To4(), dstAddr.IP.To4()
+	v4 := srcIP != nil && dstIP != nil
+	if !v4 {
+		srcIP, dstIP = srcAddr.IP.To16(), dstAddr.IP.To16()
+	}
+	switch version {
+	case 1:
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, srcAddr.Port, dstAddr.Port)
+		return err
+	case 2:
+		buf := bytes.NewBuffer(append([]byte{}, proxyV2Signature...))
+		fam := byte(0x21)
+		if v4 {
+			fam = 0x11
+		}
+		buf.Write([]byte{0x21, fam})
+		binary.Write(buf, binary.BigEndian, uint16(len(srcIP)*2+4))
+		buf.Write(srcIP)
+		buf.Write(dstIP)
+		binary.Write(buf, binary.BigEndian, uint16(srcAddr.Port))
+		binary.Write(buf, binary.BigEndian, uint16(dstAddr.Port))
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return fmt.Errorf("unsupported proxy protocol version %d", version)
+}
@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func remoteAddrHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.RemoteAddr)
+	})
+}
+
+// 先写入 PROXY 头再发送请求，返回响应体；连接被断开时返回错误
+func proxyProtoRequest(addr string, header []byte) (string, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	c.Write(header)
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+func v2Header(t *testing.T, src, dst string) []byte {
+	srcAddr, _ := net.ResolveTCPAddr("tcp", src)
+	dstAddr, _ := net.ResolveTCPAddr("tcp", dst)
+	var buf bytes.Buffer
+	if err := WriteProxyHeader(&buf, 2, srcAddr, dstAddr); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	_, addr := startServer(t, ServerConf{Handler: remoteAddrHandler(), ProxyProtocol: &ProxyProtocolConf{TrustedPeers: []string{"127.0.0.1"}}})
+	cases := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"), "192.0.2.1:56324"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 4711 443\r\n"), "[2001:db8::1]:4711"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "127.0.0.1"},
+		{"v2 tcp4", v2Header(t, "198.51.100.7:1234", "10.0.0.1:80"), "198.51.100.7:1234"},
+		{"v2 tcp6", v2Header(t, "[2001:db8::cafe]:5000", "[2001:db8::1]:443"), "[2001:db8::cafe]:5000"},
+		//LOCAL 命令使用连接本身的地址
+		{"v2 local", append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0x00, 0x00), "127.0.0.1"},
+	}
+	for _, c := range cases {
+		got, err := proxyProtoRequest(addr, c.header)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got != c.want && !(c.want == "127.0.0.1" && bytes.HasPrefix([]byte(got), []byte("127.0.0.1:"))) {
+			t.Fatalf("%s: remote addr %s want %s", c.name, got, c.want)
+		}
+	}
+
+	//不合法的头直接断开连接
+	for _, header := range [][]byte{
+		nil,
+		[]byte("PROXY TCP4 999.0.0.1 192.0.2.2 1 2\r\n"),
+		[]byte("PROXY TCP4 2001:db8::1 192.0.2.2 1 2\r\n"),
+		append(append([]byte{}, proxyV2Signature...), 0x21, 0x11, 0x00, 0x04, 1, 2, 3, 4),
+	} {
+		before := proxyProtoRejected.Get("malformed")
+		if got, err := proxyProtoRequest(addr, header); err == nil {
+			t.Fatalf("malformed header %q served: %s", header, got)
+		}
+		if proxyProtoRejected.Get("malformed") != before+1 {
+			t.Fatalf("malformed header %q not counted", header)
+		}
+	}
+}
+
+func TestProxyProtocolUntrustedPeer(t *testing.T) {
+	_, addr := startServer(t, ServerConf{Handler: remoteAddrHandler(), ProxyProtocol: &ProxyProtocolConf{TrustedPeers: []string{"10.0.0.0/8"}}})
+	before := proxyProtoRejected.Get("untrusted")
+	if got, err := proxyProtoRequest(addr, []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")); err == nil {
+		t.Fatalf("untrusted peer served: %s", got)
+	}
+	if proxyProtoRejected.Get("untrusted") != before+1 {
+		t.Fatal("untrusted peer not counted")
+	}
+}
+
+func TestWriteProxyHeaderV1(t *testing.T) {
+	var buf bytes.Buffer
+	WriteProxyHeader(&buf, 1, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}, &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 80})
+	if buf.String() != "PROXY TCP4 192.0.2.1 192.0.2.2 1000 80\r\n" {
+		t.Fatalf("got %q", buf.String())
+	}
+	src, _, err := readProxyHeader(bufio.NewReader(&buf))
+	if err != nil || src.String() != "192.0.2.1:1000" {
+		t.Fatalf("round trip %v %v", src, err)
+	}
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/rate_limiter"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func countingServer(counter *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(counter, 1)
+	}))
+}
+
+func TestBackendLimiterReselect(t *testing.T) {
+	var fragileHits, otherHits int64
+	fragile := countingServer(&fragileHits)
+	defer fragile.Close()
+	other := countingServer(&otherHits)
+	defer other.Close()
+
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(fragile.URL)
+	lb.Add(other.URL)
+	limiter := rate_limiter.NewBackendLimiter(rate_limiter.BackendLimitReselect)
+	limiter.SetLimit(fragile.URL, 0.001, 5)
+	p := NewProxy(lb, Options{BackendLimiter: limiter})
+
+	for i := 0; i < 40; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d got %d", i, rec.Code)
+		}
+	}
+	if fragileHits > 5 {
+		t.Fatalf("fragile backend got %d requests, limit 5", fragileHits)
+	}
+	if otherHits != 40-fragileHits {
+		t.Fatalf("other backend got %d requests", otherHits)
+	}
+	if backendReselects.Get(fragile.URL) == 0 {
+		t.Fatal("reselects should be recorded")
+	}
+}
+
+func TestBackendLimiterShed(t *testing.T) {
+	var hits int64
+	fragile := countingServer(&hits)
+	defer fragile.Close()
+
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(fragile.URL)
+	limiter := rate_limiter.NewBackendLimiter(rate_limiter.BackendLimitShed)
+	limiter.SetLimit(fragile.URL, 0.001, 2)
+	p := NewProxy(lb, Options{BackendLimiter: limiter})
+
+	codes := map[int]int{}
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		codes[rec.Code]++
+	}
+	if codes[http.StatusOK] != 2 || codes[http.StatusServiceUnavailable] != 3 {
+		t.Fatalf("unexpected codes %v", codes)
+	}
+	if backendSheds.Get(fragile.URL) != 3 {
+		t.Fatalf("sheds = %d", backendSheds.Get(fragile.URL))
+	}
+
+	//运行时调整限流
+	limiter.SetLimit(fragile.URL, 0, 0)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("limit removed but got %d", rec.Code)
+	}
+}
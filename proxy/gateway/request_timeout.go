@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 计算本次请求的超时，优先级：客户端请求头(不超过路由上限) > 路由配置 > 全局默认，0 表示不限制
+func (p *Proxy) requestTimeout(req *http.Request) time.Duration {
+	timeout := p.opts.Timeout
+	var max time.Duration
+	if route := RouteFromContext(req.Context()); route != nil {
+		if route.Timeout > 0 {
+			timeout = route.Timeout
+		}
+		max = route.MaxTimeout
+	}
+	if p.opts.TimeoutHeader == "" {
+		return timeout
+	}
+	d, ok := parseTimeoutHeader(req.Header.Get(p.opts.TimeoutHeader))
+	if !ok {
+		return timeout
+	}
+	//路由没有配置上限时，请求头只能缩短超时
+	if max <= 0 {
+		max = timeout
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// 支持 Go 的时长格式(如 1.5s、300ms)或毫秒数
+func parseTimeoutHeader(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if ms <= 0 {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
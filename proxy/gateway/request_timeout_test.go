@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeoutPrecedence(t *testing.T) {
+	p := NewProxy(&load_balance.RoundRobinBalance{}, Options{Timeout: 25 * time.Second, TimeoutHeader: "X-Request-Timeout"})
+	search := &Route{Name: "search", Timeout: 100 * time.Millisecond}
+	export := &Route{Name: "export", Timeout: 5 * time.Minute, MaxTimeout: 10 * time.Minute}
+	cases := []struct {
+		route  *Route
+		header string
+		want   time.Duration
+	}{
+		{nil, "", 25 * time.Second},
+		{search, "", 100 * time.Millisecond},
+		{export, "", 5 * time.Minute},
+		{export, "8m", 8 * time.Minute},
+		{export, "20m", 10 * time.Minute}, //不超过路由上限
+		{export, "1500", 1500 * time.Millisecond},
+		{search, "2s", 100 * time.Millisecond}, //没有上限时只能缩短
+		{search, "50ms", 50 * time.Millisecond},
+		{search, "-1s", 100 * time.Millisecond},
+		{search, "soon", 100 * time.Millisecond},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		if c.route != nil {
+			req = req.WithContext(withRoute(req.Context(), c.route))
+		}
+		if c.header != "" {
+			req.Header.Set("X-Request-Timeout", c.header)
+		}
+		if got := p.requestTimeout(req); got != c.want {
+			t.Errorf("route %v header %q: got %v want %v", c.route, c.header, got, c.want)
+		}
+	}
+
+	//未配置请求头时不采信客户端
+	p = NewProxy(&load_balance.RoundRobinBalance{}, Options{Timeout: time.Second})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Timeout", "10ms")
+	if got := p.requestTimeout(req); got != time.Second {
+		t.Fatalf("untrusted header applied: %v", got)
+	}
+}
+
+func TestRequestTimeoutGatewayTimeout(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+			cancelled <- struct{}{}
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	r := NewRouter()
+	r.Handle(&Route{Name: "slow", PathPrefix: "/", Handler: NewProxy(lb, Options{Timeout: 5 * time.Second, TimeoutHeader: "X-Request-Timeout"}), MaxTimeout: time.Second})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Timeout", "100ms")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("timeout not applied, took %v", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request not cancelled")
+	}
+}
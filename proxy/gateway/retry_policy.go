@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 默认可以重试的幂等方法
+var DefaultRetryMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+
+// 连接失败时的重试策略。已经向客户端写出过响应的请求无论如何都不会重试
+type RetryPolicy struct {
+	Methods           []string //可以重试的方法，默认 DefaultRetryMethods
+	IdempotencyHeader string   //带该请求头的请求视为幂等，任何方法都可以重试，默认 Idempotency-Key
+
+	//自定义判断，不为空时替代按方法、幂等头与路由配置的判断
+	ShouldRetry func(req *http.Request, err error) bool
+}
+
+func (rp *RetryPolicy) idempotent(req *http.Request) bool {
+	methods := rp.Methods
+	if methods == nil {
+		methods = DefaultRetryMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, req.Method) {
+			return true
+		}
+	}
+	header := rp.IdempotencyHeader
+	if header == "" {
+		header = "Idempotency-Key"
+	}
+	if req.Header.Get(header) != "" {
+		return true
+	}
+	route := RouteFromContext(req.Context())
+	return route != nil && route.RetryNonIdempotent
+}
+
+// 请求是否可能被重试，决定是否需要缓冲请求体
+func (p *Proxy) mayRetry(req *http.Request) bool {
+	if p.opts.MaxRetries <= 0 {
+		return false
+	}
+	return p.opts.RetryPolicy.ShouldRetry != nil || p.opts.RetryPolicy.idempotent(req)
+}
+
+func (p *Proxy) shouldRetry(req *http.Request, err error) bool {
+	if p.opts.RetryPolicy.ShouldRetry != nil {
+		return p.opts.RetryPolicy.ShouldRetry(req, err)
+	}
+	return p.opts.RetryPolicy.idempotent(req)
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 第一次尝试必然连接失败的代理
+func retryProxy(t *testing.T, upstream string, opts Options) *Proxy {
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream)
+	lb.Add(refusedAddr(t)) //轮询从第二个后端开始
+	opts.MaxRetries = 1
+	return NewProxy(lb, opts)
+}
+
+func TestRetryPolicyMethods(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+	cases := []struct {
+		method string
+		header string
+		want   int
+	}{
+		{"GET", "", http.StatusOK},
+		{"HEAD", "", http.StatusOK},
+		{"OPTIONS", "", http.StatusOK},
+		{"PUT", "", http.StatusOK},
+		{"DELETE", "", http.StatusOK},
+		{"POST", "", http.StatusBadGateway},
+		{"PATCH", "", http.StatusBadGateway},
+		{"POST", "order-42", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/", strings.NewReader("body"))
+		if c.header != "" {
+			req.Header.Set("Idempotency-Key", c.header)
+		}
+		rec := httptest.NewRecorder()
+		retryProxy(t, upstream.URL, Options{}).ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s key=%q: got %d want %d", c.method, c.header, rec.Code, c.want)
+		}
+	}
+
+	//路由显式允许非幂等请求重试
+	r := NewRouter()
+	r.Handle(&Route{Name: "payments", PathPrefix: "/", Handler: retryProxy(t, upstream.URL, Options{}), RetryNonIdempotent: true})
+	if rec := serve(r, "POST", "/", "1.1.1.1:1"); rec.Code != http.StatusOK {
+		t.Fatalf("route opt-in got %d", rec.Code)
+	}
+
+	//自定义判断替代默认规则
+	var seenErr error
+	p := retryProxy(t, upstream.URL, Options{RetryPolicy: RetryPolicy{ShouldRetry: func(req *http.Request, err error) bool {
+		seenErr = err
+		return req.Method == "PATCH"
+	}}})
+	for method, want := range map[string]int{"PATCH": http.StatusOK, "GET": http.StatusBadGateway} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+		if rec.Code != want {
+			t.Errorf("predicate %s: got %d want %d", method, rec.Code, want)
+		}
+	}
+	var opErr interface{ Timeout() bool }
+	if !errors.As(seenErr, &opErr) {
+		t.Fatalf("predicate got %v", seenErr)
+	}
+}
+
+func TestRetryAfterBytesWritten(t *testing.T) {
+	var hits int
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits++
+	}))
+	defer good.Close()
+	//先发出 103 Early Hints 再断开连接
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		rw.WriteString("HTTP/1.1 103 Early Hints\r\nLink: </app.css>; rel=preload\r\n\r\n")
+		rw.Flush()
+		conn.Close()
+	}))
+	defer broken.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(good.URL)
+	lb.Add(broken.URL)
+	p := NewProxy(lb, Options{MaxRetries: 1})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if hits != 0 {
+		t.Fatalf("request retried after 1xx was written to client")
+	}
+	if rec.Code != http.StatusEarlyHints && rec.Code != http.StatusBadGateway {
+		t.Fatalf("got %d", rec.Code)
+	}
+}
+
+// 重试换后端时保留全局与路由 Director 改写后的路径与参数
+func TestRetryKeepsDirectorRewrite(t *testing.T) {
+	var got string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.URL.RequestURI()
+	}))
+	defer upstream.Close()
+	p := retryProxy(t, upstream.URL, Options{Director: func(req *http.Request) {
+		req.URL.Path = strings.Replace(req.URL.Path, "/api/", "/v2/", 1)
+	}})
+	r := NewRouter()
+	r.Handle(&Route{Name: "rewrite", PathPrefix: "/api", Handler: p, Director: func(req *http.Request) {
+		q := req.URL.Query()
+		q.Set("tenant", "a")
+		req.URL.RawQuery = q.Encode()
+	}})
+	if rec := serve(r, "GET", "/api/users?id=1", "10.0.0.1:1"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if got != "/v2/users?id=1&tenant=a" {
+		t.Fatalf("retried request went to %q", got)
+	}
+}
+
+// 重试后进行中的请求计入新的后端，摘除状态依赖该值
+func TestRetryMovesInflight(t *testing.T) {
+	var failed, served string
+	var failedInflight, servedInflight int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		failedInflight, servedInflight = backendInflight.Get(failed), backendInflight.Get(served)
+	}))
+	defer upstream.Close()
+	p := retryProxy(t, upstream.URL, Options{})
+	servers := p.lb.(*load_balance.RoundRobinBalance).Servers()
+	served, failed = servers[0], servers[1]
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if failedInflight != 0 || servedInflight != 1 {
+		t.Fatalf("during retry: failed %d served %d", failedInflight, servedInflight)
+	}
+	if backendInflight.Get(failed) != 0 || backendInflight.Get(served) != 0 {
+		t.Fatalf("after request: failed %d served %d", backendInflight.Get(failed), backendInflight.Get(served))
+	}
+}
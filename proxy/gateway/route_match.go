@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+)
+
+// 请求头或查询参数的匹配条件：
+// Absent 为 true 时要求不存在；Regexp 不为空时任一取值匹配正则即可；Value 不为空时要求任一取值相等；否则只要求存在
+type Matcher struct {
+	Name   string
+	Value  string
+	Regexp *regexp.Regexp
+	Absent bool
+}
+
+func (m *Matcher) match(values []string) bool {
+	if m.Absent {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	if m.Regexp == nil && m.Value == "" {
+		return true
+	}
+	for _, v := range values {
+		if m.Regexp != nil && m.Regexp.MatchString(v) || m.Regexp == nil && v == m.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// 注册路由时把请求头条件的名称转为规范格式，匹配时直接查 map，避免每次请求分配内存
+func canonicalHeaderMatchers(route *Route) {
+	for i := range route.Headers {
+		route.Headers[i].Name = textproto.CanonicalMIMEHeaderKey(route.Headers[i].Name)
+	}
+}
+
+// 路由的请求头与查询参数条件，全部满足才算匹配。查询参数在第一次用到时解析，同一请求只解析一次
+func (route *Route) matchParams(req *http.Request, query *url.Values) bool {
+	for i := range route.Headers {
+		m := &route.Headers[i]
+		if !m.match(req.Header[m.Name]) {
+			return false
+		}
+	}
+	if len(route.Query) == 0 {
+		return true
+	}
+	if *query == nil {
+		*query = req.URL.Query()
+	}
+	for i := range route.Query {
+		m := &route.Query[i]
+		if !m.match((*query)[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 路由级的连接参数，在后端(或默认) transport 的基础上覆盖，零值字段沿用原配置
+type RouteTransport struct {
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration //如上传路由需要更长的等待时间
+	MaxIdleConnsPerHost   int
+	ForceAttemptHTTP2     bool //向 HTTPS 后端协商 HTTP/2
+	DisableCompression    bool //不自动请求 gzip，原样透传后端的响应体
+}
+
+type routeTransportKey struct {
+	base *http.Transport
+	conf RouteTransport
+}
+
+// 按 (基础 transport, 路由参数) 缓存派生的 transport，同一路由的请求复用同一个连接池，参数相同的路由共用一个 transport。
+// 注册表替换后端的 transport 后，旧的派生 transport 不再被使用，其空闲连接按 IdleConnTimeout 关闭
+type routeTransports struct {
+	next http.RoundTripper
+
+	mux   sync.Mutex
+	cache map[routeTransportKey]*http.Transport
+}
+
+func newRouteTransports(next http.RoundTripper) *routeTransports {
+	return &routeTransports{next: next, cache: map[routeTransportKey]*http.Transport{}}
+}
+
+func (t *routeTransports) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := RouteFromContext(req.Context())
+	if route == nil || route.Transport == nil {
+		return t.next.RoundTrip(req)
+	}
+	var base *http.Transport
+	switch next := t.next.(type) {
+	case *http.Transport:
+		base = next
+	case *TransportRegistry:
+		base = next.Transport(req.URL.Host)
+	default:
+		//自定义的 RoundTripper 无法派生，忽略路由参数
+		return t.next.RoundTrip(req)
+	}
+	return t.get(base, *route.Transport).RoundTrip(req)
+}
+
+func (t *routeTransports) get(base *http.Transport, conf RouteTransport) *http.Transport {
+	key := routeTransportKey{base: base, conf: conf}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	rt, ok := t.cache[key]
+	if !ok {
+		rt = newRouteTransport(base, conf)
+		t.cache[key] = rt
+	}
+	return rt
+}
+
+func newRouteTransport(base *http.Transport, conf RouteTransport) *http.Transport {
+	t := base.Clone()
+	dialer := defaultDialer
+	if conf.DialTimeout > 0 {
+		dialer = NewDialer(DialerConf{Timeout: conf.DialTimeout})
+		t.DialContext = dialer.DialContext
+	}
+	if conf.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = conf.ResponseHeaderTimeout
+	}
+	if conf.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+	if conf.DisableCompression {
+		t.DisableCompression = true
+	}
+	if conf.ForceAttemptHTTP2 {
+		t.ForceAttemptHTTP2 = true
+		//自定义 DialTLSContext 需要自己通过 ALPN 协商 h2，此时连接超时使用路由的配置或默认值
+		cfg := &tls.Config{}
+		if t.TLSClientConfig != nil {
+			cfg = t.TLSClientConfig.Clone()
+		}
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+		t.TLSClientConfig = cfg
+	}
+	if t.DialTLSContext != nil && (dialer != defaultDialer || conf.ForceAttemptHTTP2) {
+		t.DialTLSContext = dialer.DialTLSContext(t.TLSClientConfig)
+	}
+	return t
+}
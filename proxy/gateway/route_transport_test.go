@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRouteTransportOverrides(t *testing.T) {
+	//后端 100ms 后才返回响应头，并回显收到请求的协议版本
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(strconv.Itoa(req.ProtoMajor)))
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	tlsConf, _ := NewUpstreamTLSConfig(UpstreamTLSConf{CAPEM: certPEM(upstream.Certificate())})
+	registry := NewTransportRegistry(nil)
+	registry.Set(upstream.URL, TransportConf{TLSConfig: tlsConf, ResponseHeaderTimeout: 50 * time.Millisecond})
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	p := NewProxy(lb, Options{Transport: registry})
+	r := NewRouter()
+	r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: p})
+	r.Handle(&Route{Name: "uploads", PathPrefix: "/uploads", Handler: p, Transport: &RouteTransport{ResponseHeaderTimeout: time.Second}})
+	r.Handle(&Route{Name: "search", PathPrefix: "/search", Handler: p, Transport: &RouteTransport{ResponseHeaderTimeout: time.Second, ForceAttemptHTTP2: true}})
+
+	//同一后端，未覆盖的路由使用后端配置的超时
+	if rec := serve(r, "GET", "/api/x", "1.1.1.1:1"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("api route got %d", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := serve(r, "GET", "/uploads/x", "1.1.1.1:1"); rec.Code != http.StatusOK || rec.Body.String() != "1" {
+			t.Fatalf("uploads route got %d %q", rec.Code, rec.Body.String())
+		}
+		if rec := serve(r, "GET", "/search?q=1", "1.1.1.1:1"); rec.Code != http.StatusOK || rec.Body.String() != "2" {
+			t.Fatalf("search route got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+
+	//每组路由参数只派生一个 transport，并继承后端的 TLS 配置
+	rts := p.transport.(*poolStatsTransport).next.(*routeTransports)
+	if len(rts.cache) != 2 {
+		t.Fatalf("got %d derived transports", len(rts.cache))
+	}
+	base := registry.Transport(upstream.URL)
+	uploads := rts.get(base, RouteTransport{ResponseHeaderTimeout: time.Second})
+	if uploads.ResponseHeaderTimeout != time.Second || uploads.TLSClientConfig.RootCAs == nil || base.ResponseHeaderTimeout != 50*time.Millisecond {
+		t.Fatalf("overrides not applied on a copy: %v %v", uploads.ResponseHeaderTimeout, base.ResponseHeaderTimeout)
+	}
+	if len(rts.cache) != 2 {
+		t.Fatal("transport not reused")
+	}
+	//后端连接被复用，而不是每个请求新建
+	if hits := poolHits.Get(upstream.URL); hits < 4 {
+		t.Fatalf("pool hits %d", hits)
+	}
+}
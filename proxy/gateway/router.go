@@ -0,0 +1,246 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/middleware"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 路由：按 Host、路径前缀以及可选的请求头、查询参数条件匹配，条件之间为"且"的关系，可挂载仅对本路由生效的中间件
+type Route struct {
+	Name         string
+	Host         string //为空表示匹配任意 Host
+	PathPrefix   string
+	Headers      []Matcher //请求头条件，如 X-Tenant: acme
+	Query        []Matcher //查询参数条件，如 version=beta
+	Handler      http.Handler
+	Middlewares  []Middleware //路由中间件，在全局中间件之后执行
+	MaxBodyBytes int64        //请求体大小上限，0 表示使用路由表默认值，负数表示不限制
+	Methods      []string     //允许的请求方法，nil 表示使用路由表默认值
+
+	RetryNonIdempotent bool //允许 POST 等非幂等请求在连接失败时重试，Handler 为 *Proxy 时生效
+
+	Timeout    time.Duration //请求超时，0 表示使用 Proxy 的默认值
+	MaxTimeout time.Duration //客户端通过请求头指定超时的上限
+
+	MaxResponseBytes int64             //响应体大小上限，0 表示不限制，Handler 为 *Proxy 时生效
+	ResponseLimit    ResponseLimitMode //响应体超限时的处理方式
+	BodyIdleTimeout  time.Duration     //响应体读取的空闲超时，0 表示使用 Proxy 的默认值，负数表示不检查，SSE 等流式路由可调大或关闭
+	Mirror           *Mirror           //流量镜像，在路由中间件之后执行，nil 表示不镜像
+	Transport        *RouteTransport   //路由级的连接参数，nil 表示使用后端的 transport，Handler 为 *Proxy 时生效
+
+	//路由级的转发钩子，Handler 为 *Proxy 时生效，在内置逻辑与 Options 中的全局钩子之后执行
+	Director       func(req *http.Request)
+	ModifyResponse func(resp *http.Response) error
+	ErrorHandler   func(w http.ResponseWriter, req *http.Request, err error) //处理 ModifyResponse 返回的错误，为空时交给 Proxy 的错误处理
+	JSONTransform  *JSONTransform                                            //JSON 报文改写，响应改写先于 ModifyResponse
+	SubFilter      *SubFilter                                                //响应体文本替换，在 JSON 改写之后、ModifyResponse 之前执行
+}
+
+// 路由表，最长路径前缀优先；前缀相同时指定 Host 的路由优先，再按请求头与查询参数条件数从多到少，
+// 条件数相同时先注册的优先。
+// 请求的处理顺序：请求方法检查 -> 请求体大小限制 -> 全局中间件(Use 的顺序) -> 路由中间件(Middlewares 的顺序) -> 流量镜像 -> 路由 Handler
+type Router struct {
+	MaxBodyBytes int64    //默认请求体大小上限，0 表示不限制
+	Methods      []string //默认允许的请求方法，nil 表示不限制
+
+	mux      sync.RWMutex
+	chain    Chain
+	routes   []*Route
+	handlers map[*Route]http.Handler //叠加中间件后的处理器
+}
+
+func NewRouter() *Router {
+	return &Router{handlers: map[*Route]http.Handler{}}
+}
+
+// 追加全局中间件，已注册路由的处理器会重新构建
+func (r *Router) Use(middlewares ...Middleware) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.chain = r.chain.Use(middlewares...)
+	r.handlers = buildHandlers(r.chain, r.routes)
+}
+
+func (r *Router) Handle(route *Route) {
+	canonicalHeaderMatchers(route)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	routes := append(append([]*Route(nil), r.routes...), route)
+	sortRoutes(routes)
+	r.routes = routes
+	if r.handlers == nil {
+		r.handlers = map[*Route]http.Handler{}
+	}
+	r.handlers[route] = buildHandler(r.chain, route)
+}
+
+// 删除同名的路由，进行中的请求继续使用已匹配的处理器。没有该路由时返回 false
+func (r *Router) Remove(name string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	routes := make([]*Route, 0, len(r.routes))
+	removed := false
+	for _, route := range r.routes {
+		if route.Name == name {
+			delete(r.handlers, route)
+			removed = true
+			continue
+		}
+		routes = append(routes, route)
+	}
+	r.routes = routes
+	return removed
+}
+
+// 整体替换路由，保留全局中间件，如管理接口导入配置
+func (r *Router) SetRoutes(routes []*Route) {
+	routes = append([]*Route(nil), routes...)
+	for _, route := range routes {
+		canonicalHeaderMatchers(route)
+	}
+	sortRoutes(routes)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.routes = routes
+	r.handlers = buildHandlers(r.chain, routes)
+}
+
+// 配置重载：整体替换全局中间件与路由，新的处理器构建完成后一次性切换，
+// 进行中的请求继续使用旧的处理器
+func (r *Router) Reload(middlewares []Middleware, routes []*Route) {
+	chain := NewChain(middlewares...)
+	routes = append([]*Route(nil), routes...)
+	for _, route := range routes {
+		canonicalHeaderMatchers(route)
+	}
+	sortRoutes(routes)
+	handlers := buildHandlers(chain, routes)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.chain = chain
+	r.routes = routes
+	r.handlers = handlers
+}
+
+func (r *Router) Routes() []*Route {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return append([]*Route(nil), r.routes...)
+}
+
+func (r *Router) Match(req *http.Request) *Route {
+	route, _ := r.match(req)
+	return route
+}
+
+func (r *Router) match(req *http.Request) (*Route, http.Handler) {
+	host := req.Host
+	//不带端口时 SplitHostPort 会分配错误对象，先判断
+	if strings.IndexByte(host, ':') >= 0 {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	var query url.Values
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	for _, route := range r.routes {
+		if route.Host != "" && !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if strings.HasPrefix(req.URL.Path, route.PathPrefix) && route.matchParams(req, &query) {
+			return route, r.handlers[route]
+		}
+	}
+	return nil, nil
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	route, h := r.match(req)
+	if route == nil {
+		stampRoute(req.Context(), UnmatchedRoute)
+		http.NotFound(w, req)
+		return
+	}
+	stampRoute(req.Context(), route.Name)
+	methods := route.Methods
+	if methods == nil {
+		methods = r.Methods
+	}
+	if methods != nil && !methodAllowed(req, methods) {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := route.MaxBodyBytes
+	if limit == 0 {
+		limit = r.MaxBodyBytes
+	}
+	if limit > 0 {
+		h = middleware.MaxBodySize(limit, route.Name)(h)
+	}
+	//统计请求体与响应体的实际字节数
+	var body *countingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &countingBody{ReadCloser: req.Body}
+		req.Body = body
+	}
+	cw := &countingWriter{ResponseWriter: w}
+	defer func() {
+		if body != nil {
+			requestBodyBytes.Observe(route.Name, float64(body.n))
+		}
+		responseBodyBytes.Observe(route.Name, float64(cw.n))
+	}()
+	h.ServeHTTP(cw, req.WithContext(withRoute(req.Context(), route)))
+}
+
+// CORS 预检请求按其要预检的方法判断，交给后面的 CORS 中间件处理
+func methodAllowed(req *http.Request, methods []string) bool {
+	method := req.Method
+	if method == http.MethodOptions && req.Header.Get("Origin") != "" {
+		if reqMethod := req.Header.Get("Access-Control-Request-Method"); reqMethod != "" {
+			method = reqMethod
+		}
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortRoutes(routes []*Route) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		if len(routes[i].PathPrefix) != len(routes[j].PathPrefix) {
+			return len(routes[i].PathPrefix) > len(routes[j].PathPrefix)
+		}
+		if (routes[i].Host != "") != (routes[j].Host != "") {
+			return routes[i].Host != ""
+		}
+		return len(routes[i].Headers)+len(routes[i].Query) > len(routes[j].Headers)+len(routes[j].Query)
+	})
+}
+
+func buildHandlers(chain Chain, routes []*Route) map[*Route]http.Handler {
+	handlers := make(map[*Route]http.Handler, len(routes))
+	for _, route := range routes {
+		handlers[route] = buildHandler(chain, route)
+	}
+	return handlers
+}
+
+func buildHandler(chain Chain, route *Route) http.Handler {
+	h := route.Handler
+	if route.Mirror != nil {
+		h = route.Mirror.Handler(h)
+	}
+	return chain.Use(route.Middlewares...).Then(h)
+}
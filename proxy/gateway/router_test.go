@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/middleware"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte(RouteFromContext(req.Context()).Name))
+})
+
+func serve(h http.Handler, method, target, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouterMatch(t *testing.T) {
+	r := NewRouter()
+	r.Handle(&Route{Name: "root", PathPrefix: "/", Handler: okHandler})
+	r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: okHandler})
+	r.Handle(&Route{Name: "api-host", Host: "api.example.com", PathPrefix: "/api", Handler: okHandler})
+	cases := map[string]string{
+		"http://gw/index":                "root",
+		"http://gw/api/users":            "api",
+		"http://api.example.com:80/api/": "api-host",
+	}
+	for target, want := range cases {
+		if got := serve(r, "GET", target, "1.1.1.1:1").Body.String(); got != want {
+			t.Errorf("%s: got %q want %q", target, got, want)
+		}
+	}
+}
+
+func TestRouterMatchParams(t *testing.T) {
+	r := NewRouter()
+	r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: okHandler})
+	r.Handle(&Route{Name: "acme", PathPrefix: "/api", Handler: okHandler, Headers: []Matcher{{Name: "x-tenant", Value: "acme"}}})
+	r.Handle(&Route{Name: "beta", PathPrefix: "/api", Handler: okHandler, Query: []Matcher{{Name: "version", Regexp: regexp.MustCompile(`^beta\d*$`)}}})
+	r.Handle(&Route{Name: "mobile", PathPrefix: "/api", Handler: okHandler, Headers: []Matcher{
+		{Name: "User-Agent", Regexp: regexp.MustCompile(`(?i)android|iphone`)},
+		{Name: "Cookie", Absent: true},
+	}})
+	r.Handle(&Route{Name: "traced", PathPrefix: "/api/v2", Handler: okHandler, Headers: []Matcher{{Name: "Traceparent"}}})
+
+	cases := []struct {
+		target string
+		header map[string]string
+		want   string
+	}{
+		{"/api/users", nil, "api"},
+		{"/api/users", map[string]string{"X-Tenant": "acme"}, "acme"},
+		{"/api/users", map[string]string{"X-Tenant": "other"}, "api"},
+		{"/api/users?version=beta2", nil, "beta"},
+		{"/api/users?version=stable", nil, "api"},
+		//条件更多的路由优先
+		{"/api/users?version=beta", map[string]string{"X-Tenant": "acme"}, "acme"},
+		{"/api/users", map[string]string{"User-Agent": "Mozilla (iPhone)"}, "mobile"},
+		{"/api/users", map[string]string{"User-Agent": "Mozilla (iPhone)", "Cookie": "a=1"}, "api"},
+		//更长的路径前缀优先于条件
+		{"/api/v2/users", map[string]string{"Traceparent": "00-1", "X-Tenant": "acme"}, "traced"},
+		{"/api/v2/users", map[string]string{"X-Tenant": "acme"}, "acme"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.target, nil)
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		if got := r.Match(req); got == nil || got.Name != c.want {
+			t.Errorf("%s %v: got %v want %s", c.target, c.header, got, c.want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set("X-Tenant", "acme")
+	if allocs := testing.AllocsPerRun(100, func() { r.Match(req) }); allocs != 0 {
+		t.Fatalf("header matching allocates %v times", allocs)
+	}
+}
+
+func TestRouterPerRouteACL(t *testing.T) {
+	global, _ := middleware.NewACL("global", middleware.ACLAllow, []middleware.ACLRule{{Action: middleware.ACLDeny, CIDR: "6.6.0.0/16"}})
+	office, _ := middleware.NewACL("admin", middleware.ACLDeny, []middleware.ACLRule{{Action: middleware.ACLAllow, CIDR: "10.0.0.0/8"}})
+	r := NewRouter()
+	r.Handle(&Route{Name: "root", PathPrefix: "/", Handler: okHandler})
+	r.Handle(&Route{Name: "admin", PathPrefix: "/admin", Handler: okHandler, Middlewares: []Middleware{office.Handler}})
+	h := global.Handler(r)
+
+	if rec := serve(h, "GET", "/admin", "10.1.1.1:1"); rec.Code != http.StatusOK {
+		t.Fatalf("office admin got %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/admin", "8.8.8.8:1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("outside admin got %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/", "8.8.8.8:1"); rec.Code != http.StatusOK {
+		t.Fatalf("outside root got %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/", "6.6.6.6:1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("scraper root got %d", rec.Code)
+	}
+}
+
+func TestRouterPerRouteBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	auth := middleware.NewBasicAuth(middleware.BasicAuthConf{Users: map[string]string{"ops": string(hash)}})
+	r := NewRouter()
+	r.Handle(&Route{Name: "public", PathPrefix: "/", Handler: okHandler})
+	r.Handle(&Route{Name: "tools", PathPrefix: "/tools", Handler: okHandler, Middlewares: []Middleware{auth.Handler}})
+
+	if rec := serve(r, "GET", "/index", "1.1.1.1:1"); rec.Code != http.StatusOK {
+		t.Fatalf("public route got %d", rec.Code)
+	}
+	if rec := serve(r, "GET", "/tools/x", "1.1.1.1:1"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("protected route got %d", rec.Code)
+	}
+	req := httptest.NewRequest("GET", "/tools/x", nil)
+	req.SetBasicAuth("ops", "pw")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("authorized protected route got %d", rec.Code)
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	var authCalls int
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			authCalls++
+			next.ServeHTTP(w, req)
+		})
+	}
+	r := NewRouter()
+	r.Methods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}
+	r.Use(auth, middleware.NewCORS(middleware.CORSConf{AllowedOrigins: []string{"https://app.example.com"}}).Handler)
+	r.Handle(&Route{Name: "static", PathPrefix: "/static", Methods: []string{"GET", "HEAD"}, Handler: okHandler})
+	r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: okHandler})
+
+	rec := serve(r, "POST", "/static/a.js", "1.1.1.1:1")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Fatalf("route override: %d %v", rec.Code, rec.Header())
+	}
+	//全局默认列表拦截 TRACE
+	rec = serve(r, "TRACE", "/api/users", "1.1.1.1:1")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD, POST, PUT, DELETE, OPTIONS" {
+		t.Fatalf("global default: %d %v", rec.Code, rec.Header())
+	}
+	if authCalls != 0 {
+		t.Fatalf("method check ran after auth: %d", authCalls)
+	}
+	if rec := serve(r, "POST", "/api/users", "1.1.1.1:1"); rec.Code != http.StatusOK || rec.Body.String() != "api" {
+		t.Fatalf("allowed method rejected: %d", rec.Code)
+	}
+
+	//路由未允许 OPTIONS，但预检 GET 的请求交给 CORS 中间件应答
+	req := httptest.NewRequest("OPTIONS", "/static/a.js", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code == http.StatusMethodNotAllowed || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("preflight blocked: %d %v", rec.Code, rec.Header())
+	}
+	//预检路由不允许的方法
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("preflight for disallowed method: %d", rec.Code)
+	}
+}
+
+func TestRouterRemove(t *testing.T) {
+	r := NewRouter()
+	r.Handle(&Route{Name: "root", PathPrefix: "/", Handler: okHandler})
+	r.Handle(&Route{Name: "api", PathPrefix: "/api", Handler: okHandler})
+	if !r.Remove("api") || r.Remove("api") {
+		t.Fatal("remove should succeed once")
+	}
+	if got := serve(r, "GET", "http://gw/api/users", "1.1.1.1:1").Body.String(); got != "root" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/quic-go/quic-go/http3"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultMinReadRateWindow = 10 * time.Second
+)
+
+type connContextKeyType struct{}
+
+var connContextKey = connContextKeyType{}
+
+type ServerConf struct {
+	Addr      string
+	Handler   http.Handler
+	TLSConfig *tls.Config //不为空时监听 TLS
+
+	ReadHeaderTimeout time.Duration //读取请求头超时，默认 DefaultReadHeaderTimeout
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	ProxyProtocol *ProxyProtocolConf //不为空时接受连接后先解析 PROXY protocol 头
+	HTTP3         *HTTP3Conf         //不为空且配置了 TLS 时同时监听 HTTP/3，并在其他协议的响应中通过 Alt-Svc 宣告
+	Socket        ListenSocketConf   //SO_REUSEPORT、TCP_NODELAY 等监听 socket 选项
+
+	MaxConns          int           //最大并发连接数，0 表示不限制
+	MinReadRate       int64         //读取请求体的最低速率(字节/秒)，0 表示不限制，只作用于 HTTP/1.x
+	MinReadRateWindow time.Duration //速率统计窗口，默认 DefaultMinReadRateWindow
+
+	RuntimeStatsInterval time.Duration //协程数、堆、GC 指标的采集间隔，0 表示不在后台采集，见 metrics.RuntimeCollector
+}
+
+// 带慢客户端防护的网关服务：请求头超时、请求体最低读取速率、最大连接数
+type Server struct {
+	conf     ServerConf
+	srv      *http.Server
+	listener *limitListener
+	h3       *http3.Server
+	altSvc   string
+	runtime  *metrics.RuntimeCollector
+}
+
+func NewServer(conf ServerConf) *Server {
+	if conf.ReadHeaderTimeout <= 0 {
+		conf.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if conf.MinReadRateWindow <= 0 {
+		conf.MinReadRateWindow = DefaultMinReadRateWindow
+	}
+	s := &Server{conf: conf}
+	if conf.RuntimeStatsInterval > 0 {
+		s.runtime = metrics.NewRuntimeCollector(conf.RuntimeStatsInterval)
+	}
+	s.srv = &http.Server{
+		Addr:              conf.Addr,
+		Handler:           http.HandlerFunc(s.serveHTTP),
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		ReadTimeout:       conf.ReadTimeout,
+		WriteTimeout:      conf.WriteTimeout,
+		IdleTimeout:       conf.IdleTimeout,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connContextKey, c)
+		},
+	}
+	if conf.HTTP3 != nil {
+		if conf.TLSConfig == nil {
+			fmt.Println("http3 requires tls, disabled", conf.Addr)
+		} else {
+			s.h3 = newHTTP3Server(conf.HTTP3, conf.TLSConfig, http.HandlerFunc(s.serveHTTP))
+		}
+	}
+	return s
+}
+
+func (s *Server) ListenAndServe() error {
+	l, err := s.conf.Socket.Listen("tcp", s.conf.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	if s.conf.ProxyProtocol != nil {
+		pl, err := newProxyProtoListener(l, *s.conf.ProxyProtocol)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		l = pl
+	}
+	if s.h3 != nil {
+		altSvc, err := s.serveHTTP3(l.Addr())
+		if err != nil {
+			l.Close()
+			return err
+		}
+		s.altSvc = altSvc
+	}
+	s.listener = newLimitListener(l, s.conf.MaxConns, s.conf.MinReadRate, s.conf.MinReadRateWindow)
+	if s.runtime != nil {
+		s.runtime.Start()
+	}
+	if s.conf.TLSConfig != nil {
+		return s.srv.Serve(tls.NewListener(s.listener, s.conf.TLSConfig))
+	}
+	return s.srv.Serve(s.listener)
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.runtime != nil {
+		s.runtime.Close()
+	}
+	if s.h3 != nil {
+		if err := s.h3.Shutdown(ctx); err != nil {
+			s.srv.Shutdown(ctx)
+			return err
+		}
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, _ := req.Context().Value(connContextKey).(net.Conn)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	//HTTP/2 的多个流共用一个连接，按连接统计的速率无法区分各个流，只检查 HTTP/1.x 的请求体
+	if c, ok := conn.(*slowConn); ok && req.ProtoMajor == 1 && req.Body != nil && req.Body != http.NoBody {
+		c.startBody()
+		defer c.endBody()
+		req.Body = &watchedBody{ReadCloser: req.Body, done: c.endBody}
+	}
+	if req.ProtoMajor < 3 && s.altSvc != "" {
+		w.Header().Set("Alt-Svc", s.altSvc)
+	}
+	start := time.Now()
+	defer func() { requestLatency.Observe(protocolLabel(req), sinceMs(start)) }()
+	s.conf.Handler.ServeHTTP(w, req)
+}
+
+// 请求体读完或关闭时结束速率检查
+type watchedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *watchedBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
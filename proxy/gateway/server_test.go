@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, conf ServerConf) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(conf)
+	go s.Serve(l)
+	t.Cleanup(func() { s.srv.Close() })
+	return s, l.Addr().String()
+}
+
+func echoBodyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		w.Write(data)
+	})
+}
+
+// 等待服务端关闭连接，超时返回 false
+func waitClosed(c net.Conn, timeout time.Duration) bool {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	_, err := io.Copy(io.Discard, c)
+	ne, ok := err.(net.Error)
+	return !(ok && ne.Timeout())
+}
+
+func TestServerSlowBodyCut(t *testing.T) {
+	_, addr := startServer(t, ServerConf{Handler: echoBodyHandler(), MinReadRate: 1000, MinReadRateWindow: 200 * time.Millisecond})
+	before := slowClients.Get(addr)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1000\r\n\r\n")
+	start := time.Now()
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := c.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	if !waitClosed(c, 2*time.Second) {
+		t.Fatal("slow client connection not cut")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("slow client held connection for %s", d)
+	}
+	if slowClients.Get(addr) != before+1 {
+		t.Fatal("slow client not counted")
+	}
+}
+
+func TestServerFastClientUnaffected(t *testing.T) {
+	_, addr := startServer(t, ServerConf{Handler: echoBodyHandler(), MinReadRate: 1000, MinReadRateWindow: 100 * time.Millisecond})
+	client := &http.Client{}
+	payload := strings.Repeat("x", 1<<20)
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post("http://"+addr+"/", "text/plain", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(data) != len(payload) {
+			t.Fatalf("got %d bytes", len(data))
+		}
+		//保持连接空闲超过统计窗口，不应被当作慢客户端断开
+		time.Sleep(250 * time.Millisecond)
+	}
+	//处理耗时超过统计窗口的请求不受影响
+	_, addr = startServer(t, ServerConf{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			io.ReadAll(req.Body)
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("done"))
+		}),
+		MinReadRate:       1000,
+		MinReadRateWindow: 100 * time.Millisecond,
+	})
+	resp, err := client.Post("http://"+addr+"/", "text/plain", strings.NewReader("short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "done" {
+		t.Fatalf("got %q", data)
+	}
+}
+
+func TestServerReadHeaderTimeout(t *testing.T) {
+	_, addr := startServer(t, ServerConf{Handler: echoBodyHandler(), ReadHeaderTimeout: 200 * time.Millisecond})
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET / HTTP/1.1\r\n")
+	if !waitClosed(c, 2*time.Second) {
+		t.Fatal("connection trickling headers not cut")
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	s, addr := startServer(t, ServerConf{Handler: echoBodyHandler(), MaxConns: 1})
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	//确认第一个连接已被接受
+	io.WriteString(first, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, err := http.ReadResponse(bufio.NewReader(first), nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("first connection failed: %v", err)
+	}
+	if s.listener.Conns() != 1 || openConns.Get(addr) != 1 {
+		t.Fatalf("open connections %d gauge %d", s.listener.Conns(), openConns.Get(addr))
+	}
+	before := rejectedConns.Get(addr)
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if !waitClosed(second, time.Second) {
+		t.Fatal("overflow connection not rejected")
+	}
+	if rejectedConns.Get(addr) != before+1 {
+		t.Fatal("rejected connection not counted")
+	}
+	first.Close()
+	deadline := time.Now().Add(time.Second)
+	for s.listener.Conns() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.listener.Conns() != 0 || openConns.Get(addr) != 0 {
+		t.Fatalf("connection count not released: %d", s.listener.Conns())
+	}
+}
+
+// HTTP/2 的流共用连接，不开启按连接的速率检查
+func TestServerMinReadRateSkipsHTTP2(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &slowConn{Conn: server, listener: &limitListener{minRate: 1000, rateWindow: time.Second}}
+	enforcing := map[int]bool{}
+	s := NewServer(ServerConf{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.mux.Lock()
+		enforcing[req.ProtoMajor] = c.enforcing
+		c.mux.Unlock()
+	})})
+	for _, major := range []int{1, 2} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("body"))
+		req.ProtoMajor = major
+		req = req.WithContext(context.WithValue(req.Context(), connContextKey, net.Conn(c)))
+		s.serveHTTP(httptest.NewRecorder(), req)
+	}
+	if !enforcing[1] || enforcing[2] {
+		t.Fatalf("enforcing %v", enforcing)
+	}
+}
@@ -0,0 +1,32 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// 监听 socket 的选项，在 bind 之前通过 ListenConfig.Control 设置，不支持的平台忽略
+type ListenSocketConf struct {
+	ReusePort bool //SO_REUSEPORT，多个网关进程监听同一端口，由内核分配连接
+	NoDelay   bool //TCP_NODELAY，接受的连接继承该设置
+	FastOpen  int  //TCP_FASTOPEN 的队列长度，0 表示不开启
+}
+
+// 上游连接的 TCP 选项，在 connect 之前通过 Dialer.Control 设置，不支持的平台忽略
+type DialSocketConf struct {
+	KeepAliveIdle     time.Duration //连接空闲多久后开始发送探测，为 0 时使用 DialerConf.KeepAlive
+	KeepAliveInterval time.Duration //探测间隔，为 0 时与 KeepAliveIdle 相同
+	KeepAliveCount    int           //探测失败多少次后断开，0 表示使用系统默认值
+	FastOpen          bool          //TCP_FASTOPEN_CONNECT，握手时携带数据
+}
+
+func (c DialSocketConf) keepAliveSet() bool {
+	return c.KeepAliveIdle > 0 || c.KeepAliveInterval > 0 || c.KeepAliveCount > 0
+}
+
+// 按配置的 socket 选项监听
+func (c ListenSocketConf) Listen(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: listenControl(c)}
+	return lc.Listen(context.Background(), network, addr)
+}
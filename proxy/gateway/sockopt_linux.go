@@ -0,0 +1,77 @@
+//go:build linux
+
+package gateway
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+func listenControl(conf ListenSocketConf) func(network, address string, c syscall.RawConn) error {
+	if conf == (ListenSocketConf{}) {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var opts [][2]int
+		if conf.ReusePort {
+			opts = append(opts, [2]int{unix.SOL_SOCKET, unix.SO_REUSEPORT})
+		}
+		if conf.NoDelay {
+			opts = append(opts, [2]int{unix.IPPROTO_TCP, unix.TCP_NODELAY})
+		}
+		err := setsockopts(c, opts, 1)
+		if err == nil && conf.FastOpen > 0 {
+			err = setsockopts(c, [][2]int{{unix.IPPROTO_TCP, unix.TCP_FASTOPEN}}, conf.FastOpen)
+		}
+		return err
+	}
+}
+
+func dialControl(conf DialSocketConf, keepAlive int) func(network, address string, c syscall.RawConn) error {
+	if !conf.keepAliveSet() && !conf.FastOpen {
+		return nil
+	}
+	idle := int(conf.KeepAliveIdle.Seconds())
+	if idle <= 0 {
+		idle = keepAlive
+	}
+	interval := int(conf.KeepAliveInterval.Seconds())
+	if interval <= 0 {
+		interval = idle
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if conf.FastOpen {
+			//内核不支持时忽略
+			setsockopts(c, [][2]int{{unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT}}, 1)
+		}
+		if !conf.keepAliveSet() {
+			return nil
+		}
+		err := setsockopts(c, [][2]int{{unix.SOL_SOCKET, unix.SO_KEEPALIVE}}, 1)
+		if err == nil && idle > 0 {
+			err = setsockopts(c, [][2]int{{unix.IPPROTO_TCP, unix.TCP_KEEPIDLE}}, idle)
+		}
+		if err == nil && interval > 0 {
+			err = setsockopts(c, [][2]int{{unix.IPPROTO_TCP, unix.TCP_KEEPINTVL}}, interval)
+		}
+		if err == nil && conf.KeepAliveCount > 0 {
+			err = setsockopts(c, [][2]int{{unix.IPPROTO_TCP, unix.TCP_KEEPCNT}}, conf.KeepAliveCount)
+		}
+		return err
+	}
+}
+
+func setsockopts(c syscall.RawConn, opts [][2]int, value int) error {
+	var err error
+	ctrlErr := c.Control(func(fd uintptr) {
+		for _, opt := range opts {
+			if err = unix.SetsockoptInt(int(fd), opt[0], opt[1], value); err != nil {
+				return
+			}
+		}
+	})
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
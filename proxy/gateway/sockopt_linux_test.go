@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"context"
+	"golang.org/x/sys/unix"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func getsockopt(t *testing.T, c syscall.Conn, level, opt int) int {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	raw.Control(func(fd uintptr) {
+		value, err = unix.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestListenSocketOptions(t *testing.T) {
+	conf := ListenSocketConf{ReusePort: true, NoDelay: true, FastOpen: 16}
+	l, err := conf.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tl := l.(*net.TCPListener)
+	if getsockopt(t, tl, unix.SOL_SOCKET, unix.SO_REUSEPORT) != 1 || getsockopt(t, tl, unix.IPPROTO_TCP, unix.TCP_NODELAY) != 1 {
+		t.Fatal("listener options not applied")
+	}
+	//开启 SO_REUSEPORT 的两个进程(监听)可以绑定同一端口
+	second, err := conf.Listen("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("reuseport bind failed: %v", err)
+	}
+	second.Close()
+	if _, err := (ListenSocketConf{}).Listen("tcp", l.Addr().String()); err == nil {
+		t.Fatal("bind without reuseport succeeded")
+	}
+}
+
+func TestDialSocketOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	d := NewDialer(DialerConf{Socket: DialSocketConf{KeepAliveIdle: 15 * time.Second, KeepAliveInterval: 5 * time.Second, KeepAliveCount: 3}})
+	conn, err := d.DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := conn.(*net.TCPConn)
+	if getsockopt(t, c, unix.SOL_SOCKET, unix.SO_KEEPALIVE) != 1 ||
+		getsockopt(t, c, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE) != 15 ||
+		getsockopt(t, c, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL) != 5 ||
+		getsockopt(t, c, unix.IPPROTO_TCP, unix.TCP_KEEPCNT) != 3 {
+		t.Fatal("keepalive options not applied")
+	}
+
+	//只配置 KeepAlive 时沿用 net.Dialer 的行为
+	conn, err = NewDialer(DialerConf{KeepAlive: 20 * time.Second}).DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if idle := getsockopt(t, conn.(*net.TCPConn), unix.IPPROTO_TCP, unix.TCP_KEEPIDLE); idle != 20 {
+		t.Fatalf("default keepalive idle %d", idle)
+	}
+}
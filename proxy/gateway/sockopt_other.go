@@ -0,0 +1,14 @@
+//go:build !linux
+
+package gateway
+
+import "syscall"
+
+// 其他平台不设置 socket 选项
+func listenControl(conf ListenSocketConf) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+func dialControl(conf DialSocketConf, keepAlive int) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const DefaultStickyCookieName = "gw_sticky"
+
+type StickyConf struct {
+	Secret   []byte        //签名密钥，为空表示不开启会话保持
+	Name     string        //cookie 名，默认 DefaultStickyCookieName
+	TTL      time.Duration //cookie 有效期，0 表示会话 cookie
+	Path     string        //默认 /
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// 网关下发的会话保持 cookie，cookie 值是后端地址的 HMAC，不暴露后端地址也无法伪造
+type stickySessions struct {
+	conf StickyConf
+}
+
+func newStickySessions(conf StickyConf, lb load_balance.LoadBalance) (*stickySessions, error) {
+	if len(conf.Secret) == 0 {
+		return nil, nil
+	}
+	if _, ok := lb.(load_balance.ManagedBalance); !ok {
+		return nil, errors.New("sticky sessions need a balancer that lists its servers")
+	}
+	if conf.Name == "" {
+		conf.Name = DefaultStickyCookieName
+	}
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+	return &stickySessions{conf: conf}, nil
+}
+
+func (s *stickySessions) token(addr string) string {
+	m := hmac.New(sha256.New, s.conf.Secret)
+	m.Write([]byte(addr))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil)[:16])
+}
+
+// 从 cookie 找到仍在负载均衡中的后端，后端被摘除或签名不符时返回空
+func (s *stickySessions) backend(req *http.Request, lb load_balance.LoadBalance) string {
+	c, err := req.Cookie(s.conf.Name)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	for _, addr := range lb.(load_balance.ManagedBalance).Servers() {
+		if hmac.Equal([]byte(s.token(addr)), []byte(c.Value)) {
+			return addr
+		}
+	}
+	return ""
+}
+
+func (s *stickySessions) cookie(addr string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.conf.Name,
+		Value:    s.token(addr),
+		Path:     s.conf.Path,
+		Secure:   s.conf.Secure,
+		HttpOnly: s.conf.HttpOnly,
+		SameSite: s.conf.SameSite,
+	}
+	if s.conf.TTL > 0 {
+		c.MaxAge = int(s.conf.TTL / time.Second)
+	}
+	return c
+}
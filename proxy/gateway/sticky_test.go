@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/rate_limiter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func namedServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "upstream", Value: name})
+		w.Write([]byte(name))
+	}))
+}
+
+func stickyGet(p http.Handler, c *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	if c != nil {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	return rec
+}
+
+func cookieNamed(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func newStickyProxy(t *testing.T) (*Proxy, *load_balance.RoundRobinBalance, map[string]string) {
+	a, b := namedServer("a"), namedServer("b")
+	t.Cleanup(a.Close)
+	t.Cleanup(b.Close)
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(a.URL)
+	lb.Add(b.URL)
+	p := NewProxy(lb, Options{Sticky: StickyConf{
+		Secret:   []byte("secret"),
+		TTL:      time.Hour,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}})
+	return p, lb, map[string]string{"a": a.URL, "b": b.URL}
+}
+
+func TestStickySession(t *testing.T) {
+	p, _, _ := newStickyProxy(t)
+	rec := stickyGet(p, nil)
+	c := cookieNamed(rec, DefaultStickyCookieName)
+	if c == nil || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.MaxAge != 3600 {
+		t.Fatalf("unexpected sticky cookie %+v", c)
+	}
+	//上游的 Set-Cookie 不受影响
+	if up := cookieNamed(rec, "upstream"); up == nil || up.Value != rec.Body.String() {
+		t.Fatalf("upstream cookie lost: %v", rec.Result().Cookies())
+	}
+	first := rec.Body.String()
+	for i := 0; i < 10; i++ {
+		rec := stickyGet(p, c)
+		if rec.Body.String() != first {
+			t.Fatalf("request %d went to %q, want %q", i, rec.Body, first)
+		}
+		if cookieNamed(rec, DefaultStickyCookieName) != nil {
+			t.Fatal("valid sticky cookie should not be reissued")
+		}
+	}
+}
+
+func TestStickyReissueOnRemoval(t *testing.T) {
+	p, lb, addrs := newStickyProxy(t)
+	rec := stickyGet(p, nil)
+	c := cookieNamed(rec, DefaultStickyCookieName)
+	first := rec.Body.String()
+	if err := lb.Remove(addrs[first]); err != nil {
+		t.Fatal(err)
+	}
+	rec = stickyGet(p, c)
+	if rec.Body.String() == first {
+		t.Fatal("request routed to removed backend")
+	}
+	reissued := cookieNamed(rec, DefaultStickyCookieName)
+	if reissued == nil || reissued.Value == c.Value {
+		t.Fatalf("cookie not reissued: %v", reissued)
+	}
+	second := rec.Body.String()
+	if rec := stickyGet(p, reissued); rec.Body.String() != second || cookieNamed(rec, DefaultStickyCookieName) != nil {
+		t.Fatal("reissued cookie not honoured")
+	}
+}
+
+func TestStickyTamperedCookie(t *testing.T) {
+	p, _, addrs := newStickyProxy(t)
+	other, _ := newStickySessions(StickyConf{Secret: []byte("other")}, &load_balance.RoundRobinBalance{})
+	for _, value := range []string{"garbage", addrs["a"], other.token(addrs["a"]), other.token(addrs["b"])} {
+		rec := stickyGet(p, &http.Cookie{Name: DefaultStickyCookieName, Value: value})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: got %d", value, rec.Code)
+		}
+		c := cookieNamed(rec, DefaultStickyCookieName)
+		if c == nil || c.Value == value || c.Value != p.sticky.token(addrs[rec.Body.String()]) {
+			t.Fatalf("tampered cookie %q accepted, reissued %v", value, c)
+		}
+	}
+}
+
+func TestStickyBackendLimited(t *testing.T) {
+	p, _, addrs := newStickyProxy(t)
+	limiter := rate_limiter.NewBackendLimiter(rate_limiter.BackendLimitReselect)
+	p.opts.BackendLimiter = limiter
+	rec := stickyGet(p, nil)
+	c := cookieNamed(rec, DefaultStickyCookieName)
+	first := rec.Body.String()
+	limiter.SetLimit(addrs[first], 0.001, 2)
+
+	hits := 0
+	var reissued *http.Cookie
+	for i := 0; i < 10; i++ {
+		rec := stickyGet(p, c)
+		if rec.Body.String() == first {
+			hits++
+		} else if reissued = cookieNamed(rec, DefaultStickyCookieName); reissued == nil {
+			t.Fatal("cookie not reissued for limited sticky backend")
+		}
+	}
+	if hits > 2 {
+		t.Fatalf("limited sticky backend got %d requests, limit 2", hits)
+	}
+	if reissued == nil || reissued.Value != p.sticky.token(addrs[map[string]string{"a": "b", "b": "a"}[first]]) {
+		t.Fatalf("reissued %v", reissued)
+	}
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// 默认按流式处理的响应类型
+var DefaultStreamingContentTypes = []string{"text/event-stream", "application/x-ndjson", "application/grpc"}
+
+// 是否为流式响应类型，流式响应每次写入后立即 flush，且不经过 ModifyResponse 等需要读取响应体的处理
+func (p *Proxy) isStreaming(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range p.opts.StreamingContentTypes {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// 流式响应写入后立即 flush。
+// 无 Content-Length 的响应由 ReverseProxy 自身立即 flush，这里补上配置的其他流式类型
+// 同时记录是否已向客户端写出数据，供重试判断
+type streamWriter struct {
+	http.ResponseWriter
+	p         *Proxy
+	state     *upstreamState
+	streaming bool
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	w.state.markWritten()
+	w.streaming = w.p.isStreaming(w.Header())
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.state.markWritten()
+	n, err := w.ResponseWriter.Write(b)
+	if w.streaming {
+		w.Flush()
+	}
+	return n, err
+}
+
+func (w *streamWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 每 100ms 发送一条带发送时间戳的事件
+func sseServer(contentType string, knownLength bool, closed chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if knownLength {
+			//时间戳固定 19 位
+			w.Header().Set("Content-Length", strconv.Itoa(5*len("data: 1700000000000000000\n\n")))
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for i := 0; i < 5; i++ {
+			select {
+			case <-req.Context().Done():
+				close(closed)
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+			w.Write([]byte("data: " + strconv.FormatInt(time.Now().UnixNano(), 10) + "\n\n"))
+			w.(http.Flusher).Flush()
+		}
+		<-req.Context().Done()
+		close(closed)
+	}))
+}
+
+func streamingGateway(upstream string) *httptest.Server {
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream)
+	p := NewProxy(lb, Options{
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Set("X-Modified", "1")
+			return nil
+		},
+	})
+	return httptest.NewServer(p)
+}
+
+func TestStreamingIncremental(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		knownLength bool
+	}{
+		{"text/event-stream", false},
+		{"application/x-ndjson", true},
+	} {
+		closed := make(chan struct{})
+		upstream := sseServer(tc.contentType, tc.knownLength, closed)
+		gw := streamingGateway(upstream.URL)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, "GET", gw.URL, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get("X-Modified") != "" {
+			t.Fatalf("%s: ModifyResponse should be skipped for streaming responses", tc.contentType)
+		}
+		reader := bufio.NewReader(resp.Body)
+		for i := 0; i < 3; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader.ReadString('\n')
+			sent, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "data: ")), 10, 64)
+			if latency := time.Since(time.Unix(0, sent)); latency > 50*time.Millisecond {
+				t.Fatalf("%s: event %d buffered for %s", tc.contentType, i, latency)
+			}
+		}
+		//客户端断开后上游请求应被取消
+		cancel()
+		resp.Body.Close()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: upstream not cancelled after client disconnect", tc.contentType)
+		}
+		gw.Close()
+		upstream.Close()
+	}
+}
+
+func TestNonStreamingStillModified(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer upstream.Close()
+	gw := streamingGateway(upstream.URL)
+	defer gw.Close()
+	resp, err := http.Get(gw.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Modified") != "1" {
+		t.Fatal("ModifyResponse skipped for a regular response")
+	}
+}
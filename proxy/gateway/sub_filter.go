@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+)
+
+const DefaultSubFilterMaxMatch = 4096
+
+var DefaultSubFilterContentTypes = []string{"text/html", "text/css", "application/javascript"}
+
+// 一条替换规则，Regexp 不为空时按正则替换，New 中可以使用 $1 引用分组；否则把 Old 替换为 New
+type Replacement struct {
+	Old    string
+	Regexp *regexp.Regexp
+	New    string
+}
+
+// 路由级的响应体替换，类似 nginx 的 sub_filter。替换规则按顺序执行，
+// 响应体流式处理，不整体读入内存，改写后去掉 Content-Length 使用分块编码
+type SubFilter struct {
+	Replacements []Replacement
+	ContentTypes []string //需要处理的响应类型，默认 DefaultSubFilterContentTypes
+	MaxMatch     int      //正则匹配的最大长度，超过该长度的匹配跨读取边界时可能漏掉，默认 DefaultSubFilterMaxMatch
+}
+
+// 检查替换规则后返回 f 的副本，每条规则需要设置 Regexp 或非空的 Old
+func NewSubFilter(f SubFilter) (*SubFilter, error) {
+	if len(f.Replacements) == 0 {
+		return nil, errors.New("sub filter needs at least one replacement")
+	}
+	for i, rep := range f.Replacements {
+		if rep.Regexp == nil && rep.Old == "" {
+			return nil, fmt.Errorf("sub filter replacement %d: empty Old", i)
+		}
+	}
+	f.Replacements = append([]Replacement(nil), f.Replacements...)
+	return &f, nil
+}
+
+func (f *SubFilter) match(h http.Header) bool {
+	if enc := h.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	types := f.ContentTypes
+	if types == nil {
+		types = DefaultSubFilterContentTypes
+	}
+	for _, t := range types {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *SubFilter) transformResponse(resp *http.Response) {
+	if len(f.Replacements) == 0 || resp.Body == nil || resp.Body == http.NoBody || !f.match(resp.Header) {
+		return
+	}
+	var r io.Reader = resp.Body
+	for _, rep := range f.Replacements {
+		//未经 NewSubFilter 检查的空规则不做处理
+		if rep.Regexp == nil && rep.Old == "" {
+			continue
+		}
+		r = f.newReader(r, rep)
+	}
+	resp.Body = readCloser{r, resp.Body}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+}
+
+func (f *SubFilter) newReader(src io.Reader, rep Replacement) *replaceReader {
+	r := &replaceReader{src: src, rep: rep}
+	if rep.Regexp != nil {
+		r.window = f.MaxMatch
+		if r.window <= 0 {
+			r.window = DefaultSubFilterMaxMatch
+		}
+	} else if len(rep.Old) > 1 {
+		r.window = len(rep.Old) - 1
+	}
+	return r
+}
+
+// 执行单条替换的 reader。保留末尾 window 字节等下一次读取，使跨读取边界的匹配也能被替换
+type replaceReader struct {
+	src    io.Reader
+	rep    Replacement
+	window int
+
+	buf []byte
+	in  []byte //尚未处理的输入
+	out []byte //已替换、等待输出的数据
+	err error
+}
+
+func (r *replaceReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, 32*1024)
+		}
+		n, err := r.src.Read(r.buf)
+		r.in = append(r.in, r.buf[:n]...)
+		if err != nil {
+			r.err = err
+		}
+		r.process(err != nil)
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// 替换起点在安全区内的匹配，输入结束时处理全部剩余数据
+func (r *replaceReader) process(final bool) {
+	safe := len(r.in)
+	if !final {
+		safe -= r.window
+		if safe <= 0 {
+			return
+		}
+	}
+	var out []byte
+	last := 0
+	for _, m := range r.find(r.in) {
+		if m[0] >= safe {
+			break
+		}
+		if m[0] == m[1] {
+			continue
+		}
+		out = append(out, r.in[last:m[0]]...)
+		if r.rep.Regexp != nil {
+			out = r.rep.Regexp.Expand(out, []byte(r.rep.New), r.in, m)
+		} else {
+			out = append(out, r.rep.New...)
+		}
+		last = m[1]
+	}
+	if last < safe {
+		out = append(out, r.in[last:safe]...)
+		last = safe
+	}
+	r.out = out
+	r.in = append(r.in[:0:0], r.in[last:]...)
+}
+
+func (r *replaceReader) find(data []byte) [][]int {
+	if r.rep.Regexp != nil {
+		return r.rep.Regexp.FindAllSubmatchIndex(data, -1)
+	}
+	if r.rep.Old == "" {
+		return nil
+	}
+	var matches [][]int
+	old := []byte(r.rep.Old)
+	for start := 0; ; {
+		i := bytes.Index(data[start:], old)
+		if i < 0 {
+			return matches
+		}
+		matches = append(matches, []int{start + i, start + i + len(old)})
+		start += i + len(old)
+	}
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReplaceReaderBoundaries(t *testing.T) {
+	f := &SubFilter{MaxMatch: 16, Replacements: []Replacement{
+		{Old: "http://internal.local", New: "https://example.com"},
+		{Regexp: regexp.MustCompile(`id="(\w+)"`), New: `data-id="$1"`},
+	}}
+	page := strings.Repeat(`<a href="http://internal.local/x" id="abc">`, 50)
+	want := strings.Repeat(`<a href="https://example.com/x" data-id="abc">`, 50)
+	//每次只读 1 字节，所有匹配都跨越读取边界
+	for _, src := range []io.Reader{iotest.OneByteReader(strings.NewReader(page)), iotest.HalfReader(strings.NewReader(page)), strings.NewReader(page)} {
+		var r io.Reader = src
+		for _, rep := range f.Replacements {
+			r = f.newReader(r, rep)
+		}
+		got, err := io.ReadAll(iotest.OneByteReader(r))
+		if err != nil || string(got) != want {
+			t.Fatalf("got %q err %v", got, err)
+		}
+	}
+}
+
+func TestSubFilterProxy(t *testing.T) {
+	binary := "\x00http://internal.local\xff"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/bin" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte(binary))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<a href="http://internal.local/">home</a>`))
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	r := NewRouter()
+	r.Handle(&Route{Name: "legacy", PathPrefix: "/", Handler: NewProxy(lb, Options{}), SubFilter: &SubFilter{
+		Replacements: []Replacement{{Old: "http://internal.local", New: "https://example.com"}},
+	}})
+
+	rec := serve(r, "GET", "/page", "10.0.0.1:1")
+	if rec.Body.String() != `<a href="https://example.com/">home</a>` || rec.Header().Get("Content-Length") != "" {
+		t.Fatalf("html not rewritten: %q %v", rec.Body, rec.Header())
+	}
+	//二进制类型原样透传
+	rec = serve(r, "GET", "/bin", "10.0.0.1:1")
+	if rec.Body.String() != binary || rec.Header().Get("Content-Length") != "23" {
+		t.Fatalf("binary modified: %q %v", rec.Body, rec.Header())
+	}
+}
+
+func TestSubFilterEmptyOld(t *testing.T) {
+	if _, err := NewSubFilter(SubFilter{Replacements: []Replacement{{Old: "", New: "x"}}}); err == nil {
+		t.Fatal("empty Old accepted")
+	}
+	f, err := NewSubFilter(SubFilter{Replacements: []Replacement{{Old: "a", New: "b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//未经检查的空规则不会使读取 panic
+	raw := &SubFilter{Replacements: []Replacement{{Old: "", New: "x"}}}
+	for _, c := range []struct {
+		f    *SubFilter
+		want string
+	}{{f, "bbc"}, {raw, "aac"}} {
+		var r io.Reader = iotest.OneByteReader(strings.NewReader("aac"))
+		for _, rep := range c.f.Replacements {
+			r = c.f.newReader(r, rep)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || string(got) != c.want {
+			t.Fatalf("got %q err %v", got, err)
+		}
+	}
+	resp := &http.Response{Header: http.Header{"Content-Type": {"text/html"}}, Body: io.NopCloser(strings.NewReader("aac"))}
+	raw.transformResponse(resp)
+	if got, _ := io.ReadAll(resp.Body); string(got) != "aac" {
+		t.Fatalf("got %q", got)
+	}
+}
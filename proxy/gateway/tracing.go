@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const tracerName = "GO_GATEWAY/proxy/gateway"
+
+type TracingOptions struct {
+	Enabled      bool
+	OTLPEndpoint string  //如 127.0.0.1:4318
+	Insecure     bool    //使用 http 而不是 https 连接 OTLP
+	SampleRatio  float64 //采样率，0~1
+	ServiceName  string
+
+	//指定后忽略上面的导出配置，测试中可注入内存记录器
+	TracerProvider trace.TracerProvider
+}
+
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	shutdown   func(context.Context) error
+}
+
+// 未开启时返回 nil，调用方据此跳过所有追踪逻辑
+func newTracing(opts TracingOptions) (*tracing, error) {
+	if !opts.Enabled {
+		return nil, nil
+	}
+	t := &tracing{
+		propagator: propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)),
+		),
+		shutdown: func(context.Context) error { return nil },
+	}
+	provider := opts.TracerProvider
+	if provider == nil {
+		clientOpts := []otlptracehttp.Option{}
+		if opts.OTLPEndpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.OTLPEndpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		serviceName := opts.ServiceName
+		if serviceName == "" {
+			serviceName = "go_gateway"
+		}
+		sdkProvider := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+			sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+		)
+		provider = sdkProvider
+		t.shutdown = sdkProvider.Shutdown
+	}
+	t.tracer = provider.Tracer(tracerName)
+	return t, nil
+}
+
+// 从请求头中提取上游链路，开启服务端 span
+func (t *tracing) startServerSpan(req *http.Request) (*http.Request, trace.Span) {
+	ctx := t.propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := t.tracer.Start(ctx, "gateway "+req.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLPath(req.URL.Path),
+		))
+	return req.WithContext(ctx), span
+}
+
+func endServerSpan(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// 每次请求上游都是一个客户端 span，重试时会产生多个
+type tracingTransport struct {
+	tracing *tracing
+	next    http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracing.tracer.Start(req.Context(), "upstream "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gateway.backend", BackendFromContext(req.Context())),
+			semconv.ServerAddress(req.URL.Host),
+		))
+	defer span.End()
+	req = req.Clone(ctx)
+	t.tracing.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("gateway.error_class", errorCategory(err)))
+		span.SetStatus(codes.Error, errorCategory(err))
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// 记录状态码与写出的响应体字节数
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
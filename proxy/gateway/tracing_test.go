@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 第一次请求失败的 transport
+type flakyTransport struct {
+	calls int
+	next  http.RoundTripper
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.calls++
+	if f.calls == 1 {
+		return nil, errors.New("connection reset")
+	}
+	return f.next.RoundTrip(req)
+}
+
+// 失败后重试一次
+type retryTransport struct {
+	next http.RoundTripper
+}
+
+func (r *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return r.next.RoundTrip(req)
+	}
+	return resp, err
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingRetriedRequest(t *testing.T) {
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamTraceparent = req.Header.Get("Traceparent")
+	}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	p := NewProxy(lb, Options{
+		Transport: &flakyTransport{next: http.DefaultTransport},
+		Tracing:   TracingOptions{Enabled: true, TracerProvider: provider},
+	})
+	p.reverseProxy.Transport = &retryTransport{next: p.reverseProxy.Transport}
+
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("want 1 server + 2 client spans, got %d", len(spans))
+	}
+	server := spans[2]
+	if server.SpanKind() != trace.SpanKindServer || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span should continue incoming trace, parent %s", server.Parent().SpanID())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatal("trace id not propagated")
+	}
+	if spanAttr(server, "gateway.backend").AsString() != upstream.URL || spanAttr(server, "http.response.status_code").AsInt64() != 200 {
+		t.Fatalf("bad server attributes %v", server.Attributes())
+	}
+	for i, client := range spans[:2] {
+		if client.SpanKind() != trace.SpanKindClient || client.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Fatalf("client span %d not a child of the server span", i)
+		}
+	}
+	if spanAttr(spans[0], "gateway.error_class").AsString() != CategoryUpstreamError {
+		t.Fatalf("failed attempt should record error class, got %v", spans[0].Attributes())
+	}
+	if spanAttr(spans[1], "http.response.status_code").AsInt64() != 200 {
+		t.Fatal("second attempt should record status")
+	}
+	if upstreamTraceparent == "" || upstreamTraceparent[36:52] != spans[1].SpanContext().SpanID().String() {
+		t.Fatalf("upstream traceparent %q should carry the client span", upstreamTraceparent)
+	}
+}
+
+func TestTracingB3Extract(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	recorder := tracetest.NewSpanRecorder()
+	p := NewProxy(lb, Options{Tracing: TracingOptions{Enabled: true, TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))}})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	req.Header.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	req.Header.Set("X-B3-Sampled", "1")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	spans := recorder.Ended()
+	if got := spans[len(spans)-1].SpanContext().TraceID().String(); got != "80f198ee56343ba864fe8b2a57d3eff7" {
+		t.Fatalf("b3 trace id not extracted, got %s", got)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	p := NewProxy(&load_balance.RoundRobinBalance{}, Options{})
+	if p.tracing != nil {
+		t.Fatal("tracing should be disabled by default")
+	}
+	if _, ok := p.reverseProxy.Transport.(*tracingTransport); ok {
+		t.Fatal("transport should not be wrapped when tracing is disabled")
+	}
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func trailerServer(status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"internal":true}`))
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+}
+
+func TestTrailerPropagation(t *testing.T) {
+	transform := &JSONTransform{Response: func(resp *http.Response, body map[string]interface{}) error {
+		delete(body, "internal")
+		return nil
+	}}
+	for _, tc := range []struct {
+		name   string
+		status int
+		opts   Options
+		route  *Route
+	}{
+		{name: "plain", status: http.StatusOK},
+		{name: "error decoration", status: http.StatusInternalServerError, opts: Options{ErrorPrefix: "upstream error:"}},
+		{name: "json transform", status: http.StatusOK, route: &Route{JSONTransform: transform}},
+	} {
+		upstream := trailerServer(tc.status)
+		lb := &load_balance.RoundRobinBalance{}
+		lb.Add(upstream.URL)
+		route := tc.route
+		if route == nil {
+			route = &Route{}
+		}
+		route.Name, route.PathPrefix, route.Handler = "api", "/", NewProxy(lb, tc.opts)
+		r := NewRouter()
+		r.Handle(route)
+		gw := httptest.NewServer(r)
+
+		resp, err := http.Get(gw.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || string(body) != `{"internal":true}` {
+			t.Fatalf("%s: got %d %q", tc.name, resp.StatusCode, body)
+		}
+		if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+			t.Fatalf("%s: trailer lost, got %q", tc.name, got)
+		}
+		gw.Close()
+		upstream.Close()
+	}
+}
@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 单个后端的连接参数，零值字段使用默认 transport 的配置
+type TransportConf struct {
+	MaxIdleConnsPerHost   int
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	IdleConnTimeout       time.Duration
+	ResponseHeaderTimeout time.Duration
+	TLSConfig             *tls.Config
+	OutboundProxy         *OutboundProxy //出站代理，nil 表示沿用默认 transport 的设置(环境变量)
+}
+
+// 按后端 host 区分的 transport 集合，在 Director 改写 req.URL.Host 之后选择对应的 transport。
+// 未配置的后端从默认 transport 复制一份独立使用，摘除后端时可以单独关闭它的空闲连接。
+// 通过 Track 跟踪负载均衡后，注册中心、DNS、文件等配置删除的后端也会释放其 transport
+type TransportRegistry struct {
+	base *http.Transport
+
+	mux        sync.RWMutex
+	confs      map[string]TransportConf
+	transports map[string]*http.Transport
+	trackers   map[*transportTracker]bool
+}
+
+func NewTransportRegistry(base *http.Transport) *TransportRegistry {
+	if base == nil {
+		base = DefaultTransport
+	}
+	return &TransportRegistry{
+		base:       base,
+		confs:      map[string]TransportConf{},
+		transports: map[string]*http.Transport{},
+		trackers:   map[*transportTracker]bool{},
+	}
+}
+
+// 设置后端的连接参数，addr 可以是 host:port 或完整的后端地址。已有的 transport 会被替换并关闭空闲连接
+func (r *TransportRegistry) Set(addr string, conf TransportConf) {
+	host := transportHost(addr)
+	r.mux.Lock()
+	old := r.transports[host]
+	r.confs[host] = conf
+	r.transports[host] = r.newTransport(conf)
+	r.mux.Unlock()
+	if old != nil {
+		old.CloseIdleConnections()
+	}
+}
+
+// 已设置连接参数的后端 host 及其参数
+func (r *TransportRegistry) Confs() map[string]TransportConf {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	confs := make(map[string]TransportConf, len(r.confs))
+	for host, conf := range r.confs {
+		confs[host] = conf
+	}
+	return confs
+}
+
+// 后端被摘除时调用，关闭其空闲连接并删除配置
+func (r *TransportRegistry) Remove(addr string) {
+	host := transportHost(addr)
+	r.mux.Lock()
+	t := r.transports[host]
+	delete(r.transports, host)
+	delete(r.confs, host)
+	r.mux.Unlock()
+	if t != nil {
+		t.CloseIdleConnections()
+	}
+}
+
+// 获取后端使用的 transport，不存在时按默认配置创建
+func (r *TransportRegistry) Transport(addr string) *http.Transport {
+	host := transportHost(addr)
+	r.mux.RLock()
+	t, ok := r.transports[host]
+	r.mux.RUnlock()
+	if ok {
+		return t
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if t, ok = r.transports[host]; !ok {
+		t = r.newTransport(r.confs[host])
+		r.transports[host] = t
+	}
+	return t
+}
+
+// 跟踪 lb 的节点变化，节点从 lb 中删除且不在其他被跟踪的 lb 中时关闭并删除它的 transport，
+// 通过 Set 设置的连接参数保留。conf 为 lb 使用的配置主题，需在 lb.SetConf 之后调用，
+// 使 lb 先于跟踪者收到更新
+func (r *TransportRegistry) Track(lb load_balance.ManagedBalance, conf load_balance.LoadBalanceConf) {
+	t := &transportTracker{r: r, lb: lb, conf: conf, hosts: serverHosts(lb)}
+	r.mux.Lock()
+	r.trackers[t] = true
+	r.mux.Unlock()
+	conf.Attach(t)
+}
+
+// 停止跟踪 lb 并释放只有它使用的 transport，如删除路由时调用
+func (r *TransportRegistry) Untrack(lb load_balance.ManagedBalance) {
+	r.mux.Lock()
+	removed := []*transportTracker{}
+	for t := range r.trackers {
+		if t.lb == lb {
+			removed = append(removed, t)
+			delete(r.trackers, t)
+		}
+	}
+	r.mux.Unlock()
+	for _, t := range removed {
+		t.conf.Detach(t)
+		t.mux.Lock()
+		hosts := t.hosts
+		t.mux.Unlock()
+		for host := range hosts {
+			r.evict(host)
+		}
+	}
+}
+
+// 配置更新后比较 lb 的节点，释放被删除节点的 transport
+type transportTracker struct {
+	r    *TransportRegistry
+	lb   load_balance.ManagedBalance
+	conf load_balance.LoadBalanceConf
+
+	mux   sync.Mutex
+	hosts map[string]bool
+}
+
+func (t *transportTracker) Update() {
+	hosts := serverHosts(t.lb)
+	t.mux.Lock()
+	removed := []string{}
+	for host := range t.hosts {
+		if !hosts[host] {
+			removed = append(removed, host)
+		}
+	}
+	t.hosts = hosts
+	t.mux.Unlock()
+	for _, host := range removed {
+		t.r.evict(host)
+	}
+}
+
+func (t *transportTracker) has(host string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.hosts[host]
+}
+
+func serverHosts(lb load_balance.ManagedBalance) map[string]bool {
+	hosts := map[string]bool{}
+	for _, addr := range lb.Servers() {
+		hosts[transportHost(addr)] = true
+	}
+	return hosts
+}
+
+// 关闭并删除 host 的 transport，其他被跟踪的 lb 仍在使用时保留
+func (r *TransportRegistry) evict(host string) {
+	r.mux.Lock()
+	for t := range r.trackers {
+		if t.has(host) {
+			r.mux.Unlock()
+			return
+		}
+	}
+	tr := r.transports[host]
+	delete(r.transports, host)
+	r.mux.Unlock()
+	if tr != nil {
+		tr.CloseIdleConnections()
+	}
+}
+
+func (r *TransportRegistry) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.Transport(req.URL.Host).RoundTrip(req)
+}
+
+func (r *TransportRegistry) CloseIdleConnections() {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	for _, t := range r.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+func (r *TransportRegistry) newTransport(conf TransportConf) *http.Transport {
+	t := r.base.Clone()
+	dialer := defaultDialer
+	if conf.DialTimeout > 0 || conf.KeepAlive > 0 {
+		dialer = NewDialer(DialerConf{Timeout: conf.DialTimeout, KeepAlive: conf.KeepAlive})
+		t.DialContext = dialer.DialContext
+	}
+	if conf.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+	if conf.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = conf.IdleConnTimeout
+	}
+	if conf.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = conf.ResponseHeaderTimeout
+	}
+	if conf.TLSConfig != nil {
+		t.TLSClientConfig = conf.TLSConfig.Clone()
+	}
+	if conf.OutboundProxy != nil {
+		t.Proxy = conf.OutboundProxy.proxyFunc()
+	}
+	//设置了 DialTLSContext 时 transport 不再使用 TLSClientConfig，需要按新的配置重建
+	if t.DialTLSContext != nil && (dialer != defaultDialer || conf.TLSConfig != nil) {
+		t.DialTLSContext = dialer.DialTLSContext(t.TLSClientConfig)
+	}
+	return t
+}
+
+func transportHost(addr string) string {
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return addr
+}
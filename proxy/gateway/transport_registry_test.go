@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportRegistryPerHost(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer other.Close()
+
+	r := NewTransportRegistry(nil)
+	r.Set(slow.URL, TransportConf{ResponseHeaderTimeout: 50 * time.Millisecond, MaxIdleConnsPerHost: 7})
+	if tr := r.Transport(strings.TrimPrefix(slow.URL, "http://")); tr.MaxIdleConnsPerHost != 7 || tr.ResponseHeaderTimeout != 50*time.Millisecond {
+		t.Fatalf("per-host settings not applied: %d %s", tr.MaxIdleConnsPerHost, tr.ResponseHeaderTimeout)
+	}
+	if tr := r.Transport(other.URL); tr.MaxIdleConnsPerHost != DefaultTransport.MaxIdleConnsPerHost || tr.ResponseHeaderTimeout != 0 {
+		t.Fatal("unconfigured host should use default settings")
+	}
+
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(slow.URL)
+	p := NewProxy(lb, Options{Transport: r})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("slow host should hit its header timeout, got %d", rec.Code)
+	}
+	lb = &load_balance.RoundRobinBalance{}
+	lb.Add(other.URL)
+	p = NewProxy(lb, Options{Transport: r})
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("other host should not inherit the timeout, got %d", rec.Code)
+	}
+}
+
+func TestTransportRegistryRemoveClosesIdle(t *testing.T) {
+	var closed int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	upstream.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt64(&closed, 1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	r := NewTransportRegistry(nil)
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	admin := NewAdmin(nil)
+	admin.Transports = r
+	admin.AddPool("default", lb)
+	p := NewProxy(lb, Options{Transport: r})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt64(&closed) != 0 {
+		t.Fatal("connection should be kept alive before removal")
+	}
+
+	if rec := adminDo(admin.Handler(), "DELETE", "/backends/"+url.PathEscape(upstream.URL), ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("remove got %d", rec.Code)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&closed) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt64(&closed) != 1 {
+		t.Fatal("idle connection of removed backend not closed")
+	}
+}
+
+// 同步通知监听者的配置主题
+type listConf struct {
+	load_balance.LoadBalanceConf
+	list      []string
+	observers []load_balance.Observer
+}
+
+func (c *listConf) Attach(o load_balance.Observer) { c.observers = append(c.observers, o) }
+
+func (c *listConf) Detach(o load_balance.Observer) {
+	for i, obs := range c.observers {
+		if obs == o {
+			c.observers = append(c.observers[:i], c.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *listConf) GetConf() []string { return c.list }
+
+func (c *listConf) UpdateConf(list []string) {
+	c.list = list
+	for _, o := range c.observers {
+		o.Update()
+	}
+}
+
+func TestTransportRegistryTrackEvictsRemovedBackends(t *testing.T) {
+	r := NewTransportRegistry(nil)
+	conf := &listConf{list: []string{"http://10.0.0.1:80,10", "http://10.0.0.2:80,10"}}
+	lb := &load_balance.RoundRobinBalance{}
+	lb.SetConf(conf)
+	conf.Attach(lb)
+	lb.Update()
+	//另一个 pool 也使用 10.0.0.2
+	other := &load_balance.RoundRobinBalance{}
+	other.Add("http://10.0.0.2:80")
+	otherConf := &listConf{}
+	r.Track(lb, conf)
+	r.Track(other, otherConf)
+	r.Set("10.0.0.1:80", TransportConf{MaxIdleConnsPerHost: 3})
+	for _, host := range []string{"10.0.0.1:80", "10.0.0.2:80"} {
+		r.Transport(host)
+	}
+
+	conf.UpdateConf([]string{"http://10.0.0.3:80,10"})
+	r.mux.RLock()
+	_, kept1 := r.transports["10.0.0.1:80"]
+	_, kept2 := r.transports["10.0.0.2:80"]
+	r.mux.RUnlock()
+	if kept1 || !kept2 {
+		t.Fatalf("10.0.0.1 kept %v, shared 10.0.0.2 kept %v", kept1, kept2)
+	}
+	//重新出现时仍使用 Set 的参数
+	if tr := r.Transport("10.0.0.1:80"); tr.MaxIdleConnsPerHost != 3 {
+		t.Fatalf("conf lost, MaxIdleConnsPerHost %d", tr.MaxIdleConnsPerHost)
+	}
+
+	r.Untrack(other)
+	r.mux.RLock()
+	_, kept2 = r.transports["10.0.0.2:80"]
+	r.mux.RUnlock()
+	if kept2 || len(otherConf.observers) != 0 {
+		t.Fatal("untracked pool's transport not released")
+	}
+}
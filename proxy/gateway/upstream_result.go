@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func init() {
+	metrics.Backends.Classify = errorCategory
+}
+
+// 每次上游尝试结束后调用 metrics.RecordUpstreamResult：连接失败时立即记录，
+// 收到响应时在响应体关闭后记录，耗时与字节数包含读取响应体的部分。重试的每次尝试分别记录
+func recordUpstreamResult(backend string, start time.Time, resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		metrics.RecordUpstreamResult(backend, 0, time.Since(start), 0, err)
+		return resp, err
+	}
+	//协议升级的响应体是双向连接，不做包装
+	if resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		metrics.RecordUpstreamResult(backend, resp.StatusCode, time.Since(start), 0, nil)
+		return resp, nil
+	}
+	resp.Body = &upstreamResultBody{ReadCloser: resp.Body, backend: backend, status: resp.StatusCode, start: start}
+	return resp, nil
+}
+
+type upstreamResultBody struct {
+	io.ReadCloser
+	backend string
+	status  int
+	start   time.Time
+	once    sync.Once
+
+	//看门狗超时时会在读取的同时关闭响应体
+	mux sync.Mutex
+	n   int64
+	err error //读取响应体时的错误，不包含 io.EOF
+}
+
+func (b *upstreamResultBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mux.Lock()
+	b.n += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) && b.err == nil {
+		b.err = err
+	}
+	b.mux.Unlock()
+	return n, err
+}
+
+func (b *upstreamResultBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.mux.Lock()
+		n, readErr := b.n, b.err
+		b.mux.Unlock()
+		metrics.RecordUpstreamResult(b.backend, b.status, time.Since(b.start), n, readErr)
+	})
+	return err
+}
@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamResultRetries(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+	refused := refusedAddr(t)
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+	lb.Add(refused)
+	p := NewProxy(lb, Options{MaxRetries: 1})
+
+	//连接失败的尝试记在失败的后端上，重试成功的尝试记在重试的后端上
+	for _, path := range []string{"/", "/", "/fail"} {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	failed, ok := metrics.Backends.Get(refused)
+	if !ok || failed.Requests == 0 || failed.Failures[CategoryConnectionRefused] != failed.Requests || failed.Bytes != 0 {
+		t.Fatalf("refused backend %+v", failed)
+	}
+	good, ok := metrics.Backends.Get(upstream.URL)
+	if !ok || good.Requests != 3 || good.Bytes != 15 || good.Latency.Count != 3 ||
+		len(good.Failures) != 1 || good.Failures[metrics.FailureClass5xx] != 1 {
+		t.Fatalf("upstream backend %+v", good)
+	}
+}
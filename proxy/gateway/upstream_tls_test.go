@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 新生成的自签名证书，对 example.com 与 127.0.0.1 有效，与 httptest 默认证书不同
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"example.com"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+// 使用新生成的自签名证书的 TLS 后端
+func newSelfSignedServer(t *testing.T, h http.Handler) *httptest.Server {
+	cert, _ := selfSignedCert(t)
+	srv := httptest.NewUnstartedServer(h)
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var tlsOK = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+func certPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func tlsProxyGet(t *testing.T, backend string, conf *TransportConf) int {
+	r := NewTransportRegistry(nil)
+	if conf != nil {
+		r.Set(backend, *conf)
+	}
+	lb := &load_balance.RoundRobinBalance{}
+	lb.Add(backend)
+	p := NewProxy(lb, Options{Transport: r})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	return rec.Code
+}
+
+func TestUpstreamTLSCustomCA(t *testing.T) {
+	srv := httptest.NewTLSServer(tlsOK)
+	defer srv.Close()
+
+	//默认使用系统根证书，自签名证书校验失败
+	if code := tlsProxyGet(t, srv.URL, nil); code != http.StatusBadGateway {
+		t.Fatalf("self-signed upstream should fail default verification, got %d", code)
+	}
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, certPEM(srv.Certificate()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewUpstreamTLSConfig(UpstreamTLSConf{CAFile: file, ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := tlsProxyGet(t, srv.URL, &TransportConf{TLSConfig: cfg}); code != http.StatusOK {
+		t.Fatalf("custom CA should be trusted, got %d", code)
+	}
+
+	cfg, _ = NewUpstreamTLSConfig(UpstreamTLSConf{CAPEM: certPEM(srv.Certificate()), ServerName: "wrong.test"})
+	if code := tlsProxyGet(t, srv.URL, &TransportConf{TLSConfig: cfg}); code != http.StatusBadGateway {
+		t.Fatalf("server name override should be verified, got %d", code)
+	}
+
+	if _, err := NewUpstreamTLSConfig(UpstreamTLSConf{CAPEM: []byte("not a cert")}); err == nil {
+		t.Fatal("invalid CA bundle should be rejected")
+	}
+}
+
+func TestUpstreamTLSPinning(t *testing.T) {
+	pinned := httptest.NewTLSServer(tlsOK)
+	defer pinned.Close()
+	swapped := newSelfSignedServer(t, tlsOK)
+
+	//两个 CA 都受信任，只有 pin 住的证书能通过
+	bundle := append(certPEM(pinned.Certificate()), certPEM(swapped.Certificate())...)
+	cfg, err := NewUpstreamTLSConfig(UpstreamTLSConf{CAPEM: bundle, SPKIPins: []string{SPKIPin(pinned.Certificate())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := tlsProxyGet(t, pinned.URL, &TransportConf{TLSConfig: cfg}); code != http.StatusOK {
+		t.Fatalf("pinned certificate rejected, got %d", code)
+	}
+	if code := tlsProxyGet(t, swapped.URL, &TransportConf{TLSConfig: cfg}); code != http.StatusBadGateway {
+		t.Fatalf("swapped certificate should fail pinning, got %d", code)
+	}
+
+	//跳过校验时仍然检查 pin
+	cfg, _ = NewUpstreamTLSConfig(UpstreamTLSConf{InsecureSkipVerify: true, SPKIPins: []string{SPKIPin(pinned.Certificate())}})
+	if code := tlsProxyGet(t, pinned.URL, &TransportConf{TLSConfig: cfg}); code != http.StatusOK {
+		t.Fatalf("insecure pinned request rejected, got %d", code)
+	}
+	if code := tlsProxyGet(t, swapped.URL, &TransportConf{TLSConfig: cfg}); code != http.StatusBadGateway {
+		t.Fatalf("insecure swapped certificate should fail pinning, got %d", code)
+	}
+
+	if _, err := NewUpstreamTLSConfig(UpstreamTLSConf{SPKIPins: []string{"abc"}}); err == nil {
+		t.Fatal("malformed pin should be rejected")
+	}
+}
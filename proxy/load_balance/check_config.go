@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"slices"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -21,19 +23,41 @@ type LoadBalanceCheckConf struct {
 	confIpWeight map[string]string
 	activeList   []string
 	format       string
+	safety       *ConfSafety
+	mux          sync.RWMutex //保护 observers 与 activeList，探活在后台 goroutine 中
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// 设置保护策略，探活结果被拒绝时保留上一次的列表
+func (s *LoadBalanceCheckConf) SetSafety(safety ConfSafety) {
+	s.safety = &safety
 }
 
 func (s *LoadBalanceCheckConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	s.observers = append(s.observers, o)
 }
 
+func (s *LoadBalanceCheckConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
 func (s *LoadBalanceCheckConf) NotifyAllObservers() {
-	for _, obs := range s.observers {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
 		obs.Update()
 	}
 }
 
 func (s *LoadBalanceCheckConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
 	confList := []string{}
 	for _, ip := range s.activeList {
 		weight, ok := s.confIpWeight[ip]
@@ -73,11 +97,24 @@ func (s *LoadBalanceCheckConf) WatchConf() {
 				}
 			}
 			sort.Strings(changedList)
-			sort.Strings(s.activeList)
-			if !reflect.DeepEqual(changedList, s.activeList) {
-				s.UpdateConf(changedList)
+			s.mux.RLock()
+			activeList := slices.Clone(s.activeList)
+			s.mux.RUnlock()
+			sort.Strings(activeList)
+			if !reflect.DeepEqual(changedList, activeList) {
+				if s.safety == nil {
+					s.UpdateConf(changedList)
+				} else if err := s.safety.Check(activeList, changedList); err != nil {
+					fmt.Println("check conf update rejected", err)
+				} else {
+					s.UpdateConf(changedList)
+				}
+			}
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(time.Duration(DefaultCheckInterval) * time.Second):
 			}
-			time.Sleep(time.Duration(DefaultCheckInterval) * time.Second)
 		}
 	}()
 }
@@ -85,19 +122,26 @@ func (s *LoadBalanceCheckConf) WatchConf() {
 //更新配置时，通知监听者也更新
 func (s *LoadBalanceCheckConf) UpdateConf(conf []string) {
 	fmt.Println("UpdateConf", conf)
+	s.mux.Lock()
 	s.activeList = conf
-	for _, obs := range s.observers {
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
 		obs.Update()
 	}
 }
 
+func (s *LoadBalanceCheckConf) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
 func NewLoadBalanceCheckConf(format string, conf map[string]string) (*LoadBalanceCheckConf, error) {
 	aList := []string{}
 	//默认初始化
 	for item, _ := range conf {
 		aList = append(aList, item)
 	}
-	mConf := &LoadBalanceCheckConf{format: format, activeList: aList, confIpWeight: conf}
+	mConf := &LoadBalanceCheckConf{format: format, activeList: aList, confIpWeight: conf, stop: make(chan struct{})}
 	mConf.WatchConf()
 	return mConf, nil
 }
\ No newline at end of file
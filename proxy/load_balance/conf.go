@@ -1,94 +1,134 @@
 package load_balance
 
 import (
-	"GO_GATEWAY/proxy/zookeeper"
+	"GO_GATEWAY/proxy/registry"
+	"errors"
 	"fmt"
 )
 
 // 配置主题
 type LoadBalanceConf interface {
 	Attach(o Observer)
+	Detach(o Observer)
 	GetConf() []string
 	WatchConf()
 	UpdateConf(conf []string)
+	//停止 watch 并释放注册中心连接，可以重复调用
+	Close()
 }
 
-type LoadBalanceZkConf struct {
-	observers    []Observer
-	path         string
-	zkHosts      []string
-	confIpWeight map[string]string
-	activeList   []string
-	format       string
+// 能提供结构化后端的配置主题。GetConf 仍提供 "地址,权重" 列表，
+// 按可用区、协议等元数据选择节点的负载均衡可以通过 Backends 获取完整信息
+type BackendConf interface {
+	Backends() []registry.Backend
+	//Backend.Addr 在 GetConf 中对应的地址，即负载均衡返回的地址
+	BackendAddr(addr string) string
 }
 
-func (s *LoadBalanceZkConf) Attach(o Observer) {
-	s.observers = append(s.observers, o)
+// 可以在运行时替换后端筛选条件的配置主题，如管理接口调整标签表达式
+type FilterConf interface {
+	SetFilter(filter *registry.Filter)
+	Filter() *registry.Filter
 }
 
-func (s *LoadBalanceZkConf) NotifyAllObservers() {
-	for _, obs := range s.observers {
-		obs.Update()
-	}
+// zk 配置主题，是 zk 注册中心上的 LoadBalanceRegistryConf
+type LoadBalanceZkConf struct {
+	*LoadBalanceRegistryConf
+	path    string
+	paths   []string
+	zkHosts []string
+	zk      []*registry.ZkRegistry //Close 时释放 zk 连接
 }
 
-func (s *LoadBalanceZkConf) GetConf() []string {
-	confList := []string{}
-	for _, ip := range s.activeList {
-		weight, ok := s.confIpWeight[ip]
-		if !ok {
-			weight = "50" //默认weight
-		}
-		confList = append(confList, fmt.Sprintf(s.format, ip)+","+weight)
+// zk 不可用时使用快照启动，见 NewLoadBalanceRegistryConfWithSnapshot
+func NewLoadBalanceZkConfWithSnapshot(format, path string, zkHosts []string, conf map[string]string, snapshot SnapshotOptions) (*LoadBalanceZkConf, error) {
+	zkRegistry, err := registry.NewZkRegistry(zkHosts, path)
+	if err != nil {
+		return nil, err
 	}
-	return confList
+	rConf, err := NewLoadBalanceRegistryConfWithSnapshot(format, zkRegistry, conf, snapshot)
+	if err != nil {
+		zkRegistry.Close()
+		return nil, err
+	}
+	return &LoadBalanceZkConf{LoadBalanceRegistryConf: rConf, path: path, zkHosts: zkHosts, zk: []*registry.ZkRegistry{zkRegistry}}, nil
 }
 
-// 更新配置时，通知监听者也更新
-func (s *LoadBalanceZkConf) WatchConf() {
-	zkManager := zookeeper.NewZkManager(s.zkHosts)
-	zkManager.GetConnect()
-	fmt.Println("watchConf")
-	chanList, chanErr := zkManager.WatchServerListByPath(s.path)
-	go func() {
-		defer zkManager.Close()
-		for {
-			select {
-			case changeErr := <-chanErr:
-				fmt.Println("changeErr", changeErr)
-			case changedList := <-chanList:
-				fmt.Println("watch node changed")
-				s.UpdateConf(changedList)
-			}
-		}
-	}()
+// 多路径配置中的一个 zk 路径，如不同数据中心的服务目录
+type ZkPath struct {
+	Path         string
+	WeightFactor float64 //权重乘数，0 表示 1
+	Zone         string  //该路径下节点没有 zone 元数据时使用
 }
 
-// 更新配置时，通知监听者也更新
-func (s *LoadBalanceZkConf) UpdateConf(conf []string) {
-	s.activeList = conf
-	for _, obs := range s.observers {
-		obs.Update()
+func NewLoadBalanceZkConf(format, path string, zkHosts []string, conf map[string]string) (*LoadBalanceZkConf, error) {
+	zkRegistry, err := registry.NewZkRegistry(zkHosts, path)
+	if err != nil {
+		return nil, err
 	}
+	rConf, err := NewLoadBalanceRegistryConf(format, zkRegistry, conf)
+	if err != nil {
+		zkRegistry.Close()
+		return nil, err
+	}
+	return &LoadBalanceZkConf{LoadBalanceRegistryConf: rConf, path: path, zkHosts: zkHosts, zk: []*registry.ZkRegistry{zkRegistry}}, nil
 }
 
-func NewLoadBalanceZkConf(format, path string, zkHosts []string, conf map[string]string) (*LoadBalanceZkConf, error) {
-	zkManager := zookeeper.NewZkManager(zkHosts)
-	zkManager.GetConnect()
-	defer zkManager.Close()
-	zlist, err := zkManager.GetServerListByPath(path)
+// 合并多个 zk 路径的子节点，同一地址出现在多个路径时以列表中靠前的路径为准。
+// 各路径的变化经过去抖后合并为一次 Update
+func NewLoadBalanceZkMultiConf(format string, paths []ZkPath, zkHosts []string, conf map[string]string) (*LoadBalanceZkConf, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("zk paths required")
+	}
+	sources := make([]registry.MultiSource, 0, len(paths))
+	names := make([]string, 0, len(paths))
+	zk := make([]*registry.ZkRegistry, 0, len(paths))
+	closeAll := func() {
+		for _, r := range zk {
+			r.Close()
+		}
+	}
+	for _, p := range paths {
+		zkRegistry, err := registry.NewZkRegistry(zkHosts, p.Path)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sources = append(sources, registry.MultiSource{Registry: zkRegistry, WeightFactor: p.WeightFactor, Zone: p.Zone})
+		zk = append(zk, zkRegistry)
+		names = append(names, p.Path)
+	}
+	rConf, err := NewLoadBalanceRegistryConf(format, registry.NewMulti(sources...), conf)
 	if err != nil {
+		closeAll()
 		return nil, err
 	}
-	mConf := &LoadBalanceZkConf{format: format, activeList: zlist, confIpWeight: conf, zkHosts: zkHosts, path: path}
-	mConf.WatchConf()
-	return mConf, nil
+	return &LoadBalanceZkConf{LoadBalanceRegistryConf: rConf, path: names[0], paths: names, zkHosts: zkHosts, zk: zk}, nil
+}
+
+// 停止 watch 后释放 zk 连接
+func (s *LoadBalanceZkConf) Close() {
+	s.LoadBalanceRegistryConf.Close()
+	for _, r := range s.zk {
+		r.Close()
+	}
 }
 
 type Observer interface {
 	Update()
 }
 
+// 返回去掉 o 的新切片，不修改原切片，正在遍历原切片通知的 goroutine 不受影响
+func detachObserver(observers []Observer, o Observer) []Observer {
+	list := make([]Observer, 0, len(observers))
+	for _, obs := range observers {
+		if obs != o {
+			list = append(list, obs)
+		}
+	}
+	return list
+}
+
 type LoadBalanceObserver struct {
 	ModuleConf *LoadBalanceZkConf
 }
@@ -0,0 +1,84 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/registry"
+	"sync/atomic"
+	"time"
+)
+
+var confUpdates = metrics.NewCounterVec("gateway_lb_conf_updates_total", "注册中心推送的后端列表，按结果(published/coalesced/suppressed)统计", "result")
+
+// 合并注册中心的更新：quiet 内没有新列表时才发布最新的列表，从第一个未发布的列表起最多等待 maxDelay，
+// 避免滚动发布时每个节点变化都重置负载均衡。maxDelay 为 0 表示不限制
+func (s *LoadBalanceRegistryConf) SetDebounce(quiet, maxDelay time.Duration) {
+	s.mux.Lock()
+	s.quiet, s.maxDelay = quiet, maxDelay
+	s.mux.Unlock()
+}
+
+// 被后续列表覆盖的次数，与没有变化(或被保护策略拒绝)、未通知监听者的次数
+func (s *LoadBalanceRegistryConf) UpdateCounts() (coalesced, suppressed int64) {
+	return atomic.LoadInt64(&s.coalesced), atomic.LoadInt64(&s.suppressed)
+}
+
+func (s *LoadBalanceRegistryConf) watchLoop(ch <-chan []registry.Backend) {
+	var pending []registry.Backend
+	hasPending := false
+	quiet := time.NewTimer(time.Hour)
+	quiet.Stop()
+	var deadline <-chan time.Time
+	for {
+		select {
+		case list, ok := <-ch:
+			if !ok {
+				quiet.Stop()
+				return
+			}
+			s.mux.RLock()
+			wait, maxDelay := s.quiet, s.maxDelay
+			s.mux.RUnlock()
+			if wait <= 0 {
+				s.publish(list)
+				continue
+			}
+			if hasPending {
+				atomic.AddInt64(&s.coalesced, 1)
+				confUpdates.Inc("coalesced")
+			}
+			pending, hasPending = list, true
+			stopTimer(quiet)
+			quiet.Reset(wait)
+			if deadline == nil && maxDelay > 0 {
+				deadline = time.After(maxDelay)
+			}
+			continue
+		case <-quiet.C:
+		case <-deadline:
+			stopTimer(quiet)
+		}
+		if hasPending {
+			s.publish(pending)
+		}
+		pending, hasPending, deadline = nil, false, nil
+	}
+}
+
+func (s *LoadBalanceRegistryConf) publish(list []registry.Backend) {
+	if !s.Stale() && s.apply(list) {
+		confUpdates.Inc("published")
+		return
+	}
+	atomic.AddInt64(&s.suppressed, 1)
+	confUpdates.Inc("suppressed")
+}
+
+// 停止 timer 并丢弃已经到期、未读取的时间
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 把每个列表都推送给 watcher 的注册中心，模拟 zk 的每个子节点事件
+type pushRegistry struct {
+	*registry.Memory
+	pushes chan []registry.Backend
+}
+
+func (r *pushRegistry) Watch(ctx context.Context) (<-chan []registry.Backend, error) {
+	return r.pushes, nil
+}
+
+func backendList(n int) []registry.Backend {
+	list := []registry.Backend{}
+	for i := 0; i < n; i++ {
+		list = append(list, registry.Backend{Addr: fmt.Sprintf("127.0.0.1:%d", 8001+i)})
+	}
+	return list
+}
+
+func TestRegistryConfDebounce(t *testing.T) {
+	r := &pushRegistry{Memory: registry.NewMemory(), pushes: make(chan []registry.Backend)}
+	conf, err := NewLoadBalanceRegistryConf("%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	conf.SetDebounce(100*time.Millisecond, time.Second)
+	updates := &countObserver{}
+	conf.Attach(updates)
+
+	//滚动发布：20 个连续的列表只发布最后一个
+	for i := 1; i <= 20; i++ {
+		r.pushes <- backendList(i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for updates.get() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not published")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := updates.get(); n > 2 {
+		t.Fatalf("updates %d", n)
+	}
+	if got := conf.GetConf(); len(got) != 20 || got[19] != "127.0.0.1:8020,"+DefaultRegistryWeight {
+		t.Fatalf("conf %v", got)
+	}
+	if coalesced, _ := conf.UpdateCounts(); coalesced < 18 {
+		t.Fatalf("coalesced %d", coalesced)
+	}
+
+	//列表相同时不通知
+	before := updates.get()
+	r.pushes <- backendList(20)
+	time.Sleep(200 * time.Millisecond)
+	if _, suppressed := conf.UpdateCounts(); suppressed != 1 || updates.get() != before {
+		t.Fatalf("suppressed %d, updates %d", suppressed, updates.get())
+	}
+}
+
+func TestRegistryConfDebounceMaxDelay(t *testing.T) {
+	r := &pushRegistry{Memory: registry.NewMemory(), pushes: make(chan []registry.Backend)}
+	conf, err := NewLoadBalanceRegistryConf("%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	conf.SetDebounce(50*time.Millisecond, 150*time.Millisecond)
+
+	//持续变化时最多推迟 maxDelay
+	var published []string
+	for i := 1; i <= 30 && published == nil; i++ {
+		r.pushes <- backendList(i)
+		time.Sleep(20 * time.Millisecond)
+		if got := conf.GetConf(); len(got) > 0 {
+			published = got
+		}
+	}
+	if published == nil || len(published) == 30 {
+		t.Fatalf("update postponed past max delay: %v", published)
+	}
+}
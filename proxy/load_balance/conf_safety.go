@@ -0,0 +1,54 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"errors"
+	"fmt"
+)
+
+var confRejected = metrics.NewCounterVec("gateway_lb_conf_rejected_total", "被保护策略拒绝的后端列表更新次数，按原因(empty/remove_ratio/invalid_ratio)统计", "reason")
+
+var (
+	ErrConfEmpty         = errors.New("conf update would remove all backends")
+	ErrConfRemoveTooMany = errors.New("conf update would remove too many backends")
+)
+
+// 后端列表更新的保护策略，避免注册中心瞬时为空或 watch 异常时清空所有负载均衡。
+// 被拒绝时保留上一次的列表，之后正常的更新到来时恢复
+type ConfSafety struct {
+	MaxRemovePercent int  //一次更新最多移除当前后端的百分比(1-100)，0 表示不限制
+	AllowEmpty       bool //允许更新为空列表，有意缩容到 0 时设置
+	MinValidPercent  int  //通过格式校验的后端少于该百分比时拒绝整个更新，0 表示只丢弃不合法的后端
+}
+
+// 检查从 current 更新为 next 是否允许，当前为空时总是允许
+func (s ConfSafety) Check(current, next []string) error {
+	if len(current) == 0 {
+		return nil
+	}
+	if len(next) == 0 {
+		if s.AllowEmpty {
+			return nil
+		}
+		confRejected.Inc("empty")
+		return ErrConfEmpty
+	}
+	if s.MaxRemovePercent <= 0 {
+		return nil
+	}
+	kept := map[string]bool{}
+	for _, ip := range next {
+		kept[ip] = true
+	}
+	removed := 0
+	for _, ip := range current {
+		if !kept[ip] {
+			removed++
+		}
+	}
+	if removed*100 > s.MaxRemovePercent*len(current) {
+		confRejected.Inc("remove_ratio")
+		return fmt.Errorf("%w: %d of %d", ErrConfRemoveTooMany, removed, len(current))
+	}
+	return nil
+}
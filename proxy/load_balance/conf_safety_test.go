@@ -0,0 +1,98 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfSafetyCheck(t *testing.T) {
+	current := []string{"a", "b", "c", "d"}
+	cases := []struct {
+		safety ConfSafety
+		next   []string
+		err    error
+	}{
+		{ConfSafety{}, nil, ErrConfEmpty},
+		{ConfSafety{AllowEmpty: true}, nil, nil},
+		{ConfSafety{MaxRemovePercent: 50}, []string{"a", "b"}, nil},
+		{ConfSafety{MaxRemovePercent: 50}, []string{"a"}, ErrConfRemoveTooMany},
+		{ConfSafety{MaxRemovePercent: 50}, []string{"a", "e", "f"}, ErrConfRemoveTooMany},
+		{ConfSafety{}, []string{"e"}, nil},
+	}
+	for i, c := range cases {
+		if err := c.safety.Check(current, c.next); !errors.Is(err, c.err) {
+			t.Fatalf("case %d got %v want %v", i, err, c.err)
+		}
+	}
+	//当前为空时允许任何更新
+	if err := (ConfSafety{}).Check(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 每次更新时记录负载均衡中的节点数
+type poolObserver struct {
+	mux   sync.Mutex
+	lb    *WeightRoundRobinBalance
+	sizes []int
+}
+
+func (o *poolObserver) Update() {
+	o.mux.Lock()
+	o.sizes = append(o.sizes, len(o.lb.Servers()))
+	o.mux.Unlock()
+}
+
+func TestRegistryConfRejectsTransientEmpty(t *testing.T) {
+	r := registry.NewMemory()
+	for _, addr := range []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"} {
+		r.Register(registry.Backend{Addr: addr})
+	}
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	conf.SetSafety(ConfSafety{MaxRemovePercent: 50})
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	obs := &poolObserver{lb: lb}
+	conf.Attach(obs)
+
+	//所有后端同时重启，注册中心短暂为空
+	rejected := confRejected.Get("empty")
+	for _, addr := range []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"} {
+		r.Deregister(addr)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for confRejected.Get("empty") == rejected {
+		if time.Now().After(deadline) {
+			t.Fatal("empty snapshot not rejected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := len(lb.Servers()); got == 0 {
+		t.Fatal("pool emptied")
+	}
+	for _, addr := range []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8004"} {
+		r.Register(registry.Backend{Addr: addr})
+	}
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002", "http://127.0.0.1:8004"})
+	obs.mux.Lock()
+	sizes := append([]int(nil), obs.sizes...)
+	obs.mux.Unlock()
+	for _, size := range sizes {
+		if size == 0 {
+			t.Fatalf("balancer saw an empty pool: %v", sizes)
+		}
+	}
+
+	//有意缩容到 0
+	conf.SetSafety(ConfSafety{AllowEmpty: true})
+	for _, addr := range []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8004"} {
+		r.Deregister(addr)
+	}
+	waitServers(t, lb, []string{})
+}
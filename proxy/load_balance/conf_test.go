@@ -0,0 +1,143 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestConfDetach(t *testing.T) {
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001"})
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	kept, detached := &countObserver{}, &countObserver{}
+	conf.Attach(kept)
+	conf.Attach(detached)
+	conf.Detach(detached)
+	conf.UpdateConf([]string{"127.0.0.1:8002"})
+	if kept.get() != 1 || detached.get() != 0 {
+		t.Fatalf("kept %d detached %d", kept.get(), detached.get())
+	}
+}
+
+// 等待后台 goroutine 退出，返回最终的数量
+func waitGoroutines(max int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= max || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfCloseStopsGoroutines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	if err := os.WriteFile(path, []byte("backends:\n- addr: 127.0.0.1:8001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		r := registry.NewMemory()
+		r.Register(registry.Backend{Addr: "127.0.0.1:8001"})
+		rConf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fConf, err := NewLoadBalanceFileConf("http://%s", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dConf, err := NewLoadBalanceDnsConf("http://%s", map[string]string{"a.internal:80": "10"}, &fakeResolver{hosts: map[string][]string{"a.internal": {"10.0.0.1"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cConf, err := NewLoadBalanceCheckConf("http://%s", map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hConf := NewHybridConf([]string{"http://127.0.0.1:9000,10"}, rConf, fConf, dConf, cConf)
+		lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, hConf).(*WeightRoundRobinBalance)
+		hConf.Detach(lb)
+		hConf.Close()
+	}
+	if after := waitGoroutines(before); after > before {
+		t.Fatalf("goroutines before %d after %d", before, after)
+	}
+}
+
+// 发布更新的同时增删监听者，配合 -race 检查
+func TestConfDetachConcurrentWithUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	if err := os.WriteFile(path, []byte("backends:\n- addr: 127.0.0.1:8001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001"})
+	rConf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rConf.Close()
+	fConf, err := NewLoadBalanceFileConf("http://%s", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fConf.Close()
+	dConf, err := NewLoadBalanceDnsConf("http://%s", map[string]string{"a.internal:80": "10"}, &fakeResolver{hosts: map[string][]string{"a.internal": {"10.0.0.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dConf.Close()
+	cConf, err := NewLoadBalanceCheckConf("http://%s", map[string]string{"127.0.0.1:8001": "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cConf.Close()
+	hConf := NewHybridConf([]string{"http://127.0.0.1:9001,10"}, rConf)
+	defer hConf.Close()
+
+	for _, conf := range []interface {
+		LoadBalanceConf
+		NotifyAllObservers()
+	}{rConf, fConf, dConf, cConf, hConf} {
+		kept := &countObserver{}
+		conf.Attach(kept)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 200; i++ {
+				if i%2 == 0 {
+					conf.UpdateConf([]string{fmt.Sprintf("127.0.0.1:%d,10", 8000+i%10)})
+				} else {
+					conf.NotifyAllObservers()
+				}
+			}
+		}()
+	loop:
+		for {
+			select {
+			case <-done:
+				break loop
+			default:
+			}
+			o := &countObserver{}
+			conf.Attach(o)
+			conf.Detach(o)
+		}
+		before := kept.get()
+		conf.NotifyAllObservers()
+		if kept.get() != before+1 {
+			t.Fatalf("%T: kept observer not notified", conf)
+		}
+	}
+}
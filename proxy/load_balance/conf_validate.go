@@ -0,0 +1,124 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/registry"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 权重上限，超过的通常是配置错误
+const MaxConfWeight = 10000
+
+var confInvalid = metrics.NewCounterVec("gateway_lb_conf_invalid_entries_total", "校验失败被丢弃的后端，按字段(addr/weight/zone)统计", "field")
+
+var ErrConfTooManyInvalid = errors.New("too many invalid conf entries")
+
+var zoneLabel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// 一个校验失败的后端
+type ConfEntryError struct {
+	Entry string
+	Field string //addr、weight 或 zone
+	Err   error
+}
+
+func (e *ConfEntryError) Error() string {
+	return fmt.Sprintf("invalid conf entry %q: %s: %v", e.Entry, e.Field, e.Err)
+}
+
+func (e *ConfEntryError) Unwrap() error {
+	return e.Err
+}
+
+// 解析 "地址,权重[,元数据...]"，地址为 host:port 或带 host 的 URL，权重为 0~MaxConfWeight
+func ParseConfEntry(entry string) (registry.Backend, error) {
+	parts := strings.Split(entry, ",")
+	if len(parts) < 2 {
+		return registry.Backend{}, &ConfEntryError{Entry: entry, Field: "weight", Err: errors.New("missing weight")}
+	}
+	if err := validateConfAddr(parts[0]); err != nil {
+		return registry.Backend{}, &ConfEntryError{Entry: entry, Field: "addr", Err: err}
+	}
+	weight, err := strconv.Atoi(parts[1])
+	if err == nil && (weight < 0 || weight > MaxConfWeight) {
+		err = fmt.Errorf("out of range [0, %d]", MaxConfWeight)
+	}
+	if err != nil {
+		return registry.Backend{}, &ConfEntryError{Entry: entry, Field: "weight", Err: err}
+	}
+	return registry.Backend{Addr: parts[0], Weight: weight}, nil
+}
+
+func validateConfAddr(addr string) error {
+	host := addr
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		if u.Host == "" {
+			return errors.New("missing host")
+		}
+		if u.Port() == "" {
+			return nil
+		}
+		host = u.Host
+	}
+	_, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return err
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// 可用区为 1~63 位字母、数字、'.'、'_'、'-'，以字母或数字开头
+func ValidateZone(zone string) error {
+	if !zoneLabel.MatchString(zone) {
+		return fmt.Errorf("invalid zone %q", zone)
+	}
+	return nil
+}
+
+// 按 format 校验注册中心的后端，返回通过的后端与每个失败的原因
+func validateBackends(format string, list []registry.Backend) ([]registry.Backend, []*ConfEntryError) {
+	valid := make([]registry.Backend, 0, len(list))
+	var invalid []*ConfEntryError
+	for _, b := range list {
+		entry := fmt.Sprintf(format, b.Addr) + "," + strconv.Itoa(b.Weight)
+		_, err := ParseConfEntry(entry)
+		if err == nil {
+			if zone, ok := b.Metadata[registry.MetadataZone]; ok {
+				if zErr := ValidateZone(zone); zErr != nil {
+					err = &ConfEntryError{Entry: entry, Field: "zone", Err: zErr}
+				}
+			}
+		}
+		if err != nil {
+			entryErr := err.(*ConfEntryError)
+			confInvalid.Inc(entryErr.Field)
+			fmt.Println("skip invalid backend", entryErr)
+			invalid = append(invalid, entryErr)
+			continue
+		}
+		valid = append(valid, b)
+	}
+	return valid, invalid
+}
+
+// 通过校验的后端比例低于 MinValidPercent 时拒绝整个更新
+func (s ConfSafety) checkValid(total, valid int) error {
+	if s.MinValidPercent <= 0 || total == 0 || valid*100 >= s.MinValidPercent*total {
+		return nil
+	}
+	confRejected.Inc("invalid_ratio")
+	return fmt.Errorf("%w: %d of %d valid", ErrConfTooManyInvalid, valid, total)
+}
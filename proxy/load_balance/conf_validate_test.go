@@ -0,0 +1,86 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseConfEntry(t *testing.T) {
+	for entry, field := range map[string]string{
+		"127.0.0.1:8080,50":           "",
+		"http://127.0.0.1:8080,0":     "",
+		"https://orders.internal,10":  "",
+		"127.0.0.1:8080,abc":          "weight",
+		"127.0.0.1:8080,-1":           "weight",
+		"127.0.0.1:8080,10001":        "weight",
+		"127.0.0.1:8080":              "weight",
+		"127.0.0.1,50":                "addr",
+		"127.0.0.1:99999,50":          "addr",
+		"http://,50":                  "addr",
+		"http://127.0.0.1:8080%zz,50": "addr",
+	} {
+		_, err := ParseConfEntry(entry)
+		var entryErr *ConfEntryError
+		if field == "" && err != nil || field != "" && (!errors.As(err, &entryErr) || entryErr.Field != field) {
+			t.Errorf("%s: err %v, want field %q", entry, err, field)
+		}
+	}
+	if b, _ := ParseConfEntry("http://127.0.0.1:8080,20,zone=hz"); b.Addr != "http://127.0.0.1:8080" || b.Weight != 20 {
+		t.Fatalf("backend %+v", b)
+	}
+}
+
+func TestRegistryConfSkipsInvalidBackends(t *testing.T) {
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Weight: 20000})
+	r.Register(registry.Backend{Addr: "127.0.0.1:0", Weight: 10})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8004", Metadata: map[string]string{registry.MetadataZone: "hz a"}})
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001"})
+	fields := []string{}
+	for _, e := range conf.InvalidEntries() {
+		fields = append(fields, e.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"addr", "weight", "zone"}) {
+		t.Fatalf("invalid %v", conf.InvalidEntries())
+	}
+
+	//合法的后端少于 80% 时拒绝整个更新
+	conf.SetSafety(ConfSafety{MinValidPercent: 80})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8005", Weight: 10})
+	time.Sleep(100 * time.Millisecond)
+	if got := sortedServers(lb); !reflect.DeepEqual(got, []string{"http://127.0.0.1:8001"}) {
+		t.Fatalf("servers %v", got)
+	}
+	for _, addr := range []string{"127.0.0.1:8002", "127.0.0.1:0", "127.0.0.1:8004"} {
+		r.Deregister(addr)
+	}
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8005"})
+	if n := len(conf.InvalidEntries()); n != 0 {
+		t.Fatalf("invalid %d", n)
+	}
+}
+
+func TestSnapshotSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	os.WriteFile(path, []byte(`{"schema":2,"time":"2026-01-01T00:00:00Z","backends":[]}`), 0644)
+	if _, err := loadSnapshot(path, 0, time.Now()); err == nil {
+		t.Fatal("newer schema accepted")
+	}
+	//没有版本号的旧快照按第一版读取
+	os.WriteFile(path, []byte(`{"time":"2026-01-01T00:00:00Z","backends":[{"addr":"127.0.0.1:8001","weight":10}]}`), 0644)
+	if backends, err := loadSnapshot(path, 0, time.Now()); err != nil || len(backends) != 1 {
+		t.Fatalf("backends %v err %v", backends, err)
+	}
+}
@@ -49,6 +49,8 @@ func NewConsistentHashBanlance(replicas int, fn Hash) *ConsistentHashBanlance {
 
 // 验证是否为空
 func (c *ConsistentHashBanlance) IsEmpty() bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
 	return len(c.keys) == 0
 }
 
@@ -56,9 +58,13 @@ func (c *ConsistentHashBanlance) Add(params...string)error{
 	if len(params)==0{
 		return nil
 	}
-	addr := params[0]
 	c.mux.Lock()
 	defer c.mux.Unlock()
+	c.add(params[0])
+	return nil
+}
+
+func (c *ConsistentHashBanlance) add(addr string){
 	// 结合复制因子计算节点hash值
 	for i:=0;i<c.replicas;i++{
 		hash := c.hash([]byte(strconv.Itoa(i)+addr))
@@ -66,11 +72,45 @@ func (c *ConsistentHashBanlance) Add(params...string)error{
 		c.hashMap[hash] = addr
 	}
 	sort.Sort(c.keys)
+}
+
+func (c *ConsistentHashBanlance) Remove(addr string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	keys := UInt32Slice{}
+	for _, k := range c.keys {
+		if c.hashMap[k] == addr {
+			delete(c.hashMap, k)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == len(c.keys) {
+		return ErrNodeNotFound
+	}
+	c.keys = keys
 	return nil
 }
 
+func (c *ConsistentHashBanlance) Servers() []string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	seen := map[string]bool{}
+	list := []string{}
+	for _, k := range c.keys {
+		if addr := c.hashMap[k]; !seen[addr] {
+			seen[addr] = true
+			list = append(list, addr)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
 func (c *ConsistentHashBanlance) Get(key string)(string,error){
-	if c.IsEmpty(){
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if len(c.keys) == 0{
 		return "",errors.New("node is Empty!")
 	}
 	hash := c.hash([]byte(key))
@@ -80,9 +120,25 @@ func (c *ConsistentHashBanlance) Get(key string)(string,error){
 	if idx == len(c.keys) {
 		idx = 0
 	}
+	return c.hashMap[c.keys[idx]], nil
+}
+
+// 沿哈希环顺时针查找第一个未被排除的节点
+func (c *ConsistentHashBanlance) GetExcluding(key string, excluded map[string]bool) (string, error){
 	c.mux.RLock()
 	defer c.mux.RUnlock()
-	return c.hashMap[c.keys[idx]], nil
+	if len(c.keys) == 0 {
+		return "", ErrNoBackends
+	}
+	hash := c.hash([]byte(key))
+	idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	for i := 0; i < len(c.keys); i++ {
+		addr := c.hashMap[c.keys[(idx+i)%len(c.keys)]]
+		if !excluded[addr] {
+			return addr, nil
+		}
+	}
+	return "", ErrNoBackends
 }
 
 func (c *ConsistentHashBanlance) SetConf(conf LoadBalanceConf) {
@@ -90,20 +146,26 @@ func (c *ConsistentHashBanlance) SetConf(conf LoadBalanceConf) {
 }
 
 func (c *ConsistentHashBanlance) Update() {
-	if conf, ok := c.conf.(*LoadBalanceZkConf); ok {
-		fmt.Println("Update get conf:", conf.GetConf())
-		c.keys = nil
-		c.hashMap = nil
-		for _, ip := range conf.GetConf() {
-			c.Add(strings.Split(ip, ",")...)
-		}
+	//所有配置主题都通过 GetConf 提供 "地址,权重" 列表
+	if c.conf == nil {
+		return
 	}
-	if conf, ok := c.conf.(*LoadBalanceCheckConf); ok {
-		fmt.Println("Update get conf:", conf.GetConf())
-		c.keys = nil
-		c.hashMap = map[uint32]string{}
-		for _, ip := range conf.GetConf() {
-			c.Add(strings.Split(ip, ",")...)
-		}
+	conf := c.conf.GetConf()
+	fmt.Println("Update get conf:", conf)
+	c.reset(conf)
+}
+
+func (c *ConsistentHashBanlance) SetServers(conf []string) {
+	c.reset(conf)
+}
+
+// 用配置整体替换节点列表
+func (c *ConsistentHashBanlance) reset(confList []string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.keys = nil
+	c.hashMap = map[uint32]string{}
+	for _, ip := range confList {
+		c.add(strings.Split(ip, ",")[0])
 	}
 }
\ No newline at end of file
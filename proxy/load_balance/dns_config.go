@@ -0,0 +1,211 @@
+package load_balance
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	//default dns setting
+	DefaultDnsInterval = 30 * time.Second
+	DefaultDnsMinTTL   = 5 * time.Second
+	DefaultDnsTimeout  = 5 * time.Second
+)
+
+// 域名解析器，net.DefaultResolver 满足该接口
+type DnsResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// 能返回记录 TTL 的解析器，实现后按 TTL 刷新
+type DnsTTLResolver interface {
+	LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error)
+}
+
+// 按域名配置后端，定时解析 A/AAAA 记录并把每个 IP 作为单独的后端
+type LoadBalanceDnsConf struct {
+	observers    []Observer
+	confIpWeight map[string]string //host:port -> weight
+	format       string
+	resolver     DnsResolver
+	Interval     time.Duration //未提供 TTL 时的刷新间隔
+	MinTTL       time.Duration //刷新间隔下限
+
+	mux        sync.RWMutex
+	resolved   map[string][]string //host:port -> 最近一次成功解析出的 ip:port
+	activeList []string            //解析出的 ip:port
+	ipWeight   map[string]string   //ip:port -> weight
+	wait       time.Duration       //下次解析前的等待时间
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func (s *LoadBalanceDnsConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *LoadBalanceDnsConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *LoadBalanceDnsConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceDnsConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	confList := []string{}
+	for _, ip := range s.activeList {
+		confList = append(confList, fmt.Sprintf(s.format, ip)+","+s.ipWeight[ip])
+	}
+	return confList
+}
+
+// 定时重新解析，解析结果变化时通知监听者
+func (s *LoadBalanceDnsConf) WatchConf() {
+	fmt.Println("watchConf")
+	go func() {
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(s.wait):
+				s.wait = s.refresh()
+			}
+		}
+	}()
+}
+
+// 更新配置时，通知监听者也更新
+func (s *LoadBalanceDnsConf) UpdateConf(conf []string) {
+	fmt.Println("UpdateConf", conf)
+	s.mux.Lock()
+	s.activeList = conf
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceDnsConf) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// 解析一次所有域名，返回下次解析前的等待时间。解析失败的域名保留上次的结果
+func (s *LoadBalanceDnsConf) refresh() time.Duration {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultDnsInterval
+	}
+	minTTL := s.MinTTL
+	if minTTL <= 0 {
+		minTTL = DefaultDnsMinTTL
+	}
+	var ttl time.Duration
+	changedList := []string{}
+	ipWeight := map[string]string{}
+	for item, weight := range s.confIpWeight {
+		addrs, itemTTL, err := s.resolve(item)
+		if err != nil {
+			fmt.Println("dns resolve error", item, err)
+			s.mux.RLock()
+			addrs = s.resolved[item]
+			s.mux.RUnlock()
+		} else {
+			s.mux.Lock()
+			s.resolved[item] = addrs
+			s.mux.Unlock()
+			if itemTTL > 0 && (ttl == 0 || itemTTL < ttl) {
+				ttl = itemTTL
+			}
+		}
+		for _, addr := range addrs {
+			if _, ok := ipWeight[addr]; !ok {
+				changedList = append(changedList, addr)
+			}
+			ipWeight[addr] = weight
+		}
+	}
+	sort.Strings(changedList)
+	s.mux.RLock()
+	changed := !reflect.DeepEqual(changedList, s.activeList) || !reflect.DeepEqual(ipWeight, s.ipWeight)
+	s.mux.RUnlock()
+	if changed {
+		s.mux.Lock()
+		s.ipWeight = ipWeight
+		s.mux.Unlock()
+		s.UpdateConf(changedList)
+	}
+	if ttl > 0 {
+		interval = ttl
+	}
+	if interval < minTTL {
+		interval = minTTL
+	}
+	return interval
+}
+
+// 把 host:port 解析成 ip:port 列表，host 本身是 IP 时直接返回
+func (s *LoadBalanceDnsConf) resolve(item string) ([]string, time.Duration, error) {
+	host, port, err := net.SplitHostPort(item)
+	if err != nil {
+		return nil, 0, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{item}, 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDnsTimeout)
+	defer cancel()
+	var ips []net.IPAddr
+	var ttl time.Duration
+	if r, ok := s.resolver.(DnsTTLResolver); ok {
+		ips, ttl, err = r.LookupIPAddrTTL(ctx, host)
+	} else {
+		ips, err = s.resolver.LookupIPAddr(ctx, host)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(ips) == 0 {
+		return nil, 0, fmt.Errorf("no addresses for %s", host)
+	}
+	addrs := []string{}
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.IP.String(), port))
+	}
+	sort.Strings(addrs)
+	return addrs, ttl, nil
+}
+
+// conf 为 host:port -> weight，resolver 为空时使用系统解析
+func NewLoadBalanceDnsConf(format string, conf map[string]string, resolver DnsResolver) (*LoadBalanceDnsConf, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	mConf := &LoadBalanceDnsConf{
+		format:       format,
+		confIpWeight: conf,
+		resolver:     resolver,
+		resolved:     map[string][]string{},
+		stop:         make(chan struct{}),
+	}
+	mConf.wait = mConf.refresh()
+	mConf.WatchConf()
+	return mConf, nil
+}
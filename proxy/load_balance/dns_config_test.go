@@ -0,0 +1,107 @@
+package load_balance
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	mux   sync.Mutex
+	hosts map[string][]string
+	ttl   time.Duration
+	err   error
+}
+
+func (f *fakeResolver) set(host string, ips ...string) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.hosts[host] = ips
+}
+
+func (f *fakeResolver) LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	addrs := []net.IPAddr{}
+	for _, ip := range f.hosts[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, f.ttl, nil
+}
+
+func (f *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, _, err := f.LookupIPAddrTTL(ctx, host)
+	return addrs, err
+}
+
+func sortedServers(lb ManagedBalance) []string {
+	list := lb.Servers()
+	sort.Strings(list)
+	return list
+}
+
+func TestDnsConfPropagates(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]string{}}
+	resolver.set("api-blue.internal", "10.0.0.1", "10.0.0.2")
+	resolver.set("api-green.internal", "fd00::1")
+	conf, err := NewLoadBalanceDnsConf("http://%s", map[string]string{
+		"api-blue.internal:8080":  "20",
+		"api-green.internal:8080": "5",
+		"10.0.9.9:8080":           "1",
+	}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+
+	want := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.9.9:8080", "http://[fd00::1]:8080"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if w, _ := lb.Weight("http://10.0.0.2:8080"); w != 20 {
+		t.Fatalf("weight not preserved: %d", w)
+	}
+	if w, _ := lb.Weight("http://[fd00::1]:8080"); w != 5 {
+		t.Fatalf("weight not preserved: %d", w)
+	}
+
+	//故障切换：10.0.0.1 下线，新增 10.0.0.3
+	resolver.set("api-blue.internal", "10.0.0.2", "10.0.0.3")
+	conf.refresh()
+	want = []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080", "http://10.0.9.9:8080", "http://[fd00::1]:8080"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	//解析失败保留上次结果
+	resolver.mux.Lock()
+	resolver.err = errors.New("servfail")
+	resolver.mux.Unlock()
+	conf.refresh()
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolution failure changed backends: %v", got)
+	}
+}
+
+func TestDnsConfMinTTL(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]string{"a.internal": {"10.0.0.1"}}, ttl: time.Second}
+	conf, _ := NewLoadBalanceDnsConf("%s", map[string]string{"a.internal:80": "1"}, resolver)
+	defer conf.Close()
+	conf.MinTTL = 3 * time.Second
+	if wait := conf.refresh(); wait != 3*time.Second {
+		t.Fatalf("ttl below floor should be raised, got %s", wait)
+	}
+	resolver.ttl = time.Minute
+	if wait := conf.refresh(); wait != time.Minute {
+		t.Fatalf("record ttl ignored, got %s", wait)
+	}
+}
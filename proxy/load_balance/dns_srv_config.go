@@ -0,0 +1,187 @@
+package load_balance
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultDnsSrvInterval = 30 * time.Second
+
+// 定时查询 SRV 记录，每条记录的 target:port 作为后端，记录权重作为后端权重
+type LoadBalanceDNSSRVConf struct {
+	observers []Observer
+	format    string
+	name      string //SRV 名称，如 _http._tcp.orders.service.consul
+	resolver  *net.Resolver
+	Interval  time.Duration //查询间隔，默认 DefaultDnsSrvInterval
+	Jitter    time.Duration //每次查询间隔额外增加 [0, Jitter) 的随机时间，避免多个网关同时查询
+
+	mux        sync.RWMutex
+	activeList []string          //target:port
+	ipWeight   map[string]string //target:port -> weight
+	priority   map[string]uint16 //target:port -> SRV priority
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func (s *LoadBalanceDNSSRVConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *LoadBalanceDNSSRVConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *LoadBalanceDNSSRVConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceDNSSRVConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	confList := []string{}
+	for _, ip := range s.activeList {
+		confList = append(confList, fmt.Sprintf(s.format, ip)+","+s.ipWeight[ip])
+	}
+	return confList
+}
+
+// 后端的 SRV 优先级。目前没有按优先级分组的负载均衡，所有优先级的记录合并为同一组后端
+func (s *LoadBalanceDNSSRVConf) Priority(addr string) (uint16, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	p, ok := s.priority[addr]
+	return p, ok
+}
+
+// 定时重新查询，结果变化时通知监听者
+func (s *LoadBalanceDNSSRVConf) WatchConf() {
+	fmt.Println("watchConf")
+	go func() {
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(s.nextWait()):
+				s.refresh()
+			}
+		}
+	}()
+}
+
+// 更新配置时，通知监听者也更新
+func (s *LoadBalanceDNSSRVConf) UpdateConf(conf []string) {
+	fmt.Println("UpdateConf", conf)
+	s.mux.Lock()
+	s.activeList = conf
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceDNSSRVConf) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *LoadBalanceDNSSRVConf) nextWait() time.Duration {
+	wait := s.Interval
+	if wait <= 0 {
+		wait = DefaultDnsSrvInterval
+	}
+	if s.Jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(s.Jitter)))
+	}
+	return wait
+}
+
+// 查询一次 SRV 记录，查询失败时保留当前的后端列表。
+// 响应被截断时解析器会改用 TCP 重新查询
+func (s *LoadBalanceDNSSRVConf) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDnsTimeout)
+	defer cancel()
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		fmt.Println("dns srv lookup error", s.name, err)
+		return err
+	}
+	if len(records) == 0 {
+		err = fmt.Errorf("no srv records for %s", s.name)
+		fmt.Println("dns srv lookup error", s.name, err)
+		return err
+	}
+	changedList := []string{}
+	ipWeight := map[string]string{}
+	priority := map[string]uint16{}
+	for _, srv := range records {
+		addr := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		if _, ok := ipWeight[addr]; ok {
+			continue
+		}
+		changedList = append(changedList, addr)
+		//权重为 0 的记录仍然可用，只是很少被选中
+		weight := int(srv.Weight)
+		if weight < 1 {
+			weight = 1
+		}
+		ipWeight[addr] = strconv.Itoa(weight)
+		priority[addr] = srv.Priority
+	}
+	sort.Strings(changedList)
+	s.mux.Lock()
+	s.priority = priority
+	changed := !reflect.DeepEqual(changedList, s.activeList) || !reflect.DeepEqual(ipWeight, s.ipWeight)
+	if changed {
+		s.ipWeight = ipWeight
+	}
+	s.mux.Unlock()
+	if changed {
+		s.UpdateConf(changedList)
+	}
+	return nil
+}
+
+// server 为 DNS 服务器地址(如 127.0.0.1:8600)，为空时使用系统配置。首次查询失败时返回错误
+func NewLoadBalanceDNSSRVConf(format string, name string, server string) (*LoadBalanceDNSSRVConf, error) {
+	resolver := net.DefaultResolver
+	if server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	mConf := &LoadBalanceDNSSRVConf{
+		format:   format,
+		name:     name,
+		resolver: resolver,
+		ipWeight: map[string]string{},
+		priority: map[string]uint16{},
+		stop:     make(chan struct{}),
+	}
+	if err := mConf.refresh(); err != nil {
+		return nil, err
+	}
+	mConf.WatchConf()
+	return mConf, nil
+}
@@ -0,0 +1,212 @@
+package load_balance
+
+import (
+	"encoding/binary"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// 在同一端口上提供 UDP 与 TCP 查询的 SRV 服务器
+type stubSRVServer struct {
+	mux      sync.Mutex
+	records  []dnsmessage.SRVResource
+	fail     bool //返回 SERVFAIL
+	truncate bool //UDP 响应只设置截断标记，完整结果只能通过 TCP 获得
+	tcpHits  int
+
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+func newStubSRVServer(t *testing.T) *stubSRVServer {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Fatal(err)
+	}
+	s := &stubSRVServer{udp: udp, tcp: tcp}
+	go s.serveUDP()
+	go s.serveTCP()
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+	return s
+}
+
+func (s *stubSRVServer) set(records ...dnsmessage.SRVResource) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.records = records
+}
+
+func (s *stubSRVServer) answer(query []byte, tcp bool) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	if tcp {
+		s.tcpHits++
+	}
+	switch {
+	case s.fail:
+		resp.RCode = dnsmessage.RCodeServerFailure
+	case s.truncate && !tcp:
+		resp.Truncated = true
+	default:
+		for _, r := range s.records {
+			r := r
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 5},
+				Body:   &r,
+			})
+		}
+	}
+	data, _ := resp.Pack()
+	return data
+}
+
+func (s *stubSRVServer) serveUDP() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if data := s.answer(buf[:n], false); data != nil {
+			s.udp.WriteTo(data, addr)
+		}
+	}
+}
+
+func (s *stubSRVServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var size [2]byte
+			if _, err := io.ReadFull(conn, size[:]); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(size[:]))
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			data := s.answer(query, true)
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...))
+		}()
+	}
+}
+
+func srvRecord(target string, port, priority, weight uint16) dnsmessage.SRVResource {
+	return dnsmessage.SRVResource{Target: dnsmessage.MustNewName(target), Port: port, Priority: priority, Weight: weight}
+}
+
+type countObserver struct {
+	mux   sync.Mutex
+	count int
+}
+
+func (o *countObserver) Update() {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.count++
+}
+
+func TestDNSSRVConfPropagates(t *testing.T) {
+	server := newStubSRVServer(t)
+	server.set(srvRecord("a.orders.consul.", 8080, 1, 10), srvRecord("b.orders.consul.", 8081, 2, 0))
+	conf, err := NewLoadBalanceDNSSRVConf("http://%s", "_http._tcp.orders.consul.", server.udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	counter := &countObserver{}
+	conf.Attach(counter)
+
+	//不同优先级的记录合并为同一组后端
+	want := []string{"http://a.orders.consul:8080", "http://b.orders.consul:8081"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if w, _ := lb.Weight("http://a.orders.consul:8080"); w != 10 {
+		t.Fatalf("weight not preserved: %d", w)
+	}
+	if w, _ := lb.Weight("http://b.orders.consul:8081"); w != 1 {
+		t.Fatalf("zero weight should map to 1: %d", w)
+	}
+	if p, ok := conf.Priority("b.orders.consul:8081"); !ok || p != 2 {
+		t.Fatalf("priority %d %v", p, ok)
+	}
+
+	//结果不变时不通知监听者
+	conf.refresh()
+	if counter.count != 0 {
+		t.Fatalf("unchanged result notified %d times", counter.count)
+	}
+
+	//记录变化：b 移除，c 加入，a 权重调整
+	server.set(srvRecord("a.orders.consul.", 8080, 1, 20), srvRecord("c.orders.consul.", 8080, 1, 5))
+	conf.refresh()
+	want = []string{"http://a.orders.consul:8080", "http://c.orders.consul:8080"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if w, _ := lb.Weight("http://a.orders.consul:8080"); w != 20 {
+		t.Fatalf("weight not updated: %d", w)
+	}
+	if counter.count != 1 {
+		t.Fatalf("notified %d times", counter.count)
+	}
+
+	//查询失败时保留当前列表
+	server.mux.Lock()
+	server.fail = true
+	server.mux.Unlock()
+	if err := conf.refresh(); err == nil {
+		t.Fatal("refresh should report the error")
+	}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("lookup failure changed backends: %v", got)
+	}
+}
+
+func TestDNSSRVConfTruncated(t *testing.T) {
+	server := newStubSRVServer(t)
+	server.truncate = true
+	server.set(srvRecord("a.orders.consul.", 8080, 1, 1))
+	conf, err := NewLoadBalanceDNSSRVConf("%s", "_http._tcp.orders.consul.", server.udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	if got := conf.GetConf(); !reflect.DeepEqual(got, []string{"a.orders.consul:8080,1"}) {
+		t.Fatalf("got %v", got)
+	}
+	if server.tcpHits == 0 {
+		t.Fatal("truncated response did not fall back to tcp")
+	}
+}
@@ -0,0 +1,96 @@
+package load_balance
+
+import (
+	"sort"
+	"strconv"
+)
+
+// 负载均衡对某个 key 的选择结果及原因
+type Decision struct {
+	Addr       string         `json:"addr,omitempty"` //随机算法无法预测时为空
+	Algorithm  string         `json:"algorithm"`
+	Reason     string         `json:"reason"`
+	Candidates []string       `json:"candidates"`
+	Weights    map[string]int `json:"weights,omitempty"`
+	KeyHash    *uint32        `json:"key_hash,omitempty"`  //一致性哈希中 key 的哈希值
+	NodeHash   *uint32        `json:"node_hash,omitempty"` //key 落到的虚拟节点在哈希环上的位置
+}
+
+// 不改变状态地预测下一次 Get(key) 的结果，用于调试接口
+type ExplainingBalance interface {
+	Explain(key string) (Decision, error)
+}
+
+func (r *RoundRobinBalance) Explain(key string) (Decision, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	d := Decision{Algorithm: "round_robin", Candidates: append([]string{}, r.rss...)}
+	if len(r.rss) == 0 {
+		return d, ErrNoBackends
+	}
+	next := (r.curIndex + 1) % len(r.rss)
+	d.Addr = r.rss[next]
+	d.Reason = "next in rotation: position " + strconv.Itoa(next) + " of " + strconv.Itoa(len(r.rss))
+	return d, nil
+}
+
+func (r *WeightRoundRobinBalance) Explain(key string) (Decision, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	d := Decision{Algorithm: "weight_round_robin", Candidates: []string{}, Weights: map[string]int{}}
+	//按 next 的算法计算，但不修改节点的 currentWeight
+	var best *WeightNode
+	bestWeight := 0
+	for _, w := range r.rss {
+		d.Candidates = append(d.Candidates, w.addr)
+		d.Weights[w.addr] = w.weight
+		current := w.currentWeight + w.effectiveWeight
+		if best == nil || current > bestWeight {
+			best, bestWeight = w, current
+		}
+	}
+	if best == nil {
+		return d, ErrNoBackends
+	}
+	d.Addr = best.addr
+	d.Reason = "highest smooth weight: current " + strconv.Itoa(bestWeight) + ", weight " + strconv.Itoa(best.weight)
+	return d, nil
+}
+
+func (c *ConsistentHashBanlance) Explain(key string) (Decision, error) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	d := Decision{Algorithm: "consistent_hash", Candidates: []string{}}
+	seen := map[string]bool{}
+	for _, addr := range c.hashMap {
+		if !seen[addr] {
+			seen[addr] = true
+			d.Candidates = append(d.Candidates, addr)
+		}
+	}
+	sort.Strings(d.Candidates)
+	if len(c.keys) == 0 {
+		return d, ErrNoBackends
+	}
+	hash := c.hash([]byte(key))
+	idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	if idx == len(c.keys) {
+		idx = 0
+	}
+	node := c.keys[idx]
+	d.Addr = c.hashMap[node]
+	d.KeyHash, d.NodeHash = &hash, &node
+	d.Reason = "key " + strconv.Quote(key) + " maps to the first ring node at or after its hash"
+	return d, nil
+}
+
+func (r *RandomBalance) Explain(key string) (Decision, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	d := Decision{Algorithm: "random", Candidates: append([]string{}, r.rss...)}
+	if len(r.rss) == 0 {
+		return d, ErrNoBackends
+	}
+	d.Reason = "uniformly random among " + strconv.Itoa(len(r.rss)) + " candidates"
+	return d, nil
+}
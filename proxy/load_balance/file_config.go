@@ -0,0 +1,231 @@
+package load_balance
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 文件变化后等待写入完成再加载，编辑器保存时可能产生多个事件
+const DefaultFileReloadDelay = 100 * time.Millisecond
+
+// 配置文件中的一个后端
+type FileBackend struct {
+	Addr     string            `json:"addr"`   //host:port
+	Weight   int               `json:"weight"` //为 0 时按 1 处理
+	Zone     string            `json:"zone,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// 配置文件内容，YAML 与 JSON 均可
+type FileBackends struct {
+	Backends []FileBackend `json:"backends"`
+}
+
+// 从本地文件读取后端，文件变化时重新加载。内容不合法时保留上一次的配置
+type LoadBalanceFileConf struct {
+	observers []Observer
+	format    string
+	path      string
+	watcher   *fsnotify.Watcher
+
+	mux        sync.RWMutex
+	activeList []string          //host:port
+	ipWeight   map[string]string //host:port -> weight
+	backends   map[string]FileBackend
+	checksum   [sha256.Size]byte
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func (s *LoadBalanceFileConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *LoadBalanceFileConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *LoadBalanceFileConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceFileConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	confList := []string{}
+	for _, ip := range s.activeList {
+		confList = append(confList, fmt.Sprintf(s.format, ip)+","+s.ipWeight[ip])
+	}
+	return confList
+}
+
+// 后端在配置文件中的 zone 与 metadata
+func (s *LoadBalanceFileConf) Backend(addr string) (FileBackend, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	b, ok := s.backends[addr]
+	return b, ok
+}
+
+// 监听文件所在目录而不是文件本身：编辑器以 rename 的方式原子替换文件后，对原文件的监听会失效
+func (s *LoadBalanceFileConf) WatchConf() {
+	fmt.Println("watchConf")
+	go func() {
+		var reload <-chan time.Time
+		for {
+			select {
+			case <-s.stop:
+				return
+			case event, ok := <-s.watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == s.path && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					reload = time.After(DefaultFileReloadDelay)
+				}
+			case err, ok := <-s.watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Println("file watch error", s.path, err)
+			case <-reload:
+				reload = nil
+				s.reload()
+			}
+		}
+	}()
+}
+
+// 更新配置时，通知监听者也更新
+func (s *LoadBalanceFileConf) UpdateConf(conf []string) {
+	fmt.Println("UpdateConf", conf)
+	s.mux.Lock()
+	s.activeList = conf
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceFileConf) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.watcher.Close()
+	})
+}
+
+// 重新读取文件，内容未变化时不通知监听者
+func (s *LoadBalanceFileConf) reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		fmt.Println("file conf read error", s.path, err)
+		return err
+	}
+	checksum := sha256.Sum256(data)
+	s.mux.RLock()
+	same := checksum == s.checksum
+	s.mux.RUnlock()
+	if same {
+		return nil
+	}
+	backends, err := parseFileBackends(data)
+	if err != nil {
+		fmt.Println("file conf invalid, keep previous", s.path, err)
+		return err
+	}
+	changedList := []string{}
+	ipWeight := map[string]string{}
+	for addr, b := range backends {
+		changedList = append(changedList, addr)
+		ipWeight[addr] = strconv.Itoa(b.Weight)
+	}
+	sort.Strings(changedList)
+	s.mux.Lock()
+	s.checksum = checksum
+	s.backends = backends
+	changed := !reflect.DeepEqual(changedList, s.activeList) || !reflect.DeepEqual(ipWeight, s.ipWeight)
+	if changed {
+		s.ipWeight = ipWeight
+	}
+	s.mux.Unlock()
+	if changed {
+		s.UpdateConf(changedList)
+	}
+	return nil
+}
+
+// 解析并校验配置：至少一个后端，地址为 host:port 且不重复，权重不为负
+func parseFileBackends(data []byte) (map[string]FileBackend, error) {
+	var conf FileBackends
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		return nil, err
+	}
+	if len(conf.Backends) == 0 {
+		return nil, errors.New("no backends")
+	}
+	backends := map[string]FileBackend{}
+	for _, b := range conf.Backends {
+		if _, _, err := net.SplitHostPort(b.Addr); err != nil {
+			return nil, fmt.Errorf("backend %q: %v", b.Addr, err)
+		}
+		if _, ok := backends[b.Addr]; ok {
+			return nil, fmt.Errorf("backend %q: duplicated", b.Addr)
+		}
+		if b.Weight < 0 {
+			return nil, fmt.Errorf("backend %q: negative weight", b.Addr)
+		}
+		if b.Weight == 0 {
+			b.Weight = 1
+		}
+		backends[b.Addr] = b
+	}
+	return backends, nil
+}
+
+// format 如 http://%s，文件首次加载失败时返回错误
+func NewLoadBalanceFileConf(format string, path string) (*LoadBalanceFileConf, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	mConf := &LoadBalanceFileConf{
+		format:   format,
+		path:     path,
+		ipWeight: map[string]string{},
+		backends: map[string]FileBackend{},
+		stop:     make(chan struct{}),
+	}
+	if err := mConf.reload(); err != nil {
+		return nil, err
+	}
+	if mConf.watcher, err = fsnotify.NewWatcher(); err != nil {
+		return nil, err
+	}
+	if err := mConf.watcher.Add(filepath.Dir(path)); err != nil {
+		mConf.watcher.Close()
+		return nil, err
+	}
+	mConf.WatchConf()
+	return mConf, nil
+}
@@ -0,0 +1,139 @@
+package load_balance
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 先写临时文件再 rename，模拟编辑器的原子替换
+func replaceFile(t *testing.T, path, content string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (o *countObserver) get() int {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return o.count
+}
+
+func TestFileConfReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	if err := os.WriteFile(path, []byte(`
+backends:
+  - addr: 10.0.0.1:8080
+    weight: 10
+    zone: hz-a
+    metadata: {version: v1}
+  - addr: 10.0.0.2:8080
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := NewLoadBalanceFileConf("http://%s", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	counter := &countObserver{}
+	conf.Attach(counter)
+	want := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if w, _ := lb.Weight("http://10.0.0.2:8080"); w != 1 {
+		t.Fatalf("default weight %d", w)
+	}
+	if b, ok := conf.Backend("10.0.0.1:8080"); !ok || b.Zone != "hz-a" || b.Metadata["version"] != "v1" {
+		t.Fatalf("backend %+v", b)
+	}
+
+	//重写文件期间持续取后端
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if _, err := lb.Get(""); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	//原子替换，JSON 格式
+	replaceFile(t, path, `{"backends": [{"addr": "10.0.0.2:8080", "weight": 5}, {"addr": "10.0.0.3:8080"}]}`)
+	want = []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}
+	waitServers(t, lb, want)
+	time.Sleep(3 * DefaultFileReloadDelay)
+	if n := counter.get(); n != 1 {
+		t.Fatalf("updated %d times for one change", n)
+	}
+
+	//内容相同的写入不触发更新
+	replaceFile(t, path, `{"backends": [{"addr": "10.0.0.2:8080", "weight": 5}, {"addr": "10.0.0.3:8080"}]}`)
+	time.Sleep(3 * DefaultFileReloadDelay)
+	if n := counter.get(); n != 1 {
+		t.Fatalf("no-op rewrite updated: %d", n)
+	}
+
+	//不合法的内容保留上一次的配置
+	for _, bad := range []string{
+		`backends: [{addr: "10.0.0.4"}]`,
+		`backends: []`,
+		`backends: [{addr: "10.0.0.4:80"}, {addr: "10.0.0.4:80"}]`,
+		`backends: [{addr: "10.0.0.4:80", wieght: 3}]`,
+		`{"backends": [`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(3 * DefaultFileReloadDelay)
+		if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid edit %q changed backends: %v", bad, got)
+		}
+	}
+	if n := counter.get(); n != 1 {
+		t.Fatalf("invalid edits updated: %d", n)
+	}
+
+	//直接写入
+	if err := os.WriteFile(path, []byte("backends:\n  - addr: 10.0.0.5:8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitServers(t, lb, []string{"http://10.0.0.5:8080"})
+	time.Sleep(3 * DefaultFileReloadDelay)
+	if n := counter.get(); n != 2 {
+		t.Fatalf("updated %d times for two changes", n)
+	}
+}
+
+func TestFileConfInvalidAtStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	if err := os.WriteFile(path, []byte("backends: [{addr: nope}]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLoadBalanceFileConf("%s", path); err == nil {
+		t.Fatal("invalid file accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package load_balance
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 静态后端与动态配置主题组合而成的配置主题：静态后端(如固定的兜底节点)总在列表中，
+// 再合并各动态来源的后端。同一地址同时出现在静态与动态列表中时使用动态来源的权重
+type HybridConf struct {
+	observers []Observer
+	sources   []LoadBalanceConf
+
+	mux        sync.RWMutex
+	static     []string //"地址,权重"，与 GetConf 的格式相同
+	dynamic    []string //最近一次接受的动态后端
+	activeList []string
+	safety     *ConfSafety
+	//静态后端是否计入保护策略。计入时动态来源全部消失也不算空列表，只保留静态后端；
+	//不计入时按动态列表单独检查，动态来源瞬时为空时保留上一次的动态后端
+	staticCountsForSafety bool
+	freezeOnStale         bool //有来源处于 stale 模式时不接收新的动态后端
+}
+
+// static 为 "地址,权重" 列表，sources 为动态配置主题，可以为空
+func NewHybridConf(static []string, sources ...LoadBalanceConf) *HybridConf {
+	s := &HybridConf{sources: sources, static: append([]string{}, static...), dynamic: []string{}}
+	for _, source := range sources {
+		source.Attach(hybridSourceObserver{s})
+	}
+	s.mux.Lock()
+	s.dynamic = s.collect()
+	s.activeList = mergeConf(s.static, s.dynamic)
+	s.mux.Unlock()
+	return s
+}
+
+// 动态来源变化时重新合并
+type hybridSourceObserver struct {
+	conf *HybridConf
+}
+
+func (o hybridSourceObserver) Update() {
+	o.conf.refresh()
+}
+
+func (s *HybridConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *HybridConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *HybridConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *HybridConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return append([]string{}, s.activeList...)
+}
+
+// 动态来源在创建时已经开始 watch
+func (s *HybridConf) WatchConf() {
+	fmt.Println("watchConf")
+}
+
+// 更新配置时，通知监听者也更新。直接调用时替换合并后的列表，下一次来源变化时重新合并
+func (s *HybridConf) UpdateConf(conf []string) {
+	fmt.Println("UpdateConf", conf)
+	s.mux.Lock()
+	s.activeList = conf
+	s.mux.Unlock()
+	s.NotifyAllObservers()
+}
+
+// 与动态来源断开并关闭它们，动态来源由 HybridConf 持有
+func (s *HybridConf) Close() {
+	for _, source := range s.sources {
+		source.Detach(hybridSourceObserver{s})
+		source.Close()
+	}
+}
+
+// 设置动态后端的保护策略，staticCounts 表示静态后端是否计入
+func (s *HybridConf) SetSafety(safety ConfSafety, staticCounts bool) {
+	s.mux.Lock()
+	s.safety = &safety
+	s.staticCountsForSafety = staticCounts
+	s.mux.Unlock()
+}
+
+// 有来源处于 stale 模式(见 DetectOutage)时暂停接收其他来源新发现的后端，已有后端的移除与权重变化照常生效
+func (s *HybridConf) SetFreezeOnStale(freeze bool) {
+	s.mux.Lock()
+	s.freezeOnStale = freeze
+	s.mux.Unlock()
+}
+
+func (s *HybridConf) anyStale() bool {
+	for _, source := range s.sources {
+		if st, ok := source.(StaleConf); ok && st.Stale() {
+			return true
+		}
+	}
+	return false
+}
+
+// 替换静态后端，如配置文件重新加载
+func (s *HybridConf) SetStatic(static []string) {
+	s.mux.Lock()
+	s.static = append([]string{}, static...)
+	s.mux.Unlock()
+	s.publish()
+}
+
+func (s *HybridConf) Static() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return append([]string{}, s.static...)
+}
+
+// 各动态来源的列表，同一地址以靠前的来源为准
+func (s *HybridConf) collect() []string {
+	list := []string{}
+	seen := map[string]bool{}
+	for _, source := range s.sources {
+		for _, entry := range source.GetConf() {
+			addr := confAddr(entry)
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func (s *HybridConf) refresh() {
+	next := s.collect()
+	stale := s.anyStale()
+	s.mux.Lock()
+	if stale && s.freezeOnStale {
+		known := map[string]bool{}
+		for _, addr := range confAddrs(s.dynamic) {
+			known[addr] = true
+		}
+		admitted := []string{}
+		for _, entry := range next {
+			if known[confAddr(entry)] {
+				admitted = append(admitted, entry)
+			} else {
+				fmt.Println("hybrid conf skip new backend while stale", entry)
+			}
+		}
+		next = admitted
+	}
+	if s.safety != nil {
+		current, checked := confAddrs(s.dynamic), confAddrs(next)
+		if s.staticCountsForSafety {
+			current = confAddrs(mergeConf(s.static, s.dynamic))
+			checked = confAddrs(mergeConf(s.static, next))
+		}
+		if err := s.safety.Check(current, checked); err != nil {
+			s.mux.Unlock()
+			fmt.Println("hybrid conf update rejected", err)
+			return
+		}
+	}
+	s.dynamic = next
+	s.mux.Unlock()
+	s.publish()
+}
+
+// 合并后的列表变化时通知监听者
+func (s *HybridConf) publish() {
+	s.mux.Lock()
+	merged := mergeConf(s.static, s.dynamic)
+	if reflect.DeepEqual(merged, s.activeList) {
+		s.mux.Unlock()
+		return
+	}
+	s.activeList = merged
+	s.mux.Unlock()
+	fmt.Println("UpdateConf", merged)
+	s.NotifyAllObservers()
+}
+
+// 静态后端在前，动态后端的权重覆盖同地址的静态后端
+func mergeConf(static, dynamic []string) []string {
+	override := map[string]string{}
+	for _, entry := range dynamic {
+		override[confAddr(entry)] = entry
+	}
+	merged := []string{}
+	seen := map[string]bool{}
+	for _, entry := range static {
+		addr := confAddr(entry)
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		if d, ok := override[addr]; ok {
+			entry = d
+		}
+		merged = append(merged, entry)
+	}
+	for _, entry := range dynamic {
+		addr := confAddr(entry)
+		if !seen[addr] {
+			seen[addr] = true
+			merged = append(merged, entry)
+		}
+	}
+	return merged
+}
+
+// "地址,权重" 中的地址
+func confAddr(entry string) string {
+	if i := strings.Index(entry, ","); i >= 0 {
+		return entry[:i]
+	}
+	return entry
+}
+
+func confAddrs(list []string) []string {
+	addrs := make([]string, 0, len(list))
+	for _, entry := range list {
+		addrs = append(addrs, confAddr(entry))
+	}
+	return addrs
+}
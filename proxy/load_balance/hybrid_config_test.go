@@ -0,0 +1,91 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newHybridSource(t *testing.T, backends ...registry.Backend) (*registry.Memory, *LoadBalanceRegistryConf) {
+	r := registry.NewMemory()
+	for _, b := range backends {
+		r.Register(b)
+	}
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conf.Close)
+	return r, conf
+}
+
+func TestHybridConfMerge(t *testing.T) {
+	_, zone1 := newHybridSource(t, registry.Backend{Addr: "127.0.0.1:8001", Weight: 10}, registry.Backend{Addr: "127.0.0.1:8002", Weight: 20})
+	_, zone2 := newHybridSource(t, registry.Backend{Addr: "127.0.0.1:8002", Weight: 30}, registry.Backend{Addr: "127.0.0.1:8003", Weight: 40})
+	conf := NewHybridConf([]string{"http://127.0.0.1:9001,5", "http://127.0.0.1:8001,1"}, zone1, zone2)
+	//静态后端在前，动态来源覆盖静态后端的权重，来源之间以靠前的为准
+	want := []string{"http://127.0.0.1:9001,5", "http://127.0.0.1:8001,10", "http://127.0.0.1:8002,20", "http://127.0.0.1:8003,40"}
+	if got := conf.GetConf(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	updates := &countObserver{}
+	conf.Attach(updates)
+	conf.SetStatic([]string{"http://127.0.0.1:9001,5", "http://127.0.0.1:9002,5", "http://127.0.0.1:8001,1"})
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002", "http://127.0.0.1:8003", "http://127.0.0.1:9001", "http://127.0.0.1:9002"})
+	//合并结果不变时不通知
+	conf.SetStatic(conf.Static())
+	if n := updates.get(); n != 1 {
+		t.Fatalf("%d updates", n)
+	}
+}
+
+func TestHybridConfDynamicRemoval(t *testing.T) {
+	r, source := newHybridSource(t, registry.Backend{Addr: "127.0.0.1:8001", Weight: 10})
+	conf := NewHybridConf([]string{"http://127.0.0.1:9001,5", "http://127.0.0.1:8001,1"}, source)
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	if w, _ := lb.Weight("http://127.0.0.1:8001"); w != 10 {
+		t.Fatalf("dynamic weight %d", w)
+	}
+
+	//动态来源移除后，同地址的静态后端恢复静态权重
+	r.Deregister("127.0.0.1:8001")
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Weight: 20})
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002", "http://127.0.0.1:9001"})
+	if w, _ := lb.Weight("http://127.0.0.1:8001"); w != 1 {
+		t.Fatalf("static weight %d", w)
+	}
+	r.Deregister("127.0.0.1:8002")
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:9001"})
+}
+
+func TestHybridConfSafety(t *testing.T) {
+	static := []string{"http://127.0.0.1:9001,5"}
+	for _, staticCounts := range []bool{true, false} {
+		r, source := newHybridSource(t, registry.Backend{Addr: "127.0.0.1:8001"})
+		conf := NewHybridConf(static, source)
+		conf.SetSafety(ConfSafety{}, staticCounts)
+		lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+		//监听者按顺序通知，计数增加时 HybridConf 已经处理完这次更新
+		updates := &countObserver{}
+		source.Attach(updates)
+		r.Deregister("127.0.0.1:8001")
+		deadline := time.Now().Add(2 * time.Second)
+		for updates.get() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("source not updated")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		//静态后端计入时动态来源可以清空；不计入时动态来源为空按空列表拒绝，保留上一次的动态后端
+		want := []string{"http://127.0.0.1:8001", "http://127.0.0.1:9001"}
+		if staticCounts {
+			want = []string{"http://127.0.0.1:9001"}
+		}
+		if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+			t.Fatalf("static counts %v: servers %v", staticCounts, got)
+		}
+	}
+}
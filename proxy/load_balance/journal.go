@@ -0,0 +1,181 @@
+package load_balance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认保留的变更记录条数
+const DefaultJournalSize = 1000
+
+// 变更来源
+const (
+	JournalSourceZkWatch = "zk_watch"
+	JournalSourceAdmin   = "admin"
+	JournalSourceHealth  = "health"
+	JournalSourceFile    = "file"
+)
+
+// 权重变化的后端
+type ConfWeightChange struct {
+	Addr string `json:"addr"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// 一次生效的后端列表更新，Added 与 Removed 为 "地址,权重"
+type ConfChange struct {
+	Time    time.Time          `json:"time"`
+	Pool    string             `json:"pool"`
+	Source  string             `json:"source"`
+	Actor   string             `json:"actor,omitempty"` //管理接口的操作人
+	Added   []string           `json:"added,omitempty"`
+	Removed []string           `json:"removed,omitempty"`
+	Changed []ConfWeightChange `json:"changed,omitempty"`
+}
+
+func (c ConfChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// 后端列表的变更日志：最近的变更保存在固定大小的环形缓冲中，可以同时追加写入 JSONL 文件
+type Journal struct {
+	mux     sync.Mutex
+	entries []ConfChange
+	next    int
+	full    bool
+	file    *os.File
+	enc     *json.Encoder
+}
+
+// size 不大于 0 时使用 DefaultJournalSize，path 为空时只保存在内存中
+func NewJournal(size int, path string) (*Journal, error) {
+	if size <= 0 {
+		size = DefaultJournalSize
+	}
+	j := &Journal{entries: make([]ConfChange, size)}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		j.file, j.enc = f, json.NewEncoder(f)
+	}
+	return j, nil
+}
+
+// 记录一次变更，没有差异时忽略
+func (j *Journal) Record(change ConfChange) {
+	if change.Empty() {
+		return
+	}
+	if change.Time.IsZero() {
+		change.Time = time.Now()
+	}
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	j.entries[j.next] = change
+	j.next = (j.next + 1) % len(j.entries)
+	if j.next == 0 {
+		j.full = true
+	}
+	if j.enc != nil {
+		if err := j.enc.Encode(change); err != nil {
+			fmt.Println("journal write error", err)
+		}
+	}
+}
+
+// 按时间顺序返回最近 limit 条变更，pool 为空表示全部，limit 不大于 0 表示不限制
+func (j *Journal) Recent(pool string, limit int) []ConfChange {
+	j.mux.Lock()
+	all := append([]ConfChange{}, j.entries[:j.next]...)
+	if j.full {
+		all = append(append([]ConfChange{}, j.entries[j.next:]...), all...)
+	}
+	j.mux.Unlock()
+	list := []ConfChange{}
+	for _, c := range all {
+		if pool == "" || c.Pool == pool {
+			list = append(list, c)
+		}
+	}
+	if limit > 0 && len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return list
+}
+
+func (j *Journal) Close() error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file, j.enc = nil, nil
+	return err
+}
+
+// 记录配置主题的每次更新，source 为变更来源，如 zk watch 的配置主题使用 JournalSourceZkWatch
+func (j *Journal) Track(pool, source string, conf LoadBalanceConf) {
+	t := &journalObserver{journal: j, pool: pool, source: source, conf: conf, last: conf.GetConf()}
+	conf.Attach(t)
+}
+
+type journalObserver struct {
+	journal *Journal
+	pool    string
+	source  string
+	conf    LoadBalanceConf
+
+	mux  sync.Mutex
+	last []string
+}
+
+func (o *journalObserver) Update() {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	current := o.conf.GetConf()
+	change := DiffConf(o.last, current)
+	o.last = current
+	change.Pool, change.Source = o.pool, o.source
+	o.journal.Record(change)
+}
+
+// 比较两个 "地址,权重" 列表，结果按地址排序
+func DiffConf(before, after []string) ConfChange {
+	old, cur := confWeights(before), confWeights(after)
+	change := ConfChange{}
+	for addr, weight := range cur {
+		from, ok := old[addr]
+		if !ok {
+			change.Added = append(change.Added, addr+","+weight)
+		} else if from != weight {
+			change.Changed = append(change.Changed, ConfWeightChange{Addr: addr, From: from, To: weight})
+		}
+	}
+	for addr, weight := range old {
+		if _, ok := cur[addr]; !ok {
+			change.Removed = append(change.Removed, addr+","+weight)
+		}
+	}
+	sort.Strings(change.Added)
+	sort.Strings(change.Removed)
+	sort.Slice(change.Changed, func(i, k int) bool { return change.Changed[i].Addr < change.Changed[k].Addr })
+	return change
+}
+
+func confWeights(list []string) map[string]string {
+	weights := map[string]string{}
+	for _, entry := range list {
+		addr, weight, _ := strings.Cut(entry, ",")
+		weights[addr] = weight
+	}
+	return weights
+}
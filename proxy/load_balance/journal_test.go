@@ -0,0 +1,127 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func waitJournal(t *testing.T, j *Journal, pool string, want int) []ConfChange {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		list := j.Recent(pool, 0)
+		if len(list) >= want {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("journal of %q has %d entries, want %d", pool, len(list), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJournalTracksSources(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := NewJournal(10, journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10})
+	zkConf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zkConf.Close()
+	j.Track("orders", JournalSourceZkWatch, zkConf)
+
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	if err := os.WriteFile(path, []byte("backends:\n  - addr: 10.0.0.1:8080\n    weight: 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileConf, err := NewLoadBalanceFileConf("http://%s", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileConf.Close()
+	j.Track("payments", JournalSourceFile, fileConf)
+
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Weight: 20})
+	waitJournal(t, j, "orders", 1)
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Weight: 30})
+	r.Deregister("127.0.0.1:8001")
+	orders := waitJournal(t, j, "orders", 2)
+	//注册中心的两次变化可能被合并为一次更新
+	var added, removed []string
+	var changed []ConfWeightChange
+	for _, c := range orders {
+		if c.Source != JournalSourceZkWatch || c.Actor != "" || c.Time.IsZero() {
+			t.Fatalf("entry %+v", c)
+		}
+		added = append(added, c.Added...)
+		removed = append(removed, c.Removed...)
+		changed = append(changed, c.Changed...)
+	}
+	if !reflect.DeepEqual(orders[0].Added, []string{"http://127.0.0.1:8002,20"}) ||
+		!reflect.DeepEqual(removed, []string{"http://127.0.0.1:8001,10"}) ||
+		!reflect.DeepEqual(changed, []ConfWeightChange{{Addr: "http://127.0.0.1:8002", From: "20", To: "30"}}) ||
+		len(added) != 1 {
+		t.Fatalf("orders journal %+v", orders)
+	}
+
+	replaceFile(t, path, "backends:\n  - addr: 10.0.0.1:8080\n    weight: 5\n  - addr: 10.0.0.2:8080\n    weight: 10\n")
+	payments := waitJournal(t, j, "payments", 1)
+	want := ConfChange{Pool: "payments", Source: JournalSourceFile, Added: []string{"http://10.0.0.2:8080,10"},
+		Changed: []ConfWeightChange{{Addr: "http://10.0.0.1:8080", From: "10", To: "5"}}}
+	payments[0].Time = time.Time{}
+	if !reflect.DeepEqual(payments[0], want) {
+		t.Fatalf("payments journal %+v", payments[0])
+	}
+
+	//文件中按时间顺序逐行记录
+	all := j.Recent("", 0)
+	f, err := os.Open(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		var c ConfChange
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			t.Fatal(err)
+		}
+		if c.Pool != all[lines].Pool || !reflect.DeepEqual(c.Added, all[lines].Added) {
+			t.Fatalf("line %d %+v", lines, c)
+		}
+	}
+	if lines != len(all) {
+		t.Fatalf("%d lines, %d entries", lines, len(all))
+	}
+}
+
+func TestJournalRing(t *testing.T) {
+	j, _ := NewJournal(3, "")
+	j.Record(ConfChange{Pool: "orders"})
+	for i := 1; i <= 5; i++ {
+		j.Record(ConfChange{Pool: "orders", Added: []string{"http://127.0.0.1:800" + string(rune('0'+i)) + ",1"}})
+	}
+	list := j.Recent("", 0)
+	if len(list) != 3 || list[0].Added[0] != "http://127.0.0.1:8003,1" || list[2].Added[0] != "http://127.0.0.1:8005,1" {
+		t.Fatalf("ring %+v", list)
+	}
+	if list := j.Recent("orders", 1); len(list) != 1 || list[0].Added[0] != "http://127.0.0.1:8005,1" {
+		t.Fatalf("limit %+v", list)
+	}
+	if list := j.Recent("payments", 0); len(list) != 0 {
+		t.Fatalf("pool filter %+v", list)
+	}
+}
@@ -0,0 +1,221 @@
+package load_balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultK8sResync      = 30 * time.Second
+	DefaultK8sSyncTimeout = 30 * time.Second
+	defaultK8sWeight      = "1"
+)
+
+// Kubernetes 中的 Service
+type K8sService struct {
+	Namespace       string
+	Name            string
+	PortName        string        //EndpointSlice 中的端口名，为空时使用未命名的端口或唯一的端口
+	PublishNotReady bool          //为 true 时未就绪的端点也作为后端
+	Resync          time.Duration //informer 全量同步间隔，默认 DefaultK8sResync
+}
+
+// 创建访问集群的客户端：kubeconfig 为空时优先使用 in-cluster 配置，
+// 不在集群内时按 KUBECONFIG 环境变量或 ~/.kube/config 加载
+func NewK8sClient(kubeconfig string) (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else if config, err = rest.InClusterConfig(); err == rest.ErrNotInCluster {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// 通过 EndpointSlice informer 跟踪 Service 的端点，直接把端点作为后端，不经过 kube-proxy
+type LoadBalanceK8sConf struct {
+	observers []Observer
+	format    string
+	service   K8sService
+	informer  cache.SharedIndexInformer
+	lister    discoverylisters.EndpointSliceLister
+
+	applyMux   sync.Mutex //初始同步与 informer 回调可能同时到达，按顺序处理
+	mux        sync.RWMutex
+	activeList []string //ip:port
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func (s *LoadBalanceK8sConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *LoadBalanceK8sConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *LoadBalanceK8sConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceK8sConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	confList := []string{}
+	for _, ip := range s.activeList {
+		confList = append(confList, fmt.Sprintf(s.format, ip)+","+defaultK8sWeight)
+	}
+	return confList
+}
+
+// EndpointSlice 的增删改与定时 resync 都会重新计算后端
+func (s *LoadBalanceK8sConf) WatchConf() {
+	fmt.Println("watchConf")
+	s.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.sync() },
+		UpdateFunc: func(oldObj, newObj interface{}) { s.sync() },
+		DeleteFunc: func(obj interface{}) { s.sync() },
+	})
+	go s.informer.Run(s.stop)
+}
+
+// 更新配置时，通知监听者也更新
+func (s *LoadBalanceK8sConf) UpdateConf(conf []string) {
+	fmt.Println("UpdateConf", conf)
+	//informer 回调在后台 goroutine 中，与 Attach 并发
+	s.mux.Lock()
+	s.activeList = conf
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceK8sConf) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// 从本地缓存中汇总 Service 所有 EndpointSlice 的端点，列表变化时通知监听者
+func (s *LoadBalanceK8sConf) sync() {
+	s.applyMux.Lock()
+	defer s.applyMux.Unlock()
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: s.service.Name})
+	slices, err := s.lister.EndpointSlices(s.service.Namespace).List(selector)
+	if err != nil {
+		fmt.Println("k8s list endpointslices error", s.service.Name, err)
+		return
+	}
+	seen := map[string]bool{}
+	changedList := []string{}
+	for _, slice := range slices {
+		port, ok := s.slicePort(slice)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			//Ready 为空表示状态未知，按就绪处理
+			ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			if (!ready && !s.service.PublishNotReady) || len(ep.Addresses) == 0 {
+				continue
+			}
+			//同一端点的多个地址可以互相替代，只使用第一个
+			addr := net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(port)))
+			if !seen[addr] {
+				seen[addr] = true
+				changedList = append(changedList, addr)
+			}
+		}
+	}
+	sort.Strings(changedList)
+	s.mux.RLock()
+	changed := !reflect.DeepEqual(changedList, s.activeList)
+	s.mux.RUnlock()
+	if changed {
+		s.UpdateConf(changedList)
+	}
+}
+
+// 按端口名找到 EndpointSlice 中的端口号
+func (s *LoadBalanceK8sConf) slicePort(slice *discoveryv1.EndpointSlice) (int32, bool) {
+	for _, p := range slice.Ports {
+		name := ""
+		if p.Name != nil {
+			name = *p.Name
+		}
+		if p.Port != nil && name == s.service.PortName {
+			return *p.Port, true
+		}
+	}
+	if s.service.PortName == "" && len(slice.Ports) == 1 && slice.Ports[0].Port != nil {
+		return *slice.Ports[0].Port, true
+	}
+	return 0, false
+}
+
+// format 如 http://%s，client 可由 NewK8sClient 创建。informer 首次同步超时时返回错误
+func NewLoadBalanceK8sConf(format string, client kubernetes.Interface, service K8sService) (*LoadBalanceK8sConf, error) {
+	if service.Namespace == "" {
+		service.Namespace = "default"
+	}
+	if service.Name == "" {
+		return nil, errors.New("k8s service name required")
+	}
+	resync := service.Resync
+	if resync <= 0 {
+		resync = DefaultK8sResync
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(client, resync,
+		informers.WithNamespace(service.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = discoveryv1.LabelServiceName + "=" + service.Name
+		}))
+	slices := factory.Discovery().V1().EndpointSlices()
+	mConf := &LoadBalanceK8sConf{
+		format:   format,
+		service:  service,
+		informer: slices.Informer(),
+		lister:   slices.Lister(),
+		stop:     make(chan struct{}),
+	}
+	mConf.WatchConf()
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultK8sSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), mConf.informer.HasSynced) {
+		mConf.Close()
+		return nil, errors.New("k8s endpointslice informer sync timeout: " + service.Namespace + "/" + service.Name)
+	}
+	mConf.sync()
+	return mConf, nil
+}
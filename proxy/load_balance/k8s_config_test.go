@@ -0,0 +1,112 @@
+package load_balance
+
+import (
+	"context"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type k8sEndpoint struct {
+	ip    string
+	ready bool
+}
+
+func endpointSlice(name string, port int32, endpoints ...k8sEndpoint) *discoveryv1.EndpointSlice {
+	portName := "http"
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "shop",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "orders"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+	}
+	for _, ep := range endpoints {
+		ready := ep.ready
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{ep.ip},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+	return slice
+}
+
+// 等待 informer 把变化推送到负载均衡器
+func waitServers(t *testing.T, lb ManagedBalance, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := sortedServers(lb)
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestK8sConfPropagates(t *testing.T) {
+	ctx := context.Background()
+	other := endpointSlice("payments-1", 9090, k8sEndpoint{"10.0.1.1", true})
+	other.Labels[discoveryv1.LabelServiceName] = "payments"
+	client := fake.NewSimpleClientset(
+		endpointSlice("orders-1", 8080, k8sEndpoint{"10.0.0.1", true}, k8sEndpoint{"10.0.0.2", false}),
+		other,
+	)
+	conf, err := NewLoadBalanceK8sConf("http://%s", client, K8sService{Namespace: "shop", Name: "orders", PortName: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbRoundRobin, conf).(*RoundRobinBalance)
+	waitServers(t, lb, []string{"http://10.0.0.1:8080"})
+
+	//10.0.0.2 就绪，新增一个 slice
+	slices := client.DiscoveryV1().EndpointSlices("shop")
+	if _, err := slices.Update(ctx, endpointSlice("orders-1", 8080, k8sEndpoint{"10.0.0.1", true}, k8sEndpoint{"10.0.0.2", true}), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := slices.Create(ctx, endpointSlice("orders-2", 8080, k8sEndpoint{"10.0.0.3", true}), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitServers(t, lb, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080"})
+
+	//Pod 删除后端点从 slice 中移除
+	if _, err := slices.Update(ctx, endpointSlice("orders-1", 8080, k8sEndpoint{"10.0.0.2", true}), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := slices.Delete(ctx, "orders-2", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitServers(t, lb, []string{"http://10.0.0.2:8080"})
+}
+
+func TestK8sConfPublishNotReady(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		endpointSlice("orders-1", 8080, k8sEndpoint{"10.0.0.1", true}, k8sEndpoint{"10.0.0.2", false}),
+	)
+	conf, err := NewLoadBalanceK8sConf("http://%s", client, K8sService{Namespace: "shop", Name: "orders", PortName: "http", PublishNotReady: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	waitServers(t, lb, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"})
+
+	//端口名不匹配时没有后端
+	conf2, err := NewLoadBalanceK8sConf("http://%s", client, K8sService{Namespace: "shop", Name: "orders", PortName: "grpc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf2.Close()
+	if got := conf2.GetConf(); len(got) != 0 {
+		t.Fatalf("unexpected backends %v", got)
+	}
+}
@@ -1,9 +1,40 @@
 package load_balance
 
+import "errors"
+
+var (
+	ErrNoBackends   = errors.New("no available backends")
+	ErrNodeNotFound = errors.New("node not found")
+)
+
 type LoadBalance interface {
 	Add(...string) error
 	Get(string) (string, error) 
 
 	//后期服务发现补充
 	Update()
-}
\ No newline at end of file
+}
+
+// 支持排除部分节点后重新选择，如节点被限流时
+type ExcludingBalance interface {
+	GetExcluding(key string, excluded map[string]bool) (string, error)
+}
+
+
+// 支持运行时增删节点的负载均衡
+type ManagedBalance interface {
+	LoadBalance
+	Remove(addr string) error
+	Servers() []string
+}
+
+// 支持整体替换节点列表的负载均衡，conf 为 "地址,权重" 列表，如管理接口导入配置
+type ReplaceableBalance interface {
+	SetServers(conf []string)
+}
+
+// 支持运行时调整权重的负载均衡
+type WeightedBalance interface {
+	SetWeight(addr string, weight int) error
+	Weight(addr string) (int, error)
+}
@@ -0,0 +1,200 @@
+package load_balance
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultNacosTimeout       = 5 * time.Second
+	DefaultNacosWatchInterval = time.Second
+)
+
+type NacosClientConf struct {
+	Addrs         []string      //Nacos 地址，如 http://127.0.0.1:8848，依次尝试
+	Username      string        //用户名密码认证
+	Password      string        //
+	AccessKey     string        //AK/SK 签名认证
+	SecretKey     string        //
+	Timeout       time.Duration //单次请求超时，默认 DefaultNacosTimeout
+	WatchInterval time.Duration //订阅时查询实例变化的间隔，默认 DefaultNacosWatchInterval
+}
+
+// 基于 Nacos OpenAPI(v1) 的客户端。OpenAPI 没有推送通道，Subscribe 按 WatchInterval 查询，实例变化时回调
+type NacosHTTPClient struct {
+	conf   NacosClientConf
+	client *http.Client
+
+	mux         sync.Mutex
+	token       string
+	tokenExpire time.Time
+	watches     map[string]chan struct{}
+}
+
+func NewNacosHTTPClient(conf NacosClientConf) (*NacosHTTPClient, error) {
+	if len(conf.Addrs) == 0 {
+		return nil, errors.New("nacos addrs required")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultNacosTimeout
+	}
+	if conf.WatchInterval <= 0 {
+		conf.WatchInterval = DefaultNacosWatchInterval
+	}
+	return &NacosHTTPClient{conf: conf, client: &http.Client{Timeout: conf.Timeout}, watches: map[string]chan struct{}{}}, nil
+}
+
+func nacosServiceKey(service NacosService) string {
+	return service.Namespace + "/" + service.Group + "/" + service.Name + "/" + strings.Join(service.Clusters, ",")
+}
+
+func (c *NacosHTTPClient) SelectInstances(service NacosService) ([]NacosInstance, error) {
+	group := service.Group
+	if group == "" {
+		group = DefaultNacosGroup
+	}
+	query := url.Values{}
+	query.Set("serviceName", service.Name)
+	query.Set("groupName", group)
+	if service.Namespace != "" {
+		query.Set("namespaceId", service.Namespace)
+	}
+	if len(service.Clusters) > 0 {
+		query.Set("clusters", strings.Join(service.Clusters, ","))
+	}
+	var lastErr error
+	for _, addr := range c.conf.Addrs {
+		var result struct {
+			Hosts []NacosInstance `json:"hosts"`
+		}
+		if lastErr = c.get(addr, "/nacos/v1/ns/instance/list", query, group+"@@"+service.Name, &result); lastErr == nil {
+			return result.Hosts, nil
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *NacosHTTPClient) Subscribe(service NacosService, callback func([]NacosInstance)) error {
+	key := nacosServiceKey(service)
+	c.mux.Lock()
+	if _, ok := c.watches[key]; ok {
+		c.mux.Unlock()
+		return errors.New("already subscribed: " + service.Name)
+	}
+	stop := make(chan struct{})
+	c.watches[key] = stop
+	c.mux.Unlock()
+	go func() {
+		var last []NacosInstance
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(c.conf.WatchInterval):
+			}
+			instances, err := c.SelectInstances(service)
+			if err != nil || reflect.DeepEqual(instances, last) {
+				continue
+			}
+			last = instances
+			callback(instances)
+		}
+	}()
+	return nil
+}
+
+func (c *NacosHTTPClient) Unsubscribe(service NacosService) error {
+	key := nacosServiceKey(service)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	stop, ok := c.watches[key]
+	if !ok {
+		return errors.New("not subscribed: " + service.Name)
+	}
+	close(stop)
+	delete(c.watches, key)
+	return nil
+}
+
+func (c *NacosHTTPClient) get(addr, path string, query url.Values, resource string, v interface{}) error {
+	if c.conf.Username != "" {
+		token, err := c.accessToken(addr)
+		if err != nil {
+			return err
+		}
+		query.Set("accessToken", token)
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.conf.AccessKey != "" {
+		//签名内容为 时间戳@@分组@@服务名
+		ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		data := ts + "@@" + resource
+		mac := hmac.New(sha1.New, []byte(c.conf.SecretKey))
+		mac.Write([]byte(data))
+		req.Header.Set("ak", c.conf.AccessKey)
+		req.Header.Set("data", data)
+		req.Header.Set("signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+			c.mux.Lock()
+			c.token = ""
+			c.mux.Unlock()
+		}
+		return fmt.Errorf("nacos %s: %d %s", path, resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// 用户名密码登录，token 在过期前复用
+func (c *NacosHTTPClient) accessToken(addr string) (string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpire) {
+		return c.token, nil
+	}
+	form := url.Values{"username": {c.conf.Username}, "password": {c.conf.Password}}
+	resp, err := c.client.PostForm(strings.TrimSuffix(addr, "/")+"/nacos/v1/auth/login", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("nacos login: %d", resp.StatusCode)
+	}
+	var result struct {
+		AccessToken string `json:"accessToken"`
+		TokenTTL    int64  `json:"tokenTtl"` //秒
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("nacos login: empty access token")
+	}
+	c.token = result.AccessToken
+	//提前刷新，避免请求途中过期
+	c.tokenExpire = time.Now().Add(time.Duration(result.TokenTTL) * time.Second * 9 / 10)
+	return c.token, nil
+}
@@ -0,0 +1,207 @@
+package load_balance
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultNacosGroup     = "DEFAULT_GROUP"
+	DefaultNacosInterval  = 30 * time.Second
+	nacosWeightMultiplier = 100 //Nacos 权重为小数(默认 1.0)，乘以该值取整作为负载均衡权重
+)
+
+// Nacos 中的服务
+type NacosService struct {
+	Namespace string //命名空间 ID，为空表示 public
+	Group     string //默认 DefaultNacosGroup
+	Name      string
+	Clusters  []string //只使用这些集群的实例，为空表示全部
+}
+
+// Nacos 中的服务实例
+type NacosInstance struct {
+	IP          string            `json:"ip"`
+	Port        int               `json:"port"`
+	Weight      float64           `json:"weight"`
+	Healthy     bool              `json:"healthy"`
+	Enabled     bool              `json:"enabled"`
+	ClusterName string            `json:"clusterName"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// 访问 Nacos 的客户端，测试中可以替换
+type NacosClient interface {
+	SelectInstances(service NacosService) ([]NacosInstance, error)
+	//实例变化时回调，回调参数为服务的全部实例
+	Subscribe(service NacosService, callback func([]NacosInstance)) error
+	Unsubscribe(service NacosService) error
+}
+
+// 订阅 Nacos 服务，健康且启用的实例作为后端，实例推送与定时全量拉取都会更新
+type LoadBalanceNacosConf struct {
+	observers []Observer
+	format    string
+	client    NacosClient
+	service   NacosService
+	Interval  time.Duration //定时全量拉取的间隔，用于推送丢失时纠正，默认 DefaultNacosInterval
+
+	applyMux   sync.Mutex //推送与定时拉取可能同时到达，按顺序处理
+	mux        sync.RWMutex
+	activeList []string          //ip:port
+	ipWeight   map[string]string //ip:port -> weight
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func (s *LoadBalanceNacosConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *LoadBalanceNacosConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *LoadBalanceNacosConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceNacosConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	confList := []string{}
+	for _, ip := range s.activeList {
+		confList = append(confList, fmt.Sprintf(s.format, ip)+","+s.ipWeight[ip])
+	}
+	return confList
+}
+
+// 订阅实例推送，同时定时全量拉取
+func (s *LoadBalanceNacosConf) WatchConf() {
+	fmt.Println("watchConf")
+	if err := s.client.Subscribe(s.service, s.apply); err != nil {
+		fmt.Println("nacos subscribe error", s.service.Name, err)
+	}
+	go func() {
+		for {
+			interval := s.Interval
+			if interval <= 0 {
+				interval = DefaultNacosInterval
+			}
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(interval):
+				s.refresh()
+			}
+		}
+	}()
+}
+
+// 更新配置时，通知监听者也更新
+func (s *LoadBalanceNacosConf) UpdateConf(conf []string) {
+	fmt.Println("UpdateConf", conf)
+	s.mux.Lock()
+	s.activeList = conf
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceNacosConf) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.client.Unsubscribe(s.service)
+	})
+}
+
+// 全量拉取一次，Nacos 不可达时保留当前的后端列表
+func (s *LoadBalanceNacosConf) refresh() error {
+	instances, err := s.client.SelectInstances(s.service)
+	if err != nil {
+		fmt.Println("nacos select instances error", s.service.Name, err)
+		return err
+	}
+	s.apply(instances)
+	return nil
+}
+
+// 过滤出可用实例，列表或权重变化时通知监听者
+func (s *LoadBalanceNacosConf) apply(instances []NacosInstance) {
+	s.applyMux.Lock()
+	defer s.applyMux.Unlock()
+	changedList := []string{}
+	ipWeight := map[string]string{}
+	for _, ins := range instances {
+		if !ins.Healthy || !ins.Enabled || ins.Weight <= 0 || !s.inClusters(ins.ClusterName) {
+			continue
+		}
+		addr := net.JoinHostPort(ins.IP, strconv.Itoa(ins.Port))
+		if _, ok := ipWeight[addr]; !ok {
+			changedList = append(changedList, addr)
+		}
+		weight := int(math.Round(ins.Weight * nacosWeightMultiplier))
+		if weight < 1 {
+			weight = 1
+		}
+		ipWeight[addr] = strconv.Itoa(weight)
+	}
+	sort.Strings(changedList)
+	s.mux.RLock()
+	changed := !reflect.DeepEqual(changedList, s.activeList) || !reflect.DeepEqual(ipWeight, s.ipWeight)
+	s.mux.RUnlock()
+	if changed {
+		s.mux.Lock()
+		s.ipWeight = ipWeight
+		s.mux.Unlock()
+		s.UpdateConf(changedList)
+	}
+}
+
+func (s *LoadBalanceNacosConf) inClusters(cluster string) bool {
+	if len(s.service.Clusters) == 0 {
+		return true
+	}
+	for _, c := range s.service.Clusters {
+		if c == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// format 如 http://%s，首次拉取失败时返回错误
+func NewLoadBalanceNacosConf(format string, client NacosClient, service NacosService) (*LoadBalanceNacosConf, error) {
+	if service.Group == "" {
+		service.Group = DefaultNacosGroup
+	}
+	mConf := &LoadBalanceNacosConf{
+		format:   format,
+		client:   client,
+		service:  service,
+		ipWeight: map[string]string{},
+		stop:     make(chan struct{}),
+	}
+	if err := mConf.refresh(); err != nil {
+		return nil, err
+	}
+	mConf.WatchConf()
+	return mConf, nil
+}
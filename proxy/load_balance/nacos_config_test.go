@@ -0,0 +1,141 @@
+package load_balance
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type stubNacos struct {
+	mux       sync.Mutex
+	instances []NacosInstance
+	err       error
+	callback  func([]NacosInstance)
+}
+
+func (s *stubNacos) SelectInstances(service NacosService) ([]NacosInstance, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return append([]NacosInstance(nil), s.instances...), nil
+}
+
+func (s *stubNacos) Subscribe(service NacosService, callback func([]NacosInstance)) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.callback = callback
+	return nil
+}
+
+func (s *stubNacos) Unsubscribe(service NacosService) error { return nil }
+
+// 模拟服务端推送
+func (s *stubNacos) push(instances ...NacosInstance) {
+	s.mux.Lock()
+	s.instances = instances
+	callback := s.callback
+	s.mux.Unlock()
+	callback(instances)
+}
+
+func nacosInstance(ip string, weight float64, healthy bool) NacosInstance {
+	return NacosInstance{IP: ip, Port: 8080, Weight: weight, Healthy: healthy, Enabled: true, ClusterName: "DEFAULT"}
+}
+
+func TestNacosConfPropagates(t *testing.T) {
+	client := &stubNacos{instances: []NacosInstance{
+		nacosInstance("10.0.0.1", 1, true),
+		nacosInstance("10.0.0.2", 0.5, true),
+		nacosInstance("10.0.0.3", 1, false),
+		{IP: "10.0.0.4", Port: 8080, Weight: 1, Healthy: true, Enabled: false, ClusterName: "DEFAULT"},
+		{IP: "10.1.0.1", Port: 8080, Weight: 1, Healthy: true, Enabled: true, ClusterName: "hz"},
+	}}
+	conf, err := NewLoadBalanceNacosConf("http://%s", client, NacosService{Name: "orders", Clusters: []string{"DEFAULT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	want := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if w, _ := lb.Weight("http://10.0.0.2:8080"); w != 50 {
+		t.Fatalf("weight not mapped: %d", w)
+	}
+
+	//推送：10.0.0.1 下线，10.0.0.3 恢复
+	client.push(nacosInstance("10.0.0.1", 1, false), nacosInstance("10.0.0.2", 0.5, true), nacosInstance("10.0.0.3", 2, true))
+	want = []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after push got %v want %v", got, want)
+	}
+
+	//Nacos 不可达时保留当前列表
+	client.mux.Lock()
+	client.err = errors.New("connection refused")
+	client.mux.Unlock()
+	if err := conf.refresh(); err == nil {
+		t.Fatal("refresh should report the error")
+	}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unreachable nacos changed backends: %v", got)
+	}
+
+	//定时拉取纠正丢失的推送
+	client.mux.Lock()
+	client.err = nil
+	client.instances = []NacosInstance{nacosInstance("10.0.0.5", 1, true)}
+	client.mux.Unlock()
+	conf.refresh()
+	if got := sortedServers(lb); !reflect.DeepEqual(got, []string{"http://10.0.0.5:8080"}) {
+		t.Fatalf("reconcile got %v", got)
+	}
+	if _, err := NewLoadBalanceNacosConf("%s", &stubNacos{err: errors.New("down")}, NacosService{Name: "x"}); err == nil {
+		t.Fatal("initial failure should be reported")
+	}
+}
+
+func TestNacosHTTPClient(t *testing.T) {
+	var logins int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/nacos/v1/auth/login":
+			logins++
+			if req.FormValue("username") != "nacos" || req.FormValue("password") != "pw" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"accessToken": "tok", "tokenTtl": 18000})
+		case "/nacos/v1/ns/instance/list":
+			q := req.URL.Query()
+			if q.Get("accessToken") != "tok" || req.Header.Get("ak") != "ak1" || req.Header.Get("signature") == "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if q.Get("serviceName") != "orders" || q.Get("groupName") != "DEFAULT_GROUP" || q.Get("namespaceId") != "prod" || q.Get("clusters") != "hz" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"hosts": []NacosInstance{nacosInstance("10.0.0.1", 1, true)}})
+		}
+	}))
+	defer srv.Close()
+	client, _ := NewNacosHTTPClient(NacosClientConf{Addrs: []string{"http://127.0.0.1:1", srv.URL}, Username: "nacos", Password: "pw", AccessKey: "ak1", SecretKey: "sk"})
+	service := NacosService{Namespace: "prod", Name: "orders", Clusters: []string{"hz"}}
+	for i := 0; i < 2; i++ {
+		instances, err := client.SelectInstances(service)
+		if err != nil || len(instances) != 1 || instances[0].IP != "10.0.0.1" {
+			t.Fatalf("got %v %v", instances, err)
+		}
+	}
+	if logins != 1 {
+		t.Fatalf("token not reused: %d logins", logins)
+	}
+}
@@ -0,0 +1,110 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"fmt"
+	"time"
+)
+
+// 故障检测的默认设置
+const (
+	DefaultOutageProbeInterval = 5 * time.Second
+	DefaultOutageThreshold     = 3
+)
+
+// 为 1 表示注册中心不可用，正在使用最后一次的后端列表，按 OutageOptions.Name 统计
+var registryStale = metrics.NewGaugeVec("gateway_lb_registry_stale", "注册中心连续探测失败后进入 stale 模式，继续使用最后一次的后端列表", "conf")
+
+// 注册中心故障检测：按 ProbeInterval 获取完整列表，连续 Threshold 次失败后进入 stale 模式，
+// 期间忽略 watch 推送的列表、继续使用最后一次的列表；探测恢复后以获取的完整列表为准退出
+type OutageOptions struct {
+	Name          string        //指标与事件中的名称，如 pool 名
+	ProbeInterval time.Duration //默认 DefaultOutageProbeInterval
+	ProbeTimeout  time.Duration //单次探测的超时时间，默认与 ProbeInterval 相同
+	Threshold     int           //默认 DefaultOutageThreshold
+	OnEvent       func(OutageEvent)
+}
+
+// 进入或退出 stale 模式时的事件
+type OutageEvent struct {
+	Name     string
+	Stale    bool
+	Failures int   //进入时为连续失败次数
+	Err      error //进入时为最后一次探测的错误
+	Time     time.Time
+}
+
+// 处于 stale 模式的配置主题，HybridConf 可以据此暂停接收其他来源的新后端
+type StaleConf interface {
+	Stale() bool
+}
+
+// 开始检测注册中心故障，只能调用一次
+func (s *LoadBalanceRegistryConf) DetectOutage(opts OutageOptions) {
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = DefaultOutageProbeInterval
+	}
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = opts.ProbeInterval
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultOutageThreshold
+	}
+	registryStale.Set(opts.Name, 0)
+	go s.detectOutage(opts)
+}
+
+// 是否处于 stale 模式
+func (s *LoadBalanceRegistryConf) Stale() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.stale
+}
+
+func (s *LoadBalanceRegistryConf) detectOutage(opts OutageOptions) {
+	ticker := time.NewTicker(opts.ProbeInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-s.ctx.Done():
+			registryStale.Set(opts.Name, 0)
+			return
+		case <-ticker.C:
+		}
+		list, err := listWithTimeout(s.registry, opts.ProbeTimeout)
+		if err != nil {
+			failures++
+			fmt.Println("registry probe error", opts.Name, failures, err)
+			if failures == opts.Threshold {
+				s.setStale(opts, OutageEvent{Stale: true, Failures: failures, Err: err})
+			}
+			continue
+		}
+		failures = 0
+		if s.Stale() {
+			s.setStale(opts, OutageEvent{})
+			//以完整列表为准，列表没有变化时也通知监听者，让暂停接收新后端的组合配置重新合并
+			if !s.apply(list) {
+				s.NotifyAllObservers()
+			}
+		}
+	}
+}
+
+func (s *LoadBalanceRegistryConf) setStale(opts OutageOptions, evt OutageEvent) {
+	s.mux.Lock()
+	s.stale = evt.Stale
+	s.mux.Unlock()
+	if evt.Stale {
+		registryStale.Set(opts.Name, 1)
+		fmt.Println("registry unavailable, serving stale backends", opts.Name, evt.Err)
+	} else {
+		registryStale.Set(opts.Name, 0)
+		fmt.Println("registry recovered, leave stale mode", opts.Name)
+	}
+	if opts.OnEvent != nil {
+		evt.Name, evt.Time = opts.Name, time.Now()
+		opts.OnEvent(evt)
+	}
+}
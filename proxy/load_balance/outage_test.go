@@ -0,0 +1,113 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type outageEvents struct {
+	mux    sync.Mutex
+	events []OutageEvent
+}
+
+func (e *outageEvents) add(evt OutageEvent) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.events = append(e.events, evt)
+}
+
+func (e *outageEvents) get() []OutageEvent {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return append([]OutageEvent{}, e.events...)
+}
+
+func waitStale(t *testing.T, conf *LoadBalanceRegistryConf, stale bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for conf.Stale() != stale {
+		if time.Now().After(deadline) {
+			t.Fatalf("stale mode not %v", stale)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newOutageConf(t *testing.T, name string, events *outageEvents, addrs ...string) (*downRegistry, *LoadBalanceRegistryConf) {
+	r := &downRegistry{Memory: registry.NewMemory()}
+	for _, addr := range addrs {
+		r.Register(registry.Backend{Addr: addr})
+	}
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conf.Close)
+	conf.DetectOutage(OutageOptions{Name: name, ProbeInterval: 10 * time.Millisecond, ProbeTimeout: 20 * time.Millisecond, Threshold: 3, OnEvent: events.add})
+	return r, conf
+}
+
+func TestOutageServesStaleList(t *testing.T) {
+	events := &outageEvents{}
+	r, conf := newOutageConf(t, "orders", events, "127.0.0.1:8001", "127.0.0.1:8002")
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+
+	r.down.Store(true)
+	waitStale(t, conf, true)
+	if registryStale.Get("orders") != 1 {
+		t.Fatal("stale gauge not set")
+	}
+	if evts := events.get(); len(evts) != 1 || !evts[0].Stale || evts[0].Failures != 3 || evts[0].Err == nil || evts[0].Name != "orders" {
+		t.Fatalf("events %+v", evts)
+	}
+
+	//故障期间 watch 推送的列表不生效，继续使用最后一次的列表
+	r.Deregister("127.0.0.1:8001")
+	r.Deregister("127.0.0.1:8002")
+	r.Register(registry.Backend{Addr: "127.0.0.1:8003"})
+	time.Sleep(50 * time.Millisecond)
+	want := []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("servers during outage %v", got)
+	}
+	for i := 0; i < 4; i++ {
+		if addr, err := lb.Get(""); err != nil || addr == "" {
+			t.Fatalf("get during outage %q %v", addr, err)
+		}
+	}
+
+	//恢复后以完整列表为准
+	r.down.Store(false)
+	waitStale(t, conf, false)
+	waitServers(t, lb, []string{"http://127.0.0.1:8003"})
+	if registryStale.Get("orders") != 0 {
+		t.Fatal("stale gauge not cleared")
+	}
+	if evts := events.get(); len(evts) != 2 || evts[1].Stale {
+		t.Fatalf("events %+v", evts)
+	}
+}
+
+func TestHybridConfFreezeOnStale(t *testing.T) {
+	events := &outageEvents{}
+	zkDown, zkConf := newOutageConf(t, "orders_zk", events, "127.0.0.1:8001")
+	_, fileConf := newHybridSource(t, registry.Backend{Addr: "127.0.0.1:9001"})
+	conf := NewHybridConf(nil, zkConf, fileConf)
+	conf.SetFreezeOnStale(true)
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+
+	zkDown.down.Store(true)
+	waitStale(t, zkConf, true)
+	//其他来源新发现的后端暂不接收
+	fileConf.registry.Register(registry.Backend{Addr: "127.0.0.1:9002"})
+	time.Sleep(50 * time.Millisecond)
+	want := []string{"http://127.0.0.1:8001", "http://127.0.0.1:9001"}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("servers during outage %v", got)
+	}
+	zkDown.down.Store(false)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:9001", "http://127.0.0.1:9002"})
+}
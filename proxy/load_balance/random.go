@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"math/rand"
 	"strings"
+	"sync"
 )
 
 type RandomBalance struct {
-	curIndex int
-	rss      []string
+	mux sync.RWMutex
+	rss []string
 	//观察主体
 	conf LoadBalanceConf
 }
@@ -19,39 +20,83 @@ func (r *RandomBalance) Add(params ...string) error {
 		return errors.New("param len 1 at least")
 	}
 	addr := params[0]
+	r.mux.Lock()
+	defer r.mux.Unlock()
 	r.rss = append(r.rss, addr)
 	return nil
 }
 
+func (r *RandomBalance) Remove(addr string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for i, item := range r.rss {
+		if item == addr {
+			r.rss = append(r.rss[:i:i], r.rss[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNodeNotFound
+}
+
+func (r *RandomBalance) Servers() []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return append([]string(nil), r.rss...)
+}
+
 func (r *RandomBalance) Next() string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
 	if len(r.rss) == 0 {
 		return ""
 	}
-	r.curIndex = rand.Intn(len(r.rss))
-	return r.rss[r.curIndex]
+	return r.rss[rand.Intn(len(r.rss))]
 }
 
 func (r *RandomBalance) Get(key string) (string, error) {
 	return r.Next(), nil
 }
 
+func (r *RandomBalance) GetExcluding(key string, excluded map[string]bool) (string, error) {
+	candidates := []string{}
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	for _, addr := range r.rss {
+		if !excluded[addr] {
+			candidates = append(candidates, addr)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoBackends
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
 func (r *RandomBalance) SetConf(conf LoadBalanceConf) {
 	r.conf = conf
 }
 
 func (r *RandomBalance) Update() {
-	if conf, ok := r.conf.(*LoadBalanceZkConf); ok {
-		fmt.Println("Update get conf:", conf.GetConf())
-		r.rss = []string{}
-		for _, ip := range conf.GetConf() {
-			r.Add(strings.Split(ip, ",")...)
-		}
+	//所有配置主题都通过 GetConf 提供 "地址,权重" 列表
+	if r.conf == nil {
+		return
 	}
-	if conf, ok := r.conf.(*LoadBalanceCheckConf); ok {
-		fmt.Println("Update get conf:", conf.GetConf())
-		r.rss = nil
-		for _, ip := range conf.GetConf() {
-			r.Add(strings.Split(ip, ",")...)
-		}
+	conf := r.conf.GetConf()
+	fmt.Println("Update get conf:", conf)
+	r.reset(conf)
+}
+
+func (r *RandomBalance) SetServers(conf []string) {
+	r.reset(conf)
+}
+
+// 用配置整体替换节点列表
+func (r *RandomBalance) reset(confList []string) {
+	rss := []string{}
+	for _, ip := range confList {
+		rss = append(rss, strings.Split(ip, ",")[0])
 	}
+	r.mux.Lock()
+	r.rss = rss
+	r.mux.Unlock()
 }
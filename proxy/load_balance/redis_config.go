@@ -0,0 +1,235 @@
+package load_balance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRedisInterval   = 30 * time.Second
+	DefaultRedisMinBackoff = 100 * time.Millisecond
+	DefaultRedisMaxBackoff = 5 * time.Second
+)
+
+// Redis hash 中一个后端的值，field 为 host:port
+type RedisBackend struct {
+	Weight   int               `json:"weight"` //为 0 时按 1 处理
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// 注册后端并通知网关重新加载，对应 zk 的 RegistServerPath。
+// hash 中的 field 不会随进程退出自动删除，下线时需调用 DeregisterBackend
+func RegisterBackend(ctx context.Context, client redis.UniversalClient, key, channel, addr string, backend RedisBackend) error {
+	data, err := json.Marshal(backend)
+	if err != nil {
+		return err
+	}
+	if err := client.HSet(ctx, key, addr, data).Err(); err != nil {
+		fmt.Println("HSet error", key, addr)
+		return err
+	}
+	return client.Publish(ctx, channel, key).Err()
+}
+
+// 删除后端并通知网关重新加载
+func DeregisterBackend(ctx context.Context, client redis.UniversalClient, key, channel, addr string) error {
+	if err := client.HDel(ctx, key, addr).Err(); err != nil {
+		fmt.Println("HDel error", key, addr)
+		return err
+	}
+	return client.Publish(ctx, channel, key).Err()
+}
+
+// 后端列表保存在 Redis hash 中，收到 pub/sub 消息时重新加载，同时定时全量拉取作为兜底
+type LoadBalanceRedisConf struct {
+	observers []Observer
+	format    string
+	client    redis.UniversalClient
+	key       string        //hash key
+	channel   string        //变更通知的频道
+	Interval  time.Duration //定时全量拉取的间隔，默认 DefaultRedisInterval
+
+	applyMux   sync.Mutex //消息与定时拉取可能同时到达，按顺序处理
+	mux        sync.RWMutex
+	activeList []string //host:port
+	ipWeight   map[string]string
+	backends   map[string]RedisBackend
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func (s *LoadBalanceRedisConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *LoadBalanceRedisConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *LoadBalanceRedisConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceRedisConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	confList := []string{}
+	for _, ip := range s.activeList {
+		confList = append(confList, fmt.Sprintf(s.format, ip)+","+s.ipWeight[ip])
+	}
+	return confList
+}
+
+// 后端注册时带的 metadata
+func (s *LoadBalanceRedisConf) Backend(addr string) (RedisBackend, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	b, ok := s.backends[addr]
+	return b, ok
+}
+
+// 订阅变更频道并定时全量拉取
+func (s *LoadBalanceRedisConf) WatchConf() {
+	fmt.Println("watchConf")
+	go s.subscribe()
+	go func() {
+		for {
+			interval := s.Interval
+			if interval <= 0 {
+				interval = DefaultRedisInterval
+			}
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(interval):
+				s.refresh()
+			}
+		}
+	}()
+}
+
+// 每次(重新)订阅成功都全量拉取一次，补上断线期间错过的消息。出错时按指数退避重连
+func (s *LoadBalanceRedisConf) subscribe() {
+	pubsub := s.client.Subscribe(s.ctx, s.channel)
+	defer pubsub.Close()
+	backoff := DefaultRedisMinBackoff
+	for {
+		msg, err := pubsub.Receive(s.ctx)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			fmt.Println("redis subscribe error", s.channel, err)
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > DefaultRedisMaxBackoff {
+				backoff = DefaultRedisMaxBackoff
+			}
+			continue
+		}
+		switch msg.(type) {
+		case *redis.Subscription:
+			backoff = DefaultRedisMinBackoff
+			s.refresh()
+		case *redis.Message:
+			s.refresh()
+		}
+	}
+}
+
+// 更新配置时，通知监听者也更新
+func (s *LoadBalanceRedisConf) UpdateConf(conf []string) {
+	fmt.Println("UpdateConf", conf)
+	s.mux.Lock()
+	s.activeList = conf
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceRedisConf) Close() {
+	s.cancel()
+}
+
+// 全量拉取一次，Redis 不可用时保留当前的后端列表
+func (s *LoadBalanceRedisConf) refresh() error {
+	s.applyMux.Lock()
+	defer s.applyMux.Unlock()
+	ctx, cancel := context.WithTimeout(s.ctx, DefaultDnsTimeout)
+	defer cancel()
+	fields, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		fmt.Println("redis hgetall error", s.key, err)
+		return err
+	}
+	changedList := []string{}
+	ipWeight := map[string]string{}
+	backends := map[string]RedisBackend{}
+	for addr, value := range fields {
+		var b RedisBackend
+		if err := json.Unmarshal([]byte(value), &b); err != nil || b.Weight < 0 {
+			fmt.Println("redis backend invalid, skip", addr, value)
+			continue
+		}
+		if b.Weight == 0 {
+			b.Weight = 1
+		}
+		changedList = append(changedList, addr)
+		ipWeight[addr] = strconv.Itoa(b.Weight)
+		backends[addr] = b
+	}
+	sort.Strings(changedList)
+	s.mux.Lock()
+	s.backends = backends
+	changed := !reflect.DeepEqual(changedList, s.activeList) || !reflect.DeepEqual(ipWeight, s.ipWeight)
+	if changed {
+		s.ipWeight = ipWeight
+	}
+	s.mux.Unlock()
+	if changed {
+		s.UpdateConf(changedList)
+	}
+	return nil
+}
+
+// format 如 http://%s，key 为保存后端的 hash，channel 为变更通知频道。首次拉取失败时返回错误
+func NewLoadBalanceRedisConf(format string, client redis.UniversalClient, key, channel string) (*LoadBalanceRedisConf, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mConf := &LoadBalanceRedisConf{
+		format:   format,
+		client:   client,
+		key:      key,
+		channel:  channel,
+		ipWeight: map[string]string{},
+		backends: map[string]RedisBackend{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	if err := mConf.refresh(); err != nil {
+		cancel()
+		return nil, err
+	}
+	mConf.WatchConf()
+	return mConf, nil
+}
@@ -0,0 +1,72 @@
+package load_balance
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRedisConfPropagates(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+	const key, channel = "gateway:backends:orders", "gateway:backends"
+
+	if err := RegisterBackend(ctx, client, key, channel, "10.0.0.1:8080", RedisBackend{Weight: 10, Metadata: map[string]string{"zone": "hz-a"}}); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := NewLoadBalanceRedisConf("http://%s", client, key, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	waitServers(t, lb, []string{"http://10.0.0.1:8080"})
+	if w, _ := lb.Weight("http://10.0.0.1:8080"); w != 10 {
+		t.Fatalf("weight %d", w)
+	}
+	if b, ok := conf.Backend("10.0.0.1:8080"); !ok || b.Metadata["zone"] != "hz-a" {
+		t.Fatalf("backend %+v", b)
+	}
+
+	//注册与注销通过 pub/sub 触发更新
+	if err := RegisterBackend(ctx, client, key, channel, "10.0.0.2:8080", RedisBackend{}); err != nil {
+		t.Fatal(err)
+	}
+	waitServers(t, lb, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"})
+	if err := DeregisterBackend(ctx, client, key, channel, "10.0.0.1:8080"); err != nil {
+		t.Fatal(err)
+	}
+	waitServers(t, lb, []string{"http://10.0.0.2:8080"})
+
+	//不合法的值被跳过
+	mr.HSet(key, "10.0.0.9:8080", "not json")
+	conf.refresh()
+	waitServers(t, lb, []string{"http://10.0.0.2:8080"})
+
+	//Redis 不可用时保留当前列表
+	mr.Close()
+	if err := conf.refresh(); err == nil {
+		t.Fatal("refresh should report the error")
+	}
+	if got := sortedServers(lb); !reflect.DeepEqual(got, []string{"http://10.0.0.2:8080"}) {
+		t.Fatalf("outage changed backends: %v", got)
+	}
+
+	//Redis 恢复后重新订阅，并全量拉取断线期间的变化
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	mr.HDel(key, "10.0.0.2:8080")
+	mr.HSet(key, "10.0.0.3:8080", `{"weight": 3}`)
+	waitServers(t, lb, []string{"http://10.0.0.3:8080"})
+	time.Sleep(50 * time.Millisecond)
+	if err := RegisterBackend(ctx, client, key, channel, "10.0.0.4:8080", RedisBackend{Weight: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitServers(t, lb, []string{"http://10.0.0.3:8080", "http://10.0.0.4:8080"})
+}
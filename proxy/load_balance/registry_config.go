@@ -0,0 +1,288 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 注册中心与配置都没有提供权重时使用
+const DefaultRegistryWeight = "50"
+
+// 有心跳模式注册的后端时，重新获取列表检查过期的间隔
+var HeartbeatCheckInterval = time.Second
+
+// 通用的注册中心配置主题：watch 任意 Registry，后端列表变化时通知监听者
+type LoadBalanceRegistryConf struct {
+	observers    []Observer
+	format       string
+	registry     registry.Registry
+	confIpWeight map[string]string //注册中心未提供权重时，按地址配置的权重
+
+	mux        sync.RWMutex
+	activeList []string
+	ipWeight   map[string]string //注册中心提供的权重
+	safety     *ConfSafety
+	heartbeat  bool //最近的列表中有心跳模式注册的后端
+	checkEvery time.Duration
+	backends   map[string]registry.Backend
+	invalid    []*ConfEntryError //最近一次列表中校验失败的后端
+	filter     *registry.Filter
+	raw        []registry.Backend //最近一次注册中心的列表，筛选条件变化时重新应用
+	stale      bool               //注册中心故障，忽略 watch 推送的列表，见 DetectOutage
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	snapshot     *SnapshotOptions
+	snapMux      sync.Mutex
+	fromSnapshot bool
+
+	quiet      time.Duration //合并更新的静默时间，0 表示不合并
+	maxDelay   time.Duration
+	coalesced  int64
+	suppressed int64
+}
+
+func (s *LoadBalanceRegistryConf) Attach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+func (s *LoadBalanceRegistryConf) Detach(o Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = detachObserver(s.observers, o)
+}
+
+func (s *LoadBalanceRegistryConf) NotifyAllObservers() {
+	s.mux.RLock()
+	observers := s.observers
+	s.mux.RUnlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceRegistryConf) GetConf() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	confList := []string{}
+	for _, ip := range s.activeList {
+		weight, ok := s.ipWeight[ip]
+		if !ok {
+			weight, ok = s.confIpWeight[ip]
+		}
+		if !ok {
+			weight = DefaultRegistryWeight
+		}
+		confList = append(confList, fmt.Sprintf(s.format, ip)+","+weight)
+	}
+	return confList
+}
+
+// 按地址顺序返回注册中心提供的后端，包含权重与元数据，供元数据感知的负载均衡使用
+func (s *LoadBalanceRegistryConf) Backends() []registry.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	list := make([]registry.Backend, 0, len(s.activeList))
+	for _, ip := range s.activeList {
+		if b, ok := s.backends[ip]; ok {
+			list = append(list, b)
+		} else {
+			list = append(list, registry.Backend{Addr: ip})
+		}
+	}
+	return list
+}
+
+func (s *LoadBalanceRegistryConf) BackendAddr(addr string) string {
+	return fmt.Sprintf(s.format, addr)
+}
+
+func (s *LoadBalanceRegistryConf) Backend(addr string) (registry.Backend, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	b, ok := s.backends[addr]
+	return b, ok
+}
+
+func (s *LoadBalanceRegistryConf) WatchConf() {
+	fmt.Println("watchConf")
+	ch, err := s.registry.Watch(s.ctx)
+	if err != nil {
+		fmt.Println("registry watch error", err)
+		return
+	}
+	go s.watchLoop(ch)
+	go s.checkHeartbeats()
+}
+
+// 设置后端列表的保护策略，注册中心推送的列表被拒绝时保留上一次的列表
+func (s *LoadBalanceRegistryConf) SetSafety(safety ConfSafety) {
+	s.mux.Lock()
+	s.safety = &safety
+	s.mux.Unlock()
+}
+
+// 按健康状态与标签筛选注册中心的后端，设置后立即用最近的列表重新发布，nil 表示不筛选
+func (s *LoadBalanceRegistryConf) SetFilter(filter *registry.Filter) {
+	s.mux.Lock()
+	s.filter = filter
+	raw := s.raw
+	s.mux.Unlock()
+	if raw != nil {
+		s.apply(raw)
+	}
+}
+
+func (s *LoadBalanceRegistryConf) Filter() *registry.Filter {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.filter
+}
+
+// 最近一次注册中心列表中校验失败的后端及原因
+func (s *LoadBalanceRegistryConf) InvalidEntries() []*ConfEntryError {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return append([]*ConfEntryError(nil), s.invalid...)
+}
+
+// 更新配置时，通知监听者也更新。直接调用不受保护策略限制
+func (s *LoadBalanceRegistryConf) UpdateConf(conf []string) {
+	s.mux.Lock()
+	s.activeList = conf
+	observers := s.observers
+	s.mux.Unlock()
+	for _, obs := range observers {
+		obs.Update()
+	}
+}
+
+func (s *LoadBalanceRegistryConf) Close() {
+	s.cancel()
+}
+
+// 心跳只更新节点数据，不一定触发 watch，定期重新获取列表以过滤过期的后端、恢复重新心跳的后端
+func (s *LoadBalanceRegistryConf) checkHeartbeats() {
+	ticker := time.NewTicker(s.checkEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.mux.RLock()
+		heartbeat := s.heartbeat && !s.stale
+		s.mux.RUnlock()
+		if !heartbeat {
+			continue
+		}
+		list, err := s.registry.List()
+		if err != nil {
+			fmt.Println("registry list error", err)
+			continue
+		}
+		s.apply(list)
+	}
+}
+
+// 列表、权重或元数据变化时通知监听者并返回 true，超过 TTL 没有心跳的后端不发布
+func (s *LoadBalanceRegistryConf) apply(list []registry.Backend) bool {
+	s.mux.Lock()
+	s.raw = list
+	filter := s.filter
+	s.mux.Unlock()
+	heartbeat := registry.HasHeartbeat(list)
+	list = registry.FilterStale(list, time.Now())
+	if filter != nil {
+		list = filter.Apply(list)
+	}
+	total := len(list)
+	list, invalid := validateBackends(s.format, list)
+	changedList := []string{}
+	ipWeight := map[string]string{}
+	backends := map[string]registry.Backend{}
+	for _, b := range list {
+		changedList = append(changedList, b.Addr)
+		if b.Weight > 0 {
+			ipWeight[b.Addr] = strconv.Itoa(b.Weight)
+		}
+		//心跳时间每次都变，不作为变化通知监听者
+		if _, ok := b.Metadata[registry.MetadataHeartbeat]; ok {
+			meta := map[string]string{}
+			for k, v := range b.Metadata {
+				if k != registry.MetadataHeartbeat {
+					meta[k] = v
+				}
+			}
+			b.Metadata = meta
+		}
+		backends[b.Addr] = b
+	}
+	s.mux.Lock()
+	s.heartbeat = heartbeat
+	s.invalid = invalid
+	if s.safety != nil {
+		err := s.safety.checkValid(total, len(list))
+		if err == nil {
+			err = s.safety.Check(s.activeList, changedList)
+		}
+		if err != nil {
+			s.mux.Unlock()
+			fmt.Println("registry conf update rejected", err)
+			return false
+		}
+	}
+	changed := !reflect.DeepEqual(changedList, s.activeList) || !reflect.DeepEqual(backends, s.backends)
+	s.ipWeight = ipWeight
+	s.backends = backends
+	save := changed && s.snapshot != nil && !s.fromSnapshot
+	s.mux.Unlock()
+	if changed {
+		s.UpdateConf(changedList)
+	}
+	if save {
+		s.saveSnapshot(s.Backends())
+	}
+	return changed
+}
+
+// conf 为地址到权重的配置，可以为空。首次获取列表失败时返回错误
+func NewLoadBalanceRegistryConf(format string, r registry.Registry, conf map[string]string) (*LoadBalanceRegistryConf, error) {
+	list, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	mConf := newLoadBalanceRegistryConf(format, r, conf)
+	mConf.start(list)
+	return mConf, nil
+}
+
+func newLoadBalanceRegistryConf(format string, r registry.Registry, conf map[string]string) *LoadBalanceRegistryConf {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LoadBalanceRegistryConf{
+		format:       format,
+		registry:     r,
+		confIpWeight: conf,
+		activeList:   []string{},
+		ipWeight:     map[string]string{},
+		backends:     map[string]registry.Backend{},
+		checkEvery:   HeartbeatCheckInterval,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// 应用首次获取的列表并开始 watch
+func (s *LoadBalanceRegistryConf) start(list []registry.Backend) {
+	registry.SortBackends(list)
+	s.apply(list)
+	s.WatchConf()
+}
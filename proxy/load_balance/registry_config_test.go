@@ -0,0 +1,163 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistryConfPropagates(t *testing.T) {
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002"})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8003"})
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, map[string]string{"127.0.0.1:8002": "20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+
+	//权重依次来自注册中心、配置、默认值
+	want := []string{"http://127.0.0.1:8001,10", "http://127.0.0.1:8002,20", "http://127.0.0.1:8003," + DefaultRegistryWeight}
+	if got := conf.GetConf(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	r.Deregister("127.0.0.1:8001")
+	r.Register(registry.Backend{Addr: "127.0.0.1:8004", Weight: 5})
+	waitServers(t, lb, []string{"http://127.0.0.1:8002", "http://127.0.0.1:8003", "http://127.0.0.1:8004"})
+	if w, _ := lb.Weight("http://127.0.0.1:8004"); w != 5 {
+		t.Fatalf("weight %d", w)
+	}
+}
+
+func TestRegistryConfBackends(t *testing.T) {
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10, Metadata: map[string]string{registry.MetadataZone: "hz-a"}})
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	var _ BackendConf = conf
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+
+	//只有元数据变化时也通知监听者
+	updates := &countObserver{}
+	conf.Attach(updates)
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10, Metadata: map[string]string{registry.MetadataZone: "hz-b"}})
+	deadline := time.Now().Add(2 * time.Second)
+	for updates.get() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("metadata change not notified")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Weight: 20, Metadata: map[string]string{registry.MetadataZone: "hz-a", "version": "v2"}})
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002"})
+	if w, _ := lb.Weight("http://127.0.0.1:8002"); w != 20 {
+		t.Fatalf("weight %d", w)
+	}
+	want := []registry.Backend{
+		{Addr: "127.0.0.1:8001", Weight: 10, Metadata: map[string]string{registry.MetadataZone: "hz-b"}},
+		{Addr: "127.0.0.1:8002", Weight: 20, Metadata: map[string]string{registry.MetadataZone: "hz-a", "version": "v2"}},
+	}
+	if got := conf.Backends(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("backends %+v", got)
+	}
+	if b, ok := conf.Backend("127.0.0.1:8002"); !ok || b.Metadata["version"] != "v2" {
+		t.Fatalf("backend %+v", b)
+	}
+}
+
+func TestRegistryConfHeartbeat(t *testing.T) {
+	interval := HeartbeatCheckInterval
+	HeartbeatCheckInterval = 10 * time.Millisecond
+	defer func() { HeartbeatCheckInterval = interval }()
+
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001"})
+	ctx, cancel := context.WithCancel(context.Background())
+	go registry.RunHeartbeat(ctx, r, registry.Backend{Addr: "127.0.0.1:8002"}, 100*time.Millisecond, 10*time.Millisecond)
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbRoundRobin, conf).(*RoundRobinBalance)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002"})
+
+	//心跳停止后被过滤，没有心跳信息的后端不受影响
+	cancel()
+	waitServers(t, lb, []string{"http://127.0.0.1:8001"})
+
+	//心跳恢复
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go registry.RunHeartbeat(ctx, r, registry.Backend{Addr: "127.0.0.1:8002"}, 100*time.Millisecond, 10*time.Millisecond)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002"})
+}
+
+func TestRegistryConfMultiPath(t *testing.T) {
+	hz, sh := registry.NewMemory(), registry.NewMemory()
+	hz.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10})
+	sh.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 30})
+	m := registry.NewMulti(registry.MultiSource{Registry: hz, Zone: "hz"}, registry.MultiSource{Registry: sh, WeightFactor: 2, Zone: "sh"})
+	conf, err := NewLoadBalanceRegistryConf("http://%s", m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	updates := &countObserver{}
+	conf.Attach(updates)
+
+	//两个路径的一批变化只触发一次更新
+	sh.Register(registry.Backend{Addr: "127.0.0.1:8002", Weight: 10})
+	sh.Register(registry.Backend{Addr: "127.0.0.1:8003", Weight: 10})
+	hz.Register(registry.Backend{Addr: "127.0.0.1:8004", Weight: 10})
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002", "http://127.0.0.1:8003", "http://127.0.0.1:8004"})
+	time.Sleep(200 * time.Millisecond)
+	if n := updates.get(); n != 1 {
+		t.Fatalf("updates %d", n)
+	}
+	if w, _ := lb.Weight("http://127.0.0.1:8002"); w != 20 {
+		t.Fatalf("weight %d", w)
+	}
+	if b, _ := conf.Backend("127.0.0.1:8001"); b.Weight != 10 || b.Metadata[registry.MetadataZone] != "hz" {
+		t.Fatalf("backend %+v", b)
+	}
+}
+
+func TestRegistryConfFilter(t *testing.T) {
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Metadata: map[string]string{registry.MetadataTags: "primary"}})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Metadata: map[string]string{registry.MetadataTags: "primary,canary"}})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8003", Metadata: map[string]string{registry.MetadataTags: "primary", registry.MetadataHealth: registry.HealthWarning}})
+	conf, err := NewLoadBalanceRegistryConf("http://%s", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	var _ FilterConf = conf
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	filter, _ := registry.NewFilter("primary and not canary", false)
+	conf.SetFilter(filter)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001"})
+
+	//切换是否包含 warning 的后端
+	filter, _ = registry.NewFilter("primary and not canary", true)
+	conf.SetFilter(filter)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8003"})
+
+	//健康状态与标签变化在下一次 watch 时生效
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Metadata: map[string]string{registry.MetadataTags: "primary", registry.MetadataHealth: registry.HealthCritical}})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Metadata: map[string]string{registry.MetadataTags: "primary"}})
+	waitServers(t, lb, []string{"http://127.0.0.1:8002", "http://127.0.0.1:8003"})
+
+	conf.SetFilter(nil)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002", "http://127.0.0.1:8003"})
+}
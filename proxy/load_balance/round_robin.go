@@ -4,27 +4,54 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 )
 
 type RoundRobinBalance struct {
+	mux      sync.Mutex
 	curIndex int
 	// 当前数组
 	rss []string
 	// 观察主题
 	conf LoadBalanceConf
-	// sync.RWMutex
 }
 
 func (r *RoundRobinBalance) Add(params ...string)error{
 	if len(params)==0{
 		return errors.New("params len 0")
 	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
 	addr := params[0]
 	r.rss = append(r.rss, addr)
 	return nil	
 }
 
+func (r *RoundRobinBalance) Remove(addr string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for i, item := range r.rss {
+		if item == addr {
+			r.rss = append(r.rss[:i:i], r.rss[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNodeNotFound
+}
+
+func (r *RoundRobinBalance) Servers() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]string(nil), r.rss...)
+}
+
 func (r *RoundRobinBalance) Next() string{
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.next()
+}
+
+func (r *RoundRobinBalance) next() string{
 	if(len(r.rss)==0){
 		return ""
 	}
@@ -41,23 +68,43 @@ func (r *RoundRobinBalance) Get(key string) (string, error){
 	return r.Next(), nil
 }
 
+func (r *RoundRobinBalance) GetExcluding(key string, excluded map[string]bool) (string, error){
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for i:=0;i<len(r.rss);i++{
+		addr := r.next()
+		if !excluded[addr]{
+			return addr, nil
+		}
+	}
+	return "", ErrNoBackends
+}
+
 func (r *RoundRobinBalance) SetConf(conf LoadBalanceConf){
 	r.conf = conf
 }
 
 func (r *RoundRobinBalance) Update(){
-	if conf, ok:= r.conf.(*LoadBalanceZkConf); ok{
-		fmt.Println("Update get Conf", conf.GetConf())
-		r.rss = []string{}
-		for _,ip := range conf.GetConf(){
-			r.Add(strings.Split(ip,",")...)
-		}
+	//所有配置主题都通过 GetConf 提供 "地址,权重" 列表
+	if r.conf == nil {
+		return
 	}
-	if conf, ok:= r.conf.(*LoadBalanceCheckConf); ok{
-		fmt.Println("Update get Conf", conf.GetConf())
-		r.rss = nil
-		for _,ip:= range conf.GetConf(){
-			r.Add(strings.Split(ip,",")...)
-		}
+	conf := r.conf.GetConf()
+	fmt.Println("Update get Conf", conf)
+	r.reset(conf)
+}
+
+func (r *RoundRobinBalance) SetServers(conf []string) {
+	r.reset(conf)
+}
+
+// 用配置整体替换节点列表
+func (r *RoundRobinBalance) reset(confList []string){
+	rss := []string{}
+	for _,ip:= range confList{
+		rss = append(rss, strings.Split(ip,",")[0])
 	}
+	r.mux.Lock()
+	r.rss = rss
+	r.mux.Unlock()
 }
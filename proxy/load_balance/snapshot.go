@@ -0,0 +1,173 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"GO_GATEWAY/proxy/registry"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 启动时等待注册中心的默认时间
+const DefaultBootstrapTimeout = 5 * time.Second
+
+// 快照文件的格式版本，旧版本没有该字段按 1 处理
+const snapshotSchema = 1
+
+var ErrSnapshotStale = errors.New("backend snapshot too old")
+
+// 为 1 表示注册中心不可用，正在使用快照中的后端列表
+var servingSnapshot = metrics.NewGaugeVec("gateway_lb_serving_snapshot", "是否正在使用快照中的后端列表，按快照文件统计", "snapshot")
+
+// 最近一次可用后端列表的快照。注册中心启动时不可用时用快照启动，恢复后再以注册中心为准
+type SnapshotOptions struct {
+	Path             string        //快照文件，每次接受的列表更新都会写入
+	MaxAge           time.Duration //超过该时间的快照不使用，0 表示不限制
+	BootstrapTimeout time.Duration //启动时等待注册中心的时间，默认 DefaultBootstrapTimeout
+	RetryInterval    time.Duration //使用快照期间重试注册中心的间隔，默认与 BootstrapTimeout 相同
+}
+
+type backendSnapshot struct {
+	Schema   int                `json:"schema"`
+	Time     time.Time          `json:"time"`
+	Backends []registry.Backend `json:"backends"`
+}
+
+// 先写临时文件再改名，进程中途退出也不会留下不完整的快照
+func writeSnapshot(path string, backends []registry.Backend, now time.Time) error {
+	data, err := json.Marshal(backendSnapshot{Schema: snapshotSchema, Time: now, Backends: backends})
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func loadSnapshot(path string, maxAge time.Duration, now time.Time) ([]registry.Backend, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap backendSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	if snap.Schema > snapshotSchema {
+		return nil, fmt.Errorf("unsupported backend snapshot schema %d", snap.Schema)
+	}
+	if maxAge > 0 && now.Sub(snap.Time) > maxAge {
+		return nil, fmt.Errorf("%w: written at %s", ErrSnapshotStale, snap.Time.Format(time.RFC3339))
+	}
+	return snap.Backends, nil
+}
+
+// 在 timeout 内获取列表，Registry.List 不支持取消，超时后放弃等待
+func listWithTimeout(r registry.Registry, timeout time.Duration) ([]registry.Backend, error) {
+	type result struct {
+		list []registry.Backend
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		list, err := r.List()
+		ch <- result{list, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.list, res.err
+	case <-time.After(timeout):
+		return nil, errors.New("registry list timeout")
+	}
+}
+
+// 与 NewLoadBalanceRegistryConf 相同，并把接受的列表写入快照。注册中心在 BootstrapTimeout 内不可用时
+// 使用未过期的快照启动，之后按 RetryInterval 重试，成功后以注册中心的列表为准并开始 watch
+func NewLoadBalanceRegistryConfWithSnapshot(format string, r registry.Registry, conf map[string]string, snapshot SnapshotOptions) (*LoadBalanceRegistryConf, error) {
+	if snapshot.BootstrapTimeout <= 0 {
+		snapshot.BootstrapTimeout = DefaultBootstrapTimeout
+	}
+	if snapshot.RetryInterval <= 0 {
+		snapshot.RetryInterval = snapshot.BootstrapTimeout
+	}
+	list, err := listWithTimeout(r, snapshot.BootstrapTimeout)
+	if err == nil {
+		mConf := newLoadBalanceRegistryConf(format, r, conf)
+		mConf.snapshot = &snapshot
+		mConf.start(list)
+		return mConf, nil
+	}
+	backends, snapErr := loadSnapshot(snapshot.Path, snapshot.MaxAge, time.Now())
+	if snapErr != nil {
+		return nil, fmt.Errorf("registry unavailable: %v, snapshot: %w", err, snapErr)
+	}
+	fmt.Println("registry unavailable, serving from snapshot", snapshot.Path, err)
+	mConf := newLoadBalanceRegistryConf(format, r, conf)
+	mConf.snapshot = &snapshot
+	mConf.fromSnapshot = true
+	servingSnapshot.Set(snapshot.Path, 1)
+	mConf.apply(backends)
+	go mConf.reconcile()
+	return mConf, nil
+}
+
+// 是否正在使用快照中的列表
+func (s *LoadBalanceRegistryConf) ServingSnapshot() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.fromSnapshot
+}
+
+// 注册中心恢复后用其列表替换快照，并开始 watch
+func (s *LoadBalanceRegistryConf) reconcile() {
+	ticker := time.NewTicker(s.snapshot.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			servingSnapshot.Set(s.snapshot.Path, 0)
+			return
+		case <-ticker.C:
+		}
+		list, err := listWithTimeout(s.registry, s.snapshot.BootstrapTimeout)
+		if err != nil {
+			fmt.Println("registry still unavailable", err)
+			continue
+		}
+		s.mux.Lock()
+		s.fromSnapshot = false
+		s.mux.Unlock()
+		servingSnapshot.Set(s.snapshot.Path, 0)
+		fmt.Println("registry recovered, leave snapshot", s.snapshot.Path)
+		s.start(list)
+		//列表可能与快照相同，刷新快照时间
+		s.saveSnapshot(s.Backends())
+		return
+	}
+}
+
+// 写入接受的列表，使用快照期间不写
+func (s *LoadBalanceRegistryConf) saveSnapshot(backends []registry.Backend) {
+	s.snapMux.Lock()
+	defer s.snapMux.Unlock()
+	if err := writeSnapshot(s.snapshot.Path, backends, time.Now()); err != nil {
+		fmt.Println("write backend snapshot error", s.snapshot.Path, err)
+	}
+}
@@ -0,0 +1,108 @@
+package load_balance
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 可以模拟不可用的注册中心，不可用时 List 一直阻塞
+type downRegistry struct {
+	*registry.Memory
+	down atomic.Bool
+}
+
+func (r *downRegistry) List() ([]registry.Backend, error) {
+	for r.down.Load() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return r.Memory.List()
+}
+
+func TestSnapshotWrittenOnUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	r := registry.NewMemory()
+	r.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10})
+	conf, err := NewLoadBalanceRegistryConfWithSnapshot("http://%s", r, nil, SnapshotOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002", Metadata: map[string]string{registry.MetadataZone: "hz-a"}})
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002"})
+
+	backends, err := loadSnapshot(path, time.Minute, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []registry.Backend{{Addr: "127.0.0.1:8001", Weight: 10}, {Addr: "127.0.0.1:8002", Metadata: map[string]string{registry.MetadataZone: "hz-a"}}}
+	if !reflect.DeepEqual(backends, want) {
+		t.Fatalf("snapshot %+v", backends)
+	}
+}
+
+func TestSnapshotStartupWithRegistryDown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	if err := writeSnapshot(path, []registry.Backend{{Addr: "127.0.0.1:8001", Weight: 10}, {Addr: "127.0.0.1:8002"}}, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	r := &downRegistry{Memory: registry.NewMemory()}
+	r.down.Store(true)
+	opts := SnapshotOptions{Path: path, MaxAge: time.Hour, BootstrapTimeout: 50 * time.Millisecond, RetryInterval: 10 * time.Millisecond}
+	conf, err := NewLoadBalanceRegistryConfWithSnapshot("http://%s", r, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	lb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, conf).(*WeightRoundRobinBalance)
+	waitServers(t, lb, []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002"})
+	if !conf.ServingSnapshot() || servingSnapshot.Get(path) != 1 {
+		t.Fatal("not serving from snapshot")
+	}
+
+	//注册中心恢复后以其列表为准，并继续 watch
+	r.Register(registry.Backend{Addr: "127.0.0.1:8002"})
+	r.Register(registry.Backend{Addr: "127.0.0.1:8003"})
+	r.down.Store(false)
+	waitServers(t, lb, []string{"http://127.0.0.1:8002", "http://127.0.0.1:8003"})
+	if conf.ServingSnapshot() || servingSnapshot.Get(path) != 0 {
+		t.Fatal("still serving from snapshot")
+	}
+	r.Register(registry.Backend{Addr: "127.0.0.1:8004"})
+	waitServers(t, lb, []string{"http://127.0.0.1:8002", "http://127.0.0.1:8003", "http://127.0.0.1:8004"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		backends, _ := loadSnapshot(path, 0, time.Now())
+		if len(backends) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot %+v", backends)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSnapshotStaleRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	if err := writeSnapshot(path, []registry.Backend{{Addr: "127.0.0.1:8001"}}, time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	r := &downRegistry{Memory: registry.NewMemory()}
+	r.down.Store(true)
+	defer r.down.Store(false)
+	_, err := NewLoadBalanceRegistryConfWithSnapshot("http://%s", r, nil, SnapshotOptions{Path: path, MaxAge: time.Hour, BootstrapTimeout: 20 * time.Millisecond})
+	if !errors.Is(err, ErrSnapshotStale) {
+		t.Fatalf("err %v", err)
+	}
+	//没有快照时返回注册中心的错误
+	_, err = NewLoadBalanceRegistryConfWithSnapshot("http://%s", r, nil, SnapshotOptions{Path: path + ".missing", BootstrapTimeout: 20 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected error without snapshot")
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"strconv"
 	"fmt"
 	"strings"
+	"sync"
 )
 
 type WeightRoundRobinBalance struct {
+	mux sync.Mutex
 	curIndex int
 	rsw []string
 	rss []*WeightNode
@@ -21,27 +23,99 @@ type WeightNode struct {
 }
 
 func (r *WeightRoundRobinBalance) Add(params ...string) error {
-	if(len(params)!=2){
-		return errors.New("param len need 2")
+	curNode, err := newWeightNode(params...)
+	if err != nil{
+		return err
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.rss = append(r.rss, curNode)
+	return nil
+}
+
+func newWeightNode(params ...string) (*WeightNode, error) {
+	//第三个及之后的参数为节点元数据，由其他组件解析
+	if(len(params)<2){
+		return nil, errors.New("param len need 2")
 	}	
 	parInt, err := strconv.ParseInt(params[1],10,64)
 	if err !=nil{
-		return err
+		return nil, err
 	}
 	curNode := &WeightNode{
 		addr: params[0],
 		weight: int(parInt),
 	}
 	curNode.effectiveWeight = curNode.weight
-	r.rss = append(r.rss, curNode)
-	return nil
+	return curNode, nil
+}
+
+func (r *WeightRoundRobinBalance) Remove(addr string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for i, node := range r.rss {
+		if node.addr == addr {
+			r.rss = append(r.rss[:i:i], r.rss[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNodeNotFound
+}
+
+func (r *WeightRoundRobinBalance) Servers() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	list := make([]string, 0, len(r.rss))
+	for _, node := range r.rss {
+		list = append(list, node.addr)
+	}
+	return list
+}
+
+// 运行时调整权重
+func (r *WeightRoundRobinBalance) SetWeight(addr string, weight int) error {
+	if weight <= 0 {
+		return errors.New("weight must be positive")
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, node := range r.rss {
+		if node.addr == addr {
+			node.weight = weight
+			node.effectiveWeight = weight
+			node.currentWeight = 0
+			return nil
+		}
+	}
+	return ErrNodeNotFound
+}
+
+func (r *WeightRoundRobinBalance) Weight(addr string) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, node := range r.rss {
+		if node.addr == addr {
+			return node.weight, nil
+		}
+	}
+	return 0, ErrNodeNotFound
 }
 
 func (r *WeightRoundRobinBalance) Next() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.next(nil)
+}
+
+// 平滑加权轮询，跳过 excluded 中的节点
+func (r *WeightRoundRobinBalance) next(excluded map[string]bool) string {
 	total := 0
 	var best *WeightNode
 	for i:=0;i<len(r.rss);i++{
 		w:= r.rss[i]
+		if excluded[w.addr]{
+			continue
+		}
 		// 1. 统计总权重
 		total += w.effectiveWeight
 		// 2.临时权重变更
@@ -65,23 +139,46 @@ func (r *WeightRoundRobinBalance) Get(key string) (string,error){
 	return r.Next(),nil
 }
 
+func (r *WeightRoundRobinBalance) GetExcluding(key string, excluded map[string]bool) (string, error){
+	r.mux.Lock()
+	addr := r.next(excluded)
+	r.mux.Unlock()
+	if addr == ""{
+		return "", ErrNoBackends
+	}
+	return addr, nil
+}
+
 func (r *WeightRoundRobinBalance) SetConf(conf LoadBalanceConf) {
 	r.conf = conf
 }
 
 func (r *WeightRoundRobinBalance) Update() {
-	if conf, ok := r.conf.(*LoadBalanceZkConf); ok {
-		fmt.Println("WeightRoundRobinBalance get conf:", conf.GetConf())
-		r.rss = nil
-		for _, ip := range conf.GetConf() {
-			r.Add(strings.Split(ip, ",")...)
-		}
+	//所有配置主题都通过 GetConf 提供 "地址,权重" 列表
+	if r.conf == nil {
+		return
 	}
-	if conf, ok := r.conf.(*LoadBalanceCheckConf); ok {
-		fmt.Println("WeightRoundRobinBalance get conf:", conf.GetConf())
-		r.rss = nil
-		for _, ip := range conf.GetConf() {
-			r.Add(strings.Split(ip, ",")...)
+	conf := r.conf.GetConf()
+	fmt.Println("WeightRoundRobinBalance get conf:", conf)
+	r.reset(conf)
+}
+
+func (r *WeightRoundRobinBalance) SetServers(conf []string) {
+	r.reset(conf)
+}
+
+// 用配置整体替换节点列表
+func (r *WeightRoundRobinBalance) reset(confList []string) {
+	rss := []*WeightNode{}
+	for _, ip := range confList {
+		node, err := newWeightNode(strings.Split(ip, ",")...)
+		if err != nil {
+			fmt.Println("WeightRoundRobinBalance skip conf:", ip, err)
+			continue
 		}
+		rss = append(rss, node)
 	}
+	r.mux.Lock()
+	r.rss = rss
+	r.mux.Unlock()
 }
\ No newline at end of file
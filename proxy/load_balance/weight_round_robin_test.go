@@ -21,4 +21,24 @@ func TestWeightRoundRobinBalance(t *testing.T){
 	fmt.Println(rb.Next())
 	fmt.Println(rb.Next())
 	fmt.Println(rb.Next())
-}
\ No newline at end of file
+}
+
+func TestWeightRoundRobinExplain(t *testing.T) {
+	rb := &WeightRoundRobinBalance{}
+	rb.Add("127.0.0.1:2001", "4")
+	rb.Add("127.0.0.1:2002", "3")
+	rb.Add("127.0.0.1:2003", "1")
+	for i := 0; i < 16; i++ {
+		d, err := rb.Explain("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		//预测不改变状态，与随后的选择一致
+		if again, _ := rb.Explain(""); again.Addr != d.Addr {
+			t.Fatalf("explain changed state: %s then %s", d.Addr, again.Addr)
+		}
+		if next := rb.Next(); next != d.Addr {
+			t.Fatalf("round %d: predicted %s, got %s", i, d.Addr, next)
+		}
+	}
+}
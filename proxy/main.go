@@ -1,6 +1,8 @@
 package main
 
 import (
+	"GO_GATEWAY/proxy/gateway"
+	"GO_GATEWAY/proxy/metrics"
 	"log"
 	"net/http"
 	"net/url"
@@ -16,6 +18,9 @@ func main(){
 		log.Println(err1)
 	}
 	proxy := httputil.NewSingleHostReverseProxy(url1)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", gateway.RequestMetrics(proxy))
 	log.Println("Starting httpserver at :2000")
-	log.Fatal(http.ListenAndServe(":2000", proxy))
+	log.Fatal(http.ListenAndServe(":2000", mux))
 }
\ No newline at end of file
@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultMaxBackends        = 1024             //最多保留统计的后端数，超过后淘汰最久未出现的后端
+	DefaultBackendIdleTimeout = 30 * time.Minute //后端超过该时间没有请求时淘汰其统计
+)
+
+// 全局后端统计中耗时直方图的指标名，其他导出方式沿用该名称
+const BackendLatencyMetric = "gateway_backend_latency_ms"
+
+// 未设置 Classify 时错误统一归为该类别
+const FailureClassError = "error"
+
+// 状态码为 5xx 的响应的失败类别
+const FailureClass5xx = "5xx"
+
+// 按后端地址汇总的上游请求统计：请求数、按类别的失败数、耗时直方图与响应字节数。
+// 后端数量有上限，长时间没有请求的后端会被淘汰，后端频繁上下线时内存不会一直增长
+type BackendStatsMap struct {
+	MaxBackends int                    //默认 DefaultMaxBackends
+	IdleTimeout time.Duration          //默认 DefaultBackendIdleTimeout，小于 0 表示不按时间淘汰
+	Buckets     []float64              //耗时直方图的分桶(毫秒)，默认 LatencyBuckets
+	Classify    func(err error) string //错误的失败类别，默认 FailureClassError
+
+	mux      sync.RWMutex
+	backends map[string]*backendStats
+}
+
+type backendStats struct {
+	requests int64
+	bytes    int64
+	lastSeen int64 //UnixNano
+	latency  *histogram
+
+	mux      sync.Mutex
+	failures map[string]int64
+}
+
+// 单个后端统计的快照，与统计本身不共享任何切片或 map
+type BackendStats struct {
+	Requests   int64             `json:"requests"`
+	Failures   map[string]int64  `json:"failures,omitempty"` //按失败类别的次数，没有失败时为空
+	Bytes      int64             `json:"bytes"`
+	Latency    HistogramSnapshot `json:"latency"` //毫秒
+	LatencyAvg Duration          `json:"latency_avg"`
+	LatencyP99 Duration          `json:"latency_p99"`
+	LastSeen   time.Time         `json:"last_seen"`
+}
+
+// 网关使用的全局后端统计，Prometheus 导出时包含其中的各后端序列
+var Backends = NewBackendStatsMap()
+
+func NewBackendStatsMap() *BackendStatsMap {
+	return &BackendStatsMap{backends: map[string]*backendStats{}}
+}
+
+// 在全局后端统计中记录一次上游请求，重试的每次尝试分别记录
+func RecordUpstreamResult(backend string, status int, d time.Duration, bytes int64, err error) {
+	Backends.Record(backend, status, d, bytes, err)
+}
+
+// 记录一次上游请求：d 为本次尝试的耗时，bytes 为读取到的响应体字节数。
+// err 不为 nil 时按 Classify 归类为失败，否则状态码为 5xx 时记为 FailureClass5xx
+func (m *BackendStatsMap) Record(backend string, status int, d time.Duration, bytes int64, err error) {
+	now := time.Now()
+	//持有读锁期间更新，淘汰需要写锁，不会丢失正在记录的数据
+	m.mux.RLock()
+	s, ok := m.backends[backend]
+	if !ok {
+		m.mux.RUnlock()
+		m.mux.Lock()
+		if s, ok = m.backends[backend]; !ok {
+			m.evict(now)
+			s = &backendStats{lastSeen: now.UnixNano(), latency: newHistogram(len(m.buckets()))}
+			m.backends[backend] = s
+		}
+		m.mux.Unlock()
+		m.mux.RLock()
+		if s, ok = m.backends[backend]; !ok {
+			m.mux.RUnlock()
+			return
+		}
+	}
+	defer m.mux.RUnlock()
+	atomic.StoreInt64(&s.lastSeen, now.UnixNano())
+	atomic.AddInt64(&s.requests, 1)
+	atomic.AddInt64(&s.bytes, bytes)
+	ms := float64(d) / float64(time.Millisecond)
+	s.latency.observe(m.buckets(), ms)
+	if m == Backends {
+		callObserveHooks(BackendLatencyMetric, "backend", backend, ms)
+	}
+	if class := m.failureClass(status, err); class != "" {
+		s.mux.Lock()
+		if s.failures == nil {
+			s.failures = map[string]int64{}
+		}
+		s.failures[class]++
+		s.mux.Unlock()
+	}
+}
+
+func (m *BackendStatsMap) failureClass(status int, err error) string {
+	switch {
+	case err != nil && m.Classify != nil:
+		return m.Classify(err)
+	case err != nil:
+		return FailureClassError
+	case status >= 500:
+		return FailureClass5xx
+	}
+	return ""
+}
+
+func (m *BackendStatsMap) buckets() []float64 {
+	if m.Buckets == nil {
+		return LatencyBuckets
+	}
+	return m.Buckets
+}
+
+// 新增后端前调用，需持有写锁：先淘汰空闲超时的后端，仍然满时淘汰最久未出现的后端
+func (m *BackendStatsMap) evict(now time.Time) {
+	idle := m.IdleTimeout
+	if idle == 0 {
+		idle = DefaultBackendIdleTimeout
+	}
+	if idle > 0 {
+		m.evictIdle(now.Add(-idle).UnixNano())
+	}
+	max := m.MaxBackends
+	if max <= 0 {
+		max = DefaultMaxBackends
+	}
+	for len(m.backends) >= max {
+		oldest, oldestSeen := "", int64(0)
+		for backend, s := range m.backends {
+			if seen := atomic.LoadInt64(&s.lastSeen); oldest == "" || seen < oldestSeen {
+				oldest, oldestSeen = backend, seen
+			}
+		}
+		delete(m.backends, oldest)
+	}
+}
+
+func (m *BackendStatsMap) evictIdle(before int64) {
+	for backend, s := range m.backends {
+		if atomic.LoadInt64(&s.lastSeen) < before {
+			delete(m.backends, backend)
+		}
+	}
+}
+
+// 淘汰空闲超时的后端，快照与导出前调用，使已下线的后端不再出现在结果中
+func (m *BackendStatsMap) Prune() {
+	idle := m.IdleTimeout
+	if idle == 0 {
+		idle = DefaultBackendIdleTimeout
+	}
+	if idle < 0 {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.evictIdle(time.Now().Add(-idle).UnixNano())
+}
+
+func (m *BackendStatsMap) Get(backend string) (BackendStats, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	s, ok := m.backends[backend]
+	if !ok {
+		return BackendStats{}, false
+	}
+	return s.snapshot(m.buckets()), true
+}
+
+// 所有后端统计的深拷贝，先淘汰空闲超时的后端
+func (m *BackendStatsMap) Snapshot() map[string]BackendStats {
+	m.Prune()
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	snapshot := make(map[string]BackendStats, len(m.backends))
+	for backend, s := range m.backends {
+		snapshot[backend] = s.snapshot(m.buckets())
+	}
+	return snapshot
+}
+
+func (s *backendStats) snapshot(buckets []float64) BackendStats {
+	latency := s.latency.snapshot(buckets)
+	latency.Buckets = append([]float64(nil), buckets...)
+	stats := BackendStats{
+		Requests:   atomic.LoadInt64(&s.requests),
+		Bytes:      atomic.LoadInt64(&s.bytes),
+		Latency:    latency,
+		LatencyAvg: Duration(latency.Average() * float64(time.Millisecond)),
+		LatencyP99: Duration(latency.PercentileDuration(0.99)),
+		LastSeen:   time.Unix(0, atomic.LoadInt64(&s.lastSeen)),
+	}
+	s.mux.Lock()
+	if len(s.failures) > 0 {
+		stats.Failures = make(map[string]int64, len(s.failures))
+		for class, n := range s.failures {
+			stats.Failures[class] = n
+		}
+	}
+	s.mux.Unlock()
+	return stats
+}
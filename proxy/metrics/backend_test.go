@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackendStatsRecord(t *testing.T) {
+	m := NewBackendStatsMap()
+	m.Classify = func(err error) string { return "timeout" }
+	m.Record("a", 200, 10*time.Millisecond, 100, nil)
+	m.Record("a", 502, 20*time.Millisecond, 10, nil)
+	m.Record("a", 0, 30*time.Millisecond, 0, errors.New("i/o timeout"))
+	s, ok := m.Get("a")
+	if !ok || s.Requests != 3 || s.Bytes != 110 || s.Latency.Count != 3 || s.Latency.Max != 30 ||
+		len(s.Failures) != 2 || s.Failures[FailureClass5xx] != 1 || s.Failures["timeout"] != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	//快照是深拷贝，修改快照不影响后续统计
+	s.Failures["timeout"] = 100
+	s.Latency.Buckets[0] = 1000
+	s.Latency.Counts[0] = 1000
+	if again := m.Snapshot()["a"]; again.Failures["timeout"] != 1 || again.Latency.Buckets[0] != LatencyBuckets[0] || again.Latency.Counts[0] != 0 {
+		t.Fatalf("snapshot shared state %+v", again)
+	}
+	if _, ok := m.Get("b"); ok {
+		t.Fatal("unknown backend reported")
+	}
+}
+
+func TestBackendStatsEviction(t *testing.T) {
+	m := NewBackendStatsMap()
+	m.MaxBackends = 3
+	m.IdleTimeout = -1
+	for _, backend := range []string{"a", "b", "c"} {
+		m.Record(backend, 200, time.Millisecond, 1, nil)
+		time.Sleep(time.Millisecond)
+	}
+	m.Record("a", 200, time.Millisecond, 1, nil)
+	//满时淘汰最久未出现的后端 b，a 最近出现过而保留
+	m.Record("d", 200, time.Millisecond, 1, nil)
+	snapshot := m.Snapshot()
+	if _, ok := snapshot["b"]; ok || len(snapshot) != 3 || snapshot["a"].Requests != 2 {
+		t.Fatalf("capacity eviction %v", snapshot)
+	}
+
+	//长时间没有请求的后端在快照与新增后端时淘汰
+	m = NewBackendStatsMap()
+	m.IdleTimeout = 50 * time.Millisecond
+	m.Record("gone", 200, time.Millisecond, 1, nil)
+	time.Sleep(100 * time.Millisecond)
+	m.Record("live", 200, time.Millisecond, 1, nil)
+	if _, ok := m.Get("gone"); ok {
+		t.Fatal("idle backend kept after new backend")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if snapshot := m.Snapshot(); len(snapshot) != 0 {
+		t.Fatalf("idle backend kept in snapshot %v", snapshot)
+	}
+}
+
+func TestBackendStatsChurn(t *testing.T) {
+	m := NewBackendStatsMap()
+	m.MaxBackends = 16
+	for i := 0; i < 10000; i++ {
+		m.Record(string(rune('a'+i%26))+time.Duration(i).String(), 200, time.Millisecond, 1, nil)
+	}
+	if n := len(m.Snapshot()); n != 16 {
+		t.Fatalf("backends %d", n)
+	}
+}
+
+func TestBackendStatsPrometheus(t *testing.T) {
+	RecordUpstreamResult("http://prom:1", 500, 3*time.Millisecond, 42, nil)
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`gateway_backend_requests_total{backend="http://prom:1"} 1`,
+		`gateway_backend_failures_total{backend="http://prom:1",class="5xx"} 1`,
+		`gateway_backend_response_bytes_total{backend="http://prom:1"} 42`,
+		`gateway_backend_latency_seconds_bucket{backend="http://prom:1",le="0.005"} 1`,
+		`gateway_backend_latency_seconds_count{backend="http://prom:1"} 1`,
+		`gateway_backend_latency_seconds_sum{backend="http://prom:1"} 0.003`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 带一个标签维度的计数器，如按后端地址统计
+type CounterVec struct {
+	Name  string
+	Help  string
+	Label string
+
+	mux    sync.RWMutex
+	values map[string]*int64
+}
+
+var (
+	registryMux sync.RWMutex
+	counterVecs = map[string]*CounterVec{}
+)
+
+// 创建并注册计数器，同名重复注册时返回已存在的实例
+func NewCounterVec(name, help, label string) *CounterVec {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if c, ok := counterVecs[name]; ok {
+		return c
+	}
+	c := &CounterVec{Name: name, Help: help, Label: label, values: map[string]*int64{}}
+	counterVecs[name] = c
+	return c
+}
+
+// 所有已注册的计数器，按名称排序
+func CounterVecs() []*CounterVec {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+	list := make([]*CounterVec, 0, len(counterVecs))
+	for _, c := range counterVecs {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (c *CounterVec) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+func (c *CounterVec) Add(labelValue string, delta int64) {
+	c.mux.RLock()
+	v, ok := c.values[labelValue]
+	c.mux.RUnlock()
+	if !ok {
+		c.mux.Lock()
+		if v, ok = c.values[labelValue]; !ok {
+			v = new(int64)
+			c.values[labelValue] = v
+		}
+		c.mux.Unlock()
+	}
+	atomic.AddInt64(v, delta)
+}
+
+func (c *CounterVec) Get(labelValue string) int64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if v, ok := c.values[labelValue]; ok {
+		return atomic.LoadInt64(v)
+	}
+	return 0
+}
+
+// 当前所有标签值的快照
+func (c *CounterVec) Snapshot() map[string]int64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	snapshot := make(map[string]int64, len(c.values))
+	for k, v := range c.values {
+		snapshot[k] = atomic.LoadInt64(v)
+	}
+	return snapshot
+}
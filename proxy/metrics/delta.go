@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// 按读取者维护上一次读取到的累计值，每次读取返回与上一次读取之间的增量。
+// 全局指标本身始终是累计值，不影响 Prometheus、expvar、OpenTelemetry 等按累计值读取的导出，
+// 多个读取者各自维护基线，互不影响。零值可以直接使用，第一次读取返回启动以来的累计值
+type DeltaReader struct {
+	mux  sync.Mutex
+	last MetricsSnapshot
+}
+
+// 读取快照：计数器、直方图、后端统计、lb 与状态码统计为与上一次读取之间的增量，瞬时值与运行时状态为当前值。
+// 累计值变小(如后端统计被淘汰后重新出现)时以当前值作为增量。
+// 直方图的 Min、Max 无法按区间拆分，仍为累计的极值，分位数按区间内各桶的数量估算
+func (r *DeltaReader) Read() MetricsSnapshot {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	cur := GetSnapshot()
+	last := r.last
+	r.last = cur
+	d := cur
+	d.Counters = make(map[string]map[string]int64, len(cur.Counters))
+	for name, values := range cur.Counters {
+		d.Counters[name] = deltaValues(values, last.Counters[name])
+	}
+	d.Histograms = make(map[string]map[string]HistogramSnapshot, len(cur.Histograms))
+	for name, values := range cur.Histograms {
+		d.Histograms[name] = make(map[string]HistogramSnapshot, len(values))
+		for label, h := range values {
+			d.Histograms[name][label] = deltaHistogram(h, last.Histograms[name][label])
+		}
+	}
+	d.Backends = make(map[string]BackendStats, len(cur.Backends))
+	for backend, s := range cur.Backends {
+		d.Backends[backend] = deltaBackend(s, last.Backends[backend])
+	}
+	d.LB = deltaValues(cur.LB, last.LB)
+	if cur.Status != nil {
+		var lastStatus StatusSnapshot
+		if last.Status != nil {
+			lastStatus = *last.Status
+		}
+		status := StatusSnapshot{Total: deltaStatus(cur.Status.Total, lastStatus.Total)}
+		if cur.Status.Routes != nil {
+			status.Routes = make(map[string]StatusCounts, len(cur.Status.Routes))
+			for route, c := range cur.Status.Routes {
+				status.Routes[route] = deltaStatus(c, lastStatus.Routes[route])
+			}
+		}
+		d.Status = &status
+	}
+	return d
+}
+
+func delta(cur, last int64) int64 {
+	if cur < last {
+		return cur
+	}
+	return cur - last
+}
+
+func deltaValues[K comparable](cur, last map[K]int64) map[K]int64 {
+	if cur == nil {
+		return nil
+	}
+	d := make(map[K]int64, len(cur))
+	for k, v := range cur {
+		d[k] = delta(v, last[k])
+	}
+	return d
+}
+
+func deltaHistogram(cur, last HistogramSnapshot) HistogramSnapshot {
+	if cur.Count < last.Count || len(cur.Counts) != len(last.Counts) {
+		return cur
+	}
+	d := HistogramSnapshot{Buckets: cur.Buckets, Counts: make([]int64, len(cur.Counts)), Count: cur.Count - last.Count}
+	for i := range cur.Counts {
+		d.Counts[i] = cur.Counts[i] - last.Counts[i]
+	}
+	if d.Count == 0 {
+		return d
+	}
+	d.Sum, d.Min, d.Max = cur.Sum-last.Sum, cur.Min, cur.Max
+	d.P50, d.P90, d.P95, d.P99 = d.Percentile(0.5), d.Percentile(0.9), d.Percentile(0.95), d.Percentile(0.99)
+	return d
+}
+
+func deltaBackend(cur, last BackendStats) BackendStats {
+	if cur.Requests < last.Requests {
+		return cur
+	}
+	d := cur
+	d.Requests = cur.Requests - last.Requests
+	d.Bytes = delta(cur.Bytes, last.Bytes)
+	d.Failures = nil
+	for class, n := range cur.Failures {
+		if n = delta(n, last.Failures[class]); n > 0 {
+			if d.Failures == nil {
+				d.Failures = map[string]int64{}
+			}
+			d.Failures[class] = n
+		}
+	}
+	d.Latency = deltaHistogram(cur.Latency, last.Latency)
+	d.LatencyAvg = Duration(d.Latency.Average() * float64(time.Millisecond))
+	d.LatencyP99 = Duration(d.Latency.PercentileDuration(0.99))
+	return d
+}
+
+func deltaStatus(cur, last StatusCounts) StatusCounts {
+	d := StatusCounts{Classes: deltaValues(cur.Classes, last.Classes)}
+	if cur.Codes != nil {
+		d.Codes = deltaValues(cur.Codes, last.Codes)
+	}
+	return d
+}
@@ -0,0 +1,56 @@
+// 把网关指标发布到 expvar，导入本包时 expvar 会在 http.DefaultServeMux 上注册 /debug/vars，
+// 不需要的程序不导入即可
+package expvars
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"expvar"
+	"net/http"
+	"sync"
+)
+
+// 完整指标快照(metrics.GetSnapshot)的变量名
+const SnapshotVar = "gateway.snapshot"
+
+var once sync.Once
+
+// 发布全局指标：SnapshotVar 为完整快照，gateway.counters、gateway.gauges 为所有计数器与瞬时值，
+// 另外每个已注册的计数器、瞬时值以指标名单独发布(如负载均衡选择次数 gateway_lb_selections_total)，
+// 值为 标签值 -> 数值 的对象，读取时直接取各指标的快照，不另外保存一份。
+// 可以重复调用，已被其他代码发布的同名变量跳过
+func Publish() {
+	once.Do(func() {
+		publish(SnapshotVar, func() any { return metrics.GetSnapshot() })
+		publish("gateway.counters", func() any {
+			values := map[string]map[string]int64{}
+			for _, c := range metrics.CounterVecs() {
+				values[c.Name] = c.Snapshot()
+			}
+			return values
+		})
+		publish("gateway.gauges", func() any {
+			values := map[string]map[string]int64{}
+			for _, g := range metrics.GaugeVecs() {
+				values[g.Name] = g.Snapshot()
+			}
+			return values
+		})
+		for _, c := range metrics.CounterVecs() {
+			publish(c.Name, func() any { return c.Snapshot() })
+		}
+		for _, g := range metrics.GaugeVecs() {
+			publish(g.Name, func() any { return g.Snapshot() })
+		}
+	})
+}
+
+func publish(name string, f func() any) {
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(f))
+	}
+}
+
+// expvar 的处理器，用于挂载到 http.DefaultServeMux 之外的地址，如管理接口
+func Handler() http.Handler {
+	return expvar.Handler()
+}
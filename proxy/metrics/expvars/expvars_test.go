@@ -0,0 +1,56 @@
+package expvars
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPublish(t *testing.T) {
+	selections := metrics.NewCounterVec("test_expvar_selections_total", "", "backend")
+	inflight := metrics.NewGaugeVec("test_expvar_inflight", "", "backend")
+	latency := metrics.NewHistogramVec("test_expvar_latency_ms", "", "route", metrics.LatencyBuckets)
+	//已被其他代码发布的同名变量不会导致 panic
+	expvar.NewInt("gateway.gauges")
+	Publish()
+	Publish()
+
+	selections.Add("http://a", 3)
+	selections.Inc("http://b")
+	inflight.Inc("http://a")
+	latency.Observe("api", 12)
+	metrics.RecordUpstreamResult("http://expvar:1", 200, 5*time.Millisecond, 10, nil)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars struct {
+		Snapshot   metrics.MetricsSnapshot     `json:"gateway.snapshot"`
+		Counters   map[string]map[string]int64 `json:"gateway.counters"`
+		Selections map[string]int64            `json:"test_expvar_selections_total"`
+		Inflight   map[string]int64            `json:"test_expvar_inflight"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	want := metrics.GetSnapshot()
+	if !reflect.DeepEqual(vars.Selections, map[string]int64{"http://a": 3, "http://b": 1}) ||
+		!reflect.DeepEqual(vars.Selections, want.Counters["test_expvar_selections_total"]) {
+		t.Fatalf("selections %v", vars.Selections)
+	}
+	if !reflect.DeepEqual(vars.Inflight, want.Gauges["test_expvar_inflight"]) || vars.Inflight["http://a"] != 1 {
+		t.Fatalf("inflight %v", vars.Inflight)
+	}
+	if !reflect.DeepEqual(vars.Counters, want.Counters) || !reflect.DeepEqual(vars.Snapshot.Counters, want.Counters) {
+		t.Fatalf("counters %v", vars.Counters)
+	}
+	if got := vars.Snapshot.Histograms["test_expvar_latency_ms"]["api"]; got.Count != 1 || got.Sum != 12 {
+		t.Fatalf("histogram %+v", got)
+	}
+	if got := vars.Snapshot.Backends["http://expvar:1"]; got.Requests != 1 || got.Bytes != 10 {
+		t.Fatalf("backend %+v", got)
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 带一个标签维度的瞬时值，如按后端统计的进行中请求数
+type GaugeVec struct {
+	Name  string
+	Help  string
+	Label string
+
+	mux    sync.RWMutex
+	values map[string]*int64
+}
+
+var gaugeVecs = map[string]*GaugeVec{}
+
+// 创建并注册，同名重复注册时返回已存在的实例
+func NewGaugeVec(name, help, label string) *GaugeVec {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if g, ok := gaugeVecs[name]; ok {
+		return g
+	}
+	g := &GaugeVec{Name: name, Help: help, Label: label, values: map[string]*int64{}}
+	gaugeVecs[name] = g
+	return g
+}
+
+func GaugeVecs() []*GaugeVec {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+	list := make([]*GaugeVec, 0, len(gaugeVecs))
+	for _, g := range gaugeVecs {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (g *GaugeVec) value(labelValue string) *int64 {
+	g.mux.RLock()
+	v, ok := g.values[labelValue]
+	g.mux.RUnlock()
+	if ok {
+		return v
+	}
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if v, ok = g.values[labelValue]; !ok {
+		v = new(int64)
+		g.values[labelValue] = v
+	}
+	return v
+}
+
+func (g *GaugeVec) Inc(labelValue string) {
+	atomic.AddInt64(g.value(labelValue), 1)
+}
+
+func (g *GaugeVec) Dec(labelValue string) {
+	atomic.AddInt64(g.value(labelValue), -1)
+}
+
+func (g *GaugeVec) Set(labelValue string, v int64) {
+	atomic.StoreInt64(g.value(labelValue), v)
+}
+
+func (g *GaugeVec) Get(labelValue string) int64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	if v, ok := g.values[labelValue]; ok {
+		return atomic.LoadInt64(v)
+	}
+	return 0
+}
+
+func (g *GaugeVec) Snapshot() map[string]int64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	snapshot := make(map[string]int64, len(g.values))
+	for k, v := range g.values {
+		snapshot[k] = atomic.LoadInt64(v)
+	}
+	return snapshot
+}
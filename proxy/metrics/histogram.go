@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 字节数的默认分桶：256B 到 16MB
+var SizeBuckets = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// 毫秒耗时的默认分桶：1ms 到 10s
+var LatencyBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// 从 start 开始、每个上界是前一个的 factor 倍的 count 个分桶，如 ExponentialBuckets(1, 2, 15) 为 1ms 到约 16s
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// 带一个标签维度的直方图，Buckets 为各桶的上界(升序)。
+// 记录时只对所在的桶做原子加一，标签值已存在时不加写锁，适合在每个请求上调用
+type HistogramVec struct {
+	Name    string
+	Help    string
+	Label   string
+	Buckets []float64
+
+	mux    sync.RWMutex
+	values map[string]*histogram
+}
+
+// 64 位字段在前，保证 32 位平台上原子操作的对齐
+type histogram struct {
+	sum    uint64 //float64 的位表示，以下同
+	min    uint64
+	max    uint64
+	counts []int64 //落在各桶中的数量，最后一个为超过所有上界的数量
+}
+
+// 某个标签值的直方图快照，Counts 为累计值，与 Buckets 一一对应，Count 包含超出最大上界的观测。
+// Min、Max 为实际观测到的极值，分位数按桶内线性插值估算，误差不超过所在桶的宽度
+type HistogramSnapshot struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []int64   `json:"counts"`
+	Count   int64     `json:"count"`
+	Sum     float64   `json:"sum"`
+	Min     float64   `json:"min"`
+	Max     float64   `json:"max"`
+	P50     float64   `json:"p50"`
+	P90     float64   `json:"p90"`
+	P95     float64   `json:"p95"`
+	P99     float64   `json:"p99"`
+}
+
+var histogramVecs = map[string]*HistogramVec{}
+
+// 创建并注册，同名重复注册时返回已存在的实例
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if h, ok := histogramVecs[name]; ok {
+		return h
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{Name: name, Help: help, Label: label, Buckets: buckets, values: map[string]*histogram{}}
+	histogramVecs[name] = h
+	return h
+}
+
+func HistogramVecs() []*HistogramVec {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+	list := make([]*HistogramVec, 0, len(histogramVecs))
+	for _, h := range histogramVecs {
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// 新增标签值，需在不持有锁时调用
+func (h *HistogramVec) create(labelValue string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if _, ok := h.values[labelValue]; !ok {
+		h.values[labelValue] = newHistogram(len(h.Buckets))
+	}
+}
+
+func newHistogram(buckets int) *histogram {
+	return &histogram{counts: make([]int64, buckets+1), min: math.Float64bits(math.Inf(1)), max: math.Float64bits(math.Inf(-1))}
+}
+
+func (h *HistogramVec) Observe(labelValue string, v float64) {
+	for {
+		h.mux.RLock()
+		if hist, ok := h.values[labelValue]; ok {
+			hist.observe(h.Buckets, v)
+			h.mux.RUnlock()
+			break
+		}
+		h.mux.RUnlock()
+		h.create(labelValue)
+	}
+	callObserveHooks(h.Name, h.Label, labelValue, v)
+}
+
+// 直方图观测的回调，用于把同一次观测同时交给其他指标系统，如 OpenTelemetry
+type ObserveHook func(name, label, labelValue string, v float64)
+
+type observeHook struct {
+	f ObserveHook
+}
+
+var (
+	observeHooksMux sync.Mutex
+	observeHooks    atomic.Pointer[[]*observeHook] //写时复制，没有回调时记录只多一次原子读
+)
+
+// 注册观测回调，返回取消注册的函数
+func AddObserveHook(f ObserveHook) (remove func()) {
+	hook := &observeHook{f: f}
+	observeHooksMux.Lock()
+	defer observeHooksMux.Unlock()
+	var hooks []*observeHook
+	if old := observeHooks.Load(); old != nil {
+		hooks = append(hooks, *old...)
+	}
+	hooks = append(hooks, hook)
+	observeHooks.Store(&hooks)
+	return func() {
+		observeHooksMux.Lock()
+		defer observeHooksMux.Unlock()
+		var hooks []*observeHook
+		for _, h := range *observeHooks.Load() {
+			if h != hook {
+				hooks = append(hooks, h)
+			}
+		}
+		if len(hooks) == 0 {
+			observeHooks.Store(nil)
+			return
+		}
+		observeHooks.Store(&hooks)
+	}
+}
+
+func callObserveHooks(name, label, labelValue string, v float64) {
+	if hooks := observeHooks.Load(); hooks != nil {
+		for _, h := range *hooks {
+			h.f(name, label, labelValue, v)
+		}
+	}
+}
+
+func (hist *histogram) observe(buckets []float64, v float64) {
+	//先更新极值再计数，快照读到计数时极值已经生效
+	addFloat(&hist.sum, v)
+	for old := atomic.LoadUint64(&hist.min); v < math.Float64frombits(old); old = atomic.LoadUint64(&hist.min) {
+		if atomic.CompareAndSwapUint64(&hist.min, old, math.Float64bits(v)) {
+			break
+		}
+	}
+	for old := atomic.LoadUint64(&hist.max); v > math.Float64frombits(old); old = atomic.LoadUint64(&hist.max) {
+		if atomic.CompareAndSwapUint64(&hist.max, old, math.Float64bits(v)) {
+			break
+		}
+	}
+	atomic.AddInt64(&hist.counts[sort.SearchFloat64s(buckets, v)], 1)
+}
+
+// 按毫秒记录耗时，用于单位为毫秒的直方图
+func (h *HistogramVec) ObserveDuration(labelValue string, d time.Duration) {
+	h.Observe(labelValue, float64(d)/float64(time.Millisecond))
+}
+
+func addFloat(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (h *HistogramVec) Get(labelValue string) HistogramSnapshot {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return h.values[labelValue].snapshot(h.Buckets)
+}
+
+func (h *HistogramVec) Snapshot() map[string]HistogramSnapshot {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	snapshot := make(map[string]HistogramSnapshot, len(h.values))
+	for k, v := range h.values {
+		snapshot[k] = v.snapshot(h.Buckets)
+	}
+	return snapshot
+}
+
+// 与记录并发时各桶分别读取，Count 取各桶之和，保证与 Counts 一致
+func (hist *histogram) snapshot(buckets []float64) HistogramSnapshot {
+	s := HistogramSnapshot{Buckets: buckets, Counts: make([]int64, len(buckets))}
+	if hist == nil {
+		return s
+	}
+	var cumulative int64
+	for i := range buckets {
+		cumulative += atomic.LoadInt64(&hist.counts[i])
+		s.Counts[i] = cumulative
+	}
+	s.Count = cumulative + atomic.LoadInt64(&hist.counts[len(buckets)])
+	if s.Count == 0 {
+		return s
+	}
+	s.Sum = math.Float64frombits(atomic.LoadUint64(&hist.sum))
+	s.Min = math.Float64frombits(atomic.LoadUint64(&hist.min))
+	s.Max = math.Float64frombits(atomic.LoadUint64(&hist.max))
+	s.P50, s.P90, s.P95, s.P99 = s.Percentile(0.5), s.Percentile(0.9), s.Percentile(0.95), s.Percentile(0.99)
+	return s
+}
+
+func (s HistogramSnapshot) Average() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// p 分位数(0-1)的估算值：找到累计数达到 p 的桶，在桶的上下界之间按桶内位置线性插值，
+// 结果限制在 [Min, Max] 内；落在超出最大上界的桶时返回 Max。没有观测时返回 0
+func (s HistogramSnapshot) Percentile(p float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := p * float64(s.Count)
+	i := sort.Search(len(s.Counts), func(i int) bool { return float64(s.Counts[i]) >= rank })
+	if i == len(s.Counts) {
+		return s.Max
+	}
+	lower, below := s.Min, int64(0)
+	if i > 0 {
+		lower, below = math.Max(s.Buckets[i-1], s.Min), s.Counts[i-1]
+	}
+	upper := math.Min(s.Buckets[i], s.Max)
+	inBucket := s.Counts[i] - below
+	if inBucket == 0 || upper <= lower {
+		return math.Max(math.Min(upper, s.Max), s.Min)
+	}
+	return lower + (upper-lower)*(rank-float64(below))/float64(inBucket)
+}
+
+// 单位为毫秒的直方图的分位数
+func (s HistogramSnapshot) PercentileDuration(p float64) time.Duration {
+	return time.Duration(s.Percentile(p) * float64(time.Millisecond))
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 估算值应落在真实分位数所在的桶内
+func assertInBucket(t *testing.T, s HistogramSnapshot, p, want float64) {
+	t.Helper()
+	got := s.Percentile(p)
+	i := sort.SearchFloat64s(s.Buckets, want)
+	lower, upper := 0.0, math.Inf(1)
+	if i > 0 {
+		lower = s.Buckets[i-1]
+	}
+	if i < len(s.Buckets) {
+		upper = s.Buckets[i]
+	}
+	if got < lower || got > upper {
+		t.Errorf("p%v got %v want %v in (%v, %v]", p*100, got, want, lower, upper)
+	}
+}
+
+func TestHistogramPercentileUniform(t *testing.T) {
+	h := NewHistogramVec("test_uniform_ms", "", "route", ExponentialBuckets(1, 2, 12))
+	for v := 1; v <= 1000; v++ {
+		h.Observe("api", float64(v))
+	}
+	s := h.Get("api")
+	if s.Count != 1000 || s.Min != 1 || s.Max != 1000 || s.Average() != 500.5 {
+		t.Fatalf("count %d min %v max %v avg %v", s.Count, s.Min, s.Max, s.Average())
+	}
+	for _, p := range []float64{0.5, 0.9, 0.95, 0.99} {
+		assertInBucket(t, s, p, p*1000)
+	}
+	if s.P99 != s.Percentile(0.99) || s.P50 > s.P90 || s.P90 > s.P95 || s.P95 > s.P99 {
+		t.Fatalf("percentiles %v %v %v %v", s.P50, s.P90, s.P95, s.P99)
+	}
+}
+
+func TestHistogramPercentileTail(t *testing.T) {
+	h := NewHistogramVec("test_tail_ms", "", "route", LatencyBuckets)
+	//90% 的请求 8ms，10% 的请求 900ms，平均值掩盖了长尾
+	for i := 0; i < 900; i++ {
+		h.ObserveDuration("api", 8*time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		h.ObserveDuration("api", 900*time.Millisecond)
+	}
+	s := h.Get("api")
+	assertInBucket(t, s, 0.5, 8)
+	assertInBucket(t, s, 0.95, 900)
+	assertInBucket(t, s, 0.99, 900)
+	if s.P99 > s.Max || s.PercentileDuration(0.99) < 500*time.Millisecond {
+		t.Fatalf("p99 %v max %v", s.P99, s.Max)
+	}
+
+	//超出最大上界时返回 Max
+	h.Observe("slow", 60000)
+	if got := h.Get("slow").Percentile(0.5); got != 60000 {
+		t.Fatalf("overflow p50 %v", got)
+	}
+	if got := h.Get("none").Percentile(0.5); got != 0 {
+		t.Fatalf("empty p50 %v", got)
+	}
+}
+
+func TestHistogramConcurrentObserve(t *testing.T) {
+	h := NewHistogramVec("test_concurrent_ms", "", "route", LatencyBuckets)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				h.Observe("api", float64(g*1000+i))
+				h.Get("api")
+			}
+		}(g)
+	}
+	wg.Wait()
+	s := h.Get("api")
+	if s.Count != 8000 || s.Min != 0 || s.Max != 7999 || s.Sum != 7999*8000/2 {
+		t.Fatalf("count %d min %v max %v sum %v", s.Count, s.Min, s.Max, s.Sum)
+	}
+}
+
+// 改为原子计数之前的实现，作为基准对比
+type mutexHistogram struct {
+	buckets []float64
+	mux     sync.Mutex
+	values  map[string]*mutexHistogramValue
+}
+
+type mutexHistogramValue struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func (h *mutexHistogram) Observe(labelValue string, v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	hist, ok := h.values[labelValue]
+	if !ok {
+		hist = &mutexHistogramValue{counts: make([]int64, len(h.buckets)+1)}
+		h.values[labelValue] = hist
+	}
+	hist.counts[i]++
+	hist.count++
+	hist.sum += v
+}
+
+func BenchmarkHistogramObserve(b *testing.B) {
+	h := NewHistogramVec("bench_observe_ms", "", "route", LatencyBuckets)
+	b.RunParallel(func(pb *testing.PB) {
+		v := 0.0
+		for pb.Next() {
+			h.Observe("api", v)
+			v = math.Mod(v+7, 3000)
+		}
+	})
+}
+
+func BenchmarkMutexHistogramObserve(b *testing.B) {
+	h := &mutexHistogram{buckets: LatencyBuckets, values: map[string]*mutexHistogramValue{}}
+	b.RunParallel(func(pb *testing.PB) {
+		v := 0.0
+		for pb.Next() {
+			h.Observe("api", v)
+			v = math.Mod(v+7, 3000)
+		}
+	})
+}
+
+func BenchmarkWindowObserve(b *testing.B) {
+	w := NewWindow(time.Minute, 1000)
+	b.RunParallel(func(pb *testing.PB) {
+		v := 0.0
+		for pb.Next() {
+			w.Observe(v)
+			v = math.Mod(v+7, 3000)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import "strings"
+
+// 多标签指标的标签值分隔符。注册时 label 为逗号分隔的标签名，如 "experiment,variant"，
+// 记录时按相同顺序用分隔符连接标签值，如 "checkout/b"，见 JoinLabelValues。
+// 快照与 expvar 中使用连接后的值，Prometheus、StatsD、OpenTelemetry 分别输出各个标签
+const LabelValueSeparator = "/"
+
+func JoinLabelValues(values ...string) string {
+	return strings.Join(values, LabelValueSeparator)
+}
+
+// 把标签名与连接后的标签值拆成一一对应的两组，单标签的值原样返回，不会按分隔符拆分
+func LabelPairs(label, value string) (names, values []string) {
+	if label == "" {
+		return nil, nil
+	}
+	names = strings.Split(label, ",")
+	if len(names) == 1 {
+		return names, []string{value}
+	}
+	values = strings.SplitN(value, LabelValueSeparator, len(names))
+	for len(values) < len(names) {
+		values = append(values, "")
+	}
+	return names, values
+}
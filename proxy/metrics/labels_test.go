@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLabelPairs(t *testing.T) {
+	cases := []struct {
+		label, value  string
+		names, values []string
+	}{
+		{"", "x", nil, nil},
+		{"route", "/api/v1", []string{"route"}, []string{"/api/v1"}},
+		{"experiment,variant", "checkout/b", []string{"experiment", "variant"}, []string{"checkout", "b"}},
+		{"experiment,variant", "checkout", []string{"experiment", "variant"}, []string{"checkout", ""}},
+	}
+	for _, c := range cases {
+		names, values := LabelPairs(c.label, c.value)
+		if !reflect.DeepEqual(names, c.names) || !reflect.DeepEqual(values, c.values) {
+			t.Fatalf("%q %q: got %v %v", c.label, c.value, names, values)
+		}
+	}
+}
+
+func TestPrometheusMultiLabel(t *testing.T) {
+	c := NewCounterVec("test_multi_label_total", "", "experiment,variant")
+	c.Inc(JoinLabelValues("checkout", "b"))
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := `test_multi_label_total{experiment="checkout",variant="b"} 1`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("missing %s", want)
+	}
+}
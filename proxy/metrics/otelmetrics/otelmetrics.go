@@ -0,0 +1,210 @@
+// 把网关指标以 OpenTelemetry 指标的形式推送到 OTLP，与 Prometheus 导出共用同一份记录：
+// 计数器、瞬时值与后端统计在采集时读取，直方图在每次观测时同时记录到 OpenTelemetry 的直方图
+package otelmetrics
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const meterName = "GO_GATEWAY/proxy/metrics"
+
+const DefaultInterval = time.Minute
+
+type Options struct {
+	Enabled     bool
+	Endpoint    string            //OTLP/HTTP 地址，如 127.0.0.1:4318
+	Insecure    bool              //使用 http 而不是 https 连接 OTLP
+	Headers     map[string]string //导出请求附加的请求头，如认证信息
+	Interval    time.Duration     //推送间隔，默认 DefaultInterval
+	ServiceName string            //默认 go_gateway
+	InstanceID  string            //service.instance.id，默认主机名
+
+	//指定后忽略上面的导出配置，测试中可注入带 ManualReader 的 MeterProvider
+	MeterProvider metric.MeterProvider
+}
+
+// 开始推送，返回的函数停止推送并在推送最后一次后关闭导出。
+// 未开启时什么都不注册，记录指标时只多一次原子读
+func Start(opts Options) (shutdown func(context.Context) error, err error) {
+	if !opts.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	provider := opts.MeterProvider
+	var providerShutdown func(context.Context) error
+	if provider == nil {
+		sdkProvider, err := newProvider(opts)
+		if err != nil {
+			return nil, err
+		}
+		provider, providerShutdown = sdkProvider, sdkProvider.Shutdown
+	}
+	b := &bridge{meter: provider.Meter(meterName)}
+	registration, err := b.registerObservables()
+	if err != nil {
+		return nil, err
+	}
+	removeHook := metrics.AddObserveHook(b.observe)
+	return func(ctx context.Context) error {
+		removeHook()
+		err := registration.Unregister()
+		if providerShutdown != nil {
+			err = errors.Join(err, providerShutdown(ctx))
+		}
+		return err
+	}, nil
+}
+
+func newProvider(opts Options) (*sdkmetric.MeterProvider, error) {
+	clientOpts := []otlpmetrichttp.Option{}
+	if opts.Endpoint != "" {
+		clientOpts = append(clientOpts, otlpmetrichttp.WithEndpoint(opts.Endpoint))
+	}
+	if opts.Insecure {
+		clientOpts = append(clientOpts, otlpmetrichttp.WithInsecure())
+	}
+	if len(opts.Headers) > 0 {
+		clientOpts = append(clientOpts, otlpmetrichttp.WithHeaders(opts.Headers))
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), clientOpts...)
+	if err != nil {
+		return nil, err
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = "go_gateway"
+	}
+	instanceID := opts.InstanceID
+	if instanceID == "" {
+		instanceID, _ = os.Hostname()
+	}
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName), semconv.ServiceInstanceID(instanceID))),
+	), nil
+}
+
+type bridge struct {
+	meter metric.Meter
+
+	mux        sync.RWMutex
+	histograms map[string]metric.Float64Histogram
+}
+
+type observableCounter struct {
+	vec  *metrics.CounterVec
+	inst metric.Int64ObservableCounter
+}
+
+type observableGauge struct {
+	vec  *metrics.GaugeVec
+	inst metric.Int64ObservableGauge
+}
+
+// 为 Start 时已注册的计数器、瞬时值与后端统计创建异步指标，采集时读取各自的快照
+func (b *bridge) registerObservables() (metric.Registration, error) {
+	var counters []observableCounter
+	var gauges []observableGauge
+	var instruments []metric.Observable
+	for _, c := range metrics.CounterVecs() {
+		inst, err := b.meter.Int64ObservableCounter(c.Name, metric.WithDescription(c.Help))
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, observableCounter{vec: c, inst: inst})
+		instruments = append(instruments, inst)
+	}
+	for _, g := range metrics.GaugeVecs() {
+		inst, err := b.meter.Int64ObservableGauge(g.Name, metric.WithDescription(g.Help))
+		if err != nil {
+			return nil, err
+		}
+		gauges = append(gauges, observableGauge{vec: g, inst: inst})
+		instruments = append(instruments, inst)
+	}
+	backendRequests, err := b.meter.Int64ObservableCounter("gateway_backend_requests_total", metric.WithDescription("上游请求数，重试的每次尝试分别计数"))
+	if err != nil {
+		return nil, err
+	}
+	backendFailures, err := b.meter.Int64ObservableCounter("gateway_backend_failures_total", metric.WithDescription("上游请求失败数，按失败类别区分"))
+	if err != nil {
+		return nil, err
+	}
+	backendBytes, err := b.meter.Int64ObservableCounter("gateway_backend_response_bytes_total", metric.WithDescription("读取到的上游响应体字节数"), metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+	instruments = append(instruments, backendRequests, backendFailures, backendBytes)
+	return b.meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		for _, c := range counters {
+			for value, n := range c.vec.Snapshot() {
+				o.ObserveInt64(c.inst, n, attributes(c.vec.Label, value))
+			}
+		}
+		for _, g := range gauges {
+			for value, n := range g.vec.Snapshot() {
+				o.ObserveInt64(g.inst, n, attributes(g.vec.Label, value))
+			}
+		}
+		for backend, stats := range metrics.Backends.Snapshot() {
+			attrs := attributes("backend", backend)
+			o.ObserveInt64(backendRequests, stats.Requests, attrs)
+			o.ObserveInt64(backendBytes, stats.Bytes, attrs)
+			for class, n := range stats.Failures {
+				o.ObserveInt64(backendFailures, n, metric.WithAttributes(attribute.String("backend", backend), attribute.String("class", class)))
+			}
+		}
+		return nil
+	}, instruments...)
+}
+
+func attributes(label, value string) metric.MeasurementOption {
+	names, values := metrics.LabelPairs(label, value)
+	attrs := make([]attribute.KeyValue, len(names))
+	for i, name := range names {
+		attrs[i] = attribute.String(name, values[i])
+	}
+	return metric.WithAttributes(attrs...)
+}
+
+// 直方图的每次观测，第一次观测某个直方图时创建对应的 OpenTelemetry 直方图
+func (b *bridge) observe(name, label, labelValue string, v float64) {
+	b.mux.RLock()
+	h, ok := b.histograms[name]
+	b.mux.RUnlock()
+	if !ok {
+		b.mux.Lock()
+		if h, ok = b.histograms[name]; !ok {
+			var opts []metric.Float64HistogramOption
+			if strings.HasSuffix(name, "_ms") {
+				opts = append(opts, metric.WithUnit("ms"))
+			}
+			var err error
+			if h, err = b.meter.Float64Histogram(name, opts...); err != nil {
+				b.mux.Unlock()
+				return
+			}
+			if b.histograms == nil {
+				b.histograms = map[string]metric.Float64Histogram{}
+			}
+			b.histograms[name] = h
+		}
+		b.mux.Unlock()
+	}
+	h.Record(context.Background(), v, attributes(label, labelValue))
+}
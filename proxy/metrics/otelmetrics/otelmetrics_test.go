@@ -0,0 +1,120 @@
+package otelmetrics
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"bytes"
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			result[m.Name] = m.Data
+		}
+	}
+	return result
+}
+
+func sumValue(data metricdata.Aggregation, key, value string) (int64, bool) {
+	var points []metricdata.DataPoint[int64]
+	switch d := data.(type) {
+	case metricdata.Sum[int64]:
+		points = d.DataPoints
+	case metricdata.Gauge[int64]:
+		points = d.DataPoints
+	}
+	for _, p := range points {
+		if v, ok := p.Attributes.Value(attribute.Key(key)); ok && v.AsString() == value {
+			return p.Value, true
+		}
+	}
+	return 0, false
+}
+
+func TestOTelMetrics(t *testing.T) {
+	selections := metrics.NewCounterVec("test_otel_selections_total", "", "backend")
+	inflight := metrics.NewGaugeVec("test_otel_inflight", "", "backend")
+	latency := metrics.NewHistogramVec("test_otel_latency_ms", "", "route", metrics.LatencyBuckets)
+
+	reader := sdkmetric.NewManualReader()
+	shutdown, err := Start(Options{Enabled: true, MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	selections.Add("http://a", 3)
+	inflight.Set("http://a", 2)
+	latency.Observe("api", 12)
+	latency.Observe("api", 30)
+	metrics.RecordUpstreamResult("http://otel:1", 502, 4*time.Millisecond, 7, nil)
+
+	data := collect(t, reader)
+	if v, ok := sumValue(data["test_otel_selections_total"], "backend", "http://a"); !ok || v != 3 {
+		t.Fatalf("selections %v %v", v, ok)
+	}
+	if v, _ := sumValue(data["test_otel_inflight"], "backend", "http://a"); v != 2 {
+		t.Fatalf("inflight %v", v)
+	}
+	if v, _ := sumValue(data["gateway_backend_requests_total"], "backend", "http://otel:1"); v != 1 {
+		t.Fatalf("backend requests %v", v)
+	}
+	if v, _ := sumValue(data["gateway_backend_failures_total"], "class", "5xx"); v != 1 {
+		t.Fatalf("backend failures %v", v)
+	}
+	h, ok := data["test_otel_latency_ms"].(metricdata.Histogram[float64])
+	if !ok || len(h.DataPoints) != 1 || h.DataPoints[0].Count != 2 || h.DataPoints[0].Sum != 42 {
+		t.Fatalf("histogram %+v", data["test_otel_latency_ms"])
+	}
+	if _, ok := data[metrics.BackendLatencyMetric].(metricdata.Histogram[float64]); !ok {
+		t.Fatalf("backend latency missing")
+	}
+
+	//Prometheus 导出读取的是同一份记录
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `test_otel_selections_total{backend="http://a"} 3`) ||
+		!strings.Contains(buf.String(), `test_otel_latency_seconds_count{route="api"} 2`) {
+		t.Fatal("prometheus output differs")
+	}
+
+	//停止后不再记录
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	latency.Observe("api", 1)
+	data = collect(t, reader)
+	if h, ok := data["test_otel_latency_ms"].(metricdata.Histogram[float64]); ok && h.DataPoints[0].Count != 2 {
+		t.Fatalf("recorded after shutdown %+v", h)
+	}
+}
+
+func TestOTelMetricsDisabled(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	shutdown, err := Start(Options{MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+	metrics.NewHistogramVec("test_otel_disabled_ms", "", "route", metrics.LatencyBuckets).Observe("api", 1)
+	if data := collect(t, reader); len(data) != 0 {
+		t.Fatalf("disabled exporter recorded %v", data)
+	}
+}
+
+func BenchmarkObserveDisabled(b *testing.B) {
+	h := metrics.NewHistogramVec("bench_otel_disabled_ms", "", "route", metrics.LatencyBuckets)
+	for i := 0; i < b.N; i++ {
+		h.Observe("api", 12)
+	}
+}
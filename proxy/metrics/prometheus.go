@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"bufio"
+	"cmp"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Prometheus 文本格式的 Content-Type
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 按 Prometheus 文本格式(0.0.4)输出所有已注册的指标，指标按名称、标签值排序。
+// 标签名沿用注册时的名称；内部以毫秒记录的耗时(名称以 _ms 或 _ms_total 结尾)按 Prometheus 的惯例换算为秒，
+// 名称后缀相应改为 _seconds/_seconds_total，如 gateway_request_duration_ms 输出为 gateway_request_duration_seconds，
+// 其余指标名不变。快照 JSON 与 StatsD 仍使用毫秒与注册时的名称。
+// 只读取各指标的快照，不做聚合，适合每几秒抓取一次
+func WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range CounterVecs() {
+		name, scale := prometheusName(c.Name)
+		writeHeader(bw, name, c.Help, "counter")
+		writeValues(bw, name, c.Label, c.Snapshot(), scale)
+	}
+	for _, g := range GaugeVecs() {
+		name, scale := prometheusName(g.Name)
+		writeHeader(bw, name, g.Help, "gauge")
+		writeValues(bw, name, g.Label, g.Snapshot(), scale)
+	}
+	for _, h := range HistogramVecs() {
+		name, scale := prometheusName(h.Name)
+		writeHeader(bw, name, h.Help, "histogram")
+		snapshot := h.Snapshot()
+		for _, value := range sortedKeys(snapshot) {
+			writeHistogram(bw, name, h.Label, value, snapshot[value], scale)
+		}
+	}
+	writeBackendStats(bw, Backends.Snapshot())
+	writeStatusCodes(bw, StatusCodes.Snapshot())
+	return bw.Flush()
+}
+
+// 状态码统计(见 RecordStatus)：全局的类别计数即 gateway_requests_total，这里只输出单独统计的状态码与按路由名的序列，
+// code 标签只出现在 TrackedStatusCodes 中的状态码上
+func writeStatusCodes(w *bufio.Writer, s StatusSnapshot) {
+	writeHeader(w, "gateway_responses_by_code_total", "单独统计的状态码的响应数", "counter")
+	for _, code := range sortedKeys(s.Total.Codes) {
+		w.WriteString("gateway_responses_by_code_total" + labels("code", strconv.Itoa(code), "") + " " + strconv.FormatInt(s.Total.Codes[code], 10) + "\n")
+	}
+	routes := sortedKeys(s.Routes)
+	writeHeader(w, "gateway_route_responses_total", "按路由名与状态码类别统计的响应数", "counter")
+	for _, route := range routes {
+		classes := s.Routes[route].Classes
+		for _, class := range sortedKeys(classes) {
+			w.WriteString("gateway_route_responses_total{route=\"" + escapeLabel(route) + "\",status_class=\"" + class + "\"} " +
+				strconv.FormatInt(classes[class], 10) + "\n")
+		}
+	}
+	writeHeader(w, "gateway_route_responses_by_code_total", "按路由名统计的单独统计的状态码的响应数", "counter")
+	for _, route := range routes {
+		codes := s.Routes[route].Codes
+		for _, code := range sortedKeys(codes) {
+			w.WriteString("gateway_route_responses_by_code_total{route=\"" + escapeLabel(route) + "\",code=\"" + strconv.Itoa(code) + "\"} " +
+				strconv.FormatInt(codes[code], 10) + "\n")
+		}
+	}
+}
+
+// 全局后端统计(见 RecordUpstreamResult)的各后端序列，失败数额外带 class 标签
+func writeBackendStats(w *bufio.Writer, stats map[string]BackendStats) {
+	backends := sortedKeys(stats)
+	writeHeader(w, "gateway_backend_requests_total", "上游请求数，重试的每次尝试分别计数", "counter")
+	for _, backend := range backends {
+		w.WriteString("gateway_backend_requests_total" + labels("backend", backend, "") + " " + strconv.FormatInt(stats[backend].Requests, 10) + "\n")
+	}
+	writeHeader(w, "gateway_backend_failures_total", "上游请求失败数，按失败类别区分", "counter")
+	for _, backend := range backends {
+		failures := stats[backend].Failures
+		for _, class := range sortedKeys(failures) {
+			w.WriteString("gateway_backend_failures_total{backend=\"" + escapeLabel(backend) + "\",class=\"" + escapeLabel(class) + "\"} " +
+				strconv.FormatInt(failures[class], 10) + "\n")
+		}
+	}
+	writeHeader(w, "gateway_backend_response_bytes_total", "读取到的上游响应体字节数", "counter")
+	for _, backend := range backends {
+		w.WriteString("gateway_backend_response_bytes_total" + labels("backend", backend, "") + " " + strconv.FormatInt(stats[backend].Bytes, 10) + "\n")
+	}
+	latency, scale := prometheusName(BackendLatencyMetric)
+	writeHeader(w, latency, "单次上游请求从发起到响应体读完的耗时", "histogram")
+	for _, backend := range backends {
+		writeHistogram(w, latency, "backend", backend, stats[backend].Latency, scale)
+	}
+}
+
+// 输出所有指标的 http 处理器，挂载在管理接口的 GET /metrics 上
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", PrometheusContentType)
+		WritePrometheus(w)
+	})
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// 输出的指标名与取值的换算比例：毫秒记录的耗时换算为秒，其余原样输出(比例为1)
+func prometheusName(name string) (string, float64) {
+	switch {
+	case strings.HasSuffix(name, "_ms"):
+		return strings.TrimSuffix(name, "_ms") + "_seconds", 1000
+	case strings.HasSuffix(name, "_ms_total"):
+		return strings.TrimSuffix(name, "_ms_total") + "_seconds_total", 1000
+	}
+	return name, 1
+}
+
+func writeValues(w *bufio.Writer, name, label string, values map[string]int64, scale float64) {
+	for _, value := range sortedKeys(values) {
+		v := strconv.FormatInt(values[value], 10)
+		if scale != 1 {
+			v = formatFloat(float64(values[value]) / scale)
+		}
+		w.WriteString(name + labels(label, value, "") + " " + v + "\n")
+	}
+}
+
+func writeHistogram(w *bufio.Writer, name, label, value string, s HistogramSnapshot, scale float64) {
+	for i, bound := range s.Buckets {
+		w.WriteString(name + "_bucket" + labels(label, value, formatFloat(bound/scale)) + " " + strconv.FormatInt(s.Counts[i], 10) + "\n")
+	}
+	w.WriteString(name + "_bucket" + labels(label, value, "+Inf") + " " + strconv.FormatInt(s.Count, 10) + "\n")
+	w.WriteString(name + "_sum" + labels(label, value, "") + " " + formatFloat(s.Sum/scale) + "\n")
+	w.WriteString(name + "_count" + labels(label, value, "") + " " + strconv.FormatInt(s.Count, 10) + "\n")
+}
+
+// 没有标签维度(label 为空)的指标只有 le 标签或不带标签，多标签指标见 LabelPairs
+func labels(label, value, le string) string {
+	var pairs []string
+	names, values := LabelPairs(label, value)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusSecondsUnits(t *testing.T) {
+	NewHistogramVec("test_prom_duration_ms", "", "route", []float64{10, 100}).Observe("api", 50)
+	NewCounterVec("test_prom_wait_ms_total", "", "").Add("", 1500)
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE test_prom_duration_seconds histogram",
+		`test_prom_duration_seconds_bucket{route="api",le="0.01"} 0`,
+		`test_prom_duration_seconds_bucket{route="api",le="0.1"} 1`,
+		`test_prom_duration_seconds_sum{route="api"} 0.05`,
+		"test_prom_wait_seconds_total 1.5",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	if strings.Contains(buf.String(), "test_prom_duration_ms") {
+		t.Fatal("millisecond name exported")
+	}
+}
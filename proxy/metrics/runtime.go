@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultRuntimeInterval = 10 * time.Second
+
+var (
+	goroutines   = NewGaugeVec("gateway_goroutines", "协程数", "")
+	heapAlloc    = NewGaugeVec("gateway_heap_alloc_bytes", "堆上已分配且未释放的字节数", "")
+	heapObjects  = NewGaugeVec("gateway_heap_objects", "堆上已分配且未释放的对象数", "")
+	gcPauseTotal = NewGaugeVec("gateway_gc_pause_total_ns", "进程启动以来 GC 暂停的总时间(纳秒)", "")
+	gcCycles     = NewCounterVec("gateway_gc_cycles_total", "完成的 GC 次数", "")
+
+	//运行中的采集器数量与最近一次采集结果，有采集器时快照直接使用采集结果
+	runtimeCollectors int32
+	latestRuntime     atomic.Pointer[runtimeSample]
+
+	//每个监听端口各有一个采集器，GC 次数的基线由所有采集器共享，增量只累加一次
+	runtimeMux sync.Mutex
+	lastGC     uint32
+	gcBaseline bool
+)
+
+// ReadMemStats 会暂停所有协程，没有采集器时快照在该时间内复用上一次的读取结果
+const runtimeReadInterval = time.Second
+
+type runtimeSample struct {
+	stats RuntimeStats
+	at    time.Time
+}
+
+// 按固定间隔读取运行时状态并更新协程数、堆、GC 相关的指标，
+// 指标随其他已注册指标一起导出(Prometheus、expvar、StatsD 等)，快照的 runtime 段使用最近一次的采集结果
+type RuntimeCollector struct {
+	interval time.Duration
+
+	mux       sync.Mutex
+	started   bool
+	closed    bool
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// interval 不大于 0 时使用 DefaultRuntimeInterval
+func NewRuntimeCollector(interval time.Duration) *RuntimeCollector {
+	if interval <= 0 {
+		interval = DefaultRuntimeInterval
+	}
+	//GC 次数只累加第一个采集器创建之后的增量
+	runtimeMux.Lock()
+	if !gcBaseline {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		lastGC, gcBaseline = m.NumGC, true
+	}
+	runtimeMux.Unlock()
+	return &RuntimeCollector{interval: interval, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// 立即采集一次后启动后台采集，Close 时停止，已经 Close 或重复调用时什么都不做
+func (c *RuntimeCollector) Start() {
+	c.mux.Lock()
+	if c.started || c.closed {
+		c.mux.Unlock()
+		return
+	}
+	c.started = true
+	c.mux.Unlock()
+	atomic.AddInt32(&runtimeCollectors, 1)
+	c.Collect()
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.Collect()
+			}
+		}
+	}()
+}
+
+// 停止后台采集并等待采集协程退出，可以重复调用
+func (c *RuntimeCollector) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.mux.Lock()
+		started := c.started
+		c.closed = true
+		c.mux.Unlock()
+		if started {
+			<-c.done
+			atomic.AddInt32(&runtimeCollectors, -1)
+		}
+	})
+}
+
+// 立即采集一次，多个采集器同一时间只有一次采集
+func (c *RuntimeCollector) Collect() {
+	runtimeMux.Lock()
+	defer runtimeMux.Unlock()
+	stats := readRuntime()
+	goroutines.Set("", int64(stats.Goroutines))
+	heapAlloc.Set("", int64(stats.HeapAlloc))
+	heapObjects.Set("", int64(stats.HeapObjects))
+	gcPauseTotal.Set("", int64(stats.GCPauseTotal))
+	//NumGC 为 uint32，回绕后差值仍然正确
+	gcCycles.Add("", int64(stats.GCCycles-lastGC))
+	lastGC = stats.GCCycles
+	latestRuntime.Store(&runtimeSample{stats: *stats, at: time.Now()})
+}
+
+// 有采集器运行时返回最近一次的采集结果；否则上一次读取不超过 runtimeReadInterval 时复用，
+// 避免频繁的 /stats、expvar 请求反复暂停进程
+func currentRuntime() *RuntimeStats {
+	if sample := latestRuntime.Load(); sample != nil &&
+		(atomic.LoadInt32(&runtimeCollectors) > 0 || time.Since(sample.at) < runtimeReadInterval) {
+		copied := sample.stats
+		return &copied
+	}
+	runtimeMux.Lock()
+	defer runtimeMux.Unlock()
+	//等锁期间其他请求可能已经读取过
+	if sample := latestRuntime.Load(); sample != nil && time.Since(sample.at) < runtimeReadInterval {
+		copied := sample.stats
+		return &copied
+	}
+	stats := readRuntime()
+	latestRuntime.Store(&runtimeSample{stats: *stats, at: time.Now()})
+	return stats
+}
+
+func readRuntime() *RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return &RuntimeStats{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapObjects:  m.HeapObjects,
+		GCCycles:     m.NumGC,
+		GCPauseTotal: Duration(m.PauseTotalNs),
+	}
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var sink [][]byte
+
+func TestRuntimeCollector(t *testing.T) {
+	c := NewRuntimeCollector(time.Hour)
+	runtime.GC()
+	c.Collect()
+	objects, cycles := heapObjects.Get(""), gcCycles.Get("")
+	for i := 0; i < 10000; i++ {
+		sink = append(sink, make([]byte, 64))
+	}
+	c.Collect()
+	if heapObjects.Get("") <= objects {
+		t.Errorf("heap objects %d, before %d", heapObjects.Get(""), objects)
+	}
+	sink = nil
+	runtime.GC()
+	runtime.GC()
+	c.Collect()
+	if got := gcCycles.Get("") - cycles; got < 2 {
+		t.Errorf("gc cycles delta %d", got)
+	}
+	if goroutines.Get("") <= 0 || heapAlloc.Get("") <= 0 || gcPauseTotal.Get("") <= 0 {
+		t.Errorf("goroutines %d heap %d pause %d", goroutines.Get(""), heapAlloc.Get(""), gcPauseTotal.Get(""))
+	}
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"gateway_goroutines ", "gateway_heap_alloc_bytes ", "gateway_gc_cycles_total "} {
+		if !strings.Contains(buf.String(), "\n"+name) {
+			t.Errorf("%s missing", name)
+		}
+	}
+	//重复采集不重复累加 GC 次数
+	n := gcCycles.Get("")
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	gc := m.NumGC
+	c.Collect()
+	runtime.ReadMemStats(&m)
+	if got := gcCycles.Get("") - n; got > int64(m.NumGC-gc) {
+		t.Errorf("gc cycles counted twice: %d", got)
+	}
+}
+
+func TestRuntimeCollectorSnapshot(t *testing.T) {
+	c := NewRuntimeCollector(10 * time.Millisecond)
+	c.Start()
+	defer c.Close()
+	runtime.GC()
+	deadline := time.Now().Add(time.Second)
+	for {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if GetSnapshot().Runtime.GCCycles >= m.NumGC {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot runtime stats not updated")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRuntimeCollectorClose(t *testing.T) {
+	c := NewRuntimeCollector(time.Millisecond)
+	c.Start()
+	c.Close()
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("collector goroutine still running after Close")
+	}
+	c.Close()
+	//Close 后快照不再使用停止的采集器的结果
+	if n := atomic.LoadInt32(&runtimeCollectors); n != 0 {
+		t.Fatalf("%d collectors running", n)
+	}
+
+	//未启动时 Close 不阻塞，之后 Start 不再启动
+	idle := NewRuntimeCollector(time.Millisecond)
+	idle.Close()
+	idle.Start()
+	if idle.started {
+		t.Fatal("started after Close")
+	}
+}
+
+// 每个监听端口各有一个采集器，GC 次数只累加一次
+func TestRuntimeCollectorsShareGCBaseline(t *testing.T) {
+	a, b := NewRuntimeCollector(time.Hour), NewRuntimeCollector(time.Hour)
+	a.Collect()
+	b.Collect()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	before, gc := gcCycles.Get(""), m.NumGC
+	runtime.GC()
+	runtime.GC()
+	a.Collect()
+	b.Collect()
+	runtime.ReadMemStats(&m)
+	if got := gcCycles.Get("") - before; got > int64(m.NumGC-gc) || got < 2 {
+		t.Fatalf("gc cycles delta %d, actual %d", got, m.NumGC-gc)
+	}
+}
+
+// 没有采集器时短时间内的快照复用同一次读取
+func TestCurrentRuntimeCached(t *testing.T) {
+	latestRuntime.Store(nil)
+	first := currentRuntime()
+	runtime.GC()
+	if second := currentRuntime(); second.GCCycles != first.GCCycles {
+		t.Fatalf("runtime read again within %s", runtimeReadInterval)
+	}
+	latestRuntime.Store(&runtimeSample{stats: *first, at: time.Now().Add(-runtimeReadInterval)})
+	if third := currentRuntime(); third.GCCycles == first.GCCycles {
+		t.Fatal("stale runtime stats reused")
+	}
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 负载均衡选中后端次数的计数器名，快照中单独作为 lb 段输出
+const LBSelectionsMetric = "gateway_lb_selections_total"
+
+// 快照中可以单独选择的段，time 与 uptime 总是输出
+var SnapshotSections = []string{"counters", "gauges", "histograms", "backends", "lb", "status", "runtime"}
+
+var startTime = time.Now()
+
+// 所有已注册指标在某一时刻的副本，按 指标名 -> 标签值 组织，与底层统计不共享任何切片或 map。
+// JSON 字段名是对外的接口，不随意修改；未选择的段不输出
+type MetricsSnapshot struct {
+	Time       time.Time                               `json:"time"`
+	Uptime     Duration                                `json:"uptime"`
+	Counters   map[string]map[string]int64             `json:"counters,omitempty"`
+	Gauges     map[string]map[string]int64             `json:"gauges,omitempty"`
+	Histograms map[string]map[string]HistogramSnapshot `json:"histograms,omitempty"`
+	Backends   map[string]BackendStats                 `json:"backends,omitempty"` //全局后端统计，见 RecordUpstreamResult
+	LB         map[string]int64                        `json:"lb,omitempty"`       //按后端的负载均衡选中次数
+	Status     *StatusSnapshot                         `json:"status,omitempty"`   //全局与按路由名的状态码统计
+	Runtime    *RuntimeStats                           `json:"runtime,omitempty"`
+}
+
+// 进程运行时状态，运行了 RuntimeCollector 时为其最近一次的采集结果，否则为不超过一秒前的读取结果
+type RuntimeStats struct {
+	Goroutines   int      `json:"goroutines"`
+	HeapAlloc    uint64   `json:"heap_alloc"`
+	HeapObjects  uint64   `json:"heap_objects"`
+	GCCycles     uint32   `json:"gc_cycles"`
+	GCPauseTotal Duration `json:"gc_pause_total"`
+}
+
+// 输出 JSON 时同时给出纳秒数与可读的字符串，如 {"ns":1500000,"human":"1.5ms"}
+type Duration time.Duration
+
+type jsonDuration struct {
+	Ns    int64  `json:"ns"`
+	Human string `json:"human"`
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonDuration{Ns: int64(d), Human: time.Duration(d).String()})
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v jsonDuration
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*d = Duration(v.Ns)
+	return nil
+}
+
+// 所有指标当前的累计值，按区间读取增量见 DeltaReader
+func GetSnapshot() MetricsSnapshot {
+	now := time.Now()
+	s := MetricsSnapshot{
+		Time:       now,
+		Uptime:     Duration(now.Sub(startTime)),
+		Counters:   map[string]map[string]int64{},
+		Gauges:     map[string]map[string]int64{},
+		Histograms: map[string]map[string]HistogramSnapshot{},
+		Runtime:    currentRuntime(),
+	}
+	for _, c := range CounterVecs() {
+		s.Counters[c.Name] = c.Snapshot()
+	}
+	for _, g := range GaugeVecs() {
+		s.Gauges[g.Name] = g.Snapshot()
+	}
+	for _, h := range HistogramVecs() {
+		s.Histograms[h.Name] = h.Snapshot()
+	}
+	s.Backends = Backends.Snapshot()
+	status := StatusCodes.Snapshot()
+	s.Status = &status
+	s.LB = map[string]int64{}
+	for backend, n := range s.Counters[LBSelectionsMetric] {
+		s.LB[backend] = n
+	}
+	return s
+}
+
+// 只保留指定的段(见 SnapshotSections)，sections 为空时保留全部，有未知的段时返回错误
+func (s MetricsSnapshot) Filter(sections ...string) (MetricsSnapshot, error) {
+	if len(sections) == 0 {
+		return s, nil
+	}
+	selected := map[string]bool{}
+	for _, section := range sections {
+		if !contains(SnapshotSections, section) {
+			return s, fmt.Errorf("unknown section %q, valid sections: %s", section, strings.Join(SnapshotSections, ","))
+		}
+		selected[section] = true
+	}
+	filtered := MetricsSnapshot{Time: s.Time, Uptime: s.Uptime}
+	if selected["counters"] {
+		filtered.Counters = s.Counters
+	}
+	if selected["gauges"] {
+		filtered.Gauges = s.Gauges
+	}
+	if selected["histograms"] {
+		filtered.Histograms = s.Histograms
+	}
+	if selected["backends"] {
+		filtered.Backends = s.Backends
+	}
+	if selected["lb"] {
+		filtered.LB = s.LB
+	}
+	if selected["status"] {
+		filtered.Status = s.Status
+	}
+	if selected["runtime"] {
+		filtered.Runtime = s.Runtime
+	}
+	return filtered, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 并发记录时反复按区间读取增量，所有增量之和等于记录的总数，全局指标仍为累计值
+func TestDeltaReaderConcurrent(t *testing.T) {
+	c := NewCounterVec("test_reset_requests_total", "", "route")
+	h := NewHistogramVec("test_reset_latency_ms", "", "route", LatencyBuckets)
+	const writers, ops = 4, 2000
+	var wg sync.WaitGroup
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				c.Inc("api")
+				h.Observe("api", 3)
+				RecordUpstreamResult("http://reset:1", 200, time.Millisecond, 1, nil)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var reader DeltaReader
+	var requests, observations, upstream int64
+	read := func() {
+		s := reader.Read()
+		requests += s.Counters["test_reset_requests_total"]["api"]
+		observations += s.Histograms["test_reset_latency_ms"]["api"].Count
+		upstream += s.Backends["http://reset:1"].Requests
+	}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			read()
+		}
+	}
+	read()
+	if requests != writers*ops || observations != writers*ops || upstream != writers*ops {
+		t.Fatalf("requests %d observations %d upstream %d, want %d", requests, observations, upstream, writers*ops)
+	}
+	if c.Get("api") != writers*ops || h.Get("api").Count != writers*ops {
+		t.Fatal("global metrics reset by delta reader")
+	}
+	//读取者之间互不影响
+	var other DeltaReader
+	if s := other.Read(); s.Counters["test_reset_requests_total"]["api"] != writers*ops {
+		t.Fatalf("second reader got %d", s.Counters["test_reset_requests_total"]["api"])
+	}
+	if s := reader.Read(); s.Counters["test_reset_requests_total"]["api"] != 0 || s.Histograms["test_reset_latency_ms"]["api"].Count != 0 {
+		t.Fatal("no new observations but non-zero delta")
+	}
+}
+
+func TestDeltaHistogram(t *testing.T) {
+	h := NewHistogramVec("test_delta_ms", "", "", []float64{10, 100})
+	var reader DeltaReader
+	h.Observe("", 5)
+	h.Observe("", 50)
+	reader.Read()
+	h.Observe("", 50)
+	h.Observe("", 500)
+	d := reader.Read().Histograms["test_delta_ms"][""]
+	if d.Count != 2 || d.Sum != 550 || d.Counts[0] != 0 || d.Counts[1] != 1 || d.Max != 500 {
+		t.Fatalf("delta %+v", d)
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	NewCounterVec("test_json_total", "", "route").Inc("api")
+	s, err := GetSnapshot().Filter("counters", "runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(s)
+	var decoded MetricsSnapshot
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Counters["test_json_total"]["api"] != 1 || decoded.Runtime == nil || decoded.Uptime != s.Uptime || decoded.Gauges != nil {
+		t.Fatalf("decoded %+v", decoded)
+	}
+	if _, err := GetSnapshot().Filter("paths"); err == nil {
+		t.Fatal("unknown section accepted")
+	}
+}
@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultStatsDInterval   = 10 * time.Second
+	DefaultStatsDPacketSize = 1432 //以太网 MTU 下不分片的 UDP 负载
+)
+
+var statsdErrors = NewCounterVec("gateway_statsd_errors_total", "StatsD 发送失败的包数", "")
+
+// StatsD/DogStatsD 输出配置
+type StatsDConf struct {
+	Addr          string        //UDP 地址，如 127.0.0.1:8125
+	Interval      time.Duration //发送间隔，默认 DefaultStatsDInterval
+	Prefix        string        //指标名前缀，如 "gateway."
+	Tags          []string      //附加在每条指标上的 DogStatsD 标签，如 env:prod
+	MaxPacketSize int           //单个 UDP 包的最大字节数，默认 DefaultStatsDPacketSize
+}
+
+// 按固定间隔把已注册的指标以 StatsD 文本协议发送到 UDP 地址，指标的标签维度作为 DogStatsD 标签(如 backend、route、status_class)：
+// 计数器发送两次发送之间的增量，瞬时值发送当前值，直方图按桶发送增量，
+// 每个有新增观测的桶只发送一行，以桶的中点为值、采样率为 1/增量数，包数不随请求量增长。
+// 发送在后台协程中进行，失败只计数，不影响请求处理
+type StatsD struct {
+	conf StatsDConf
+	conn net.Conn
+
+	mux        sync.Mutex //同一时间只有一次发送
+	counters   map[string]int64
+	histograms map[string][]int64 //各桶(非累计)的数量
+
+	started   bool
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewStatsD(conf StatsDConf) (*StatsD, error) {
+	if conf.Addr == "" {
+		return nil, errors.New("statsd: empty addr")
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = DefaultStatsDInterval
+	}
+	if conf.MaxPacketSize <= 0 {
+		conf.MaxPacketSize = DefaultStatsDPacketSize
+	}
+	conn, err := net.Dial("udp", conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{conf: conf, conn: conn, counters: map[string]int64{}, histograms: map[string][]int64{},
+		stop: make(chan struct{}), done: make(chan struct{})}, nil
+}
+
+// 启动后台发送，Close 时停止
+func (s *StatsD) Start() {
+	s.mux.Lock()
+	s.started = true
+	s.mux.Unlock()
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.conf.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.Flush()
+			}
+		}
+	}()
+}
+
+// 停止后台发送，最后发送一次后关闭连接，可以重复调用
+func (s *StatsD) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.mux.Lock()
+		started := s.started
+		s.mux.Unlock()
+		if started {
+			<-s.done
+		}
+		s.Flush()
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// 立即发送一次
+func (s *StatsD) Flush() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	p := &statsdPacket{s: s}
+	for _, c := range CounterVecs() {
+		values := c.Snapshot()
+		for _, label := range sortedKeys(values) {
+			s.writeCounter(p, c.Name, values[label], s.tags(c.Label, label))
+		}
+	}
+	for _, g := range GaugeVecs() {
+		values := g.Snapshot()
+		for _, label := range sortedKeys(values) {
+			p.add(s.line(g.Name, strconv.FormatInt(values[label], 10), "g", 1, s.tags(g.Label, label)))
+		}
+	}
+	for _, h := range HistogramVecs() {
+		values := h.Snapshot()
+		for _, label := range sortedKeys(values) {
+			s.writeHistogram(p, h.Name, values[label], s.tags(h.Label, label))
+		}
+	}
+	backends := Backends.Snapshot()
+	for _, backend := range sortedKeys(backends) {
+		stats := backends[backend]
+		tags := s.tags("backend", backend)
+		s.writeCounter(p, "gateway_backend_requests_total", stats.Requests, tags)
+		s.writeCounter(p, "gateway_backend_response_bytes_total", stats.Bytes, tags)
+		for _, class := range sortedKeys(stats.Failures) {
+			s.writeCounter(p, "gateway_backend_failures_total", stats.Failures[class], append(tags, "class:"+statsdTag(class)))
+		}
+		s.writeHistogram(p, BackendLatencyMetric, stats.Latency, tags)
+	}
+	p.flush()
+}
+
+// 发送与上次的差值，后端统计被淘汰后重新出现时当前值即为增量
+func (s *StatsD) writeCounter(p *statsdPacket, name string, value int64, tags []string) {
+	key := name + "|" + strings.Join(tags, ",")
+	delta := value - s.counters[key]
+	if delta < 0 {
+		delta = value
+	}
+	s.counters[key] = value
+	if delta > 0 {
+		p.add(s.line(name, strconv.FormatInt(delta, 10), "c", 1, tags))
+	}
+}
+
+func (s *StatsD) writeHistogram(p *statsdPacket, name string, h HistogramSnapshot, tags []string) {
+	key := name + "|" + strings.Join(tags, ",")
+	counts := make([]int64, len(h.Buckets)+1)
+	var below int64
+	for i, cumulative := range h.Counts {
+		counts[i] = cumulative - below
+		below = cumulative
+	}
+	counts[len(h.Buckets)] = h.Count - below
+	last := s.histograms[key]
+	s.histograms[key] = counts
+	typ := "h"
+	if strings.HasSuffix(name, "_ms") {
+		typ = "ms"
+	}
+	for i, n := range counts {
+		if i < len(last) && last[i] <= n {
+			n -= last[i]
+		}
+		if n <= 0 {
+			continue
+		}
+		var v float64
+		switch {
+		case i == len(h.Buckets):
+			v = h.Max
+		case i == 0:
+			v = h.Buckets[0] / 2
+		default:
+			v = (h.Buckets[i-1] + h.Buckets[i]) / 2
+		}
+		p.add(s.line(name, strconv.FormatFloat(v, 'g', -1, 64), typ, 1/float64(n), tags))
+	}
+}
+
+// 一行 StatsD 指标：<name>:<value>|<type>[|@<rate>][|#<tags>]
+func (s *StatsD) line(name, value, typ string, rate float64, tags []string) []byte {
+	var b bytes.Buffer
+	b.WriteString(s.conf.Prefix + name + ":" + value + "|" + typ)
+	if rate < 1 {
+		b.WriteString("|@" + strconv.FormatFloat(rate, 'g', 6, 64))
+	}
+	if len(tags) > 0 {
+		b.WriteString("|#" + strings.Join(tags, ","))
+	}
+	return b.Bytes()
+}
+
+func (s *StatsD) tags(label, value string) []string {
+	tags := append([]string(nil), s.conf.Tags...)
+	names, values := LabelPairs(label, value)
+	for i, name := range names {
+		tags = append(tags, statsdTag(name)+":"+statsdTag(values[i]))
+	}
+	return tags
+}
+
+// 标签中不能出现协议的分隔符
+var statsdTagEscaper = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+func statsdTag(s string) string {
+	return statsdTagEscaper.Replace(s)
+}
+
+// 按 MaxPacketSize 把多行合并成一个 UDP 包，超过上限的单行单独发送
+type statsdPacket struct {
+	s   *StatsD
+	buf []byte
+}
+
+func (p *statsdPacket) add(line []byte) {
+	if len(p.buf) > 0 && len(p.buf)+1+len(line) > p.s.conf.MaxPacketSize {
+		p.flush()
+	}
+	if len(p.buf) > 0 {
+		p.buf = append(p.buf, '\n')
+	}
+	p.buf = append(p.buf, line...)
+}
+
+func (p *statsdPacket) flush() {
+	if len(p.buf) == 0 {
+		return
+	}
+	//UDP 写不等待对端，失败(如端口不可达)只计数
+	p.s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := p.s.conn.Write(p.buf); err != nil {
+		statsdErrors.Inc("")
+	}
+	p.buf = p.buf[:0]
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 读取一次发送的所有行
+func readStatsD(t *testing.T, conn net.PacketConn, maxPacket int) []string {
+	t.Helper()
+	var lines []string
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return lines
+		}
+		if n > maxPacket && strings.Contains(string(buf[:n]), "\n") {
+			t.Fatalf("packet of %d bytes exceeds %d", n, maxPacket)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func hasLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStatsDFlush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := NewStatsD(StatsDConf{Addr: conn.LocalAddr().String(), Prefix: "gw.", Tags: []string{"env:test"}, MaxPacketSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	requests := NewCounterVec("test_statsd_requests_total", "", "status_class")
+	inflight := NewGaugeVec("test_statsd_inflight", "", "route")
+	latency := NewHistogramVec("test_statsd_latency_ms", "", "route", []float64{10, 100})
+	requests.Add("2xx", 5)
+	inflight.Set("api", 2)
+	latency.Observe("api", 4)
+	latency.Observe("api", 6)
+	latency.Observe("api", 50)
+	RecordUpstreamResult("http://statsd:1", 502, time.Millisecond, 0, nil)
+	s.Flush()
+	lines := readStatsD(t, conn, 512)
+	for _, want := range []string{
+		"gw.test_statsd_requests_total:5|c|#env:test,status_class:2xx",
+		"gw.test_statsd_inflight:2|g|#env:test,route:api",
+		"gw.test_statsd_latency_ms:5|ms|@0.5|#env:test,route:api",
+		"gw.test_statsd_latency_ms:55|ms|#env:test,route:api",
+		"gw.gateway_backend_requests_total:1|c|#env:test,backend:http://statsd:1",
+		"gw.gateway_backend_failures_total:1|c|#env:test,backend:http://statsd:1,class:5xx",
+	} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+
+	//第二次只发送增量，没有变化的计数器与直方图不发送
+	requests.Add("2xx", 3)
+	latency.Observe("api", 500)
+	s.Flush()
+	lines = readStatsD(t, conn, 512)
+	if !hasLine(lines, "gw.test_statsd_requests_total:3|c|#env:test,status_class:2xx") ||
+		!hasLine(lines, "gw.test_statsd_latency_ms:500|ms|#env:test,route:api") ||
+		!hasLine(lines, "gw.test_statsd_inflight:2|g|#env:test,route:api") {
+		t.Fatalf("second flush %v", lines)
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "gw.test_statsd_latency_ms:5|") || strings.HasPrefix(line, "gw.gateway_backend_requests_total:") && strings.Contains(line, "statsd:1") {
+			t.Fatalf("unchanged series sent again: %s", line)
+		}
+	}
+}
+
+func TestStatsDUnreachable(t *testing.T) {
+	conn, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	s, err := NewStatsD(StatsDConf{Addr: addr, Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//对端不可达时发送失败只计数，不阻塞
+	s.Start()
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close blocked")
+	}
+	if statsdErrors.Get("") == 0 {
+		t.Fatal("send errors not counted")
+	}
+}
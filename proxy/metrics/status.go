@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 单独统计的状态码，其他状态码只计入所属类别，使 code 标签的取值有上限。
+// 创建统计后修改不影响已有的路由
+var TrackedStatusCodes = []int{401, 404, 429, 502, 503, 504}
+
+// 全局与按路由名的响应状态码统计：按类别(1xx-5xx)计数，TrackedStatusCodes 中的状态码另外单独计数
+type StatusCodeMap struct {
+	mux    sync.RWMutex
+	total  *statusCounts
+	routes map[string]*statusCounts
+}
+
+type statusCounts struct {
+	classes [5]int64       //1xx-5xx
+	codes   map[int]*int64 //创建时填入 TrackedStatusCodes，之后只读
+}
+
+// 一组状态码统计的快照，只包含不为 0 的类别与状态码
+type StatusCounts struct {
+	Classes map[string]int64 `json:"classes"` //如 "2xx"
+	Codes   map[int]int64    `json:"codes,omitempty"`
+}
+
+type StatusSnapshot struct {
+	Total  StatusCounts            `json:"total"`
+	Routes map[string]StatusCounts `json:"routes,omitempty"`
+}
+
+// 网关使用的全局状态码统计，由 gateway.RequestMetrics 记录
+var StatusCodes = NewStatusCodeMap()
+
+func NewStatusCodeMap() *StatusCodeMap {
+	return &StatusCodeMap{total: newStatusCounts(), routes: map[string]*statusCounts{}}
+}
+
+func newStatusCounts() *statusCounts {
+	c := &statusCounts{codes: make(map[int]*int64, len(TrackedStatusCodes))}
+	for _, code := range TrackedStatusCodes {
+		c.codes[code] = new(int64)
+	}
+	return c
+}
+
+// 在全局状态码统计中记录一次响应
+func RecordStatus(route string, status int) {
+	StatusCodes.Record(route, status)
+}
+
+// 记录一次响应，route 为空时只计入全局统计，状态码不在 100-599 之间时忽略
+func (m *StatusCodeMap) Record(route string, status int) {
+	if status < 100 || status > 599 {
+		return
+	}
+	for {
+		m.mux.RLock()
+		c, ok := m.routes[route]
+		if route == "" || ok {
+			m.total.add(status)
+			if ok {
+				c.add(status)
+			}
+			m.mux.RUnlock()
+			return
+		}
+		m.mux.RUnlock()
+		m.mux.Lock()
+		if _, ok := m.routes[route]; !ok {
+			m.routes[route] = newStatusCounts()
+		}
+		m.mux.Unlock()
+	}
+}
+
+func (c *statusCounts) add(status int) {
+	atomic.AddInt64(&c.classes[status/100-1], 1)
+	if n, ok := c.codes[status]; ok {
+		atomic.AddInt64(n, 1)
+	}
+}
+
+func (c *statusCounts) snapshot() StatusCounts {
+	s := StatusCounts{Classes: map[string]int64{}}
+	for i := range c.classes {
+		if n := atomic.LoadInt64(&c.classes[i]); n > 0 {
+			s.Classes[strconv.Itoa(i+1)+"xx"] = n
+		}
+	}
+	for code, p := range c.codes {
+		if n := atomic.LoadInt64(p); n > 0 {
+			if s.Codes == nil {
+				s.Codes = map[int]int64{}
+			}
+			s.Codes[code] = n
+		}
+	}
+	return s
+}
+
+func (m *StatusCodeMap) Snapshot() StatusSnapshot {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	s := StatusSnapshot{Total: m.total.snapshot()}
+	if len(m.routes) > 0 {
+		s.Routes = make(map[string]StatusCounts, len(m.routes))
+		for route, c := range m.routes {
+			s.Routes[route] = c.snapshot()
+		}
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatusCodeMap(t *testing.T) {
+	m := NewStatusCodeMap()
+	for _, status := range []int{200, 204, 301, 401, 404, 404, 418, 429, 500, 502, 503, 504, 0, 600} {
+		m.Record("api", status)
+	}
+	m.Record("", 200)
+	s := m.Snapshot()
+	wantClasses := map[string]int64{"2xx": 2, "3xx": 1, "4xx": 5, "5xx": 4}
+	wantCodes := map[int]int64{401: 1, 404: 2, 429: 1, 502: 1, 503: 1, 504: 1}
+	if got := s.Routes["api"]; !reflect.DeepEqual(got.Classes, wantClasses) || !reflect.DeepEqual(got.Codes, wantCodes) {
+		t.Fatalf("api %+v", got)
+	}
+	wantClasses["2xx"]++
+	if !reflect.DeepEqual(s.Total.Classes, wantClasses) || !reflect.DeepEqual(s.Total.Codes, wantCodes) {
+		t.Fatalf("total %+v", s.Total)
+	}
+	if len(s.Routes) != 1 {
+		t.Fatalf("routes %v", s.Routes)
+	}
+
+}
+
+func TestStatusCodesPrometheus(t *testing.T) {
+	RecordStatus("status-prom", 404)
+	RecordStatus("status-prom", 403)
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		`gateway_route_responses_total{route="status-prom",status_class="4xx"} 2`,
+		`gateway_route_responses_by_code_total{route="status-prom",code="404"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	//未单独统计的状态码不出现在 code 标签上
+	if strings.Contains(out, `code="403"`) {
+		t.Error("untracked code exported")
+	}
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 窗口内最多保留的样本数，超过后丢弃最早的样本
+const DefaultWindowMaxSamples = 4096
+
+// 滑动时间窗口内的样本，用于计算最近一段时间的分位数，如响应耗时 p90。
+// 样本存放在容量为 MaxSamples 的环形缓冲中，记录与淘汰都不移动已有样本，平均值按累计和维护
+type Window struct {
+	Size       time.Duration
+	MaxSamples int
+
+	mux  sync.Mutex
+	buf  []windowSample //第一次记录时按 MaxSamples 分配
+	head int            //最早的样本
+	n    int
+	sum  float64
+}
+
+type windowSample struct {
+	at time.Time
+	v  float64
+}
+
+func NewWindow(size time.Duration, maxSamples int) *Window {
+	if maxSamples <= 0 {
+		maxSamples = DefaultWindowMaxSamples
+	}
+	return &Window{Size: size, MaxSamples: maxSamples}
+}
+
+func (w *Window) Observe(v float64) {
+	now := time.Now()
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.buf == nil {
+		w.buf = make([]windowSample, w.MaxSamples)
+	}
+	w.prune(now)
+	if w.n == len(w.buf) {
+		w.dropOldest()
+	}
+	w.buf[(w.head+w.n)%len(w.buf)] = windowSample{at: now, v: v}
+	w.n++
+	w.sum += v
+}
+
+// 窗口内样本的 p 分位数(0-1)，没有样本时 ok 为 false
+func (w *Window) Percentile(p float64) (v float64, ok bool) {
+	w.mux.Lock()
+	w.prune(time.Now())
+	values := make([]float64, w.n)
+	for i := range values {
+		values[i] = w.buf[(w.head+i)%len(w.buf)].v
+	}
+	w.mux.Unlock()
+	if len(values) == 0 {
+		return 0, false
+	}
+	sort.Float64s(values)
+	i := int(p*float64(len(values))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(values) {
+		i = len(values) - 1
+	}
+	return values[i], true
+}
+
+// 窗口内样本的平均值，没有样本时 ok 为 false
+func (w *Window) Average() (v float64, ok bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.prune(time.Now())
+	if w.n == 0 {
+		return 0, false
+	}
+	return w.sum / float64(w.n), true
+}
+
+func (w *Window) Len() int {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.prune(time.Now())
+	return w.n
+}
+
+// 样本按时间顺序记录，从最早的样本开始丢弃窗口之外的样本
+func (w *Window) prune(now time.Time) {
+	for w.n > 0 && now.Sub(w.buf[w.head].at) > w.Size {
+		w.dropOldest()
+	}
+}
+
+func (w *Window) dropOldest() {
+	w.sum -= w.buf[w.head].v
+	w.head = (w.head + 1) % len(w.buf)
+	w.n--
+	if w.n == 0 {
+		//清空时归零，避免浮点误差累积
+		w.head, w.sum = 0, 0
+	}
+}
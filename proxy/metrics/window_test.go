@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWindowEviction(t *testing.T) {
+	w := NewWindow(time.Minute, 4)
+	for v := 1; v <= 10; v++ {
+		w.Observe(float64(v))
+	}
+	//超过容量时丢弃最早的样本，只保留 7~10
+	if avg, ok := w.Average(); !ok || avg != 8.5 || w.Len() != 4 {
+		t.Fatalf("avg %v len %d", avg, w.Len())
+	}
+	if p, _ := w.Percentile(0); p != 7 {
+		t.Fatalf("min %v", p)
+	}
+	if p, _ := w.Percentile(1); p != 10 {
+		t.Fatalf("max %v", p)
+	}
+
+	w = NewWindow(50*time.Millisecond, 0)
+	w.Observe(100)
+	time.Sleep(80 * time.Millisecond)
+	w.Observe(2)
+	w.Observe(4)
+	if avg, _ := w.Average(); avg != 3 || w.Len() != 2 {
+		t.Fatalf("expired sample kept: avg %v len %d", avg, w.Len())
+	}
+	time.Sleep(80 * time.Millisecond)
+	if _, ok := w.Average(); ok {
+		t.Fatal("average of empty window")
+	}
+	if _, ok := w.Percentile(0.5); ok || w.Len() != 0 {
+		t.Fatal("percentile of empty window")
+	}
+	w.Observe(5)
+	if avg, _ := w.Average(); avg != 5 {
+		t.Fatalf("avg after refill %v", avg)
+	}
+}
+
+func TestWindowConcurrent(t *testing.T) {
+	w := NewWindow(time.Minute, 100)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				w.Observe(7)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if avg, ok := w.Average(); ok && avg != 7 {
+					t.Errorf("avg %v", avg)
+					return
+				}
+				w.Percentile(0.9)
+				w.Len()
+			}
+		}()
+	}
+	wg.Wait()
+	if w.Len() != 100 {
+		t.Fatalf("len %d", w.Len())
+	}
+}
+
+// 改为环形缓冲之前的实现，作为基准对比
+type sliceWindow struct {
+	size       time.Duration
+	maxSamples int
+	mux        sync.Mutex
+	samples    []windowSample
+}
+
+func (w *sliceWindow) Observe(v float64) {
+	now := time.Now()
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	i := 0
+	for i < len(w.samples) && now.Sub(w.samples[i].at) > w.size {
+		i++
+	}
+	if i > 0 {
+		w.samples = append(w.samples[:0], w.samples[i:]...)
+	}
+	if len(w.samples) >= w.maxSamples {
+		w.samples = w.samples[1:]
+	}
+	w.samples = append(w.samples, windowSample{at: now, v: v})
+}
+
+func BenchmarkWindowObserveFull(b *testing.B) {
+	w := NewWindow(time.Minute, 1000)
+	for i := 0; i < 1000; i++ {
+		w.Observe(1)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Observe(float64(i))
+	}
+}
+
+func BenchmarkSliceWindowObserveFull(b *testing.B) {
+	w := &sliceWindow{size: time.Minute, maxSamples: 1000}
+	for i := 0; i < 1000; i++ {
+		w.Observe(1)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Observe(float64(i))
+	}
+}
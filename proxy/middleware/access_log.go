@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 访问日志的附加字段，由各中间件在处理过程中写入
+type LogFields struct {
+	mux    sync.Mutex
+	fields map[string]string
+}
+
+func (f *LogFields) Set(key, value string) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.fields[key] = value
+}
+
+func (f *LogFields) Get(key string) string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.fields[key]
+}
+
+func (f *LogFields) String() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	keys := make([]string, 0, len(f.fields))
+	for k := range f.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+f.fields[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// 写入访问日志字段，请求未经过 AccessLog 中间件时忽略
+func SetLogField(req *http.Request, key, value string) {
+	if f := LogFieldsFromContext(req.Context()); f != nil {
+		f.Set(key, value)
+	}
+}
+
+func LogFieldsFromContext(ctx context.Context) *LogFields {
+	f, _ := ctx.Value(logFieldsContextKey).(*LogFields)
+	return f
+}
+
+// 记录状态码与响应字节数
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// 访问日志中间件，应放在最外层，内层中间件通过 SetLogField 追加字段
+type AccessLog struct {
+	Logger *log.Logger //为空时使用 log 包默认输出
+}
+
+func (a *AccessLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if IsDryRun(req.Context()) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		start := time.Now()
+		fields := &LogFields{fields: map[string]string{}}
+		req = req.WithContext(context.WithValue(req.Context(), logFieldsContextKey, fields))
+		rec := &statusRecorder{ResponseWriter: w}
+		//请求被 http.ErrAbortHandler 中断时也记录日志，标记为未完整传输
+		defer func() {
+			if err := recover(); err != nil {
+				if fields.Get("aborted") == "" {
+					fields.Set("aborted", "true")
+				}
+				a.log(req, rec, start, fields)
+				panic(err)
+			}
+		}()
+		next.ServeHTTP(rec, req)
+		a.log(req, rec, start, fields)
+	})
+}
+
+func (a *AccessLog) log(req *http.Request, rec *statusRecorder, start time.Time, fields *LogFields) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	line := []interface{}{ClientIP(req), req.Method, req.URL.RequestURI(), rec.status, rec.bytes, time.Since(start), fields.String()}
+	if a.Logger != nil {
+		a.Logger.Println(line...)
+	} else {
+		log.Println(line...)
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+var aclDenied = metrics.NewCounterVec("gateway_acl_denied_total", "被访问控制拒绝的请求数", "acl")
+
+type ACLAction int
+
+const (
+	ACLAllow ACLAction = iota
+	ACLDeny
+)
+
+func (a ACLAction) String() string {
+	if a == ACLAllow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// 一条规则，CIDR 可以是网段或单个 IP
+type ACLRule struct {
+	Action ACLAction
+	CIDR   string
+}
+
+// 按顺序匹配的 IP 访问控制，第一条命中的规则生效，都不命中时使用默认动作
+// 规则存放在按位的前缀树中，查找代价与规则数量无关
+type ACL struct {
+	Name          string
+	DefaultAction ACLAction
+	v4            *aclNode
+	v6            *aclNode
+	rules         []ACLRule
+}
+
+type aclNode struct {
+	children [2]*aclNode
+	ruleIdx  int //-1 表示该节点没有规则
+}
+
+// IPv4 映射地址段 ::ffff:0:0/96 的起始地址
+var v4InV6Prefix = net.ParseIP("::ffff:0.0.0.0")
+
+func newACLNode() *aclNode {
+	return &aclNode{ruleIdx: -1}
+}
+
+func NewACL(name string, defaultAction ACLAction, rules []ACLRule) (*ACL, error) {
+	a := &ACL{Name: name, DefaultAction: defaultAction, v4: newACLNode(), v6: newACLNode(), rules: rules}
+	for i, rule := range rules {
+		ipNet, err := ParseCIDROrIP(rule.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		ip := ipNet.IP.To16()
+		ones, bits := ipNet.Mask.Size()
+		ip4 := ipNet.IP.To4()
+		switch {
+		case ip4 != nil && bits == 32:
+			a.v4.insert(ip4, ones, i)
+		case ip4 != nil && ones >= 96:
+			//IPv4 映射地址(如 ::ffff:10.0.0.0/104)查找时走 v4 树，规则按去掉前 96 位的 v4 网段存放
+			a.v4.insert(ip4, ones-96, i)
+		default:
+			a.v6.insert(ip, ones, i)
+			//覆盖整个 ::ffff:0:0/96 的更短网段(如 ::/0)同样覆盖所有 v4 地址
+			if v4InV6Prefix.Mask(ipNet.Mask).Equal(ipNet.IP) {
+				a.v4.insert(nil, 0, i)
+			}
+		}
+	}
+	return a, nil
+}
+
+// 在前缀树中插入前 ones 位为 ip 的网段
+func (n *aclNode) insert(ip net.IP, ones, ruleIdx int) {
+	node := n
+	for b := 0; b < ones; b++ {
+		bit := ip[b/8] >> (7 - uint(b%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = newACLNode()
+		}
+		node = node.children[bit]
+	}
+	//同一前缀多条规则时保留最靠前的一条
+	if node.ruleIdx == -1 {
+		node.ruleIdx = ruleIdx
+	}
+}
+
+// 返回命中规则的下标，-1 表示未命中
+func (a *ACL) match(ip net.IP) int {
+	root := a.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		root, ip = a.v4, ip4
+	} else {
+		ip = ip.To16()
+	}
+	best := root.ruleIdx
+	node := root
+	for b := 0; b < len(ip)*8; b++ {
+		node = node.children[ip[b/8]>>(7-uint(b%8))&1]
+		if node == nil {
+			break
+		}
+		if node.ruleIdx != -1 && (best == -1 || node.ruleIdx < best) {
+			best = node.ruleIdx
+		}
+	}
+	return best
+}
+
+// 判断 IP 是否放行，同时返回命中的规则描述
+func (a *ACL) Check(ipStr string) (ACLAction, string) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return a.DefaultAction, "default"
+	}
+	idx := a.match(ip)
+	if idx == -1 {
+		return a.DefaultAction, "default"
+	}
+	return a.rules[idx].Action, "rule" + strconv.Itoa(idx) + ":" + a.rules[idx].CIDR
+}
+
+func (a *ACL) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		action, matched := a.Check(ClientIP(req))
+		SetLogField(req, "acl", a.Name+"/"+action.String()+"/"+matched)
+		if action == ACLDeny {
+			if !IsDryRun(req.Context()) {
+				aclDenied.Inc(a.Name)
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestACLCheck(t *testing.T) {
+	acl, err := NewACL("test", ACLAllow, []ACLRule{
+		{Action: ACLAllow, CIDR: "10.1.2.3"},
+		{Action: ACLDeny, CIDR: "10.0.0.0/8"},
+		{Action: ACLAllow, CIDR: "10.1.0.0/16"}, //被上一条规则覆盖
+		{Action: ACLDeny, CIDR: "2001:db8::/32"},
+		{Action: ACLAllow, CIDR: "2001:db8:1::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ip   string
+		want ACLAction
+	}{
+		{"10.1.2.3", ACLAllow},
+		{"10.1.2.4", ACLDeny},
+		{"10.200.0.1", ACLDeny},
+		{"11.0.0.1", ACLAllow},
+		{"2001:db8::5", ACLDeny},
+		{"2001:db8:1::1", ACLDeny},
+		{"2001:db9::1", ACLAllow},
+		{"bad-ip", ACLAllow},
+	}
+	for _, c := range cases {
+		if got, matched := acl.Check(c.ip); got != c.want {
+			t.Errorf("%s: got %v (%s), want %v", c.ip, got, matched, c.want)
+		}
+	}
+}
+
+func TestACLMappedIPv4Rules(t *testing.T) {
+	acl, err := NewACL("test", ACLAllow, []ACLRule{
+		{Action: ACLDeny, CIDR: "::ffff:10.0.0.0/104"},
+		{Action: ACLDeny, CIDR: "::ffff:192.168.1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]ACLAction{
+		"10.1.2.3":        ACLDeny,
+		"::ffff:10.1.2.3": ACLDeny,
+		"192.168.1.1":     ACLDeny,
+		"192.168.1.2":     ACLAllow,
+		"11.0.0.1":        ACLAllow,
+		"2001:db8::1":     ACLAllow,
+	} {
+		if got, matched := acl.Check(ip); got != want {
+			t.Errorf("%s: got %v (%s), want %v", ip, got, matched, want)
+		}
+	}
+
+	all, err := NewACL("all", ACLAllow, []ACLRule{{Action: ACLDeny, CIDR: "::/0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := all.Check("10.1.2.3"); got != ACLDeny {
+		t.Fatalf("::/0 should cover mapped IPv4 addresses")
+	}
+}
+
+func TestACLDefaultDeny(t *testing.T) {
+	acl, err := NewACL("office", ACLDeny, []ACLRule{{Action: ACLAllow, CIDR: "192.168.0.0/16"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := acl.Handler(okHandler)
+	if rec := doRequest(h, "192.168.3.3:1", nil); rec.Code != http.StatusOK {
+		t.Fatalf("office ip got %d", rec.Code)
+	}
+	before := aclDenied.Get("office")
+	if rec := doRequest(h, "8.8.8.8:1", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("outside ip got %d", rec.Code)
+	}
+	if aclDenied.Get("office") != before+1 {
+		t.Fatal("denied counter not incremented")
+	}
+}
+
+func TestACLManyRules(t *testing.T) {
+	rules := []ACLRule{}
+	for i := 0; i < 5000; i++ {
+		rules = append(rules, ACLRule{Action: ACLDeny, CIDR: fmt.Sprintf("%d.%d.0.0/16", 20+i/256, i%256)})
+	}
+	acl, err := NewACL("scrapers", ACLAllow, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action, _ := acl.Check("39.135.1.1"); action != ACLDeny {
+		t.Fatal("39.135.0.0/16 should be denied")
+	}
+	if action, _ := acl.Check("200.1.1.1"); action != ACLAllow {
+		t.Fatal("200.1.1.1 should be allowed")
+	}
+}
+
+func TestACLInvalidRule(t *testing.T) {
+	if _, err := NewACL("bad", ACLAllow, []ACLRule{{CIDR: "10.0.0.0/33"}}); err == nil {
+		t.Fatal("invalid cidr should fail")
+	}
+}
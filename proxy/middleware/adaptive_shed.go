@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultShedPercentile = 0.9
+	DefaultShedWindow     = 10 * time.Second
+	DefaultShedInterval   = time.Second
+	DefaultShedStep       = 0.1
+	DefaultShedMaxReject  = 0.9
+)
+
+var (
+	shedRejectPermille = metrics.NewGaugeVec("gateway_adaptive_shed_reject_permille", "自适应限流当前的拒绝概率(千分比)", "shedder")
+	shedRejected       = metrics.NewCounterVec("gateway_adaptive_shed_rejected_total", "被自适应限流拒绝的请求数", "shedder")
+)
+
+type AdaptiveShedConf struct {
+	Name          string        //指标中的 shedder 标签
+	TargetLatency time.Duration //目标耗时，窗口内分位数超过该值时开始拒绝
+	Percentile    float64       //观测的耗时分位数，默认 DefaultShedPercentile
+	Window        time.Duration //统计耗时的滑动窗口，默认 DefaultShedWindow
+	Interval      time.Duration //调整拒绝概率的间隔，默认 DefaultShedInterval
+	Step          float64       //每次调整的幅度，默认 DefaultShedStep
+	MinReject     float64       //开始拒绝时的最小概率，恢复时低于该值直接归零
+	MaxReject     float64       //拒绝概率上限，默认 DefaultShedMaxReject
+	ExemptPaths   []string      //不参与统计也不会被拒绝的路径前缀，默认 /healthz
+	RetryAfter    time.Duration //拒绝时的 Retry-After，默认 DefaultRetryAfter
+}
+
+// 自适应限流：按最近一段时间的响应耗时分位数调整拒绝概率。
+// 超过目标耗时时每个周期提高 Step，恢复后每个周期降低 Step，直到为 0。
+// 调整在请求到来时进行，被拒绝的请求不计入耗时，窗口内没有样本视为已恢复
+type AdaptiveShedder struct {
+	conf    AdaptiveShedConf
+	latency *metrics.Window
+
+	mux        sync.Mutex
+	reject     float64
+	lastAdjust time.Time
+}
+
+func NewAdaptiveShedder(conf AdaptiveShedConf) *AdaptiveShedder {
+	if conf.Percentile <= 0 || conf.Percentile > 1 {
+		conf.Percentile = DefaultShedPercentile
+	}
+	if conf.Window <= 0 {
+		conf.Window = DefaultShedWindow
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = DefaultShedInterval
+	}
+	if conf.Step <= 0 {
+		conf.Step = DefaultShedStep
+	}
+	if conf.MaxReject <= 0 || conf.MaxReject > 1 {
+		conf.MaxReject = DefaultShedMaxReject
+	}
+	if conf.MinReject > conf.MaxReject {
+		conf.MinReject = conf.MaxReject
+	}
+	if conf.ExemptPaths == nil {
+		conf.ExemptPaths = []string{"/healthz"}
+	}
+	if conf.RetryAfter < time.Second {
+		conf.RetryAfter = DefaultRetryAfter
+	}
+	return &AdaptiveShedder{conf: conf, latency: metrics.NewWindow(conf.Window, 0), lastAdjust: time.Now()}
+}
+
+// 当前的拒绝概率
+func (s *AdaptiveShedder) RejectProbability() float64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.reject
+}
+
+func (s *AdaptiveShedder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if s.exempt(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if reject := s.adjust(); reject > 0 && rand.Float64() < reject {
+			if !IsDryRun(req.Context()) {
+				shedRejected.Inc(s.conf.Name)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.conf.RetryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if IsDryRun(req.Context()) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		start := time.Now()
+		next.ServeHTTP(w, req)
+		s.latency.Observe(float64(time.Since(start)) / float64(time.Millisecond))
+	})
+}
+
+func (s *AdaptiveShedder) exempt(path string) bool {
+	for _, prefix := range s.conf.ExemptPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 距上次调整超过 Interval 时按窗口内的耗时调整拒绝概率，返回调整后的概率
+func (s *AdaptiveShedder) adjust() float64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if time.Since(s.lastAdjust) < s.conf.Interval {
+		return s.reject
+	}
+	s.lastAdjust = time.Now()
+	p, ok := s.latency.Percentile(s.conf.Percentile)
+	if ok && p > float64(s.conf.TargetLatency)/float64(time.Millisecond) {
+		s.reject = math.Min(math.Max(s.reject+s.conf.Step, s.conf.MinReject), s.conf.MaxReject)
+	} else if s.reject > 0 {
+		s.reject -= s.conf.Step
+		if s.reject < s.conf.MinReject || s.reject < 1e-9 {
+			s.reject = 0
+		}
+	}
+	shedRejectPermille.Set(s.conf.Name, int64(s.reject*1000+0.5))
+	return s.reject
+}
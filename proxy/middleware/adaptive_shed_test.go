@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveShedderRampsAndRecovers(t *testing.T) {
+	var delay int64 = int64(30 * time.Millisecond)
+	s := NewAdaptiveShedder(AdaptiveShedConf{
+		Name:          "spike",
+		TargetLatency: 10 * time.Millisecond,
+		Window:        100 * time.Millisecond,
+		Interval:      20 * time.Millisecond,
+		Step:          0.25,
+		MaxReject:     0.75,
+	})
+	h := s.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(time.Duration(atomic.LoadInt64(&delay)))
+	}))
+
+	//后端变慢，拒绝概率逐步升高到上限
+	before := shedRejected.Get("spike")
+	var last float64
+	deadline := time.Now().Add(2 * time.Second)
+	for s.RejectProbability() < 0.75 && time.Now().Before(deadline) {
+		doRequest(h, "10.0.0.1:1234", nil)
+		if p := s.RejectProbability(); p < last {
+			t.Fatalf("rejection dropped during spike: %v -> %v", last, p)
+		} else {
+			last = p
+		}
+	}
+	if s.RejectProbability() != 0.75 || shedRejectPermille.Get("spike") != 750 {
+		t.Fatalf("rejection did not ramp up: %v", s.RejectProbability())
+	}
+	rejected := 0
+	for i := 0; i < 40; i++ {
+		if rec := doRequest(h, "10.0.0.1:1234", nil); rec.Code == http.StatusServiceUnavailable {
+			rejected++
+		}
+		if rec := doRequestPath(h, "/healthz", nil); rec.Code != http.StatusOK {
+			t.Fatal("exempt path rejected")
+		}
+	}
+	if rejected == 0 || shedRejected.Get("spike") == before {
+		t.Fatal("no requests rejected at max rejection")
+	}
+
+	//后端恢复，拒绝概率回到 0
+	atomic.StoreInt64(&delay, 0)
+	deadline = time.Now().Add(2 * time.Second)
+	for s.RejectProbability() > 0 && time.Now().Before(deadline) {
+		doRequest(h, "10.0.0.1:1234", nil)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.RejectProbability() != 0 || shedRejectPermille.Get("spike") != 0 {
+		t.Fatalf("rejection did not recover: %v", s.RejectProbability())
+	}
+	for i := 0; i < 20; i++ {
+		if rec := doRequest(h, "10.0.0.1:1234", nil); rec.Code != http.StatusOK {
+			t.Fatalf("request rejected after recovery: %d", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	store := NewMemoryKeyStore([]APIKey{
+		{Key: "k1", Name: "partner-a", AllowedPaths: []string{"/orders"}},
+		{Key: "k2", Name: "partner-b", Rate: 0.001, Burst: 2},
+	})
+	var name string
+	auth := NewAPIKeyAuth(store, APIKeyConf{QueryParam: "api_key"})
+	h := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name = APIKeyFromContext(req.Context()).Name
+	}))
+	get := func(path, key string) int {
+		return doRequestPath(h, path, map[string]string{"X-API-Key": key}).Code
+	}
+
+	if code := get("/orders/1", "k1"); code != http.StatusOK || name != "partner-a" {
+		t.Fatalf("k1 got %d, name %q", code, name)
+	}
+	if code := doRequestPath(h, "/orders/1?api_key=k1", nil).Code; code != http.StatusOK {
+		t.Fatalf("query key got %d", code)
+	}
+	if code := get("/users", "k1"); code != http.StatusForbidden {
+		t.Fatalf("disallowed route got %d", code)
+	}
+	//前缀按整段匹配
+	if code := get("/orders", "k1"); code != http.StatusOK {
+		t.Fatalf("exact prefix got %d", code)
+	}
+	if code := get("/ordersadmin", "k1"); code != http.StatusForbidden {
+		t.Fatalf("sibling prefix got %d", code)
+	}
+	if code := get("/orders", "unknown"); code != http.StatusUnauthorized {
+		t.Fatalf("unknown key got %d", code)
+	}
+	if code := get("/orders", ""); code != http.StatusUnauthorized {
+		t.Fatalf("missing key got %d", code)
+	}
+
+	//配额
+	for i := 0; i < 2; i++ {
+		if code := get("/any", "k2"); code != http.StatusOK {
+			t.Fatalf("k2 request %d got %d", i, code)
+		}
+	}
+	if code := get("/any", "k2"); code != http.StatusTooManyRequests {
+		t.Fatalf("k2 over quota got %d", code)
+	}
+
+	//运行中吊销
+	store.Revoke("k1")
+	if code := get("/orders/1", "k1"); code != http.StatusUnauthorized {
+		t.Fatalf("revoked key got %d", code)
+	}
+	//重新加载
+	store.Load([]APIKey{{Key: "k1", Name: "partner-a"}})
+	if code := get("/users", "k1"); code != http.StatusOK {
+		t.Fatalf("reloaded key got %d", code)
+	}
+}
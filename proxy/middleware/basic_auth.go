@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+)
+
+// 用户不存在时用于比较的哈希，保证耗时与用户存在时一致
+var dummyBcryptHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+type BasicAuthConf struct {
+	Realm              string
+	Users              map[string]string //用户名 -> bcrypt 哈希
+	ForwardCredentials bool              //是否将 Authorization 头转发给上游
+}
+
+// Basic 认证中间件，通过路由的 Middlewares 挂载到需要保护的路由上
+type BasicAuth struct {
+	conf BasicAuthConf
+}
+
+func NewBasicAuth(conf BasicAuthConf) *BasicAuth {
+	if conf.Realm == "" {
+		conf.Realm = "gateway"
+	}
+	return &BasicAuth{conf: conf}
+}
+
+// 校验用户名密码，用户不存在时同样做一次 bcrypt 比较
+func (b *BasicAuth) check(username, password string) bool {
+	hash, ok := b.conf.Users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (b *BasicAuth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Del("X-Authenticated-User")
+		username, password, ok := req.BasicAuth()
+		if !ok || !b.check(username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+b.conf.Realm+`", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		SetLogField(req, "user", username)
+		req.Header.Set("X-Authenticated-User", username)
+		if !b.conf.ForwardCredentials {
+			req.Header.Del("Authorization")
+		}
+		next.ServeHTTP(w, req)
+	})
+}
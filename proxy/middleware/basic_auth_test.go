@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	users := map[string]string{"admin": string(hash)}
+	var upstream http.Header
+	capture := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstream = req.Header.Clone()
+	})
+	h := NewBasicAuth(BasicAuthConf{Realm: "tools", Users: users}).Handler(capture)
+
+	send := func(setup func(req *http.Request)) *httptest.ResponseRecorder {
+		upstream = nil
+		req := httptest.NewRequest("GET", "/tools", nil)
+		setup(req)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(func(req *http.Request) { req.SetBasicAuth("admin", "wrong") })
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != `Basic realm="tools", charset="UTF-8"` {
+		t.Fatalf("wrong password got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := send(func(req *http.Request) { req.SetBasicAuth("nobody", "s3cret") }); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown user got %d", rec.Code)
+	}
+	if rec := send(func(req *http.Request) { req.Header.Set("Authorization", "Basic !!!notbase64") }); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("malformed header got %d", rec.Code)
+	}
+	if rec := send(func(req *http.Request) { req.Header.Set("X-Authenticated-User", "admin") }); rec.Code != http.StatusUnauthorized || upstream != nil {
+		t.Fatalf("spoofed user header got %d", rec.Code)
+	}
+
+	rec = send(func(req *http.Request) { req.SetBasicAuth("admin", "s3cret") })
+	if rec.Code != http.StatusOK {
+		t.Fatalf("valid credentials got %d", rec.Code)
+	}
+	if upstream.Get("X-Authenticated-User") != "admin" || upstream.Get("Authorization") != "" {
+		t.Fatalf("unexpected upstream headers %v", upstream)
+	}
+
+	forward := NewBasicAuth(BasicAuthConf{Users: users, ForwardCredentials: true}).Handler(capture)
+	req := httptest.NewRequest("GET", "/tools", nil)
+	req.SetBasicAuth("admin", "s3cret")
+	forward.ServeHTTP(httptest.NewRecorder(), req)
+	if upstream.Get("Authorization") == "" {
+		t.Fatal("credentials should be forwarded when configured")
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var bodyTooLarge = metrics.NewCounterVec("gateway_request_body_too_large_total", "请求体超过限制的次数", "route")
+
+// 限制请求体大小，Content-Length 超限时直接返回413，不读取请求体；
+// 没有 Content-Length 的分块上传在读取过程中超限时中断
+func MaxBodySize(limit int64, route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if limit <= 0 || req.Body == nil || req.Body == http.NoBody {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if req.ContentLength > limit {
+				if !IsDryRun(req.Context()) {
+					bodyTooLarge.Inc(route)
+				}
+				WriteBodyTooLarge(w, limit)
+				return
+			}
+			req.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, req.Body, limit), route: route}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// 在读取超限时计数，同一请求只计一次
+type limitedBody struct {
+	io.ReadCloser
+	route string
+	once  sync.Once
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxErr *http.MaxBytesError
+	if err != nil && errors.As(err, &maxErr) {
+		b.once.Do(func() { bodyTooLarge.Inc(b.route) })
+	}
+	return n, err
+}
+
+func WriteBodyTooLarge(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": "request body too large",
+		"limit": limit,
+	})
+}
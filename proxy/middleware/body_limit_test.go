@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 记录是否被读取过的请求体
+type trackingBody struct {
+	io.Reader
+	read bool
+}
+
+func (b *trackingBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.Reader.Read(p)
+}
+
+func (b *trackingBody) Close() error { return nil }
+
+func TestMaxBodySizeContentLength(t *testing.T) {
+	hit := false
+	h := MaxBodySize(10, "upload")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { hit = true }))
+	body := &trackingBody{Reader: strings.NewReader(strings.Repeat("a", 100))}
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.ContentLength = 100
+	rec := httptest.NewRecorder()
+	before := bodyTooLarge.Get("upload")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || hit || body.read {
+		t.Fatalf("got %d, upstream hit %v, body read %v", rec.Code, hit, body.read)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" || !strings.Contains(rec.Body.String(), `"limit":10`) {
+		t.Fatalf("bad error body %q", rec.Body.String())
+	}
+	if bodyTooLarge.Get("upload") != before+1 {
+		t.Fatal("counter not incremented")
+	}
+}
+
+func TestMaxBodySizeChunked(t *testing.T) {
+	var got string
+	var readErr error
+	h := MaxBodySize(10, "chunked")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := io.ReadAll(req.Body)
+		got, readErr = string(data), err
+	}))
+
+	req := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader("small")))
+	req.ContentLength = -1
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "small" || readErr != nil {
+		t.Fatalf("compliant body changed: %q %v", got, readErr)
+	}
+
+	before := bodyTooLarge.Get("chunked")
+	req = httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(strings.Repeat("b", 50))))
+	req.ContentLength = -1
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if readErr == nil || len(got) > 10 {
+		t.Fatalf("overrun should fail mid-stream, read %d bytes, err %v", len(got), readErr)
+	}
+	if bodyTooLarge.Get("chunked") != before+1 {
+		t.Fatal("counter not incremented")
+	}
+}
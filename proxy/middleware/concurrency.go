@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultQueueTimeout = time.Second
+	DefaultRetryAfter   = time.Second
+)
+
+var (
+	concurrencyActive = metrics.NewGaugeVec("gateway_concurrency_active", "并发限制器中正在处理的请求数", "limiter")
+	concurrencyQueue  = metrics.NewGaugeVec("gateway_concurrency_queue_depth", "并发限制器中排队等待的请求数", "limiter")
+	concurrencyWait   = metrics.NewHistogramVec("gateway_concurrency_queue_wait_ms", "请求在并发限制器中排队的时间", "limiter", metrics.LatencyBuckets)
+	concurrencyShed   = metrics.NewCounterVec("gateway_concurrency_shed_total", "被并发限制器拒绝的请求数，按 限制器/原因 统计，原因为 queue_full 或 timeout", "limiter")
+)
+
+type ConcurrencyConf struct {
+	Name          string        //指标中的 limiter 标签，如 global 或路由名
+	MaxConcurrent int           //同时处理的请求数上限
+	MaxQueue      int           //排队等待的请求数上限，0 表示不排队
+	QueueTimeout  time.Duration //排队超时，默认 DefaultQueueTimeout
+	RetryAfter    time.Duration //拒绝时的 Retry-After，默认 DefaultRetryAfter
+}
+
+// 并发限制：最多 MaxConcurrent 个请求同时处理，之后的请求按先进先出排队，
+// 队列已满或排队超时的请求直接返回 503。
+// 全局限制在监听或路由表上 Use，路由级限制放在 Route.Middlewares 中
+type ConcurrencyLimiter struct {
+	conf ConcurrencyConf
+
+	mux    sync.Mutex
+	active int
+	queue  *list.List //等待中的请求，元素为 chan struct{}，关闭表示获得处理名额
+}
+
+func NewConcurrencyLimiter(conf ConcurrencyConf) *ConcurrencyLimiter {
+	if conf.MaxConcurrent <= 0 {
+		conf.MaxConcurrent = 1
+	}
+	if conf.QueueTimeout <= 0 {
+		conf.QueueTimeout = DefaultQueueTimeout
+	}
+	if conf.RetryAfter < time.Second {
+		conf.RetryAfter = DefaultRetryAfter
+	}
+	return &ConcurrencyLimiter{conf: conf, queue: list.New()}
+}
+
+func (l *ConcurrencyLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if IsDryRun(req.Context()) {
+			//只判断是否会因队列已满被拒绝，不占用名额也不排队
+			l.mux.Lock()
+			full := l.active >= l.conf.MaxConcurrent && l.queue.Len() >= l.conf.MaxQueue
+			l.mux.Unlock()
+			if full {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, req)
+			return
+		}
+		if reason := l.acquire(req); reason != "" {
+			concurrencyShed.Inc(l.conf.Name + "/" + reason)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.conf.RetryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// 获取处理名额，失败时返回原因
+func (l *ConcurrencyLimiter) acquire(req *http.Request) string {
+	l.mux.Lock()
+	if l.active < l.conf.MaxConcurrent && l.queue.Len() == 0 {
+		l.active++
+		l.mux.Unlock()
+		concurrencyActive.Inc(l.conf.Name)
+		return ""
+	}
+	if l.queue.Len() >= l.conf.MaxQueue {
+		l.mux.Unlock()
+		return "queue_full"
+	}
+	ready := make(chan struct{})
+	elem := l.queue.PushBack(ready)
+	l.mux.Unlock()
+	concurrencyQueue.Inc(l.conf.Name)
+
+	start := time.Now()
+	timer := time.NewTimer(l.conf.QueueTimeout)
+	defer timer.Stop()
+	reason := ""
+	select {
+	case <-ready:
+	case <-timer.C:
+		reason = "timeout"
+	case <-req.Context().Done():
+		reason = "canceled"
+	}
+	if reason != "" {
+		l.mux.Lock()
+		select {
+		case <-ready:
+			//超时的同时获得了名额
+			reason = ""
+		default:
+			l.queue.Remove(elem)
+		}
+		l.mux.Unlock()
+	}
+	concurrencyQueue.Dec(l.conf.Name)
+	concurrencyWait.Observe(l.conf.Name, float64(time.Since(start))/float64(time.Millisecond))
+	return reason
+}
+
+// 释放名额，有排队的请求时直接交给队首
+func (l *ConcurrencyLimiter) release() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if front := l.queue.Front(); front != nil {
+		l.queue.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	l.active--
+	concurrencyActive.Dec(l.conf.Name)
+}
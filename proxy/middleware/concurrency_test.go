@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterLoad(t *testing.T) {
+	var inflight, maxInflight int64
+	h := NewConcurrencyLimiter(ConcurrencyConf{Name: "load", MaxConcurrent: 4, MaxQueue: 4, QueueTimeout: time.Second}).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			n := atomic.AddInt64(&inflight, 1)
+			for {
+				m := atomic.LoadInt64(&maxInflight)
+				if n <= m || atomic.CompareAndSwapInt64(&maxInflight, m, n) {
+					break
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+			atomic.AddInt64(&inflight, -1)
+		}))
+
+	before := concurrencyShed.Get("load/queue_full")
+	waits := concurrencyWait.Get("load").Count
+	var served, shed int64
+	var slowest int64
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			rec := doRequest(h, "10.0.0.1:1234", nil)
+			switch rec.Code {
+			case http.StatusOK:
+				atomic.AddInt64(&served, 1)
+			case http.StatusServiceUnavailable:
+				if rec.Header().Get("Retry-After") != "1" {
+					t.Error("shed response without Retry-After")
+				}
+				atomic.AddInt64(&shed, 1)
+			}
+			if d := int64(time.Since(start)); d > atomic.LoadInt64(&slowest) {
+				atomic.StoreInt64(&slowest, d)
+			}
+		}()
+	}
+	wg.Wait()
+	//4 个立即处理，4 个排队，其余直接拒绝
+	if served != 8 || shed != 32 {
+		t.Fatalf("served %d shed %d", served, shed)
+	}
+	if maxInflight > 4 {
+		t.Fatalf("concurrency exceeded: %d", maxInflight)
+	}
+	if time.Duration(slowest) > time.Second {
+		t.Fatalf("tail latency not bounded: %s", time.Duration(slowest))
+	}
+	if concurrencyShed.Get("load/queue_full") != before+32 {
+		t.Fatal("shed requests not counted")
+	}
+	if concurrencyQueue.Get("load") != 0 || concurrencyActive.Get("load") != 0 {
+		t.Fatalf("gauges not reset: queue %d active %d", concurrencyQueue.Get("load"), concurrencyActive.Get("load"))
+	}
+	if concurrencyWait.Get("load").Count != waits+4 {
+		t.Fatalf("queue wait observed %d times", concurrencyWait.Get("load").Count-waits)
+	}
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	release := make(chan struct{})
+	var order []string
+	var mux sync.Mutex
+	h := NewConcurrencyLimiter(ConcurrencyConf{Name: "queue", MaxConcurrent: 1, MaxQueue: 2, QueueTimeout: 300 * time.Millisecond}).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mux.Lock()
+			order = append(order, req.URL.Path)
+			mux.Unlock()
+			if req.URL.Path == "/first" {
+				<-release
+			}
+		}))
+
+	var wg sync.WaitGroup
+	codes := map[string]int{}
+	for i, path := range []string{"/first", "/second", "/third"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			rec := doRequestPath(h, path, nil)
+			mux.Lock()
+			codes[path] = rec.Code
+			mux.Unlock()
+		}(path)
+		time.Sleep(time.Duration(i+1) * 20 * time.Millisecond)
+	}
+	//放行第一个请求，排队的请求按到达顺序处理
+	close(release)
+	wg.Wait()
+	if len(order) != 3 || order[1] != "/second" || order[2] != "/third" {
+		t.Fatalf("queue is not fifo: %v", order)
+	}
+
+	//第一个请求一直占用名额，排队的请求超时
+	release = make(chan struct{})
+	defer close(release)
+	before := concurrencyShed.Get("queue/timeout")
+	go doRequestPath(h, "/first", nil)
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	rec := doRequestPath(h, "/second", nil)
+	if rec.Code != http.StatusServiceUnavailable || time.Since(start) < 300*time.Millisecond {
+		t.Fatalf("queued request should time out, got %d after %s", rec.Code, time.Since(start))
+	}
+	if concurrencyShed.Get("queue/timeout") != before+1 {
+		t.Fatal("queue timeout not counted")
+	}
+}
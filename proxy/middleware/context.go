@@ -0,0 +1,23 @@
+package middleware
+
+import "context"
+
+type contextKey int
+
+const (
+	logFieldsContextKey contextKey = iota
+	jwtClaimsContextKey
+	apiKeyContextKey
+	clientIPContextKey
+	dryRunContextKey
+)
+
+// 标记调试用的模拟请求：有状态的中间件只报告判断结果，不消耗令牌、名额，不记录日志
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunContextKey, true)
+}
+
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunContextKey).(bool)
+	return dryRun
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CORSConf struct {
+	AllowedOrigins   []string //精确匹配、"*" 或 "*.example.com" 子域名通配
+	AllowedMethods   []string //默认 GET/HEAD/POST
+	AllowedHeaders   []string //为空时回显预检请求的 Access-Control-Request-Headers
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int  //预检结果缓存秒数
+	PassUpstream     bool //上游已返回 CORS 头时保留上游的，否则去掉上游的 CORS 头
+}
+
+// CORS 中间件，预检请求直接由网关应答，不会转发到上游
+type CORS struct {
+	conf CORSConf
+}
+
+func NewCORS(conf CORSConf) *CORS {
+	if len(conf.AllowedMethods) == 0 {
+		conf.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	return &CORS{conf: conf}
+}
+
+func (c *CORS) originAllowed(origin string) bool {
+	for _, pattern := range c.conf.AllowedOrigins {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") {
+			//*.example.com 匹配 https://a.example.com，不匹配 https://example.com
+			host := origin
+			if i := strings.Index(host, "://"); i != -1 {
+				host = host[i+3:]
+			}
+			if strings.HasSuffix(strings.ToLower(host), strings.ToLower(pattern[1:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 允许携带凭证时不能返回 "*"，只能回显具体的 Origin
+func (c *CORS) allowOriginValue(origin string) string {
+	for _, pattern := range c.conf.AllowedOrigins {
+		if pattern == "*" && !c.conf.AllowCredentials {
+			return "*"
+		}
+	}
+	return origin
+}
+
+func (c *CORS) methodAllowed(method string) bool {
+	for _, m := range c.conf.AllowedMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) setCommonHeaders(h http.Header, origin string) {
+	h.Set("Access-Control-Allow-Origin", c.allowOriginValue(origin))
+	if c.conf.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *CORS) handlePreflight(w http.ResponseWriter, req *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	reqMethod := req.Header.Get("Access-Control-Request-Method")
+	if !c.originAllowed(origin) || !c.methodAllowed(reqMethod) {
+		varyOrigin(h)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	c.setCommonHeaders(h, origin)
+	varyOrigin(h)
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.conf.AllowedMethods, ", "))
+	if len(c.conf.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.conf.AllowedHeaders, ", "))
+	} else if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if c.conf.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(c.conf.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			c.handlePreflight(w, req, origin)
+			return
+		}
+		if !c.originAllowed(origin) {
+			next.ServeHTTP(&corsWriter{ResponseWriter: w, cors: c}, req)
+			return
+		}
+		next.ServeHTTP(&corsWriter{ResponseWriter: w, cors: c, origin: origin}, req)
+	})
+}
+
+// 在写响应头前处理上游返回的 CORS 头，避免重复
+type corsWriter struct {
+	http.ResponseWriter
+	cors        *CORS
+	origin      string //为空表示 Origin 不被允许
+	wroteHeader bool
+}
+
+func (w *corsWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		upstreamSet := h.Get("Access-Control-Allow-Origin") != ""
+		if !(w.cors.conf.PassUpstream && upstreamSet) {
+			for k := range h {
+				if strings.HasPrefix(k, "Access-Control-") {
+					h.Del(k)
+				}
+			}
+			if w.origin != "" {
+				w.cors.setCommonHeaders(h, w.origin)
+				if len(w.cors.conf.ExposedHeaders) > 0 {
+					h.Set("Access-Control-Expose-Headers", strings.Join(w.cors.conf.ExposedHeaders, ", "))
+				}
+			}
+		}
+		varyOrigin(h)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// 响应随 Origin 变化：不允许的 Origin 得到的是没有 CORS 头的响应，共享缓存不能把它返回给允许的 Origin。
+// 只有 Access-Control-Allow-Origin 为 "*" 时响应与 Origin 无关
+func varyOrigin(h http.Header) {
+	if h.Get("Access-Control-Allow-Origin") == "*" {
+		return
+	}
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if f := strings.TrimSpace(field); f == "*" || strings.EqualFold(f, "Origin") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Origin")
+}
+
+func (w *corsWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *corsWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *corsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func corsRequest(h http.Handler, method, origin string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api", nil)
+	req.Header.Set("Origin", origin)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSPreflightShortCircuit(t *testing.T) {
+	hit := false
+	c := NewCORS(CORSConf{AllowedOrigins: []string{"https://app.example.com"}, AllowedMethods: []string{"GET", "PUT"}, MaxAge: 600})
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { hit = true }))
+
+	rec := corsRequest(h, "OPTIONS", "https://app.example.com", map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "X-Token"})
+	if rec.Code != http.StatusNoContent || hit {
+		t.Fatalf("preflight got %d, upstream hit %v", rec.Code, hit)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rec.Header().Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+		rec.Header().Get("Access-Control-Allow-Headers") != "X-Token" ||
+		rec.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("bad preflight headers %v", rec.Header())
+	}
+	rec = corsRequest(h, "OPTIONS", "https://app.example.com", map[string]string{"Access-Control-Request-Method": "DELETE"})
+	if rec.Code != http.StatusForbidden || hit {
+		t.Fatalf("disallowed method preflight got %d", rec.Code)
+	}
+	//普通 OPTIONS 请求继续转发
+	corsRequest(h, "OPTIONS", "https://app.example.com", nil)
+	if !hit {
+		t.Fatal("plain OPTIONS should reach upstream")
+	}
+}
+
+func TestCORSOriginMatching(t *testing.T) {
+	c := NewCORS(CORSConf{AllowedOrigins: []string{"https://exact.com", "*.example.com"}, ExposedHeaders: []string{"X-Request-Id"}})
+	h := c.Handler(okHandler)
+	cases := map[string]bool{
+		"https://exact.com":        true,
+		"https://a.example.com":    true,
+		"https://a.b.example.com":  true,
+		"https://example.com":      false,
+		"https://evil-example.com": false,
+		"https://other.com":        false,
+	}
+	for origin, allowed := range cases {
+		rec := corsRequest(h, "GET", origin, nil)
+		got := rec.Header().Get("Access-Control-Allow-Origin")
+		if allowed && (got != origin || rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id") {
+			t.Errorf("%s should be allowed, got %q", origin, got)
+		}
+		if !allowed && got != "" {
+			t.Errorf("%s should not be allowed, got %q", origin, got)
+		}
+	}
+}
+
+func TestCORSCredentialsNeverWildcard(t *testing.T) {
+	c := NewCORS(CORSConf{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	rec := corsRequest(c.Handler(okHandler), "GET", "https://any.com", nil)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://any.com" || rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("bad headers %v", rec.Header())
+	}
+	c = NewCORS(CORSConf{AllowedOrigins: []string{"*"}})
+	if got := corsRequest(c.Handler(okHandler), "GET", "https://any.com", nil).Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("without credentials want *, got %q", got)
+	}
+}
+
+func TestCORSUpstreamHeaders(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET")
+		w.WriteHeader(http.StatusOK)
+	})
+	strip := NewCORS(CORSConf{AllowedOrigins: []string{"https://app.com"}}).Handler(upstream)
+	rec := corsRequest(strip, "GET", "https://app.com", nil)
+	if v := rec.Header().Values("Access-Control-Allow-Origin"); len(v) != 1 || v[0] != "https://app.com" {
+		t.Fatalf("upstream cors header should be replaced, got %v", v)
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Fatal("upstream cors header should be stripped")
+	}
+	pass := NewCORS(CORSConf{AllowedOrigins: []string{"https://app.com"}, PassUpstream: true}).Handler(upstream)
+	if got := corsRequest(pass, "GET", "https://app.com", nil).Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("upstream cors header should pass through, got %q", got)
+	}
+}
+
+func TestCORSVaryOrigin(t *testing.T) {
+	h := NewCORS(CORSConf{AllowedOrigins: []string{"https://app.example.com"}}).Handler(okHandler)
+	for _, origin := range []string{"https://app.example.com", "https://evil.com"} {
+		if got := corsRequest(h, "GET", origin, nil).Header().Values("Vary"); len(got) != 1 || got[0] != "Origin" {
+			t.Fatalf("%s: Vary %v", origin, got)
+		}
+	}
+	//上游已声明 Vary: Origin 时不重复
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Vary", "Accept-Encoding, Origin")
+	})
+	h = NewCORS(CORSConf{AllowedOrigins: []string{"https://app.example.com"}}).Handler(upstream)
+	if got := corsRequest(h, "GET", "https://evil.com", nil).Header().Values("Vary"); len(got) != 1 {
+		t.Fatalf("Vary %v", got)
+	}
+	//"*" 与 Origin 无关
+	h = NewCORS(CORSConf{AllowedOrigins: []string{"*"}}).Handler(okHandler)
+	if got := corsRequest(h, "GET", "https://any.com", nil).Header().Get("Vary"); got != "" {
+		t.Fatalf("wildcard response got Vary %q", got)
+	}
+}
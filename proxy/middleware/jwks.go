@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultJWKSRefresh = 10 * time.Minute
+	//遇到未知 kid 时触发刷新的最小间隔，防止被伪造的 kid 打爆 JWKS 服务
+	jwksMinRefreshInterval = 5 * time.Second
+)
+
+// 从 JWKS 地址拉取公钥，定时刷新，遇到未知 kid 时按需刷新
+type JWKS struct {
+	url     string
+	client  *http.Client
+	mux     sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	stop    chan struct{}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func NewJWKS(url string, refresh time.Duration) (*JWKS, error) {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	j := &JWKS{url: url, client: &http.Client{Timeout: 10 * time.Second}, keys: map[string]crypto.PublicKey{}, stop: make(chan struct{})}
+	if err := j.Refresh(); err != nil {
+		return nil, err
+	}
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := j.Refresh(); err != nil {
+					fmt.Println("jwks refresh error", err)
+				}
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+	return j, nil
+}
+
+// 停止定时刷新
+func (j *JWKS) Stop() {
+	close(j.stop)
+}
+
+func (j *JWKS) Refresh() error {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			fmt.Println("jwks skip key", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	j.mux.Lock()
+	j.keys = keys
+	j.fetched = time.Now()
+	j.mux.Unlock()
+	return nil
+}
+
+func (j *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	j.mux.RLock()
+	key, ok := j.keys[kid]
+	fetched := j.fetched
+	j.mux.RUnlock()
+	if ok {
+		return key, nil
+	}
+	//可能是密钥轮换，刷新一次再查
+	if time.Since(fetched) >= jwksMinRefreshInterval {
+		if err := j.Refresh(); err != nil {
+			return nil, err
+		}
+		j.mux.RLock()
+		key, ok = j.keys[kid]
+		j.mux.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, errors.New("unknown kid " + kid)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, errors.New("unsupported kty " + k.Kty)
+}
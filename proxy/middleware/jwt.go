@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenMissing   = errors.New("token missing")
+	ErrTokenMalformed = errors.New("token malformed")
+	ErrTokenSignature = errors.New("token signature invalid")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenNotYet    = errors.New("token not valid yet")
+	ErrTokenIssuer    = errors.New("token issuer invalid")
+	ErrTokenAudience  = errors.New("token audience invalid")
+)
+
+type JWTConf struct {
+	HMACSecret   []byte        //HS256 密钥
+	PublicKeyPEM []byte        //RS256/ES256 公钥或证书
+	JWKSURL      string        //JWKS 地址，与 PublicKeyPEM 二选一
+	JWKSRefresh  time.Duration //JWKS 刷新间隔
+	Issuer       string        //为空不校验
+	Audience     string        //为空不校验
+	Leeway       time.Duration //exp/nbf 允许的时钟偏差
+
+	ClaimHeaders       map[string]string //claim 名 -> 转发给上游的请求头，如 sub -> X-User-Id
+	StripAuthorization bool              //校验通过后去掉 Authorization 头
+	PublicPaths        []string          //无需认证的路径前缀
+	Realm              string
+}
+
+// JWT 认证中间件
+type JWTAuth struct {
+	conf      JWTConf
+	staticKey crypto.PublicKey
+	jwks      *JWKS
+	now       func() time.Time
+}
+
+func NewJWTAuth(conf JWTConf) (*JWTAuth, error) {
+	j := &JWTAuth{conf: conf, now: time.Now}
+	if len(conf.PublicKeyPEM) > 0 {
+		key, err := parsePublicKeyPEM(conf.PublicKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		j.staticKey = key
+	}
+	if conf.JWKSURL != "" {
+		jwks, err := NewJWKS(conf.JWKSURL, conf.JWKSRefresh)
+		if err != nil {
+			return nil, err
+		}
+		j.jwks = jwks
+	}
+	if j.conf.Realm == "" {
+		j.conf.Realm = "gateway"
+	}
+	return j, nil
+}
+
+// 停止 JWKS 刷新
+func (j *JWTAuth) Close() {
+	if j.jwks != nil {
+		j.jwks.Stop()
+	}
+}
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// 校验 token 并返回 claims
+func (j *JWTAuth) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := j.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := j.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (j *JWTAuth) verifySignature(alg, kid, signingInput string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "HS256":
+		if len(j.conf.HMACSecret) == 0 {
+			return ErrTokenSignature
+		}
+		mac := hmac.New(sha256.New, j.conf.HMACSecret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrTokenSignature
+		}
+		return nil
+	case "RS256", "ES256":
+		key := j.staticKey
+		if j.jwks != nil && (key == nil || kid != "") {
+			var err error
+			if key, err = j.jwks.Key(kid); err != nil {
+				return ErrTokenSignature
+			}
+		}
+		switch pub := key.(type) {
+		case *rsa.PublicKey:
+			if alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if alg == "ES256" && len(sig) == 64 {
+				r := new(big.Int).SetBytes(sig[:32])
+				s := new(big.Int).SetBytes(sig[32:])
+				if ecdsa.Verify(pub, digest[:], r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return ErrTokenSignature
+}
+
+func (j *JWTAuth) validateClaims(claims map[string]interface{}) error {
+	now := j.now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(j.conf.Leeway)) {
+			return ErrTokenExpired
+		}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(j.conf.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return ErrTokenNotYet
+		}
+	}
+	if j.conf.Issuer != "" && claims["iss"] != j.conf.Issuer {
+		return ErrTokenIssuer
+	}
+	if j.conf.Audience != "" && !audienceContains(claims["aud"], j.conf.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+// aud 可以是字符串或字符串数组
+func audienceContains(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, item := range v {
+			if item == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func (j *JWTAuth) isPublic(path string) bool {
+	for _, prefix := range j.conf.PublicPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *JWTAuth) unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="`+j.conf.Realm+`", error="invalid_token", error_description="`+err.Error()+`"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (j *JWTAuth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		//去掉客户端伪造的身份头
+		for _, header := range j.conf.ClaimHeaders {
+			req.Header.Del(header)
+		}
+		if j.isPublic(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		token := bearerToken(req)
+		if token == "" {
+			j.unauthorized(w, ErrTokenMissing)
+			return
+		}
+		claims, err := j.Verify(token)
+		if err != nil {
+			j.unauthorized(w, err)
+			return
+		}
+		for claim, header := range j.conf.ClaimHeaders {
+			if v, ok := claims[claim]; ok {
+				req.Header.Set(header, claimString(v))
+			}
+		}
+		if j.conf.StripAuthorization {
+			req.Header.Del("Authorization")
+		}
+		if sub, ok := claims["sub"].(string); ok {
+			SetLogField(req, "user", sub)
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), jwtClaimsContextKey, claims)))
+	})
+}
+
+func claimString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// 获取校验通过的 JWT claims
+func JWTClaimsFromContext(ctx context.Context) map[string]interface{} {
+	claims, _ := ctx.Value(jwtClaimsContextKey).(map[string]interface{})
+	return claims
+}
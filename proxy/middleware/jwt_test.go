@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + b64(sig)
+}
+
+func authRequest(h http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJWTHS256Claims(t *testing.T) {
+	secret := []byte("secret")
+	auth, err := NewJWTAuth(JWTConf{
+		HMACSecret:         secret,
+		Issuer:             "gw",
+		Audience:           "api",
+		ClaimHeaders:       map[string]string{"sub": "X-User-Id", "tenant": "X-Tenant"},
+		StripAuthorization: true,
+		PublicPaths:        []string{"/public"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got http.Header
+	h := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header.Clone()
+	}))
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	token := signToken(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "tenant": 7, "iss": "gw", "aud": []string{"web", "api"}, "exp": exp})
+	if rec := authRequest(h, "/api", token); rec.Code != http.StatusOK {
+		t.Fatalf("valid token got %d: %s", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if got.Get("X-User-Id") != "u1" || got.Get("X-Tenant") != "7" || got.Get("Authorization") != "" {
+		t.Fatalf("bad forwarded headers %v", got)
+	}
+
+	wrongAud := signToken(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "gw", "aud": "other", "exp": exp})
+	rec := authRequest(h, "/api", wrongAud)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("wrong audience got %d", rec.Code)
+	}
+	expired := signToken(t, "HS256", "", secret, map[string]interface{}{"iss": "gw", "aud": "api", "exp": float64(time.Now().Add(-time.Minute).Unix())})
+	if rec := authRequest(h, "/api", expired); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expired token got %d", rec.Code)
+	}
+	forged := signToken(t, "HS256", "", []byte("other"), map[string]interface{}{"iss": "gw", "aud": "api", "exp": exp})
+	if rec := authRequest(h, "/api", forged); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("forged token got %d", rec.Code)
+	}
+	if rec := authRequest(h, "/public/info", ""); rec.Code != http.StatusOK {
+		t.Fatalf("public path got %d", rec.Code)
+	}
+	if rec := authRequest(h, "/api", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("missing token got %d", rec.Code)
+	}
+}
+
+func TestJWTStaticPEM(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	auth, err := NewJWTAuth(JWTConf{PublicKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Verify(signToken(t, "ES256", "", ecKey, map[string]interface{}{"sub": "a"})); err != nil {
+		t.Fatal(err)
+	}
+	//HS256 不能用公钥作为密钥
+	if _, err := auth.Verify(signToken(t, "HS256", "", der, map[string]interface{}{"sub": "a"})); err == nil {
+		t.Fatal("alg confusion should fail")
+	}
+}
+
+type jwksServer struct {
+	mux  sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	keys := []map[string]string{}
+	for kid, pub := range s.keys {
+		keys = append(keys, map[string]string{"kty": "RSA", "kid": kid, "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func TestJWTRotatedJWKS(t *testing.T) {
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	js := &jwksServer{keys: map[string]*rsa.PublicKey{"k1": &key1.PublicKey}}
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	auth, err := NewJWTAuth(JWTConf{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auth.Close()
+	if _, err := auth.Verify(signToken(t, "RS256", "k1", key1, map[string]interface{}{"sub": "a"})); err != nil {
+		t.Fatal(err)
+	}
+	//轮换：k1 下线，k2 上线
+	js.mux.Lock()
+	js.keys = map[string]*rsa.PublicKey{"k2": &key2.PublicKey}
+	js.mux.Unlock()
+	auth.jwks.mux.Lock()
+	auth.jwks.fetched = time.Time{}
+	auth.jwks.mux.Unlock()
+	if _, err := auth.Verify(signToken(t, "RS256", "k2", key2, map[string]interface{}{"sub": "a"})); err != nil {
+		t.Fatalf("rotated key should be fetched: %v", err)
+	}
+	if _, err := auth.Verify(signToken(t, "RS256", "k1", key1, map[string]interface{}{"sub": "a"})); err == nil {
+		t.Fatal("retired key should be rejected")
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"GO_GATEWAY/proxy/rate_limiter"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 从请求中提取限流 key，返回空字符串表示不限流
+type KeyFunc func(req *http.Request) string
+
+// 按客户端真实 IP
+func IPKey() KeyFunc {
+	return func(req *http.Request) string {
+		return ClientIP(req)
+	}
+}
+
+// 按请求头，如 X-API-Key
+func HeaderKey(name string) KeyFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// 按 cookie
+func CookieKey(name string) KeyFunc {
+	return func(req *http.Request) string {
+		c, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+type RateLimitConf struct {
+	Rate        float64       //每秒令牌数
+	Burst       int           //桶容量
+	MaxKeys     int           //最多跟踪的 key 数
+	IdleTimeout time.Duration //key 空闲淘汰时间
+	KeyFunc     KeyFunc       //默认按 IP
+}
+
+// 按 key 限流的中间件
+type RateLimiter struct {
+	keyFunc  KeyFunc
+	limiters *rate_limiter.KeyedLimiter
+}
+
+func NewRateLimiter(conf RateLimitConf) *RateLimiter {
+	keyFunc := conf.KeyFunc
+	if keyFunc == nil {
+		keyFunc = IPKey()
+	}
+	return &RateLimiter{
+		keyFunc:  keyFunc,
+		limiters: rate_limiter.NewKeyedLimiter(conf.Rate, conf.Burst, conf.MaxKeys, conf.IdleTimeout),
+	}
+}
+
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := l.keyFunc(req)
+		if key == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		bucket := l.limiters.Get(key)
+		var allowed bool
+		if IsDryRun(req.Context()) {
+			allowed = bucket.Remaining() > 0
+		} else {
+			allowed = bucket.Allow()
+		}
+		SetRateLimitHeaders(w.Header(), bucket)
+		if !allowed {
+			SetRetryAfter(w.Header(), bucket)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// 写入 RateLimit-Limit/Remaining/Reset 头，Reset 单位为秒
+func SetRateLimitHeaders(h http.Header, bucket *rate_limiter.TokenBucket) {
+	remaining := bucket.Remaining()
+	reset := int(math.Ceil(bucket.Reset().Seconds()))
+	if remaining == 0 && reset == 0 {
+		reset = 1
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(bucket.Limit()))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(reset))
+}
+
+// 写入被拒绝时的 Retry-After 头：下一个令牌可用的秒数，向上取整且至少为1
+func SetRetryAfter(h http.Header, bucket *rate_limiter.TokenBucket) {
+	wait := int(math.Ceil(bucket.NextToken().Seconds()))
+	if wait < 1 {
+		wait = 1
+	}
+	h.Set("Retry-After", strconv.Itoa(wait))
+}
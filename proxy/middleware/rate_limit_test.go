@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func doRequest(h http.Handler, remoteAddr string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://gateway/", nil)
+	req.RemoteAddr = remoteAddr
+	return serveRequest(h, req, header)
+}
+
+func doRequestPath(h http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	return serveRequest(h, httptest.NewRequest("GET", "http://gateway"+path, nil), header)
+}
+
+func serveRequest(h http.Handler, req *http.Request, header map[string]string) *httptest.ResponseRecorder {
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRateLimiterIndependentClients(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConf{Rate: 0.001, Burst: 2})
+	h := rl.Handler(okHandler)
+	for i := 0; i < 2; i++ {
+		if rec := doRequest(h, "10.0.0.1:1234", nil); rec.Code != http.StatusOK {
+			t.Fatalf("client1 request %d got %d", i, rec.Code)
+		}
+	}
+	if rec := doRequest(h, "10.0.0.1:1234", nil); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("client1 should be limited, got %d", rec.Code)
+	}
+	if rec := doRequest(h, "10.0.0.2:1234", nil); rec.Code != http.StatusOK {
+		t.Fatalf("client2 should have its own budget, got %d", rec.Code)
+	}
+}
+
+func TestRateLimiterHeaders(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConf{Rate: 1, Burst: 3})
+	h := rl.Handler(okHandler)
+	rec := doRequest(h, "10.0.0.1:1234", nil)
+	if got := rec.Header().Get("RateLimit-Limit"); got != "3" {
+		t.Fatalf("RateLimit-Limit = %q", got)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "2" {
+		t.Fatalf("RateLimit-Remaining = %q", got)
+	}
+	if got := rec.Header().Get("RateLimit-Reset"); got != "1" {
+		t.Fatalf("RateLimit-Reset = %q", got)
+	}
+	doRequest(h, "10.0.0.1:1234", nil)
+	doRequest(h, "10.0.0.1:1234", nil)
+	rec = doRequest(h, "10.0.0.1:1234", nil)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("want 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("RateLimit-Remaining") != "0" || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("bad limited headers: %v", rec.Header())
+	}
+}
+
+func TestRateLimiterRetryAfterNextToken(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConf{Rate: 1, Burst: 100})
+	h := rl.Handler(okHandler)
+	for i := 0; i < 100; i++ {
+		doRequest(h, "10.0.0.1:1234", nil)
+	}
+	rec := doRequest(h, "10.0.0.1:1234", nil)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("want 429, got %d", rec.Code)
+	}
+	//填满要等约100秒，下一个令牌只需约1秒
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("Retry-After = %q, want 1", got)
+	}
+	if got := rec.Header().Get("RateLimit-Reset"); got == "1" {
+		t.Fatalf("RateLimit-Reset = %q, want time until full", got)
+	}
+}
+
+func TestRateLimiterHeaderKeyAndTrustedProxy(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConf{Rate: 0.001, Burst: 1, KeyFunc: HeaderKey("X-API-Key")})
+	h := rl.Handler(okHandler)
+	if rec := doRequest(h, "10.0.0.1:1", map[string]string{"X-API-Key": "a"}); rec.Code != http.StatusOK {
+		t.Fatalf("key a got %d", rec.Code)
+	}
+	if rec := doRequest(h, "10.0.0.1:1", map[string]string{"X-API-Key": "b"}); rec.Code != http.StatusOK {
+		t.Fatalf("key b got %d", rec.Code)
+	}
+	if rec := doRequest(h, "10.0.0.1:1", map[string]string{"X-API-Key": "a"}); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("key a should be limited, got %d", rec.Code)
+	}
+
+	if err := SetTrustedProxies([]string{"192.168.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTrustedProxies(nil)
+	ipLimiter := NewRateLimiter(RateLimitConf{Rate: 0.001, Burst: 1}).Handler(okHandler)
+	xff := map[string]string{"X-Forwarded-For": "1.1.1.1"}
+	if rec := doRequest(ipLimiter, "192.168.1.1:80", xff); rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if rec := doRequest(ipLimiter, "192.168.1.2:80", map[string]string{"X-Forwarded-For": "2.2.2.2"}); rec.Code != http.StatusOK {
+		t.Fatalf("different real ip behind same proxy should pass, got %d", rec.Code)
+	}
+	if rec := doRequest(ipLimiter, "192.168.1.2:80", xff); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("same real ip should be limited, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 受信任的代理网段，只有来自这些地址的 X-Forwarded-For / X-Real-Ip 才会被采信
+var (
+	trustedMux     sync.RWMutex
+	trustedProxies []*net.IPNet
+)
+
+// 设置受信任代理，支持 CIDR 或单个 IP
+func SetTrustedProxies(proxies []string) error {
+	nets := []*net.IPNet{}
+	for _, item := range proxies {
+		ipNet, err := ParseCIDROrIP(item)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, ipNet)
+	}
+	trustedMux.Lock()
+	trustedProxies = nets
+	trustedMux.Unlock()
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	trustedMux.RLock()
+	defer trustedMux.RUnlock()
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取客户端真实 IP：经过 RealIP 中间件时使用其解析结果；否则
+// RemoteAddr 为受信任代理时，从 X-Forwarded-For 由右向左取第一个非受信任地址，其次取 X-Real-Ip
+func ClientIP(req *http.Request) string {
+	if ip := ClientIPFromContext(req.Context()); ip != "" {
+		return ip
+	}
+	remoteIP := remoteAddrIP(req.RemoteAddr)
+	if !isTrustedProxy(net.ParseIP(remoteIP)) {
+		return remoteIP
+	}
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			ip := net.ParseIP(hop)
+			if ip == nil {
+				break
+			}
+			if !isTrustedProxy(ip) {
+				return ip.String()
+			}
+		}
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-Ip"))); realIP != nil {
+		return realIP.String()
+	}
+	return remoteIP
+}
+
+const (
+	HeaderXForwardedFor  = "X-Forwarded-For"
+	HeaderXRealIP        = "X-Real-IP"
+	HeaderCFConnectingIP = "CF-Connecting-IP"
+	HeaderForwarded      = "Forwarded"
+)
+
+type RealIPConf struct {
+	Header       string   //采信的请求头，默认 X-Forwarded-For
+	TrustedHops  int      //X-Forwarded-For/Forwarded 中由右向左剥离的可信代理跳数，0 表示取第一个不在 TrustedPeers 中的地址
+	TrustedPeers []string //直连地址不在这些网段内时完全忽略请求头，为空表示不信任任何请求头
+}
+
+// 按部署方式配置的真实 IP 解析，Handler 把结果放入请求上下文，
+// 之后的限流、ACL、ip-hash、日志通过 ClientIP 拿到同一个结果
+type RealIP struct {
+	conf    RealIPConf
+	trusted []*net.IPNet
+}
+
+func NewRealIP(conf RealIPConf) (*RealIP, error) {
+	if conf.Header == "" {
+		conf.Header = HeaderXForwardedFor
+	}
+	switch http.CanonicalHeaderKey(conf.Header) {
+	case http.CanonicalHeaderKey(HeaderXForwardedFor), http.CanonicalHeaderKey(HeaderXRealIP),
+		http.CanonicalHeaderKey(HeaderCFConnectingIP), http.CanonicalHeaderKey(HeaderForwarded):
+	default:
+		return nil, errors.New("unsupported real ip header: " + conf.Header)
+	}
+	if conf.TrustedHops < 0 {
+		return nil, errors.New("trusted hops must not be negative")
+	}
+	r := &RealIP{conf: conf}
+	for _, item := range conf.TrustedPeers {
+		ipNet, err := ParseCIDROrIP(item)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, ipNet)
+	}
+	return r, nil
+}
+
+func (r *RealIP) isTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range r.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析请求的客户端 IP，请求头缺失或不合法时返回直连地址
+func (r *RealIP) Resolve(req *http.Request) string {
+	remoteIP := remoteAddrIP(req.RemoteAddr)
+	if !r.isTrusted(net.ParseIP(remoteIP)) {
+		return remoteIP
+	}
+	switch http.CanonicalHeaderKey(r.conf.Header) {
+	case http.CanonicalHeaderKey(HeaderXForwardedFor):
+		if ip := r.fromHops(splitForwardedFor(req.Header.Values(HeaderXForwardedFor))); ip != nil {
+			return ip.String()
+		}
+	case http.CanonicalHeaderKey(HeaderForwarded):
+		if ip := r.fromHops(parseForwarded(req.Header.Values(HeaderForwarded))); ip != nil {
+			return ip.String()
+		}
+	default:
+		if ip := net.ParseIP(strings.TrimSpace(req.Header.Get(r.conf.Header))); ip != nil {
+			return ip.String()
+		}
+	}
+	return remoteIP
+}
+
+// hops 为由左到右的地址列表，不合法的地址为 nil
+func (r *RealIP) fromHops(hops []net.IP) net.IP {
+	if len(hops) == 0 {
+		return nil
+	}
+	if r.conf.TrustedHops > 0 {
+		i := len(hops) - r.conf.TrustedHops
+		if i < 0 {
+			i = 0
+		}
+		return hops[i]
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == nil {
+			return nil
+		}
+		if !r.isTrusted(hops[i]) {
+			return hops[i]
+		}
+	}
+	return hops[0]
+}
+
+func (r *RealIP) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), clientIPContextKey, r.Resolve(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// 获取 RealIP 中间件解析出的客户端 IP，未经过该中间件时返回空
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey).(string)
+	return ip
+}
+
+func splitForwardedFor(values []string) []net.IP {
+	hops := []net.IP{}
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, net.ParseIP(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// 解析 RFC 7239 Forwarded 头中各跳的 for 参数，如 for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"
+func parseForwarded(values []string) []net.IP {
+	hops := []net.IP{}
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			var ip net.IP
+			for _, pair := range strings.Split(elem, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				ip = parseForwardedNode(strings.Trim(value, `"`))
+			}
+			hops = append(hops, ip)
+		}
+	}
+	return hops
+}
+
+// 节点可能带端口，IPv6 用方括号包裹；unknown 或混淆标识返回 nil
+func parseForwardedNode(node string) net.IP {
+	if strings.HasPrefix(node, "[") {
+		end := strings.Index(node, "]")
+		if end < 0 {
+			return nil
+		}
+		return net.ParseIP(node[1:end])
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	return net.ParseIP(node)
+}
+
+func remoteAddrIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// 解析 CIDR，单个 IP 按 /32 或 /128 处理
+func ParseCIDROrIP(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
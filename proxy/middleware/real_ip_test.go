@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	cases := []struct {
+		name   string
+		conf   RealIPConf
+		remote string
+		header string
+		value  string
+		want   string
+	}{
+		{"xff first untrusted", RealIPConf{TrustedPeers: []string{"10.0.0.0/8"}}, "10.0.0.1:1", "X-Forwarded-For", "1.1.1.1, 2.2.2.2, 10.0.0.5", "2.2.2.2"},
+		{"xff hops", RealIPConf{TrustedHops: 2, TrustedPeers: []string{"10.0.0.0/8"}}, "10.0.0.1:1", "X-Forwarded-For", "9.9.9.9, 1.1.1.1, 2.2.2.2", "1.1.1.1"},
+		{"xff fewer entries than hops", RealIPConf{TrustedHops: 3, TrustedPeers: []string{"10.0.0.0/8"}}, "10.0.0.1:1", "X-Forwarded-For", "1.1.1.1", "1.1.1.1"},
+		{"untrusted peer spoofing", RealIPConf{TrustedPeers: []string{"10.0.0.0/8"}}, "8.8.8.8:1", "X-Forwarded-For", "1.1.1.1", "8.8.8.8"},
+		{"no trusted peers", RealIPConf{}, "10.0.0.1:1", "X-Forwarded-For", "1.1.1.1", "10.0.0.1"},
+		{"xff garbage", RealIPConf{TrustedPeers: []string{"10.0.0.0/8"}}, "10.0.0.1:1", "X-Forwarded-For", "1.1.1.1, evil", "10.0.0.1"},
+		{"ipv6", RealIPConf{TrustedPeers: []string{"fd00::/8"}}, "[fd00::1]:443", "X-Forwarded-For", "2001:db8::1", "2001:db8::1"},
+		{"cloudflare", RealIPConf{Header: HeaderCFConnectingIP, TrustedPeers: []string{"173.245.48.0/20"}}, "173.245.48.1:1", "CF-Connecting-IP", "3.3.3.3", "3.3.3.3"},
+		{"x-real-ip", RealIPConf{Header: HeaderXRealIP, TrustedPeers: []string{"10.0.0.1"}}, "10.0.0.1:1", "X-Real-IP", " 4.4.4.4 ", "4.4.4.4"},
+		{"forwarded", RealIPConf{Header: HeaderForwarded, TrustedPeers: []string{"10.0.0.0/8"}}, "10.0.0.1:1", "Forwarded", `for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`, "2001:db8:cafe::17"},
+		{"forwarded hops", RealIPConf{Header: HeaderForwarded, TrustedHops: 2, TrustedPeers: []string{"10.0.0.0/8"}}, "10.0.0.1:1", "Forwarded", `For="192.0.2.60:80", for=198.51.100.17`, "192.0.2.60"},
+		{"forwarded obfuscated", RealIPConf{Header: HeaderForwarded, TrustedPeers: []string{"10.0.0.0/8"}}, "10.0.0.1:1", "Forwarded", "for=_hidden", "10.0.0.1"},
+	}
+	for _, c := range cases {
+		r, err := NewRealIP(c.conf)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		req.Header.Set(c.header, c.value)
+		if got := r.Resolve(req); got != c.want {
+			t.Errorf("%s: got %s want %s", c.name, got, c.want)
+		}
+	}
+	if _, err := NewRealIP(RealIPConf{Header: "X-Client"}); err == nil {
+		t.Fatal("unsupported header accepted")
+	}
+}
+
+func TestRealIPHandler(t *testing.T) {
+	r, _ := NewRealIP(RealIPConf{TrustedPeers: []string{"10.0.0.0/8"}})
+	var fromCtx, fromClientIP string
+	h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fromCtx = ClientIPFromContext(req.Context())
+		fromClientIP = ClientIP(req)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1"
+	req.Header.Set("X-Forwarded-For", "5.5.5.5")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	//全局的 SetTrustedProxies 未配置时 ClientIP 同样使用中间件的结果
+	if fromCtx != "5.5.5.5" || fromClientIP != "5.5.5.5" {
+		t.Fatalf("got %s %s", fromCtx, fromClientIP)
+	}
+}
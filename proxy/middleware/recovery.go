@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+var panicsRecovered = metrics.NewCounterVec("gateway_panics_recovered_total", "处理请求时恢复的 panic 次数", "method")
+
+// 捕获处理过程中的 panic，返回 500 并打印堆栈，避免单个请求拖垮整个进程。
+// http.ErrAbortHandler 是主动中断请求，继续向上抛出
+func Recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			panicsRecovered.Inc(req.Method)
+			SetLogField(req, "panic", fmt.Sprint(err))
+			fmt.Println("panic recovered:", err, string(debug.Stack()))
+			w.WriteHeader(http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
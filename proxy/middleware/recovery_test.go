@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	h := Recovery(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	}))
+	if rec := doRequest(h, "10.0.0.1:1", nil); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if panicsRecovered.Get("GET") == 0 {
+		t.Fatal("panic not counted")
+	}
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Fatalf("ErrAbortHandler should propagate, got %v", err)
+		}
+	}()
+	h = Recovery(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	doRequest(h, "10.0.0.1:1", nil)
+}
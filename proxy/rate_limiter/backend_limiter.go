@@ -0,0 +1,106 @@
+package rate_limiter
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/registry"
+	"strconv"
+	"sync"
+)
+
+// 后端被限流时的处理方式
+type BackendLimitMode int
+
+const (
+	BackendLimitReselect BackendLimitMode = iota //重新选择其他后端
+	BackendLimitShed                             //直接返回503
+)
+
+// 按后端地址限流，保护处理能力有限的后端，未配置的地址不限流
+type BackendLimiter struct {
+	Mode BackendLimitMode
+
+	mux      sync.RWMutex
+	buckets  map[string]*TokenBucket
+	fromConf map[string]bool //由配置元数据设置的限流，Update 时按配置重建
+	//观察主体
+	conf load_balance.LoadBalanceConf
+}
+
+func NewBackendLimiter(mode BackendLimitMode) *BackendLimiter {
+	return &BackendLimiter{Mode: mode, buckets: map[string]*TokenBucket{}, fromConf: map[string]bool{}}
+}
+
+// 设置或调整某个后端的限流，rate<=0 表示取消限流。手动设置的限流不随配置更新删除，
+// 配置元数据中有同一后端的 qps 时以配置为准
+func (l *BackendLimiter) SetLimit(addr string, rate float64, burst int) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	delete(l.fromConf, addr)
+	if rate <= 0 {
+		delete(l.buckets, addr)
+		return
+	}
+	if b, ok := l.buckets[addr]; ok {
+		b.SetLimit(rate, burst)
+		return
+	}
+	l.buckets[addr] = NewTokenBucket(rate, burst)
+}
+
+func (l *BackendLimiter) Allow(addr string) bool {
+	l.mux.RLock()
+	b, ok := l.buckets[addr]
+	l.mux.RUnlock()
+	if !ok {
+		return true
+	}
+	return b.Allow()
+}
+
+// 后端当前是否已被限流，不消耗令牌
+func (l *BackendLimiter) Limited(addr string) bool {
+	l.mux.RLock()
+	b, ok := l.buckets[addr]
+	l.mux.RUnlock()
+	return ok && b.Remaining() == 0
+}
+
+func (l *BackendLimiter) SetConf(conf load_balance.LoadBalanceConf) {
+	l.conf = conf
+}
+
+// 从注册中心后端元数据 registry.MetadataQPS 读取限流值，只有提供 load_balance.BackendConf 的配置主题有元数据。
+// 按配置重建限流表：已存在的令牌桶保留令牌状态，qps 被删除或已离开配置的后端取消限流
+func (l *BackendLimiter) Update() {
+	bc, ok := l.conf.(load_balance.BackendConf)
+	if !ok {
+		return
+	}
+	limits := map[string]float64{}
+	for _, b := range bc.Backends() {
+		qps, err := strconv.ParseFloat(b.Metadata[registry.MetadataQPS], 64)
+		if err != nil || qps <= 0 {
+			continue
+		}
+		limits[bc.BackendAddr(b.Addr)] = qps
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	buckets := make(map[string]*TokenBucket, len(limits))
+	fromConf := make(map[string]bool, len(limits))
+	for addr, b := range l.buckets {
+		if !l.fromConf[addr] {
+			buckets[addr] = b
+		}
+	}
+	for addr, qps := range limits {
+		if b, ok := l.buckets[addr]; ok {
+			b.SetLimit(qps, 0)
+			buckets[addr] = b
+		} else {
+			buckets[addr] = NewTokenBucket(qps, 0)
+		}
+		fromConf[addr] = true
+	}
+	l.buckets, l.fromConf = buckets, fromConf
+}
@@ -0,0 +1,91 @@
+package rate_limiter
+
+import (
+	"GO_GATEWAY/proxy/load_balance"
+	"GO_GATEWAY/proxy/registry"
+	"testing"
+)
+
+// 只提供 Backends 的配置主题
+type backendConf struct {
+	load_balance.LoadBalanceConf
+	backends []registry.Backend
+}
+
+func (c *backendConf) Backends() []registry.Backend {
+	return c.backends
+}
+
+func (c *backendConf) BackendAddr(addr string) string {
+	return "http://" + addr
+}
+
+func qpsBackend(addr, qps string) registry.Backend {
+	return registry.Backend{Addr: addr, Metadata: map[string]string{registry.MetadataQPS: qps}}
+}
+
+func TestBackendLimiterConfUpdate(t *testing.T) {
+	conf := &backendConf{backends: []registry.Backend{
+		qpsBackend("10.0.0.1:80", "0.001"),
+		qpsBackend("10.0.0.2:80", "bad"),
+		{Addr: "10.0.0.3:80"},
+	}}
+	l := NewBackendLimiter(BackendLimitShed)
+	l.SetConf(conf)
+	l.Update()
+	if !l.Allow("http://10.0.0.1:80") || l.Allow("http://10.0.0.1:80") {
+		t.Fatal("10.0.0.1 should be limited to one request")
+	}
+	for _, addr := range []string{"http://10.0.0.2:80", "http://10.0.0.3:80"} {
+		for i := 0; i < 3; i++ {
+			if !l.Allow(addr) {
+				t.Fatalf("%s should not be limited", addr)
+			}
+		}
+	}
+
+	//再次更新保留令牌状态
+	l.Update()
+	if l.Allow("http://10.0.0.1:80") {
+		t.Fatal("token state lost on update")
+	}
+
+	//qps 被删除后取消限流
+	conf.backends = []registry.Backend{{Addr: "10.0.0.1:80"}}
+	l.Update()
+	if !l.Allow("http://10.0.0.1:80") || len(l.buckets) != 0 {
+		t.Fatalf("stale limit kept, buckets %v", l.buckets)
+	}
+}
+
+func TestBackendLimiterConfKeepsManualLimits(t *testing.T) {
+	conf := &backendConf{backends: []registry.Backend{qpsBackend("10.0.0.1:80", "5")}}
+	l := NewBackendLimiter(BackendLimitShed)
+	l.SetConf(conf)
+	l.SetLimit("http://10.0.0.9:80", 0.001, 1)
+	l.Update()
+	conf.backends = nil
+	l.Update()
+	if _, ok := l.buckets["http://10.0.0.1:80"]; ok {
+		t.Fatal("backend left the conf but limit kept")
+	}
+	if !l.Allow("http://10.0.0.9:80") || l.Allow("http://10.0.0.9:80") {
+		t.Fatal("manual limit should survive conf updates")
+	}
+}
+
+// 没有元数据的配置主题不影响限流
+func TestBackendLimiterPlainConf(t *testing.T) {
+	conf, err := load_balance.NewLoadBalanceCheckConf("http://%s", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conf.Close()
+	l := NewBackendLimiter(BackendLimitShed)
+	l.SetLimit("http://10.0.0.1:80", 0.001, 1)
+	l.SetConf(conf)
+	l.Update()
+	if !l.Allow("http://10.0.0.1:80") || l.Allow("http://10.0.0.1:80") {
+		t.Fatal("manual limit changed by conf without metadata")
+	}
+}
@@ -0,0 +1,104 @@
+package rate_limiter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxKeys     = 10000
+	DefaultIdleTimeout = 10 * time.Minute
+)
+
+// 按 key 限流，内部用 LRU 保存每个 key 的令牌桶，保证 key 数量有上限
+type KeyedLimiter struct {
+	mux         sync.Mutex
+	rate        float64
+	burst       int
+	maxKeys     int
+	idleTimeout time.Duration
+	ll          *list.List
+	items       map[string]*list.Element
+	now         func() time.Time
+}
+
+type keyedEntry struct {
+	key    string
+	bucket *TokenBucket
+}
+
+func NewKeyedLimiter(rate float64, burst, maxKeys int, idleTimeout time.Duration) *KeyedLimiter {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	return &KeyedLimiter{
+		rate:        rate,
+		burst:       burst,
+		maxKeys:     maxKeys,
+		idleTimeout: idleTimeout,
+		ll:          list.New(),
+		items:       map[string]*list.Element{},
+		now:         time.Now,
+	}
+}
+
+// 获取 key 对应的令牌桶，不存在则创建，并按 LRU 淘汰
+func (k *KeyedLimiter) Get(key string) *TokenBucket {
+	return k.GetWithLimit(key, k.rate, k.burst)
+}
+
+// 同 Get，但新建的令牌桶使用指定的速率，已存在的桶会被调整到该速率
+func (k *KeyedLimiter) GetWithLimit(key string, rate float64, burst int) *TokenBucket {
+	burst = normalizeBurst(rate, burst)
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if ele, ok := k.items[key]; ok {
+		k.ll.MoveToFront(ele)
+		b := ele.Value.(*keyedEntry).bucket
+		if b.Rate() != rate || b.Limit() != burst {
+			b.SetLimit(rate, burst)
+		}
+		return b
+	}
+	k.evictIdle()
+	for k.ll.Len() >= k.maxKeys {
+		k.removeElement(k.ll.Back())
+	}
+	b := newTokenBucketWithClock(rate, burst, k.now)
+	k.items[key] = k.ll.PushFront(&keyedEntry{key: key, bucket: b})
+	return b
+}
+
+func (k *KeyedLimiter) Allow(key string) bool {
+	return k.Get(key).Allow()
+}
+
+// 当前跟踪的 key 数量
+func (k *KeyedLimiter) Len() int {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	return k.ll.Len()
+}
+
+// 从链表尾部开始淘汰空闲过久的 key，调用方需持有锁
+func (k *KeyedLimiter) evictIdle() {
+	deadline := k.now().Add(-k.idleTimeout)
+	for ele := k.ll.Back(); ele != nil; ele = k.ll.Back() {
+		if ele.Value.(*keyedEntry).bucket.lastSeen().After(deadline) {
+			return
+		}
+		k.removeElement(ele)
+	}
+}
+
+func (k *KeyedLimiter) removeElement(ele *list.Element) {
+	if ele == nil {
+		return
+	}
+	k.ll.Remove(ele)
+	delete(k.items, ele.Value.(*keyedEntry).key)
+}
@@ -0,0 +1,37 @@
+package rate_limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiterLRUEviction(t *testing.T) {
+	kl := NewKeyedLimiter(1, 1, 2, time.Hour)
+	kl.Allow("a")
+	kl.Allow("b")
+	kl.Get("a") //a 变为最近使用
+	kl.Allow("c")
+	if kl.Len() != 2 {
+		t.Fatalf("len = %d, want 2", kl.Len())
+	}
+	if _, ok := kl.items["b"]; ok {
+		t.Fatal("b should be evicted as least recently used")
+	}
+	//a 的令牌已用完，未被淘汰说明状态仍在
+	if kl.Allow("a") {
+		t.Fatal("a should still be limited")
+	}
+}
+
+func TestKeyedLimiterIdleEviction(t *testing.T) {
+	now := time.Now()
+	kl := NewKeyedLimiter(1, 1, 10, time.Minute)
+	kl.now = func() time.Time { return now }
+	kl.Allow("a")
+	kl.Allow("b")
+	now = now.Add(2 * time.Minute)
+	kl.Allow("c")
+	if kl.Len() != 1 {
+		t.Fatalf("idle keys should be evicted, len = %d", kl.Len())
+	}
+}
@@ -0,0 +1,123 @@
+package rate_limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 令牌桶：rate 为每秒补充的令牌数，burst 为桶容量
+type TokenBucket struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return newTokenBucketWithClock(rate, burst, time.Now)
+}
+
+func newTokenBucketWithClock(rate float64, burst int, now func() time.Time) *TokenBucket {
+	burst = normalizeBurst(rate, burst)
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+		now:    now,
+	}
+}
+
+// 未指定容量时按一秒的令牌数计算，至少为1
+func normalizeBurst(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(rate)))
+}
+
+// 按时间差补充令牌，调用方需持有锁
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// 尝试取一个令牌
+func (b *TokenBucket) Allow() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.refill(b.now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// 当前剩余令牌数（向下取整）
+func (b *TokenBucket) Remaining() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.refill(b.now())
+	return int(b.tokens)
+}
+
+// 桶被填满还需要的时间
+func (b *TokenBucket) Reset() time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.refill(b.now())
+	if b.rate <= 0 || b.tokens >= b.burst {
+		return 0
+	}
+	return time.Duration((b.burst - b.tokens) / b.rate * float64(time.Second))
+}
+
+// 下一个令牌可用还需要的时间，当前有令牌时为0
+func (b *TokenBucket) NextToken() time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.refill(b.now())
+	if b.rate <= 0 || b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *TokenBucket) Limit() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return int(b.burst)
+}
+
+func (b *TokenBucket) Rate() float64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.rate
+}
+
+// 运行时调整速率与容量
+func (b *TokenBucket) SetLimit(rate float64, burst int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.refill(b.now())
+	burst = normalizeBurst(rate, burst)
+	b.rate = rate
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// 最近一次被访问的时间，用于空闲淘汰
+func (b *TokenBucket) lastSeen() time.Time {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.last
+}
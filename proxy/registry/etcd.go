@@ -0,0 +1,216 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcd 调用的超时时间与租约失效后重新注册前的等待时间
+const (
+	etcdCallTimeout   = 5 * time.Second
+	etcdRetryInterval = time.Second
+)
+
+// 基于 etcd 的注册中心：prefix 下每个 key 为一个后端(prefix+地址)，值为 Backend 的 JSON。
+// 带租约注册的 key 在后端停止续约后被 etcd 删除，watch 收到删除事件后从列表中移除
+type EtcdRegistry struct {
+	client *clientv3.Client
+	prefix string
+}
+
+func NewEtcdRegistry(client *clientv3.Client, prefix string) *EtcdRegistry {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &EtcdRegistry{client: client, prefix: prefix}
+}
+
+func (e *EtcdRegistry) List() ([]Backend, error) {
+	list, _, err := e.list(context.Background())
+	return list, err
+}
+
+// 返回列表与读取时的版本，watch 从下一个版本开始，不会漏掉变化
+func (e *EtcdRegistry) list(ctx context.Context) ([]Backend, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, etcdCallTimeout)
+	defer cancel()
+	resp, err := e.client.Get(ctx, e.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	list := []Backend{}
+	for _, kv := range resp.Kvs {
+		if b, ok := e.parse(kv.Key, kv.Value); ok {
+			list = append(list, b)
+		}
+	}
+	SortBackends(list)
+	return list, resp.Header.Revision, nil
+}
+
+// 值无法解析时只使用 key 中的地址
+func (e *EtcdRegistry) parse(key, value []byte) (Backend, bool) {
+	addr := strings.TrimPrefix(string(key), e.prefix)
+	if addr == "" || strings.Contains(addr, "/") {
+		return Backend{}, false
+	}
+	var b Backend
+	if err := json.Unmarshal(value, &b); err != nil {
+		fmt.Println("etcd backend value error", string(key), err)
+	}
+	b.Addr = addr
+	return b, true
+}
+
+func (e *EtcdRegistry) Watch(ctx context.Context) (<-chan []Backend, error) {
+	list, rev, err := e.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Backend, 1)
+	ch <- list
+	go func() {
+		defer close(ch)
+		for {
+			events := e.client.Watch(clientv3.WithRequireLeader(ctx), e.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+			for resp := range events {
+				if resp.Err() != nil {
+					fmt.Println("etcd watch error", e.prefix, resp.Err())
+					break
+				}
+				if len(resp.Events) == 0 {
+					continue
+				}
+				var err error
+				if list, rev, err = e.list(ctx); err != nil {
+					fmt.Println("etcd list error", e.prefix, err)
+					break
+				}
+				//只保留最新的列表
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- list
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(etcdRetryInterval):
+			}
+			//压缩或出错后重新获取列表
+			latest, latestRev, err := e.list(ctx)
+			if err != nil {
+				fmt.Println("etcd list error", e.prefix, err)
+				continue
+			}
+			rev = latestRev
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- latest
+		}
+	}()
+	return ch, nil
+}
+
+// 不带租约的注册，后端需要自己注销；后端服务应使用 RegisterWithLease
+func (e *EtcdRegistry) Register(backend Backend) error {
+	data, err := json.Marshal(backend)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdCallTimeout)
+	defer cancel()
+	_, err = e.client.Put(ctx, e.prefix+backend.Addr, string(data))
+	return err
+}
+
+func (e *EtcdRegistry) Deregister(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdCallTimeout)
+	defer cancel()
+	_, err := e.client.Delete(ctx, e.prefix+addr)
+	return err
+}
+
+// 以 ttl 租约把 backend 注册到 keyPrefix 下，并在后台续约，进程退出或失联时 key 在 ttl 后自动删除。
+// 续约中断(如租约已过期)时重新申请租约并写入。ctx 结束时撤销租约，之后 done 关闭
+func RegisterWithLease(ctx context.Context, client *clientv3.Client, keyPrefix string, backend Backend, ttl time.Duration) (done <-chan struct{}, err error) {
+	if backend.Addr == "" {
+		return nil, fmt.Errorf("backend addr required")
+	}
+	if !strings.HasSuffix(keyPrefix, "/") {
+		keyPrefix += "/"
+	}
+	data, err := json.Marshal(backend)
+	if err != nil {
+		return nil, err
+	}
+	l := &etcdLease{client: client, key: keyPrefix + backend.Addr, value: string(data), ttl: int64((ttl + time.Second - 1) / time.Second)}
+	lease, err := l.grantAndPut(ctx)
+	if err != nil {
+		return nil, err
+	}
+	finished := make(chan struct{})
+	go l.keepAlive(ctx, lease, finished)
+	return finished, nil
+}
+
+type etcdLease struct {
+	client *clientv3.Client
+	key    string
+	value  string
+	ttl    int64 //秒
+}
+
+func (l *etcdLease) grantAndPut(ctx context.Context) (clientv3.LeaseID, error) {
+	ctx, cancel := context.WithTimeout(ctx, etcdCallTimeout)
+	defer cancel()
+	grant, err := l.client.Grant(ctx, l.ttl)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := l.client.Put(ctx, l.key, l.value, clientv3.WithLease(grant.ID)); err != nil {
+		return 0, err
+	}
+	return grant.ID, nil
+}
+
+func (l *etcdLease) keepAlive(ctx context.Context, lease clientv3.LeaseID, finished chan struct{}) {
+	defer close(finished)
+	for {
+		if lease != 0 {
+			responses, err := l.client.KeepAlive(ctx, lease)
+			if err == nil {
+				for range responses {
+				}
+			}
+			if ctx.Err() != nil {
+				//ctx 已结束，用新的 ctx 撤销
+				revokeCtx, cancel := context.WithTimeout(context.Background(), etcdCallTimeout)
+				if _, err := l.client.Revoke(revokeCtx, lease); err != nil {
+					fmt.Println("etcd revoke lease error", l.key, err)
+				}
+				cancel()
+				return
+			}
+			fmt.Println("etcd keepalive closed, re-register", l.key, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(etcdRetryInterval):
+		}
+		var err error
+		if lease, err = l.grantAndPut(ctx); err != nil {
+			fmt.Println("etcd re-register error", l.key, err)
+			lease = 0
+		}
+	}
+}
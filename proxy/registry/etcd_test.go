@@ -0,0 +1,159 @@
+package registry_test
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"GO_GATEWAY/proxy/registry/registrytest"
+	"context"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// 启动嵌入式 etcd，测试结束时关闭
+func startEtcd(t *testing.T) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls = []url.URL{{Scheme: "http", Host: "127.0.0.1:0"}}
+	cfg.ListenPeerUrls = []url.URL{{Scheme: "http", Host: "127.0.0.1:0"}}
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd not ready")
+	}
+	return e.Clients[0].Addr().String()
+}
+
+func newEtcdClient(t *testing.T, endpoint string) *clientv3.Client {
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestEtcdConformance(t *testing.T) {
+	client := newEtcdClient(t, startEtcd(t))
+	var n int64
+	registrytest.Run(t, func(t *testing.T) registry.Registry {
+		return registry.NewEtcdRegistry(client, fmt.Sprintf("/gateway_servers_%d", atomic.AddInt64(&n, 1)))
+	})
+}
+
+func waitEtcdList(t *testing.T, ch <-chan []registry.Backend, timeout time.Duration, want int) []registry.Backend {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case list := <-ch:
+			if len(list) == want {
+				return list
+			}
+		case <-deadline:
+			t.Fatalf("watch did not reach %d backends", want)
+		}
+	}
+}
+
+func TestEtcdLeaseRevoke(t *testing.T) {
+	endpoint := startEtcd(t)
+	r := registry.NewEtcdRegistry(newEtcdClient(t, endpoint), "/gateway_servers_orders")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := r.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEtcdList(t, watch, time.Second, 0)
+
+	regCtx, stop := context.WithCancel(context.Background())
+	backend := registry.Backend{Addr: "127.0.0.1:8001", Weight: 20, Metadata: map[string]string{registry.MetadataZone: "az1"}}
+	done, err := registry.RegisterWithLease(regCtx, newEtcdClient(t, endpoint), "/gateway_servers_orders", backend, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := waitEtcdList(t, watch, 2*time.Second, 1)
+	if list[0].Addr != backend.Addr || list[0].Weight != 20 || list[0].Metadata[registry.MetadataZone] != "az1" {
+		t.Fatalf("registered backend %+v", list[0])
+	}
+
+	//撤销租约后立即删除，不需要等待 ttl
+	stop()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("registration not finished")
+	}
+	waitEtcdList(t, watch, 2*time.Second, 0)
+}
+
+func TestEtcdLeaseExpiresOnCrash(t *testing.T) {
+	endpoint := startEtcd(t)
+	r := registry.NewEtcdRegistry(newEtcdClient(t, endpoint), "/gateway_servers_orders")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := r.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEtcdList(t, watch, time.Second, 0)
+
+	//关闭注册方的连接模拟进程崩溃，租约不会被撤销
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.RegisterWithLease(context.Background(), client, "/gateway_servers_orders", registry.Backend{Addr: "127.0.0.1:8001"}, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitEtcdList(t, watch, 2*time.Second, 1)
+	client.Close()
+	start := time.Now()
+	waitEtcdList(t, watch, 10*time.Second, 0)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("backend removed after %v, before lease expiry", elapsed)
+	}
+}
+
+func TestEtcdLeaseRegrant(t *testing.T) {
+	endpoint := startEtcd(t)
+	client := newEtcdClient(t, endpoint)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done, err := registry.RegisterWithLease(ctx, newEtcdClient(t, endpoint), "/gateway_servers_orders", registry.Backend{Addr: "127.0.0.1:8001"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(ctx, "/gateway_servers_orders/127.0.0.1:8001")
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("get %v %v", resp, err)
+	}
+	lease := clientv3.LeaseID(resp.Kvs[0].Lease)
+	//租约被外部撤销后重新申请并写入
+	if _, err := client.Revoke(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get(ctx, "/gateway_servers_orders/127.0.0.1:8001")
+		if err == nil && len(resp.Kvs) == 1 && clientv3.LeaseID(resp.Kvs[0].Lease) != lease {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lease not re-granted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
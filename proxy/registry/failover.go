@@ -0,0 +1,315 @@
+package registry
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 故障切换的默认设置
+const (
+	DefaultFailoverProbeInterval = 5 * time.Second
+	DefaultFailoverThreshold     = 3
+	DefaultFailoverHoldDown      = 30 * time.Second
+)
+
+// 为 1 表示该来源正在提供后端列表，按来源名称统计
+var failoverActive = metrics.NewGaugeVec("gateway_registry_failover_active", "故障切换注册中心当前使用的来源，正在使用为 1", "source")
+
+// Failover 的一个来源，名称用于指标与管理接口，需要全局唯一
+type FailoverSource struct {
+	Name     string
+	Registry Registry
+}
+
+type FailoverOptions struct {
+	ProbeInterval time.Duration //探测各来源的间隔，默认 DefaultFailoverProbeInterval
+	ProbeTimeout  time.Duration //单次探测的超时时间，默认与 ProbeInterval 相同
+	Threshold     int           //连续失败该次数后认为来源故障，默认 DefaultFailoverThreshold
+	HoldDown      time.Duration //更高优先级的来源恢复后需要持续健康该时间才切回，默认 DefaultFailoverHoldDown
+}
+
+// 来源的健康状态
+type FailoverSourceStatus struct {
+	Name     string `json:"name"`
+	Healthy  bool   `json:"healthy"`
+	Failures int    `json:"failures"` //连续探测失败次数
+}
+
+type FailoverStatus struct {
+	Active  string                 `json:"active"`
+	Sources []FailoverSourceStatus `json:"sources"`
+}
+
+// 按优先级排列的多个注册中心，只发布当前使用的来源的列表：当前来源故障时切换到优先级最高的健康来源，
+// 更高优先级的来源恢复并持续健康 HoldDown 后切回，避免来回切换。只读，不支持注册
+type Failover struct {
+	sources []FailoverSource
+	opts    FailoverOptions
+
+	mux          sync.RWMutex
+	active       int
+	healthy      []bool
+	failures     []int
+	healthySince []time.Time
+	latest       [][]Backend //各来源最近的列表
+}
+
+func NewFailover(opts FailoverOptions, sources ...FailoverSource) *Failover {
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = DefaultFailoverProbeInterval
+	}
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = opts.ProbeInterval
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultFailoverThreshold
+	}
+	if opts.HoldDown <= 0 {
+		opts.HoldDown = DefaultFailoverHoldDown
+	}
+	f := &Failover{
+		sources:      sources,
+		opts:         opts,
+		healthy:      make([]bool, len(sources)),
+		failures:     make([]int, len(sources)),
+		healthySince: make([]time.Time, len(sources)),
+		latest:       make([][]Backend, len(sources)),
+	}
+	for i := range sources {
+		f.healthy[i] = true
+	}
+	f.setActive(0)
+	return f
+}
+
+// 当前使用的来源的列表
+func (f *Failover) List() ([]Backend, error) {
+	if len(f.sources) == 0 {
+		return nil, errors.New("failover registry has no source")
+	}
+	f.mux.RLock()
+	r := f.sources[f.active].Registry
+	f.mux.RUnlock()
+	return r.List()
+}
+
+// 当前使用的来源名称
+func (f *Failover) Active() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	if len(f.sources) == 0 {
+		return ""
+	}
+	return f.sources[f.active].Name
+}
+
+func (f *Failover) Status() FailoverStatus {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	status := FailoverStatus{Sources: make([]FailoverSourceStatus, 0, len(f.sources))}
+	if len(f.sources) > 0 {
+		status.Active = f.sources[f.active].Name
+	}
+	for i, source := range f.sources {
+		status.Sources = append(status.Sources, FailoverSourceStatus{Name: source.Name, Healthy: f.healthy[i], Failures: f.failures[i]})
+	}
+	return status
+}
+
+func (f *Failover) setActive(active int) {
+	f.active = active
+	for i, source := range f.sources {
+		if i == active {
+			failoverActive.Set(source.Name, 1)
+		} else {
+			failoverActive.Set(source.Name, 0)
+		}
+	}
+}
+
+type failoverUpdate struct {
+	index int
+	list  []Backend
+}
+
+// 先发送优先级最高的可用来源的列表，之后当前来源变化或切换来源时发送完整列表。
+// 所有来源都不可用时返回错误。同一个 Failover 只能 Watch 一次
+func (f *Failover) Watch(ctx context.Context) (<-chan []Backend, error) {
+	if len(f.sources) == 0 {
+		return nil, errors.New("failover registry has no source")
+	}
+	var initial []Backend
+	var lastErr error
+	f.mux.Lock()
+	for i, source := range f.sources {
+		list, err := listTimeout(source.Registry, f.opts.ProbeTimeout)
+		if err != nil {
+			//启动时不可用的来源恢复后同样需要经过 HoldDown
+			f.healthy[i], f.failures[i] = false, 1
+			lastErr = err
+			continue
+		}
+		f.latest[i] = list
+		if initial == nil {
+			initial = list
+			f.setActive(i)
+		}
+	}
+	f.mux.Unlock()
+	if initial == nil {
+		return nil, lastErr
+	}
+
+	updates := make(chan failoverUpdate)
+	for i, source := range f.sources {
+		go f.watchSource(ctx, i, source.Registry, updates)
+	}
+	out := make(chan []Backend, 1)
+	out <- initial
+	go f.run(ctx, updates, out, initial)
+	return out, nil
+}
+
+// watch 一个来源，出错或 channel 关闭后按探测间隔重试
+func (f *Failover) watchSource(ctx context.Context, index int, r Registry, updates chan<- failoverUpdate) {
+	for {
+		ch, err := r.Watch(ctx)
+		if err != nil {
+			fmt.Println("failover watch error", f.sources[index].Name, err)
+		} else {
+			for list := range ch {
+				select {
+				case updates <- failoverUpdate{index: index, list: list}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.opts.ProbeInterval):
+		}
+	}
+}
+
+func (f *Failover) run(ctx context.Context, updates <-chan failoverUpdate, out chan []Backend, last []Backend) {
+	defer close(out)
+	ticker := time.NewTicker(f.opts.ProbeInterval)
+	defer ticker.Stop()
+	send := func(list []Backend) {
+		if reflect.DeepEqual(list, last) {
+			return
+		}
+		last = list
+		select {
+		case <-out:
+		default:
+		}
+		out <- list
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-updates:
+			f.mux.Lock()
+			f.latest[u.index] = u.list
+			active := u.index == f.active
+			f.mux.Unlock()
+			if active {
+				send(u.list)
+			}
+		case <-ticker.C:
+			if list, switched := f.probe(); switched {
+				send(list)
+			}
+		}
+	}
+}
+
+// 并发探测所有来源并更新健康状态，需要切换来源时返回新来源的列表
+func (f *Failover) probe() ([]Backend, bool) {
+	lists := make([][]Backend, len(f.sources))
+	errs := make([]error, len(f.sources))
+	var wg sync.WaitGroup
+	for i, source := range f.sources {
+		wg.Add(1)
+		go func(i int, r Registry) {
+			defer wg.Done()
+			lists[i], errs[i] = listTimeout(r, f.opts.ProbeTimeout)
+		}(i, source.Registry)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for i, err := range errs {
+		if err != nil {
+			f.failures[i]++
+			if f.healthy[i] && f.failures[i] >= f.opts.Threshold {
+				f.healthy[i] = false
+				fmt.Println("failover source unavailable", f.sources[i].Name, err)
+			}
+			continue
+		}
+		f.failures[i] = 0
+		f.latest[i] = lists[i]
+		if !f.healthy[i] {
+			f.healthy[i], f.healthySince[i] = true, now
+			fmt.Println("failover source recovered", f.sources[i].Name)
+		}
+	}
+	next := f.active
+	for i := range f.sources {
+		if !f.healthy[i] {
+			continue
+		}
+		//当前来源故障时立即切换；当前来源健康时，切回更高优先级的来源需要经过 HoldDown
+		if i < f.active && f.healthy[f.active] && now.Sub(f.healthySince[i]) < f.opts.HoldDown {
+			continue
+		}
+		if i < f.active || !f.healthy[f.active] {
+			next = i
+		}
+		break
+	}
+	if next == f.active {
+		return nil, false
+	}
+	fmt.Println("failover switch", f.sources[f.active].Name, "->", f.sources[next].Name)
+	f.setActive(next)
+	return f.latest[next], true
+}
+
+func (f *Failover) Register(backend Backend) error {
+	return errors.New("failover registry is read only")
+}
+
+func (f *Failover) Deregister(addr string) error {
+	return errors.New("failover registry is read only")
+}
+
+func listTimeout(r Registry, timeout time.Duration) ([]Backend, error) {
+	type result struct {
+		list []Backend
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		list, err := r.List()
+		ch <- result{list, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.list, res.err
+	case <-time.After(timeout):
+		return nil, errors.New("registry list timeout")
+	}
+}
@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 可以模拟故障的注册中心，故障时 List 返回错误
+type downRegistry struct {
+	*Memory
+	down atomic.Bool
+}
+
+func (r *downRegistry) List() ([]Backend, error) {
+	if r.down.Load() {
+		return nil, errors.New("registry down")
+	}
+	return r.Memory.List()
+}
+
+func newDownRegistry(addrs ...string) *downRegistry {
+	r := &downRegistry{Memory: NewMemory()}
+	for _, addr := range addrs {
+		r.Register(Backend{Addr: addr})
+	}
+	return r
+}
+
+func waitFailoverList(t *testing.T, ch <-chan []Backend, want []Backend) time.Time {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v want %+v", got, want)
+		}
+		return time.Now()
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no update, want %+v", want)
+	}
+	return time.Time{}
+}
+
+func TestFailoverSwitchesWithHoldDown(t *testing.T) {
+	primary, secondary := newDownRegistry("127.0.0.1:8001"), newDownRegistry("127.0.0.1:8002")
+	primaryList, _ := primary.Memory.List()
+	secondaryList, _ := secondary.Memory.List()
+	holdDown := 150 * time.Millisecond
+	f := NewFailover(FailoverOptions{ProbeInterval: 10 * time.Millisecond, Threshold: 2, HoldDown: holdDown},
+		FailoverSource{Name: "zk", Registry: primary},
+		FailoverSource{Name: "file", Registry: secondary},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := f.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFailoverList(t, ch, primaryList)
+	if f.Active() != "zk" || failoverActive.Get("zk") != 1 || failoverActive.Get("file") != 0 {
+		t.Fatalf("active %s", f.Active())
+	}
+
+	//备用来源的变化在主来源可用时不发布
+	secondary.Register(Backend{Addr: "127.0.0.1:8003"})
+	secondaryList, _ = secondary.Memory.List()
+	select {
+	case got := <-ch:
+		t.Fatalf("published secondary list %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	primary.down.Store(true)
+	waitFailoverList(t, ch, secondaryList)
+	if f.Active() != "file" || failoverActive.Get("zk") != 0 || failoverActive.Get("file") != 1 {
+		t.Fatalf("active %s", f.Active())
+	}
+	status := f.Status()
+	if status.Sources[0].Healthy || status.Sources[0].Failures < 2 || !status.Sources[1].Healthy {
+		t.Fatalf("status %+v", status)
+	}
+
+	//主来源恢复后持续健康 HoldDown 才切回
+	recovered := time.Now()
+	primary.down.Store(false)
+	switched := waitFailoverList(t, ch, primaryList)
+	if elapsed := switched.Sub(recovered); elapsed < holdDown {
+		t.Fatalf("switched back after %v, hold down %v", elapsed, holdDown)
+	}
+	if f.Active() != "zk" || failoverActive.Get("zk") != 1 || failoverActive.Get("file") != 0 {
+		t.Fatalf("active %s", f.Active())
+	}
+}
+
+func TestFailoverFlapWithinHoldDown(t *testing.T) {
+	primary, secondary := newDownRegistry("127.0.0.1:8001"), newDownRegistry("127.0.0.1:8002")
+	secondaryList, _ := secondary.Memory.List()
+	f := NewFailover(FailoverOptions{ProbeInterval: 10 * time.Millisecond, Threshold: 1, HoldDown: time.Hour},
+		FailoverSource{Name: "zk", Registry: primary},
+		FailoverSource{Name: "dns", Registry: secondary},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := f.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	primary.down.Store(true)
+	waitFailoverList(t, ch, secondaryList)
+
+	//主来源反复恢复与故障时一直使用备用来源
+	for i := 0; i < 5; i++ {
+		primary.down.Store(i%2 == 1)
+		time.Sleep(30 * time.Millisecond)
+	}
+	select {
+	case got := <-ch:
+		t.Fatalf("switched within hold down %+v", got)
+	default:
+	}
+	if f.Active() != "dns" {
+		t.Fatalf("active %s", f.Active())
+	}
+}
+
+func TestFailoverStartsOnSecondary(t *testing.T) {
+	primary, secondary := newDownRegistry("127.0.0.1:8001"), newDownRegistry("127.0.0.1:8002")
+	secondaryList, _ := secondary.Memory.List()
+	primary.down.Store(true)
+	f := NewFailover(FailoverOptions{ProbeInterval: 10 * time.Millisecond},
+		FailoverSource{Name: "zk", Registry: primary},
+		FailoverSource{Name: "file", Registry: secondary},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := f.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFailoverList(t, ch, secondaryList)
+	if f.Active() != "file" || f.Status().Sources[0].Healthy {
+		t.Fatalf("status %+v", f.Status())
+	}
+
+	secondary.down.Store(true)
+	if _, err := NewFailover(FailoverOptions{}, FailoverSource{Name: "zk", Registry: primary}, FailoverSource{Name: "file", Registry: secondary}).Watch(ctx); err == nil {
+		t.Fatal("expected error when all sources are down")
+	}
+	if f.Register(Backend{Addr: "127.0.0.1:8004"}) == nil {
+		t.Fatal("failover registry should be read only")
+	}
+}
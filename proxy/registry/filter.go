@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Backend.Metadata 中的健康状态与标签，标签以逗号分隔
+const (
+	MetadataHealth = "health"
+	MetadataTags   = "tags"
+)
+
+// 健康状态，没有 health 元数据的后端视为 passing
+const (
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+)
+
+// 按健康状态与标签表达式筛选后端。表达式支持 and、or、not 与括号，如 "primary and not canary"，
+// 为空时不按标签筛选。表达式在 NewFilter 时解析一次
+type Filter struct {
+	expr           string
+	includeWarning bool
+	match          tagExpr
+}
+
+func NewFilter(expr string, includeWarning bool) (*Filter, error) {
+	f := &Filter{expr: strings.TrimSpace(expr), includeWarning: includeWarning}
+	if f.expr == "" {
+		return f, nil
+	}
+	p := &tagParser{tokens: tokenizeTagExpr(f.expr)}
+	match, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag expression %q: %v", f.expr, err)
+	}
+	f.match = match
+	return f, nil
+}
+
+func (f *Filter) Expr() string {
+	return f.expr
+}
+
+func (f *Filter) IncludeWarning() bool {
+	return f.includeWarning
+}
+
+func (f *Filter) Match(b Backend) bool {
+	switch b.Metadata[MetadataHealth] {
+	case "", HealthPassing:
+	case HealthWarning:
+		if !f.includeWarning {
+			return false
+		}
+	default:
+		return false
+	}
+	if f.match == nil {
+		return true
+	}
+	tags := map[string]bool{}
+	for _, tag := range strings.Split(b.Metadata[MetadataTags], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags[tag] = true
+		}
+	}
+	return f.match(tags)
+}
+
+func (f *Filter) Apply(list []Backend) []Backend {
+	matched := make([]Backend, 0, len(list))
+	for _, b := range list {
+		if f.Match(b) {
+			matched = append(matched, b)
+		}
+	}
+	return matched
+}
+
+type tagExpr func(tags map[string]bool) bool
+
+func tokenizeTagExpr(expr string) []string {
+	var tokens []string
+	current := strings.Builder{}
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range expr {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// or := and {"or" and}；and := unary {"and" unary}；unary := "not" unary | "(" or ")" | 标签
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) parseOr() (tagExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(tags map[string]bool) bool { return l(tags) || right(tags) }
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseAnd() (tagExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(tags map[string]bool) bool { return l(tags) && right(tags) }
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseUnary() (tagExpr, error) {
+	token := p.peek()
+	p.pos++
+	switch token {
+	case "":
+		return nil, errors.New("unexpected end")
+	case "not":
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(tags map[string]bool) bool { return !inner(tags) }, nil
+	case "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return inner, nil
+	case ")", "and", "or":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	return func(tags map[string]bool) bool { return tags[token] }, nil
+}
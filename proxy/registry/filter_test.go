@@ -0,0 +1,53 @@
+package registry
+
+import "testing"
+
+func TestFilterTagExpr(t *testing.T) {
+	tagged := func(tags string) Backend {
+		return Backend{Addr: "127.0.0.1:8001", Metadata: map[string]string{MetadataTags: tags}}
+	}
+	for _, c := range []struct {
+		expr string
+		tags string
+		want bool
+	}{
+		{"", "", true},
+		{"primary", "primary, v2", true},
+		{"primary and not canary", "primary,canary", false},
+		{"primary and not canary", "primary", true},
+		{"canary or primary and v2", "canary", true},
+		{"(canary or primary) and v2", "canary", false},
+		{"not (a or b)", "c", true},
+		{"not not a", "a", true},
+	} {
+		f, err := NewFilter(c.expr, false)
+		if err != nil {
+			t.Fatalf("%q: %v", c.expr, err)
+		}
+		if got := f.Match(tagged(c.tags)); got != c.want {
+			t.Errorf("%q on %q = %v", c.expr, c.tags, got)
+		}
+	}
+	for _, expr := range []string{"primary and", "(primary", "primary)", "and canary", "primary canary", "not"} {
+		if _, err := NewFilter(expr, false); err == nil {
+			t.Errorf("%q should fail", expr)
+		}
+	}
+}
+
+func TestFilterHealth(t *testing.T) {
+	list := []Backend{
+		{Addr: "127.0.0.1:8001"},
+		{Addr: "127.0.0.1:8002", Metadata: map[string]string{MetadataHealth: HealthPassing}},
+		{Addr: "127.0.0.1:8003", Metadata: map[string]string{MetadataHealth: HealthWarning}},
+		{Addr: "127.0.0.1:8004", Metadata: map[string]string{MetadataHealth: HealthCritical}},
+	}
+	strict, _ := NewFilter("", false)
+	if got := strict.Apply(list); len(got) != 2 || got[1].Addr != "127.0.0.1:8002" {
+		t.Fatalf("strict %+v", got)
+	}
+	lenient, _ := NewFilter("", true)
+	if got := lenient.Apply(list); len(got) != 3 || got[2].Addr != "127.0.0.1:8003" {
+		t.Fatalf("include warning %+v", got)
+	}
+}
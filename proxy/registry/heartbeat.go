@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 心跳注册模式使用的 Metadata 键：最后一次心跳的时间(unix 毫秒)与过期时间(如 "10s")
+const (
+	MetadataHeartbeat = "heartbeat_at"
+	MetadataTTL       = "ttl"
+)
+
+// 返回带心跳信息的 backend 副本，心跳时间为 now
+func WithHeartbeat(backend Backend, ttl time.Duration, now time.Time) Backend {
+	meta := make(map[string]string, len(backend.Metadata)+2)
+	for k, v := range backend.Metadata {
+		meta[k] = v
+	}
+	meta[MetadataTTL] = ttl.String()
+	meta[MetadataHeartbeat] = strconv.FormatInt(now.UnixMilli(), 10)
+	backend.Metadata = meta
+	return backend
+}
+
+// 超过 TTL 没有心跳的后端。没有心跳信息的后端(依赖 zk 临时节点等机制)不会过期
+func Stale(backend Backend, now time.Time) bool {
+	ttl, err := time.ParseDuration(backend.Metadata[MetadataTTL])
+	if err != nil || ttl <= 0 {
+		return false
+	}
+	at, err := strconv.ParseInt(backend.Metadata[MetadataHeartbeat], 10, 64)
+	if err != nil {
+		return true
+	}
+	return now.Sub(time.UnixMilli(at)) > ttl
+}
+
+// 去掉过期的后端
+func FilterStale(list []Backend, now time.Time) []Backend {
+	out := make([]Backend, 0, len(list))
+	for _, b := range list {
+		if !Stale(b, now) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// 是否有心跳模式注册的后端，有时需要定期重新检查过期
+func HasHeartbeat(list []Backend) bool {
+	for _, b := range list {
+		if _, ok := b.Metadata[MetadataTTL]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 以心跳模式注册 backend，之后每隔 interval 更新心跳时间，直到 ctx 结束。
+// 退出时不注销，由网关在 TTL 后过滤掉；首次注册失败时返回错误
+func RunHeartbeat(ctx context.Context, r Registry, backend Backend, ttl, interval time.Duration) error {
+	if err := r.Register(WithHeartbeat(backend, ttl, time.Now())); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.Register(WithHeartbeat(backend, ttl, time.Now())); err != nil {
+				fmt.Println("registry heartbeat error", backend.Addr, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	fresh := WithHeartbeat(Backend{Addr: "127.0.0.1:8001", Metadata: map[string]string{MetadataZone: "hz-a"}}, 10*time.Second, now.Add(-5*time.Second))
+	stale := WithHeartbeat(Backend{Addr: "127.0.0.1:8002"}, 10*time.Second, now.Add(-11*time.Second))
+	ephemeral := Backend{Addr: "127.0.0.1:8003"}
+	broken := Backend{Addr: "127.0.0.1:8004", Metadata: map[string]string{MetadataTTL: "10s", MetadataHeartbeat: "x"}}
+	got := FilterStale([]Backend{fresh, stale, ephemeral, broken}, now)
+	if len(got) != 2 || got[0].Addr != "127.0.0.1:8001" || got[1].Addr != "127.0.0.1:8003" {
+		t.Fatalf("got %+v", got)
+	}
+	if fresh.Metadata[MetadataZone] != "hz-a" || !HasHeartbeat([]Backend{ephemeral, fresh}) || HasHeartbeat([]Backend{ephemeral}) {
+		t.Fatal("heartbeat metadata")
+	}
+}
+
+func TestRunHeartbeat(t *testing.T) {
+	r := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RunHeartbeat(ctx, r, Backend{Addr: "127.0.0.1:8001", Weight: 10}, 100*time.Millisecond, 10*time.Millisecond)
+	}()
+	heartbeat := func() string {
+		list, _ := r.List()
+		if len(list) != 1 {
+			return ""
+		}
+		return list[0].Metadata[MetadataHeartbeat]
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for heartbeat() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	//心跳时间持续更新
+	first := heartbeat()
+	for heartbeat() == first {
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat not updated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	//停止后不注销，超过 TTL 后过期
+	list, _ := r.List()
+	if len(list) != 1 || list[0].Weight != 10 || Stale(list[0], time.Now()) || !Stale(list[0], time.Now().Add(time.Second)) {
+		t.Fatalf("list %+v", list)
+	}
+}
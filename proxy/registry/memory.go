@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// 内存中的注册中心，用于测试
+type Memory struct {
+	mux      sync.Mutex
+	backends map[string]Backend
+	watchers map[chan []Backend]struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{backends: map[string]Backend{}, watchers: map[chan []Backend]struct{}{}}
+}
+
+func (m *Memory) List() ([]Backend, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.list(), nil
+}
+
+func (m *Memory) list() []Backend {
+	list := []Backend{}
+	for _, b := range m.backends {
+		list = append(list, b)
+	}
+	SortBackends(list)
+	return list
+}
+
+func (m *Memory) Watch(ctx context.Context) (<-chan []Backend, error) {
+	ch := make(chan []Backend, 1)
+	m.mux.Lock()
+	ch <- m.list()
+	m.watchers[ch] = struct{}{}
+	m.mux.Unlock()
+	go func() {
+		<-ctx.Done()
+		m.mux.Lock()
+		delete(m.watchers, ch)
+		close(ch)
+		m.mux.Unlock()
+	}()
+	return ch, nil
+}
+
+func (m *Memory) Register(backend Backend) error {
+	if backend.Addr == "" {
+		return errors.New("backend addr required")
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.backends[backend.Addr] = backend
+	m.notify()
+	return nil
+}
+
+func (m *Memory) Deregister(addr string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, ok := m.backends[addr]; !ok {
+		return nil
+	}
+	delete(m.backends, addr)
+	m.notify()
+	return nil
+}
+
+// 只保留最新的列表，watcher 处理慢时丢弃未读取的旧列表
+func (m *Memory) notify() {
+	list := m.list()
+	for ch := range m.watchers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- list
+	}
+}
@@ -0,0 +1,11 @@
+package registry_test
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"GO_GATEWAY/proxy/registry/registrytest"
+	"testing"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	registrytest.Run(t, func(t *testing.T) registry.Registry { return registry.NewMemory() })
+}
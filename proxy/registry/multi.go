@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"time"
+)
+
+// 多个来源的变化在该时间内合并为一次更新
+const DefaultMultiDebounce = 50 * time.Millisecond
+
+// Multi 的一个来源，如一个数据中心的 zk 路径
+type MultiSource struct {
+	Registry     Registry
+	WeightFactor float64 //权重乘数，0 表示 1；只作用于注册中心提供了权重的后端
+	Zone         string  //后端没有 zone 元数据时使用
+}
+
+// 合并多个注册中心的后端列表，同一地址出现在多个来源时以靠前的来源为准。只读，不支持注册
+type Multi struct {
+	sources  []MultiSource
+	debounce time.Duration
+}
+
+func NewMulti(sources ...MultiSource) *Multi {
+	return &Multi{sources: sources, debounce: DefaultMultiDebounce}
+}
+
+func (m *Multi) List() ([]Backend, error) {
+	lists := make([][]Backend, len(m.sources))
+	for i, source := range m.sources {
+		list, err := source.Registry.List()
+		if err != nil {
+			return nil, err
+		}
+		lists[i] = list
+	}
+	return m.merge(lists), nil
+}
+
+type multiUpdate struct {
+	index int
+	list  []Backend
+}
+
+// 先发送合并后的当前列表，之后任一来源变化时在去抖时间后发送合并的列表
+func (m *Multi) Watch(ctx context.Context) (<-chan []Backend, error) {
+	initial, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan multiUpdate)
+	for i, source := range m.sources {
+		ch, err := source.Registry.Watch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		go func(i int, ch <-chan []Backend) {
+			for list := range ch {
+				select {
+				case updates <- multiUpdate{index: i, list: list}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, ch)
+	}
+	out := make(chan []Backend, 1)
+	out <- initial
+	go func() {
+		defer close(out)
+		lists := make([][]Backend, len(m.sources))
+		received := make([]bool, len(m.sources))
+		last := initial
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u := <-updates:
+				lists[u.index], received[u.index] = u.list, true
+				if timer == nil {
+					timer = time.After(m.debounce)
+				}
+			case <-timer:
+				timer = nil
+				//每个来源都发送过首个列表后才合并
+				if !allReceived(received) {
+					continue
+				}
+				merged := m.merge(lists)
+				if reflect.DeepEqual(merged, last) {
+					continue
+				}
+				last = merged
+				select {
+				case <-out:
+				default:
+				}
+				out <- merged
+			}
+		}
+	}()
+	return out, nil
+}
+
+func allReceived(received []bool) bool {
+	for _, ok := range received {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Multi) Register(backend Backend) error {
+	return errors.New("multi registry is read only")
+}
+
+func (m *Multi) Deregister(addr string) error {
+	return errors.New("multi registry is read only")
+}
+
+// 按来源顺序合并，去掉重复地址，并应用来源的权重乘数与 zone
+func (m *Multi) merge(lists [][]Backend) []Backend {
+	seen := map[string]bool{}
+	merged := []Backend{}
+	for i, list := range lists {
+		source := m.sources[i]
+		for _, b := range list {
+			if seen[b.Addr] {
+				continue
+			}
+			seen[b.Addr] = true
+			if source.WeightFactor > 0 && b.Weight > 0 {
+				b.Weight = int(math.Max(1, math.Round(float64(b.Weight)*source.WeightFactor)))
+			}
+			if source.Zone != "" && b.Metadata[MetadataZone] == "" {
+				meta := map[string]string{MetadataZone: source.Zone}
+				for k, v := range b.Metadata {
+					if k != MetadataZone {
+						meta[k] = v
+					}
+				}
+				b.Metadata = meta
+			}
+			merged = append(merged, b)
+		}
+	}
+	SortBackends(merged)
+	return merged
+}
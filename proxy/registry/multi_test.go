@@ -0,0 +1,90 @@
+package registry_test
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMultiMergesSources(t *testing.T) {
+	hz, sh := registry.NewMemory(), registry.NewMemory()
+	hz.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10})
+	hz.Register(registry.Backend{Addr: "127.0.0.1:8002", Metadata: map[string]string{registry.MetadataZone: "hz-b"}})
+	sh.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 99})
+	sh.Register(registry.Backend{Addr: "127.0.0.1:8003", Weight: 10})
+	m := registry.NewMulti(
+		registry.MultiSource{Registry: hz, Zone: "hz-a"},
+		registry.MultiSource{Registry: sh, WeightFactor: 0.5, Zone: "sh"},
+	)
+
+	//重复地址以靠前的来源为准，zone 只补充缺少的元数据
+	want := []registry.Backend{
+		{Addr: "127.0.0.1:8001", Weight: 10, Metadata: map[string]string{registry.MetadataZone: "hz-a"}},
+		{Addr: "127.0.0.1:8002", Metadata: map[string]string{registry.MetadataZone: "hz-b"}},
+		{Addr: "127.0.0.1:8003", Weight: 5, Metadata: map[string]string{registry.MetadataZone: "sh"}},
+	}
+	list, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Fatalf("list %+v", list)
+	}
+
+	//第一个来源去掉地址后使用第二个来源的节点
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := m.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ch; !reflect.DeepEqual(got, want) {
+		t.Fatalf("initial %+v", got)
+	}
+	hz.Deregister("127.0.0.1:8001")
+	select {
+	case got := <-ch:
+		if len(got) != 3 || got[0].Weight != 50 || got[0].Metadata[registry.MetadataZone] != "sh" {
+			t.Fatalf("after deregister %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update")
+	}
+	if m.Register(registry.Backend{Addr: "127.0.0.1:8004"}) == nil {
+		t.Fatal("multi registry should be read only")
+	}
+}
+
+func TestMultiDebouncesBursts(t *testing.T) {
+	hz, sh := registry.NewMemory(), registry.NewMemory()
+	m := registry.NewMulti(registry.MultiSource{Registry: hz}, registry.MultiSource{Registry: sh})
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := m.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	for _, addr := range []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"} {
+		hz.Register(registry.Backend{Addr: addr})
+		sh.Register(registry.Backend{Addr: addr})
+	}
+	var updates [][]registry.Backend
+	timeout := time.After(300 * time.Millisecond)
+loop:
+	for {
+		select {
+		case list := <-ch:
+			updates = append(updates, list)
+		case <-timeout:
+			break loop
+		}
+	}
+	if len(updates) != 1 || len(updates[0]) != 3 {
+		t.Fatalf("updates %+v", updates)
+	}
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,34 @@
+package registry
+
+import (
+	"context"
+	"sort"
+)
+
+// 注册中心中的一个后端
+type Backend struct {
+	Addr     string            `json:"addr"`   //host:port
+	Weight   int               `json:"weight"` //为 0 表示注册中心没有提供权重
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Backend.Metadata 中约定的键
+const (
+	MetadataZone     = "zone"
+	MetadataProtocol = "protocol"
+	MetadataQPS      = "qps" //网关对该后端的限流值，见 rate_limiter.BackendLimiter
+)
+
+// 服务发现的统一接口，网关通过 List/Watch 获取后端，后端服务通过 Register/Deregister 注册自己
+type Registry interface {
+	List() ([]Backend, error)
+	//先发送当前的后端列表，之后每次变化发送完整列表；ctx 结束后关闭 channel
+	Watch(ctx context.Context) (<-chan []Backend, error)
+	Register(backend Backend) error
+	Deregister(addr string) error
+}
+
+// 按地址排序，便于比较
+func SortBackends(list []Backend) {
+	sort.Slice(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+}
@@ -0,0 +1,151 @@
+// registrytest 提供 Registry 实现都应通过的一致性测试
+package registrytest
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"context"
+	"testing"
+	"time"
+)
+
+// 等待 watch 收到的列表的超时时间，基于网络的实现可以适当调大
+var WatchTimeout = 5 * time.Second
+
+// newRegistry 返回一个空的注册中心，每个子测试调用一次
+func Run(t *testing.T, newRegistry func(t *testing.T) registry.Registry) {
+	t.Run("RegisterList", func(t *testing.T) { testRegisterList(t, newRegistry(t)) })
+	t.Run("Deregister", func(t *testing.T) { testDeregister(t, newRegistry(t)) })
+	t.Run("Watch", func(t *testing.T) { testWatch(t, newRegistry(t)) })
+	t.Run("WatchCancel", func(t *testing.T) { testWatchCancel(t, newRegistry(t)) })
+}
+
+func addrs(list []registry.Backend) []string {
+	registry.SortBackends(list)
+	out := []string{}
+	for _, b := range list {
+		out = append(out, b.Addr)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mustList(t *testing.T, r registry.Registry, want ...string) {
+	t.Helper()
+	list, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addrs(list); !equal(got, want) {
+		t.Fatalf("List got %v want %v", got, want)
+	}
+}
+
+// 读取 watch 的列表直到与 want 一致
+func waitWatch(t *testing.T, ch <-chan []registry.Backend, want ...string) {
+	t.Helper()
+	timeout := time.After(WatchTimeout)
+	var got []string
+	for {
+		select {
+		case list, ok := <-ch:
+			if !ok {
+				t.Fatalf("watch closed, last %v want %v", got, want)
+			}
+			if got = addrs(list); equal(got, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("watch got %v want %v", got, want)
+		}
+	}
+}
+
+func testRegisterList(t *testing.T, r registry.Registry) {
+	mustList(t, r)
+	for _, addr := range []string{"127.0.0.1:8002", "127.0.0.1:8001"} {
+		if err := r.Register(registry.Backend{Addr: addr, Weight: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//重复注册不产生重复的后端
+	if err := r.Register(registry.Backend{Addr: "127.0.0.1:8001", Weight: 10}); err != nil {
+		t.Fatal(err)
+	}
+	mustList(t, r, "127.0.0.1:8001", "127.0.0.1:8002")
+}
+
+func testDeregister(t *testing.T, r registry.Registry) {
+	for _, addr := range []string{"127.0.0.1:8001", "127.0.0.1:8002"} {
+		if err := r.Register(registry.Backend{Addr: addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Deregister("127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	mustList(t, r, "127.0.0.1:8002")
+	//注销不存在的后端不报错
+	if err := r.Deregister("127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testWatch(t *testing.T, r registry.Registry) {
+	if err := r.Register(registry.Backend{Addr: "127.0.0.1:8001"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//首先收到当前列表
+	select {
+	case list := <-ch:
+		if got := addrs(list); !equal(got, []string{"127.0.0.1:8001"}) {
+			t.Fatalf("initial watch got %v", got)
+		}
+	case <-time.After(WatchTimeout):
+		t.Fatal("no initial list")
+	}
+	if err := r.Register(registry.Backend{Addr: "127.0.0.1:8002"}); err != nil {
+		t.Fatal(err)
+	}
+	waitWatch(t, ch, "127.0.0.1:8001", "127.0.0.1:8002")
+	if err := r.Deregister("127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	waitWatch(t, ch, "127.0.0.1:8002")
+}
+
+func testWatchCancel(t *testing.T, r registry.Registry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := r.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	timeout := time.After(WatchTimeout)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("watch not closed after cancel")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package selfreg 供后端服务把自己注册到网关的注册中心：
+//
+//	reg, err := selfreg.Register(ctx, selfreg.RegistryConfig{ZkHosts: hosts, Path: "/gateway_servers_orders"},
+//		selfreg.ServiceInfo{Addr: "10.0.0.1:8080", Weight: 10, Zone: "hz-a"})
+//	...
+//	<-reg.Done() //ctx 结束或收到信号后已注销
+package selfreg
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"GO_GATEWAY/proxy/zookeeper"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 节点丢失后重新注册失败时的重试间隔
+const DefaultRetryInterval = time.Second
+
+type RegistryConfig struct {
+	ZkHosts   []string
+	Path      string //服务目录，如 /gateway_servers_orders
+	ZkOptions []zookeeper.ZkOption
+	//设置后直接使用该注册中心，不连接 zk
+	Registry      registry.Registry
+	Signals       []os.Signal //收到这些信号时注销，为空时使用 SIGINT、SIGTERM
+	RetryInterval time.Duration
+}
+
+type ServiceInfo struct {
+	Addr     string //host:port
+	Weight   int
+	Zone     string
+	Metadata map[string]string //其他元数据，如 protocol
+}
+
+func (s ServiceInfo) backend() registry.Backend {
+	b := registry.Backend{Addr: s.Addr, Weight: s.Weight}
+	if s.Zone != "" || len(s.Metadata) > 0 {
+		b.Metadata = map[string]string{}
+		for k, v := range s.Metadata {
+			b.Metadata[k] = v
+		}
+		if s.Zone != "" {
+			b.Metadata[registry.MetadataZone] = s.Zone
+		}
+	}
+	return b
+}
+
+type State int
+
+const (
+	StateRegistered   State = iota + 1
+	StateLost               //节点丢失，如 zk 会话过期，正在重新注册
+	StateDeregistered       //已注销，不再恢复
+)
+
+func (s State) String() string {
+	switch s {
+	case StateRegistered:
+		return "registered"
+	case StateLost:
+		return "lost"
+	case StateDeregistered:
+		return "deregistered"
+	}
+	return "unknown"
+}
+
+// 一次注册。注册中心里的节点消失时自动重新注册，直到 ctx 结束、收到信号或调用 Deregister
+type Registration struct {
+	r       registry.Registry
+	backend registry.Backend
+	retry   time.Duration
+	closer  func()
+
+	mux    sync.Mutex
+	state  State
+	states chan State
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// 注册成功后返回，之后在后台维持注册
+func Register(ctx context.Context, conf RegistryConfig, info ServiceInfo) (*Registration, error) {
+	if info.Addr == "" {
+		return nil, errors.New("service addr required")
+	}
+	r, closer := conf.Registry, func() {}
+	if r == nil {
+		zr, err := registry.NewZkRegistry(conf.ZkHosts, conf.Path, conf.ZkOptions...)
+		if err != nil {
+			return nil, err
+		}
+		r, closer = zr, zr.Close
+	}
+	reg := &Registration{r: r, backend: info.backend(), retry: conf.RetryInterval, closer: closer,
+		states: make(chan State, 1), done: make(chan struct{})}
+	if reg.retry <= 0 {
+		reg.retry = DefaultRetryInterval
+	}
+	if err := r.Register(reg.backend); err != nil {
+		closer()
+		return nil, err
+	}
+	signals := conf.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	ctx, stop := signal.NotifyContext(ctx, signals...)
+	ctx, cancel := context.WithCancel(ctx)
+	reg.cancel = func() {
+		cancel()
+		stop()
+	}
+	watch, err := r.Watch(ctx)
+	if err != nil {
+		reg.cancel()
+		r.Deregister(reg.backend.Addr)
+		closer()
+		return nil, err
+	}
+	reg.setState(StateRegistered)
+	go reg.run(ctx, watch)
+	return reg, nil
+}
+
+// 状态变化通知，只保留最新的状态，可用于控制服务自己的就绪状态
+func (reg *Registration) States() <-chan State {
+	return reg.states
+}
+
+func (reg *Registration) State() State {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	return reg.state
+}
+
+func (reg *Registration) Registered() bool {
+	return reg.State() == StateRegistered
+}
+
+// 注销后关闭
+func (reg *Registration) Done() <-chan struct{} {
+	return reg.done
+}
+
+// 注销并等待完成
+func (reg *Registration) Deregister() {
+	reg.cancel()
+	<-reg.done
+}
+
+func (reg *Registration) run(ctx context.Context, watch <-chan []registry.Backend) {
+	defer close(reg.done)
+	var retry <-chan time.Time
+	for watch != nil {
+		select {
+		case list, ok := <-watch:
+			if !ok {
+				watch = nil
+				continue
+			}
+			if contains(list, reg.backend.Addr) {
+				reg.setState(StateRegistered)
+				retry = nil
+				continue
+			}
+			reg.setState(StateLost)
+			if retry == nil {
+				retry = time.After(0)
+			}
+		case <-retry:
+			//重新注册后等 watch 确认节点存在
+			retry = nil
+			if err := reg.r.Register(reg.backend); err != nil {
+				fmt.Println("selfreg re-register error", reg.backend.Addr, err)
+				retry = time.After(reg.retry)
+			}
+		case <-ctx.Done():
+			watch = nil
+		}
+	}
+	reg.cancel()
+	if err := reg.r.Deregister(reg.backend.Addr); err != nil {
+		fmt.Println("selfreg deregister error", reg.backend.Addr, err)
+	}
+	reg.closer()
+	reg.setState(StateDeregistered)
+}
+
+func contains(list []registry.Backend, addr string) bool {
+	for _, b := range list {
+		if b.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (reg *Registration) setState(state State) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	if reg.state == state {
+		return
+	}
+	reg.state = state
+	select {
+	case <-reg.states:
+	default:
+	}
+	reg.states <- state
+}
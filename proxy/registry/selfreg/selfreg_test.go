@@ -0,0 +1,110 @@
+package selfreg
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func waitState(t *testing.T, reg *Registration, want State) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for reg.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("state %v want %v", reg.State(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := registry.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	reg, err := Register(ctx, RegistryConfig{Registry: r}, ServiceInfo{Addr: "127.0.0.1:8001", Weight: 10, Zone: "hz-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := <-reg.States(); state != StateRegistered || !reg.Registered() {
+		t.Fatalf("state %v", state)
+	}
+	list, _ := r.List()
+	want := []registry.Backend{{Addr: "127.0.0.1:8001", Weight: 10, Metadata: map[string]string{registry.MetadataZone: "hz-a"}}}
+	if !reflect.DeepEqual(list, want) {
+		t.Fatalf("list %+v", list)
+	}
+
+	//ctx 结束后注销
+	cancel()
+	<-reg.Done()
+	if list, _ := r.List(); len(list) != 0 {
+		t.Fatalf("not deregistered %+v", list)
+	}
+	if reg.State() != StateDeregistered {
+		t.Fatalf("state %v", reg.State())
+	}
+	if _, err := Register(context.Background(), RegistryConfig{Registry: r}, ServiceInfo{}); err == nil {
+		t.Fatal("empty addr should fail")
+	}
+}
+
+// 前几次注册失败的注册中心
+type failingRegistry struct {
+	*registry.Memory
+	fails chan struct{}
+}
+
+func (f *failingRegistry) Register(backend registry.Backend) error {
+	select {
+	case <-f.fails:
+		return errors.New("zk connection loss")
+	default:
+	}
+	return f.Memory.Register(backend)
+}
+
+func TestRegisterRecoversLostNode(t *testing.T) {
+	r := &failingRegistry{Memory: registry.NewMemory(), fails: make(chan struct{}, 2)}
+	reg, err := Register(context.Background(), RegistryConfig{Registry: r, RetryInterval: 10 * time.Millisecond}, ServiceInfo{Addr: "127.0.0.1:8001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Deregister()
+
+	//模拟会话过期后节点被删除，且重新注册先失败两次
+	r.fails <- struct{}{}
+	r.fails <- struct{}{}
+	r.Memory.Deregister("127.0.0.1:8001")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.fails) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("re-register not retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitState(t, reg, StateRegistered)
+	if list, _ := r.List(); len(list) != 1 {
+		t.Fatalf("list %+v", list)
+	}
+}
+
+func TestDeregisterOnSignal(t *testing.T) {
+	r := registry.NewMemory()
+	reg, err := Register(context.Background(), RegistryConfig{Registry: r, Signals: []os.Signal{syscall.SIGUSR1}}, ServiceInfo{Addr: "127.0.0.1:8001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	select {
+	case <-reg.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not deregistered on signal")
+	}
+	if list, _ := r.List(); len(list) != 0 {
+		t.Fatalf("list %+v", list)
+	}
+}
@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"GO_GATEWAY/proxy/zookeeper"
+	"context"
+	"fmt"
+	"time"
+)
+
+// zk 出错后重新 watch 前的等待时间
+const zkRetryInterval = time.Second
+
+// 基于 zk 临时节点的注册中心：path 下的每个子节点名为一个后端地址，
+// 节点数据为 zookeeper.NodeMetadata，其中 zone、protocol 与 labels 对应 Backend.Metadata
+type ZkRegistry struct {
+	manager *zookeeper.ZkManager
+	path    string
+}
+
+// opts 为 zk 的认证与 ACL 配置
+func NewZkRegistry(hosts []string, path string, opts ...zookeeper.ZkOption) (*ZkRegistry, error) {
+	manager := zookeeper.NewZkManager(hosts, opts...)
+	if err := manager.GetConnect(); err != nil {
+		return nil, err
+	}
+	return &ZkRegistry{manager: manager, path: path}, nil
+}
+
+func (z *ZkRegistry) List() ([]Backend, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), zookeeper.DefaultCallTimeout)
+	defer cancel()
+	nodes, err := z.manager.GetServerNodesByPathCtx(ctx, z.path)
+	if err != nil {
+		return nil, err
+	}
+	return zkBackends(nodes), nil
+}
+
+func (z *ZkRegistry) Watch(ctx context.Context) (<-chan []Backend, error) {
+	ch := make(chan []Backend, 1)
+	go func() {
+		defer close(ch)
+		for {
+			callCtx, cancel := context.WithTimeout(ctx, zookeeper.DefaultCallTimeout)
+			nodes, events, err := z.manager.ChildrenNodesWCtx(callCtx, z.path)
+			cancel()
+			if err != nil {
+				fmt.Println("zk watch error", z.path, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(zkRetryInterval):
+				}
+				continue
+			}
+			//只保留最新的列表
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- zkBackends(nodes)
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-events:
+				fmt.Printf("ChildrenW Event Path:%v, Type:%v\n", evt.Path, evt.Type)
+			}
+			//合并短时间内的多次变化
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(zookeeper.DefaultWatchDebounce):
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// 心跳模式(Metadata 带 TTL)的后端注册为持久节点，由网关按心跳时间判断过期，其余注册为临时节点
+func (z *ZkRegistry) Register(backend Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), zookeeper.DefaultCallTimeout)
+	defer cancel()
+	if _, ok := backend.Metadata[MetadataTTL]; ok {
+		data, err := zkMetadata(backend).Marshal()
+		if err != nil {
+			return err
+		}
+		return z.manager.SetPathDataCtx(ctx, z.path+"/"+backend.Addr, data, -1)
+	}
+	return z.manager.RegistServerMetadataCtx(ctx, z.path, backend.Addr, zkMetadata(backend))
+}
+
+func (z *ZkRegistry) Deregister(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), zookeeper.DefaultCallTimeout)
+	defer cancel()
+	return z.manager.DeregisterServerPathCtx(ctx, z.path, addr)
+}
+
+func (z *ZkRegistry) Close() {
+	z.manager.Close()
+}
+
+// Metadata 中的 zone、protocol 保存为对应字段，其余保存为 labels
+func zkMetadata(backend Backend) zookeeper.NodeMetadata {
+	meta := zookeeper.NodeMetadata{Weight: backend.Weight}
+	for k, v := range backend.Metadata {
+		switch k {
+		case MetadataZone:
+			meta.Zone = v
+		case MetadataProtocol:
+			meta.Protocol = v
+		default:
+			if meta.Labels == nil {
+				meta.Labels = map[string]string{}
+			}
+			meta.Labels[k] = v
+		}
+	}
+	return meta
+}
+
+func zkBackends(nodes []zookeeper.ServerNode) []Backend {
+	backends := []Backend{}
+	for _, node := range nodes {
+		b := Backend{Addr: node.Host, Weight: node.Metadata.Weight}
+		meta := node.Metadata
+		if meta.Zone != "" || meta.Protocol != "" || len(meta.Labels) > 0 {
+			b.Metadata = map[string]string{}
+			for k, v := range meta.Labels {
+				b.Metadata[k] = v
+			}
+			if meta.Zone != "" {
+				b.Metadata[MetadataZone] = meta.Zone
+			}
+			if meta.Protocol != "" {
+				b.Metadata[MetadataProtocol] = meta.Protocol
+			}
+		}
+		backends = append(backends, b)
+	}
+	SortBackends(backends)
+	return backends
+}
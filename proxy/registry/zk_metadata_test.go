@@ -0,0 +1,29 @@
+package registry
+
+import (
+	"GO_GATEWAY/proxy/zookeeper"
+	"reflect"
+	"testing"
+)
+
+func TestZkMetadataRoundTrip(t *testing.T) {
+	backend := Backend{Addr: "127.0.0.1:8001", Weight: 30, Metadata: map[string]string{
+		MetadataZone: "hz-a", MetadataProtocol: "grpc", "version": "v2",
+	}}
+	data, err := zkMetadata(backend).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := zookeeper.ParseNodeMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Zone != "hz-a" || meta.Protocol != "grpc" || !reflect.DeepEqual(meta.Labels, map[string]string{"version": "v2"}) {
+		t.Fatalf("metadata %+v", meta)
+	}
+	got := zkBackends([]zookeeper.ServerNode{{Host: "127.0.0.1:8001", Metadata: meta}, {Host: "127.0.0.1:8000"}})
+	want := []Backend{{Addr: "127.0.0.1:8000"}, backend}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+}
@@ -0,0 +1,36 @@
+package registry_test
+
+import (
+	"GO_GATEWAY/proxy/registry"
+	"GO_GATEWAY/proxy/registry/registrytest"
+	"GO_GATEWAY/proxy/zookeeper"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 需要 zk，设置 GATEWAY_TEST_ZK_HOSTS(逗号分隔)后运行
+func TestZkConformance(t *testing.T) {
+	hosts := os.Getenv("GATEWAY_TEST_ZK_HOSTS")
+	if hosts == "" {
+		t.Skip("GATEWAY_TEST_ZK_HOSTS not set")
+	}
+	registrytest.Run(t, func(t *testing.T) registry.Registry {
+		path := "/gateway_registry_test_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		//先创建父节点，List 对不存在的路径返回错误
+		manager := zookeeper.NewZkManager(strings.Split(hosts, ","))
+		if err := manager.GetConnect(); err != nil {
+			t.Fatal(err)
+		}
+		manager.SetPathData(path, nil, 0)
+		manager.Close()
+		r, err := registry.NewZkRegistry(strings.Split(hosts, ","), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(r.Close)
+		return r
+	})
+}
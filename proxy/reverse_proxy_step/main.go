@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"GO_GATEWAY/proxy/gateway"
+	"GO_GATEWAY/proxy/metrics"
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httputil"
@@ -24,8 +23,11 @@ func main() {
 		log.Println(err1)
 	}
 	proxy := NewSingleHostReverseProxy(url1)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", gateway.RequestMetrics(proxy))
 	log.Println("Starting httpserver at " + addr)
-	log.Fatal(http.ListenAndServe(addr, proxy))
+	log.Fatal(http.ListenAndServe(addr, mux))
 }
 
 func NewSingleHostReverseProxy(target *url.URL) *httputil.ReverseProxy {
@@ -60,14 +62,6 @@ func NewSingleHostReverseProxy(target *url.URL) *httputil.ReverseProxy {
 	modifyFunc := func(res *http.Response) error {
 		if res.StatusCode != 200 {
 			return errors.New("error statusCode")
-			oldPayload, err := ioutil.ReadAll(res.Body)
-			if err != nil {
-				return err
-			}
-			newPayLoad := []byte("hello " + string(oldPayload))
-			res.Body = ioutil.NopCloser(bytes.NewBuffer(newPayLoad))
-			res.ContentLength = int64(len(newPayLoad))
-			res.Header.Set("Content-Length", fmt.Sprint(len(newPayLoad)))
 		}
 		return nil
 	}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// 收到 SIGUSR2 时执行升级，升级成功后停止监听信号
+func (u *Upgrader) HandleSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+				if err := u.Upgrade(); err != nil {
+					fmt.Println("upgrade failed", err)
+				}
+			case <-u.exitC:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,8 @@
+package upgrade
+
+import "fmt"
+
+// Windows 不支持 SIGUSR2 与继承 socket，不做处理
+func (u *Upgrader) HandleSignals() {
+	fmt.Println("upgrade: signals not supported on windows")
+}
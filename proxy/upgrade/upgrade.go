@@ -0,0 +1,283 @@
+// 不中断连接的二进制热升级：运行中的进程启动新的二进制，通过 ExtraFiles 把监听 socket 交给子进程，
+// 子进程就绪后父进程停止接受新连接并处理完已有请求再退出。
+//
+// 典型用法：
+//
+//	u, _ := upgrade.New(upgrade.Options{})
+//	l, _ := u.Listen("tcp", ":8080") //子进程中返回继承的 socket
+//	go srv.Serve(l)
+//	u.Ready()                        //通知父进程可以退出
+//	u.HandleSignals()                //收到 SIGUSR2 时升级
+//	<-u.Exit()
+//	time.Sleep(time.Second)          //等待已接受连接的请求到达
+//	srv.Shutdown(ctx)
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envListeners = "GATEWAY_UPGRADE_LISTENERS" //继承的监听 socket，按 ExtraFiles 顺序以逗号分隔的 network:addr
+	envReadyFD   = "GATEWAY_UPGRADE_READY_FD"  //子进程就绪后写入的管道
+
+	DefaultReadyTimeout = time.Minute
+)
+
+var (
+	ErrUpgrading = errors.New("upgrade already in progress")
+	ErrUpgraded  = errors.New("process already upgraded")
+)
+
+type Options struct {
+	Path         string        //新二进制的路径，默认当前可执行文件
+	Args         []string      //启动参数(不含程序名)，默认与当前进程相同
+	ReadyTimeout time.Duration //等待子进程就绪的时间，超时则杀掉子进程，默认 DefaultReadyTimeout
+}
+
+type Upgrader struct {
+	opts Options
+
+	mux       sync.Mutex
+	inherited map[string]*os.File     //从父进程继承、尚未被 Listen 取走的 socket
+	listeners map[string]net.Listener //升级时交给子进程的 socket
+	readyFile *os.File
+	upgrading bool
+	exitC     chan struct{}
+}
+
+// 解析从父进程继承的 socket，非升级启动时为空
+func New(opts Options) (*Upgrader, error) {
+	if opts.Path == "" {
+		path, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		opts.Path = path
+	}
+	if opts.Args == nil {
+		opts.Args = os.Args[1:]
+	}
+	if opts.ReadyTimeout <= 0 {
+		opts.ReadyTimeout = DefaultReadyTimeout
+	}
+	u := &Upgrader{
+		opts:      opts,
+		inherited: map[string]*os.File{},
+		listeners: map[string]net.Listener{},
+		exitC:     make(chan struct{}),
+	}
+	if names := os.Getenv(envListeners); names != "" {
+		for i, name := range strings.Split(names, ",") {
+			u.inherited[name] = os.NewFile(uintptr(3+i), name)
+		}
+	}
+	if fd := os.Getenv(envReadyFD); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", envReadyFD, fd)
+		}
+		u.readyFile = os.NewFile(uintptr(n), "upgrade-ready")
+	}
+	//不传给之后启动的进程
+	os.Unsetenv(envListeners)
+	os.Unsetenv(envReadyFD)
+	return u, nil
+}
+
+// 是否由父进程升级启动
+func (u *Upgrader) Inherited() bool {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.readyFile != nil
+}
+
+// 优先使用从父进程继承的同一 network:addr 的 socket，没有时新绑定。addr 以配置中的原值匹配，:0 同样适用
+func (u *Upgrader) Listen(network, addr string) (net.Listener, error) {
+	name := network + ":" + addr
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if _, ok := u.listeners[name]; ok {
+		return nil, fmt.Errorf("%s already listening", name)
+	}
+	var l net.Listener
+	var err error
+	if f, ok := u.inherited[name]; ok {
+		delete(u.inherited, name)
+		l, err = net.FileListener(f)
+		f.Close()
+	} else {
+		l, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.listeners[name] = l
+	return &upgradeListener{Listener: l, exitC: u.exitC, closed: make(chan struct{})}, nil
+}
+
+// 所有监听就绪后调用，通知父进程开始退出；未被 Listen 取走的继承 socket 在此关闭
+func (u *Upgrader) Ready() error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	for name, f := range u.inherited {
+		f.Close()
+		delete(u.inherited, name)
+	}
+	if u.readyFile == nil {
+		return nil
+	}
+	_, err := u.readyFile.Write([]byte{1})
+	u.readyFile.Close()
+	u.readyFile = nil
+	return err
+}
+
+// 子进程就绪后关闭，此时 Listen 返回的监听已停止接受新连接。
+// 调用方稍等已接受连接的请求到达后再优雅关闭，否则 http.Server.Shutdown 会直接关掉还没读到请求的连接
+func (u *Upgrader) Exit() <-chan struct{} {
+	return u.exitC
+}
+
+// 启动新的二进制并交出所有监听 socket，子进程就绪后返回 nil 并关闭 Exit()；
+// 子进程启动失败、提前退出或就绪超时时返回错误，当前进程继续服务
+func (u *Upgrader) Upgrade() error {
+	u.mux.Lock()
+	select {
+	case <-u.exitC:
+		u.mux.Unlock()
+		return ErrUpgraded
+	default:
+	}
+	if u.upgrading {
+		u.mux.Unlock()
+		return ErrUpgrading
+	}
+	u.upgrading = true
+	names, files, err := u.listenerFiles()
+	u.mux.Unlock()
+	defer func() {
+		u.mux.Lock()
+		u.upgrading = false
+		u.mux.Unlock()
+	}()
+	defer closeFiles(files)
+	if err != nil {
+		return err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	cmd := exec.Command(u.opts.Path, u.opts.Args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		envListeners+"="+strings.Join(names, ","),
+		envReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyR.Read(buf); err != nil {
+			ready <- errors.New("child closed ready pipe without signalling")
+			return
+		}
+		ready <- nil
+	}()
+	timer := time.NewTimer(u.opts.ReadyTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+	case err = <-exited:
+		err = fmt.Errorf("child exited before ready: %v", err)
+	case <-timer.C:
+		err = errors.New("child not ready in " + u.opts.ReadyTimeout.String())
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	fmt.Println("upgrade: child ready, pid", cmd.Process.Pid)
+	u.mux.Lock()
+	close(u.exitC)
+	//唤醒阻塞在 Accept 上的 goroutine，之后的新连接全部由子进程接受
+	for _, l := range u.listeners {
+		if dl, ok := l.(interface{ SetDeadline(time.Time) error }); ok {
+			dl.SetDeadline(time.Now())
+		}
+	}
+	u.mux.Unlock()
+	return nil
+}
+
+// 复制监听 socket 的文件描述符，顺序即子进程中的 fd 3、4...
+func (u *Upgrader) listenerFiles() ([]string, []*os.File, error) {
+	names := make([]string, 0, len(u.listeners))
+	files := make([]*os.File, 0, len(u.listeners))
+	for name, l := range u.listeners {
+		filer, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, files, fmt.Errorf("%s: listener does not expose its file", name)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, files, fmt.Errorf("%s: %v", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+	return names, files, nil
+}
+
+// 升级完成后不再接受新连接，Accept 阻塞到 Close，使 http.Server 在 Shutdown 前保持服务已有连接
+type upgradeListener struct {
+	net.Listener
+	exitC     chan struct{}
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (l *upgradeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	select {
+	case <-l.exitC:
+		//升级前已接受的连接照常服务，之后 Accept 因截止时间失败
+		if err == nil {
+			return conn, nil
+		}
+		<-l.closed
+		return nil, net.ErrClosed
+	default:
+		return conn, err
+	}
+}
+
+func (l *upgradeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
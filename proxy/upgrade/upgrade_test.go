@@ -0,0 +1,152 @@
+package upgrade
+
+import (
+	"GO_GATEWAY/proxy/gateway"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const envHelperChild = "GATEWAY_UPGRADE_TEST_CHILD"
+
+func upgradeGateway(t *testing.T, u *Upgrader, text string, exit chan struct{}) *gateway.Gateway {
+	var once sync.Once
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/exit" {
+			once.Do(func() { close(exit) })
+		}
+		w.Write([]byte(text))
+	})
+	g := gateway.NewGateway(gateway.GatewayOptions{
+		Listeners: []gateway.Listener{{Name: "http", Addr: "127.0.0.1:0", Handler: h}},
+		Listen:    u.Listen,
+	})
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// 升级后启动的子进程：接管父进程的 socket 并服务到收到 /exit
+func TestHelperChild(t *testing.T) {
+	switch os.Getenv(envHelperChild) {
+	case "":
+		return
+	case "fail":
+		os.Exit(3)
+	}
+	u, err := New(Options{})
+	if err != nil || !u.Inherited() {
+		os.Exit(2)
+	}
+	exit := make(chan struct{})
+	g := upgradeGateway(t, u, "child", exit)
+	u.Ready()
+	select {
+	case <-exit:
+	case <-time.After(30 * time.Second):
+	}
+	g.Shutdown(context.Background())
+	os.Exit(0)
+}
+
+func TestUpgradeUnderLoad(t *testing.T) {
+	t.Setenv(envHelperChild, "1")
+	u, err := New(Options{Args: []string{"-test.run=^TestHelperChild$"}, ReadyTimeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := upgradeGateway(t, u, "parent", make(chan struct{}))
+	url := "http://" + g.Addr("http").String()
+
+	//持续请求，每次使用新连接
+	var failures, parent, child int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := client.Get(url + "/")
+				if err != nil {
+					t.Log(err)
+					atomic.AddInt64(&failures, 1)
+					continue
+				}
+				data, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				switch string(data) {
+				case "parent":
+					atomic.AddInt64(&parent, 1)
+				case "child":
+					atomic.AddInt64(&child, 1)
+				default:
+					atomic.AddInt64(&failures, 1)
+				}
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := u.Upgrade(); err != nil {
+		close(stop)
+		wg.Wait()
+		t.Fatal(err)
+	}
+	<-u.Exit()
+	if err := u.Upgrade(); err != ErrUpgraded {
+		t.Fatalf("second upgrade got %v", err)
+	}
+	//父进程已停止接受连接，等已接受连接的请求处理完后关闭，子进程继续在同一端口服务
+	time.Sleep(100 * time.Millisecond)
+	g.Shutdown(context.Background())
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	resp, err := http.Get(url + "/exit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if failures != 0 || parent == 0 || child == 0 {
+		t.Fatalf("failures %d, parent %d, child %d", failures, parent, child)
+	}
+}
+
+func TestUpgradeChildNotReady(t *testing.T) {
+	//子进程不调用 Ready 直接退出，当前进程继续服务
+	t.Setenv(envHelperChild, "fail")
+	u, err := New(Options{Args: []string{"-test.run=^TestHelperChild$"}, ReadyTimeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := u.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := u.Upgrade(); err == nil {
+		t.Fatal("upgrade succeeded without ready child")
+	}
+	select {
+	case <-u.Exit():
+		t.Fatal("exit signalled after failed upgrade")
+	default:
+	}
+	if _, err := u.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Fatal("duplicate listen allowed")
+	}
+}
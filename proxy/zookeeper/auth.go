@@ -0,0 +1,77 @@
+package zookeeper
+
+import (
+	"github.com/samuel/go-zookeeper/zk"
+	"net"
+	"time"
+)
+
+// zk.Conn 中 ZkManager 用到的方法，测试中可以替换
+type zkConn interface {
+	AddAuth(scheme string, auth []byte) error
+	Exists(path string) (bool, *zk.Stat, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Delete(path string, version int32) error
+	Multi(ops ...interface{}) ([]zk.MultiResponse, error)
+	Close()
+}
+
+// dialer 为空时使用默认的 TCP 连接，callback 接收会话事件
+var zkConnect = func(hosts []string, timeout time.Duration, dialer zk.Dialer, callback zk.EventCallback) (zkConn, error) {
+	if dialer == nil {
+		dialer = net.DialTimeout
+	}
+	conn, _, err := zk.Connect(hosts, timeout, zk.WithDialer(dialer), zk.WithEventCallback(callback))
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+type ZkOption func(z *ZkManager)
+
+// 连接后调用 AddAuth，如 WithAuth("digest", []byte("user:password"))
+func WithAuth(scheme string, credential []byte) ZkOption {
+	return func(z *ZkManager) {
+		z.authScheme, z.authCredential = scheme, credential
+	}
+}
+
+// 创建节点时使用的 ACL，默认 zk.WorldACL(zk.PermAll)。
+// 只允许认证用户读写可使用 zk.DigestACL(zk.PermAll, user, password)
+func WithACL(acl ...zk.ACL) ZkOption {
+	return func(z *ZkManager) {
+		z.acl = acl
+	}
+}
+
+// 认证失败或没有权限时返回的错误
+type AuthError struct {
+	Op   string //add_auth、create、set、delete
+	Path string
+	Err  error
+}
+
+func (e *AuthError) Error() string {
+	if e.Path == "" {
+		return "zk " + e.Op + ": " + e.Err.Error()
+	}
+	return "zk " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// 把 zk 的认证、权限错误包装为 AuthError
+func authError(op, path string, err error) error {
+	if err == zk.ErrNoAuth || err == zk.ErrAuthFailed {
+		return &AuthError{Op: op, Path: path, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package zookeeper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"sync"
+)
+
+// 新建连接的函数，callback 接收该连接的会话事件
+type dialFunc func(callback zk.EventCallback) (zkConn, error)
+
+// 多个 ZkManager 共享的一个 zk 连接，会话过期时按 policy 重连
+type sharedConn struct {
+	key    string //含认证摘要，不输出到日志
+	hosts  string //排序后的地址列表，用作指标标签与日志
+	dial   dialFunc
+	policy ReconnectPolicy
+
+	mux          sync.RWMutex
+	conn         zkConn
+	gen          int //连接的代数，用于忽略旧连接的事件
+	refs         int
+	managers     map[*ZkManager]struct{}
+	reconnecting bool
+	stop         chan struct{}
+	attempts     int64
+	successes    int64
+}
+
+func (s *sharedConn) current() zkConn {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.conn
+}
+
+// 按 zk 地址与认证配置共享连接，引用计数归零时才关闭连接
+type connManager struct {
+	mux   sync.Mutex
+	conns map[string]*sharedConn
+}
+
+var defaultConnManager = newConnManager()
+
+func newConnManager() *connManager {
+	return &connManager{conns: map[string]*sharedConn{}}
+}
+
+// 能报告连接状态的连接，*zk.Conn 满足该接口
+type connStater interface {
+	State() zk.State
+}
+
+// 认证失败或会话已过期的连接不再分配给新的 ZkManager，短暂断开的连接由客户端自己恢复，仍然可用
+func healthy(conn zkConn) bool {
+	s, ok := conn.(connStater)
+	if !ok {
+		return true
+	}
+	state := s.State()
+	return state != zk.StateAuthFailed && state != zk.StateExpired
+}
+
+// 获取 z 对应的连接，没有可用连接时新建。重连策略使用新建连接的 ZkManager 的配置
+func (m *connManager) acquire(z *ZkManager) (*sharedConn, error) {
+	key := z.connKey()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if shared, ok := m.conns[key]; ok {
+		if healthy(shared.current()) {
+			shared.mux.Lock()
+			shared.refs++
+			shared.managers[z] = struct{}{}
+			shared.mux.Unlock()
+			return shared, nil
+		}
+		//仍被引用的旧连接由最后一个引用者释放时关闭
+		fmt.Println("zk shared conn unhealthy, redial", shared.hosts)
+		delete(m.conns, key)
+	}
+	shared := &sharedConn{
+		key:      key,
+		hosts:    z.metricHosts(),
+		dial:     z.dial,
+		policy:   z.reconnect,
+		refs:     1,
+		managers: map[*ZkManager]struct{}{z: {}},
+		stop:     make(chan struct{}),
+	}
+	conn, err := z.dial(shared.callback(0))
+	if err != nil {
+		return nil, err
+	}
+	shared.mux.Lock()
+	shared.conn = conn
+	shared.mux.Unlock()
+	m.conns[key] = shared
+	return shared, nil
+}
+
+func (m *connManager) release(shared *sharedConn, z *ZkManager) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	shared.mux.Lock()
+	delete(shared.managers, z)
+	shared.refs--
+	if shared.refs > 0 {
+		shared.mux.Unlock()
+		return
+	}
+	close(shared.stop)
+	conn := shared.conn
+	shared.mux.Unlock()
+	if m.conns[shared.key] == shared {
+		delete(m.conns, shared.key)
+	}
+	conn.Close()
+	zkConnected.Set(shared.hosts, 0)
+}
+
+// 地址集合与认证、TLS 配置都相同的 ZkManager 才共享连接。
+// 认证信息只以摘要出现在 key 中，key 不能输出到日志
+func (z *ZkManager) connKey() string {
+	auth := sha256.Sum256([]byte(z.authScheme + "\x00" + string(z.authCredential)))
+	return fmt.Sprintf("%s|%s|%p", z.metricHosts(), hex.EncodeToString(auth[:]), z.tlsConfig)
+}
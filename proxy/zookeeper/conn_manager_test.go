@@ -0,0 +1,122 @@
+package zookeeper
+
+import (
+	"github.com/samuel/go-zookeeper/zk"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 记录关闭次数并可设置状态的连接
+type stateConn struct {
+	*fakeConn
+	state  zk.State
+	closed int
+}
+
+func (c *stateConn) State() zk.State { return c.state }
+
+func (c *stateConn) Close() { c.closed++ }
+
+// 每次 dial 返回新的连接
+func countDials(t *testing.T) *[]*stateConn {
+	dials := &[]*stateConn{}
+	connect, manager := zkConnect, defaultConnManager
+	zkConnect = func(hosts []string, timeout time.Duration, dialer zk.Dialer, callback zk.EventCallback) (zkConn, error) {
+		conn := &stateConn{fakeConn: newFakeConn(), state: zk.StateHasSession}
+		*dials = append(*dials, conn)
+		return conn, nil
+	}
+	defaultConnManager = newConnManager()
+	t.Cleanup(func() { zkConnect, defaultConnManager = connect, manager })
+	return dials
+}
+
+func TestZkSharedConn(t *testing.T) {
+	dials := countDials(t)
+	a := NewZkManager([]string{"10.0.0.1:2181", "10.0.0.2:2181"})
+	b := NewZkManager([]string{"10.0.0.2:2181", "10.0.0.1:2181"})
+	for _, z := range []*ZkManager{a, b} {
+		if err := z.GetConnect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*dials) != 1 {
+		t.Fatalf("dialed %d times for one host set", len(*dials))
+	}
+	//通过一个 ZkManager 注册的节点对另一个可见
+	if err := a.RegistServerPath("/gateway_servers_orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := b.GetServerListByPath("/gateway_servers_orders"); len(list) != 1 {
+		t.Fatalf("list %v", list)
+	}
+
+	//不同的地址或认证配置不共享
+	c := NewZkManager([]string{"10.0.0.3:2181"})
+	d := NewZkManager([]string{"10.0.0.1:2181", "10.0.0.2:2181"}, WithAuth("digest", []byte("u:p")))
+	c.GetConnect()
+	d.GetConnect()
+	if len(*dials) != 3 {
+		t.Fatalf("dialed %d times for three keys", len(*dials))
+	}
+	//认证信息不以明文出现在 key 中
+	if strings.Contains(d.connKey(), "u:p") {
+		t.Fatalf("credential in key %q", d.connKey())
+	}
+
+	shared := (*dials)[0]
+	a.Close()
+	a.Close()
+	if shared.closed != 0 {
+		t.Fatal("closed while still referenced")
+	}
+	b.Close()
+	if shared.closed != 1 {
+		t.Fatalf("closed %d times after last release", shared.closed)
+	}
+	//重新连接时新建连接
+	if err := a.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*dials) != 4 {
+		t.Fatalf("dialed %d times after teardown", len(*dials))
+	}
+	a.Close()
+	c.Close()
+	d.Close()
+}
+
+func TestZkSharedConnHealthCheck(t *testing.T) {
+	dials := countDials(t)
+	a := NewZkManager([]string{"10.0.0.1:2181"})
+	if err := a.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	old := (*dials)[0]
+	old.state = zk.StateAuthFailed
+
+	//不健康的连接不再分配，新的 ZkManager 使用新连接
+	b := NewZkManager([]string{"10.0.0.1:2181"})
+	if err := b.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*dials) != 2 || b.conn == old {
+		t.Fatalf("unhealthy conn handed out, dials %d", len(*dials))
+	}
+	//旧连接在最后一个引用者释放时关闭，不影响新连接
+	a.Close()
+	if old.closed != 1 || (*dials)[1].closed != 0 {
+		t.Fatalf("old closed %d, new closed %d", old.closed, (*dials)[1].closed)
+	}
+	c := NewZkManager([]string{"10.0.0.1:2181"})
+	c.GetConnect()
+	if len(*dials) != 2 {
+		t.Fatal("healthy replacement not shared")
+	}
+	b.Close()
+	c.Close()
+	if (*dials)[1].closed != 1 {
+		t.Fatal("replacement not closed after last release")
+	}
+}
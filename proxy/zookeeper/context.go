@@ -0,0 +1,126 @@
+package zookeeper
+
+import (
+	"context"
+	"github.com/samuel/go-zookeeper/zk"
+	"time"
+)
+
+// 内部调用 zk 的默认超时
+const DefaultCallTimeout = 5 * time.Second
+
+// 在 goroutine 中执行 zk 调用，ctx 结束时立即返回 ctx.Err()。
+// zk 客户端的调用无法取消，会在后台继续完成，完成后调用 abandoned 做清理
+func runCtx(ctx context.Context, call func() error, abandoned func(err error)) error {
+	if ctx.Done() == nil {
+		return call()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if abandoned != nil {
+			go func() { abandoned(<-done) }()
+		}
+		return ctx.Err()
+	}
+}
+
+// 获取配置
+func (z *ZkManager) GetPathData(nodePath string) ([]byte, *zk.Stat, error) {
+	return z.GetPathDataCtx(context.Background(), nodePath)
+}
+
+func (z *ZkManager) GetPathDataCtx(ctx context.Context, nodePath string) ([]byte, *zk.Stat, error) {
+	var data []byte
+	var stat *zk.Stat
+	err := runCtx(ctx, func() (err error) {
+		data, stat, err = z.getPathData(nodePath)
+		return
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, stat, nil
+}
+
+// 更新配置
+func (z *ZkManager) SetPathData(nodePath string, config []byte, version int32) error {
+	return z.SetPathDataCtx(context.Background(), nodePath, config, version)
+}
+
+// 超时返回后写入可能仍会在后台完成
+func (z *ZkManager) SetPathDataCtx(ctx context.Context, nodePath string, config []byte, version int32) error {
+	return runCtx(ctx, func() error {
+		return z.setPathData(nodePath, config, version)
+	}, nil)
+}
+
+// 创建临时节点
+func (z *ZkManager) RegistServerPath(nodePath, host string) error {
+	return z.RegistServerPathCtx(context.Background(), nodePath, host)
+}
+
+// 超时返回后如果节点仍然创建成功，删除该临时节点，避免调用方以为注册失败而节点却存在
+func (z *ZkManager) RegistServerPathCtx(ctx context.Context, nodePath, host string) error {
+	return runCtx(ctx, func() error {
+		return z.registServerPath(nodePath, host, nil)
+	}, func(err error) {
+		if err == nil {
+			z.deregisterServerPath(nodePath, host)
+		}
+	})
+}
+
+// 删除临时节点，下线前调用使其他网关立即停止转发，节点已不存在时不报错
+func (z *ZkManager) DeregisterServerPath(nodePath, host string) error {
+	return z.DeregisterServerPathCtx(context.Background(), nodePath, host)
+}
+
+func (z *ZkManager) DeregisterServerPathCtx(ctx context.Context, nodePath, host string) error {
+	return runCtx(ctx, func() error {
+		return z.deregisterServerPath(nodePath, host)
+	}, nil)
+}
+
+// 获取服务列表
+func (z *ZkManager) GetServerListByPath(path string) ([]string, error) {
+	return z.GetServerListByPathCtx(context.Background(), path)
+}
+
+func (z *ZkManager) GetServerListByPathCtx(ctx context.Context, path string) ([]string, error) {
+	var list []string
+	err := runCtx(ctx, func() (err error) {
+		list, err = z.getServerListByPath(path)
+		return
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// 获取服务列表，同时设置子节点变化的 watch
+func (z *ZkManager) ChildrenW(path string) ([]string, <-chan zk.Event, error) {
+	return z.ChildrenWCtx(context.Background(), path)
+}
+
+func (z *ZkManager) ChildrenWCtx(ctx context.Context, path string) ([]string, <-chan zk.Event, error) {
+	var list []string
+	var events <-chan zk.Event
+	err := runCtx(ctx, func() (err error) {
+		list, events, err = z.childrenW(path)
+		return
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return list, events, nil
+}
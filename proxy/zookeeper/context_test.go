@@ -0,0 +1,115 @@
+package zookeeper
+
+import (
+	"context"
+	"github.com/samuel/go-zookeeper/zk"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 在 release 关闭前阻塞所有调用的连接，模拟不可用的 zk 集群
+type stallConn struct {
+	*fakeConn
+	mux     sync.Mutex
+	release chan struct{}
+}
+
+func (c *stallConn) wait() {
+	<-c.release
+	c.mux.Lock()
+}
+
+func (c *stallConn) Exists(path string) (bool, *zk.Stat, error) {
+	c.wait()
+	defer c.mux.Unlock()
+	return c.fakeConn.Exists(path)
+}
+
+func (c *stallConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	c.wait()
+	defer c.mux.Unlock()
+	return c.fakeConn.Create(path, data, flags, acl)
+}
+
+func (c *stallConn) Get(path string) ([]byte, *zk.Stat, error) {
+	c.wait()
+	defer c.mux.Unlock()
+	return c.fakeConn.Get(path)
+}
+
+func (c *stallConn) Children(path string) ([]string, *zk.Stat, error) {
+	c.wait()
+	defer c.mux.Unlock()
+	return c.fakeConn.Children(path)
+}
+
+func (c *stallConn) Delete(path string, version int32) error {
+	c.wait()
+	defer c.mux.Unlock()
+	return c.fakeConn.Delete(path, version)
+}
+
+func (c *stallConn) has(path string) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_, ok := c.nodes[path]
+	return ok
+}
+
+func TestZkCallsHonorContext(t *testing.T) {
+	conn := &stallConn{fakeConn: newFakeConn(), release: make(chan struct{})}
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	z.conn = conn
+
+	calls := map[string]func(ctx context.Context) error{
+		"GetPathData": func(ctx context.Context) error {
+			_, _, err := z.GetPathDataCtx(ctx, "/gateway_servers_config_orders")
+			return err
+		},
+		"SetPathData": func(ctx context.Context) error {
+			return z.SetPathDataCtx(ctx, "/gateway_servers_config_orders", []byte("conf"), 0)
+		},
+		"GetServerListByPath": func(ctx context.Context) error {
+			_, err := z.GetServerListByPathCtx(ctx, "/gateway_servers_orders")
+			return err
+		},
+		"RegistServerPath": func(ctx context.Context) error {
+			return z.RegistServerPathCtx(ctx, "/gateway_servers_orders", "127.0.0.1:8001")
+		},
+	}
+	for name, call := range calls {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := call(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("%s got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("%s returned after %v", name, elapsed)
+		}
+	}
+	//已取消的 ctx 不发起调用
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := z.GetServerListByPathCtx(ctx, "/gateway_servers_orders"); err != context.Canceled {
+		t.Fatalf("canceled ctx got %v", err)
+	}
+
+	//zk 恢复后，超时的注册在后台完成并被清理
+	close(conn.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.has("/gateway_servers_orders/127.0.0.1:8001") || !conn.has("/gateway_servers_orders") {
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned registration not cleaned up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := z.RegistServerPath("/gateway_servers_orders", "127.0.0.1:8002"); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.has("/gateway_servers_orders/127.0.0.1:8002") {
+		t.Fatal("registration without context failed")
+	}
+}
@@ -0,0 +1,233 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 选举节点的名称前缀，zk 在其后追加 10 位序号
+const electionPrefix = "n_"
+
+var ErrElectionNodeLost = errors.New("election node lost")
+
+// 基于临时顺序节点的主备选举：序号最小的节点为 leader，其余节点只 watch 前一个节点，
+// 避免 leader 变化时所有节点同时被唤醒。连接断开或会话过期后先放弃 leader 身份，
+// 重新建立会话后重新参选
+type LeaderElector struct {
+	OnElected  func() //成为 leader 时调用
+	OnResigned func() //失去 leader 身份时调用，包括 Close
+
+	z    *ZkManager
+	path string
+	id   []byte //写入选举节点的数据，如实例地址
+
+	mux       sync.Mutex
+	node      string //本实例的选举节点
+	leader    bool
+	connected bool          //断开期间 zk 可能已让节点过期并选出新 leader，不能再认为自己是 leader
+	resumed   chan struct{} //重新建立会话时通知 run 重新参选
+	cancel    context.CancelFunc
+	done      chan struct{}
+	unhook    func() //取消会话状态回调
+}
+
+func NewLeaderElector(z *ZkManager, electionPath, id string) *LeaderElector {
+	return &LeaderElector{z: z, path: electionPath, id: []byte(id)}
+}
+
+// 开始参选，直到 Close 时退出并删除选举节点
+func (e *LeaderElector) Start() error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.cancel != nil {
+		return errors.New("elector already started")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel, e.done = cancel, make(chan struct{})
+	e.connected, e.resumed = true, make(chan struct{}, 1)
+	e.unhook = e.z.OnStateChange(e.onStateChange)
+	go e.run(ctx)
+	return nil
+}
+
+// 客户端只在重连后才报告会话过期，分区期间旧 leader 收不到 watch 错误，
+// 所以断开时立即放弃 leader 身份，恢复会话后再按节点序号重新判断
+func (e *LeaderElector) onStateChange(state zk.State) {
+	switch state {
+	case zk.StateDisconnected:
+		e.mux.Lock()
+		e.connected = false
+		e.mux.Unlock()
+		e.setLeader(false)
+	case zk.StateHasSession:
+		e.mux.Lock()
+		e.connected = true
+		e.mux.Unlock()
+		select {
+		case e.resumed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *LeaderElector) IsLeader() bool {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.leader
+}
+
+// 退出选举，是 leader 时先调用 OnResigned 再删除节点，让下一个节点接任；之后可以再次 Start
+func (e *LeaderElector) Close() {
+	e.mux.Lock()
+	cancel, done, unhook := e.cancel, e.done, e.unhook
+	e.mux.Unlock()
+	if cancel == nil {
+		return
+	}
+	unhook()
+	cancel()
+	<-done
+	e.setLeader(false)
+	e.mux.Lock()
+	node := e.node
+	e.node = ""
+	e.cancel, e.unhook = nil, nil
+	e.mux.Unlock()
+	if node != "" {
+		if err := e.z.getConn().Delete(node, -1); err != nil && err != zk.ErrNoNode {
+			fmt.Println("Delete election node error", node, err)
+		}
+	}
+}
+
+func (e *LeaderElector) run(ctx context.Context) {
+	defer close(e.done)
+	failures := 0
+	for {
+		events, err := e.campaign()
+		if err != nil {
+			e.setLeader(false)
+			failures++
+			if !e.z.watchFailed(ctx, nil, "election", e.path, err, failures) {
+				return
+			}
+			continue
+		}
+		failures = 0
+		select {
+		case evt := <-events:
+			//会话过期或连接关闭，节点可能已经不在，重新参选前不能再认为自己是 leader
+			if evt.Err != nil {
+				fmt.Println("election watch error", e.path, evt.Err)
+				e.setLeader(false)
+			}
+		case <-e.resumed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 确保本实例的节点存在，按序号判断是否为 leader，返回需要等待的 watch
+func (e *LeaderElector) campaign() (<-chan zk.Event, error) {
+	conn := e.z.getConn()
+	for {
+		e.mux.Lock()
+		node := e.node
+		e.mux.Unlock()
+		if node != "" {
+			if ok, _, err := conn.Exists(node); err != nil {
+				return nil, err
+			} else if !ok {
+				//会话过期后临时节点已被删除
+				node = ""
+			}
+		}
+		if node == "" {
+			if err := createAll(conn, e.path, nil, e.z.acl); err != nil {
+				return nil, err
+			}
+			created, err := conn.Create(e.path+"/"+electionPrefix, e.id, zk.FlagEphemeral|zk.FlagSequence, e.z.acl)
+			if err != nil {
+				return nil, authError("create", e.path, err)
+			}
+			node = created
+			e.mux.Lock()
+			e.node = node
+			e.mux.Unlock()
+		}
+
+		children, _, err := conn.Children(e.path)
+		if err != nil {
+			return nil, err
+		}
+		candidates := electionNodes(children)
+		index := sort.SearchStrings(candidates, path.Base(node))
+		if index == len(candidates) || candidates[index] != path.Base(node) {
+			e.mux.Lock()
+			e.node = ""
+			e.mux.Unlock()
+			return nil, ErrElectionNodeLost
+		}
+		if index == 0 {
+			//leader watch 自己的节点，会话过期时收到错误事件
+			_, _, events, err := conn.GetW(node)
+			if err != nil {
+				return nil, err
+			}
+			e.setLeader(true)
+			return events, nil
+		}
+		e.setLeader(false)
+		_, _, events, err := conn.GetW(e.path + "/" + candidates[index-1])
+		if err == zk.ErrNoNode {
+			//前一个节点刚刚退出，重新判断
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+}
+
+// 序号位数相同，按名称排序即按序号排序
+func electionNodes(children []string) []string {
+	nodes := make([]string, 0, len(children))
+	for _, child := range children {
+		if strings.HasPrefix(child, electionPrefix) {
+			nodes = append(nodes, child)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func (e *LeaderElector) setLeader(leader bool) {
+	e.mux.Lock()
+	//与断开回调在同一把锁内判断，断开后不会再被 campaign 选为 leader
+	leader = leader && e.connected
+	changed := e.leader != leader
+	e.leader = leader
+	e.mux.Unlock()
+	if !changed {
+		return
+	}
+	if leader {
+		fmt.Println("elected leader", e.path, string(e.id))
+		if e.OnElected != nil {
+			e.OnElected()
+		}
+	} else {
+		fmt.Println("resigned leader", e.path, string(e.id))
+		if e.OnResigned != nil {
+			e.OnResigned()
+		}
+	}
+}
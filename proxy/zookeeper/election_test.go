@@ -0,0 +1,345 @@
+package zookeeper
+
+import (
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 多个会话共享的内存 zk，支持顺序节点、临时节点与节点的 watch
+type sessionZk struct {
+	mux     sync.Mutex
+	data    *fakeConn
+	seq     int
+	owners  map[string]*sessionConn //临时节点 -> 所属会话
+	watches map[string][]chan zk.Event
+}
+
+func newSessionZk() *sessionZk {
+	return &sessionZk{data: newFakeConn(), owners: map[string]*sessionConn{}, watches: map[string][]chan zk.Event{}}
+}
+
+// 一个会话，关闭时删除其临时节点，并向其 watch 发送错误事件
+type sessionConn struct {
+	zk      *sessionZk
+	watches []chan zk.Event
+	closed  bool
+}
+
+func (s *sessionZk) connect() *sessionConn {
+	return &sessionConn{zk: s}
+}
+
+// 在持有锁时调用
+func (s *sessionZk) fire(path string, typ zk.EventType) {
+	for _, ch := range s.watches[path] {
+		select {
+		case ch <- zk.Event{Type: typ, Path: path}:
+		default:
+		}
+	}
+	delete(s.watches, path)
+}
+
+func (c *sessionConn) watch(path string) <-chan zk.Event {
+	ch := make(chan zk.Event, 1)
+	c.zk.watches[path] = append(c.zk.watches[path], ch)
+	c.watches = append(c.watches, ch)
+	return ch
+}
+
+func (c *sessionConn) AddAuth(scheme string, auth []byte) error { return nil }
+
+func (c *sessionConn) Exists(path string) (bool, *zk.Stat, error) {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	if c.closed {
+		return false, nil, zk.ErrClosing
+	}
+	return c.zk.data.Exists(path)
+}
+
+func (c *sessionConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	if c.closed {
+		return "", zk.ErrClosing
+	}
+	if flags&zk.FlagSequence != 0 {
+		path = fmt.Sprintf("%s%010d", path, c.zk.seq)
+		c.zk.seq++
+	}
+	created, err := c.zk.data.Create(path, data, flags, acl)
+	if err == nil && flags&zk.FlagEphemeral != 0 {
+		c.zk.owners[created] = c
+	}
+	return created, err
+}
+
+func (c *sessionConn) Get(path string) ([]byte, *zk.Stat, error) {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	if c.closed {
+		return nil, nil, zk.ErrClosing
+	}
+	return c.zk.data.Get(path)
+}
+
+func (c *sessionConn) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	if c.closed {
+		return nil, nil, nil, zk.ErrClosing
+	}
+	data, stat, err := c.zk.data.Get(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return data, stat, c.watch(path), nil
+}
+
+func (c *sessionConn) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	stat, err := c.zk.data.Set(path, data, version)
+	if err == nil {
+		c.zk.fire(path, zk.EventNodeDataChanged)
+	}
+	return stat, err
+}
+
+func (c *sessionConn) Children(path string) ([]string, *zk.Stat, error) {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	if c.closed {
+		return nil, nil, zk.ErrClosing
+	}
+	return c.zk.data.Children(path)
+}
+
+func (c *sessionConn) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	list, stat, err := c.Children(path)
+	return list, stat, make(chan zk.Event), err
+}
+
+func (c *sessionConn) Delete(path string, version int32) error {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	if c.closed {
+		return zk.ErrClosing
+	}
+	return c.delete(path, version)
+}
+
+func (c *sessionConn) delete(path string, version int32) error {
+	if err := c.zk.data.Delete(path, version); err != nil {
+		return err
+	}
+	delete(c.zk.owners, path)
+	c.zk.fire(path, zk.EventNodeDeleted)
+	return nil
+}
+
+func (c *sessionConn) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	return nil, zk.ErrAPIError
+}
+
+// 模拟会话结束：临时节点被删除，本会话的 watch 收到错误
+func (c *sessionConn) Close() {
+	c.zk.mux.Lock()
+	defer c.zk.mux.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for path, owner := range c.zk.owners {
+		if owner == c {
+			c.delete(path, -1)
+		}
+	}
+	for _, ch := range c.watches {
+		select {
+		case ch <- zk.Event{Type: zk.EventNotWatching, Err: zk.ErrSessionExpired}:
+		default:
+		}
+	}
+}
+
+// 每个 ZkManager 使用不同的地址，各自拥有一个会话
+func useSessionZk(t *testing.T) (*sessionZk, *[]zk.EventCallback) {
+	s := newSessionZk()
+	callbacks := &[]zk.EventCallback{}
+	var mux sync.Mutex
+	connect, manager, sleep := zkConnect, defaultConnManager, reconnectSleep
+	zkConnect = func(hosts []string, timeout time.Duration, dialer zk.Dialer, callback zk.EventCallback) (zkConn, error) {
+		mux.Lock()
+		*callbacks = append(*callbacks, callback)
+		mux.Unlock()
+		return s.connect(), nil
+	}
+	reconnectSleep = func(d time.Duration, stop <-chan struct{}) bool { return true }
+	defaultConnManager = newConnManager()
+	t.Cleanup(func() { zkConnect, defaultConnManager, reconnectSleep = connect, manager, sleep })
+	return s, callbacks
+}
+
+type testElector struct {
+	*LeaderElector
+	elected, resigned int32
+}
+
+func newTestElector(t *testing.T, host string) *testElector {
+	z := NewZkManager([]string{host}, WithReconnect(ReconnectPolicy{InitialInterval: time.Millisecond, Jitter: -1}))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(z.Close)
+	e := &testElector{LeaderElector: NewLeaderElector(z, "/gateway_election", host)}
+	e.OnElected = func() { atomic.AddInt32(&e.elected, 1) }
+	e.OnResigned = func() { atomic.AddInt32(&e.resigned, 1) }
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+// 等待 leader 为 want，期间任何时刻最多只有一个 leader
+func waitLeader(t *testing.T, want *testElector, electors ...*testElector) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		leaders := 0
+		for _, e := range electors {
+			if e.IsLeader() {
+				leaders++
+			}
+		}
+		if leaders > 1 {
+			t.Fatalf("%d leaders", leaders)
+		}
+		if leaders == 1 && want.IsLeader() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("leader not elected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitElectionNodes(t *testing.T, s *sessionZk, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mux.Lock()
+		children, _, _ := s.data.Children("/gateway_election")
+		s.mux.Unlock()
+		if len(children) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("election nodes %v", children)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLeaderElectorFailover(t *testing.T) {
+	s, _ := useSessionZk(t)
+	a := newTestElector(t, "10.0.0.1:2181")
+	waitLeader(t, a, a)
+	b := newTestElector(t, "10.0.0.2:2181")
+	waitElectionNodes(t, s, 2)
+	waitLeader(t, a, a, b)
+
+	//leader 退出后备节点接任
+	a.Close()
+	waitLeader(t, b, a, b)
+	if atomic.LoadInt32(&a.elected) != 1 || atomic.LoadInt32(&a.resigned) != 1 {
+		t.Fatalf("a elected %d resigned %d", a.elected, a.resigned)
+	}
+	if atomic.LoadInt32(&b.elected) != 1 {
+		t.Fatalf("b elected %d", b.elected)
+	}
+}
+
+func TestLeaderElectorSessionExpiry(t *testing.T) {
+	s, callbacks := useSessionZk(t)
+	a := newTestElector(t, "10.0.0.1:2181")
+	waitLeader(t, a, a)
+	b := newTestElector(t, "10.0.0.2:2181")
+	waitElectionNodes(t, s, 2)
+	waitLeader(t, a, a, b)
+
+	//a 的会话过期：放弃 leader，b 接任，a 重连后作为备节点重新参选
+	(*callbacks)[0](zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	waitLeader(t, b, a, b)
+	waitElectionNodes(t, s, 2)
+	if atomic.LoadInt32(&a.resigned) != 1 || a.IsLeader() {
+		t.Fatalf("a resigned %d", a.resigned)
+	}
+
+	b.Close()
+	waitLeader(t, a, a, b)
+	if atomic.LoadInt32(&a.elected) != 2 {
+		t.Fatalf("a elected %d", a.elected)
+	}
+}
+
+func TestLeaderElectorDisconnect(t *testing.T) {
+	s, callbacks := useSessionZk(t)
+	a := newTestElector(t, "10.0.0.1:2181")
+	waitLeader(t, a, a)
+	b := newTestElector(t, "10.0.0.2:2181")
+	waitElectionNodes(t, s, 2)
+	waitLeader(t, a, a, b)
+
+	//分区期间 a 收不到会话过期，断开时就要放弃 leader
+	(*callbacks)[0](zk.Event{Type: zk.EventSession, State: zk.StateDisconnected})
+	deadline := time.Now().Add(5 * time.Second)
+	for a.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("a still leader after disconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&a.resigned) != 1 {
+		t.Fatalf("a resigned %d", a.resigned)
+	}
+
+	//恢复原会话，节点仍是序号最小的，重新成为 leader
+	(*callbacks)[0](zk.Event{Type: zk.EventSession, State: zk.StateHasSession})
+	waitLeader(t, a, a, b)
+	if atomic.LoadInt32(&a.elected) != 2 {
+		t.Fatalf("a elected %d", a.elected)
+	}
+}
+
+func TestLeaderElectorCloseRemovesHook(t *testing.T) {
+	useSessionZk(t)
+	a := newTestElector(t, "10.0.0.1:2181")
+	waitLeader(t, a, a)
+	hooks := func() int {
+		a.z.hooks.mux.Lock()
+		defer a.z.hooks.mux.Unlock()
+		return len(a.z.hooks.hooks)
+	}
+	for i := 0; i < 2; i++ {
+		if n := hooks(); n != 1 {
+			t.Fatalf("round %d: %d state hooks while running", i, n)
+		}
+		a.Close()
+		if n := hooks(); n != 0 {
+			t.Fatalf("round %d: %d state hooks after close", i, n)
+		}
+		//关闭后可以重新参选，不会重复注册回调
+		if err := a.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitLeader(t, a, a)
+	}
+}
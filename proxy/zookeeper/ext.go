@@ -10,6 +10,11 @@ func (z *ZkManager) RegistServer(module, host string) (err error) {
 	return z.RegistServerPath(nodePath, host)
 }
 
+//注销服务
+func (z *ZkManager) DeregisterServer(module, host string) error {
+	return z.DeregisterServerPath(z.pathPrefix+module, host)
+}
+
 func (z *ZkManager) GetServerList(module string) (list []string, err error) {
 	return z.GetServerListByPath(z.pathPrefix + module)
 }
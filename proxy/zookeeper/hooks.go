@@ -0,0 +1,103 @@
+package zookeeper
+
+import (
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"sync"
+)
+
+// 会话状态回调。当前连接的每个会话事件(连接中、已建立会话、断开、过期等)按发生顺序
+// 在单独的 goroutine 中依次通知，同一事件按注册顺序调用各回调，每个回调至少收到一次。
+// 回调 panic 只记录日志，不影响其他回调与内置的重连、重新注册
+type stateHooks struct {
+	mux     sync.Mutex
+	hooks   []*stateHook
+	queue   []zk.State
+	running bool
+}
+
+// 用指针区分同一函数的多次注册
+type stateHook struct {
+	f func(zk.State)
+}
+
+func (h *stateHooks) add(f func(zk.State)) (remove func()) {
+	hook := &stateHook{f: f}
+	h.mux.Lock()
+	h.hooks = append(h.hooks, hook)
+	h.mux.Unlock()
+	return func() {
+		h.mux.Lock()
+		defer h.mux.Unlock()
+		for i, registered := range h.hooks {
+			if registered == hook {
+				h.hooks = append(h.hooks[:i:i], h.hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (h *stateHooks) notify(state zk.State) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if len(h.hooks) == 0 {
+		return
+	}
+	h.queue = append(h.queue, state)
+	if !h.running {
+		h.running = true
+		go h.drain()
+	}
+}
+
+func (h *stateHooks) drain() {
+	for {
+		h.mux.Lock()
+		if len(h.queue) == 0 {
+			h.running = false
+			h.mux.Unlock()
+			return
+		}
+		state := h.queue[0]
+		h.queue = h.queue[1:]
+		hooks := make([]*stateHook, len(h.hooks))
+		copy(hooks, h.hooks)
+		h.mux.Unlock()
+		for _, hook := range hooks {
+			callHook(hook.f, state)
+		}
+	}
+}
+
+func callHook(hook func(zk.State), state zk.State) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("zk state hook panic", state, err)
+		}
+	}()
+	hook(state)
+}
+
+// 注册会话状态变化的回调，可以注册多个，调用返回的 remove 后不再通知
+func (z *ZkManager) OnStateChange(hook func(zk.State)) (remove func()) {
+	return z.hooks.add(hook)
+}
+
+// 会话过期时调用，此时临时节点已被删除，重连与重新注册由 ZkManager 完成
+func (z *ZkManager) OnSessionExpired(hook func()) (remove func()) {
+	return z.OnStateChange(func(state zk.State) {
+		if state == zk.StateExpired {
+			hook()
+		}
+	})
+}
+
+// 建立会话时调用，包括首次连接与重连成功
+func (z *ZkManager) OnConnected(hook func()) (remove func()) {
+	return z.OnStateChange(func(state zk.State) {
+		if state == zk.StateHasSession {
+			hook()
+		}
+	})
+}
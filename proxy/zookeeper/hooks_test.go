@@ -0,0 +1,72 @@
+package zookeeper
+
+import (
+	"github.com/samuel/go-zookeeper/zk"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestZkStateHooks(t *testing.T) {
+	f := useFlakyConnect(t)
+	z := NewZkManager([]string{"10.0.0.1:2181"}, WithReconnect(ReconnectPolicy{Jitter: -1}))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if err := z.RegistServerPath("/gateway_servers_orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+
+	var mux sync.Mutex
+	var calls []string
+	record := func(name string) {
+		mux.Lock()
+		calls = append(calls, name)
+		mux.Unlock()
+	}
+	z.OnStateChange(func(state zk.State) {
+		panic("hook failed")
+	})
+	z.OnStateChange(func(state zk.State) {
+		record("state:" + state.String())
+	})
+	z.OnSessionExpired(func() { record("expired") })
+	z.OnConnected(func() { record("connected") })
+
+	//回调 panic 不影响其他回调与重连
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, successes := z.ReconnectCounts(); successes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := z.Reregistrations(); n != 1 {
+		t.Fatalf("reregistrations %d", n)
+	}
+	//只有当前连接的事件通知回调
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateDisconnected})
+	f.callbacks[1](zk.Event{Type: zk.EventSession, State: zk.StateConnected})
+	f.callbacks[1](zk.Event{Type: zk.EventSession, State: zk.StateHasSession})
+	f.callbacks[1](zk.Event{Type: zk.EventNodeCreated, Path: "/gateway_servers_orders"})
+
+	want := []string{"state:StateExpired", "expired", "state:StateConnected", "state:StateHasSession", "connected"}
+	for {
+		mux.Lock()
+		got := append([]string(nil), calls...)
+		mux.Unlock()
+		if reflect.DeepEqual(got, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("calls %v", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,126 @@
+package zookeeper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"sort"
+)
+
+// 当前的节点元数据版本
+const NodeMetadataSchema = 1
+
+// 注册在临时节点上的后端信息，以 JSON 保存为节点数据
+type NodeMetadata struct {
+	Schema   int               `json:"schema"`
+	Weight   int               `json:"weight,omitempty"`
+	Zone     string            `json:"zone,omitempty"`
+	Protocol string            `json:"protocol,omitempty"` //如 http、https、grpc
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// 带元数据的服务节点，没有数据的旧节点 Metadata 为零值
+type ServerNode struct {
+	Host     string
+	Metadata NodeMetadata
+}
+
+func (m NodeMetadata) Marshal() ([]byte, error) {
+	m.Schema = NodeMetadataSchema
+	return json.Marshal(m)
+}
+
+// 解析节点数据，空数据视为没有元数据，不认识的字段忽略，更高的版本返回错误
+func ParseNodeMetadata(data []byte) (NodeMetadata, error) {
+	var m NodeMetadata
+	if len(data) == 0 {
+		return m, nil
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return NodeMetadata{}, err
+	}
+	if m.Schema < 1 || m.Schema > NodeMetadataSchema {
+		return NodeMetadata{}, fmt.Errorf("unsupported node metadata schema %d", m.Schema)
+	}
+	return m, nil
+}
+
+// 创建带元数据的临时节点
+func (z *ZkManager) RegistServerMetadata(nodePath, host string, meta NodeMetadata) error {
+	return z.RegistServerMetadataCtx(context.Background(), nodePath, host, meta)
+}
+
+func (z *ZkManager) RegistServerMetadataCtx(ctx context.Context, nodePath, host string, meta NodeMetadata) error {
+	data, err := meta.Marshal()
+	if err != nil {
+		return err
+	}
+	return runCtx(ctx, func() error {
+		return z.registServerPath(nodePath, host, data)
+	}, func(err error) {
+		if err == nil {
+			z.deregisterServerPath(nodePath, host)
+		}
+	})
+}
+
+// 获取服务列表及每个节点的元数据
+func (z *ZkManager) GetServerNodesByPath(path string) ([]ServerNode, error) {
+	return z.GetServerNodesByPathCtx(context.Background(), path)
+}
+
+func (z *ZkManager) GetServerNodesByPathCtx(ctx context.Context, path string) ([]ServerNode, error) {
+	var nodes []ServerNode
+	err := runCtx(ctx, func() (err error) {
+		var list []string
+		list, err = z.getServerListByPath(path)
+		if err != nil {
+			return
+		}
+		nodes = z.serverNodes(path, list)
+		return
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// 读取子节点的元数据，读取期间被删除的节点跳过，元数据无法解析时按没有元数据处理
+func (z *ZkManager) serverNodes(path string, list []string) []ServerNode {
+	nodes := make([]ServerNode, 0, len(list))
+	for _, host := range list {
+		data, _, err := z.getConn().Get(path + "/" + host)
+		if err != nil {
+			fmt.Println("Get node data error", path+"/"+host, err)
+			continue
+		}
+		meta, err := ParseNodeMetadata(data)
+		if err != nil {
+			fmt.Println("Parse node metadata error", path+"/"+host, err)
+		}
+		nodes = append(nodes, ServerNode{Host: host, Metadata: meta})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Host < nodes[j].Host })
+	return nodes
+}
+
+// 获取服务列表及元数据，同时设置子节点变化的 watch。只在子节点增减时触发，节点元数据变化不会触发
+func (z *ZkManager) ChildrenNodesWCtx(ctx context.Context, path string) ([]ServerNode, <-chan zk.Event, error) {
+	var nodes []ServerNode
+	var events <-chan zk.Event
+	err := runCtx(ctx, func() (err error) {
+		var list []string
+		list, events, err = z.childrenW(path)
+		if err != nil {
+			return
+		}
+		nodes = z.serverNodes(path, list)
+		return
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodes, events, nil
+}
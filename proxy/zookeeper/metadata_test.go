@@ -0,0 +1,61 @@
+package zookeeper
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestZkServerMetadata(t *testing.T) {
+	conn := newFakeConn()
+	useFakeConn(t, conn)
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	meta := NodeMetadata{Weight: 30, Zone: "hz-a", Protocol: "http", Labels: map[string]string{"version": "v2"}}
+	if err := z.RegistServerMetadata("/gateway_servers_orders", "127.0.0.1:8001", meta); err != nil {
+		t.Fatal(err)
+	}
+	//旧的没有数据的节点
+	if err := z.RegistServerPath("/gateway_servers_orders", "127.0.0.1:8000"); err != nil {
+		t.Fatal(err)
+	}
+	//无法解析的数据按没有元数据处理
+	conn.nodes["/gateway_servers_orders/127.0.0.1:8002"] = []byte(`{"schema":99,"weight":5}`)
+
+	nodes, err := z.GetServerNodesByPath("/gateway_servers_orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Schema = NodeMetadataSchema
+	want := []ServerNode{{Host: "127.0.0.1:8000"}, {Host: "127.0.0.1:8001", Metadata: meta}, {Host: "127.0.0.1:8002"}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %+v want %+v", nodes, want)
+	}
+
+	//重新注册时更新元数据
+	if err := z.RegistServerMetadata("/gateway_servers_orders", "127.0.0.1:8001", NodeMetadata{Weight: 10}); err != nil {
+		t.Fatal(err)
+	}
+	nodes, _, err = z.ChildrenNodesWCtx(context.Background(), "/gateway_servers_orders")
+	if err != nil || nodes[1].Metadata.Weight != 10 || nodes[1].Metadata.Zone != "" {
+		t.Fatalf("nodes %+v, err %v", nodes, err)
+	}
+}
+
+func TestParseNodeMetadata(t *testing.T) {
+	if m, err := ParseNodeMetadata(nil); err != nil || !reflect.DeepEqual(m, NodeMetadata{}) {
+		t.Fatalf("empty data got %+v %v", m, err)
+	}
+	//不认识的字段忽略
+	m, err := ParseNodeMetadata([]byte(`{"schema":1,"weight":3,"extra":true}`))
+	if err != nil || m.Weight != 3 {
+		t.Fatalf("got %+v %v", m, err)
+	}
+	for _, data := range []string{`{"weight":3}`, `{"schema":2}`, `not json`} {
+		if _, err := ParseNodeMetadata([]byte(data)); err == nil {
+			t.Fatalf("%s parsed", data)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package zookeeper
+
+import (
+	"GO_GATEWAY/proxy/metrics"
+	"github.com/samuel/go-zookeeper/zk"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 连接事件与 watch 事件的类型
+const (
+	ZkEventConnect        = "connect"
+	ZkEventDisconnect     = "disconnect"
+	ZkEventExpired        = "expired"
+	ZkEventReregister     = "reregister"
+	ZkEventWatchReconnect = "watch_reestablish"
+)
+
+var (
+	zkEvents    = metrics.NewCounterVec("gateway_zk_events_total", "zk 连接与 watch 事件数，按类型(connect/disconnect/expired/reregister/watch_reestablish)统计", "event")
+	zkErrors    = metrics.NewCounterVec("gateway_zk_errors_total", "zk 调用失败次数，节点不存在或已存在不计入，按操作统计", "op")
+	zkLatency   = metrics.NewHistogramVec("gateway_zk_call_duration_ms", "zk 调用耗时，按操作(get/set/children/create/delete/exists/multi)统计", "op", metrics.LatencyBuckets)
+	zkConnected = metrics.NewGaugeVec("gateway_zk_connected", "zk 连接是否有可用会话，按 zk 地址统计", "hosts")
+)
+
+// 记录每次调用耗时与错误的连接
+type metricConn struct {
+	zkConn
+}
+
+func observeCall(op string, start time.Time, err error) {
+	zkLatency.Observe(op, float64(time.Since(start))/float64(time.Millisecond))
+	if err != nil && err != zk.ErrNoNode && err != zk.ErrNodeExists {
+		zkErrors.Inc(op)
+	}
+}
+
+func (c metricConn) Exists(path string) (bool, *zk.Stat, error) {
+	start := time.Now()
+	ok, stat, err := c.zkConn.Exists(path)
+	observeCall("exists", start, err)
+	return ok, stat, err
+}
+
+func (c metricConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	start := time.Now()
+	created, err := c.zkConn.Create(path, data, flags, acl)
+	observeCall("create", start, err)
+	return created, err
+}
+
+func (c metricConn) Get(path string) ([]byte, *zk.Stat, error) {
+	start := time.Now()
+	data, stat, err := c.zkConn.Get(path)
+	observeCall("get", start, err)
+	return data, stat, err
+}
+
+func (c metricConn) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	start := time.Now()
+	data, stat, events, err := c.zkConn.GetW(path)
+	observeCall("get", start, err)
+	return data, stat, events, err
+}
+
+func (c metricConn) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	start := time.Now()
+	stat, err := c.zkConn.Set(path, data, version)
+	observeCall("set", start, err)
+	return stat, err
+}
+
+func (c metricConn) Children(path string) ([]string, *zk.Stat, error) {
+	start := time.Now()
+	list, stat, err := c.zkConn.Children(path)
+	observeCall("children", start, err)
+	return list, stat, err
+}
+
+func (c metricConn) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	start := time.Now()
+	list, stat, events, err := c.zkConn.ChildrenW(path)
+	observeCall("children", start, err)
+	return list, stat, events, err
+}
+
+func (c metricConn) Delete(path string, version int32) error {
+	start := time.Now()
+	err := c.zkConn.Delete(path, version)
+	observeCall("delete", start, err)
+	return err
+}
+
+func (c metricConn) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	start := time.Now()
+	res, err := c.zkConn.Multi(ops...)
+	observeCall("multi", start, err)
+	return res, err
+}
+
+// 会话事件计入连接事件与连接状态
+func observeSessionEvent(hosts string, evt zk.Event) {
+	if evt.Type != zk.EventSession {
+		return
+	}
+	switch evt.State {
+	case zk.StateHasSession:
+		zkEvents.Inc(ZkEventConnect)
+		zkConnected.Set(hosts, 1)
+	case zk.StateDisconnected:
+		zkEvents.Inc(ZkEventDisconnect)
+		zkConnected.Set(hosts, 0)
+	case zk.StateExpired:
+		zkEvents.Inc(ZkEventExpired)
+		zkConnected.Set(hosts, 0)
+	}
+}
+
+// 指标标签只使用地址，不包含认证信息
+func (z *ZkManager) metricHosts() string {
+	hosts := append([]string(nil), z.hosts...)
+	sort.Strings(hosts)
+	return strings.Join(hosts, ",")
+}
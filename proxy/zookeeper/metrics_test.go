@@ -0,0 +1,120 @@
+package zookeeper
+
+import (
+	"context"
+	"github.com/samuel/go-zookeeper/zk"
+	"testing"
+	"time"
+)
+
+// 指标是全局的，按调用前后的差值判断
+type zkMetricsDelta struct {
+	events map[string]int64
+	errors map[string]int64
+	calls  map[string]int64
+}
+
+func zkMetricsNow() zkMetricsDelta {
+	d := zkMetricsDelta{events: zkEvents.Snapshot(), errors: zkErrors.Snapshot(), calls: map[string]int64{}}
+	for op, h := range zkLatency.Snapshot() {
+		d.calls[op] = h.Count
+	}
+	return d
+}
+
+func (d zkMetricsDelta) since(before zkMetricsDelta) zkMetricsDelta {
+	diff := zkMetricsDelta{events: map[string]int64{}, errors: map[string]int64{}, calls: map[string]int64{}}
+	for k, v := range d.events {
+		diff.events[k] = v - before.events[k]
+	}
+	for k, v := range d.errors {
+		diff.errors[k] = v - before.errors[k]
+	}
+	for k, v := range d.calls {
+		diff.calls[k] = v - before.calls[k]
+	}
+	return diff
+}
+
+func TestZkMetricsCalls(t *testing.T) {
+	useFakeConn(t, newFakeConn())
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	before := zkMetricsNow()
+	if err := z.SetPathData("/gateway_conf/orders", []byte("v1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	z.GetPathData("/gateway_conf/orders")
+	z.GetPathData("/gateway_conf/missing")
+	z.GetServerListByPath("/gateway_conf")
+	if _, err := z.getConn().Set("/gateway_conf/orders", []byte("v2"), 5); err != zk.ErrBadVersion {
+		t.Fatalf("err %v", err)
+	}
+	d := zkMetricsNow().since(before)
+	//不存在的节点不计为错误
+	if d.calls["get"] != 2 || d.calls["children"] != 1 || d.calls["set"] != 1 || d.calls["create"] != 2 {
+		t.Fatalf("calls %v", d.calls)
+	}
+	if d.errors["get"] != 0 || d.errors["set"] != 1 {
+		t.Fatalf("errors %v", d.errors)
+	}
+}
+
+func TestZkMetricsSessionEvents(t *testing.T) {
+	f := useFlakyConnect(t)
+	z := NewZkManager([]string{"10.0.0.2:2181", "10.0.0.1:2181"}, WithReconnect(ReconnectPolicy{Jitter: -1}))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if err := z.RegistServerPath("/gateway_servers_orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	hosts := "10.0.0.1:2181,10.0.0.2:2181"
+	before := zkMetricsNow()
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateHasSession})
+	if zkConnected.Get(hosts) != 1 {
+		t.Fatal("not connected")
+	}
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	if zkConnected.Get(hosts) != 0 {
+		t.Fatal("still connected after expiry")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, successes := z.ReconnectCounts(); successes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	//旧连接的事件不计入
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateDisconnected})
+	f.callbacks[1](zk.Event{Type: zk.EventSession, State: zk.StateHasSession})
+	d := zkMetricsNow().since(before)
+	if d.events[ZkEventConnect] != 2 || d.events[ZkEventExpired] != 1 || d.events[ZkEventDisconnect] != 0 || d.events[ZkEventReregister] != 1 {
+		t.Fatalf("events %v", d.events)
+	}
+	if zkConnected.Get(hosts) != 1 {
+		t.Fatal("not connected after reconnect")
+	}
+}
+
+func TestZkMetricsWatchReestablish(t *testing.T) {
+	conn := &watchConn{fakeConn: newFakeConn(), fails: 2}
+	z := newWatchManager(conn)
+	before := zkMetricsNow()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshots, _ := z.WatchServerListByPathCtx(ctx, "/gateway_servers_orders")
+	<-snapshots
+	d := zkMetricsNow().since(before)
+	if d.events[ZkEventWatchReconnect] != 1 || d.errors["children"] != 2 {
+		t.Fatalf("events %v errors %v", d.events, d.errors)
+	}
+}
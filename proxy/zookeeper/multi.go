@@ -0,0 +1,196 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ZkOpType int
+
+const (
+	ZkOpCreate ZkOpType = iota
+	ZkOpSet
+	ZkOpDelete
+	ZkOpCheck //只检查版本，用于依赖其他节点未变化的更新
+)
+
+// 事务中的一个操作
+type ZkOp struct {
+	Type    ZkOpType
+	Path    string
+	Data    []byte
+	Version int32 //Set、Delete、Check 期望的版本，-1 表示不检查
+	Flags   int32 //Create 的节点类型，如 zk.FlagEphemeral
+}
+
+func CreateOp(path string, data []byte, flags int32) ZkOp {
+	return ZkOp{Type: ZkOpCreate, Path: path, Data: data, Flags: flags}
+}
+
+func SetOp(path string, data []byte, version int32) ZkOp {
+	return ZkOp{Type: ZkOpSet, Path: path, Data: data, Version: version}
+}
+
+func DeleteOp(path string, version int32) ZkOp {
+	return ZkOp{Type: ZkOpDelete, Path: path, Version: version}
+}
+
+func CheckOp(path string, version int32) ZkOp {
+	return ZkOp{Type: ZkOpCheck, Path: path, Version: version}
+}
+
+// 事务中每个操作的结果，失败时出错的操作 Err 为具体原因
+type ZkOpResult struct {
+	Path string
+	Stat *zk.Stat //Set 后的节点状态
+	Err  error
+}
+
+// 原子地执行一批操作，全部成功或全部不生效
+func (z *ZkManager) Multi(ops ...ZkOp) ([]ZkOpResult, error) {
+	return z.MultiCtx(context.Background(), ops...)
+}
+
+// 超时返回后事务可能仍会在后台完成
+func (z *ZkManager) MultiCtx(ctx context.Context, ops ...ZkOp) ([]ZkOpResult, error) {
+	var results []ZkOpResult
+	err := runCtx(ctx, func() (err error) {
+		results, err = z.multi(ops)
+		return
+	}, nil)
+	if err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+func (z *ZkManager) multi(ops []ZkOp) ([]ZkOpResult, error) {
+	reqs := make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		switch op.Type {
+		case ZkOpCreate:
+			reqs = append(reqs, &zk.CreateRequest{Path: op.Path, Data: op.Data, Acl: z.acl, Flags: op.Flags})
+		case ZkOpSet:
+			reqs = append(reqs, &zk.SetDataRequest{Path: op.Path, Data: op.Data, Version: op.Version})
+		case ZkOpDelete:
+			reqs = append(reqs, &zk.DeleteRequest{Path: op.Path, Version: op.Version})
+		case ZkOpCheck:
+			reqs = append(reqs, &zk.CheckVersionRequest{Path: op.Path, Version: op.Version})
+		default:
+			return nil, fmt.Errorf("unknown zk op type %d", op.Type)
+		}
+	}
+	resps, err := z.getConn().Multi(reqs...)
+	results := make([]ZkOpResult, len(ops))
+	for i, op := range ops {
+		results[i].Path = op.Path
+		if i < len(resps) {
+			results[i].Stat = resps[i].Stat
+			results[i].Err = authError("multi", op.Path, resps[i].Error)
+		}
+	}
+	if err != nil {
+		fmt.Println("Multi error", err)
+		//返回出错的操作，便于定位
+		for _, r := range results {
+			if r.Err != nil && errors.Is(r.Err, err) {
+				return results, &MultiError{Path: r.Path, Err: r.Err}
+			}
+		}
+		return results, err
+	}
+	return results, nil
+}
+
+// 事务中某个操作失败
+type MultiError struct {
+	Path string
+	Err  error
+}
+
+func (e *MultiError) Error() string {
+	return "zk multi " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *MultiError) Unwrap() error {
+	return e.Err
+}
+
+// SetPathData 的批量版本：在一个事务中写入多个节点，不存在的节点新建，已存在的按读取时的版本更新，
+// 读取后被其他客户端修改时整批失败(zk.ErrBadVersion)。缺少的父节点在事务前创建
+func (z *ZkManager) SetPathDataBatch(configs map[string][]byte) error {
+	return z.SetPathDataBatchCtx(context.Background(), configs)
+}
+
+func (z *ZkManager) SetPathDataBatchCtx(ctx context.Context, configs map[string][]byte) error {
+	return runCtx(ctx, func() error {
+		return z.setPathDataBatch(configs)
+	}, nil)
+}
+
+func (z *ZkManager) setPathDataBatch(configs map[string][]byte) error {
+	paths := make([]string, 0, len(configs))
+	for path := range configs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	//先检查全部路径，不合法时不创建任何父节点
+	for _, path := range paths {
+		if !validBatchPath(path) {
+			return &MultiError{Path: path, Err: zk.ErrInvalidPath}
+		}
+	}
+	ops := make([]ZkOp, 0, len(paths))
+	for _, path := range paths {
+		ex, stat, err := z.getConn().Exists(path)
+		if err != nil {
+			fmt.Println("Exists error", path)
+			return err
+		}
+		if ex {
+			ops = append(ops, SetOp(path, configs[path], stat.Version))
+			continue
+		}
+		if parent := path[:strings.LastIndex(path, "/")]; parent != "" {
+			if err := z.CreateAll(parent, nil, z.acl); err != nil {
+				return err
+			}
+		}
+		ops = append(ops, CreateOp(path, configs[path], 0))
+	}
+	_, err := z.multi(ops)
+	return err
+}
+
+// 以 / 开头、不以 / 结尾且没有空的路径段，如 /gateway_conf/routes
+func validBatchPath(path string) bool {
+	return len(path) > 1 && path[0] == '/' && !strings.HasSuffix(path, "/") && !strings.Contains(path, "//")
+}
+
+// 默认的 watch 去抖时间，事务或批量修改产生的多个事件合并为一次更新
+const DefaultWatchDebounce = 50 * time.Millisecond
+
+// 收到 watch 事件后等待 d 再重新读取，0 表示不等待
+func WithWatchDebounce(d time.Duration) ZkOption {
+	return func(z *ZkManager) {
+		z.watchDebounce = d
+	}
+}
+
+// 等待去抖时间，ctx 结束时返回 false
+func (z *ZkManager) debounce(ctx context.Context) bool {
+	if z.watchDebounce <= 0 {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(z.watchDebounce):
+		return true
+	}
+}
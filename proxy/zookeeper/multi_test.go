@@ -0,0 +1,115 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZkMultiAllOrNothing(t *testing.T) {
+	conn := newFakeConn()
+	useFakeConn(t, conn)
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.SetPathDataBatch(map[string][]byte{
+		"/gateway_conf/routes":       []byte("r1"),
+		"/gateway_conf/pools/orders": []byte("p1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if string(conn.nodes["/gateway_conf/routes"]) != "r1" || string(conn.nodes["/gateway_conf/pools/orders"]) != "p1" {
+		t.Fatalf("nodes %v", conn.nodes)
+	}
+
+	results, err := z.Multi(
+		SetOp("/gateway_conf/routes", []byte("r2"), 0),
+		CreateOp("/gateway_conf/pools/users", []byte("p2"), 0),
+		SetOp("/gateway_conf/pools/orders", []byte("p2"), 0),
+	)
+	if err != nil || len(results) != 3 || results[0].Stat.Version != 1 {
+		t.Fatalf("multi got %+v %v", results, err)
+	}
+
+	//其中一个操作的版本过期，整批不生效
+	before := map[string][]byte{}
+	for k, v := range conn.nodes {
+		before[k] = v
+	}
+	results, err = z.Multi(
+		SetOp("/gateway_conf/routes", []byte("r3"), 1),
+		DeleteOp("/gateway_conf/pools/users", -1),
+		SetOp("/gateway_conf/pools/orders", []byte("p3"), 0),
+	)
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) || multiErr.Path != "/gateway_conf/pools/orders" || !errors.Is(err, zk.ErrBadVersion) {
+		t.Fatalf("stale version got %v", err)
+	}
+	if results[2].Err != zk.ErrBadVersion {
+		t.Fatalf("results %+v", results)
+	}
+	if !reflect.DeepEqual(conn.nodes, before) {
+		t.Fatalf("partial apply: %v", conn.nodes)
+	}
+
+	//批量写入读取版本后被修改
+	conn.versions["/gateway_conf/routes"] = 5
+	check, _ := z.Multi(CheckOp("/gateway_conf/routes", 5))
+	if len(check) != 1 {
+		t.Fatal("check op not applied")
+	}
+	if _, err := z.Multi(CheckOp("/gateway_conf/routes", 4), SetOp("/gateway_conf/pools/orders", []byte("p4"), -1)); !errors.Is(err, zk.ErrBadVersion) {
+		t.Fatalf("check op got %v", err)
+	}
+	if string(conn.nodes["/gateway_conf/pools/orders"]) != "p2" {
+		t.Fatal("set applied despite failed check")
+	}
+}
+
+func TestZkBatchInvalidPath(t *testing.T) {
+	conn := newFakeConn()
+	useFakeConn(t, conn)
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"routes", "", "/", "/gateway_conf/", "/gateway_conf//routes"} {
+		err := z.SetPathDataBatch(map[string][]byte{"/gateway_conf/pools/orders": []byte("p1"), path: []byte("r1")})
+		var multiErr *MultiError
+		if !errors.As(err, &multiErr) || multiErr.Path != path || !errors.Is(err, zk.ErrInvalidPath) {
+			t.Fatalf("%q got %v", path, err)
+		}
+	}
+	if len(conn.nodes) != 0 {
+		t.Fatalf("nodes created for invalid batch: %v", conn.nodes)
+	}
+}
+
+func TestZkWatchDebounce(t *testing.T) {
+	conn := &watchConn{fakeConn: newFakeConn()}
+	conn.nodes["/gateway_servers_orders/127.0.0.1:8001"] = nil
+	z := newWatchManager(conn)
+	z.watchDebounce = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshots, _ := z.WatchServerListByPathCtx(ctx, "/gateway_servers_orders")
+	<-snapshots
+
+	//事务产生的多个变化只产生一次更新
+	conn.fire(func() { conn.nodes["/gateway_servers_orders/127.0.0.1:8002"] = nil }, zk.Event{Type: zk.EventNodeChildrenChanged}, 0)
+	conn.mux.Lock()
+	conn.nodes["/gateway_servers_orders/127.0.0.1:8003"] = nil
+	conn.mux.Unlock()
+	select {
+	case list := <-snapshots:
+		if len(list) != 3 {
+			t.Fatalf("snapshot %v", list)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update")
+	}
+}
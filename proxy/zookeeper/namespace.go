@@ -0,0 +1,137 @@
+package zookeeper
+
+import (
+	"errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"path"
+	"strings"
+)
+
+var ErrPathEscapesNamespace = errors.New("zk path escapes namespace")
+
+// 所有节点路径都放在 namespace 下，多个团队共用一个 zk 集群时使用，如 WithNamespace("/infra/gateway")
+func WithNamespace(namespace string) ZkOption {
+	return func(z *ZkManager) {
+		z.namespace = strings.TrimSuffix(path.Clean("/"+namespace), "/")
+	}
+}
+
+// 在命名空间下的完整路径，合并多余的 /，包含 . 或 .. 的路径返回错误
+func namespacePath(namespace, nodePath string) (string, error) {
+	for _, segment := range strings.Split(nodePath, "/") {
+		if segment == "." || segment == ".." {
+			return "", ErrPathEscapesNamespace
+		}
+	}
+	cleaned := path.Clean("/" + nodePath)
+	if cleaned == "/" {
+		return namespace, nil
+	}
+	return namespace + cleaned, nil
+}
+
+// 给所有路径加上命名空间前缀的连接
+type namespaceConn struct {
+	zkConn
+	namespace string
+}
+
+func (c *namespaceConn) Exists(nodePath string) (bool, *zk.Stat, error) {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return false, nil, err
+	}
+	return c.zkConn.Exists(p)
+}
+
+func (c *namespaceConn) Create(nodePath string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return "", err
+	}
+	created, err := c.zkConn.Create(p, data, flags, acl)
+	return strings.TrimPrefix(created, c.namespace), err
+}
+
+func (c *namespaceConn) Get(nodePath string) ([]byte, *zk.Stat, error) {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.zkConn.Get(p)
+}
+
+func (c *namespaceConn) GetW(nodePath string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return c.zkConn.GetW(p)
+}
+
+func (c *namespaceConn) Set(nodePath string, data []byte, version int32) (*zk.Stat, error) {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return nil, err
+	}
+	return c.zkConn.Set(p, data, version)
+}
+
+func (c *namespaceConn) Children(nodePath string) ([]string, *zk.Stat, error) {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.zkConn.Children(p)
+}
+
+func (c *namespaceConn) ChildrenW(nodePath string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return c.zkConn.ChildrenW(p)
+}
+
+func (c *namespaceConn) Delete(nodePath string, version int32) error {
+	p, err := namespacePath(c.namespace, nodePath)
+	if err != nil {
+		return err
+	}
+	return c.zkConn.Delete(p, version)
+}
+
+func (c *namespaceConn) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	reqs := make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		var err error
+		switch op := op.(type) {
+		case *zk.CreateRequest:
+			req := *op
+			req.Path, err = namespacePath(c.namespace, op.Path)
+			reqs = append(reqs, &req)
+		case *zk.SetDataRequest:
+			req := *op
+			req.Path, err = namespacePath(c.namespace, op.Path)
+			reqs = append(reqs, &req)
+		case *zk.DeleteRequest:
+			req := *op
+			req.Path, err = namespacePath(c.namespace, op.Path)
+			reqs = append(reqs, &req)
+		case *zk.CheckVersionRequest:
+			req := *op
+			req.Path, err = namespacePath(c.namespace, op.Path)
+			reqs = append(reqs, &req)
+		default:
+			reqs = append(reqs, op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	resps, err := c.zkConn.Multi(reqs...)
+	for i := range resps {
+		resps[i].String = strings.TrimPrefix(resps[i].String, c.namespace)
+	}
+	return resps, err
+}
@@ -0,0 +1,79 @@
+package zookeeper
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestZkNamespace(t *testing.T) {
+	conn := newFakeConn()
+	useFakeConn(t, conn)
+	infra := NewZkManager([]string{"127.0.0.1:2181"}, WithNamespace("/infra//gateway/"))
+	other := NewZkManager([]string{"127.0.0.1:2181"}, WithNamespace("team_b"))
+	for _, z := range []*ZkManager{infra, other} {
+		if err := z.GetConnect(); err != nil {
+			t.Fatal(err)
+		}
+		defer z.Close()
+	}
+	//命名空间自动创建
+	for _, p := range []string{"/infra", "/infra/gateway", "/team_b"} {
+		if _, ok := conn.nodes[p]; !ok {
+			t.Fatalf("namespace node %s not created", p)
+		}
+	}
+
+	if err := infra.RegistServerPath("/gateway_servers_orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := infra.SetPathData("//gateway_servers_config_orders", []byte("conf"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.RegistServerPath("/gateway_servers_orders", "127.0.0.1:9001"); err != nil {
+		t.Fatal(err)
+	}
+	raw := []string{}
+	for p := range conn.nodes {
+		raw = append(raw, p)
+	}
+	sort.Strings(raw)
+	want := []string{
+		"/infra", "/infra/gateway",
+		"/infra/gateway/gateway_servers_config_orders",
+		"/infra/gateway/gateway_servers_orders", "/infra/gateway/gateway_servers_orders/127.0.0.1:8001",
+		"/team_b", "/team_b/gateway_servers_orders", "/team_b/gateway_servers_orders/127.0.0.1:9001",
+	}
+	if !reflect.DeepEqual(raw, want) {
+		t.Fatalf("raw paths %v", raw)
+	}
+
+	//不同命名空间互不可见
+	if list, _ := infra.GetServerListByPath("/gateway_servers_orders"); !reflect.DeepEqual(list, []string{"127.0.0.1:8001"}) {
+		t.Fatalf("infra list %v", list)
+	}
+	if list, _ := other.GetServerListByPath("/gateway_servers_orders"); !reflect.DeepEqual(list, []string{"127.0.0.1:9001"}) {
+		t.Fatalf("other list %v", list)
+	}
+	if data, _, err := infra.GetPathData("/gateway_servers_config_orders"); err != nil || string(data) != "conf" {
+		t.Fatalf("data %q %v", data, err)
+	}
+	if _, _, err := other.GetPathData("/gateway_servers_config_orders"); err == nil {
+		t.Fatal("config visible across namespaces")
+	}
+	snapshots, _ := infra.WatchServerListByPath("/gateway_servers_orders")
+	if list := <-snapshots; !reflect.DeepEqual(list, []string{"127.0.0.1:8001"}) {
+		t.Fatalf("watch list %v", list)
+	}
+	if _, err := infra.Multi(SetOp("/gateway_servers_config_orders", []byte("v2"), -1)); err != nil || string(conn.nodes["/infra/gateway/gateway_servers_config_orders"]) != "v2" {
+		t.Fatalf("multi got %v", err)
+	}
+
+	//不能通过 .. 访问命名空间外的节点
+	for _, p := range []string{"/../team_b/gateway_servers_orders", "/gateway_servers_orders/../../x", "./x"} {
+		if _, err := infra.GetServerListByPath(p); !errors.Is(err, ErrPathEscapesNamespace) {
+			t.Fatalf("%s got %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package zookeeper
+
+import (
+	"errors"
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 重连策略：第 n 次重试前等待 InitialInterval*2^(n-1)，不超过 MaxInterval，再加减 Jitter 比例的随机时间
+type ReconnectPolicy struct {
+	InitialInterval time.Duration //默认 500ms
+	MaxInterval     time.Duration //默认 30s
+	MaxAttempts     int           //0 表示不限次数
+	Jitter          float64       //0~1，默认 0.2；小于 0 表示不加随机时间
+}
+
+var DefaultReconnectPolicy = ReconnectPolicy{InitialInterval: 500 * time.Millisecond, MaxInterval: 30 * time.Second, Jitter: 0.2}
+
+// 重连后等待建立会话的时间，超时记为一次失败的尝试
+var reconnectSessionTimeout = 10 * time.Second
+
+var errNoSession = errors.New("zk: session not established")
+
+// 每次重连尝试后调用，err 为空表示重连成功，可用于统计重连次数
+type ReconnectHook func(attempt int, err error)
+
+// 设置会话过期后的重连策略，短暂断开由客户端自己恢复，不重建连接
+func WithReconnect(policy ReconnectPolicy) ZkOption {
+	return func(z *ZkManager) {
+		z.reconnect = policy
+	}
+}
+
+func WithReconnectHook(hook ReconnectHook) ZkOption {
+	return func(z *ZkManager) {
+		z.reconnectHook = hook
+	}
+}
+
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	initial, max, jitter := p.InitialInterval, p.MaxInterval, p.Jitter
+	if initial <= 0 {
+		initial = DefaultReconnectPolicy.InitialInterval
+	}
+	if max <= 0 {
+		max = DefaultReconnectPolicy.MaxInterval
+	}
+	if jitter == 0 {
+		jitter = DefaultReconnectPolicy.Jitter
+	}
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * jitter * float64(d))
+	}
+	return d
+}
+
+// 等待 d，stop 关闭时返回 false，测试中可以替换
+var reconnectSleep = func(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// 第 gen 代连接的会话事件回调
+func (s *sharedConn) callback(gen int) zk.EventCallback {
+	return func(evt zk.Event) {
+		s.mux.RLock()
+		current := gen == s.gen
+		managers := make([]*ZkManager, 0, len(s.managers))
+		for z := range s.managers {
+			managers = append(managers, z)
+		}
+		s.mux.RUnlock()
+		if current {
+			observeSessionEvent(s.hosts, evt)
+			if evt.Type == zk.EventSession {
+				for _, z := range managers {
+					z.hooks.notify(evt.State)
+				}
+			}
+		}
+		//断开时客户端会自己重连并恢复原会话，只有会话过期才需要新建连接
+		if evt.Type != zk.EventSession || evt.State != zk.StateExpired {
+			return
+		}
+		s.mux.Lock()
+		//首次连接尚未返回时由 acquire 处理错误
+		if gen != s.gen || s.conn == nil || s.reconnecting || s.refs == 0 {
+			s.mux.Unlock()
+			return
+		}
+		s.reconnecting = true
+		s.mux.Unlock()
+		go s.reconnectLoop()
+	}
+}
+
+// 会话过期后按退避策略重建连接，新连接建立会话后才算成功，
+// 之后替换共享连接并让每个 ZkManager 恢复临时节点
+func (s *sharedConn) reconnectLoop() {
+	defer func() {
+		s.mux.Lock()
+		s.reconnecting = false
+		s.mux.Unlock()
+	}()
+	for attempt := 1; s.policy.MaxAttempts <= 0 || attempt <= s.policy.MaxAttempts; attempt++ {
+		if !reconnectSleep(s.policy.backoff(attempt), s.stop) {
+			return
+		}
+		s.mux.RLock()
+		gen := s.gen + 1
+		s.mux.RUnlock()
+		atomic.AddInt64(&s.attempts, 1)
+		session := make(chan struct{})
+		var once sync.Once
+		callback := s.callback(gen)
+		conn, err := s.dial(func(evt zk.Event) {
+			if evt.Type == zk.EventSession && evt.State == zk.StateHasSession {
+				once.Do(func() { close(session) })
+			}
+			callback(evt)
+		})
+		if err == nil {
+			//zk.Connect 在后台建立连接，返回时还没有会话
+			if err = waitSession(conn, session, s.stop); err != nil {
+				conn.Close()
+			}
+		}
+		s.notifyHooks(attempt, err)
+		if err != nil {
+			fmt.Println("zk reconnect error", s.hosts, attempt, err)
+			continue
+		}
+		s.mux.Lock()
+		if s.refs == 0 {
+			s.mux.Unlock()
+			conn.Close()
+			return
+		}
+		old := s.conn
+		s.conn, s.gen = conn, gen
+		managers := make([]*ZkManager, 0, len(s.managers))
+		for z := range s.managers {
+			managers = append(managers, z)
+		}
+		s.mux.Unlock()
+		old.Close()
+		fmt.Println("zk reconnected", s.hosts, attempt)
+		for _, z := range managers {
+			z.restoreEphemerals()
+		}
+		atomic.AddInt64(&s.successes, 1)
+		return
+	}
+	fmt.Println("zk reconnect give up", s.hosts)
+}
+
+// 等待新连接建立会话，不能报告状态的连接视为已建立
+func waitSession(conn zkConn, session <-chan struct{}, stop <-chan struct{}) error {
+	st, ok := conn.(connStater)
+	if !ok || st.State() == zk.StateHasSession {
+		return nil
+	}
+	timer := time.NewTimer(reconnectSessionTimeout)
+	defer timer.Stop()
+	select {
+	case <-session:
+		return nil
+	case <-stop:
+		return errNoSession
+	case <-timer.C:
+		if st.State() == zk.StateHasSession {
+			return nil
+		}
+		return errNoSession
+	}
+}
+
+func (s *sharedConn) notifyHooks(attempt int, err error) {
+	s.mux.RLock()
+	hooks := []ReconnectHook{}
+	for z := range s.managers {
+		if z.reconnectHook != nil {
+			hooks = append(hooks, z.reconnectHook)
+		}
+	}
+	s.mux.RUnlock()
+	for _, hook := range hooks {
+		hook(attempt, err)
+	}
+}
+
+// 重连次数与成功次数，多个 ZkManager 共享连接时统计的是共享连接
+func (z *ZkManager) ReconnectCounts() (attempts, successes int64) {
+	if z.shared == nil {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&z.shared.attempts), atomic.LoadInt64(&z.shared.successes)
+}
+
+// 新会话中重新创建本 ZkManager 注册过的临时节点，父节点不存在时一并创建
+func (z *ZkManager) restoreEphemerals() {
+	z.mux.Lock()
+	nodes := make(map[[2]string][]byte, len(z.ephemerals))
+	for node, data := range z.ephemerals {
+		nodes[node] = data
+	}
+	z.mux.Unlock()
+	for node, data := range nodes {
+		if err := z.createServerPath(node[0], node[1], data); err != nil {
+			fmt.Println("zk re-register error", node[0], node[1], err)
+			continue
+		}
+		//恢复期间被注销的节点不能复活
+		z.mux.Lock()
+		_, ok := z.ephemerals[node]
+		z.mux.Unlock()
+		if !ok {
+			z.deregisterServerPath(node[0], node[1])
+			continue
+		}
+		atomic.AddInt64(&z.reregistered, 1)
+		zkEvents.Inc(ZkEventReregister)
+		fmt.Println("zk re-register", node[0], node[1])
+	}
+}
+
+// 会话过期后重新注册临时节点的次数
+func (z *ZkManager) Reregistrations() int64 {
+	return atomic.LoadInt64(&z.reregistered)
+}
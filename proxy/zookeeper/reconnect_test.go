@@ -0,0 +1,304 @@
+package zookeeper
+
+import (
+	"errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 可以设置失败次数的连接函数，记录每次连接与其事件回调
+type flakyConnect struct {
+	mux       sync.Mutex
+	fails     int
+	noSession int //之后这么多次连接只连上、不建立会话
+	late      int //之后这么多次连接返回后才在后台收到会话事件
+	conns     []*stateConn
+	callbacks []zk.EventCallback
+	sleeps    []time.Duration
+}
+
+func useFlakyConnect(t *testing.T) *flakyConnect {
+	f := &flakyConnect{}
+	connect, manager, sleep := zkConnect, defaultConnManager, reconnectSleep
+	zkConnect = func(hosts []string, timeout time.Duration, dialer zk.Dialer, callback zk.EventCallback) (zkConn, error) {
+		f.mux.Lock()
+		defer f.mux.Unlock()
+		if f.fails > 0 {
+			f.fails--
+			return nil, errors.New("connection refused")
+		}
+		conn := &stateConn{fakeConn: newFakeConn(), state: zk.StateHasSession}
+		if f.noSession > 0 {
+			f.noSession--
+			conn.state = zk.StateConnecting
+		} else if f.late > 0 {
+			f.late--
+			conn.state = zk.StateConnecting
+			go func() {
+				time.Sleep(time.Millisecond)
+				callback(zk.Event{Type: zk.EventSession, State: zk.StateHasSession})
+			}()
+		}
+		f.conns = append(f.conns, conn)
+		f.callbacks = append(f.callbacks, callback)
+		return conn, nil
+	}
+	reconnectSleep = func(d time.Duration, stop <-chan struct{}) bool {
+		f.mux.Lock()
+		defer f.mux.Unlock()
+		f.sleeps = append(f.sleeps, d)
+		return true
+	}
+	defaultConnManager = newConnManager()
+	t.Cleanup(func() { zkConnect, defaultConnManager, reconnectSleep = connect, manager, sleep })
+	return f
+}
+
+func (f *flakyConnect) snapshot() ([]*stateConn, []time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]*stateConn(nil), f.conns...), append([]time.Duration(nil), f.sleeps...)
+}
+
+func TestZkReconnectBackoff(t *testing.T) {
+	f := useFlakyConnect(t)
+	var hookMux sync.Mutex
+	var hookErrs []error
+	policy := ReconnectPolicy{InitialInterval: 100 * time.Millisecond, MaxInterval: 300 * time.Millisecond, Jitter: -1}
+	z := NewZkManager([]string{"10.0.0.1:2181"}, WithReconnect(policy), WithReconnectHook(func(attempt int, err error) {
+		hookMux.Lock()
+		hookErrs = append(hookErrs, err)
+		hookMux.Unlock()
+	}))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if err := z.RegistServerPath("/gateway_servers_orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+
+	//会话过期后连续失败 3 次
+	f.mux.Lock()
+	f.fails = 3
+	f.mux.Unlock()
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, successes := z.ReconnectCounts(); successes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	conns, sleeps := f.snapshot()
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	if !reflect.DeepEqual(sleeps, want) {
+		t.Fatalf("backoff %v", sleeps)
+	}
+	if attempts, _ := z.ReconnectCounts(); attempts != 4 {
+		t.Fatalf("attempts %d", attempts)
+	}
+	hookMux.Lock()
+	if len(hookErrs) != 4 || hookErrs[0] == nil || hookErrs[3] != nil {
+		t.Fatalf("hook errors %v", hookErrs)
+	}
+	hookMux.Unlock()
+	if len(conns) != 2 || conns[0].closed != 1 {
+		t.Fatalf("conns %d, old closed %d", len(conns), conns[0].closed)
+	}
+	//新会话中重新创建临时节点，后续调用使用新连接
+	if _, ok := conns[1].nodes["/gateway_servers_orders/127.0.0.1:8001"]; !ok {
+		t.Fatal("ephemeral node not restored")
+	}
+	if list, _ := z.GetServerListByPath("/gateway_servers_orders"); len(list) != 1 {
+		t.Fatalf("list %v", list)
+	}
+	//旧连接的事件被忽略
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	time.Sleep(20 * time.Millisecond)
+	if conns, _ := f.snapshot(); len(conns) != 2 {
+		t.Fatal("stale event triggered reconnect")
+	}
+}
+
+func TestZkReconnectOnlyOnExpiry(t *testing.T) {
+	f := useFlakyConnect(t)
+	z := NewZkManager([]string{"10.0.0.1:2181"}, WithReconnect(ReconnectPolicy{MaxAttempts: 2, Jitter: -1}))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	//短暂断开由客户端恢复原会话，不关闭连接也不重建
+	f.conns[0].state = zk.StateDisconnected
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateDisconnected})
+	waitReconnectDone(t, z)
+	if conns, _ := f.snapshot(); len(conns) != 1 || conns[0].closed != 0 {
+		t.Fatalf("redialed %d times, closed %d", len(conns)-1, conns[0].closed)
+	}
+	if !healthy(f.conns[0]) {
+		t.Fatal("disconnected conn treated as unhealthy")
+	}
+	f.conns[0].state = zk.StateHasSession
+
+	//超过最大次数后放弃
+	f.mux.Lock()
+	f.fails = 5
+	f.mux.Unlock()
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	waitReconnectDone(t, z)
+	if attempts, successes := z.ReconnectCounts(); attempts != 2 || successes != 0 {
+		t.Fatalf("attempts %d, successes %d", attempts, successes)
+	}
+}
+
+// 连接返回后没有建立会话的尝试算作失败，退避时间继续增长；收到会话事件后才算成功
+func TestZkReconnectWaitsForSession(t *testing.T) {
+	f := useFlakyConnect(t)
+	timeout := reconnectSessionTimeout
+	reconnectSessionTimeout = 50 * time.Millisecond
+	defer func() { reconnectSessionTimeout = timeout }()
+	var hookMux sync.Mutex
+	var hookErrs []error
+	policy := ReconnectPolicy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Jitter: -1}
+	z := NewZkManager([]string{"10.0.0.1:2181"}, WithReconnect(policy), WithReconnectHook(func(attempt int, err error) {
+		hookMux.Lock()
+		hookErrs = append(hookErrs, err)
+		hookMux.Unlock()
+	}))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	f.mux.Lock()
+	f.noSession, f.late = 2, 1
+	f.mux.Unlock()
+	f.callbacks[0](zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, successes := z.ReconnectCounts(); successes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	conns, sleeps := f.snapshot()
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	if !reflect.DeepEqual(sleeps, want) {
+		t.Fatalf("backoff %v", sleeps)
+	}
+	if conns[1].closed != 1 || conns[2].closed != 1 || conns[3].closed != 0 || z.shared.current() != conns[3] {
+		t.Fatal("conns without session should be closed and not used")
+	}
+	hookMux.Lock()
+	defer hookMux.Unlock()
+	if len(hookErrs) != 3 || !errors.Is(hookErrs[0], errNoSession) || hookErrs[2] != nil {
+		t.Fatalf("hook errors %v", hookErrs)
+	}
+}
+
+func waitReconnectDone(t *testing.T, z *ZkManager) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		time.Sleep(10 * time.Millisecond)
+		z.shared.mux.RLock()
+		reconnecting := z.shared.reconnecting
+		z.shared.mux.RUnlock()
+		if !reconnecting {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("reconnect loop not finished")
+		}
+	}
+}
+
+func TestReconnectBackoffJitter(t *testing.T) {
+	p := ReconnectPolicy{InitialInterval: time.Second, MaxInterval: 10 * time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(3); d < 3200*time.Millisecond || d > 4800*time.Millisecond {
+			t.Fatalf("backoff(3) = %v", d)
+		}
+		if d := p.backoff(10); d < 8*time.Second || d > 12*time.Second {
+			t.Fatalf("backoff(10) = %v", d)
+		}
+	}
+	if d := (ReconnectPolicy{}).backoff(1); d < 400*time.Millisecond || d > 600*time.Millisecond {
+		t.Fatalf("default backoff(1) = %v", d)
+	}
+}
+
+// 记录每个路径 Create 的次数
+type createCounter struct {
+	*stateConn
+	creates map[string]int
+}
+
+func (c *createCounter) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	c.creates[path]++
+	return c.stateConn.Create(path, data, flags, acl)
+}
+
+func TestZkReregisterAfterExpiry(t *testing.T) {
+	f := useFlakyConnect(t)
+	var fresh *createCounter
+	connect := zkConnect
+	zkConnect = func(hosts []string, timeout time.Duration, dialer zk.Dialer, callback zk.EventCallback) (zkConn, error) {
+		conn, err := connect(hosts, timeout, dialer, callback)
+		if err != nil {
+			return nil, err
+		}
+		fresh = &createCounter{stateConn: conn.(*stateConn), creates: map[string]int{}}
+		return fresh, nil
+	}
+	z := NewZkManager([]string{"10.0.0.1:2181"}, WithReconnect(ReconnectPolicy{Jitter: -1}))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	for _, host := range []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"} {
+		if err := z.RegistServerPath("/gateway_servers_orders", host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//注销的节点不会在重连后复活
+	if err := z.DeregisterServerPath("/gateway_servers_orders", "127.0.0.1:8003"); err != nil {
+		t.Fatal(err)
+	}
+
+	//同一次过期的重复事件只触发一次恢复
+	expired := zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	f.callbacks[0](expired)
+	f.callbacks[0](expired)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, successes := z.ReconnectCounts(); successes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	want := map[string]int{
+		"/gateway_servers_orders":                1,
+		"/gateway_servers_orders/127.0.0.1:8001": 1,
+		"/gateway_servers_orders/127.0.0.1:8002": 1,
+	}
+	if !reflect.DeepEqual(fresh.creates, want) {
+		t.Fatalf("creates %v", fresh.creates)
+	}
+	if n := z.Reregistrations(); n != 2 {
+		t.Fatalf("reregistrations %d", n)
+	}
+}
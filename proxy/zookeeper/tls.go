@@ -0,0 +1,94 @@
+package zookeeper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"net"
+	"os"
+	"time"
+)
+
+// 使用 TLS 连接 zk 的安全端口，不设置时使用明文连接
+func WithTLS(conf *tls.Config) ZkOption {
+	return func(z *ZkManager) {
+		z.tlsConfig = conf
+	}
+}
+
+// 由 CA 证书、客户端证书与私钥构造 TLS 配置。caFile 为空时使用系统 CA，
+// 客户端证书与私钥必须同时设置或同时为空；serverName 为空时使用连接的主机名校验证书
+func NewZkTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("zk tls: client cert and key must be set together")
+	}
+	conf := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("zk tls: no certificates in " + caFile)
+		}
+		conf.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// 与某个 zk 节点 TLS 握手失败，如证书校验失败
+type TLSError struct {
+	Host string
+	Err  error
+}
+
+func (e *TLSError) Error() string {
+	return "zk tls handshake with " + e.Host + ": " + e.Err.Error()
+}
+
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+// 建立 TCP 连接后完成 TLS 握手，握手失败时返回 TLSError
+func (z *ZkManager) dialTLS(network, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conf := z.tlsConfig.Clone()
+	if conf.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			conf.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, conf)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, &TLSError{Host: address, Err: err}
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// 记录首次连接结果的 dialer，GetConnect 据此在证书错误时立即返回
+func (z *ZkManager) tlsDialer(first chan<- error) zk.Dialer {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		conn, err := z.dialTLS(network, address, timeout)
+		select {
+		case first <- err:
+		default:
+		}
+		return conn, err
+	}
+}
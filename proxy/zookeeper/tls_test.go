@@ -0,0 +1,194 @@
+package zookeeper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zk test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// 签发证书，返回证书与私钥的 PEM
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 只实现连接握手的 zk 服务端：回复 connect 请求后保持连接，收到的客户端证书通过 channel 返回
+func startTLSZk(t *testing.T, ca *testCA) (string, <-chan string) {
+	certPEM, keyPEM := ca.issue(t, "zk.test", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	sessions := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var size [4]byte
+				if _, err := io.ReadFull(conn, size[:]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(size[:]))); err != nil {
+					return
+				}
+				client := ""
+				if state := conn.(*tls.Conn).ConnectionState(); len(state.PeerCertificates) > 0 {
+					client = state.PeerCertificates[0].Subject.CommonName
+				}
+				sessions <- client
+				//protocolVersion, timeOut, sessionID, passwd
+				resp := make([]byte, 4+4+4+8+4+16)
+				binary.BigEndian.PutUint32(resp[0:], uint32(len(resp)-4))
+				binary.BigEndian.PutUint32(resp[8:], 10000)
+				binary.BigEndian.PutUint64(resp[12:], 1)
+				binary.BigEndian.PutUint32(resp[20:], 16)
+				conn.Write(resp)
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), sessions
+}
+
+func TestZkTLSConnect(t *testing.T) {
+	ca := newTestCA(t)
+	addr, sessions := startTLSZk(t, ca)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "gateway", x509.ExtKeyUsageClientAuth)
+	conf, err := NewZkTLSConfig(writeFile(t, dir, "ca.pem", ca.pem), writeFile(t, dir, "client.pem", certPEM), writeFile(t, dir, "client.key", keyPEM), "zk.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := NewZkManager([]string{addr}, WithTLS(conf))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	select {
+	case client := <-sessions:
+		if client != "gateway" {
+			t.Fatalf("client cert %q", client)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no zk session over tls")
+	}
+}
+
+func TestZkTLSVerifyFailure(t *testing.T) {
+	addr, _ := startTLSZk(t, newTestCA(t))
+	//信任另一个 CA
+	pool := x509.NewCertPool()
+	pool.AddCert(newTestCA(t).cert)
+	z := NewZkManager([]string{addr}, WithTLS(&tls.Config{RootCAs: pool, ServerName: "zk.test"}))
+	err := z.GetConnect()
+	var tlsErr *TLSError
+	if !errors.As(err, &tlsErr) || tlsErr.Host != addr || !strings.Contains(err.Error(), addr) {
+		t.Fatalf("got %v", err)
+	}
+	var verifyErr *tls.CertificateVerificationError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("not a verification error: %v", err)
+	}
+}
+
+func TestZkTLSConfigValidation(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, "gateway", x509.ExtKeyUsageClientAuth)
+	certFile := writeFile(t, dir, "client.pem", certPEM)
+	keyFile := writeFile(t, dir, "client.key", keyPEM)
+	if _, err := NewZkTLSConfig("", certFile, "", ""); err == nil {
+		t.Fatal("cert without key accepted")
+	}
+	if _, err := NewZkTLSConfig("", "", keyFile, ""); err == nil {
+		t.Fatal("key without cert accepted")
+	}
+	//证书与私钥不匹配
+	otherPEM, _ := ca.issue(t, "other", x509.ExtKeyUsageClientAuth)
+	if _, err := NewZkTLSConfig("", writeFile(t, dir, "other.pem", otherPEM), keyFile, ""); err == nil {
+		t.Fatal("mismatched key pair accepted")
+	}
+	if _, err := NewZkTLSConfig(writeFile(t, dir, "bad-ca.pem", []byte("junk")), "", "", ""); err == nil {
+		t.Fatal("invalid ca accepted")
+	}
+	if _, err := NewZkTLSConfig(writeFile(t, dir, "ca.pem", ca.pem), certFile, keyFile, ""); err != nil {
+		t.Fatal(err)
+	}
+}
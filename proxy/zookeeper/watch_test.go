@@ -0,0 +1,159 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 可以设置 watch 失败次数并手动触发 watch 事件的连接
+type watchConn struct {
+	*fakeConn
+	mux     sync.Mutex
+	fails   int
+	calls   int
+	watches []chan zk.Event
+}
+
+func (c *watchConn) watch() (<-chan zk.Event, error) {
+	c.calls++
+	if c.fails > 0 {
+		c.fails--
+		return nil, errors.New("connection loss")
+	}
+	events := make(chan zk.Event, 1)
+	c.watches = append(c.watches, events)
+	return events, nil
+}
+
+func (c *watchConn) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	events, err := c.watch()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	list, stat, _ := c.fakeConn.Children(path)
+	return list, stat, events, nil
+}
+
+func (c *watchConn) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	events, err := c.watch()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data, stat, err := c.fakeConn.Get(path)
+	return data, stat, events, err
+}
+
+// 修改节点后触发当前的 watch，fails 为之后 watch 失败的次数
+func (c *watchConn) fire(change func(), evt zk.Event, fails int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if change != nil {
+		change()
+	}
+	c.fails = fails
+	for _, w := range c.watches {
+		w <- evt
+	}
+	c.watches = nil
+}
+
+func (c *watchConn) callCount() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.calls
+}
+
+func newWatchManager(conn zkConn) *ZkManager {
+	z := NewZkManager([]string{"127.0.0.1:2181"}, WithReconnect(ReconnectPolicy{InitialInterval: time.Millisecond, MaxInterval: 5 * time.Millisecond, Jitter: -1}))
+	z.conn = conn
+	return z
+}
+
+func TestZkWatchServerListResumes(t *testing.T) {
+	conn := &watchConn{fakeConn: newFakeConn(), fails: 2}
+	conn.nodes["/gateway_servers_orders/127.0.0.1:8001"] = nil
+	z := newWatchManager(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	snapshots, errs := z.WatchServerListByPathCtx(ctx, "/gateway_servers_orders")
+
+	next := func() []string {
+		select {
+		case list := <-snapshots:
+			return list
+		case <-time.After(2 * time.Second):
+			t.Fatal("no snapshot")
+			return nil
+		}
+	}
+	//前两次 watch 失败后恢复
+	if list := next(); !reflect.DeepEqual(list, []string{"127.0.0.1:8001"}) {
+		t.Fatalf("first snapshot %v", list)
+	}
+	if err := <-errs; err == nil {
+		t.Fatal("error not reported")
+	}
+
+	//watch 事件出错后重新获取列表
+	conn.fire(func() { conn.nodes["/gateway_servers_orders/127.0.0.1:8002"] = nil }, zk.Event{Err: zk.ErrSessionExpired}, 1)
+	if list := next(); !reflect.DeepEqual(list, []string{"127.0.0.1:8001", "127.0.0.1:8002"}) {
+		t.Fatalf("snapshot after error %v", list)
+	}
+
+	//列表不变时不重复发送
+	calls := conn.callCount()
+	conn.fire(nil, zk.Event{Type: zk.EventNodeChildrenChanged}, 0)
+	for conn.callCount() == calls {
+		time.Sleep(time.Millisecond)
+	}
+	conn.fire(func() { delete(conn.nodes, "/gateway_servers_orders/127.0.0.1:8001") }, zk.Event{Type: zk.EventNodeChildrenChanged}, 0)
+	if list := next(); !reflect.DeepEqual(list, []string{"127.0.0.1:8002"}) {
+		t.Fatalf("snapshot after change %v", list)
+	}
+
+	//ctx 结束后关闭 channel
+	cancel()
+	select {
+	case _, ok := <-snapshots:
+		if ok {
+			t.Fatal("unexpected snapshot after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watch goroutine not stopped")
+	}
+}
+
+func TestZkWatchPathDataResumes(t *testing.T) {
+	conn := &watchConn{fakeConn: newFakeConn()}
+	conn.nodes["/gateway_servers_config_orders"] = []byte("v1")
+	z := newWatchManager(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	snapshots, _ := z.WatchPathDataCtx(ctx, "/gateway_servers_config_orders")
+	if data := <-snapshots; string(data) != "v1" {
+		t.Fatalf("first data %q", data)
+	}
+	//连续失败后恢复
+	conn.fire(func() { conn.nodes["/gateway_servers_config_orders"] = []byte("v2") }, zk.Event{Err: zk.ErrClosing}, 3)
+	select {
+	case data := <-snapshots:
+		if string(data) != "v2" {
+			t.Fatalf("data after error %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("updates not resumed")
+	}
+	if calls := conn.callCount(); calls != 5 {
+		t.Fatalf("GetW called %d times", calls)
+	}
+	cancel()
+	for range snapshots {
+	}
+}
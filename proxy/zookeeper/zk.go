@@ -1,118 +1,312 @@
 package zookeeper
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/samuel/go-zookeeper/zk"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 type ZkManager struct {
-	hosts      []string
-	conn       *zk.Conn
-	pathPrefix string
+	hosts          []string
+	conn           zkConn
+	pathPrefix     string
+	authScheme     string
+	authCredential []byte
+	acl            []zk.ACL
+	tlsConfig      *tls.Config
+	shared         *sharedConn
+	reconnect      ReconnectPolicy
+	reconnectHook  ReconnectHook
+	mux            sync.Mutex
+	ephemerals     map[[2]string][]byte //注册过的临时节点及其数据，重连后重新创建
+	reregistered   int64
+	watchDebounce  time.Duration
+	namespace      string //所有路径的前缀，如 /infra/gateway
+	hooks          stateHooks
 }
 
-func NewZkManager(hosts []string) *ZkManager {
-	return &ZkManager{hosts: hosts, pathPrefix: "/gateway_servers_"}
+func NewZkManager(hosts []string, opts ...ZkOption) *ZkManager {
+	z := &ZkManager{hosts: hosts, pathPrefix: "/gateway_servers_", acl: zk.WorldACL(zk.PermAll),
+		reconnect: DefaultReconnectPolicy, watchDebounce: DefaultWatchDebounce, ephemerals: map[[2]string][]byte{}}
+	for _, opt := range opts {
+		opt(z)
+	}
+	return z
 }
 
-//连接zk服务器
+//连接zk服务器，地址与认证配置相同的 ZkManager 共享同一个连接
 func (z *ZkManager) GetConnect() error {
-	conn, _, err := zk.Connect(z.hosts, 5*time.Second)
+	if z.shared != nil {
+		z.Close()
+	}
+	shared, err := defaultConnManager.acquire(z)
 	if err != nil {
 		return err
 	}
-	z.conn = conn
+	z.shared = shared
+	z.conn = shared.conn
+	if z.namespace != "" {
+		//命名空间首次使用时创建
+		if err := createAll(shared.current(), z.namespace, nil, z.acl); err != nil {
+			z.Close()
+			return err
+		}
+	}
 	return nil
 }
 
-//关闭服务
+// 新建连接，配置了认证信息时连接后认证
+func (z *ZkManager) dial(callback zk.EventCallback) (zkConn, error) {
+	var dialer zk.Dialer
+	first := make(chan error, 1)
+	if z.tlsConfig != nil {
+		dialer = z.tlsDialer(first)
+	}
+	conn, err := zkConnect(z.hosts, 5*time.Second, dialer, callback)
+	if err != nil {
+		return nil, err
+	}
+	if z.tlsConfig != nil {
+		//连接在后台建立，等待首次连接的结果，TLS 握手失败通常是配置错误，直接返回
+		select {
+		case err := <-first:
+			var tlsErr *TLSError
+			if errors.As(err, &tlsErr) {
+				conn.Close()
+				return nil, err
+			}
+		case <-time.After(5 * time.Second):
+		}
+	}
+	if z.authScheme != "" {
+		if err := conn.AddAuth(z.authScheme, z.authCredential); err != nil {
+			conn.Close()
+			return nil, &AuthError{Op: "add_auth", Err: err}
+		}
+	}
+	return conn, nil
+}
+
+//关闭服务，最后一个使用连接的 ZkManager 关闭时才真正断开
 func (z *ZkManager) Close() {
-	z.conn.Close()
-	return
+	if z.shared == nil {
+		return
+	}
+	defaultConnManager.release(z.shared, z)
+	z.shared = nil
 }
 
-//获取配置
-func (z *ZkManager) GetPathData(nodePath string) ([]byte, *zk.Stat, error) {
-	return z.conn.Get(nodePath)
+// 当前使用的连接，重连后共享连接会被替换。调用记录到指标，配置了命名空间时所有路径加上前缀
+func (z *ZkManager) getConn() zkConn {
+	conn := z.conn
+	if z.shared != nil {
+		conn = z.shared.current()
+	}
+	conn = metricConn{zkConn: conn}
+	if z.namespace != "" {
+		return &namespaceConn{zkConn: conn, namespace: z.namespace}
+	}
+	return conn
 }
 
-//更新配置
-func (z *ZkManager) SetPathData(nodePath string, config []byte, version int32) (err error) {
-	ex, _, _ := z.conn.Exists(nodePath)
+func (z *ZkManager) getPathData(nodePath string) ([]byte, *zk.Stat, error) {
+	return z.getConn().Get(nodePath)
+}
+
+func (z *ZkManager) setPathData(nodePath string, config []byte, version int32) (err error) {
+	ex, _, _ := z.getConn().Exists(nodePath)
 	if !ex {
-		z.conn.Create(nodePath, config, 0, zk.WorldACL(zk.PermAll))
-		return nil
+		return z.CreateAll(nodePath, config, z.acl)
 	}
-	_, dStat, err := z.GetPathData(nodePath)
+	_, dStat, err := z.getPathData(nodePath)
 	if err != nil {
 		return
 	}
-	_, err = z.conn.Set(nodePath, config, dStat.Version)
+	_, err = z.getConn().Set(nodePath, config, dStat.Version)
 	if err != nil {
 		fmt.Println("Update node error", err)
-		return err
+		return authError("set", nodePath, err)
 	}
 	fmt.Println("SetData ok")
 	return
 }
 
-//创建临时节点
-func (z *ZkManager) RegistServerPath(nodePath, host string) (err error) {
-	ex, _, err := z.conn.Exists(nodePath)
-	if err != nil {
-		fmt.Println("Exists error", nodePath)
-		return err
-	}
-	if !ex {
-		//持久化节点，思考题：如果不是持久化节点会怎么样？
-		_, err = z.conn.Create(nodePath, nil, 0, zk.WorldACL(zk.PermAll))
+// 逐级创建 nodePath 及缺少的持久化父节点，data 只写入最后一级。
+// 其他客户端同时创建同一节点时(ErrNodeExists)视为成功
+func (z *ZkManager) CreateAll(nodePath string, data []byte, acl []zk.ACL) error {
+	return createAll(z.getConn(), nodePath, data, acl)
+}
+
+func createAll(conn zkConn, nodePath string, data []byte, acl []zk.ACL) error {
+	segments := strings.Split(strings.Trim(nodePath, "/"), "/")
+	path := ""
+	for i, segment := range segments {
+		path += "/" + segment
+		ex, _, err := conn.Exists(path)
 		if err != nil {
-			fmt.Println("Create error", nodePath)
+			fmt.Println("Exists error", path)
 			return err
 		}
+		if ex {
+			continue
+		}
+		var nodeData []byte
+		if i == len(segments)-1 {
+			nodeData = data
+		}
+		_, err = conn.Create(path, nodeData, 0, acl)
+		if err != nil && err != zk.ErrNodeExists {
+			fmt.Println("Create error", path)
+			return authError("create", path, err)
+		}
+	}
+	return nil
+}
+
+// 创建临时节点并记录，会话过期重连后重新创建
+func (z *ZkManager) registServerPath(nodePath, host string, data []byte) error {
+	if err := z.createServerPath(nodePath, host, data); err != nil {
+		return err
+	}
+	z.mux.Lock()
+	z.ephemerals[[2]string{nodePath, host}] = data
+	z.mux.Unlock()
+	return nil
+}
+
+// data 为临时节点的数据，节点已存在且数据不同时更新
+func (z *ZkManager) createServerPath(nodePath, host string, data []byte) (err error) {
+	//持久化节点，思考题：如果不是持久化节点会怎么样？
+	if err = z.CreateAll(nodePath, nil, z.acl); err != nil {
+		return err
 	}
 	//临时节点
 	subNodePath := nodePath + "/" + host
-	ex, _, err = z.conn.Exists(subNodePath)
+	ex, _, err := z.getConn().Exists(subNodePath)
 	if err != nil {
 		fmt.Println("Exists error", subNodePath)
 		return err
 	}
 	if !ex {
-		_, err = z.conn.Create(subNodePath, nil, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+		_, err = z.getConn().Create(subNodePath, data, zk.FlagEphemeral, z.acl)
 		if err != nil {
 			fmt.Println("Create error", subNodePath)
-			return err
+			return authError("create", subNodePath, err)
+		}
+	} else if old, _, err := z.getConn().Get(subNodePath); err == nil && !bytes.Equal(old, data) {
+		if _, err = z.getConn().Set(subNodePath, data, -1); err != nil {
+			fmt.Println("Update node error", subNodePath)
+			return authError("set", subNodePath, err)
 		}
 	}
 	return
 }
 
-//获取服务列表
-func (z *ZkManager) GetServerListByPath(path string) (list []string, err error) {
-	list, _, err = z.conn.Children(path)
+func (z *ZkManager) deregisterServerPath(nodePath, host string) error {
+	subNodePath := nodePath + "/" + host
+	err := z.getConn().Delete(subNodePath, -1)
+	if err != nil && err != zk.ErrNoNode {
+		fmt.Println("Delete error", subNodePath)
+		return authError("delete", subNodePath, err)
+	}
+	z.mux.Lock()
+	delete(z.ephemerals, [2]string{nodePath, host})
+	z.mux.Unlock()
+	return nil
+}
+
+// 删除节点及其所有子节点，节点不存在时不报错，用于清理测试路径
+func (z *ZkManager) DeleteRecursive(nodePath string) error {
+	children, _, err := z.getConn().Children(nodePath)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := z.DeleteRecursive(nodePath + "/" + child); err != nil {
+			return err
+		}
+	}
+	err = z.getConn().Delete(nodePath, -1)
+	if err != nil && err != zk.ErrNoNode {
+		return authError("delete", nodePath, err)
+	}
+	return nil
+}
+
+func (z *ZkManager) childrenW(path string) ([]string, <-chan zk.Event, error) {
+	list, _, events, err := z.getConn().ChildrenW(path)
+	return list, events, err
+}
+
+func (z *ZkManager) getServerListByPath(path string) (list []string, err error) {
+	list, _, err = z.getConn().Children(path)
 	return
 }
 
 //watch机制，服务器有断开或者重连，收到消息
 func (z *ZkManager) WatchServerListByPath(path string) (chan []string, chan error) {
-	conn := z.conn
+	return z.WatchServerListByPathCtx(context.Background(), path)
+}
+
+// 出错后按重连策略退避并重新 watch，直到 ctx 结束时关闭两个 channel。
+// 列表与上次相同时不重复发送；errors 只作通知，没有读取时丢弃
+func (z *ZkManager) WatchServerListByPathCtx(ctx context.Context, path string) (chan []string, chan error) {
 	snapshots := make(chan []string)
-	errors := make(chan error)
+	errors := make(chan error, 1)
 	go func() {
+		defer close(snapshots)
+		defer close(errors)
+		var last []string
+		sent := false
+		failures := 0
 		for {
-			snapshot, _, events, err := conn.ChildrenW(path)
+			snapshot, _, events, err := z.getConn().ChildrenW(path)
 			if err != nil {
-				errors <- err
+				failures++
+				if !z.watchFailed(ctx, errors, "ChildrenW", path, err, failures) {
+					return
+				}
+				continue
+			}
+			if failures > 0 {
+				zkEvents.Inc(ZkEventWatchReconnect)
+			}
+			failures = 0
+			sort.Strings(snapshot)
+			if !sent || !reflect.DeepEqual(snapshot, last) {
+				select {
+				case snapshots <- snapshot:
+				case <-ctx.Done():
+					return
+				}
+				last, sent = snapshot, true
 			}
-			snapshots <- snapshot
 			select {
 			case evt := <-events:
 				if evt.Err != nil {
-					errors <- evt.Err
+					failures++
+					if !z.watchFailed(ctx, errors, "ChildrenW", path, evt.Err, failures) {
+						return
+					}
+					continue
 				}
 				fmt.Printf("ChildrenW Event Path:%v, Type:%v\n", evt.Path, evt.Type)
+				if !z.debounce(ctx) {
+					return
+				}
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -122,27 +316,73 @@ func (z *ZkManager) WatchServerListByPath(path string) (chan []string, chan erro
 
 //watch机制，监听节点值变化
 func (z *ZkManager) WatchPathData(nodePath string) (chan []byte, chan error) {
-	conn := z.conn
+	return z.WatchPathDataCtx(context.Background(), nodePath)
+}
+
+// 与 WatchServerListByPathCtx 相同，出错后重试直到 ctx 结束，内容不变时不重复发送
+func (z *ZkManager) WatchPathDataCtx(ctx context.Context, nodePath string) (chan []byte, chan error) {
 	snapshots := make(chan []byte)
-	errors := make(chan error)
+	errors := make(chan error, 1)
 
 	go func() {
+		defer close(snapshots)
+		defer close(errors)
+		var last []byte
+		sent := false
+		failures := 0
 		for {
-			dataBuf, _, events, err := conn.GetW(nodePath)
+			dataBuf, _, events, err := z.getConn().GetW(nodePath)
 			if err != nil {
-				errors <- err
-				return
+				failures++
+				if !z.watchFailed(ctx, errors, "GetW", nodePath, err, failures) {
+					return
+				}
+				continue
+			}
+			if failures > 0 {
+				zkEvents.Inc(ZkEventWatchReconnect)
+			}
+			failures = 0
+			if !sent || !bytes.Equal(dataBuf, last) {
+				select {
+				case snapshots <- dataBuf:
+				case <-ctx.Done():
+					return
+				}
+				last, sent = dataBuf, true
 			}
-			snapshots <- dataBuf
 			select {
 			case evt := <-events:
 				if evt.Err != nil {
-					errors <- evt.Err
-					return
+					failures++
+					if !z.watchFailed(ctx, errors, "GetW", nodePath, evt.Err, failures) {
+						return
+					}
+					continue
 				}
 				fmt.Printf("GetW Event Path:%v, Type:%v\n", evt.Path, evt.Type)
+				if !z.debounce(ctx) {
+					return
+				}
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 	return snapshots, errors
-}
\ No newline at end of file
+}
+
+// 记录 watch 错误并按重连策略等待，ctx 结束时返回 false
+func (z *ZkManager) watchFailed(ctx context.Context, errs chan<- error, op, path string, err error, failures int) bool {
+	fmt.Println(op, "error", path, err)
+	select {
+	case errs <- err:
+	default:
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(z.reconnect.backoff(failures)):
+		return true
+	}
+}
@@ -0,0 +1,356 @@
+package zookeeper
+
+import (
+	"errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 内存中的 zk 连接，记录认证信息与节点的 ACL
+type fakeConn struct {
+	auths   []string
+	authErr error
+	nodes   map[string][]byte
+	acls    map[string][]zk.ACL
+	//节点的数据版本，每次 Set 加一
+	versions map[string]int32
+	//以这些前缀开头的路径需要认证
+	protected []string
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{nodes: map[string][]byte{}, acls: map[string][]zk.ACL{}, versions: map[string]int32{}}
+}
+
+func (c *fakeConn) AddAuth(scheme string, auth []byte) error {
+	if c.authErr != nil {
+		return c.authErr
+	}
+	c.auths = append(c.auths, scheme+":"+string(auth))
+	return nil
+}
+
+func (c *fakeConn) allowed(path string) bool {
+	for _, p := range c.protected {
+		if strings.HasPrefix(path, p) && len(c.auths) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *fakeConn) Exists(path string) (bool, *zk.Stat, error) {
+	_, ok := c.nodes[path]
+	return ok, &zk.Stat{Version: c.versions[path]}, nil
+}
+
+func (c *fakeConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	if !c.allowed(path) {
+		return "", zk.ErrNoAuth
+	}
+	if _, ok := c.nodes[path]; ok {
+		return "", zk.ErrNodeExists
+	}
+	if parent := path[:strings.LastIndex(path, "/")]; parent != "" {
+		if _, ok := c.nodes[parent]; !ok {
+			return "", zk.ErrNoNode
+		}
+	}
+	c.nodes[path] = data
+	c.acls[path] = acl
+	c.versions[path] = 0
+	return path, nil
+}
+
+func (c *fakeConn) Get(path string) ([]byte, *zk.Stat, error) {
+	data, ok := c.nodes[path]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return data, &zk.Stat{Version: c.versions[path]}, nil
+}
+
+func (c *fakeConn) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	data, stat, err := c.Get(path)
+	return data, stat, make(chan zk.Event), err
+}
+
+func (c *fakeConn) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	if !c.allowed(path) {
+		return nil, zk.ErrNoAuth
+	}
+	if _, ok := c.nodes[path]; !ok {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != c.versions[path] {
+		return nil, zk.ErrBadVersion
+	}
+	c.nodes[path] = data
+	c.versions[path]++
+	return &zk.Stat{Version: c.versions[path]}, nil
+}
+
+func (c *fakeConn) Children(path string) ([]string, *zk.Stat, error) {
+	list := []string{}
+	for p := range c.nodes {
+		if child := strings.TrimPrefix(p, path+"/"); child != p && !strings.Contains(child, "/") {
+			list = append(list, child)
+		}
+	}
+	return list, &zk.Stat{}, nil
+}
+
+func (c *fakeConn) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	list, stat, err := c.Children(path)
+	return list, stat, make(chan zk.Event), err
+}
+
+func (c *fakeConn) Delete(path string, version int32) error {
+	if !c.allowed(path) {
+		return zk.ErrNoAuth
+	}
+	if _, ok := c.nodes[path]; !ok {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != c.versions[path] {
+		return zk.ErrBadVersion
+	}
+	if list, _, _ := c.Children(path); len(list) > 0 {
+		return zk.ErrNotEmpty
+	}
+	delete(c.nodes, path)
+	delete(c.versions, path)
+	return nil
+}
+
+// 依次执行，任一操作失败时恢复所有节点，只有出错的操作返回错误
+func (c *fakeConn) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	nodes, versions := map[string][]byte{}, map[string]int32{}
+	for k, v := range c.nodes {
+		nodes[k] = v
+	}
+	for k, v := range c.versions {
+		versions[k] = v
+	}
+	res := make([]zk.MultiResponse, len(ops))
+	for i, op := range ops {
+		var err error
+		switch op := op.(type) {
+		case *zk.CreateRequest:
+			res[i].String, err = c.Create(op.Path, op.Data, op.Flags, op.Acl)
+		case *zk.SetDataRequest:
+			res[i].Stat, err = c.Set(op.Path, op.Data, op.Version)
+		case *zk.DeleteRequest:
+			err = c.Delete(op.Path, op.Version)
+		case *zk.CheckVersionRequest:
+			if _, ok := c.nodes[op.Path]; !ok {
+				err = zk.ErrNoNode
+			} else if op.Version != -1 && op.Version != c.versions[op.Path] {
+				err = zk.ErrBadVersion
+			}
+		}
+		if err != nil {
+			c.nodes, c.versions = nodes, versions
+			res = make([]zk.MultiResponse, len(ops))
+			res[i].Error = err
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func (c *fakeConn) Close() {}
+
+func useFakeConn(t *testing.T, conn zkConn) {
+	connect, manager := zkConnect, defaultConnManager
+	zkConnect = func(hosts []string, timeout time.Duration, dialer zk.Dialer, callback zk.EventCallback) (zkConn, error) {
+		return conn, nil
+	}
+	defaultConnManager = newConnManager()
+	t.Cleanup(func() { zkConnect, defaultConnManager = connect, manager })
+}
+
+func TestZkDefaultACL(t *testing.T) {
+	conn := newFakeConn()
+	useFakeConn(t, conn)
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RegistServer("orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.auths) != 0 {
+		t.Fatalf("unexpected auth %v", conn.auths)
+	}
+	if acl := conn.acls["/gateway_servers_orders/127.0.0.1:8001"]; !reflect.DeepEqual(acl, zk.WorldACL(zk.PermAll)) {
+		t.Fatalf("default acl %v", acl)
+	}
+}
+
+func TestZkDigestAuthAndACL(t *testing.T) {
+	conn := newFakeConn()
+	conn.protected = []string{"/gateway"}
+	useFakeConn(t, conn)
+	acl := zk.DigestACL(zk.PermAll, "gateway", "secret")
+	z := NewZkManager([]string{"127.0.0.1:2181"}, WithAuth("digest", []byte("gateway:secret")), WithACL(acl...))
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conn.auths, []string{"digest:gateway:secret"}) {
+		t.Fatalf("AddAuth not invoked: %v", conn.auths)
+	}
+	if err := z.RegistServer("orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.SetData("orders", []byte("conf"), 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/gateway_servers_orders", "/gateway_servers_orders/127.0.0.1:8001", "/gateway_servers_config_orders"} {
+		if got := conn.acls[path]; !reflect.DeepEqual(got, acl) {
+			t.Fatalf("acl of %s: %v", path, got)
+		}
+	}
+}
+
+func TestZkAuthErrors(t *testing.T) {
+	//没有认证时写入受保护的节点
+	conn := newFakeConn()
+	conn.protected = []string{"/gateway"}
+	useFakeConn(t, conn)
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	var authErr *AuthError
+	if err := z.RegistServer("orders", "127.0.0.1:8001"); !errors.As(err, &authErr) || authErr.Op != "create" || !errors.Is(err, zk.ErrNoAuth) {
+		t.Fatalf("register got %v", err)
+	}
+	if err := z.SetData("orders", []byte("conf"), 0); !errors.As(err, &authErr) {
+		t.Fatalf("set data got %v", err)
+	}
+
+	//认证失败
+	conn = newFakeConn()
+	conn.authErr = zk.ErrAuthFailed
+	useFakeConn(t, conn)
+	z = NewZkManager([]string{"127.0.0.1:2181"}, WithAuth("digest", []byte("gateway:wrong")))
+	if err := z.GetConnect(); !errors.As(err, &authErr) || authErr.Op != "add_auth" {
+		t.Fatalf("connect got %v", err)
+	}
+}
+
+func TestZkDeregister(t *testing.T) {
+	conn := newFakeConn()
+	useFakeConn(t, conn)
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RegistServer("orders", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	//子节点删除，父节点保留，重复注销不报错
+	for i := 0; i < 2; i++ {
+		if err := z.DeregisterServer("orders", "127.0.0.1:8001"); err != nil {
+			t.Fatalf("deregister %d: %v", i, err)
+		}
+	}
+	if _, ok := conn.nodes["/gateway_servers_orders/127.0.0.1:8001"]; ok {
+		t.Fatal("ephemeral node not deleted")
+	}
+	if _, ok := conn.nodes["/gateway_servers_orders"]; !ok {
+		t.Fatal("parent node deleted")
+	}
+
+	for _, path := range []string{"/gateway_test", "/gateway_test/a", "/gateway_test/a/b", "/gateway_test/c"} {
+		conn.Create(path, nil, 0, nil)
+	}
+	if err := z.DeleteRecursive("/gateway_test"); err != nil {
+		t.Fatal(err)
+	}
+	for path := range conn.nodes {
+		if strings.HasPrefix(path, "/gateway_test") {
+			t.Fatalf("%s not deleted", path)
+		}
+	}
+	if err := z.DeleteRecursive("/gateway_test"); err != nil {
+		t.Fatalf("delete missing path: %v", err)
+	}
+}
+
+// 并发安全的 fakeConn，用于多个 goroutine 同时创建节点
+type lockedConn struct {
+	*fakeConn
+	mux sync.Mutex
+}
+
+func (c *lockedConn) Exists(path string) (bool, *zk.Stat, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.fakeConn.Exists(path)
+}
+
+func (c *lockedConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.fakeConn.Create(path, data, flags, acl)
+}
+
+func TestZkCreateAll(t *testing.T) {
+	conn := &lockedConn{fakeConn: newFakeConn()}
+	useFakeConn(t, conn)
+	z := NewZkManager([]string{"127.0.0.1:2181"})
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	//多级不存在的父节点
+	if err := z.RegistServerPath("/gateway_servers_prod/serviceA", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.SetPathData("/gateway_servers_prod/config/serviceA", []byte("conf"), 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/gateway_servers_prod", "/gateway_servers_prod/serviceA", "/gateway_servers_prod/serviceA/127.0.0.1:8001", "/gateway_servers_prod/config"} {
+		if _, ok := conn.nodes[path]; !ok {
+			t.Fatalf("%s not created", path)
+		}
+	}
+	if data := conn.nodes["/gateway_servers_prod/config/serviceA"]; string(data) != "conf" {
+		t.Fatalf("config data %q", data)
+	}
+
+	//已有的前缀保留原数据
+	conn.nodes["/gateway_servers_prod"] = []byte("keep")
+	if err := z.CreateAll("/gateway_servers_prod/serviceB/v1", []byte("v1"), z.acl); err != nil {
+		t.Fatal(err)
+	}
+	if string(conn.nodes["/gateway_servers_prod"]) != "keep" || string(conn.nodes["/gateway_servers_prod/serviceB/v1"]) != "v1" || conn.nodes["/gateway_servers_prod/serviceB"] != nil {
+		t.Fatalf("nodes %v", conn.nodes)
+	}
+
+	//并发创建同一路径
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- z.CreateAll("/gateway_servers_race/a/b/c", nil, z.acl)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := conn.nodes["/gateway_servers_race/a/b/c"]; !ok {
+		t.Fatal("concurrent path not created")
+	}
+}